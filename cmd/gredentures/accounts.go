@@ -0,0 +1,88 @@
+package main
+
+import (
+	"log/slog"
+
+	appc "gredentures/pkg/appconfig"
+	"gredentures/pkg/audit"
+	appa "gredentures/pkg/awsconfig"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+)
+
+// runAccounts handles "gredentures accounts": it lists the accounts of the AWS Organization
+// named by the Org option, with the org and profile each one is assumed as by --all, and
+// returns the exit code.
+func runAccounts(app *appc.AppConfig, creds *appa.AwsConfig) int {
+	if !app.IsOrganization() {
+		return 1 // Already reported by the option validation
+	}
+	accounts, ok := loadOrganization(app, creds)
+	if !ok {
+		return 1
+	}
+	if len(accounts) == 0 {
+		console.Warnf("No active accounts of %s match Organization.Tags", app.Org)
+		return 0
+	}
+
+	rows := make([][]string, 0, len(accounts))
+	for _, account := range accounts {
+		org, profile := configuredOrg(app.Orgs, account.RoleArn), "-"
+		if org == "" {
+			org = "-" // The generated name is taken by an org with another role
+		} else {
+			profile = app.Orgs[org].ProfileName(org)
+		}
+		rows = append(rows, []string{account.ID, account.Name, org, profile})
+	}
+	console.Table([]string{"ACCOUNT", "NAME", "ORG", "PROFILE"}, rows)
+	return 0
+}
+
+// cacheAccounts remembers the accounts and aliases of the issued credentials, so show, roles
+// discover and editor plugins can name accounts by alias. It never fails the login, and with
+// --no-write leaves the cache alone like every other file.
+func cacheAccounts(app appc.AppConfig, creds *appa.AwsConfig, issued []audit.Event) {
+	if app.NoWrite {
+		return
+	}
+	if err := creds.CacheAccounts(issued, appa.AccountCachePath()); err != nil {
+		slog.Debug("Could not update the account cache", "error", err)
+	}
+}
+
+// arnAccount returns the account ID of an ARN, "" when it is not one.
+func arnAccount(value string) string {
+	parsed, err := arn.Parse(value)
+	if err != nil {
+		return ""
+	}
+	return parsed.AccountID
+}
+
+// loadOrganization lists the accounts of the AWS Organization named by the Org option and adds
+// an org assuming Organization.RoleName in each of them to app.Orgs, so --all covers every
+// account. Orgs in the config file take precedence over generated ones with the same name or
+// role. It reports whether the accounts could be listed.
+func loadOrganization(app *appc.AppConfig, creds *appa.AwsConfig) ([]appa.OrganizationAccount, bool) {
+	spinner := spin(*app, "Listing the accounts of "+app.Org+"...")
+	accounts, err := creds.OrganizationAccounts(*app)
+	spinner.Stop()
+	if err != nil {
+		console.Errorf("Error listing organization accounts: %v", err)
+		console.Hintf("Listing accounts requires organizations:ListAccounts in the management account or a delegated administrator.")
+		return nil, false
+	}
+
+	if app.Orgs == nil {
+		app.Orgs = map[string]appc.OrgConfig{}
+	}
+	for _, account := range accounts {
+		if _, taken := app.Orgs[account.Org]; taken || configuredOrg(app.Orgs, account.RoleArn) != "" {
+			continue
+		}
+		app.Orgs[account.Org] = appc.OrgConfig{RoleArn: account.RoleArn}
+	}
+	return accounts, true
+}
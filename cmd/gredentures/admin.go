@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	appc "gredentures/pkg/appconfig"
+)
+
+// runAdminBootstrap handles "gredentures admin bootstrap", generating the config file of every
+// user of the roster from the config file of the admin, then writing each to --out and mailing
+// it with --mail. Nothing is written or sent unless every config could be generated. It returns
+// 1 when a config could not be delivered.
+func runAdminBootstrap(app appc.AppConfig) int {
+	if app.OutDir == "" && !app.Mail {
+		console.Errorf("Error bootstrapping users: pass --out, --mail or both to deliver the configs")
+		return 1
+	}
+	if err := app.GetGredenturesConfig(); err != nil {
+		console.Errorf("Error getting gredentures config: %v", err)
+		return 1
+	}
+	entries, err := appc.ReadRoster(app.Roster)
+	if err != nil {
+		console.Errorf("Error reading roster: %v", err)
+		return 1
+	}
+
+	configs := make([][]byte, len(entries))
+	for i, entry := range entries {
+		if app.Mail && entry.Email == "" {
+			console.Errorf("Error bootstrapping users: %s has no email to mail the config to", entry.User)
+			return 1
+		}
+		if configs[i], err = app.UserConfig(entry); err != nil {
+			console.Errorf("Error bootstrapping users: %v", err)
+			return 1
+		}
+	}
+
+	failed := 0
+	for i, entry := range entries {
+		if app.OutDir != "" {
+			path := filepath.Join(app.OutDir, entry.User+".yml")
+			if err := writeUserConfig(path, configs[i], app.Force); err != nil {
+				console.Errorf("Error writing the config of %s: %v", entry.User, err)
+				failed++
+				continue
+			}
+			console.Successf("Wrote the config of %s to %s", entry.User, path)
+		}
+		if app.Mail {
+			if err := mailUserConfig(entry, configs[i]); err != nil {
+				console.Errorf("Error mailing the config of %s: %v", entry.User, err)
+				failed++
+				continue
+			}
+			console.Successf("Mailed the config of %s to %s", entry.User, entry.Email)
+		}
+	}
+	if failed > 0 {
+		console.Warnf("%d of %d configs were not delivered", failed, len(entries))
+		return 1
+	}
+	return 0
+}
+
+// writeUserConfig writes the config of a roster user to path, refusing to replace an existing
+// file unless force is set, as the user may have been handed it already.
+func writeUserConfig(path string, config []byte, force bool) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	file, err := os.OpenFile(path, flags, 0o644)
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("%s exists already, pass --force to replace it", path)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(config); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// mailUserConfig mails the config of a roster user through sendmail, which reads the recipient
+// from the To header.
+func mailUserConfig(entry appc.RosterEntry, config []byte) error {
+	mail, err := appc.BootstrapMail(entry, config)
+	if err != nil {
+		return fmt.Errorf("failed to compose the mail: %w", err)
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command("sendmail", "-t", "-i")
+	cmd.Stdin = bytes.NewReader(mail)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("sendmail failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
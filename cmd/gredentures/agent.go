@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+
+	"gredentures/pkg/agent"
+	appc "gredentures/pkg/appconfig"
+	appa "gredentures/pkg/awsconfig"
+	"gredentures/pkg/interrupt"
+)
+
+// runAgent handles "gredentures agent", serving the credentials obtained at startup on a
+// local socket until interrupted, and returns the exit code. With a token command configured,
+// or for --no-mfa sessions, the agent logs in again once the credentials expire within their
+// RefreshMargin. With Agent.WatchCredentials set, the served profiles are also kept in
+// ~/.aws/credentials.
+func runAgent(app appc.AppConfig, creds *appa.AwsConfig) int {
+	server := &agent.Server{
+		Path:    app.Agent.Socket,
+		Allow:   agent.Allowlist{UIDs: app.Agent.AllowUIDs, Binaries: app.Agent.AllowBinaries},
+		Margin:  app.RefreshMargin(app.Profile),
+		Margins: app.RefreshMargins(),
+	}
+	if server.Path == "" {
+		server.Path = agent.DefaultPath()
+	}
+	writers := []appa.CredentialWriter{server}
+	var watcher *agent.Watcher
+	if app.Agent.WatchCredentials {
+		watcher = &agent.Watcher{Path: appa.CredentialsPath()}
+		writers = append(writers, watcher)
+	}
+	if app.TokenCommand != "" || app.NoMFA {
+		server.Refresh = func() error { return refreshCredentials(&app, creds, writers) }
+	}
+
+	for _, writer := range writers {
+		if err := creds.WriteCredentials(writer); err != nil {
+			console.Errorf("Error loading credentials into the agent: %v", err)
+			return 1
+		}
+	}
+	if watcher != nil {
+		go func() {
+			if err := watcher.Watch(interrupt.Context()); err != nil {
+				console.Warnf("Not watching the credentials file: %v", err)
+			}
+		}()
+	}
+
+	// A signal ends the process before ListenAndServe returns, so the socket is removed here
+	defer interrupt.OnInterrupt(func() { os.Remove(server.Path) })()
+	if err := server.ListenAndServe(interrupt.Context()); err != nil {
+		console.Errorf("Error running agent: %v", err)
+		return 1
+	}
+	return 0
+}
+
+// refreshCredentials obtains a new MFA token from the token command, unless the session is
+// requested without MFA, repeats the login and hands the new credentials to writers. It renews
+// the credentials of the agent and of commands run by exec --renew.
+func refreshCredentials(app *appc.AppConfig, creds *appa.AwsConfig, writers []appa.CredentialWriter) error {
+	if !app.NoMFA {
+		if err := app.RunTokenCommand(); err != nil {
+			return err
+		}
+	}
+	if err := creds.GetSessionCreds(*app); err != nil {
+		return err
+	}
+	if app.All {
+		if err := creds.GetRoleCreds(*app); err != nil {
+			return err
+		}
+	}
+	if app.Recipe != "" {
+		if err := creds.GetRecipeCreds(*app); err != nil {
+			return err
+		}
+	}
+	recordAudit(*app, creds.IssueEvents()...)
+	for _, writer := range writers {
+		if err := creds.WriteCredentials(writer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"slices"
+	"strings"
+
+	appc "gredentures/pkg/appconfig"
+)
+
+// warnArgvSecrets warns when a secret was given as an argument, where any user on the host can
+// read it for as long as gredentures runs, unless --allow-argv-secrets accepts that.
+func warnArgvSecrets(app appc.AppConfig) {
+	secrets := app.ArgvSecrets()
+	if len(secrets) == 0 || app.AllowArgvSecrets {
+		return
+	}
+	console.Warnf("%s given as an argument, other users on this host can read it with ps", strings.Join(secrets, " and "))
+	if slices.Contains(secrets, "--token") {
+		console.Hintf("Pipe the token with --token -, export it as %s, or leave it out to be asked for it.", appc.TokenEnv)
+	}
+	if slices.Contains(secrets, "--proxy") {
+		console.Hintf("Set the proxy in HTTPS_PROXY or in the config file instead.")
+	}
+	console.Hintf("Pass --allow-argv-secrets where nothing else is possible, to silence this warning.")
+}
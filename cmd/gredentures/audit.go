@@ -0,0 +1,67 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+	"time"
+
+	appc "gredentures/pkg/appconfig"
+	"gredentures/pkg/audit"
+)
+
+// recordAudit appends events to the audit log when one is configured. Failures are reported
+// on stderr but never stop the run, the credentials have already been issued or written.
+func recordAudit(app appc.AppConfig, events ...audit.Event) {
+	if app.AuditLog == "" || len(events) == 0 {
+		return
+	}
+	if err := audit.Append(app.AuditLog, events...); err != nil {
+		console.Errorf("Error writing audit log: %v", err)
+		return
+	}
+	slog.Debug("Recorded audit events", "path", app.AuditLog, "count", len(events))
+}
+
+// writeEvent describes a write of the issued profiles to path.
+func writeEvent(path string, issued []audit.Event) audit.Event {
+	profiles := make([]string, 0, len(issued))
+	for _, event := range issued {
+		profiles = append(profiles, event.Profile)
+	}
+	return audit.Event{Action: audit.ActionWrite, Path: path, Profiles: profiles}
+}
+
+// runAuditCommand handles "gredentures audit", printing the audit log as a table, and returns
+// the exit code.
+func runAuditCommand(app appc.AppConfig) int {
+	if err := app.GetGredenturesConfig(); err != nil {
+		console.Errorf("Error getting gredentures config: %v", err)
+		return 1
+	}
+	if app.AuditLog == "" {
+		console.Warnf("Audit logging is disabled, set AuditLog in the config file to record credential issuance and writes")
+		return 0
+	}
+
+	events, err := audit.Read(app.AuditLog)
+	if err != nil {
+		console.Errorf("Error reading audit log: %v", err)
+		return 1
+	}
+
+	rows := make([][]string, 0, len(events))
+	for _, event := range events {
+		expires, detail := "-", event.CallerARN
+		if event.Expiration != nil {
+			expires = event.Expiration.Local().Format(time.RFC3339)
+		}
+		profile := event.Profile
+		if event.Action == audit.ActionWrite {
+			profile, detail = strings.Join(event.Profiles, ","), event.Path
+		}
+		rows = append(rows, []string{event.Time.Local().Format(time.RFC3339),
+			event.User, event.Host, string(event.Action), profile, expires, detail})
+	}
+	console.Table([]string{"TIME", "USER", "HOST", "ACTION", "PROFILE", "EXPIRES", "DETAIL"}, rows)
+	return 0
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"cmp"
+
+	appc "gredentures/pkg/appconfig"
+	appa "gredentures/pkg/awsconfig"
+	"gredentures/pkg/secret"
+)
+
+// runAWSVaultImport handles "gredentures aws-vault import": it copies the long-lived keys
+// aws-vault stores for a profile, the source profile by default, into the source profile of
+// gredentures, and returns the exit code. Keys already there are never replaced.
+func runAWSVaultImport(app appc.AppConfig, creds *appa.AwsConfig) int {
+	if err := app.GetGredenturesConfig(); err != nil {
+		console.Errorf("Error getting gredentures config: %v", err)
+		return 1
+	}
+	creds.SetSourceProfile(app)
+	name := cmp.Or(app.ProfileArg, creds.SourceProfileName())
+
+	configured, err := creds.SourceConfigured()
+	if err != nil {
+		console.Errorf("Error reading the credentials file: %v", err)
+		return 1
+	}
+	if configured {
+		console.Errorf("Profile %s in %s already holds long-lived keys", creds.SourceProfileName(), creds.SourceCredentialsPath())
+		console.Hintf("Remove them first, or set SourceProfile in %s to import into another profile.", app.Config)
+		return 1
+	}
+
+	keys, err := appa.NewAWSVault().ReadKeys(name)
+	if err != nil {
+		console.Errorf("Error reading the keys from aws-vault: %v", err)
+		console.Hintf("aws-vault list shows the profiles it holds keys for.")
+		return 1
+	}
+	if err := creds.BootstrapCredentials(keys.Credentials.AccessKeyID, secret.Value(keys.Credentials.SecretAccessKey)); err != nil {
+		console.Errorf("Error writing the keys: %v", err)
+		return 1
+	}
+	console.Successf("Imported the keys of aws-vault profile %s into profile %s of %s.", name, creds.SourceProfileName(), creds.SourceCredentialsPath())
+
+	// aws-vault reads the MFA device from the profile in ~/.aws/config, gredentures from its own config
+	settings, err := appa.ReadConfigProfile(appa.AWSConfigPath(), name)
+	switch {
+	case err != nil:
+		console.Warnf("Could not read the MFA device of profile %s: %v", name, err)
+	case settings.MFASerial != "" && app.Device == "":
+		console.Hintf("aws-vault used the MFA device %s, set it as Device in %s.", settings.MFASerial, app.Config)
+	case settings.MFASerial != "" && settings.MFASerial != app.Device:
+		console.Warnf("aws-vault used the MFA device %s, gredentures is configured for %s", settings.MFASerial, app.Device)
+	}
+	return 0
+}
+
+// runAWSVaultExport handles "gredentures aws-vault export": it stores the long-lived keys of
+// the source profile in aws-vault, under the given profile or the source profile's name, and
+// prints the ~/.aws/config profiles aws-vault needs to log in with them. It returns the exit code.
+func runAWSVaultExport(app appc.AppConfig, creds *appa.AwsConfig) int {
+	if err := app.GetGredenturesConfig(); err != nil {
+		console.Errorf("Error getting gredentures config: %v", err)
+		return 1
+	}
+	creds.SetSourceProfile(app)
+
+	keys, err := creds.SourceKeys()
+	if err != nil {
+		console.Errorf("Error reading the long-lived keys: %v", err)
+		return 1
+	}
+	keys.Name = cmp.Or(app.ProfileArg, keys.Name)
+	if err := appa.NewAWSVault().WriteKeys(keys); err != nil {
+		console.Errorf("Error storing the keys in aws-vault: %v", err)
+		console.Hintf("aws-vault creates its keychain or collection when it first stores keys, e.g. with aws-vault add.")
+		return 1
+	}
+	console.Notef("Stored the long-lived keys in aws-vault as profile %s.", keys.Name)
+	console.Printf("%s", appa.AWSVaultConfig(app, keys.Name))
+	return 0
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+
+	appc "gredentures/pkg/appconfig"
+	appa "gredentures/pkg/awsconfig"
+	"gredentures/pkg/secret"
+)
+
+// bootstrapCredentials prompts for a long-lived key pair on first run, when the source
+// profile has no credentials yet, and stores it in the key store when one is configured or in
+// the credentials file. It does nothing when the source profile is configured or when there
+// is no way to prompt. With --no-write the key pair is only used for this run and nothing is written.
+func bootstrapCredentials(creds *appa.AwsConfig, app appc.AppConfig) error {
+	configured, err := creds.SourceConfigured()
+	if err != nil {
+		return err
+	}
+	if configured {
+		if app.KeyStore.Backend != "" && !creds.SourceExternal() {
+			console.Warnf("The long-lived keys of profile %s are kept in plain text in %s", creds.SourceProfileName(), creds.SourceCredentialsPath())
+			console.Hintf("gredentures keys import moves them to the %s key store.", app.KeyStore.Backend)
+		}
+		return nil
+	}
+	ask := prompter(app)
+	if ask == nil {
+		return nil
+	}
+
+	if app.KeyStore.Backend != "" {
+		console.Notef("No long-lived AWS credentials stored for %s.", app.KeyName())
+	} else {
+		console.Notef("No long-lived AWS credentials found in %s.", creds.SourceCredentialsPath())
+	}
+	accessKeyID, err := ask.Ask("AWS Access Key ID: ")
+	if err != nil {
+		return fmt.Errorf("failed to read access key ID: %w", err)
+	}
+	secretAccessKey, err := ask.AskSecret("AWS Secret Access Key: ")
+	if err != nil {
+		return fmt.Errorf("failed to read secret access key: %w", err)
+	}
+	if accessKeyID == "" || secretAccessKey == "" {
+		return fmt.Errorf("both an access key ID and a secret access key are required")
+	}
+
+	if app.NoWrite {
+		creds.SetSourceCreds(accessKeyID, secretAccessKey)
+		return nil
+	}
+	if app.KeyStore.Backend != "" {
+		return storeBootstrapKeys(creds, app, accessKeyID, secretAccessKey)
+	}
+	return creds.BootstrapCredentials(accessKeyID, secretAccessKey)
+}
+
+// storeBootstrapKeys stores the key pair asked for by bootstrapCredentials in the key store,
+// under the org, and uses it for this run.
+func storeBootstrapKeys(creds *appa.AwsConfig, app appc.AppConfig, accessKeyID string, secretAccessKey secret.Value) error {
+	store, err := openKeyStore(app)
+	if err != nil {
+		return err
+	}
+	creds.SetSourceCreds(accessKeyID, secretAccessKey)
+	keys, err := creds.SourceKeys()
+	if err != nil {
+		return err
+	}
+	if err := store.StoreKeys(app.KeyName(), keys); err != nil {
+		return err
+	}
+	console.Notef("Stored the long-lived keys of %s in the %s key store.", app.KeyName(), app.KeyStore.Backend)
+	return nil
+}
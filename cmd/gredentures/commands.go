@@ -0,0 +1,152 @@
+package main
+
+import (
+	"os"
+
+	appc "gredentures/pkg/appconfig"
+	appa "gredentures/pkg/awsconfig"
+	"gredentures/pkg/messages"
+)
+
+// stage is how far main has to get through the login before a subcommand takes over.
+type stage int
+
+// Stages of the login at which subcommands run, in the order main reaches them.
+const (
+	stageParsed  stage = iota // The command line is parsed, no credentials are read yet.
+	stageSource               // The long-lived keys are available, e.g. read from 1Password.
+	stageSession              // The MFA session is acquired, no role is assumed yet.
+	stageIssued               // Every session and role is issued, nothing is written yet.
+)
+
+// subcommand runs in place of the rest of the login once main reaches its stage.
+type subcommand struct {
+	stage    stage                                                // When the subcommand runs.
+	selected func(app appc.AppConfig) bool                        // Whether the command line selects it.
+	run      func(app *appc.AppConfig, creds *appa.AwsConfig) int // Runs it and returns the exit code.
+}
+
+// subcommands lists every subcommand, checked in order at each stage. Adding one takes a usage
+// pattern in appconfig.Usage, the AppConfig field docopt sets for it, and an entry here.
+var subcommands = []subcommand{
+	// Config subcommands work on the config file alone and need no credentials.
+	{stageParsed, func(app appc.AppConfig) bool { return app.ConfigCmd },
+		func(app *appc.AppConfig, creds *appa.AwsConfig) int { return runConfigCommand(*app, creds) }},
+	// Generated AWS config profiles are built from the config file alone.
+	{stageParsed, func(app appc.AppConfig) bool { return app.GenerateCmd },
+		func(app *appc.AppConfig, _ *appa.AwsConfig) int { return runGenerate(*app) }},
+	// Syncing with Windows copies existing profiles and needs no new credentials.
+	{stageParsed, func(app appc.AppConfig) bool { return app.WSLSyncCmd },
+		func(app *appc.AppConfig, _ *appa.AwsConfig) int { return runWSLSync(*app) }},
+	// Pushing copies the session profile already written and needs no new credentials.
+	{stageParsed, func(app appc.AppConfig) bool { return app.PushCmd },
+		func(app *appc.AppConfig, _ *appa.AwsConfig) int { return runPush(*app) }},
+	// Showing a profile only reads the credentials file.
+	{stageParsed, func(app appc.AppConfig) bool { return app.ShowCmd },
+		func(app *appc.AppConfig, creds *appa.AwsConfig) int { return runShow(*app, creds) }},
+	// Switching only selects a profile written already.
+	{stageParsed, func(app appc.AppConfig) bool { return app.SwitchCmd },
+		func(app *appc.AppConfig, _ *appa.AwsConfig) int { return runSwitch(*app) }},
+	// The key store is managed on its own, keys import shares its word with import too.
+	{stageParsed, func(app appc.AppConfig) bool { return app.KeysCmd && !app.Report },
+		func(app *appc.AppConfig, creds *appa.AwsConfig) int { return runKeys(*app, creds) }},
+	// Keys imported from aws-vault are long-lived already. Listed before import, whose word it shares.
+	{stageParsed, func(app appc.AppConfig) bool { return app.AWSVaultCmd && app.ImportCmd },
+		func(app *appc.AppConfig, creds *appa.AwsConfig) int { return runAWSVaultImport(*app, creds) }},
+	// The keys of a CSV file from the IAM console are stored as they are, gredentures requests none.
+	{stageParsed, func(app appc.AppConfig) bool { return app.ImportCSV },
+		func(app *appc.AppConfig, creds *appa.AwsConfig) int { return runImportCSV(*app, creds) }},
+	// Imported credentials come from the AWS access portal, gredentures requests none.
+	{stageParsed, func(app appc.AppConfig) bool { return app.ImportCmd },
+		func(app *appc.AppConfig, creds *appa.AwsConfig) int { return runImport(*app, creds) }},
+	// The status only reads the credentials file, so it works without a network.
+	{stageParsed, func(app appc.AppConfig) bool { return app.StatusCmd },
+		func(app *appc.AppConfig, _ *appa.AwsConfig) int { return runStatus(*app) }},
+	// Offline, exec uses the session already written and never reaches the first AWS call.
+	{stageParsed, func(app appc.AppConfig) bool { return app.Exec && app.Offline }, runExecOffline},
+	// The unset command is built from the environment alone.
+	{stageParsed, func(app appc.AppConfig) bool { return app.EnvCmd },
+		func(*appc.AppConfig, *appa.AwsConfig) int { return runEnv() }},
+	// Usage statistics are kept locally and need no credentials either.
+	{stageParsed, func(app appc.AppConfig) bool { return app.StatsCmd },
+		func(app *appc.AppConfig, _ *appa.AwsConfig) int { return runStatsCommand(*app) }},
+	// The audit log is only read, no credentials are involved.
+	{stageParsed, func(app appc.AppConfig) bool { return app.AuditCmd },
+		func(app *appc.AppConfig, _ *appa.AwsConfig) int { return runAuditCommand(*app) }},
+	// The login history is kept locally, no credentials are involved.
+	{stageParsed, func(app appc.AppConfig) bool { return app.HistoryCmd },
+		func(app *appc.AppConfig, _ *appa.AwsConfig) int { return runHistory(*app) }},
+	// Events are read from a running gredentures serve, which holds the credentials.
+	{stageParsed, func(app appc.AppConfig) bool { return app.EventsCmd },
+		func(app *appc.AppConfig, _ *appa.AwsConfig) int { return runEvents(*app) }},
+	// The configs of a roster are built from the config file of the admin alone.
+	{stageParsed, func(app appc.AppConfig) bool { return app.AdminCmd && app.Bootstrap },
+		func(app *appc.AppConfig, _ *appa.AwsConfig) int { return runAdminBootstrap(*app) }},
+	// Completion scripts are static, the candidates are looked up when the shell calls back.
+	{stageParsed, func(app appc.AppConfig) bool { return app.CompleteCmd },
+		func(app *appc.AppConfig, _ *appa.AwsConfig) int { return runCompletion(*app) }},
+
+	// Enrolling an MFA device uses the long-lived credentials only, no token exists yet.
+	{stageSource, func(app appc.AppConfig) bool { return app.DeviceCmd && app.Enroll },
+		func(app *appc.AppConfig, creds *appa.AwsConfig) int { return runDeviceEnroll(*app, creds) }},
+	// A drifted device produces no accepted token, so resyncing uses the long-lived credentials too.
+	{stageSource, func(app appc.AppConfig) bool { return app.DeviceCmd && app.Resync },
+		func(app *appc.AppConfig, creds *appa.AwsConfig) int { return runDeviceResync(*app, creds) }},
+	// Exporting to aws-vault moves the long-lived keys, read from 1Password when it holds them.
+	{stageSource, func(app appc.AppConfig) bool { return app.AWSVaultCmd && app.Export },
+		func(app *appc.AppConfig, creds *appa.AwsConfig) int { return runAWSVaultExport(*app, creds) }},
+	// The report on the access keys asks IAM with the long-lived keys, wherever they are kept.
+	{stageSource, func(app appc.AppConfig) bool { return app.KeysCmd && app.Report },
+		func(app *appc.AppConfig, creds *appa.AwsConfig) int { return runKeysReport(*app, creds) }},
+	// The doctor checks whatever is there and never logs in.
+	{stageSource, func(app appc.AppConfig) bool { return app.DoctorCmd },
+		func(app *appc.AppConfig, creds *appa.AwsConfig) int { return runDoctor(*app, creds) }},
+	// Editor plugins drive logins over stdin and stdout instead, one request at a time.
+	{stageSource, func(app appc.AppConfig) bool { return app.JSONRPC },
+		func(app *appc.AppConfig, creds *appa.AwsConfig) int { return runJSONRPC(*app, creds) }},
+	// Desktop apps drive the same logins over a local HTTP API.
+	{stageSource, func(app appc.AppConfig) bool { return app.ServeCmd },
+		func(app *appc.AppConfig, creds *appa.AwsConfig) int { return runServe(*app, creds) }},
+
+	// Discovering roles only reads IAM with the session credentials and writes no profile.
+	{stageSession, func(app appc.AppConfig) bool { return app.RolesCmd && app.Discover },
+		func(app *appc.AppConfig, creds *appa.AwsConfig) int { return runRolesDiscover(*app, creds) }},
+	// Listing the accounts of an AWS Organization needs the session of its management account.
+	{stageSession, func(app appc.AppConfig) bool { return app.AccountsCmd }, runAccounts},
+	// Sessions are looked up in CloudTrail with the session credentials, which policies tend to require.
+	{stageSession, func(app appc.AppConfig) bool { return app.SessionsCmd },
+		func(app *appc.AppConfig, creds *appa.AwsConfig) int { return runSessions(*app, creds) }},
+
+	// Serve the credentials on a local socket instead of persisting them.
+	{stageIssued, func(app appc.AppConfig) bool { return app.AgentCmd },
+		func(app *appc.AppConfig, creds *appa.AwsConfig) int { return runAgent(*app, creds) }},
+	// Run the requested command with the session credentials instead of persisting them.
+	{stageIssued, func(app appc.AppConfig) bool { return app.Exec }, runExec},
+	// Print the session credentials for containers instead of persisting them.
+	{stageIssued, func(app appc.AppConfig) bool { return app.Export }, runExport},
+}
+
+// runSubcommand exits with the code of the subcommand selected for stage at, if there is one.
+// Subcommands with a name of their own in CommandName are counted first.
+func runSubcommand(at stage, app *appc.AppConfig, creds *appa.AwsConfig) {
+	for _, command := range subcommands {
+		if command.stage == at && command.selected(*app) {
+			if !app.NoWrite && app.CommandName() != appc.CommandLogin {
+				recordUsage(*app)
+			}
+			os.Exit(command.run(app, creds))
+		}
+	}
+}
+
+// runExport handles "gredentures export", printing the session credentials for a container,
+// and returns the exit code.
+func runExport(app *appc.AppConfig, creds *appa.AwsConfig) int {
+	out, err := creds.Export(app.Format, app.Mount)
+	if err != nil {
+		console.Errorf("%s", text(messages.ErrExport, messages.Args{"Err": err}))
+		return 1
+	}
+	os.Stdout.Write(out)
+	return 0
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+
+	appc "gredentures/pkg/appconfig"
+)
+
+// runCompletion handles "gredentures completion", printing the completion script of the
+// selected shell, and returns the exit code.
+func runCompletion(app appc.AppConfig) int {
+	shell := "bash"
+	switch {
+	case app.Zsh:
+		shell = "zsh"
+	case app.Fish:
+		shell = "fish"
+	}
+	script, err := appc.CompletionScript(shell)
+	if err != nil {
+		console.Errorf("Error printing completion script: %v", err)
+		return 1
+	}
+	os.Stdout.WriteString(script)
+	return 0
+}
+
+// runComplete handles the hidden __complete command of the completion scripts, printing the
+// candidates for the last of words one per line, and returns the exit code. It never prints
+// anything else, a shell would offer it as a candidate.
+func runComplete(words []string) int {
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+	for _, candidate := range appc.Complete(words) {
+		console.Printf("%s\n", candidate)
+	}
+	return 0
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	appc "gredentures/pkg/appconfig"
+	appa "gredentures/pkg/awsconfig"
+	"gredentures/pkg/ui"
+)
+
+// runConfigCommand handles the "gredentures config" subcommands and returns the exit code.
+func runConfigCommand(app appc.AppConfig, creds *appa.AwsConfig) int {
+	switch {
+	case app.Migrate:
+		legacyPath := appc.LegacyConfigPath()
+		if err := app.MigrateLegacyConfig(legacyPath); err != nil {
+			console.Errorf("Error migrating config: %v", err)
+			return 1
+		}
+		console.Successf("Migrated %s to %s (original saved as %s.bak)", legacyPath, app.Config, legacyPath)
+	case app.Explain:
+		options, err := app.ExplainOptions()
+		if err != nil {
+			console.Errorf("Error explaining config: %v", err)
+			return 1
+		}
+		rows := make([][]string, 0, len(options))
+		for _, option := range options {
+			rows = append(rows, []string{option.Name, option.Value, option.Source})
+		}
+		console.Table([]string{"OPTION", "VALUE", "SOURCE"}, rows)
+	case app.Lint:
+		return runConfigLint(app, creds)
+	case app.ShowPath:
+		path, origin := app.ConfigPath()
+		console.Printf("%s\n", path)
+		if !app.Quiet {
+			console.Notef("Chosen from: %s", origin)
+			console.Notef("Search order: --config, %s", strings.Join(appc.ConfigSearchPath(), ", "))
+		}
+	case app.Export:
+		bundle, err := app.ExportBundle(app.Personal)
+		if err != nil {
+			console.Errorf("Error exporting config: %v", err)
+			return 1
+		}
+		if _, err := os.Stdout.Write(bundle); err != nil {
+			console.Errorf("Error exporting config: %v", err)
+			return 1
+		}
+	case app.ImportCmd:
+		data, err := readBundle(app.Bundle)
+		if err != nil {
+			console.Errorf("Error reading bundle: %v", err)
+			return 1
+		}
+		held, err := appc.BundleConfirmations(data)
+		if err != nil {
+			console.Errorf("Error importing config: %v", err)
+			return 1
+		}
+		confirmed := len(held) > 0 && confirmBundle(app, held)
+		added, skipped, err := app.ImportBundle(data, confirmed)
+		if err != nil {
+			console.Errorf("Error importing config: %v", err)
+			return 1
+		}
+		if len(added) == 0 {
+			console.Notef("%s already has every setting of %s", app.Config, app.Bundle)
+		} else {
+			console.Successf("Added %s to %s", strings.Join(added, ", "), app.Config)
+		}
+		if len(skipped) > 0 {
+			console.Notef("Kept your own %s", strings.Join(skipped, ", "))
+		}
+		if len(held) > 0 && !confirmed {
+			console.Warnf("Left these settings out, add them to %s yourself if you trust them", app.Config)
+		}
+	}
+
+	return 0
+}
+
+// runConfigLint handles "gredentures config lint", checking the config file and then every
+// credentials file gredentures reads or writes, and fixing what it can with --fix. It returns
+// 1 while problems are left.
+func runConfigLint(app appc.AppConfig, creds *appa.AwsConfig) int {
+	issues, err := app.LintConfig(app.Fix)
+	if err != nil {
+		console.Errorf("Error linting config: %v", err)
+		return 1
+	}
+
+	// The config file names the credentials files, the defaults are checked when it is broken
+	appa.SetCredentialsPath(app.CredentialsFile)
+	creds.SetSourceProfile(app)
+	paths := []string{appa.CredentialsPath()}
+	for _, path := range append([]string{creds.SourceCredentialsPath()}, app.CredentialsFiles...) {
+		if !slices.Contains(paths, path) {
+			paths = append(paths, path)
+		}
+	}
+	for _, path := range paths {
+		found, err := appa.LintCredentials(path, app.Fix)
+		issues = append(issues, found...)
+		if err != nil {
+			console.Errorf("Error linting %s: %v", path, err)
+			return 1
+		}
+	}
+
+	if len(issues) == 0 {
+		console.Successf("No problems found in %s and %s", app.Config, strings.Join(paths, ", "))
+		return 0
+	}
+	rows := make([][]string, 0, len(issues))
+	left, fixable := 0, 0
+	for _, issue := range issues {
+		location := issue.File
+		if issue.Line > 0 {
+			location = fmt.Sprintf("%s:%d", issue.File, issue.Line)
+		}
+		status := console.Paint(ui.Red, "error")
+		switch {
+		case issue.Fixed:
+			status = console.Paint(ui.Green, "fixed")
+		case issue.Fix != "":
+			status = console.Paint(ui.Yellow, "fixable")
+			fixable++
+		}
+		if !issue.Fixed {
+			left++
+		}
+		rows = append(rows, []string{status, location, issue.Problem, issue.Fix})
+	}
+	console.Table([]string{"STATUS", "LOCATION", "PROBLEM", "FIX"}, rows)
+
+	if fixable > 0 {
+		console.Hintf("Run gredentures config lint --fix to fix %d of them.", fixable)
+	}
+	if left > 0 {
+		return 1
+	}
+	return 0
+}
+
+// confirmBundle lists the settings of a bundle that run commands or decide where credentials
+// go and whom to trust, then asks before they are imported.
+func confirmBundle(app appc.AppConfig, settings []string) bool {
+	console.Warnf("The bundle sets what runs and where your credentials go:")
+	for _, setting := range settings {
+		console.Warnf("  %s", setting)
+	}
+	if prompter(app) == nil {
+		console.Hintf("These have to be confirmed, run it on a terminal or choose how to ask with --prompt.")
+		return false
+	}
+	return confirm(app, "Import these settings as well?")
+}
+
+// readBundle reads the config bundle at path, or from stdin when path is "-".
+func readBundle(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
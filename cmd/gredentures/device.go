@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	appc "gredentures/pkg/appconfig"
+	appa "gredentures/pkg/awsconfig"
+	"gredentures/pkg/interrupt"
+	"gredentures/pkg/prompt"
+	"gredentures/pkg/secret"
+	"gredentures/pkg/ui"
+)
+
+// enrollAttempts is how many times the two codes may be entered, before enroll deletes the
+// device or resync gives up.
+const enrollAttempts = 3
+
+// runDeviceEnroll creates a virtual MFA device for the IAM user of the source credentials,
+// shows it for an authenticator app, enables it with two consecutive codes and saves its ARN
+// as the Device in the config file. It returns the exit code.
+func runDeviceEnroll(app appc.AppConfig, creds *appa.AwsConfig) int {
+	if err := app.GetGredenturesConfig(); err != nil {
+		console.Errorf("Error getting gredentures config: %v", err)
+		return 1
+	}
+	creds.SetSourceProfile(app)
+	ask := prompter(app)
+	if ask == nil {
+		console.Errorf("Error enrolling MFA device: the codes from the authenticator app cannot be prompted for")
+		console.Hintf("Run gredentures device enroll on a terminal, or choose how to ask with --prompt.")
+		return 1
+	}
+
+	spinner := spin(app, "Creating virtual MFA device...")
+	device, err := creds.CreateMFADevice()
+	spinner.Stop()
+	if err != nil {
+		console.Errorf("Error creating MFA device: %v", err)
+		return 1
+	}
+
+	// The QR code holds the seed, so it only lives in a private temporary file
+	qr, err := os.CreateTemp("", "gredentures-mfa-*.png")
+	if err == nil {
+		defer os.Remove(qr.Name())
+		defer interrupt.OnInterrupt(func() { os.Remove(qr.Name()) })()
+		_, err = qr.Write(device.QRCodePNG)
+		if closeErr := qr.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	console.Successf("Created virtual MFA device %s for IAM user %s.", device.SerialNumber, device.UserName)
+	if err == nil {
+		console.Printf("Scan the QR code in %s with your authenticator app, or enter this secret manually:\n", qr.Name())
+	} else {
+		console.Warnf("Could not write the QR code (%v), enter this secret in your authenticator app:", err)
+	}
+	console.Printf("\n    %s\n\n", console.Paint(ui.Bold, device.Seed.Reveal()))
+
+	for attempt := 1; ; attempt++ {
+		code1, code2, err := readCodes(ask)
+		if err == nil {
+			spinner := spin(app, "Enabling MFA device...")
+			err = creds.EnableMFADevice(device, code1, code2)
+			spinner.Stop()
+		}
+		if err == nil {
+			break
+		}
+		console.Errorf("Error enabling MFA device: %v", err)
+		if attempt == enrollAttempts {
+			if err := creds.DeleteMFADevice(device); err != nil {
+				console.Errorf("Error removing the unused MFA device: %v", err)
+			}
+			return 1
+		}
+	}
+
+	if err := app.SaveDevice(device.SerialNumber); err != nil {
+		console.Errorf("Error saving MFA device to the config file: %v", err)
+		console.Hintf("Set Device to %s in your gredentures config.", device.SerialNumber)
+		return 1
+	}
+	console.Successf("Enabled %s and saved it as the Device in %s.", device.SerialNumber, app.Config)
+	return 0
+}
+
+// runDeviceResync resynchronizes the configured Device, typically a hardware token whose clock
+// has drifted, from two consecutive codes, and returns the exit code.
+func runDeviceResync(app appc.AppConfig, creds *appa.AwsConfig) int {
+	if err := app.GetGredenturesConfig(); err != nil {
+		console.Errorf("Error getting gredentures config: %v", err)
+		return 1
+	}
+	if app.Device == "" {
+		console.Errorf("Error resyncing MFA device: no Device is configured")
+		console.Hintf("Pass the serial number or ARN of the device with -d, or set Device in your gredentures config.")
+		return 1
+	}
+	if err := appc.ValidateDevice(app.Device); err != nil {
+		console.Errorf("Error resyncing MFA device: %v", err)
+		printHint(err)
+		return 1
+	}
+	creds.SetSourceProfile(app)
+	ask := prompter(app)
+	if ask == nil {
+		console.Errorf("Error resyncing MFA device: the codes from the device cannot be prompted for")
+		console.Hintf("Run gredentures device resync on a terminal, or choose how to ask with --prompt.")
+		return 1
+	}
+
+	console.Printf("Enter two consecutive codes from %s.\n", app.Device)
+	for attempt := 1; ; attempt++ {
+		code1, code2, err := readCodes(ask)
+		if err == nil {
+			spinner := spin(app, "Resyncing MFA device...")
+			err = creds.ResyncMFADevice(app.Device, code1, code2)
+			spinner.Stop()
+		}
+		if err == nil {
+			break
+		}
+		console.Errorf("Error resyncing MFA device: %v", err)
+		if attempt == enrollAttempts {
+			return 1
+		}
+	}
+	console.Successf("Resynced %s, its codes are accepted again.", app.Device)
+	return 0
+}
+
+// readCodes prompts for two consecutive codes from the authenticator app or hardware token,
+// dropping the spaces and hyphens some apps group them with.
+func readCodes(ask prompt.Prompter) (code1, code2 secret.Value, err error) {
+	read := func(question string) (secret.Value, error) {
+		code, err := ask.Ask(question)
+		if err != nil {
+			return "", fmt.Errorf("failed to read code: %w", err)
+		}
+		return secret.Value(appc.NormalizeToken(code)), nil
+	}
+
+	if code1, err = read("First code: "); err != nil {
+		return "", "", err
+	}
+	if code2, err = read("Next code, once the first one has changed: "); err != nil {
+		return "", "", err
+	}
+	return code1, code2, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+
+	appc "gredentures/pkg/appconfig"
+	appa "gredentures/pkg/awsconfig"
+	"gredentures/pkg/doctor"
+	"gredentures/pkg/interrupt"
+	"gredentures/pkg/ui"
+)
+
+// runDoctor handles "gredentures doctor", checking the prerequisites for logging in and
+// printing every finding with a suggested fix. It returns 1 when a check failed.
+func runDoctor(app appc.AppConfig, creds *appa.AwsConfig) int {
+	creds.SetSourceProfile(app)
+	d := &doctor.Doctor{
+		App:             &app,
+		Creds:           creds,
+		CredentialsPath: appa.CredentialsPath(),
+		Environ:         os.Environ(),
+	}
+
+	spinner := spin(app, "Running checks...")
+	findings := d.Run(interrupt.Context())
+	spinner.Stop()
+
+	styles := map[doctor.Status]ui.Style{doctor.StatusOK: ui.Green, doctor.StatusWarn: ui.Yellow, doctor.StatusFail: ui.Red}
+	rows := make([][]string, 0, len(findings))
+	for _, finding := range findings {
+		rows = append(rows, []string{console.Paint(styles[finding.Status], string(finding.Status)), finding.Check, finding.Message})
+		if finding.Fix != "" {
+			rows = append(rows, []string{"", "", console.Paint(ui.Cyan, "-> "+finding.Fix)})
+		}
+	}
+	console.Table([]string{"STATUS", "CHECK", "FINDING"}, rows)
+
+	if doctor.Failed(findings) {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	appa "gredentures/pkg/awsconfig"
+)
+
+// runEnv implements "gredentures env --unset": it prints a shell command clearing the AWS_*
+// credential variables of the invoking shell, meant for eval "$(gredentures env --unset)".
+func runEnv() int {
+	command := appa.UnsetCommand(os.Environ())
+	if command == "" {
+		console.Notef("No AWS_* credential variables are set.")
+		return 0
+	}
+	console.Printf("%s", command)
+	return 0
+}
+
+// warnEnvConflicts warns when the invoking shell has static credentials exported, which the
+// AWS CLI and SDKs use instead of AWS_PROFILE and the profiles gredentures writes.
+func warnEnvConflicts() {
+	conflicts := appa.ConflictingEnv(os.Environ())
+	if len(conflicts) == 0 {
+		return
+	}
+	console.Warnf("%s set in this shell, AWS tools use them instead of the profiles written by gredentures", strings.Join(conflicts, ", "))
+	console.Hintf(`Run eval "$(gredentures env --unset)" to clear them, or use gredentures exec to run commands with a session.`)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	appc "gredentures/pkg/appconfig"
+	"gredentures/pkg/events"
+	"gredentures/pkg/interrupt"
+	"gredentures/pkg/localapi"
+	"gredentures/pkg/ui"
+)
+
+// eventStyles colours each event type in the output of events.
+var eventStyles = map[string]ui.Style{events.Issued: ui.Green, events.Expiring: ui.Yellow, events.Expired: ui.Red}
+
+// runEvents handles "gredentures events", printing the latest event of every profile from the
+// API of a running gredentures serve, and with --follow the events that follow until
+// interrupted. It is the reference consumer of the event stream and returns the exit code.
+func runEvents(app appc.AppConfig) int {
+	if err := app.GetGredenturesConfig(); err != nil {
+		console.Errorf("Error getting gredentures config: %v", err)
+		return 1
+	}
+	// The token goes to the API in plain HTTP, so only ever to a loopback address
+	if err := localapi.CheckLoopback(app.Listen); err != nil {
+		console.Errorf("Error connecting to the API: %v", err)
+		console.Hintf("gredentures serve only listens on 127.0.0.1, ::1 or localhost, pass one of them with --listen.")
+		return 1
+	}
+	token, err := localapi.ReadToken(localapi.DefaultTokenPath())
+	if err != nil {
+		console.Errorf("Error reading the API token: %v", err)
+		console.Hintf("The token is created by gredentures serve, start it first.")
+		return 1
+	}
+
+	path := "/v1/events"
+	if !app.Follow {
+		path += "?follow=false"
+	}
+	client := localapi.Client{Addr: app.Listen, Token: token}
+	err = client.Stream(interrupt.Context(), path, func(raw json.RawMessage) error {
+		var event events.Event
+		if err := json.Unmarshal(raw, &event); err != nil {
+			return fmt.Errorf("invalid event: %w", err)
+		}
+		console.Printf("%s\n", describeEvent(event))
+		return nil
+	})
+	if err != nil {
+		console.Errorf("Error reading events from %s: %v", app.Listen, err)
+		console.Hintf("Check that gredentures serve is running and listens on %s.", app.Listen)
+		return 1
+	}
+	return 0
+}
+
+// describeEvent returns the line events prints for event, e.g.
+// "2025-01-02 14:50:05  expiring  default-mfa  expires 15:04:05".
+func describeEvent(event events.Event) string {
+	line := fmt.Sprintf("%s  %s  %s", event.Time.Local().Format(time.DateTime),
+		console.Paint(eventStyles[event.Type], fmt.Sprintf("%-8s", event.Type)), event.Profile)
+	if !event.Expires.IsZero() {
+		line += "  expires " + event.Expires.Local().Format(time.DateTime)
+	}
+	return line
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	appc "gredentures/pkg/appconfig"
+	appa "gredentures/pkg/awsconfig"
+	"gredentures/pkg/interrupt"
+	"gredentures/pkg/messages"
+)
+
+// renewRetry is how long exec --renew waits before trying again after a failed renewal.
+const renewRetry = time.Minute
+
+// runExec handles "gredentures exec", running the command with the session credentials in its
+// environment, and returns the exit code. With --renew the command reads the credentials from
+// a file instead, which is renewed before the session expires, so long runs such as a
+// terraform apply outlive a single session.
+func runExec(app *appc.AppConfig, creds *appa.AwsConfig) int {
+	if !app.Renew {
+		env, err := creds.SessionEnv(os.Environ())
+		if err != nil {
+			console.Errorf("%s", text(messages.ErrCommandEnv, messages.Args{"Err": err}))
+			return 1
+		}
+		return runCommand(app.Command, env)
+	}
+
+	files, err := creds.NewRenewFiles()
+	if err == nil {
+		err = creds.WriteCredentials(files)
+	}
+	if err != nil {
+		console.Errorf("%s", text(messages.ErrCommandEnv, messages.Args{"Err": err}))
+		return 1
+	}
+	// A signal ends the process before runCommand returns, so the files are removed here
+	defer interrupt.OnInterrupt(func() { files.Remove() })()
+	defer files.Remove()
+
+	ctx, cancel := context.WithCancel(interrupt.Context())
+	defer cancel()
+	go renewSession(ctx, app, creds, files)
+	return runCommand(app.Command, files.Env(os.Environ()))
+}
+
+// runExecOffline handles "gredentures exec --offline", running the command with the session
+// profile written by an earlier login instead of a new session, for when STS cannot be
+// reached. It returns the exit code.
+func runExecOffline(app *appc.AppConfig, creds *appa.AwsConfig) int {
+	if app.Renew {
+		console.Errorf("Error running command: --renew logs in again and cannot be combined with --offline")
+		return 1
+	}
+	if err := app.GetGredenturesConfig(); err != nil {
+		console.Errorf("Error getting gredentures config: %v", err)
+		return 1
+	}
+	creds.SetSourceProfile(*app)
+	profile, err := appc.RenderProfile(app.Profile, app.SessionProfileData())
+	if err != nil {
+		console.Errorf("%s", text(messages.ErrCommandEnv, messages.Args{"Err": err}))
+		console.Hintf("Name the session profile with -p, its template cannot be evaluated offline.")
+		return 1
+	}
+
+	written, err := creds.UseProfile(appa.CredentialsPath(), profile, time.Now())
+	if err != nil {
+		console.Errorf("%s", text(messages.ErrCommandEnv, messages.Args{"Err": err}))
+		return 1
+	}
+	console.Notef("Offline, using the credentials of %s: %s.", profile, describeExpiry(written, time.Now()))
+	return runExec(app, creds)
+}
+
+// renewSession logs in again shortly before the session expires and writes the new one to
+// files, until ctx is cancelled. A failed renewal is retried until the session has expired.
+// The margin is capped to half the session, which is taken to start whenever one is obtained.
+func renewSession(ctx context.Context, app *appc.AppConfig, creds *appa.AwsConfig, files *appa.RenewFiles) {
+	expires, ok := creds.SessionExpires()
+	if !ok {
+		return
+	}
+	margin := app.RefreshMargin(app.Profile)
+	now := time.Now()
+	wait := appa.RenewDelay(expires, now, appa.RenewMargin(margin, expires.Sub(now)))
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		if err := refreshCredentials(app, creds, []appa.CredentialWriter{files}); err != nil {
+			if time.Now().After(expires) {
+				console.Warnf("Could not renew the session, it has expired: %v", err)
+				return
+			}
+			console.Warnf("Could not renew the session, retrying in %s: %v", renewRetry, err)
+			wait = renewRetry
+			continue
+		}
+		expires, ok = creds.SessionExpires()
+		if !ok {
+			return
+		}
+		now = time.Now()
+		wait = appa.RenewDelay(expires, now, appa.RenewMargin(margin, expires.Sub(now)))
+		console.Notef("Renewed the session, it now expires at %s.", expires.Local().Format(time.Kitchen))
+	}
+}
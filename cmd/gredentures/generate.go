@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	appc "gredentures/pkg/appconfig"
+	appa "gredentures/pkg/awsconfig"
+)
+
+// runGenerate handles "gredentures generate aws-config", printing ~/.aws/config profiles that
+// run this gredentures binary through credential_process, or merging them into the AWS config
+// file with --write, and returns the exit code.
+func runGenerate(app appc.AppConfig) int {
+	if err := app.GetGredenturesConfig(); err != nil {
+		console.Errorf("Error getting gredentures config: %v", err)
+		return 1
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		console.Warnf("Could not locate the gredentures binary, relying on PATH: %v", err)
+		executable = "gredentures"
+	}
+	if app.Write {
+		path := appa.AWSConfigPath()
+		names, err := appa.WriteGeneratedConfig(app, executable, path, app.Force)
+		if err != nil {
+			console.Errorf("Error writing AWS config file: %v", err)
+			return 1
+		}
+		console.Successf("Wrote profiles %s to %s.", strings.Join(names, ", "), path)
+	} else {
+		console.Printf("%s", appa.GenerateConfig(app, executable))
+	}
+
+	// The SDKs run credential_process without a terminal to type an MFA code into
+	if app.TokenCommand == "" && app.OnePassword.Item == "" && !app.NoMFA {
+		console.Warnf("No token command is configured, so the generated profiles cannot get an MFA code")
+		console.Hintf("Set TokenCommand in %s, e.g. to read the code from 1Password, pass or a YubiKey.", app.Config)
+	}
+	return 0
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	appc "gredentures/pkg/appconfig"
+	"gredentures/pkg/history"
+	"gredentures/pkg/messages"
+)
+
+// recordLogin adds the login to the history, failed when err is set. Nothing is recorded with
+// --no-write, and a history that cannot be written never stops the run.
+func recordLogin(app appc.AppConfig, err error) {
+	if app.NoWrite {
+		return
+	}
+	entry := history.Entry{Org: app.Org, Device: app.Device, Duration: app.Timeout, Result: history.ResultOK}
+	if app.NoMFA {
+		entry.Device = ""
+	}
+	if err != nil {
+		entry.Result = history.ResultFailed
+	}
+	if err := history.Record(history.DefaultPath(), entry, history.DefaultKeep); err != nil {
+		slog.Debug("Failed to record login history", "error", err)
+	}
+}
+
+// promptDefaults asks for a missing org and MFA device, offering those of the last successful
+// login. Nothing is asked before the first login, when a recipe or the Device of the org
+// supplies the device, when the token is piped on stdin, or when nobody can be asked;
+// ValidateOptions reports them missing.
+func promptDefaults(app *appc.AppConfig) {
+	// applyOrg would replace a device asked for with the Device of the org
+	needDevice := func() bool { return app.Device == "" && !app.NoMFA && app.Orgs[app.Org].Device == "" }
+	if app.Org != "" && !needDevice() || app.Recipe != "" || app.TokenStdin {
+		return
+	}
+	logins, err := history.Load(history.DefaultPath())
+	if err != nil {
+		slog.Debug("Ignoring unreadable login history", "error", err)
+		return
+	}
+	ask := func(id messages.ID, last string) string {
+		if last == "" {
+			return ""
+		}
+		asker := prompter(*app)
+		if asker == nil {
+			return ""
+		}
+		answer, err := asker.Ask(text(id, messages.Args{"Default": last}))
+		if err != nil {
+			return ""
+		}
+		if answer == "" {
+			return last
+		}
+		return answer
+	}
+	if app.Org == "" {
+		app.Org = ask(messages.PromptOrg, history.LastOrg(logins))
+	}
+	if needDevice() {
+		app.Device = ask(messages.PromptDevice, history.LastDevice(logins, app.Org))
+	}
+}
+
+// runHistory handles "gredentures history", printing the recent logins as a table, and
+// returns the exit code.
+func runHistory(app appc.AppConfig) int {
+	if err := app.GetGredenturesConfig(); err != nil {
+		console.Errorf("Error getting gredentures config: %v", err)
+		return 1
+	}
+
+	logins, err := history.Load(history.DefaultPath())
+	if err != nil {
+		console.Errorf("Error reading login history: %v", err)
+		return 1
+	}
+	if len(logins) == 0 {
+		console.Notef("No logins recorded yet in %s", history.DefaultPath())
+		return 0
+	}
+
+	rows := make([][]string, 0, len(logins))
+	for _, entry := range logins {
+		device := entry.Device
+		if device == "" {
+			device = "-"
+		}
+		rows = append(rows, []string{entry.Time.Local().Format(time.RFC3339), entry.Org, device,
+			(time.Duration(entry.Duration) * time.Second).String(), entry.Result})
+	}
+	console.Table([]string{"TIME", "ORG", "DEVICE", "DURATION", "RESULT"}, rows)
+	return 0
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"time"
+
+	appc "gredentures/pkg/appconfig"
+	appa "gredentures/pkg/awsconfig"
+
+	"golang.org/x/term"
+)
+
+// clipboardCommands print the clipboard, tried in order on each OS.
+var clipboardCommands = map[string][][]string{
+	"darwin":  {{"pbpaste"}},
+	"windows": {{"powershell.exe", "-NoProfile", "-Command", "Get-Clipboard"}},
+	"linux": {
+		{"wl-paste", "--no-newline"},
+		{"xclip", "-selection", "clipboard", "-out"},
+		{"xsel", "--clipboard", "--output"},
+		{"powershell.exe", "-NoProfile", "-Command", "Get-Clipboard"}, // WSL
+	},
+}
+
+// runImport handles "gredentures import": it reads temporary credentials copied from the AWS
+// access portal and writes them to a profile of the credentials file, for accounts
+// gredentures cannot log in to itself. It returns the exit code.
+func runImport(app appc.AppConfig, creds *appa.AwsConfig) int {
+	if err := app.GetGredenturesConfig(); err != nil {
+		console.Errorf("Error getting gredentures config: %v", err)
+		return 1
+	}
+	creds.SetSourceProfile(app)
+
+	lifetime, err := appc.ParseTimeout(app.Expires)
+	if err != nil {
+		console.Errorf("Error parsing --expires: %v", err)
+		return 1
+	}
+
+	text, err := readImport(app.Clipboard)
+	if err != nil {
+		console.Errorf("Error reading the credentials: %v", err)
+		return 1
+	}
+	profile, err := appa.ParseImport(text)
+	if err != nil {
+		console.Errorf("Error parsing the credentials: %v", err)
+		return 1
+	}
+	if app.ProfileArg != "" {
+		profile.Name = app.ProfileArg
+	}
+
+	switch {
+	case profile.Name == "":
+		console.Errorf("The pasted credentials do not name a profile")
+		console.Hintf("Give the profile to write them to, e.g. gredentures import sso-dev.")
+		return 1
+	case profile.Name == creds.SourceProfileName():
+		console.Errorf("Profile %s holds the long-lived keys of gredentures and is never overwritten by an import", profile.Name)
+		return 1
+	case slices.Contains(app.ManagedProfiles(), profile.Name):
+		console.Warnf("Profile %s is managed by gredentures, the next login overwrites the imported credentials", profile.Name)
+	}
+
+	imported, err := appa.ImportProfile(appa.CredentialsPath(), profile, time.Duration(lifetime)*time.Second, time.Now())
+	if err != nil {
+		console.Errorf("Error importing the credentials: %v", err)
+		return 1
+	}
+	console.Successf("Imported profile %s into %s, valid until %s.", imported.Name, appa.CredentialsPath(),
+		imported.Credentials.Expires.Local().Format(time.RFC1123))
+	return 0
+}
+
+// readImport returns the text to import, read from the clipboard or from stdin up to EOF.
+func readImport(clipboard bool) (string, error) {
+	if clipboard {
+		return readClipboard()
+	}
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		console.Notef("Paste the credentials from the AWS access portal, then press Ctrl-D:")
+	}
+	data, err := io.ReadAll(os.Stdin)
+	return string(data), err
+}
+
+// readClipboard returns the clipboard contents using the first clipboard tool installed.
+func readClipboard() (string, error) {
+	for _, command := range clipboardCommands[runtime.GOOS] {
+		if _, err := exec.LookPath(command[0]); err != nil {
+			continue
+		}
+		out, err := exec.Command(command[0], command[1:]...).Output()
+		if err != nil {
+			return "", fmt.Errorf("%s failed: %w", command[0], err)
+		}
+		return string(out), nil
+	}
+	return "", fmt.Errorf("no clipboard tool found, paste the credentials on stdin instead")
+}
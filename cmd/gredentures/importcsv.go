@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+
+	appc "gredentures/pkg/appconfig"
+	appa "gredentures/pkg/awsconfig"
+	"gredentures/pkg/secret"
+)
+
+// runImportCSV handles "gredentures import-csv": it stores the long-lived key pair of an
+// access keys CSV file downloaded from the IAM console, like aws configure import, in the key
+// store when one is configured and in the source profile otherwise. Keys already there are
+// only replaced with --force, as after rotating them. It returns the exit code.
+func runImportCSV(app appc.AppConfig, creds *appa.AwsConfig) int {
+	if err := app.GetGredenturesConfig(); err != nil {
+		console.Errorf("Error getting gredentures config: %v", err)
+		return 1
+	}
+	creds.SetSourceProfile(app)
+
+	keys, err := appa.ReadAccessKeysCSV(app.CSVFile)
+	if err != nil {
+		console.Errorf("Error reading the access keys: %v", err)
+		return 1
+	}
+	if keys.Name != "" {
+		console.Notef("The file holds access key %s of IAM user %s.", keys.Credentials.AccessKeyID, keys.Name)
+	}
+
+	var code int
+	if app.KeyStore.Backend != "" {
+		code = importCSVToKeyStore(app, creds, keys)
+	} else {
+		code = importCSVToProfile(app, creds, keys)
+	}
+	if code == 0 {
+		console.Hintf("Delete %s now, it holds the secret access key in plain text.", app.CSVFile)
+	}
+	return code
+}
+
+// importCSVToProfile writes the key pair of import-csv to the source profile of the credentials file.
+func importCSVToProfile(app appc.AppConfig, creds *appa.AwsConfig, keys appa.Profile) int {
+	configured, err := creds.SourceConfigured()
+	if err != nil {
+		console.Errorf("Error reading the credentials file: %v", err)
+		return 1
+	}
+	if configured && !app.Force {
+		current, err := creds.SourceKeys()
+		if err == nil && sameKeys(current, keys) {
+			console.Notef("Profile %s in %s already holds access key %s.", creds.SourceProfileName(), creds.SourceCredentialsPath(), keys.Credentials.AccessKeyID)
+			return 0
+		}
+		console.Errorf("Profile %s in %s already holds long-lived keys", creds.SourceProfileName(), creds.SourceCredentialsPath())
+		console.Hintf("Run again with --force to replace them, or set SourceProfile in %s to import into another profile.", app.Config)
+		return 1
+	}
+
+	if err := creds.BootstrapCredentials(keys.Credentials.AccessKeyID, secret.Value(keys.Credentials.SecretAccessKey)); err != nil {
+		console.Errorf("Error writing the keys: %v", err)
+		return 1
+	}
+	console.Successf("Imported access key %s into profile %s of %s.", keys.Credentials.AccessKeyID, creds.SourceProfileName(), creds.SourceCredentialsPath())
+	return 0
+}
+
+// importCSVToKeyStore stores the key pair of import-csv in the key store, under the org.
+func importCSVToKeyStore(app appc.AppConfig, creds *appa.AwsConfig, keys appa.Profile) int {
+	store, err := openKeyStore(app)
+	if err != nil {
+		console.Errorf("Error opening the key store: %v", err)
+		return 1
+	}
+	current, err := store.Keys(app.KeyName())
+	switch {
+	case errors.Is(err, appa.ErrNoStoredKeys):
+	case err != nil:
+		console.Errorf("Error reading the key store: %v", err)
+		return 1
+	case sameKeys(current, keys):
+		console.Notef("The %s key store already holds access key %s for %s.", app.KeyStore.Backend, keys.Credentials.AccessKeyID, app.KeyName())
+		return 0
+	case !app.Force:
+		console.Errorf("The %s key store already holds keys for %s", app.KeyStore.Backend, app.KeyName())
+		console.Hintf("Run again with --force to replace them, or select another org with -o.")
+		return 1
+	}
+
+	creds.SetSourceCreds(keys.Credentials.AccessKeyID, secret.Value(keys.Credentials.SecretAccessKey))
+	stored, err := creds.SourceKeys()
+	if err == nil {
+		err = store.StoreKeys(app.KeyName(), stored)
+	}
+	if err != nil {
+		console.Errorf("Error storing the keys: %v", err)
+		return 1
+	}
+	console.Successf("Stored access key %s in the %s key store as %s.", keys.Credentials.AccessKeyID, app.KeyStore.Backend, app.KeyName())
+	return 0
+}
+
+// sameKeys reports whether two profiles hold the same long-lived key pair.
+func sameKeys(a, b appa.Profile) bool {
+	return a.Credentials.AccessKeyID == b.Credentials.AccessKeyID && a.Credentials.SecretAccessKey == b.Credentials.SecretAccessKey
+}
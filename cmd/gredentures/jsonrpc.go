@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"time"
+
+	appc "gredentures/pkg/appconfig"
+	appa "gredentures/pkg/awsconfig"
+	"gredentures/pkg/interrupt"
+	"gredentures/pkg/jsonrpc"
+	"gredentures/pkg/secret"
+)
+
+// rpcProfile describes a profile in getStatus, login and listProfiles results.
+type rpcProfile struct {
+	Name    string `json:"name"`
+	Kind    string `json:"kind,omitempty"`         // session, org or recipe, in listProfiles.
+	Source  string `json:"source,omitempty"`       // Org or recipe the profile belongs to.
+	Valid   *bool  `json:"valid,omitempty"`        // Whether STS accepts the credentials, in getStatus.
+	Arn     string `json:"arn,omitempty"`          // Caller ARN of valid credentials.
+	Account string `json:"account,omitempty"`      // Account ID, when known.
+	Alias   string `json:"accountAlias,omitempty"` // IAM alias of the account, when cached.
+	Error   string `json:"error,omitempty"`        // Why the credentials are not valid.
+	Expires string `json:"expires,omitempty"`      // RFC 3339 expiry of the credentials written by login.
+	Pinned  bool   `json:"pinned,omitempty"`       // Pinned with switch --pin, such profiles are listed first.
+}
+
+// loginParams are the params of the login method. Every field is optional.
+type loginParams struct {
+	Token  string `json:"token"`  // MFA code, when no token command is configured.
+	All    bool   `json:"all"`    // Assume the role of every configured org.
+	Recipe string `json:"recipe"` // Login recipe to run.
+	NoMFA  bool   `json:"noMfa"`  // Request the session without MFA.
+}
+
+// rpcReasons names the recognised failures in the data of JSON-RPC errors and in API errors,
+// so plugins can react to them without matching on messages, e.g. by asking for an MFA code.
+var rpcReasons = []struct {
+	err    error
+	reason string
+}{
+	{appc.ErrSecurityKeyDevice, "securityKeyDevice"}, // Before missingToken, no code the key produces is accepted
+	{appc.ErrMissingToken, "missingToken"},
+	{appc.ErrInvalidDevice, "invalidDevice"},
+	{appc.ErrInvalidToken, "invalidToken"},
+	{appa.ErrSTSThrottled, "throttled"},
+	{appa.ErrExpiredToken, "expiredToken"},
+	{appa.ErrClockSkew, "clockSkew"},
+	{appa.ErrCredentialsFileLocked, "credentialsFileLocked"},
+	{appa.ErrMFARequired, "mfaRequired"},
+	{appa.ErrSTSUnreachable, "stsUnreachable"},
+	{appa.ErrForeignProfile, "foreignProfile"},
+	{appc.ErrInvalidProfile, "invalidProfile"},
+	{appa.ErrProfileCollision, "profileCollision"},
+}
+
+// runJSONRPC handles --json-rpc, serving getStatus, login and listProfiles requests from an
+// editor plugin on stdin and stdout until stdin is closed, and returns the exit code. Nothing
+// but responses is written to stdout; logs and errors go to stderr.
+func runJSONRPC(app appc.AppConfig, creds *appa.AwsConfig) int {
+	if err := app.GetGredenturesConfig(); err != nil {
+		console.Errorf("Error getting gredentures config: %v", err)
+		return 1
+	}
+	creds.SetSourceProfile(app) // getStatus calls STS through the configured proxy
+
+	server := jsonrpc.NewServer()
+	server.Handle("getStatus", func(ctx context.Context, params json.RawMessage) (any, error) {
+		return rpcStatus(app, creds), nil
+	})
+	server.Handle("listProfiles", func(ctx context.Context, params json.RawMessage) (any, error) {
+		return map[string]any{"profiles": rpcProfiles(app)}, nil
+	})
+	server.Handle("login", func(ctx context.Context, params json.RawMessage) (any, error) {
+		var p loginParams
+		if err := jsonrpc.DecodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		profiles, err := rpcLogin(app, *creds, p)
+		if err != nil {
+			return nil, rpcError(err)
+		}
+		return map[string]any{"profiles": profiles}, nil
+	})
+
+	if err := server.Serve(interrupt.Context(), os.Stdin, os.Stdout); err != nil && !errors.Is(err, context.Canceled) {
+		console.Errorf("Error serving JSON-RPC: %v", err)
+		return 1
+	}
+	return 0
+}
+
+// rpcStatus checks the credentials of every managed profile with STS.
+func rpcStatus(app appc.AppConfig, creds *appa.AwsConfig) map[string]any {
+	profiles := []rpcProfile{}
+	for _, profile := range rpcProfiles(app) {
+		arn, err := creds.ProfileIdentity(profile.Name)
+		status := rpcProfile{Name: profile.Name, Valid: new(bool), Arn: arn, Account: profile.Account, Alias: profile.Alias}
+		if err != nil {
+			status.Arn, status.Error = "", err.Error()
+		} else if account := arnAccount(arn); account != "" {
+			status.Account, status.Alias = account, appa.LoadAccountCache(appa.AccountCachePath()).Alias(account)
+		}
+		*status.Valid = err == nil
+		profiles = append(profiles, status)
+	}
+	return map[string]any{"version": version, "profiles": profiles}
+}
+
+// rpcProfiles lists the managed profiles: the Favorites, then the session profile and those of
+// the orgs and recipes sorted by name, with their account as far as the account cache knows it.
+func rpcProfiles(app appc.AppConfig) []rpcProfile {
+	profiles := []rpcProfile{{Name: app.Profile, Kind: "session"}}
+	for _, name := range slices.Sorted(maps.Keys(app.Orgs)) {
+		profiles = append(profiles, rpcProfile{Name: app.Orgs[name].ProfileName(name), Kind: "org", Source: name})
+	}
+	for _, name := range slices.Sorted(maps.Keys(app.Recipes)) {
+		profile := app.Recipes[name].ProfileName(name)
+		if !slices.ContainsFunc(profiles, func(p rpcProfile) bool { return p.Name == profile }) {
+			profiles = append(profiles, rpcProfile{Name: profile, Kind: "recipe", Source: name})
+		}
+	}
+
+	cache := appa.LoadAccountCache(appa.AccountCachePath())
+	for i, profile := range profiles {
+		account := cache.ProfileAccount(profile.Name)
+		if account == "" && profile.Kind == "org" {
+			account = arnAccount(app.Orgs[profile.Source].RoleArn)
+		}
+		profiles[i].Account, profiles[i].Alias = account, cache.Alias(account)
+	}
+	return pinnedFirst(profiles, app.Favorites)
+}
+
+// rpcLogin logs in like a plain gredentures run, writing the credentials files unless
+// --no-write is given, and returns the issued profiles. creds is a copy, so no request sees
+// the credentials of an earlier one.
+func rpcLogin(app appc.AppConfig, creds appa.AwsConfig, p loginParams) ([]rpcProfile, error) {
+	app.Token = secret.Value(p.Token)
+	app.All = p.All
+	app.Recipe = p.Recipe
+	app.NoMFA = app.NoMFA || p.NoMFA
+
+	// A one-time password read from 1Password at startup would be stale by now
+	if err := loadOnePassword(&app, &creds); err != nil {
+		return nil, fmt.Errorf("failed to read 1Password item: %w", err)
+	}
+	if err := app.ValidateOptions(); err != nil {
+		return nil, err
+	}
+	if !app.NoWrite {
+		recordUsage(app)
+	}
+
+	creds.SetSourceProfile(app)
+	if err := creds.GetDefaultCreds(); err != nil {
+		return nil, fmt.Errorf("failed to get default credentials: %w", err)
+	}
+	if err := creds.GetSessionCreds(app); err != nil {
+		return nil, err
+	}
+	if app.All {
+		if err := creds.GetRoleCreds(app); err != nil {
+			return nil, err
+		}
+	}
+	if app.Recipe != "" {
+		if err := creds.GetRecipeCreds(app); err != nil {
+			return nil, err
+		}
+	}
+	issued := creds.IssueEvents()
+	cacheAccounts(app, &creds, issued)
+	recordAudit(app, issued...)
+
+	if !app.NoWrite {
+		for _, result := range creds.WriteCredentialsFiles(app.CredentialsFiles) {
+			if result.Err != nil {
+				return nil, fmt.Errorf("failed to write %s: %w", result.Path, result.Err)
+			}
+			recordAudit(app, writeEvent(result.Path, issued))
+		}
+	}
+
+	written := &profileCollector{}
+	if err := creds.WriteCredentials(written); err != nil {
+		return nil, err
+	}
+	return written.profiles, nil
+}
+
+// rpcError adds the reason of a recognised failure to err, see rpcReasons.
+func rpcError(err error) error {
+	if reason := failureReason(err); reason != "" {
+		return &jsonrpc.Error{Code: jsonrpc.CodeServerError, Message: err.Error(), Data: map[string]string{"reason": reason}}
+	}
+	return err
+}
+
+// failureReason returns the reason rpcReasons names err by, empty when it is not recognised.
+func failureReason(err error) string {
+	for _, r := range rpcReasons {
+		if errors.Is(err, r.err) {
+			return r.reason
+		}
+	}
+	return ""
+}
+
+// profileCollector is a CredentialWriter that only records the names and expiry of the
+// session and role profiles.
+type profileCollector struct {
+	profiles []rpcProfile
+}
+
+// WriteCredentials implements appa.CredentialWriter.
+func (c *profileCollector) WriteCredentials(set appa.CredentialSet) error {
+	for _, profile := range append([]appa.Profile{set.Session}, set.Roles...) {
+		entry := rpcProfile{Name: profile.Name}
+		if profile.Credentials.CanExpire {
+			entry.Expires = profile.Credentials.Expires.UTC().Format(time.RFC3339)
+		}
+		c.profiles = append(c.profiles, entry)
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"time"
+
+	appc "gredentures/pkg/appconfig"
+	appa "gredentures/pkg/awsconfig"
+	"gredentures/pkg/secret"
+	"gredentures/pkg/ui"
+)
+
+// keyStorePassphraseEnv holds the passphrase of the key file, for runs nobody can be asked in.
+const keyStorePassphraseEnv = "GREDENTURES_KEYSTORE_PASSPHRASE"
+
+// openKeyStore returns the configured key store, nil when there is none. The passphrase of
+// the key file is read from GREDENTURES_KEYSTORE_PASSPHRASE or asked for, twice for a new file.
+func openKeyStore(app appc.AppConfig) (appa.KeyStore, error) {
+	return appa.NewKeyStore(app, func(confirm bool) (secret.Value, error) {
+		if passphrase := os.Getenv(keyStorePassphraseEnv); passphrase != "" {
+			return secret.Value(passphrase), nil
+		}
+		ask := prompter(app)
+		if ask == nil {
+			return "", fmt.Errorf("cannot prompt for it, set %s", keyStorePassphraseEnv)
+		}
+		passphrase, err := ask.AskSecret("Key file passphrase: ")
+		if err != nil || !confirm {
+			return passphrase, err
+		}
+		repeated, err := ask.AskSecret("Repeat the passphrase: ")
+		if err != nil {
+			return "", err
+		}
+		if repeated != passphrase {
+			return "", fmt.Errorf("the passphrases differ")
+		}
+		return passphrase, nil
+	})
+}
+
+// loadStoredKeys supplies the long-lived keys the key store holds for the org, unless
+// 1Password supplied them already. An org without stored keys is left to bootstrapCredentials.
+func loadStoredKeys(app appc.AppConfig, creds *appa.AwsConfig) error {
+	if app.KeyStore.Backend == "" || app.OnePassword.Item != "" {
+		return nil
+	}
+	store, err := openKeyStore(app)
+	if err != nil {
+		return err
+	}
+	keys, err := store.Keys(app.KeyName())
+	if errors.Is(err, appa.ErrNoStoredKeys) {
+		slog.Debug("No long-lived keys stored for the org", "org", app.KeyName(), "backend", app.KeyStore.Backend)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	slog.Info("Using the long-lived keys of the key store", "org", app.KeyName(), "backend", app.KeyStore.Backend)
+	creds.SetSourceCreds(keys.Credentials.AccessKeyID, secret.Value(keys.Credentials.SecretAccessKey))
+	return nil
+}
+
+// runKeys handles "gredentures keys": it adds, imports, removes or lists the long-lived keys
+// of the key store, stored per org, and returns the exit code.
+func runKeys(app appc.AppConfig, creds *appa.AwsConfig) int {
+	if err := app.GetGredenturesConfig(); err != nil {
+		console.Errorf("Error getting gredentures config: %v", err)
+		return 1
+	}
+	store, err := openKeyStore(app)
+	if err != nil {
+		console.Errorf("Error opening the key store: %v", err)
+		return 1
+	}
+	if store == nil {
+		console.Errorf("No key store is configured")
+		console.Hintf("Set KeyStore.Backend to %s or %s in %s.", appc.KeyStoreKeychain, appc.KeyStoreFile, app.Config)
+		return 1
+	}
+
+	switch {
+	case app.Add:
+		return runKeysAdd(app, creds, store)
+	case app.ImportCmd:
+		return runKeysImport(app, creds, store)
+	case app.Remove:
+		return runKeysRemove(app, store)
+	}
+	names, err := store.Names()
+	if err != nil {
+		console.Errorf("Error listing the key store: %v", err)
+		return 1
+	}
+	if len(names) == 0 {
+		console.Notef("The %s key store holds no keys yet, add them with gredentures keys add.", app.KeyStore.Backend)
+		return 0
+	}
+	for _, name := range names {
+		console.Printf("%s\n", name)
+	}
+	return 0
+}
+
+// runKeysAdd handles "gredentures keys add", storing a long-lived key pair asked for under
+// the org, replacing any stored before.
+func runKeysAdd(app appc.AppConfig, creds *appa.AwsConfig, store appa.KeyStore) int {
+	ask := prompter(app)
+	if ask == nil {
+		console.Errorf("Cannot prompt for the keys to add")
+		return 1
+	}
+	accessKeyID, err := ask.Ask("AWS Access Key ID: ")
+	if err != nil {
+		console.Errorf("Error reading access key ID: %v", err)
+		return 1
+	}
+	secretAccessKey, err := ask.AskSecret("AWS Secret Access Key: ")
+	if err != nil {
+		console.Errorf("Error reading secret access key: %v", err)
+		return 1
+	}
+
+	creds.SetSourceCreds(accessKeyID, secretAccessKey)
+	keys, err := creds.SourceKeys()
+	if err == nil {
+		err = store.StoreKeys(app.KeyName(), keys)
+	}
+	if err != nil {
+		console.Errorf("Error storing the keys: %v", err)
+		return 1
+	}
+	console.Successf("Stored the long-lived keys of %s in the %s key store.", app.KeyName(), app.KeyStore.Backend)
+	return 0
+}
+
+// runKeysImport handles "gredentures keys import", moving the long-lived keys of the source
+// profile out of the credentials file into the key store, under the org. Keys already stored
+// for the org are never replaced.
+func runKeysImport(app appc.AppConfig, creds *appa.AwsConfig, store appa.KeyStore) int {
+	if _, err := store.Keys(app.KeyName()); err == nil {
+		console.Errorf("The %s key store already holds keys for %s", app.KeyStore.Backend, app.KeyName())
+		console.Hintf("Remove them first with gredentures keys remove, or select another org with -o.")
+		return 1
+	} else if !errors.Is(err, appa.ErrNoStoredKeys) {
+		console.Errorf("Error reading the key store: %v", err)
+		return 1
+	}
+
+	creds.SetSourceProfile(app)
+	keys, err := creds.SourceKeys()
+	if err != nil {
+		console.Errorf("Error reading the long-lived keys: %v", err)
+		return 1
+	}
+	if err := store.StoreKeys(app.KeyName(), keys); err != nil {
+		console.Errorf("Error storing the keys: %v", err)
+		return 1
+	}
+	if err := creds.RemoveSourceKeys(); err != nil {
+		console.Errorf("Stored the keys, but could not remove them from %s: %v", creds.SourceCredentialsPath(), err)
+		return 1
+	}
+	console.Successf("Moved the long-lived keys of profile %s in %s to the %s key store as %s.",
+		keys.Name, creds.SourceCredentialsPath(), app.KeyStore.Backend, app.KeyName())
+	return 0
+}
+
+// runKeysReport handles "gredentures keys report": it lists the access keys of the IAM user of
+// the long-lived keys with their age and last use, and warns about active keys older than the
+// KeyMaxAge of the org. It returns the exit code.
+func runKeysReport(app appc.AppConfig, creds *appa.AwsConfig) int {
+	creds.SetSourceProfile(app)
+	spinner := spin(app, "Looking up access keys...")
+	keys, err := creds.AccessKeys()
+	spinner.Stop()
+	if err != nil {
+		console.Errorf("Error looking up access keys: %v", err)
+		console.Hintf("The report requires iam:ListAccessKeys and iam:GetAccessKeyLastUsed on your IAM user.")
+		return 1
+	}
+
+	now, maxAge := time.Now(), app.KeyMaxAge()
+	rows := make([][]string, 0, len(keys))
+	for _, key := range keys {
+		id, age, lastUsed := key.AccessKeyID, days(key.Age(now)), "never"
+		if key.Current {
+			id += " (source)"
+		}
+		if key.Expired(now, maxAge) {
+			age = console.Paint(ui.Red, age)
+		}
+		if !key.LastUsed.IsZero() {
+			lastUsed = fmt.Sprintf("%s ago, %s in %s", days(now.Sub(key.LastUsed)), key.LastService, key.LastRegion)
+		}
+		rows = append(rows, []string{id, key.UserName, key.Status, key.Created.Local().Format(time.DateOnly), age, lastUsed})
+	}
+	console.Table([]string{"ACCESS KEY", "USER", "STATUS", "CREATED", "AGE", "LAST USED"}, rows)
+
+	for _, key := range keys {
+		if key.Expired(now, maxAge) {
+			console.Warnf("Access key %s is %s old, older than the %s allowed", key.AccessKeyID, days(key.Age(now)), days(maxAge))
+		}
+	}
+	if slices.ContainsFunc(keys, func(key appa.AccessKey) bool { return key.Expired(now, maxAge) }) {
+		console.Hintf("Create a new access key, store it with gredentures keys add, then deactivate and delete the old one.")
+	}
+	return 0
+}
+
+// days describes d in whole days, e.g. "91 days".
+func days(d time.Duration) string {
+	if n := int(d / (24 * time.Hour)); n != 1 {
+		return fmt.Sprintf("%d days", n)
+	}
+	return "1 day"
+}
+
+// runKeysRemove handles "gredentures keys remove", deleting the long-lived keys of the org
+// from the key store once confirmed.
+func runKeysRemove(app appc.AppConfig, store appa.KeyStore) int {
+	if !confirm(app, fmt.Sprintf("Delete the long-lived keys of %s from the %s key store?", app.KeyName(), app.KeyStore.Backend)) {
+		console.Notef("Nothing was deleted.")
+		return 1
+	}
+	if err := store.DeleteKeys(app.KeyName()); err != nil {
+		console.Errorf("Error deleting the keys: %v", err)
+		return 1
+	}
+	console.Successf("Deleted the long-lived keys of %s from the %s key store.", app.KeyName(), app.KeyStore.Backend)
+	return 0
+}
@@ -4,67 +4,379 @@
 package main
 
 import (
-	"fmt"
+	"errors"
 	"log/slog"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
 
 	appc "gredentures/pkg/appconfig"
 	appa "gredentures/pkg/awsconfig"
+	"gredentures/pkg/interrupt"
+	"gredentures/pkg/messages"
+	"gredentures/pkg/progress"
+	"gredentures/pkg/sysuser"
+	"gredentures/pkg/ui"
+	"gredentures/pkg/validate"
+
+	"golang.org/x/term"
 )
 
 var version = "dev" // Overwritten during build
 
-// EnvVarMessageTemplate provides instructions for setting the AWS_PROFILE environment variable
-// to use the session credentials by default.
-const EnvVarMessageTemplate = `
-***********************************************************************************
-* To use your session creds by default please add the following to your profile:  *
-*                                                                                 *
-* export AWS_PROFILE=%s                                                           *
-* then source your profile                                                        *
-***********************************************************************************
-`
+// exitPartialSuccess is the exit code of a --soft-fail run that wrote credentials while
+// some org roles failed, so scripts can tell it from a failed and a complete login.
+const exitPartialSuccess = 3
+
+// console prints every message and table, so all commands share one style.
+var console = ui.New(os.Stdout, os.Stderr)
 
 // main is the entry point for the Gredentures CLI tool.
 // It handles the parsing of command-line arguments, validation of configurations,
 // and management of AWS credentials for MFA authentication.
 func main() {
-	fmt.Printf("Gredentures CLI version: %s\n", version)
-
 	var g_app appc.AppConfig
 	var g_aws appa.AwsConfig
+	exitCode := 0
+
+	// Ctrl-C or SIGTERM cancels AWS calls, restores the terminal and removes temporary files.
+	interrupt.Notify()
+
+	// Pick the translation of the messages before anything is printed.
+	loadCatalog()
+
+	// The completion scripts call back with the words typed so far, which are no command line.
+	if len(os.Args) > 1 && os.Args[1] == appc.CompleteCommand {
+		os.Exit(runComplete(os.Args[2:]))
+	}
 
 	// Parse command-line arguments.
 	if err := g_app.Parse(os.Args[1:]); err != nil {
-		fmt.Printf("Error parsing command line arguments: %v\n", err)
+		console.Errorf("%s", text(messages.ErrParseArgs, messages.Args{"Err": err}))
 	}
+	appa.SetAWSConfigPath(g_app.AWSConfigFile)
+
+	// Capture sanitized traces of the AWS requests for a support ticket.
+	if g_app.DebugHTTP != "" {
+		if err := appa.TraceHTTP(g_app.DebugHTTP); err != nil {
+			console.Errorf("%s", text(messages.ErrDebugHTTP, messages.Args{"Err": err}))
+			os.Exit(1)
+		}
+	}
+
+	// Load the config file up front, its Output and CredentialsFile decide where the credentials
+	// go. A config file that fails to load is reported by the command loading it again.
+	if !g_app.ConfigCmd && !g_app.EnvCmd && !g_app.CompleteCmd {
+		if err := g_app.GetGredenturesConfig(); err != nil {
+			slog.Debug("Config file not loaded yet", "err", err)
+		}
+		appa.SetCredentialsPath(g_app.CredentialsFile)
+	}
+
+	// Keep stdout clean when it carries exported credentials, a config path, generated config, shell commands, completion scripts, JSON-RPC responses or a status check, or quiet is requested.
+	if !g_app.Export && g_app.Output == appc.OutputINI && !g_app.Quiet && !g_app.ShowPath && !g_app.JSONRPC && !g_app.EnvCmd && !g_app.Isolated && !g_app.GenerateCmd && !g_app.AWSVaultCmd && !g_app.SwitchCmd && !g_app.CompleteCmd && !g_app.Check {
+		console.Printf("%s\n", text(messages.Banner, messages.Args{"Version": version}))
+	}
+
+	// Subcommands that need no credentials at all.
+	runSubcommand(stageParsed, &g_app, &g_aws)
+
+	// Read the MFA token piped in with --token -. From a terminal it is asked for instead, unechoed.
+	if g_app.TokenStdin && !term.IsTerminal(int(os.Stdin.Fd())) {
+		if err := g_app.ReadToken(os.Stdin); err != nil {
+			console.Errorf("%s", text(messages.ErrValidateOptions, messages.Args{"Err": err}))
+			printHint(err)
+			os.Exit(1)
+		}
+	}
+
+	// Read secrets from 1Password when an item is configured.
+	if err := loadOnePassword(&g_app, &g_aws); err != nil {
+		console.Errorf("%s", text(messages.ErrOnePassword, messages.Args{"Err": err}))
+	}
+
+	// Read the long-lived keys of the org from the key store when one is configured.
+	if err := loadStoredKeys(g_app, &g_aws); err != nil {
+		console.Errorf("%s", text(messages.ErrKeyStore, messages.Args{"Err": err}))
+		printHint(err)
+	}
+
+	// Secrets in the arguments are readable by every user on the host.
+	warnArgvSecrets(g_app)
+
+	// Subcommands that only need the long-lived credentials.
+	runSubcommand(stageSource, &g_app, &g_aws)
+
+	// Offer the org and MFA device of the last login when they are not set anywhere.
+	promptDefaults(&g_app)
 
 	// Validate Gredentures configuration and options.
 	slog.Info("Validating gredentures options and config...")
-	if err := g_app.ValidateOptions(); err != nil {
-		fmt.Printf("Error validating options: %v\n", err)
+	err := g_app.ValidateOptions()
+	// The token is only asked for when it is the one problem, others are reported first
+	var problems validate.Errors
+	missingToken := errors.Is(err, appc.ErrMissingToken) && (!errors.As(err, &problems) || len(problems) == 1)
+	if err != nil && !missingToken {
+		console.Errorf("%s", text(messages.ErrValidateOptions, messages.Args{"Err": err}))
+		printHint(err)
+	}
+	// The other commands are counted by runSubcommand
+	if !g_app.NoWrite && g_app.CommandName() == appc.CommandLogin {
+		recordUsage(g_app)
 	}
 
 	// Load default AWS credentials.
+	g_aws.SetSourceProfile(g_app)
+	if err := bootstrapCredentials(&g_aws, g_app); err != nil {
+		console.Errorf("%s", text(messages.ErrBootstrap, messages.Args{"Err": err}))
+	}
 	slog.Info("Getting default aws credentials...")
 	if err := g_aws.GetDefaultCreds(); err != nil {
-		fmt.Printf("Error getting default credentials: %v\n", err)
+		console.Errorf("%s", text(messages.ErrDefaultCreds, messages.Args{"Err": err}))
+	}
+
+	// Ask for a missing MFA code only now, after any first-run prompt for the key pair.
+	if missingToken {
+		if err := promptToken(&g_app); err != nil {
+			console.Errorf("%s", text(messages.ErrValidateOptions, messages.Args{"Err": err}))
+			printHint(err)
+		}
 	}
 
 	// Acquire session credentials.
 	slog.Info("Getting aws session credentials...")
-	if err := g_aws.GetSessionCreds(g_app); err != nil {
-		fmt.Printf("Error getting session credentials: %v\n", err)
+	spinner := spin(g_app, text(messages.ProgressSession, nil))
+	err = g_aws.GetSessionCreds(g_app)
+	spinner.Stop()
+	recordLogin(g_app, err)
+	if err != nil {
+		console.Errorf("%s", text(messages.ErrSessionCreds, messages.Args{"Err": err}))
+		printHint(err)
+	}
+
+	// Subcommands that use the MFA session instead of assuming roles.
+	runSubcommand(stageSession, &g_app, &g_aws)
+
+	// An Org naming an AWS Organization stands for an org per account, only listed when needed.
+	if g_app.IsOrganization() && g_app.All {
+		loadOrganization(&g_app, &g_aws)
+	}
+
+	// Assume the roles of all configured orgs with the session credentials.
+	if g_app.All {
+		slog.Info("Assuming roles for all configured orgs...")
+		spinner = spin(g_app, text(messages.ProgressOrgRoles, nil))
+		err = g_aws.GetRoleCreds(g_app)
+		spinner.Stop()
+		if errors.Is(err, appa.ErrPartialRoles) {
+			console.Warnf("%s", text(messages.WarnPartialRoles, messages.Args{"Err": err}))
+			printHint(err)
+		} else if err != nil {
+			console.Errorf("%s", text(messages.ErrOrgRoles, messages.Args{"Err": err}))
+			printHint(err)
+		}
+		if g_app.SoftFail && err != nil {
+			printRoleSummary(g_aws.RoleResults())
+			exitCode = exitPartialSuccess
+			if !errors.Is(err, appa.ErrPartialRoles) {
+				exitCode = 1 // Not a single role was assumed
+			}
+		}
+	}
+
+	// Run the role chain of the selected login recipe with the session credentials.
+	if g_app.Recipe != "" {
+		slog.Info("Running login recipe...", "recipe", g_app.Recipe)
+		spinner = spin(g_app, text(messages.ProgressRecipe, messages.Args{"Recipe": g_app.Recipe}))
+		err = g_aws.GetRecipeCreds(g_app)
+		spinner.Stop()
+		if err != nil {
+			console.Errorf("%s", text(messages.ErrRecipe, messages.Args{"Recipe": g_app.Recipe, "Err": err}))
+			printHint(err)
+			os.Exit(1)
+		}
+	}
+	g_aws.ApplyProfileNames(&g_app)
+	spinner = spin(g_app, text(messages.ProgressIdentity, nil))
+	issued := g_aws.IssueEvents()
+	cacheAccounts(g_app, &g_aws, issued)
+	spinner.Stop()
+	recordAudit(g_app, issued...)
+
+	// Subcommands that hand the credentials elsewhere instead of persisting them.
+	runSubcommand(stageIssued, &g_app, &g_aws)
+
+	// Hand the credentials to the requested writer instead of the credentials file.
+	if g_app.Output != appc.OutputINI || g_app.NoWrite {
+		var writer appa.CredentialWriter = &appa.SummaryWriter{Out: os.Stdout, ShowSecrets: g_app.ShowSecrets}
+		var err error
+		if !g_app.NoWrite {
+			writer, err = appa.NewCredentialWriter(g_app.Output, os.Stdout, g_app.Cluster)
+		}
+		if err == nil {
+			err = g_aws.WriteCredentials(writer)
+		}
+		if err != nil {
+			console.Errorf("%s", text(messages.ErrWriteOutput, messages.Args{"Output": g_app.Output, "Err": err}))
+			os.Exit(1)
+		}
+		if g_app.Output == appc.OutputKeychain && !g_app.NoWrite {
+			recordAudit(g_app, writeEvent("keychain", issued))
+		}
+		os.Exit(exitCode)
 	}
 
-	// Rewrite ~/.aws/credentials file.
+	// Write a throwaway credentials file instead, printing the exports that select it.
+	if g_app.Isolated {
+		path, err := g_aws.WriteIsolated()
+		if err != nil {
+			console.Errorf("%s", text(messages.ErrWriteIsolated, messages.Args{"Err": err}))
+			printHint(err)
+			os.Exit(1)
+		}
+		recordAudit(g_app, writeEvent(path, issued))
+		console.Printf("%s", appa.IsolatedExports(path, g_app.Profile))
+		console.Notef("%s", text(messages.WroteIsolated, messages.Args{"Path": path, "CredentialsPath": appa.CredentialsPath(), "Dir": filepath.Dir(path)}))
+		os.Exit(exitCode)
+	}
+
+	// Rewrite ~/.aws/credentials and any extra credentials files.
+	if g_app.WSLSync {
+		if windowsPath, err := appa.WindowsCredentialsPath(); err != nil {
+			console.Errorf("%s", text(messages.ErrWindowsPath, messages.Args{"Err": err}))
+		} else {
+			g_app.CredentialsFiles = append(g_app.CredentialsFiles, windowsPath)
+		}
+	}
 	slog.Info("Writing updated aws credentials file...")
-	if err := g_aws.CreateUpdatedConfig(); err != nil {
-		fmt.Printf("Error creating updated config: %v\n", err)
+	failed := false
+	for _, result := range g_aws.WriteCredentialsFiles(g_app.CredentialsFiles) {
+		if result.RemovedToken {
+			console.Notef("%s", text(messages.RemovedSessionToken, messages.Args{"Profile": g_aws.SourceProfileName(), "Path": result.Path}))
+		}
+		switch {
+		case result.Err != nil:
+			console.Errorf("%s", text(messages.ErrWriteFile, messages.Args{"Path": result.Path, "Err": result.Err}))
+			printHint(result.Err)
+			failed = true
+		case len(g_app.CredentialsFiles) > 0:
+			console.Successf("%s", text(messages.WroteFile, messages.Args{"Path": result.Path}))
+			fallthrough
+		default:
+			recordAudit(g_app, writeEvent(result.Path, issued))
+		}
 	}
 
-	// Print environment variable message if not the selected profile.
-	if os.Getenv("AWS_PROFILE") != g_app.Profile {
-		fmt.Printf(EnvVarMessageTemplate, g_app.Profile)
+	// Nothing to advise on when no session was written, scripts see the failure in the exit code.
+	if failed {
+		os.Exit(1)
 	}
+
+	// Credential variables in the invoking shell would shadow the profiles just written.
+	warnEnvConflicts()
+
+	// Print the login message, by default advice on selecting the session profile.
+	message, err := g_app.RenderLoginMessage()
+	if err != nil {
+		console.Errorf("%s", text(messages.ErrLoginMessage, messages.Args{"Err": err}))
+	}
+	console.Printf("%s", message)
+	os.Exit(exitCode)
+}
+
+// printRoleSummary lists the outcome of every org of --all on stderr, so a --soft-fail run
+// shows at a glance which roles were written and which are missing.
+func printRoleSummary(results []appa.RoleResult) {
+	rows := make([][]string, 0, len(results))
+	for _, result := range results {
+		if result.Err != nil {
+			rows = append(rows, []string{result.Org, "-", "failed", result.Err.Error()})
+			continue
+		}
+		rows = append(rows, []string{result.Org, result.Profile, "ok", ""})
+	}
+	console.NoteTable([]string{"ORG", "PROFILE", "RESULT", "ERROR"}, rows)
+}
+
+// spin starts a spinner on stderr for an AWS call that may take a while. Nothing is drawn
+// when stderr is not a terminal, with --quiet, or with -v, where log lines would garble it.
+func spin(app appc.AppConfig, message string) *progress.Spinner {
+	if app.Quiet || app.Verbose > 0 || !progress.Enabled(os.Stderr) {
+		return nil
+	}
+	return progress.Start(os.Stderr, message)
+}
+
+// printHint suggests a fix for the failure categories gredentures can recognise.
+func printHint(err error) {
+	switch {
+	case errors.Is(err, appc.ErrSecurityKeyDevice):
+		// Checked first, no token the key could produce would be accepted
+		console.Hintf("%s", text(messages.HintSecurityKeyDevice, nil))
+	case errors.Is(err, appc.ErrMissingToken):
+		console.Hintf("%s", text(messages.HintMissingToken, nil))
+	case errors.Is(err, appc.ErrInvalidDevice):
+		console.Hintf("%s", text(messages.HintInvalidDevice, nil))
+	case errors.Is(err, appc.ErrInvalidToken):
+		console.Hintf("%s", text(messages.HintInvalidToken, nil))
+	case errors.Is(err, appa.ErrSTSThrottled):
+		console.Hintf("%s", text(messages.HintThrottled, nil))
+	case errors.Is(err, appa.ErrExpiredToken):
+		console.Hintf("%s", text(messages.HintExpiredToken, nil))
+	case errors.Is(err, appa.ErrClockSkew):
+		console.Hintf("%s", text(messages.HintClockSkew, nil))
+	case errors.Is(err, appa.ErrCredentialsFileLocked):
+		console.Hintf("%s", text(messages.HintFileLocked, nil))
+	case errors.Is(err, appa.ErrMFARequired):
+		console.Hintf("%s", text(messages.HintMFARequired, nil))
+	case errors.Is(err, appa.ErrIncompleteCredentials):
+		console.Hintf("%s", text(messages.HintIncompleteCredentials, nil))
+	case appa.MissingExternalID(err):
+		console.Hintf("%s", text(messages.HintExternalIDRequired, nil))
+	case errors.Is(err, appa.ErrSTSUnreachable):
+		console.Hintf("%s", text(messages.HintSTSUnreachable, nil))
+	case errors.Is(err, appa.ErrKeyFilePassphrase):
+		console.Hintf("%s", text(messages.HintKeyFilePassphrase, nil))
+	case errors.Is(err, sysuser.ErrForeignFile):
+		console.Hintf("%s", text(messages.HintForeignFile, nil))
+	case errors.Is(err, appa.ErrForeignProfile):
+		console.Hintf("%s", text(messages.HintForeignProfile, nil))
+	case errors.Is(err, appc.ErrInvalidProfile):
+		console.Hintf("%s", text(messages.HintInvalidProfile, nil))
+	case errors.Is(err, appa.ErrProfileCollision):
+		console.Hintf("%s", text(messages.HintProfileCollision, nil))
+	}
+}
+
+// runCommand executes the given command with env, which carries the session credentials, and
+// returns the exit code to propagate. The credentials file is never touched.
+func runCommand(command []string, env []string) int {
+	slog.Debug("Running command with session credentials", "command", command[0])
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// The command decides how to handle Ctrl-C, gredentures waits for it and keeps its exit code
+	err := cmd.Start()
+	if err == nil {
+		stop := interrupt.Forward(cmd.Process)
+		err = cmd.Wait()
+		stop()
+	}
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+				return interrupt.ExitCode(status.Signal())
+			}
+			return exitErr.ExitCode()
+		}
+		console.Errorf("%s", text(messages.ErrRunCommand, messages.Args{"Err": err}))
+		return 1
+	}
+
+	return 0
 }
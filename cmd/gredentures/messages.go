@@ -0,0 +1,28 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	appc "gredentures/pkg/appconfig"
+	"gredentures/pkg/messages"
+)
+
+// catalog renders the messages of the login flow in the user's locale, see loadCatalog.
+var catalog = messages.Default()
+
+// loadCatalog selects the translation for the locale of the environment from the messages
+// directory next to the XDG config file, e.g. ~/.config/gredentures/messages/de.yml. A broken
+// translation is reported and English is used instead.
+func loadCatalog() {
+	loaded, err := messages.Load(filepath.Join(filepath.Dir(appc.XDGConfigPath()), "messages"), os.Environ())
+	if err != nil {
+		console.Warnf("Using English messages: %v", err)
+	}
+	catalog = loaded
+}
+
+// text renders message id of the catalog with args.
+func text(id messages.ID, args messages.Args) string {
+	return catalog.Text(id, args)
+}
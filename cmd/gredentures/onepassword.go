@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	appc "gredentures/pkg/appconfig"
+	appa "gredentures/pkg/awsconfig"
+	"gredentures/pkg/interrupt"
+	"gredentures/pkg/onepassword"
+)
+
+// loadOnePassword reads the long-lived access key pair and, unless a token was already
+// given, the MFA one-time password from the configured 1Password item.
+func loadOnePassword(app *appc.AppConfig, creds *appa.AwsConfig) error {
+	if err := app.GetGredenturesConfig(); err != nil {
+		return fmt.Errorf("error getting gredentures config: %w", err)
+	}
+	if app.OnePassword.Item == "" {
+		return nil
+	}
+
+	slog.Info("Reading aws credentials from 1Password...", "item", app.OnePassword.Item)
+	client := onepassword.NewFromEnv(app.OnePassword.ConnectHost)
+	item, err := client.GetItem(interrupt.Context(), app.OnePassword.Vault, app.OnePassword.Item)
+	if err != nil {
+		return err
+	}
+
+	creds.SetSourceCreds(item.AccessKeyID, item.SecretAccessKey)
+	if app.Token == "" && app.TokenCommand == "" {
+		app.Token = item.TOTP
+	}
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	appc "gredentures/pkg/appconfig"
+	"gredentures/pkg/messages"
+	"gredentures/pkg/prompt"
+)
+
+// prompters holds the Prompter created for each kind. Prompts read stdin through a buffer, so
+// every prompt of a run has to share one Prompter or the answers read ahead would be lost.
+var prompters = map[string]prompt.Prompter{}
+
+// prompter returns how to ask the user for missing input, or nil when nobody can be asked.
+func prompter(app appc.AppConfig) prompt.Prompter {
+	if ask, ok := prompters[app.Prompt]; ok {
+		return ask
+	}
+	ask, err := prompt.New(app.Prompt)
+	if err != nil {
+		console.Warnf("Cannot prompt for input: %v", err)
+	}
+	prompters[app.Prompt] = ask
+	return ask
+}
+
+// confirm asks a yes or no question, defaulting to no. It returns false when nobody can be asked.
+func confirm(app appc.AppConfig, question string) bool {
+	ask := prompter(app)
+	if ask == nil {
+		return false
+	}
+	answer, err := ask.Ask(text(messages.PromptConfirm, messages.Args{"Question": question}))
+	if err != nil {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// promptToken asks for the MFA code when neither --token nor a token command provided one,
+// then validates the options again with it.
+func promptToken(app *appc.AppConfig) error {
+	ask := prompter(*app)
+	if ask == nil {
+		return appc.ErrMissingToken
+	}
+
+	token, err := ask.AskSecret(text(messages.PromptMFACode, nil))
+	if err != nil {
+		return fmt.Errorf("%w: %w", appc.ErrMissingToken, err)
+	}
+	if token == "" {
+		return appc.ErrMissingToken
+	}
+	app.Token = token
+	return app.ValidateOptions()
+}
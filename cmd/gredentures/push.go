@@ -0,0 +1,34 @@
+package main
+
+import (
+	appc "gredentures/pkg/appconfig"
+	"gredentures/pkg/audit"
+	appa "gredentures/pkg/awsconfig"
+)
+
+// runPush handles "gredentures push", copying the session profile already written to the
+// local credentials file to a remote host over SSH without calling STS, and returns the exit
+// code. The long-lived keys are never copied.
+func runPush(app appc.AppConfig) int {
+	if err := app.GetGredenturesConfig(); err != nil {
+		console.Errorf("Error getting gredentures config: %v", err)
+		return 1
+	}
+
+	remotePath := app.RemotePath
+	if remotePath == "" {
+		remotePath = appa.DefaultRemotePath
+	}
+	spinner := spin(app, "Pushing "+app.Profile+" to "+app.Destination+"...")
+	err := appa.PushProfile(app.Destination, remotePath, app.Profile)
+	spinner.Stop()
+	if err != nil {
+		console.Errorf("Error pushing profile: %v", err)
+		return 1
+	}
+
+	target := app.Destination + ":" + remotePath
+	recordAudit(app, audit.Event{Action: audit.ActionWrite, Path: target, Profiles: []string{app.Profile}})
+	console.Successf("Pushed %s to %s", app.Profile, target)
+	return 0
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	appc "gredentures/pkg/appconfig"
+	appa "gredentures/pkg/awsconfig"
+)
+
+// runRolesDiscover handles "gredentures roles discover": it lists the roles the source user
+// can assume, proposes an org for each one that is not configured yet and, once confirmed,
+// adds them to the config file. It returns the exit code.
+func runRolesDiscover(app appc.AppConfig, creds *appa.AwsConfig) int {
+	spinner := spin(app, "Discovering assumable roles...")
+	roles, err := creds.DiscoverRoles()
+	spinner.Stop()
+	if err != nil {
+		console.Errorf("Error discovering roles: %v", err)
+		return 1
+	}
+	if len(roles) == 0 {
+		console.Warnf("No assumable roles found")
+		console.Hintf("Only roles named in the policies of the user, or roles of its own account trusting it, can be discovered.")
+		return 0
+	}
+
+	names := proposeOrgNames(roles, app.Orgs)
+	cache := appa.LoadAccountCache(appa.AccountCachePath())
+	orgs := map[string]appc.OrgConfig{}
+	rows := make([][]string, 0, len(roles))
+	for _, role := range roles {
+		name, status := names[role.Arn], "new"
+		if configured := configuredOrg(app.Orgs, role.Arn); configured != "" {
+			name, status = configured, "configured"
+		} else {
+			orgs[name] = appc.OrgConfig{RoleArn: role.Arn}
+		}
+		rows = append(rows, []string{name, cache.DisplayAccount(role.AccountID), role.Arn, role.Source, status})
+	}
+	console.Table([]string{"ORG", "ACCOUNT", "ROLE", "FOUND IN", "STATUS"}, rows)
+
+	if len(orgs) == 0 {
+		console.Successf("Every discovered role is configured already.")
+		return 0
+	}
+	if prompter(app) == nil {
+		console.Hintf("Run gredentures roles discover on a terminal, or choose how to ask with --prompt, to add the new roles to the config file.")
+		return 0
+	}
+	if !confirm(app, fmt.Sprintf("Add %d new orgs to %s?", len(orgs), app.Config)) {
+		console.Notef("Nothing was added.")
+		return 0
+	}
+
+	added, err := app.SaveOrgs(orgs)
+	if err != nil {
+		console.Errorf("Error saving orgs to the config file: %v", err)
+		return 1
+	}
+	console.Successf("Added %s to %s, log in to every org with gredentures --all.", strings.Join(added, ", "), app.Config)
+	return 0
+}
+
+// configuredOrg returns the org already configured with roleArn, or "" when there is none.
+func configuredOrg(orgs map[string]appc.OrgConfig, roleArn string) string {
+	for name, org := range orgs {
+		if org.RoleArn == roleArn {
+			return name
+		}
+	}
+	return ""
+}
+
+// proposeOrgNames returns an org name for each role ARN, see appc.OrgName. Role names
+// found in several accounts, or already used by a configured org, are prefixed with the
+// account ID to keep them apart.
+func proposeOrgNames(roles []appa.DiscoveredRole, configured map[string]appc.OrgConfig) map[string]string {
+	counts := map[string]int{}
+	for _, role := range roles {
+		counts[appc.OrgName(role.Name)]++
+	}
+
+	names := make(map[string]string, len(roles))
+	for _, role := range roles {
+		name := appc.OrgName(role.Name)
+		if _, taken := configured[name]; taken || counts[name] > 1 {
+			name = role.AccountID + "-" + name
+		}
+		names[role.Arn] = name
+	}
+	return names
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	appc "gredentures/pkg/appconfig"
+	appa "gredentures/pkg/awsconfig"
+	"gredentures/pkg/events"
+	"gredentures/pkg/interrupt"
+	"gredentures/pkg/localapi"
+)
+
+// eventsInterval is how often serve reads the credentials file for the events of the profiles.
+const eventsInterval = 30 * time.Second
+
+// runServe handles "gredentures serve", serving the status, login and profiles of --json-rpc
+// as a JSON API on a loopback address until interrupted, along with the events of the profiles,
+// and returns the exit code. Requests carry the bearer token of localapi.DefaultTokenPath,
+// created on first start.
+func runServe(app appc.AppConfig, creds *appa.AwsConfig) int {
+	if err := app.GetGredenturesConfig(); err != nil {
+		console.Errorf("Error getting gredentures config: %v", err)
+		return 1
+	}
+	if err := localapi.CheckLoopback(app.Listen); err != nil {
+		console.Errorf("Error starting the API: %v", err)
+		console.Hintf("The API hands out credentials, so it only listens on 127.0.0.1, ::1 or localhost.")
+		return 1
+	}
+	tokenPath := localapi.DefaultTokenPath()
+	token, err := localapi.LoadToken(tokenPath)
+	if err != nil {
+		console.Errorf("Error loading the API token: %v", err)
+		return 1
+	}
+	creds.SetSourceProfile(app) // The status calls STS through the configured proxy
+
+	stream := &events.Stream{Window: app.RefreshMargin(app.Profile), Windows: app.RefreshMargins()}
+	stream.Update(time.Now(), profileExpiries(app))
+	go watchExpiries(interrupt.Context(), app, stream)
+
+	server := localapi.NewServer(app.Listen, token)
+	server.Handle("GET /v1/status", func(ctx context.Context, body json.RawMessage) (any, error) {
+		return rpcStatus(app, creds), nil
+	})
+	server.Handle("GET /v1/profiles", func(ctx context.Context, body json.RawMessage) (any, error) {
+		return map[string]any{"profiles": rpcProfiles(app)}, nil
+	})
+	server.Handle("POST /v1/login", func(ctx context.Context, body json.RawMessage) (any, error) {
+		var p loginParams
+		if err := localapi.DecodeBody(body, &p); err != nil {
+			return nil, err
+		}
+		profiles, err := rpcLogin(app, *creds, p)
+		if err != nil {
+			return nil, apiError(err)
+		}
+		stream.Update(time.Now(), profileExpiries(app))
+		return map[string]any{"profiles": profiles}, nil
+	})
+	server.HandleStream("GET /v1/events", func(ctx context.Context, query url.Values, send func(any) error) error {
+		return streamEvents(ctx, stream, query.Get("follow") != "false", send)
+	})
+
+	console.Notef("Serving the API on http://%s, with the bearer token in %s.", app.Listen, tokenPath)
+	if err := server.ListenAndServe(interrupt.Context()); err != nil {
+		console.Errorf("Error serving the API: %v", err)
+		return 1
+	}
+	return 0
+}
+
+// apiError answers a recognised failure with 422 and its reason, see rpcReasons. Others are
+// left to localapi, which answers them with 500.
+func apiError(err error) error {
+	if reason := failureReason(err); reason != "" {
+		return &localapi.Error{Status: http.StatusUnprocessableEntity, Message: err.Error(), Reason: reason}
+	}
+	return err
+}
+
+// streamEvents sends the latest event of every profile, then with follow the events that
+// follow until ctx ends.
+func streamEvents(ctx context.Context, stream *events.Stream, follow bool, send func(any) error) error {
+	latest, changes, cancel := stream.Subscribe()
+	defer cancel()
+	for _, event := range latest {
+		if err := send(event); err != nil {
+			return err
+		}
+	}
+	for follow {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event := <-changes:
+			if err := send(event); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// watchExpiries updates stream with the expiry of the profiles every eventsInterval until ctx
+// ends, so logins of other gredentures runs and expiring credentials are noticed too.
+func watchExpiries(ctx context.Context, app appc.AppConfig, stream *events.Stream) {
+	ticker := time.NewTicker(eventsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			stream.Update(now, profileExpiries(app))
+		}
+	}
+}
+
+// profileExpiries returns when the credentials of each managed profile in the credentials file
+// expire. Profiles that are missing or hold credentials without an expiry are left out.
+func profileExpiries(app appc.AppConfig) map[string]time.Time {
+	expiries := map[string]time.Time{}
+	for _, entry := range rpcProfiles(app) {
+		profile, err := appa.ReadProfile(appa.CredentialsPath(), entry.Name)
+		if err == nil && profile.Credentials.CanExpire {
+			expiries[entry.Name] = profile.Credentials.Expires
+		}
+	}
+	return expiries
+}
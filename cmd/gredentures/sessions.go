@@ -0,0 +1,79 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"strings"
+	"time"
+
+	appc "gredentures/pkg/appconfig"
+	appa "gredentures/pkg/awsconfig"
+	"gredentures/pkg/ui"
+)
+
+// cloudTrailRetention is how long CloudTrail keeps the events sessions looks up.
+const cloudTrailRetention = 90 * 24 * time.Hour
+
+// runSessions handles "gredentures sessions": it lists the sessions CloudTrail recorded as
+// issued to the caller within --since, so unexpected ones stand out, and returns the exit code.
+// Nothing is written.
+func runSessions(app appc.AppConfig, creds *appa.AwsConfig) int {
+	seconds, err := appc.ParseTimeout(app.Since)
+	if err != nil {
+		console.Errorf("Error parsing --since: %v", err)
+		return 1
+	}
+	since := time.Duration(seconds) * time.Second
+	if since > cloudTrailRetention {
+		console.Warnf("CloudTrail keeps events for 90 days, older sessions are not listed")
+		since = cloudTrailRetention
+	}
+
+	spinner := spin(app, "Looking up sessions in CloudTrail...")
+	now := time.Now()
+	sessions, err := creds.IssuedSessions(now.Add(-since))
+	spinner.Stop()
+	if err != nil {
+		console.Errorf("Error looking up sessions: %v", err)
+		console.Hintf("Looking up sessions requires cloudtrail:LookupEvents in the account of the source profile.")
+		return 1
+	}
+	if len(sessions) == 0 {
+		console.Warnf("CloudTrail recorded no sessions issued within %s", app.Since)
+		console.Hintf("CloudTrail records events about 5 minutes after the request.")
+		return 0
+	}
+
+	rows := make([][]string, 0, len(sessions))
+	for _, session := range sessions {
+		key := cmp.Or(session.AccessKeyID, "-")
+		if session.Current {
+			key += " (this login)"
+		}
+		rows = append(rows, []string{
+			session.Time.Local().Format(time.DateTime),
+			session.Event,
+			cmp.Or(session.RoleArn, "-"),
+			key,
+			describeSessionExpiry(session, now),
+			cmp.Or(session.SourceIP, "-"),
+			session.Region,
+		})
+	}
+	console.Table([]string{"ISSUED", "EVENT", "ROLE", "ACCESS KEY", "EXPIRES", "SOURCE IP", "REGION"}, rows)
+	return 0
+}
+
+// describeSessionExpiry says whether the session of a CloudTrail event is still valid at now.
+func describeSessionExpiry(session appa.IssuedSession, now time.Time) string {
+	switch {
+	case session.Error != "":
+		return console.Paint(ui.Red, "refused, "+session.Error)
+	case session.Expires.IsZero():
+		return "unknown"
+	case session.Expires.After(now):
+		left := session.Expires.Sub(now).Round(time.Minute)
+		return fmt.Sprintf("in %s", strings.TrimSuffix(left.String(), "0s"))
+	}
+	return console.Paint(ui.Dim, "expired")
+}
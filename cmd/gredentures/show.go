@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	appc "gredentures/pkg/appconfig"
+	appa "gredentures/pkg/awsconfig"
+	"gredentures/pkg/ui"
+)
+
+// runShow handles "gredentures show", printing which keys a profile holds with the secret
+// redacted, when they expire and where they come from, and returns the exit code. Nothing is
+// modified and no AWS call is made. With --full the secrets are printed as they are, but only
+// once the user confirms.
+func runShow(app appc.AppConfig, creds *appa.AwsConfig) int {
+	if err := app.GetGredenturesConfig(); err != nil {
+		console.Errorf("Error getting gredentures config: %v", err)
+		return 1
+	}
+	creds.SetSourceProfile(app)
+
+	name := app.ProfileArg
+	if name == "" {
+		name = app.Profile
+	}
+	path, kind := describeProfile(app, creds, name)
+	profile, err := appa.ReadProfile(path, name)
+	if err != nil {
+		console.Errorf("Error reading profile: %v", err)
+		return 1
+	}
+
+	secretKey, token := profile.RedactedSecret(), "none"
+	if profile.Credentials.SessionToken != "" {
+		token = fmt.Sprintf("present, %d characters", len(profile.Credentials.SessionToken))
+	}
+	if app.Full {
+		if !confirmFull(app, name) {
+			console.Errorf("Not confirmed, nothing was printed.")
+			return 1
+		}
+		secretKey, token = profile.Credentials.SecretAccessKey, profile.Credentials.SessionToken
+	}
+
+	region := profile.Region
+	if region == "" {
+		region = "-"
+	}
+	account := profileAccount(app, name)
+	if account == "" {
+		account = "-"
+	}
+	console.Table([]string{"FIELD", "VALUE"}, [][]string{
+		{"Profile", name},
+		{"File", path},
+		{"Kind", kind},
+		{"Account", account},
+		{"Access key ID", profile.Credentials.AccessKeyID},
+		{"Secret access key", secretKey},
+		{"Session token", token},
+		{"Expires", describeExpiry(profile, time.Now())},
+		{"Region", region},
+	})
+	return 0
+}
+
+// describeProfile returns the credentials file holding name and what the profile is to
+// gredentures.
+func describeProfile(app appc.AppConfig, creds *appa.AwsConfig, name string) (path, kind string) {
+	if name == creds.SourceProfileName() {
+		return creds.SourceCredentialsPath(), "long-lived keys, the gredentures source profile"
+	}
+	if name == app.Profile {
+		return appa.CredentialsPath(), "MFA session, written by gredentures"
+	}
+	for org, config := range app.Orgs {
+		if config.ProfileName(org) == name {
+			if config.LinkedAccount != "" {
+				return appa.CredentialsPath(), fmt.Sprintf("role %s of org %s in %s, linked to account %s, written by gredentures",
+					config.RoleArn, org, config.PartitionName(), config.LinkedAccount)
+			}
+			return appa.CredentialsPath(), fmt.Sprintf("role %s of org %s, written by gredentures", config.RoleArn, org)
+		}
+	}
+	for recipe, config := range app.Recipes {
+		if config.ProfileName(recipe) == name {
+			return appa.CredentialsPath(), fmt.Sprintf("login recipe %s, written by gredentures", recipe)
+		}
+	}
+	return appa.CredentialsPath(), "not managed by gredentures"
+}
+
+// profileAccount returns the account of profile name with its alias, as far as the account
+// cache or the role of its org tell, "" when neither does.
+func profileAccount(app appc.AppConfig, name string) string {
+	cache := appa.LoadAccountCache(appa.AccountCachePath())
+	account := cache.ProfileAccount(name)
+	for org, config := range app.Orgs {
+		if account == "" && config.ProfileName(org) == name {
+			account = arnAccount(config.RoleArn)
+		}
+	}
+	return cache.DisplayAccount(account)
+}
+
+// describeExpiry says when the credentials of profile expire relative to now.
+func describeExpiry(profile appa.Profile, now time.Time) string {
+	switch {
+	case profile.Credentials.CanExpire && profile.Credentials.Expires.After(now):
+		left := profile.Credentials.Expires.Sub(now).Round(time.Minute)
+		return fmt.Sprintf("%s (in %s)", profile.Credentials.Expires.Format(time.RFC3339), strings.TrimSuffix(left.String(), "0s"))
+	case profile.Credentials.CanExpire:
+		ago := now.Sub(profile.Credentials.Expires).Round(time.Minute)
+		return console.Paint(ui.Red, fmt.Sprintf("%s (expired %s ago)", profile.Credentials.Expires.Format(time.RFC3339), strings.TrimSuffix(ago.String(), "0s")))
+	case profile.Credentials.SessionToken != "":
+		return "unknown, written without an expiry"
+	}
+	return "never, long-lived keys"
+}
+
+// confirmFull asks before the secrets of profile are printed in full.
+func confirmFull(app appc.AppConfig, profile string) bool {
+	if prompter(app) == nil {
+		console.Hintf("--full has to be confirmed, run it on a terminal or choose how to ask with --prompt.")
+		return false
+	}
+	return confirm(app, fmt.Sprintf("Print the full secret access key and session token of %s?", profile))
+}
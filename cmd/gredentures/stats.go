@@ -0,0 +1,76 @@
+package main
+
+import (
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+
+	appc "gredentures/pkg/appconfig"
+	"gredentures/pkg/interrupt"
+	"gredentures/pkg/stats"
+)
+
+// statsPath returns the configured stats file or the default one.
+func statsPath(app appc.AppConfig) string {
+	if app.Stats.File != "" {
+		return app.Stats.File
+	}
+	return stats.DefaultPath()
+}
+
+// recordUsage counts the current command when usage statistics are enabled. Failures are
+// only logged, statistics must never get in the way of obtaining credentials.
+func recordUsage(app appc.AppConfig) {
+	if !app.Stats.Enabled {
+		return
+	}
+
+	command := app.CommandName()
+	if err := stats.Record(statsPath(app), command); err != nil {
+		slog.Debug("Failed to record usage", "error", err)
+	}
+	if app.Stats.Endpoint != "" {
+		if err := stats.Report(interrupt.Context(), http.DefaultClient, app.Stats.Endpoint, command); err != nil {
+			slog.Debug("Failed to report usage", "error", err)
+		}
+	}
+}
+
+// runStatsCommand handles the "gredentures stats" subcommands and returns the exit code.
+func runStatsCommand(app appc.AppConfig) int {
+	if app.Aggregate {
+		counts, err := stats.Aggregate(app.Files)
+		if err != nil {
+			console.Errorf("Error aggregating stats: %v", err)
+			return 1
+		}
+		printCounts(counts)
+		return 0
+	}
+
+	if err := app.GetGredenturesConfig(); err != nil {
+		console.Errorf("Error getting gredentures config: %v", err)
+		return 1
+	}
+	if !app.Stats.Enabled {
+		console.Warnf("Usage statistics are disabled, set Stats.Enabled in the config file to record them")
+	}
+	counts, err := stats.Load(statsPath(app))
+	if err != nil {
+		console.Errorf("Error reading stats: %v", err)
+		return 1
+	}
+	printCounts(counts)
+	return 0
+}
+
+// printCounts prints the runs of each command as a table.
+func printCounts(counts stats.Counts) {
+	rows := make([][]string, 0, len(counts))
+	for _, command := range slices.Sorted(maps.Keys(counts)) {
+		rows = append(rows, []string{command, strconv.FormatInt(counts[command], 10)})
+	}
+	console.Table([]string{"COMMAND", "RUNS"}, rows)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"log/slog"
+	"time"
+
+	appc "gredentures/pkg/appconfig"
+	appa "gredentures/pkg/awsconfig"
+	"gredentures/pkg/ui"
+)
+
+// Exit codes of status --check, a documented contract for wrapper scripts: never renumber them.
+const (
+	checkExpiringSoon  = 10 // The credentials expire within the RefreshMargin of the profile.
+	checkExpired       = 20 // The credentials have expired.
+	checkNotConfigured = 30 // The profile holds no credentials.
+)
+
+// runStatus handles "gredentures status", listing every managed profile with its account and
+// how long its credentials stay valid, those expiring within their RefreshMargin in yellow, and
+// returns the exit code: 0 while the session profile holds credentials that have not expired.
+// Only the credentials file and the account cache are read, so it works without a network.
+// With --check only one profile is checked, see runStatusCheck.
+func runStatus(app appc.AppConfig) int {
+	if err := app.GetGredenturesConfig(); err != nil {
+		console.Errorf("Error getting gredentures config: %v", err)
+		return 1
+	}
+	if app.Check {
+		return runStatusCheck(app)
+	}
+
+	now, code := time.Now(), 0
+	cache := appa.LoadAccountCache(appa.AccountCachePath())
+	rows := [][]string{}
+	for _, entry := range rpcProfiles(app) {
+		account := cache.DisplayAccount(entry.Account)
+		if account == "" {
+			account = "-"
+		}
+		expires := console.Paint(ui.Red, "not logged in")
+		profile, err := appa.ReadProfile(appa.CredentialsPath(), entry.Name)
+		if err == nil {
+			expires = describeExpiry(profile, now)
+			if profile.Freshness(now, app.RefreshMargin(entry.Name)) == appa.FreshnessExpiringSoon {
+				expires = console.Paint(ui.Yellow, expires)
+			}
+		}
+		if entry.Kind == "session" && (err != nil || profile.Credentials.CanExpire && !profile.Credentials.Expires.After(now)) {
+			code = 1
+		}
+		rows = append(rows, []string{entry.Name, entry.Kind, account, expires})
+	}
+	console.Table([]string{"PROFILE", "KIND", "ACCOUNT", "EXPIRES"}, rows)
+	return code
+}
+
+// runStatusCheck handles "gredentures status --check", printing how fresh the credentials of
+// a profile, the session profile by default, are and exiting with 0 while they are valid,
+// checkExpiringSoon, checkExpired or checkNotConfigured, so scripts can gate AWS commands on them.
+// Long-lived keys and credentials of unknown expiry count as valid.
+func runStatusCheck(app appc.AppConfig) int {
+	name := cmp.Or(app.ProfileArg, app.Profile)
+	profile, err := appa.ReadProfile(appa.CredentialsPath(), name)
+	if err == nil && profile.Credentials.AccessKeyID == "" {
+		err = fmt.Errorf("profile %s holds no access key", name)
+	}
+	if err != nil {
+		slog.Debug("Profile not configured", "profile", name, "error", err)
+		console.Printf("%s: %s\n", name, console.Paint(ui.Red, "not logged in"))
+		return checkNotConfigured
+	}
+
+	now := time.Now()
+	switch profile.Freshness(now, app.RefreshMargin(name)) {
+	case appa.FreshnessExpiringSoon:
+		console.Printf("%s: %s\n", name, console.Paint(ui.Yellow, describeExpiry(profile, now)))
+		return checkExpiringSoon
+	case appa.FreshnessExpired:
+		console.Printf("%s: %s\n", name, describeExpiry(profile, now))
+		return checkExpired
+	}
+	console.Printf("%s: %s\n", name, describeExpiry(profile, now))
+	return 0
+}
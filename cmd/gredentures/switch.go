@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	appc "gredentures/pkg/appconfig"
+	appa "gredentures/pkg/awsconfig"
+)
+
+// runSwitch handles "gredentures switch", printing the exports that point future shells at a
+// profile gredentures manages, for eval, and returns the exit code. No credentials are
+// requested, the profile has to be logged in already. --pin and --unpin add the profile to the
+// favorites or remove it. With a CurrentProfile alias, the alias is pointed at the profile too.
+func runSwitch(app appc.AppConfig) int {
+	if err := app.GetGredenturesConfig(); err != nil {
+		console.Errorf("Error getting gredentures config: %v", err)
+		return 1
+	}
+
+	name := app.ProfileArg
+	if !slices.Contains(app.ManagedProfiles(), name) {
+		console.Errorf("Profile %s is not managed by gredentures, expected one of %s", name, strings.Join(app.ManagedProfiles(), ", "))
+		return 1
+	}
+	profile, err := appa.ReadProfile(appa.CredentialsPath(), name)
+	if err != nil {
+		console.Errorf("Error reading profile: %v", err)
+		console.Hintf("Log in first, switch only selects a profile written already.")
+		return 1
+	}
+	switch profile.Freshness(time.Now(), app.RefreshMargin(name)) {
+	case appa.FreshnessExpired:
+		console.Warnf("The credentials of profile %s expired at %s, log in again before using it", name, profile.Credentials.Expires.Local().Format(time.RFC3339))
+	case appa.FreshnessExpiringSoon:
+		console.Warnf("The credentials of profile %s expire at %s, log in again soon", name, profile.Credentials.Expires.Local().Format(time.RFC3339))
+	}
+
+	if app.Pin || app.Unpin {
+		if err := app.SaveFavorite(name, app.Pin); err != nil {
+			console.Errorf("Error saving favorites: %v", err)
+			return 1
+		}
+	}
+
+	if app.CurrentProfile != "" && name != app.CurrentProfile && !app.NoWrite {
+		current := profile
+		current.Name = app.CurrentProfile
+		writer := &appa.SharedCredentialsWriter{Path: appa.CredentialsPath(), Merge: true}
+		if err := writer.WriteCredentials(appa.CredentialSet{Session: current}); err != nil {
+			console.Errorf("Error writing profile %s: %v", app.CurrentProfile, err)
+			return 1
+		}
+		console.Notef("Profile %s now mirrors %s.", app.CurrentProfile, name)
+	}
+
+	console.Printf("%s", appa.SwitchExports(name, os.Environ()))
+	console.Notef("Switched to profile %s, run as eval \"$(gredentures switch %s)\" to apply it to this shell.", name, name)
+	return 0
+}
+
+// pinnedFirst moves the profiles named in favorites to the front of profiles, in the order of
+// favorites, and marks them pinned. The others keep their order.
+func pinnedFirst(profiles []rpcProfile, favorites []string) []rpcProfile {
+	rank := func(p rpcProfile) int {
+		if i := slices.Index(favorites, p.Name); i >= 0 {
+			return i
+		}
+		return len(favorites)
+	}
+	for i := range profiles {
+		profiles[i].Pinned = slices.Contains(favorites, profiles[i].Name)
+	}
+	slices.SortStableFunc(profiles, func(a, b rpcProfile) int { return rank(a) - rank(b) })
+	return profiles
+}
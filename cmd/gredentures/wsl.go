@@ -0,0 +1,40 @@
+package main
+
+import (
+	appc "gredentures/pkg/appconfig"
+	"gredentures/pkg/audit"
+	appa "gredentures/pkg/awsconfig"
+)
+
+// runWSLSync handles "gredentures wsl-sync", copying the managed profiles between the WSL and
+// Windows credentials files without calling STS, and returns the exit code.
+func runWSLSync(app appc.AppConfig) int {
+	if err := app.GetGredenturesConfig(); err != nil {
+		console.Errorf("Error getting gredentures config: %v", err)
+		return 1
+	}
+
+	windowsPath, err := appa.WindowsCredentialsPath()
+	if err != nil {
+		console.Errorf("Error locating Windows credentials file: %v", err)
+		return 1
+	}
+
+	from, to := appa.CredentialsPath(), windowsPath
+	if app.Pull {
+		from, to = to, from
+	}
+
+	copied, err := appa.SyncProfiles(from, to, app.ManagedProfiles())
+	if err != nil {
+		console.Errorf("Error syncing profiles: %v", err)
+		return 1
+	}
+	if len(copied) == 0 {
+		console.Warnf("No managed profiles found in %s", from)
+		return 0
+	}
+	recordAudit(app, audit.Event{Action: audit.ActionWrite, Path: to, Profiles: copied})
+	console.Successf("Synced %v from %s to %s", copied, from, to)
+	return 0
+}
@@ -0,0 +1,250 @@
+// Package e2e runs the gredentures binary end to end: it is built once, pointed at a mock STS
+// server through AWS_ENDPOINT_URL and run with a temporary HOME, and the tests assert the
+// credentials files it leaves behind. Setting GREDENTURES_E2E_ENDPOINT, e.g. to the
+// http://localhost:4566 of localstack, runs the tests against that endpoint instead of the
+// embedded server. The tests are skipped with -short.
+package e2e
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/ini.v1"
+)
+
+// Long-lived keys of the source profile and the MFA device every test logs in with.
+const (
+	sourceKeyID  = "AKIAE2ESOURCE"
+	sourceSecret = "e2eSourceSecret"
+	device       = "arn:aws:iam::123456789012:mfa/alice"
+)
+
+// binary is the gredentures binary built by TestMain.
+var binary string
+
+// watched is done once newEnv stat'ed the sources, see watchSources.
+var watched sync.Once
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if testing.Short() {
+		os.Exit(m.Run()) // Every test skips itself
+	}
+
+	dir, err := os.MkdirTemp("", "gredentures-e2e-")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create build directory: %v\n", err)
+		os.Exit(1)
+	}
+	binary = filepath.Join(dir, "gredentures")
+	build := exec.Command("go", "build", "-o", binary, "./cmd/gredentures")
+	build.Dir = ".."
+	if out, err := build.CombinedOutput(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to build gredentures: %v\n%s", err, out)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// watchSources stats the Go sources and module files below root. The test binary never reads
+// the files gredentures is built from, so this is what makes go test rerun the tests instead of
+// reporting a cached result after they change. Only files stat'ed while tests run count.
+func watchSources(root string) {
+	_ = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() && (strings.HasSuffix(path, ".go") || entry.Name() == "go.mod" || entry.Name() == "go.sum") {
+			_, _ = os.Stat(path)
+		}
+		return nil
+	})
+}
+
+// stsRequest is a request received by mockSTS.
+type stsRequest struct {
+	Action string
+	Form   map[string]string
+	KeyID  string // Access key ID the request was signed with.
+}
+
+// mockSTS answers the STS query API the way AWS does, issuing predictable credentials, and
+// records the requests it receives. Actions it does not know, such as the IAM lookups
+// gredentures makes in passing, are refused with AccessDenied.
+type mockSTS struct {
+	mu       sync.Mutex
+	requests []stsRequest
+	refuse   map[string]string // Error code returned for a token code.
+}
+
+func (s *mockSTS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	request := stsRequest{Action: r.PostForm.Get("Action"), Form: map[string]string{}, KeyID: signingKeyID(r)}
+	for key := range r.PostForm {
+		request.Form[key] = r.PostForm.Get(key)
+	}
+	s.mu.Lock()
+	s.requests = append(s.requests, request)
+	s.mu.Unlock()
+
+	expires := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	switch {
+	case request.Action == "GetSessionToken" && s.refuse[request.Form["TokenCode"]] != "":
+		writeError(w, s.refuse[request.Form["TokenCode"]], "MultiFactorAuthentication failed with invalid MFA one time pass code.")
+	case request.Action == "GetSessionToken":
+		writeResult(w, request.Action, fmt.Sprintf(`<Credentials><AccessKeyId>ASIAE2ESESSION</AccessKeyId>
+<SecretAccessKey>e2eSessionSecret</SecretAccessKey><SessionToken>e2eSessionToken</SessionToken>
+<Expiration>%s</Expiration></Credentials>`, expires))
+	case request.Action == "AssumeRole":
+		// Keys are named after the account of the role, e.g. ASIA111111111111
+		role := request.Form["RoleArn"]
+		account := strings.Split(role, ":")[4]
+		assumed := strings.Replace(strings.Replace(role, ":iam:", ":sts:", 1), ":role/", ":assumed-role/", 1)
+		writeResult(w, request.Action, fmt.Sprintf(`<Credentials><AccessKeyId>ASIA%s</AccessKeyId>
+<SecretAccessKey>secret-%s</SecretAccessKey><SessionToken>token-%s</SessionToken><Expiration>%s</Expiration></Credentials>
+<AssumedRoleUser><Arn>%s/%s</Arn><AssumedRoleId>AROAE2E:%s</AssumedRoleId></AssumedRoleUser>`,
+			account, account, account, expires, assumed, request.Form["RoleSessionName"], request.Form["RoleSessionName"]))
+	case request.Action == "GetCallerIdentity":
+		writeResult(w, request.Action, `<Arn>arn:aws:iam::123456789012:user/alice</Arn><UserId>AIDAE2E</UserId><Account>123456789012</Account>`)
+	default:
+		writeError(w, "AccessDenied", "not served by the e2e mock")
+	}
+}
+
+// Requests returns the requests with the given action received so far.
+func (s *mockSTS) Requests(action string) []stsRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var matched []stsRequest
+	for _, request := range s.requests {
+		if request.Action == action {
+			matched = append(matched, request)
+		}
+	}
+	return matched
+}
+
+// signingKeyID returns the access key ID of the SigV4 Authorization header of r.
+func signingKeyID(r *http.Request) string {
+	_, credential, _ := strings.Cut(r.Header.Get("Authorization"), "Credential=")
+	keyID, _, _ := strings.Cut(credential, "/")
+	return keyID
+}
+
+// writeResult writes the XML response of a successful STS action.
+func writeResult(w http.ResponseWriter, action, result string) {
+	w.Header().Set("Content-Type", "text/xml")
+	fmt.Fprintf(w, `<%sResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><%sResult>%s</%sResult>
+<ResponseMetadata><RequestId>e2e</RequestId></ResponseMetadata></%sResponse>`, action, action, result, action, action)
+}
+
+// writeError writes the XML response of a refused STS action.
+func writeError(w http.ResponseWriter, code, message string) {
+	var escaped bytes.Buffer
+	_ = xml.EscapeText(&escaped, []byte(message))
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(http.StatusForbidden)
+	fmt.Fprintf(w, `<ErrorResponse><Error><Type>Sender</Type><Code>%s</Code><Message>%s</Message></Error>
+<RequestId>e2e</RequestId></ErrorResponse>`, code, escaped.String())
+}
+
+// env is an isolated home directory gredentures runs in, with the source profile in its
+// credentials file.
+type env struct {
+	t        *testing.T
+	home     string
+	endpoint string
+	sts      *mockSTS // nil when running against GREDENTURES_E2E_ENDPOINT.
+	vars     []string // Extra environment variables of every run.
+	stdin    string   // Piped to every run.
+}
+
+// newEnv creates a temporary HOME holding the long-lived keys of the default profile and,
+// when config is not empty, a gredentures config file, and starts the mock STS server.
+func newEnv(t *testing.T, config string) *env {
+	if testing.Short() {
+		t.Skip("end-to-end tests are skipped with -short")
+	}
+	watched.Do(func() { watchSources("..") })
+	e := &env{t: t, home: t.TempDir(), endpoint: os.Getenv("GREDENTURES_E2E_ENDPOINT")}
+	if e.endpoint == "" {
+		e.sts = &mockSTS{refuse: map[string]string{"000000": "AccessDenied"}}
+		server := httptest.NewServer(e.sts)
+		t.Cleanup(server.Close)
+		e.endpoint = server.URL
+	}
+
+	e.write(".aws/credentials", fmt.Sprintf("[default]\naws_access_key_id = %s\naws_secret_access_key = %s\n", sourceKeyID, sourceSecret))
+	if config != "" {
+		e.write(".config/gredentures/config.yml", config)
+	}
+	return e
+}
+
+// write writes a file below the home directory.
+func (e *env) write(name, content string) {
+	path := filepath.Join(e.home, name)
+	require.NoError(e.t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(e.t, os.WriteFile(path, []byte(content), 0o600))
+}
+
+// run runs gredentures with args and returns its exit code and output. Only the variables
+// gredentures needs are passed, so the tests never see the credentials of whoever runs them.
+func (e *env) run(args ...string) (int, string, string) {
+	cmd := exec.Command(binary, args...)
+	cmd.Dir = e.home
+	cmd.Env = []string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + e.home,
+		"XDG_CONFIG_HOME=" + filepath.Join(e.home, ".config"),
+		"XDG_CACHE_HOME=" + filepath.Join(e.home, ".cache"),
+		"XDG_STATE_HOME=" + filepath.Join(e.home, ".local/state"),
+		"GREDENTURES_SYSTEM_CONFIG=" + filepath.Join(e.home, "no-system-config.yml"),
+		"AWS_ENDPOINT_URL=" + e.endpoint,
+		"AWS_EC2_METADATA_DISABLED=true",
+	}
+	cmd.Env = append(cmd.Env, e.vars...)
+	cmd.Stdin = strings.NewReader(e.stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	if exit, ok := err.(*exec.ExitError); ok {
+		return exit.ExitCode(), stdout.String(), stderr.String()
+	}
+	require.NoError(e.t, err)
+	return 0, stdout.String(), stderr.String()
+}
+
+// credentials returns the key pair and session token of profile in ~/.aws/credentials.
+func (e *env) credentials(profile string) (string, string, string) {
+	file, err := ini.Load(filepath.Join(e.home, ".aws/credentials"))
+	require.NoError(e.t, err)
+	section, err := file.GetSection(profile)
+	if !assert.NoError(e.t, err, "profile %s is written", profile) {
+		return "", "", ""
+	}
+	return section.Key("aws_access_key_id").String(), section.Key("aws_secret_access_key").String(), section.Key("aws_session_token").String()
+}
+
+// embedded reports whether the tests run against the embedded mock, whose requests and
+// issued credentials can be asserted exactly.
+func (e *env) embedded() bool {
+	return e.sts != nil
+}
@@ -0,0 +1,155 @@
+package e2e
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogin(t *testing.T) {
+	e := newEnv(t, "")
+
+	code, stdout, stderr := e.run("-t", "123456", "-d", device, "-o", "e2e")
+	assert.Equal(t, 0, code, stderr)
+	assert.Contains(t, stdout, "default-mfa")
+	assert.Contains(t, stderr, "--token given as an argument")
+
+	keyID, secret, token := e.credentials("default-mfa")
+	assert.True(t, strings.HasPrefix(keyID, "ASIA"), "a session key is written, not %q", keyID)
+	assert.NotEmpty(t, secret)
+	assert.NotEmpty(t, token)
+	if e.embedded() {
+		assert.Equal(t, []string{"ASIAE2ESESSION", "e2eSessionSecret", "e2eSessionToken"}, []string{keyID, secret, token})
+
+		requests := e.sts.Requests("GetSessionToken")
+		if assert.Len(t, requests, 1) {
+			assert.Equal(t, sourceKeyID, requests[0].KeyID, "the session is requested with the long-lived keys")
+			assert.Equal(t, device, requests[0].Form["SerialNumber"])
+			assert.Equal(t, "123456", requests[0].Form["TokenCode"])
+			assert.Equal(t, "86400", requests[0].Form["DurationSeconds"])
+		}
+	}
+
+	keyID, secret, _ = e.credentials("default")
+	assert.Equal(t, sourceKeyID, keyID, "the long-lived keys are kept")
+	assert.Equal(t, sourceSecret, secret)
+}
+
+func TestLoginTokenFromStdin(t *testing.T) {
+	e := newEnv(t, "")
+	e.stdin = "654321\n"
+
+	code, _, stderr := e.run("login", "--token", "-", "-d", device, "-o", "e2e")
+	assert.Equal(t, 0, code, stderr)
+	assert.NotContains(t, stderr, "given as an argument", "a piped token is not in the arguments")
+	keyID, _, _ := e.credentials("default-mfa")
+	assert.True(t, strings.HasPrefix(keyID, "ASIA"), "a session key is written, not %q", keyID)
+	if e.embedded() {
+		requests := e.sts.Requests("GetSessionToken")
+		if assert.Len(t, requests, 1) {
+			assert.Equal(t, "654321", requests[0].Form["TokenCode"], "the piped token is used")
+		}
+	}
+
+	e.stdin = ""
+	code, _, stderr = e.run("login", "--token", "-", "-d", device, "-o", "e2e")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, "nothing was piped to stdin")
+}
+
+func TestLoginFromConfig(t *testing.T) {
+	e := newEnv(t, `gredentures:
+  Org: e2e
+  Device: `+device+`
+  Timeout: 1h
+  Orgs:
+    prod:
+      RoleArn: arn:aws:iam::111111111111:role/Admin
+    staging:
+      RoleArn: arn:aws:iam::222222222222:role/Admin
+      Profile: staging-admin
+`)
+
+	code, _, stderr := e.run("login", "--all", "-t", "123456")
+	assert.Equal(t, 0, code, stderr)
+
+	session, _, _ := e.credentials("default-mfa")
+	prod, _, _ := e.credentials("prod-mfa")
+	staging, _, _ := e.credentials("staging-admin")
+	for _, keyID := range []string{session, prod, staging} {
+		assert.True(t, strings.HasPrefix(keyID, "ASIA"), "a session key is written, not %q", keyID)
+	}
+	if !e.embedded() {
+		return
+	}
+	assert.Equal(t, "ASIA111111111111", prod)
+	assert.Equal(t, "ASIA222222222222", staging)
+
+	sessions := e.sts.Requests("GetSessionToken")
+	if assert.Len(t, sessions, 1) {
+		assert.Equal(t, "3600", sessions[0].Form["DurationSeconds"], "the timeout of the config file applies")
+	}
+	roles := e.sts.Requests("AssumeRole")
+	assert.Len(t, roles, 2)
+	for _, role := range roles {
+		assert.Equal(t, session, role.KeyID, "roles are assumed with the MFA session")
+	}
+}
+
+func TestLoginFromKeyStore(t *testing.T) {
+	e := newEnv(t, `gredentures:
+  Org: e2e
+  Device: `+device+`
+  KeyStore:
+    Backend: file
+`)
+	e.vars = append(e.vars, "GREDENTURES_KEYSTORE_PASSPHRASE=e2e passphrase")
+
+	code, stdout, stderr := e.run("keys", "import")
+	assert.Equal(t, 0, code, stderr)
+	assert.Contains(t, stdout, "Moved the long-lived keys")
+	data, err := os.ReadFile(filepath.Join(e.home, ".aws/credentials"))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), sourceSecret, "the long-lived keys leave the credentials file")
+	stored, err := os.ReadFile(filepath.Join(e.home, ".local/share/gredentures/keys.enc"))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(stored), sourceSecret, "the key file is encrypted")
+
+	code, _, stderr = e.run("-t", "123456")
+	assert.Equal(t, 0, code, stderr)
+	keyID, _, _ := e.credentials("default-mfa")
+	assert.True(t, strings.HasPrefix(keyID, "ASIA"), "a session key is written, not %q", keyID)
+	data, err = os.ReadFile(filepath.Join(e.home, ".aws/credentials"))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), sourceKeyID, "only the session is written")
+	if e.embedded() {
+		requests := e.sts.Requests("GetSessionToken")
+		if assert.Len(t, requests, 1) {
+			assert.Equal(t, sourceKeyID, requests[0].KeyID, "the session is requested with the stored keys")
+		}
+	}
+
+	code, stdout, _ = e.run("keys", "list")
+	assert.Equal(t, 0, code)
+	assert.Contains(t, stdout, "e2e\n")
+}
+
+func TestLoginRefused(t *testing.T) {
+	e := newEnv(t, "")
+	if !e.embedded() {
+		t.Skip("only the embedded mock refuses MFA codes")
+	}
+	before, err := os.ReadFile(filepath.Join(e.home, ".aws/credentials"))
+	assert.NoError(t, err)
+
+	code, _, stderr := e.run("-t", "000000", "-d", device, "-o", "e2e")
+	assert.NotEqual(t, 0, code)
+	assert.Contains(t, stderr, "AccessDenied")
+
+	after, err := os.ReadFile(filepath.Join(e.home, ".aws/credentials"))
+	assert.NoError(t, err)
+	assert.Equal(t, string(before), string(after), "nothing is written when STS refuses the code")
+}
@@ -0,0 +1,308 @@
+// Package agent vends the current session credentials to other local processes over a Unix
+// socket, so tools that refresh frequently never have to read or watch the credentials file.
+//
+// The protocol is one JSON request per connection, e.g. {"profile": "prod-mfa"}, answered with
+// one JSON response in the credential_process format, or {"Error": "..."} on failure. An empty
+// profile selects the session credentials. Only peers allowed by the Allowlist are served.
+package agent
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"gredentures/pkg/awsconfig"
+	"gredentures/pkg/sysuser"
+)
+
+// expiryWindow is how long before expiry held credentials are treated as expired, so
+// clients never receive credentials that lapse mid-request.
+const expiryWindow = time.Minute
+
+// requestTimeout bounds how long a client may take to send its request.
+const requestTimeout = 5 * time.Second
+
+// DefaultPath returns the socket used when none is configured.
+func DefaultPath() string {
+	if dir := sysuser.StateDir(); dir != "" {
+		return filepath.Join(dir, "agent.sock")
+	}
+	return filepath.Join(sysuser.Home(), ".gredentures", "agent.sock")
+}
+
+// Request asks the agent for the credentials of a profile.
+type Request struct {
+	Profile string `json:"profile"` // Profile to return, the session profile when empty.
+}
+
+// Response carries credentials in the credential_process format, or an error.
+type Response struct {
+	Version         int    `json:"Version,omitempty"`
+	AccessKeyID     string `json:"AccessKeyId,omitempty"`
+	SecretAccessKey string `json:"SecretAccessKey,omitempty"`
+	SessionToken    string `json:"SessionToken,omitempty"`
+	Expiration      string `json:"Expiration,omitempty"`
+	Error           string `json:"Error,omitempty"`
+}
+
+// Peer identifies the process on the other end of a connection.
+type Peer struct {
+	UID    int    // User ID of the client process.
+	PID    int    // Process ID of the client.
+	Binary string // Path of the client's executable, empty if it could not be determined.
+}
+
+// Allowlist restricts which local processes may request credentials.
+type Allowlist struct {
+	UIDs     []int    // User IDs allowed to connect besides the agent's own user.
+	Binaries []string // Executables allowed to connect, any executable when empty.
+}
+
+// check returns an error describing why peer is not allowed, or nil.
+func (a Allowlist) check(peer Peer) error {
+	if peer.UID != os.Getuid() && !slices.Contains(a.UIDs, peer.UID) {
+		return fmt.Errorf("uid %d is not allowed", peer.UID)
+	}
+	if len(a.Binaries) > 0 && !slices.Contains(a.Binaries, peer.Binary) {
+		return fmt.Errorf("binary %q is not allowed", peer.Binary)
+	}
+	return nil
+}
+
+// otherUsers reports whether the Allowlist admits users besides the agent's own.
+func (a Allowlist) otherUsers() bool {
+	return slices.ContainsFunc(a.UIDs, func(uid int) bool { return uid != os.Getuid() })
+}
+
+// socketMode returns the permissions of the socket and, when newly created, of its directory.
+// Both are the owner's only, unless other users are allowed, who then have to be able to
+// connect at all. The peer credentials checked by the Allowlist are what keeps everyone else out.
+func (a Allowlist) socketMode() (socket, dir os.FileMode) {
+	if a.otherUsers() {
+		return 0o666, 0o711
+	}
+	return 0o600, 0o700
+}
+
+// Server serves credentials on a Unix socket. It implements awsconfig.CredentialWriter, so the
+// credentials it serves are handed to it like to any other writer.
+type Server struct {
+	Path    string                   // Socket path.
+	Allow   Allowlist                // Processes allowed to request credentials.
+	Refresh func() error             // Obtains new credentials once the held ones expire within their margin, nil to never refresh.
+	Margin  time.Duration            // How long before expiry Refresh renews the held credentials, expiryWindow when shorter.
+	Margins map[string]time.Duration // Margin of single profiles, overriding Margin.
+
+	mu        sync.Mutex              // Guards set.
+	set       awsconfig.CredentialSet // Credentials served, without the long-lived source keys.
+	issued    time.Time               // When set was written, the lifetime of its credentials starts.
+	refreshMu sync.Mutex              // Serializes calls to Refresh.
+	peer      func(net.Conn) (Peer, error)
+	now       func() time.Time
+}
+
+// WriteCredentials implements awsconfig.CredentialWriter. The long-lived source keys are
+// dropped, the agent only ever vends session and role credentials.
+func (s *Server) WriteCredentials(set awsconfig.CredentialSet) error {
+	set.Source = nil
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.set = set
+	s.issued = s.clock()
+	return nil
+}
+
+// ListenAndServe listens on the socket path, with the permissions of Allowlist.socketMode, and
+// serves until ctx is cancelled. A socket left behind by an earlier agent is replaced.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	socketMode, dirMode := s.Allow.socketMode()
+	if err := sysuser.MkdirAll(filepath.Dir(s.Path), dirMode); err != nil {
+		return fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	if err := os.Remove(s.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+
+	listener, err := net.Listen("unix", s.Path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.Path, err)
+	}
+	defer os.Remove(s.Path)
+	if err := os.Chmod(s.Path, socketMode); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+	if info, err := os.Stat(filepath.Dir(s.Path)); err == nil && s.Allow.otherUsers() && info.Mode().Perm()&0o001 == 0 {
+		slog.Warn("The allowed users cannot reach the agent socket, its directory is not searchable by them", "dir", filepath.Dir(s.Path))
+	}
+
+	return s.Serve(ctx, listener)
+}
+
+// Serve accepts connections on listener until ctx is cancelled, handling each concurrently.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	slog.Info("Agent listening", "socket", listener.Addr().String())
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.handle(conn)
+		}()
+	}
+}
+
+// handle answers a single request and closes the connection.
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	respond := func(response Response) {
+		if err := json.NewEncoder(conn).Encode(response); err != nil {
+			slog.Debug("Failed to write agent response", "error", err)
+		}
+	}
+
+	peerFunc := s.peer
+	if peerFunc == nil {
+		peerFunc = peerCredentials
+	}
+	peer, err := peerFunc(conn)
+	if err != nil {
+		slog.Warn("Rejected agent client", "error", err)
+		respond(Response{Error: "could not identify client"})
+		return
+	}
+	if err := s.Allow.check(peer); err != nil {
+		slog.Warn("Rejected agent client", "uid", peer.UID, "pid", peer.PID, "binary", peer.Binary, "reason", err)
+		respond(Response{Error: "client not allowed"})
+		return
+	}
+
+	var request Request
+	conn.SetReadDeadline(time.Now().Add(requestTimeout))
+	if err := json.NewDecoder(conn).Decode(&request); err != nil {
+		respond(Response{Error: "invalid request"})
+		return
+	}
+
+	profile, err := s.lookup(request.Profile)
+	if err != nil {
+		respond(Response{Error: err.Error()})
+		return
+	}
+	slog.Info("Served credentials", "profile", profile.Name, "uid", peer.UID, "pid", peer.PID, "binary", peer.Binary)
+	response := Response{
+		Version:         1,
+		AccessKeyID:     profile.Credentials.AccessKeyID,
+		SecretAccessKey: profile.Credentials.SecretAccessKey,
+		SessionToken:    profile.Credentials.SessionToken,
+	}
+	if profile.Credentials.CanExpire {
+		response.Expiration = profile.Credentials.Expires.Format(time.RFC3339)
+	}
+	respond(response)
+}
+
+// lookup returns the credentials of a profile, refreshing them first when they expire within
+// their margin. Credentials that failed to refresh are served until they expire.
+func (s *Server) lookup(name string) (awsconfig.Profile, error) {
+	profile, ok := s.find(name)
+	if !ok {
+		return awsconfig.Profile{}, fmt.Errorf("unknown profile %q", name)
+	}
+	if !s.due(profile) {
+		return profile, nil
+	}
+	if s.Refresh == nil {
+		return awsconfig.Profile{}, fmt.Errorf("credentials for %q have expired, restart the agent to log in again", profile.Name)
+	}
+
+	// Only one client triggers a refresh, the others wait for and reuse its result
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+	if profile, ok = s.find(name); ok && !s.due(profile) {
+		return profile, nil
+	}
+	slog.Info("Refreshing expiring credentials", "profile", profile.Name)
+	if err := s.Refresh(); err != nil {
+		if ok && !s.expired(profile) {
+			slog.Warn("Serving the held credentials until they expire, refreshing them failed", "profile", profile.Name, "error", err)
+			return profile, nil
+		}
+		return awsconfig.Profile{}, fmt.Errorf("failed to refresh credentials: %w", err)
+	}
+	if profile, ok = s.find(name); !ok || s.expired(profile) {
+		return awsconfig.Profile{}, fmt.Errorf("no current credentials for %q after refreshing", name)
+	}
+	return profile, nil
+}
+
+// find returns the held credentials of a profile.
+func (s *Server) find(name string) (awsconfig.Profile, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if name == "" || name == s.set.Session.Name {
+		return s.set.Session, s.set.Session.Name != ""
+	}
+	for _, role := range s.set.Roles {
+		if role.Name == name {
+			return role, true
+		}
+	}
+	return awsconfig.Profile{}, false
+}
+
+// expired reports whether a profile's credentials expire within expiryWindow.
+func (s *Server) expired(profile awsconfig.Profile) bool {
+	return s.expiresWithin(profile, expiryWindow)
+}
+
+// due reports whether a profile's credentials are to be refreshed: once they expire within the
+// margin of the profile when Refresh can renew them, once they expire otherwise. The margin is
+// capped to half the lifetime of the credentials, so short sessions are not refreshed again on
+// every lookup.
+func (s *Server) due(profile awsconfig.Profile) bool {
+	if s.Refresh == nil {
+		return s.expired(profile)
+	}
+	s.mu.Lock()
+	lifetime := profile.Credentials.Expires.Sub(s.issued)
+	s.mu.Unlock()
+	margin := awsconfig.RenewMargin(cmp.Or(s.Margins[profile.Name], s.Margin), lifetime)
+	return s.expiresWithin(profile, max(margin, expiryWindow))
+}
+
+// expiresWithin reports whether a profile's credentials expire within window.
+func (s *Server) expiresWithin(profile awsconfig.Profile, window time.Duration) bool {
+	return profile.Credentials.CanExpire && s.clock().Add(window).After(profile.Credentials.Expires)
+}
+
+// clock returns the current time, that of now when set by tests.
+func (s *Server) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
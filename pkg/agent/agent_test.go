@@ -0,0 +1,240 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"gredentures/pkg/awsconfig"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+)
+
+var expires = time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func testSet() awsconfig.CredentialSet {
+	return awsconfig.CredentialSet{
+		Source:  &awsconfig.Profile{Name: "default", Credentials: aws.Credentials{AccessKeyID: "longLived"}},
+		Session: awsconfig.Profile{Name: "default-mfa", Credentials: aws.Credentials{AccessKeyID: "sessionKey", SecretAccessKey: "sessionSecret", SessionToken: "sessionToken", CanExpire: true, Expires: expires}},
+		Roles:   []awsconfig.Profile{{Name: "prod-mfa", Credentials: aws.Credentials{AccessKeyID: "roleKey"}}},
+	}
+}
+
+// startServer serves s on a socket in a temporary directory until the test ends.
+func startServer(t *testing.T, s *Server) {
+	s.Path = filepath.Join(t.TempDir(), "agent.sock")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.ListenAndServe(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		assert.NoError(t, <-done)
+	})
+
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(s.Path)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+// request sends a single request to the agent at path.
+func request(t *testing.T, path, profile string) Response {
+	conn, err := net.Dial("unix", path)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	// Rejected clients are answered without reading the request, so writing it may fail
+	json.NewEncoder(conn).Encode(Request{Profile: profile})
+	var response Response
+	assert.NoError(t, json.NewDecoder(conn).Decode(&response))
+	return response
+}
+
+func TestServer(t *testing.T) {
+	s := &Server{peer: func(net.Conn) (Peer, error) { return Peer{UID: os.Getuid(), Binary: "/usr/bin/aws"}, nil }}
+	assert.NoError(t, s.WriteCredentials(testSet()))
+	startServer(t, s)
+
+	info, err := os.Stat(s.Path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	assert.Equal(t, Response{
+		Version:         1,
+		AccessKeyID:     "sessionKey",
+		SecretAccessKey: "sessionSecret",
+		SessionToken:    "sessionToken",
+		Expiration:      "2030-01-02T03:04:05Z",
+	}, request(t, s.Path, ""))
+	assert.Equal(t, "sessionKey", request(t, s.Path, "default-mfa").AccessKeyID)
+	assert.Equal(t, "roleKey", request(t, s.Path, "prod-mfa").AccessKeyID)
+	assert.Equal(t, `unknown profile "default"`, request(t, s.Path, "default").Error, "source keys are never served")
+}
+
+func TestServerRejectsClients(t *testing.T) {
+	tests := []struct {
+		name string
+		peer func(net.Conn) (Peer, error)
+	}{
+		{"Other user", func(net.Conn) (Peer, error) { return Peer{UID: os.Getuid() + 1}, nil }},
+		{"Other binary", func(net.Conn) (Peer, error) { return Peer{UID: os.Getuid(), Binary: "/tmp/evil"}, nil }},
+		{"Unknown peer", func(net.Conn) (Peer, error) { return Peer{}, fmt.Errorf("unsupported") }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{Allow: Allowlist{Binaries: []string{"/usr/bin/aws"}}, peer: tt.peer}
+			assert.NoError(t, s.WriteCredentials(testSet()))
+			startServer(t, s)
+
+			response := request(t, s.Path, "")
+			assert.NotEmpty(t, response.Error)
+			assert.Empty(t, response.AccessKeyID)
+		})
+	}
+}
+
+func TestAllowlist(t *testing.T) {
+	uid := os.Getuid()
+	assert.NoError(t, Allowlist{}.check(Peer{UID: uid}))
+	assert.Error(t, Allowlist{}.check(Peer{UID: uid + 1}))
+	assert.NoError(t, Allowlist{UIDs: []int{uid + 1}}.check(Peer{UID: uid + 1}))
+	assert.NoError(t, Allowlist{UIDs: []int{uid + 1}}.check(Peer{UID: uid}), "the agent's own user is always allowed")
+	assert.Error(t, Allowlist{UIDs: []int{uid + 1}}.check(Peer{UID: uid + 2}))
+	assert.NoError(t, Allowlist{Binaries: []string{"/usr/bin/aws"}}.check(Peer{UID: uid, Binary: "/usr/bin/aws"}))
+	assert.ErrorContains(t, Allowlist{Binaries: []string{"/usr/bin/aws"}}.check(Peer{UID: uid, Binary: "/bin/sh"}), `"/bin/sh"`)
+}
+
+func TestSocketMode(t *testing.T) {
+	uid := os.Getuid()
+	s := &Server{Allow: Allowlist{UIDs: []int{uid + 1}}}
+	startServer(t, s)
+
+	info, err := os.Stat(s.Path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o666), info.Mode().Perm(), "the allowed users can connect, the peer check keeps out the rest")
+
+	socket, dir := Allowlist{UIDs: []int{uid}}.socketMode()
+	assert.Equal(t, os.FileMode(0o600), socket, "listing the own user allows nobody else")
+	assert.Equal(t, os.FileMode(0o700), dir)
+}
+
+func TestLookupRefresh(t *testing.T) {
+	now := expires // The held session credentials are expired
+	refreshed := testSet()
+	refreshed.Session.Credentials.AccessKeyID = "newSessionKey"
+	refreshed.Session.Credentials.Expires = expires.Add(time.Hour)
+
+	t.Run("Refreshes expired credentials", func(t *testing.T) {
+		s := &Server{now: func() time.Time { return now }}
+		calls := 0
+		s.Refresh = func() error {
+			calls++
+			return s.WriteCredentials(refreshed)
+		}
+		assert.NoError(t, s.WriteCredentials(testSet()))
+
+		profile, err := s.lookup("")
+		assert.NoError(t, err)
+		assert.Equal(t, "newSessionKey", profile.Credentials.AccessKeyID)
+		_, err = s.lookup("")
+		assert.NoError(t, err)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("Does not refresh credentials that are still valid", func(t *testing.T) {
+		s := &Server{now: func() time.Time { return expires.Add(-time.Hour) }, Refresh: func() error { panic("unexpected refresh") }}
+		assert.NoError(t, s.WriteCredentials(testSet()))
+
+		profile, err := s.lookup("")
+		assert.NoError(t, err)
+		assert.Equal(t, "sessionKey", profile.Credentials.AccessKeyID)
+	})
+
+	t.Run("Refreshes credentials within the margin", func(t *testing.T) {
+		s := &Server{now: func() time.Time { return expires.Add(-time.Hour) }, Margin: 15 * time.Minute}
+		s.Refresh = func() error { return s.WriteCredentials(refreshed) }
+		assert.NoError(t, s.WriteCredentials(testSet()))
+
+		s.now = func() time.Time { return expires.Add(-10 * time.Minute) }
+		profile, err := s.lookup("")
+		assert.NoError(t, err)
+		assert.Equal(t, "newSessionKey", profile.Credentials.AccessKeyID)
+	})
+
+	t.Run("Caps the margin to half of a short session", func(t *testing.T) {
+		issued := expires.Add(-15 * time.Minute)
+		s := &Server{now: func() time.Time { return issued }, Margin: 15 * time.Minute, Refresh: func() error { panic("unexpected refresh") }}
+		assert.NoError(t, s.WriteCredentials(testSet()))
+		profile, _ := s.find("")
+		assert.False(t, s.due(profile), "fresh credentials are not refreshed right away")
+
+		s.now = func() time.Time { return issued.Add(7 * time.Minute) }
+		assert.False(t, s.due(profile))
+		s.now = func() time.Time { return issued.Add(8 * time.Minute) }
+		assert.True(t, s.due(profile))
+	})
+
+	t.Run("Margin of a profile", func(t *testing.T) {
+		s := &Server{now: func() time.Time { return expires.Add(-10 * time.Minute) }, Margin: 15 * time.Minute,
+			Margins: map[string]time.Duration{"default-mfa": 5 * time.Minute}, Refresh: func() error { panic("unexpected refresh") }}
+		assert.NoError(t, s.WriteCredentials(testSet()))
+
+		profile, err := s.lookup("")
+		assert.NoError(t, err)
+		assert.Equal(t, "sessionKey", profile.Credentials.AccessKeyID)
+	})
+
+	t.Run("Serves credentials that failed to refresh until they expire", func(t *testing.T) {
+		s := &Server{now: func() time.Time { return expires.Add(-10 * time.Minute) }, Margin: 15 * time.Minute,
+			Refresh: func() error { return fmt.Errorf("token command failed") }}
+		assert.NoError(t, s.WriteCredentials(testSet()))
+
+		profile, err := s.lookup("")
+		assert.NoError(t, err)
+		assert.Equal(t, "sessionKey", profile.Credentials.AccessKeyID)
+
+		s.now = func() time.Time { return now }
+		_, err = s.lookup("")
+		assert.ErrorContains(t, err, "token command failed")
+	})
+
+	t.Run("Fails without a refresh function", func(t *testing.T) {
+		s := &Server{now: func() time.Time { return now }}
+		assert.NoError(t, s.WriteCredentials(testSet()))
+
+		_, err := s.lookup("")
+		assert.ErrorContains(t, err, "restart the agent")
+	})
+}
+
+func TestPeerCredentials(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials are only supported on linux")
+	}
+
+	listener, err := net.Listen("unix", filepath.Join(t.TempDir(), "peer.sock"))
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	client, err := net.Dial("unix", listener.Addr().String())
+	assert.NoError(t, err)
+	defer client.Close()
+	conn, err := listener.Accept()
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	// The test binary is on both ends of the connection
+	executable, err := os.Executable()
+	assert.NoError(t, err)
+	peer, err := peerCredentials(conn)
+	assert.NoError(t, err)
+	assert.Equal(t, Peer{UID: os.Getuid(), PID: os.Getpid(), Binary: executable}, peer)
+}
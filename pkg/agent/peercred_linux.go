@@ -0,0 +1,36 @@
+package agent
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// peerCredentials identifies the client of a Unix socket connection with SO_PEERCRED, and its
+// executable through /proc.
+func peerCredentials(conn net.Conn) (Peer, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return Peer{}, fmt.Errorf("not a unix socket connection")
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return Peer{}, err
+	}
+
+	var cred *syscall.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return Peer{}, err
+	}
+	if credErr != nil {
+		return Peer{}, fmt.Errorf("failed to read peer credentials: %w", credErr)
+	}
+
+	peer := Peer{UID: int(cred.Uid), PID: int(cred.Pid)}
+	peer.Binary, _ = os.Readlink(fmt.Sprintf("/proc/%d/exe", cred.Pid))
+	return peer, nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package agent
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+// peerCredentials cannot identify clients on this platform, so every client is rejected.
+func peerCredentials(conn net.Conn) (Peer, error) {
+	return Peer{}, fmt.Errorf("identifying agent clients is not supported on %s", runtime.GOOS)
+}
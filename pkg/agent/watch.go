@@ -0,0 +1,130 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gredentures/pkg/awsconfig"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// settleDelay is how long the credentials file has to stay unchanged before it is checked,
+// so tools writing it in several steps are not interrupted halfway.
+const settleDelay = 500 * time.Millisecond
+
+// Watcher keeps the profiles served by the agent in a shared credentials file. When another
+// tool, such as aws configure or aws sso login, rewrites the file and drops or replaces them,
+// the watcher logs the conflict and puts them back. Like Server it implements
+// awsconfig.CredentialWriter, and it never writes the long-lived source keys.
+type Watcher struct {
+	Path string // Credentials file, usually ~/.aws/credentials.
+
+	mu     sync.Mutex              // Guards set.
+	set    awsconfig.CredentialSet // Profiles to keep in the file.
+	settle time.Duration           // Replaces settleDelay in tests.
+}
+
+// WriteCredentials implements awsconfig.CredentialWriter, merging the session and role
+// profiles into the file and remembering them for Reconcile.
+func (w *Watcher) WriteCredentials(set awsconfig.CredentialSet) error {
+	set.Source = nil
+	w.mu.Lock()
+	w.set = set
+	w.mu.Unlock()
+	return w.write(set)
+}
+
+// write merges the profiles of set into the file, keeping every other profile.
+func (w *Watcher) write(set awsconfig.CredentialSet) error {
+	return (&awsconfig.SharedCredentialsWriter{Path: w.Path, Merge: true}).WriteCredentials(set)
+}
+
+// Reconcile compares the file with the held profiles and restores every one that is missing
+// or holds other credentials. It returns the names of the restored profiles.
+func (w *Watcher) Reconcile() ([]string, error) {
+	w.mu.Lock()
+	set := w.set
+	w.mu.Unlock()
+	if set.Session.Name == "" {
+		return nil, nil
+	}
+
+	var clobbered []string
+	for _, profile := range append([]awsconfig.Profile{set.Session}, set.Roles...) {
+		written, err := awsconfig.ReadProfile(w.Path, profile.Name)
+		if err != nil || written.Credentials.AccessKeyID != profile.Credentials.AccessKeyID ||
+			written.Credentials.SessionToken != profile.Credentials.SessionToken {
+			clobbered = append(clobbered, profile.Name)
+		}
+	}
+	if len(clobbered) == 0 {
+		return nil, nil
+	}
+
+	slog.Warn("Another tool rewrote the credentials file, restoring the managed profiles", "path", w.Path, "profiles", clobbered)
+	// The agent wrote the profiles first, so long-lived keys another tool put in their place
+	// are replaced like any other clobbering.
+	set.Force = true
+	if err := w.write(set); err != nil {
+		return nil, fmt.Errorf("failed to restore %v in %s: %w", clobbered, w.Path, err)
+	}
+	return clobbered, nil
+}
+
+// Watch reconciles the file every time it changes, until ctx is cancelled. The directory is
+// watched rather than the file, because most tools replace the file instead of writing to it,
+// which would end a watch on the file itself. The watcher's own writes are seen as well, but
+// find every profile in place.
+func (w *Watcher) Watch(ctx context.Context) error {
+	dir := filepath.Dir(w.Path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to watch %s: %w", w.Path, err)
+	}
+	defer watcher.Close()
+	if err := watcher.Add(dir); err != nil {
+		return fmt.Errorf("failed to watch %s: %w", dir, err)
+	}
+
+	settle := w.settle
+	if settle == 0 {
+		settle = settleDelay
+	}
+	changed := time.NewTimer(settle)
+	changed.Stop()
+	defer changed.Stop()
+
+	slog.Info("Watching the credentials file", "path", w.Path)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if filepath.Clean(event.Name) == filepath.Clean(w.Path) {
+				slog.Debug("Credentials file changed", "path", w.Path, "op", event.Op.String())
+				changed.Reset(settle)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			slog.Warn("Error watching the credentials file", "path", w.Path, "error", err)
+		case <-changed.C:
+			if _, err := w.Reconcile(); err != nil {
+				slog.Warn("Could not restore the managed profiles", "error", err)
+			}
+		}
+	}
+}
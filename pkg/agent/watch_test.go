@@ -0,0 +1,101 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gredentures/pkg/awsconfig"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ini.v1"
+)
+
+func TestWatcherWriteCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials")
+	assert.NoError(t, os.WriteFile(path, []byte("[default]\naws_access_key_id = keep-me\n"), 0o600))
+
+	w := &Watcher{Path: path}
+	assert.NoError(t, w.WriteCredentials(testSet()))
+
+	cfg, err := ini.Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "keep-me", cfg.Section("default").Key("aws_access_key_id").String(), "the source keys are never written")
+	assert.Equal(t, "sessionKey", cfg.Section("default-mfa").Key("aws_access_key_id").String())
+	assert.Equal(t, "roleKey", cfg.Section("prod-mfa").Key("aws_access_key_id").String())
+}
+
+func TestWatcherReconcile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials")
+	w := &Watcher{Path: path}
+
+	t.Run("Does nothing before credentials are held", func(t *testing.T) {
+		restored, err := w.Reconcile()
+		assert.NoError(t, err)
+		assert.Empty(t, restored)
+		assert.NoFileExists(t, path)
+	})
+
+	assert.NoError(t, w.WriteCredentials(testSet()))
+
+	t.Run("Leaves an intact file alone", func(t *testing.T) {
+		restored, err := w.Reconcile()
+		assert.NoError(t, err)
+		assert.Empty(t, restored)
+	})
+
+	t.Run("Restores clobbered profiles and keeps the others", func(t *testing.T) {
+		// What aws configure leaves behind after rewriting the file
+		assert.NoError(t, os.WriteFile(path, []byte("[default-mfa]\naws_access_key_id = other\n\n[sso]\naws_access_key_id = new\n"), 0o600))
+
+		restored, err := w.Reconcile()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"default-mfa", "prod-mfa"}, restored)
+
+		cfg, err := ini.Load(path)
+		assert.NoError(t, err)
+		assert.Equal(t, "new", cfg.Section("sso").Key("aws_access_key_id").String())
+		assert.Equal(t, "sessionKey", cfg.Section("default-mfa").Key("aws_access_key_id").String())
+		assert.Equal(t, "roleKey", cfg.Section("prod-mfa").Key("aws_access_key_id").String())
+	})
+
+	t.Run("Recreates a deleted file", func(t *testing.T) {
+		assert.NoError(t, os.Remove(path))
+
+		restored, err := w.Reconcile()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"default-mfa", "prod-mfa"}, restored)
+		assert.FileExists(t, path)
+	})
+}
+
+func TestWatcherWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".aws", "credentials")
+	w := &Watcher{Path: path, settle: 10 * time.Millisecond}
+	assert.NoError(t, w.WriteCredentials(testSet()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- w.Watch(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		assert.NoError(t, <-done)
+	})
+
+	// Give the watch time to start, then replace the file the way most tools do
+	time.Sleep(50 * time.Millisecond)
+	replacement := filepath.Join(filepath.Dir(path), "credentials.new")
+	assert.NoError(t, os.WriteFile(replacement, []byte("[other]\naws_access_key_id = new\n"), 0o600))
+	assert.NoError(t, os.Rename(replacement, path))
+
+	assert.Eventually(t, func() bool {
+		profile, err := awsconfig.ReadProfile(path, "default-mfa")
+		return err == nil && profile.Credentials.AccessKeyID == "sessionKey"
+	}, 5*time.Second, 20*time.Millisecond)
+
+	profile, err := awsconfig.ReadProfile(path, "other")
+	assert.NoError(t, err)
+	assert.Equal(t, "new", profile.Credentials.AccessKeyID)
+}
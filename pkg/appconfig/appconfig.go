@@ -4,14 +4,30 @@
 package appconfig
 
 import (
+	"cmp"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"gredentures/pkg/remoteconfig"
+	"gredentures/pkg/secret"
+	"gredentures/pkg/sysuser"
+	"gredentures/pkg/validate"
 
 	"github.com/knadh/koanf"
-	"github.com/knadh/koanf/parsers/yaml"
 	"github.com/knadh/koanf/providers/confmap"
-	"github.com/knadh/koanf/providers/file"
+	"github.com/mitchellh/mapstructure"
 	y "gopkg.in/yaml.v3" // Alias this import to avoid conflicts
 
 	"github.com/docopt/docopt-go"
@@ -19,43 +35,540 @@ import (
 
 // Usage defines the command-line usage instructions for the Gredentures CLI tool.
 const Usage = `Usage:
-  gredentures -t <token> [-c <config>] [-o <org>] [-d <device>] [-p <profile>] [--timeout <seconds>] [--verbose]
-  gredentures --token <token> [--config <config>] [--org <org>] [--device <device>] [--profile <profile>] [--timeout <seconds>] [--verbose]
+  gredentures [-v...] [options]
+  gredentures login [<recipe>] [-v...] [options]
+  gredentures exec [--renew] [-v...] [options] -- <command>...
+  gredentures export --format <format> [--mount] [-v...] [options]
+  gredentures config (migrate | explain | path) [-v...] [options]
+  gredentures config lint [--fix] [-v...] [options]
+  gredentures config export [--personal] [-v...] [options]
+  gredentures config import <bundle> [-v...] [options]
+  gredentures generate aws-config [--write] [-v...] [options]
+  gredentures wsl-sync [--pull] [-v...] [options]
+  gredentures push <destination> [--remote-path <path>] [-v...] [options]
+  gredentures show [<profile>] [--full] [-v...] [options]
+  gredentures switch <profile> [--pin | --unpin] [-v...] [options]
+  gredentures status [-v...] [options]
+  gredentures status --check [<profile>] [-v...] [options]
+  gredentures import [<profile>] [--clipboard] [--expires <duration>] [-v...] [options]
+  gredentures env --unset [-v...] [options]
+  gredentures roles discover [-v...] [options]
+  gredentures accounts [-v...] [options]
+  gredentures sessions [--since <duration>] [-v...] [options]
+  gredentures stats [-v...] [options]
+  gredentures stats aggregate <file>... [-v...] [options]
+  gredentures audit [-v...] [options]
+  gredentures history [-v...] [options]
+  gredentures agent [-v...] [options]
+  gredentures serve [--listen <address>] [-v...] [options]
+  gredentures events [--follow] [--listen <address>] [-v...] [options]
+  gredentures device (enroll | resync) [-v...] [options]
+  gredentures aws-vault (import | export) [<profile>] [-v...] [options]
+  gredentures import-csv <csv> [--force] [-v...] [options]
+  gredentures keys (add | import | remove | list | report) [-v...] [options]
+  gredentures doctor [-v...] [options]
+  gredentures admin bootstrap --roster <file> [--out <dir>] [--mail] [-v...] [options]
+  gredentures completion (bash | zsh | fish) [-v...] [options]
   gredentures --help
 
 Options:
-  -t <token>, --token <token>       MFA token (required)
-  -c <config>, --config <config>    Path to gredentures config file [default: $HOME/.gredentures.yml]
+  -t <token>, --token <token>       MFA token, or - to read it from stdin (required unless a token command or --no-mfa is set)
+  --token-command <cmd>             Shell command that prints the MFA token, e.g. "op item get aws --otp"
+  --no-mfa                          Request the session without MFA, for accounts that do not enforce it
+  --prompt <kind>                   Ask for missing input with tty, stdin, zenity, osascript or a shell command
+  --json-rpc                        Serve getStatus, login and listProfiles as JSON-RPC on stdin and stdout
+  --listen <address>                Loopback address serve listens on, and events connects to [default: 127.0.0.1:7821]
+  --follow                          Have events keep printing the events of the profiles as they happen
+  -c <config>, --config <config>    Path or https URL of the gredentures config file, see "gredentures config path"
   -o <org>, --org <org>             Organization (optional if set in config)
   -d <device>, --device <device>    MFA device ARN (optional if set in config)
-  -p <profile>, --profile <profile> Name to use for the session creds profile [default: default-mfa]
-  --timeout <seconds>               Token timeout in seconds [default: 86400]
-  --verbose                         Enable verbose output
+  -p <profile>, --profile <profile> Name or template of the session creds profile [default: default-mfa]
+  --timeout <duration>              Token timeout in seconds or as a duration like 12h, 90m or 1d [default: 86400]
+  --all                             Assume the roles of all orgs configured in the config file
+  --soft-fail                       With --all, write the roles assumed when others fail, exiting with 3
+  --policy-arns <arns>              Comma-separated managed policy ARNs to scope assumed role sessions to
+  --policy-file <file>              JSON policy document to scope assumed role sessions to
+  --external-id <id>                External ID for roles of third parties, unless the org or recipe sets one
+  --proxy <url>                     Proxy for requests to AWS, overriding HTTPS_PROXY
+  --aws-config-file <file>          Shared AWS config file to read and write profiles in, overriding AWS_CONFIG_FILE
+  --write                           Have generate merge the profiles into the AWS config file instead of printing them
+  --ca-bundle <file>                PEM file of extra CA certificates to trust, e.g. of a TLS-intercepting proxy
+  --skip-imds                       Never query the EC2 instance metadata service, which only answers on EC2
+  --allow-argv-secrets              Accept the MFA token and proxy password as arguments without a warning
+  --format <format>                 Export format: docker-env or devcontainer
+  --mount                           Also write a credentials file to a temporary directory for mounting
+  --renew                           Have exec renew the session before it expires while the command runs
+  --offline                         Have exec use the session already written instead of calling STS
+  --output <output>                 Credential output: ini, env, json, keychain, credential-process or k8s-exec [default: ini]
+  --cluster <cluster>               EKS cluster name for k8s-exec output
+  --wsl-sync                        Also write the managed profiles to the Windows credentials file under WSL
+  --pull                            Copy the managed profiles from Windows to WSL instead of the other way
+  --remote-path <path>              Credentials file on the push destination, ~/.aws/credentials by default
+  --pin                             Have switch add the profile to the favorites, listed first
+  --unpin                           Have switch remove the profile from the favorites
+  --full                            Have show print the full secret and session token, after confirming
+  --check                           Have status check one profile, the session profile by default, exiting with 0 valid, 10 expiring soon, 20 expired or 30 not configured
+  --clipboard                       Have import read the credentials from the clipboard instead of stdin
+  --expires <duration>              Lifetime of imported credentials that carry no expiry [default: 1h]
+  --since <duration>                How far back sessions looks in CloudTrail, at most 90 days [default: 24h]
+  --unset                           Print an unset command for the AWS_* credential variables, for eval
+  --isolated                        Write the credentials to a new temporary directory and print its exports
+  --no-write                        Print the credentials (redacted) instead of writing them anywhere
+  --force                           Overwrite a profile holding long-lived keys that gredentures does not manage, or replace them with import-csv
+  --save-config                     Save the org, device and timeout given as flags to the config file
+  --no-config-write                 Never create, upgrade or edit the config file
+  --show-secrets                    Print full secrets with --no-write
+  --personal                        Keep the device, token command, 1Password item and proxy user in config export
+  --fix                             Have config lint fix the problems it can, such as misspelled keys and profiles defined twice
+  --roster <file>                   CSV or JSON roster of the users admin bootstrap generates configs for
+  --out <dir>                       Directory admin bootstrap writes a config file per user to
+  --mail                            Have admin bootstrap mail every user their config with sendmail
+  -q, --quiet                       Suppress the banner, spinner and login message
+  --log-file <path>                 Write logs to this file, rotated by size, instead of stderr
+  --debug-http <path>               Write sanitized traces of the AWS requests to this file, for support tickets
+  -v, --verbose                     Log more: -v progress, -vv debug, -vvv trace including AWS SDK requests
   --help                            Show this help message`
 
+// Credential outputs selectable with --output.
+const (
+	OutputINI               = "ini"                // Shared credentials file, the default.
+	OutputEnv               = "env"                // Shell export statements.
+	OutputJSON              = "json"               // JSON object keyed by profile name.
+	OutputKeychain          = "keychain"           // OS keychain, one item per profile.
+	OutputCredentialProcess = "credential-process" // credential_process JSON for an AWS config profile.
+	OutputK8sExec           = "k8s-exec"           // Kubernetes ExecCredential for EKS.
+)
+
+// Outputs lists every supported --output value.
+var Outputs = []string{OutputINI, OutputEnv, OutputJSON, OutputKeychain, OutputCredentialProcess, OutputK8sExec}
+
+// ConfigOutputs lists the outputs the Output of the config file may select. The
+// credential-process and k8s-exec outputs are run by other tools and only given as flags.
+var ConfigOutputs = []string{OutputINI, OutputEnv, OutputJSON, OutputKeychain}
+
+// TokenFromStdin is the --token value that reads the MFA token from stdin instead.
+const TokenFromStdin = "-"
+
+// TokenEnv holds the MFA token when neither --token nor a token command gives one. Unlike
+// the arguments, the environment of a process is only readable by its own user.
+const TokenEnv = "GREDENTURES_TOKEN"
+
 // AppConfig represents the configuration options for the Gredentures CLI tool.
 // It includes fields for command-line arguments and configuration file values.
 type AppConfig struct {
-	Token   string `docopt:"--token"`   // MFA token (required).
-	Config  string `docopt:"--config"`  // Path to the configuration file.
-	Org     string `docopt:"--org"`     // Organization name.
-	Device  string `docopt:"--device"`  // MFA device ARN.
-	Verbose bool   `docopt:"--verbose"` // Enable verbose output.
-	Timeout int32  `docopt:"--timeout"` // Token timeout in seconds.
-	Profile string `docopt:"--profile"` // Profile name for session credentials.
+	Token   secret.Value // MFA token (required), parsed from TokenArg, stdin or the token command.
+	Config  string       `docopt:"--config"`   // Path to the configuration file.
+	Org     string       `docopt:"--org"`      // Organization name.
+	Device  string       `docopt:"--device"`   // MFA device ARN.
+	Verbose int          `docopt:"--verbose"`  // Number of -v flags: 1 info, 2 debug, 3 trace.
+	Quiet   bool         `docopt:"--quiet"`    // Suppress the banner, spinner and login message.
+	LogFile string       `docopt:"--log-file"` // Write logs to this file instead of stderr.
+	Timeout int32        // Token timeout in seconds, parsed from TimeoutArg or the config file.
+	KeyAge  int32        // Maximum age of the access keys in seconds, loaded from KeyMaxAge in the config file.
+	Margin  int32        // How long before expiry credentials are expiring soon in seconds, loaded from RefreshMargin in the config file.
+	Profile string       `docopt:"--profile"` // Profile name for session credentials.
+
+	TokenArg      string `docopt:"--token"`           // Raw --token value, cleared once moved to Token.
+	TimeoutArg    string `docopt:"--timeout"`         // Raw --timeout value as seconds or a duration.
+	TokenCommand  string `docopt:"--token-command"`   // Shell command printing the MFA token.
+	Prompt        string `docopt:"--prompt"`          // How to ask for missing input, see prompt.New.
+	PolicyArnsArg string `docopt:"--policy-arns"`     // Raw comma-separated --policy-arns value.
+	PolicyFile    string `docopt:"--policy-file"`     // Path to an inline session policy document.
+	ExternalID    string `docopt:"--external-id"`     // External ID sent when assuming roles without their own.
+	Proxy         string `docopt:"--proxy"`           // Proxy URL for AWS requests, HTTPS_PROXY applies when empty.
+	CABundle      string `docopt:"--ca-bundle"`       // PEM file of CA certificates trusted besides the system ones.
+	DebugHTTP     string `docopt:"--debug-http"`      // Write sanitized traces of AWS requests to this file.
+	AWSConfigFile string `docopt:"--aws-config-file"` // Shared AWS config file used instead of AWS_CONFIG_FILE and ~/.aws/config.
+
+	AllowArgvSecrets bool `docopt:"--allow-argv-secrets"` // Accept secrets as arguments without a warning, see ArgvSecrets.
+
+	PolicyArns []string // Managed session policy ARNs, parsed from PolicyArnsArg.
+	TokenStdin bool     // --token - was given, so the token is read from stdin with ReadToken.
+	Policy     string   // Inline session policy document, read from PolicyFile.
+
+	SourceProfile    string   // Profile holding the long-lived credentials, loaded from the config file.
+	SourceFile       string   // Credentials file holding the source profile, loaded from the config file.
+	LoginMessage     string   // text/template printed after login, loaded from the config file.
+	CredentialsFile  string   // Credentials file the sessions are written to instead of ~/.aws/credentials, loaded from the config file.
+	CredentialsFiles []string // Extra credentials files to keep in sync, loaded from the config file.
+	CurrentProfile   string   // Profile mirroring the session of the last login, whatever the org, loaded from the config file.
+	Favorites        []string // Profiles pinned with switch --pin, listed first, loaded from the config file.
+	ManagedPatterns  []string // Further profiles gredentures owns, names or path.Match patterns, loaded from ManagedProfiles in the config file.
+	AuditLog         string   // Append-only audit log of issued and written credentials, loaded from the config file.
+	BaseConfigs      []string // Shared config files merged underneath the config file, lowest precedence first.
+	SystemMode       bool     // Keep the state of each user below sysuser.StateRoot, loaded from the config file.
+	CompatMode       bool     // Never overwrite or remove the profiles of other credential helpers, loaded from the config file.
+	STSRegions       []string // Regions, or STSGlobal, STS calls fail over to when the regional endpoint is down, loaded from the config file.
+
+	Login       bool     `docopt:"login"`          // Explicit login subcommand.
+	Recipe      string   `docopt:"<recipe>"`       // Login recipe to run, see Recipes.
+	All         bool     `docopt:"--all"`          // Acquire credentials for every configured org.
+	SoftFail    bool     `docopt:"--soft-fail"`    // Keep the roles of --all that were assumed when others fail.
+	Exec        bool     `docopt:"exec"`           // Run a command with session credentials in its environment.
+	Separator   bool     `docopt:"--"`             // Marks the end of gredentures options for exec.
+	Command     []string `docopt:"<command>"`      // Command and arguments to run for exec.
+	Renew       bool     `docopt:"--renew"`        // Renew the session while the exec command runs.
+	Offline     bool     `docopt:"--offline"`      // Run the exec command with the session already written.
+	Export      bool     `docopt:"export"`         // Print session credentials for containers.
+	Format      string   `docopt:"--format"`       // Output format for export.
+	Mount       bool     `docopt:"--mount"`        // Write a mountable credentials file for export.
+	Output      string   `docopt:"--output"`       // Print credentials in this format instead of writing them.
+	Cluster     string   `docopt:"--cluster"`      // EKS cluster name for k8s-exec output.
+	NoWrite     bool     `docopt:"--no-write"`     // Print the credentials instead of persisting them.
+	Isolated    bool     `docopt:"--isolated"`     // Write the credentials to a temporary directory only.
+	Force       bool     `docopt:"--force"`        // Overwrite a profile holding long-lived keys gredentures does not manage.
+	NoMFA       bool     `docopt:"--no-mfa"`       // Request the session without an MFA device and token.
+	SkipIMDS    bool     `docopt:"--skip-imds"`    // Disable the EC2 instance metadata lookups of the SDK.
+	ShowSecrets bool     `docopt:"--show-secrets"` // Print secrets unredacted with NoWrite.
+	ConfigCmd   bool     `docopt:"config"`         // Manage the gredentures config file.
+	Migrate     bool     `docopt:"migrate"`        // Convert the legacy INI config file to YAML.
+	Explain     bool     `docopt:"explain"`        // Print every effective option and its source.
+	ShowPath    bool     `docopt:"path"`           // Print which config file is in use.
+	Lint        bool     `docopt:"lint"`           // Check the config file and the credentials files for problems.
+	Fix         bool     `docopt:"--fix"`          // Fix the problems config lint can.
+	Personal    bool     `docopt:"--personal"`     // Keep personal settings in the exported config bundle.
+	Bundle      string   `docopt:"<bundle>"`       // Config bundle to import, or - for stdin.
+	GenerateCmd bool     `docopt:"generate"`       // Print config for other tools.
+	AWSConfig   bool     `docopt:"aws-config"`     // Print ~/.aws/config profiles running gredentures.
+	Write       bool     `docopt:"--write"`        // Merge the generated profiles into the AWS config file.
+	StatsCmd    bool     `docopt:"stats"`          // Show the local usage statistics.
+	Aggregate   bool     `docopt:"aggregate"`      // Sum the usage statistics of several files.
+	Files       []string `docopt:"<file>"`         // Stats files to aggregate.
+	AuditCmd    bool     `docopt:"audit"`          // Show the audit log.
+	HistoryCmd  bool     `docopt:"history"`        // Show the recent logins.
+	AgentCmd    bool     `docopt:"agent"`          // Serve the credentials on a local socket.
+	DeviceCmd   bool     `docopt:"device"`         // Manage the MFA device.
+	Enroll      bool     `docopt:"enroll"`         // Create, enable and save a virtual MFA device.
+	Resync      bool     `docopt:"resync"`         // Resynchronize a drifted MFA device from two codes.
+	DoctorCmd   bool     `docopt:"doctor"`         // Check the prerequisites for logging in.
+	AdminCmd    bool     `docopt:"admin"`          // Tasks of the platform admins rolling gredentures out.
+	Bootstrap   bool     `docopt:"bootstrap"`      // Generate the config files of the users of a roster.
+	Roster      string   `docopt:"--roster"`       // Roster of users to bootstrap.
+	OutDir      string   `docopt:"--out"`          // Directory bootstrap writes the configs to.
+	Mail        bool     `docopt:"--mail"`         // Mail every user of the roster their config.
+	AWSVaultCmd bool     `docopt:"aws-vault"`      // Move the long-lived keys to or from aws-vault.
+	ImportCSV   bool     `docopt:"import-csv"`     // Store the long-lived keys of a CSV file downloaded from the IAM console.
+	CSVFile     string   `docopt:"<csv>"`          // accessKeys.csv or credentials.csv file to import.
+	KeysCmd     bool     `docopt:"keys"`           // Manage the long-lived keys of the KeyStore.
+	Add         bool     `docopt:"add"`            // Store a long-lived key pair for the org.
+	Remove      bool     `docopt:"remove"`         // Delete the long-lived key pair of the org.
+	List        bool     `docopt:"list"`           // List the orgs the KeyStore holds keys for.
+	Report      bool     `docopt:"report"`         // Report the age and last use of the access keys of the IAM user.
+	WSLSyncCmd  bool     `docopt:"wsl-sync"`       // Mirror the managed profiles between WSL and Windows.
+	WSLSync     bool     `docopt:"--wsl-sync"`     // Mirror the profiles to Windows after login.
+	JSONRPC     bool     `docopt:"--json-rpc"`     // Serve requests from an editor plugin on stdin and stdout.
+	Pull        bool     `docopt:"--pull"`         // Copy the profiles from Windows to WSL instead.
+	PushCmd     bool     `docopt:"push"`           // Copy the session profile to a remote host over SSH.
+	Destination string   `docopt:"<destination>"`  // [user@]host to push to.
+	RemotePath  string   `docopt:"--remote-path"`  // Credentials file on the destination, see Push.RemotePath.
+	ShowCmd     bool     `docopt:"show"`           // Inspect the credentials of a profile.
+	ProfileArg  string   `docopt:"<profile>"`      // Profile to inspect, check, switch to, import into or move to and from aws-vault.
+	SwitchCmd   bool     `docopt:"switch"`         // Point future shells at a managed profile.
+	Pin         bool     `docopt:"--pin"`          // Add the profile switched to to the favorites.
+	Unpin       bool     `docopt:"--unpin"`        // Remove the profile switched to from the favorites.
+	Full        bool     `docopt:"--full"`         // Show the secrets unredacted once confirmed.
+	StatusCmd   bool     `docopt:"status"`         // List the managed profiles and how fresh their credentials are.
+	Check       bool     `docopt:"--check"`        // Exit with the freshness of one profile instead.
+	ImportCmd   bool     `docopt:"import"`         // Write pasted temporary credentials to a profile, or import keys.
+	Clipboard   bool     `docopt:"--clipboard"`    // Read the credentials to import from the clipboard.
+	Expires     string   `docopt:"--expires"`      // Lifetime of imported credentials without an expiry.
+	EnvCmd      bool     `docopt:"env"`            // Help with the AWS_* variables of the invoking shell.
+	Unset       bool     `docopt:"--unset"`        // Print a command clearing the credential variables.
+	RolesCmd    bool     `docopt:"roles"`          // Manage the roles of the Orgs.
+	Discover    bool     `docopt:"discover"`       // List the assumable roles and offer to add them as orgs.
+	AccountsCmd bool     `docopt:"accounts"`       // List the accounts of the AWS Organization named by Org.
+	SessionsCmd bool     `docopt:"sessions"`       // List the sessions CloudTrail recorded as issued to the caller.
+	Since       string   `docopt:"--since"`        // How far back sessions looks in CloudTrail.
+	ServeCmd    bool     `docopt:"serve"`          // Serve status, login and the profiles over HTTP on the loopback interface.
+	Listen      string   `docopt:"--listen"`       // Loopback address serve listens on and events connects to.
+	EventsCmd   bool     `docopt:"events"`         // Print the events of the profiles served by serve.
+	Follow      bool     `docopt:"--follow"`       // Keep printing events until interrupted.
+	CompleteCmd bool     `docopt:"completion"`     // Print a shell completion script.
+	Bash        bool     `docopt:"bash"`           // Print the completion script for bash.
+	Zsh         bool     `docopt:"zsh"`            // Print the completion script for zsh.
+	Fish        bool     `docopt:"fish"`           // Print the completion script for fish.
+
+	SaveConfig    bool `docopt:"--save-config"`     // Save the org, device and timeout flags to the config file.
+	NoConfigWrite bool `docopt:"--no-config-write"` // Never create, upgrade or edit the config file.
+
+	Orgs         map[string]OrgConfig    // Per-org role configuration loaded from the config file.
+	Recipes      map[string]RecipeConfig // Named login recipes loaded from the config file.
+	OnePassword  OnePasswordConfig       // Optional 1Password item holding the AWS secrets.
+	KeyStore     KeyStoreConfig          // Optional store of the long-lived keys of each org.
+	Stats        StatsConfig             // Opt-in anonymous usage statistics.
+	Agent        AgentConfig             // Socket and allowlist of the credential agent.
+	Organization OrganizationConfig      // Orgs generated for the accounts of the AWS Organization named by Org.
+	SDK          SDKConfig               // Retries and timeouts of the AWS SDK calls.
+
+	configLoaded bool              // Set once the config file has been read.
+	sources      map[string]string // Source of each option not left at its default, see ExplainOptions.
+	configOrigin string            // Why Config was chosen, see ConfigPath.
+	httpClient   *http.Client      // Client for remote config files, replaced in tests.
+	completing   bool              // Loaded for shell completion, see Complete.
+}
+
+// OnePasswordConfig names the 1Password item gredentures reads the long-lived access key
+// pair and the MFA one-time password from. The integration is disabled when Item is empty.
+type OnePasswordConfig struct {
+	Item        string `koanf:"Item"`        // Title of the 1Password item.
+	Vault       string `koanf:"Vault"`       // Vault containing the item.
+	ConnectHost string `koanf:"ConnectHost"` // 1Password Connect server, the op CLI is used when empty.
+}
+
+// Key stores selectable as KeyStore.Backend.
+const (
+	KeyStoreKeychain = "keychain" // OS keychain, one item per org.
+	KeyStoreFile     = "file"     // File encrypted with a passphrase.
+)
+
+// KeyStores lists every supported KeyStore.Backend value.
+var KeyStores = []string{KeyStoreKeychain, KeyStoreFile}
+
+// KeyStoreConfig has gredentures keep the long-lived key pair of each org itself, so the
+// credentials file only ever holds session credentials. The source profile of the credentials
+// file is used when Backend is empty.
+type KeyStoreConfig struct {
+	Backend string `koanf:"Backend"` // KeyStoreKeychain or KeyStoreFile.
+	File    string `koanf:"File"`    // Encrypted file of the file backend, in the XDG data directory by default.
 }
 
-// setLogger configures the logging level for the application based on the verbose flag.
-// If verbose is true, debug-level logging is enabled; otherwise, info-level logging is used.
-func setLogger(verbose bool) error {
-	level := slog.LevelInfo
+// KeyName returns the name the long-lived keys of the selected org are stored under: the org,
+// or "default" when none is set.
+func (conf AppConfig) KeyName() string {
+	if conf.Org == "" {
+		return "default"
+	}
+	return conf.Org
+}
+
+// StatsConfig controls the opt-in usage statistics. Nothing is recorded unless Enabled is set,
+// and nothing leaves the machine unless Endpoint is also set.
+type StatsConfig struct {
+	Enabled  bool   `koanf:"Enabled"`  // Record command usage counts.
+	Endpoint string `koanf:"Endpoint"` // URL each run is reported to, local counting only when empty.
+	File     string `koanf:"File"`     // Local stats file, ~/.gredentures-stats.json by default.
+}
+
+// AgentConfig controls "gredentures agent". By default the socket is
+// ~/.gredentures/agent.sock and only processes of the same user may connect.
+type AgentConfig struct {
+	Socket           string   `koanf:"Socket"`           // Socket path.
+	AllowUIDs        []int    `koanf:"AllowUIDs"`        // User IDs allowed to connect besides the agent's own user.
+	AllowBinaries    []string `koanf:"AllowBinaries"`    // Client executables allowed to connect, any when empty.
+	WatchCredentials bool     `koanf:"WatchCredentials"` // Also keep the served profiles in ~/.aws/credentials, restoring them when clobbered.
+}
+
+// OrgConfig describes a role that can be assumed from the MFA session for a single org.
+type OrgConfig struct {
+	RoleArn       string `koanf:"RoleArn"`       // ARN of the role to assume with the MFA session credentials.
+	Profile       string `koanf:"Profile"`       // Profile name to write the role credentials to.
+	Timeout       int32  `koanf:"Timeout"`       // Role session duration in seconds (STS default when zero).
+	SourceProfile string `koanf:"SourceProfile"` // Profile holding the long-lived keys when this org is selected.
+	SourceFile    string `koanf:"SourceFile"`    // Credentials file holding SourceProfile when this org is selected.
+	ExternalID    string `koanf:"ExternalID"`    // External ID required by the trust policy of a third party's role.
+	KeyMaxAge     int32  `koanf:"KeyMaxAge"`     // Maximum age of the access keys in seconds when this org is selected.
+	RefreshMargin int32  `koanf:"RefreshMargin"` // How long before expiry the role credentials are expiring soon, in seconds.
+	Device        string `koanf:"Device"`        // MFA device when this org is selected, overrides the top-level one.
+	Partition     string `koanf:"Partition"`     // Partition of the account, one of Partitions, see PartitionName.
+	LinkedAccount string `koanf:"LinkedAccount"` // Commercial account a GovCloud or China account is linked to.
+}
+
+// ProfileTemplate returns the name or template of the profile the org's role credentials are
+// written to, defaulting to "<org>-mfa" when no profile is configured.
+func (org OrgConfig) ProfileTemplate(name string) string {
+	return cmp.Or(org.Profile, name+"-mfa")
+}
 
-	if verbose {
-		level = slog.LevelDebug
+// ProfileName returns the profile the org's role credentials are written to, see
+// ProfileTemplate, evaluated with the org's ProfileData. The AccountAlias is only looked up at
+// login, until then the account ID stands in for it.
+func (org OrgConfig) ProfileName(name string) string {
+	data := org.ProfileData(name)
+	profile, err := RenderProfile(org.ProfileTemplate(name), data.WithAccountAlias(func() (string, error) { return data.AccountID, nil }))
+	if err != nil {
+		return org.Profile
+	}
+	return profile
+}
+
+// Source returns the profile and credentials file the long-lived keys are read from. When
+// the selected org or recipe has its own source settings they override the top-level ones. An empty
+// file means the default shared credentials file.
+func (config AppConfig) Source() (profile, file string) {
+	profile, file = config.SourceProfile, config.SourceFile
+	if org, ok := config.Orgs[config.Org]; ok {
+		if org.SourceProfile != "" {
+			profile = org.SourceProfile
+		}
+		if org.SourceFile != "" {
+			file = org.SourceFile
+		}
+	}
+	if recipe, ok := config.SelectedRecipe(); ok {
+		if recipe.SourceProfile != "" {
+			profile = recipe.SourceProfile
+		}
+		if recipe.SourceFile != "" {
+			file = recipe.SourceFile
+		}
 	}
+	return profile, expandPath(file)
+}
+
+// DefaultKeyMaxAge is how old access keys may get unless KeyMaxAge says otherwise, the 90 days
+// of the CIS AWS Foundations Benchmark.
+const DefaultKeyMaxAge = 90 * 24 * time.Hour
+
+// KeyMaxAge returns how old the access keys may get before they should be rotated: that of the
+// selected org when it sets one, the top-level one otherwise, and DefaultKeyMaxAge without either.
+func (config AppConfig) KeyMaxAge() time.Duration {
+	if org, ok := config.Orgs[config.Org]; ok && org.KeyMaxAge > 0 {
+		return time.Duration(org.KeyMaxAge) * time.Second
+	}
+	if config.KeyAge > 0 {
+		return time.Duration(config.KeyAge) * time.Second
+	}
+	return DefaultKeyMaxAge
+}
 
-	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
-		Level: level,
+// DefaultRefreshMargin is how long before they expire credentials are expiring soon unless
+// RefreshMargin says otherwise.
+const DefaultRefreshMargin = 15 * time.Minute
+
+// RefreshMargin returns how long before they expire the credentials of profile are expiring
+// soon, which status reports, the agent refreshes them at and events are sent for: that of the
+// org or recipe writing the profile when it sets one, see RefreshMargins, the top-level one
+// otherwise, and DefaultRefreshMargin without either.
+func (config AppConfig) RefreshMargin(profile string) time.Duration {
+	if margin, ok := config.RefreshMargins()[profile]; ok {
+		return margin
+	}
+	if config.Margin > 0 {
+		return time.Duration(config.Margin) * time.Second
+	}
+	return DefaultRefreshMargin
+}
+
+// RefreshMargins returns the profiles of the orgs and recipes setting a RefreshMargin of their
+// own, with that margin.
+func (config AppConfig) RefreshMargins() map[string]time.Duration {
+	margins := map[string]time.Duration{}
+	for name, org := range config.Orgs {
+		if org.RefreshMargin > 0 {
+			margins[org.ProfileName(name)] = time.Duration(org.RefreshMargin) * time.Second
+		}
+	}
+	for name, recipe := range config.Recipes {
+		if recipe.RefreshMargin > 0 {
+			margins[recipe.ProfileName(name)] = time.Duration(recipe.RefreshMargin) * time.Second
+		}
+	}
+	return margins
+}
+
+// expandPath expands environment variables and a leading "~/" in a file path.
+func expandPath(path string) string {
+	path = os.ExpandEnv(path)
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		return fmt.Sprintf("%s/%s", sysuser.Home(), rest)
+	}
+	return path
+}
+
+// CommandLogin is the CommandName of the login and of the commands without a name of their own.
+const CommandLogin = "login"
+
+// CommandName returns the name usage statistics are recorded under for the parsed command, and
+// the settings of SDK.Commands are looked up by.
+func (config AppConfig) CommandName() string {
+	switch {
+	case config.Exec:
+		return "exec"
+	case config.Export:
+		return "export"
+	case config.AgentCmd:
+		return "agent"
+	case config.JSONRPC:
+		return "json-rpc"
+	case config.ServeCmd:
+		return "serve"
+	case config.Output == OutputK8sExec:
+		return "k8s-exec"
+	case config.SessionsCmd:
+		return "sessions"
+	case config.AccountsCmd:
+		return "accounts"
+	case config.RolesCmd:
+		return "roles"
+	case config.KeysCmd:
+		return "keys"
+	case config.DeviceCmd:
+		return "device"
+	case config.DoctorCmd:
+		return "doctor"
+	default:
+		return CommandLogin
+	}
+}
+
+// ManagedProfiles returns the names of every profile gredentures writes session
+// credentials to: the main session profile, the profile of each configured org and recipe
+// and the CurrentProfile alias.
+func (config AppConfig) ManagedProfiles() []string {
+	profiles := []string{config.Profile}
+	for name, org := range config.Orgs {
+		profiles = append(profiles, org.ProfileName(name))
+	}
+	for name, recipe := range config.Recipes {
+		if profile := recipe.ProfileName(name); !slices.Contains(profiles, profile) {
+			profiles = append(profiles, profile)
+		}
+	}
+	if config.CurrentProfile != "" && !slices.Contains(profiles, config.CurrentProfile) {
+		profiles = append(profiles, config.CurrentProfile)
+	}
+	return profiles
+}
+
+// LevelTrace is the most detailed log level, selected with -vvv. It also turns on logging of
+// every AWS SDK request and response, with signatures and session tokens redacted.
+const LevelTrace = slog.LevelDebug - 4
+
+// logLevel maps the number of -v flags to a log level: none only logs warnings and errors,
+// -v adds progress messages, -vv debug details, and -vvv traces.
+func logLevel(verbosity int) slog.Level {
+	switch {
+	case verbosity >= 3:
+		return LevelTrace
+	case verbosity == 2:
+		return slog.LevelDebug
+	case verbosity == 1:
+		return slog.LevelInfo
+	}
+	return slog.LevelWarn
+}
+
+// setLogger configures the logging level for the application from the number of -v flags.
+// Logs go to logFile, rotated by size, when set and to stderr otherwise.
+func setLogger(verbosity int, logFile string) error {
+	var out io.Writer = os.Stderr
+	if logFile != "" {
+		file, err := openLogFile(expandPath(logFile))
+		if err != nil {
+			return err
+		}
+		out = file
+	}
+
+	logger := slog.New(slog.NewTextHandler(out, &slog.HandlerOptions{
+		Level: logLevel(verbosity),
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			// Name the custom level instead of printing it as DEBUG-4
+			if a.Key == slog.LevelKey && len(groups) == 0 && a.Value.Any() == LevelTrace {
+				a.Value = slog.StringValue("TRACE")
+			}
+			return a
+		},
 	}))
 	slog.SetDefault(logger)
 
@@ -75,15 +588,47 @@ func (config *AppConfig) Parse(args []string) error {
 		return fmt.Errorf("error binding options: %v", err)
 	}
 
+	// Remember which options were given on the command line
+	config.recordFlags(args)
+	config.trimFlags()
+
+	// Keep the token only as a secret, so it can never be printed. A "-" is only a placeholder
+	// for the token piped to stdin, which keeps it out of the process arguments.
+	if config.TokenArg == TokenFromStdin {
+		config.TokenStdin, config.TokenArg = true, ""
+	}
+	config.Token, config.TokenArg = secret.Value(config.TokenArg), ""
+
+	// Convert the timeout into canonical seconds
+	if config.TimeoutArg != "" {
+		timeout, err := ParseTimeout(config.TimeoutArg)
+		if err != nil {
+			return fmt.Errorf("error parsing timeout: %w", err)
+		}
+		config.Timeout = timeout
+	}
+
+	// Split the managed session policies
+	for _, arn := range strings.Split(config.PolicyArnsArg, ",") {
+		if arn = strings.TrimSpace(arn); arn != "" {
+			config.PolicyArns = append(config.PolicyArns, arn)
+		}
+	}
+
 	// Set default value for Profile if not provided
 	if config.Profile == "" {
 		config.Profile = "default-mfa"
 	}
 
 	// Setup logging
-	if err := setLogger(config.Verbose); err != nil {
+	if err := setLogger(config.Verbose, config.LogFile); err != nil {
 		fmt.Printf("Error setting logger: %v\n", err)
 	}
+	config.DebugHTTP = expandPath(config.DebugHTTP)
+	config.AWSConfigFile = expandPath(config.AWSConfigFile)
+	config.Roster = expandPath(config.Roster)
+	config.OutDir = expandPath(config.OutDir)
+	config.CSVFile = expandPath(config.CSVFile)
 
 	return nil
 }
@@ -91,50 +636,145 @@ func (config *AppConfig) Parse(args []string) error {
 // WriteGredenturesConfig writes the current AppConfig values to a YAML configuration file.
 // If the file does not exist, it creates a new one.
 func (conf *AppConfig) WriteGredenturesConfig() error {
+	data, err := conf.marshalConfig()
+	if err != nil {
+		return err
+	}
+	return conf.writeConfigFile(data, os.O_TRUNC)
+}
+
+// createConfig creates a config file of empty settings, so flags given to a single run do not
+// become defaults without --save-config. A file created in the meantime, e.g. by a parallel
+// run, is left alone.
+func (conf *AppConfig) createConfig() error {
+	data, err := (&AppConfig{}).marshalConfig()
+	if err != nil {
+		return err
+	}
+	if err := conf.writeConfigFile(data, os.O_EXCL); err != nil && !errors.Is(err, os.ErrExist) {
+		return err
+	}
+	return nil
+}
+
+// marshalConfig returns the Org, Device and Timeout of conf as the YAML of a config file.
+func (conf *AppConfig) marshalConfig() ([]byte, error) {
 	k := koanf.New(".") // Initialize koanf with a delimiter
 
 	// Load the current AppConfig values into koanf
 	configMap := map[string]interface{}{
+		configVersionKey:      ConfigVersion,
 		"gredentures.Org":     conf.Org,
 		"gredentures.Device":  conf.Device,
 		"gredentures.Timeout": conf.Timeout,
 	}
 	if err := k.Load(confmap.Provider(configMap, "."), nil); err != nil {
-		return fmt.Errorf("failed to load AppConfig values into koanf: %w", err)
+		return nil, fmt.Errorf("failed to load AppConfig values into koanf: %w", err)
 	}
 
-	// Marshal the configuration into YAML
-	yamlData, err := y.Marshal(k.All())
+	// Marshal the nested configuration into YAML, so it passes ValidateConfig on the next run
+	yamlData, err := y.Marshal(k.Raw())
 	if err != nil {
-		return fmt.Errorf("failed to marshal configuration to YAML: %w", err)
+		return nil, fmt.Errorf("failed to marshal configuration to YAML: %w", err)
+	}
+	return yamlData, nil
+}
+
+// writeConfigFile writes data to the config file, opened with flag besides os.O_CREATE.
+func (conf *AppConfig) writeConfigFile(data []byte, flag int) error {
+	if err := conf.checkConfigWritable(); err != nil {
+		return err
 	}
 
-	// Write the YAML data to the specified file
-	if err := os.WriteFile(conf.Config, yamlData, 0o644); err != nil {
+	// Write the YAML data to the specified file, creating the XDG config directory if needed
+	if err := sysuser.MkdirAll(filepath.Dir(conf.Config), 0o755); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	file, err := os.OpenFile(conf.Config, os.O_WRONLY|os.O_CREATE|flag, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to write configuration to file: %w", err)
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
 		return fmt.Errorf("failed to write configuration to file: %w", err)
 	}
 
+	return sysuser.Chown(conf.Config)
+}
+
+// checkConfigWritable returns an error when the config file must not be written: it is a remote
+// file, or --no-config-write guarantees it is left untouched.
+func (conf *AppConfig) checkConfigWritable() error {
+	switch {
+	case remoteconfig.IsRemote(conf.Config):
+		return fmt.Errorf("cannot write to the remote config file %s", conf.Config)
+	case conf.NoConfigWrite:
+		return fmt.Errorf("not writing the config file %s with --no-config-write", conf.Config)
+	}
 	return nil
 }
 
+// saveFlags writes the Org, Device and Timeout given as flags to the config file, for
+// --save-config. Every other key and comment in the file is kept.
+func (conf *AppConfig) saveFlags() error {
+	saved := [][2]string{{"Org", conf.Org}, {"Device", conf.Device}, {"Timeout", conf.TimeoutArg}}
+	return conf.editConfig(func(section *y.Node) error {
+		for _, setting := range saved {
+			if conf.source(setting[0]) != SourceFlag {
+				continue
+			}
+			field, err := mappingEntry(section, setting[0], y.ScalarNode)
+			if err != nil {
+				return err
+			}
+			field.SetString(setting[1])
+			slog.Debug("Saved flag to the config file", "option", setting[0], "path", conf.Config)
+		}
+		return nil
+	})
+}
+
 // GetGredenturesConfig ensures that the configuration file exists and loads its values
 // into the AppConfig struct. If the file does not exist, it creates a new one.
+// The file is only processed once, so repeated calls are cheap.
 func (conf *AppConfig) GetGredenturesConfig() error {
-	if conf.Config == "" {
-		conf.Config = fmt.Sprintf("%s/.gredentures.yml", os.Getenv("HOME"))
+	if conf.configLoaded {
+		return nil
 	}
+	conf.resolveConfigPath()
 
 	// Check if the gredentures config file exists
+	var err error
 	slog.Debug("Checking for gredentures config file", "path", conf.Config)
-	if _, err := os.Stat(conf.Config); err == nil {
-		return conf.LoadGredenturesConfig()
-	} else if os.IsNotExist(err) {
+	if _, statErr := os.Stat(conf.Config); statErr == nil || remoteconfig.IsRemote(conf.Config) {
+		err = conf.LoadGredenturesConfig()
+	} else if os.IsNotExist(statErr) && (conf.NoWrite || conf.NoConfigWrite) {
+		slog.Debug("Gredentures config file does not exist, not creating it with --no-write or --no-config-write", "path", conf.Config)
+	} else if os.IsNotExist(statErr) {
 		slog.Debug("Gredentures config file does not exist", "path", conf.Config)
-		// Create a new gredentures config if it doesn't exist
-		return conf.WriteGredenturesConfig()
+		// Create a new gredentures config if it doesn't exist, then pick up any system config
+		if err = conf.createConfig(); err == nil {
+			err = conf.LoadGredenturesConfig()
+		}
 	} else {
-		return fmt.Errorf("error checking config file: %w", err)
+		return fmt.Errorf("error checking config file: %w", statErr)
+	}
+
+	// Keep the flags of this run as defaults only when asked to
+	if err == nil && conf.SaveConfig {
+		err = conf.saveFlags()
+	}
+
+	// Keep the state of every user of a shared host apart, see sysuser.EnableSystemMode
+	if err == nil && conf.SystemMode && !conf.completing {
+		err = sysuser.EnableSystemMode(sysuser.StateRoot())
 	}
+
+	conf.configLoaded = err == nil
+	return err
 }
 
 // LoadGredenturesConfig loads the configuration values from the YAML file into the AppConfig struct.
@@ -152,41 +792,371 @@ func (conf *AppConfig) LoadGredenturesConfig() error {
 		return fmt.Errorf("failed to load existing AppConfig values into koanf: %w", err)
 	}
 
-	// Load the YAML file into koanf
-	if err := k.Load(file.Provider(conf.Config), yaml.Parser()); err != nil {
+	// Merge the YAML file over any shared base configs, each validated against the config schema
+	merged, user, err := conf.loadConfigLayers()
+	if err != nil {
+		return err
+	}
+	if err := k.Merge(merged); err != nil {
 		return fmt.Errorf("failed to load YAML file into koanf: %w", err)
 	}
+	if err := trimConfigValues(k); err != nil {
+		return fmt.Errorf("failed to trim config values: %w", err)
+	}
+
+	// Options only provided by a base config are reported as such by ExplainOptions
+	fileSource := func(name string) string {
+		if unsetValue(user.Get("gredentures." + name)) {
+			return SourceBaseConfig
+		}
+		return SourceConfig
+	}
 
 	// Update AppConfig fields only if they are not already set
-	if conf.Org == "" {
-		conf.Org = k.String("gredentures.Org")
+	fromFile := func(name string, target *string) {
+		if *target == "" && k.String("gredentures."+name) != "" {
+			*target = k.String("gredentures." + name)
+			conf.setSource(name, fileSource(name))
+		}
+	}
+	fromFile("Org", &conf.Org)
+	fromFile("Device", &conf.Device)
+	fromFile("SourceProfile", &conf.SourceProfile)
+	fromFile("SourceFile", &conf.SourceFile)
+	fromFile("TokenCommand", &conf.TokenCommand)
+	fromFile("Prompt", &conf.Prompt)
+	fromFile("Push.RemotePath", &conf.RemotePath)
+	fromFile("LoginMessage", &conf.LoginMessage)
+	fromFile("Proxy", &conf.Proxy)
+	fromFile("CABundle", &conf.CABundle)
+	conf.CABundle = expandPath(conf.CABundle)
+	fromFile("CredentialsFile", &conf.CredentialsFile)
+	conf.CredentialsFile = expandPath(conf.CredentialsFile)
+	fromFile("CurrentProfile", &conf.CurrentProfile)
+	// docopt fills in the default of --output when it is not given, which the Output of the file
+	// overrides unless --no-write or --isolated asks for no output at all.
+	if conf.source("Output") != SourceFlag && !conf.NoWrite && !conf.Isolated && k.String("gredentures.Output") != "" {
+		conf.Output = k.String("gredentures.Output")
+		conf.setSource("Output", fileSource("Output"))
+	}
+	if !conf.SystemMode && k.Bool("gredentures.SystemMode") {
+		conf.SystemMode = true
+		conf.setSource("SystemMode", fileSource("SystemMode"))
+	}
+	if !conf.CompatMode && k.Bool("gredentures.CompatMode") {
+		conf.CompatMode = true
+		conf.setSource("CompatMode", fileSource("CompatMode"))
+	}
+	if !conf.AllowArgvSecrets && k.Bool("gredentures.AllowArgvSecrets") {
+		conf.AllowArgvSecrets = true
+		conf.setSource("AllowArgvSecrets", fileSource("AllowArgvSecrets"))
+	}
+	if !conf.SkipIMDS && k.Bool("gredentures.SkipIMDS") {
+		conf.SkipIMDS = true
+		conf.setSource("SkipIMDS", fileSource("SkipIMDS"))
+	}
+	if conf.CredentialsFiles == nil && len(k.Strings("gredentures.CredentialsFiles")) > 0 {
+		for _, path := range k.Strings("gredentures.CredentialsFiles") {
+			conf.CredentialsFiles = append(conf.CredentialsFiles, expandPath(path))
+		}
+		conf.setSource("CredentialsFiles", fileSource("CredentialsFiles"))
+	}
+	if conf.Favorites == nil && len(k.Strings("gredentures.Favorites")) > 0 {
+		conf.Favorites = k.Strings("gredentures.Favorites")
+		conf.setSource("Favorites", fileSource("Favorites"))
+	}
+	if conf.STSRegions == nil && len(k.Strings("gredentures.STSRegions")) > 0 {
+		conf.STSRegions = k.Strings("gredentures.STSRegions")
+		conf.setSource("STSRegions", fileSource("STSRegions"))
+	}
+	if conf.ManagedPatterns == nil && len(k.Strings("gredentures.ManagedProfiles")) > 0 {
+		for _, pattern := range k.Strings("gredentures.ManagedProfiles") {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid pattern %q in ManagedProfiles: %w", pattern, err)
+			}
+			conf.ManagedPatterns = append(conf.ManagedPatterns, pattern)
+		}
+		conf.setSource("ManagedProfiles", fileSource("ManagedProfiles"))
+	}
+	if conf.AuditLog == "" && k.String("gredentures.AuditLog") != "" {
+		conf.AuditLog = expandPath(k.String("gredentures.AuditLog"))
+		conf.setSource("AuditLog", fileSource("AuditLog"))
+	}
+	// A zero timeout means it was never set, either on the command line or in the file. docopt
+	// fills in the default of --timeout when it is not given, which a timeout in the file overrides.
+	defaulted := conf.TimeoutArg != "" && conf.source("Timeout") != SourceFlag && merged.Exists("gredentures.Timeout")
+	if (conf.Timeout == 0 || defaulted) && k.String("gredentures.Timeout") != "0" {
+		timeout, err := ParseTimeout(k.String("gredentures.Timeout"))
+		if err != nil {
+			return fmt.Errorf("failed to load timeout from config: %w", err)
+		}
+		conf.Timeout = timeout
+		conf.setSource("Timeout", fileSource("Timeout"))
+	}
+	if conf.KeyAge == 0 && k.String("gredentures.KeyMaxAge") != "" && k.String("gredentures.KeyMaxAge") != "0" {
+		age, err := ParseTimeout(k.String("gredentures.KeyMaxAge"))
+		if err != nil {
+			return fmt.Errorf("failed to load KeyMaxAge from config: %w", err)
+		}
+		conf.KeyAge = age
+		conf.setSource("KeyMaxAge", fileSource("KeyMaxAge"))
+	}
+	if conf.Margin == 0 && k.String("gredentures.RefreshMargin") != "" && k.String("gredentures.RefreshMargin") != "0" {
+		margin, err := ParseTimeout(k.String("gredentures.RefreshMargin"))
+		if err != nil {
+			return fmt.Errorf("failed to load RefreshMargin from config: %w", err)
+		}
+		conf.Margin = margin
+		conf.setSource("RefreshMargin", fileSource("RefreshMargin"))
+	}
+	if conf.OnePassword.Item == "" && k.Exists("gredentures.OnePassword") {
+		if err := k.Unmarshal("gredentures.OnePassword", &conf.OnePassword); err != nil {
+			return fmt.Errorf("failed to load 1Password settings from config: %w", err)
+		}
+		conf.setSource("OnePassword", fileSource("OnePassword"))
+	}
+	if conf.KeyStore.Backend == "" && k.Exists("gredentures.KeyStore") {
+		if err := k.Unmarshal("gredentures.KeyStore", &conf.KeyStore); err != nil {
+			return fmt.Errorf("failed to load key store settings from config: %w", err)
+		}
+		conf.KeyStore.File = expandPath(conf.KeyStore.File)
+		conf.setSource("KeyStore", fileSource("KeyStore"))
 	}
-	if conf.Device == "" {
-		conf.Device = k.String("gredentures.Device")
+	if !conf.Stats.Enabled && k.Exists("gredentures.Stats") {
+		if err := k.Unmarshal("gredentures.Stats", &conf.Stats); err != nil {
+			return fmt.Errorf("failed to load stats settings from config: %w", err)
+		}
+		conf.Stats.File = expandPath(conf.Stats.File)
+		conf.setSource("Stats", fileSource("Stats"))
 	}
-	if conf.Timeout == 0 {
-		conf.Timeout = int32(k.Int("gredentures.Timeout"))
+	if conf.Agent.Socket == "" && k.Exists("gredentures.Agent") {
+		if err := k.Unmarshal("gredentures.Agent", &conf.Agent); err != nil {
+			return fmt.Errorf("failed to load agent settings from config: %w", err)
+		}
+		conf.Agent.Socket = expandPath(conf.Agent.Socket)
+		conf.setSource("Agent", fileSource("Agent"))
+	}
+	if conf.Organization.RoleName == "" && conf.Organization.Tags == nil && k.Exists("gredentures.Organization") {
+		if err := k.Unmarshal("gredentures.Organization", &conf.Organization); err != nil {
+			return fmt.Errorf("failed to load organization settings from config: %w", err)
+		}
+		conf.setSource("Organization", fileSource("Organization"))
+	}
+	if conf.SDK.RetryMode == "" && conf.SDK.MaxAttempts == 0 && conf.SDK.CallTimeout == 0 && conf.SDK.Log == nil && conf.SDK.Commands == nil && k.Exists("gredentures.SDK") {
+		if err := unmarshalWithTimeouts(k, "gredentures.SDK", &conf.SDK); err != nil {
+			return fmt.Errorf("failed to load SDK settings from config: %w", err)
+		}
+		conf.setSource("SDK", fileSource("SDK"))
+	}
+	if conf.Orgs == nil && k.Exists("gredentures.Orgs") {
+		if err := unmarshalWithTimeouts(k, "gredentures.Orgs", &conf.Orgs); err != nil {
+			return fmt.Errorf("failed to load orgs from config: %w", err)
+		}
+		conf.setSource("Orgs", fileSource("Orgs"))
+	}
+	if conf.Recipes == nil && k.Exists("gredentures.Recipes") {
+		if err := unmarshalWithTimeouts(k, "gredentures.Recipes", &conf.Recipes); err != nil {
+			return fmt.Errorf("failed to load recipes from config: %w", err)
+		}
+		conf.setSource("Recipes", fileSource("Recipes"))
+	}
+
+	return nil
+}
+
+// unmarshalWithTimeouts decodes the config value at path into target, accepting durations
+// such as "1h" wherever a timeout in seconds is expected.
+func unmarshalWithTimeouts(k *koanf.Koanf, path string, target any) error {
+	return k.UnmarshalWithConf(path, target, koanf.UnmarshalConf{
+		DecoderConfig: &mapstructure.DecoderConfig{
+			DecodeHook:       timeoutHookFunc(),
+			Result:           target,
+			WeaklyTypedInput: true,
+		},
+	})
+}
+
+// RunTokenCommand runs the configured token command through the shell and stores its
+// trimmed output as the MFA token. This lets password managers such as 1Password, pass,
+// or Bitwarden supply the one-time password without bespoke integrations.
+func (config *AppConfig) RunTokenCommand() error {
+	slog.Debug("Running token command", "command", config.TokenCommand)
+	cmd := exec.Command("sh", "-c", config.TokenCommand)
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("token command failed: %w", err)
+	}
+
+	config.Token = secret.Value(strings.TrimSpace(string(out)))
+	if config.Token == "" {
+		return fmt.Errorf("token command produced no output")
 	}
 
 	return nil
 }
 
+// ArgvSecrets returns the options given on the command line that carry a secret: --token with
+// a code, and --proxy with a password. Any user on the host can read the arguments of a
+// process, e.g. with ps or from /proc, for as long as it runs.
+func (config *AppConfig) ArgvSecrets() []string {
+	var secrets []string
+	if config.source("Token") == SourceFlag && !config.TokenStdin && config.Token != "" {
+		secrets = append(secrets, "--token")
+	}
+	if config.source("Proxy") == SourceFlag {
+		if proxy, err := url.Parse(config.Proxy); err == nil {
+			if _, ok := proxy.User.Password(); ok {
+				secrets = append(secrets, "--proxy")
+			}
+		}
+	}
+	return secrets
+}
+
+// ReadToken reads the MFA token given as --token - from the first line of r. It reads a byte
+// at a time and stops at the newline, so any further lines are left for the prompts.
+func (config *AppConfig) ReadToken(r io.Reader) error {
+	var line []byte
+	buf := make([]byte, 1)
+	for {
+		n, err := r.Read(buf)
+		if n == 1 {
+			if buf[0] == '\n' {
+				break
+			}
+			line = append(line, buf[0])
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to read the token from stdin: %w", err)
+		}
+	}
+
+	config.Token = secret.Value(strings.TrimSpace(string(line)))
+	if config.Token == "" {
+		return fmt.Errorf("%w: nothing was piped to stdin for --token %s", ErrMissingToken, TokenFromStdin)
+	}
+	return nil
+}
+
 // ValidateOptions validates the AppConfig fields to ensure all required options are set.
-// It checks for the presence of a token, organization, and device, and returns an error if any are missing.
+// It checks the token, organization, device and timeout together and returns every problem
+// found with them as validate.Errors, so missing options are reported in one go.
 func (config *AppConfig) ValidateOptions() error {
 	slog.Debug("Validating options")
 	if err := config.GetGredenturesConfig(); err != nil {
 		return fmt.Errorf("error getting gredentures config: %w", err)
 	}
+	if err := config.applyRecipe(); err != nil {
+		return err
+	}
+	config.applyOrg()
+	if err := config.renderSessionProfile(); err != nil {
+		return err
+	}
+
+	// Obtain the token from the environment or the token command when it wasn't given directly
+	if token := os.Getenv(TokenEnv); config.Token == "" && token != "" && !config.NoMFA {
+		config.Token = secret.Value(strings.TrimSpace(token))
+		config.setSource("Token", SourceEnv)
+	}
+	if config.Token == "" && config.TokenCommand != "" && !config.NoMFA {
+		if err := config.RunTokenCommand(); err != nil {
+			return err
+		}
+	}
+
+	// Codes copied from authenticator apps may be grouped, as in "123 456"
+	config.Token = secret.Value(NormalizeToken(config.Token.Reveal()))
+
+	// Confirm required values have been found, reporting every missing or malformed one at once.
+	// No token or device is needed with --no-mfa, whether the account allows this is checked with STS.
+	if err := validate.Check(
+		validate.RequiredString{Name: "Org", Value: config.Org, Where: "in a config file or as a commandline option"},
+		validate.When(!config.NoMFA,
+			validate.RequiredString{Name: "Token", Value: config.Token.Reveal(), Err: ErrMissingToken},
+			validate.TokenFormat{Name: "Token", Value: config.Token.Reveal(), Err: ErrInvalidToken},
+			validate.RequiredString{Name: "Device", Value: config.Device, Where: "in a config file or as a commandline option"},
+			validate.Func(func() error {
+				if config.Device == "" {
+					return nil
+				}
+				return ValidateDevice(config.Device)
+			}),
+		),
+		validate.DurationRange{Name: "Timeout", Value: time.Duration(config.Timeout) * time.Second, Min: minSessionTimeout, Max: maxSessionTimeout},
+	); err != nil {
+		return err
+	}
 
-	// Confirm required values have been found
+	sourceProfile, _ := config.Source()
 	switch {
-	case config.Token == "":
-		slog.Debug("Checking for token")
-		return fmt.Errorf("token must be supplied for MFA")
-	case config.Org == "" || config.Device == "":
-		slog.Debug("Checking for org and device")
-		return fmt.Errorf("the Token must be set with a commandline arg. Org, and Device must be set in a config file or as commandline options")
+	case config.Output != "" && !slices.Contains(Outputs, config.Output):
+		return fmt.Errorf("unknown output %q, expected one of %s", config.Output, strings.Join(Outputs, ", "))
+	case config.KeyStore.Backend != "" && !slices.Contains(KeyStores, config.KeyStore.Backend):
+		return fmt.Errorf("unknown KeyStore.Backend %q, expected one of %s", config.KeyStore.Backend, strings.Join(KeyStores, ", "))
+	case config.NoWrite && config.Output != "" && config.Output != OutputINI:
+		return fmt.Errorf("--no-write cannot be combined with --output %s", config.Output)
+	case config.CurrentProfile != "" && config.CurrentProfile == cmp.Or(sourceProfile, "default"):
+		return fmt.Errorf("CurrentProfile %q is the source profile, the alias would overwrite the long-lived keys", config.CurrentProfile)
+	case config.Pull:
+		return fmt.Errorf("--pull is only used with the wsl-sync command")
+	case config.ShowSecrets && !config.NoWrite:
+		return fmt.Errorf("--show-secrets requires --no-write")
+	case config.SaveConfig && (config.NoWrite || config.NoConfigWrite):
+		return fmt.Errorf("--save-config writes the config file and cannot be combined with --no-write or --no-config-write")
+	case config.Isolated && (config.NoWrite || config.Output != "" && config.Output != OutputINI):
+		return fmt.Errorf("--isolated writes a credentials file and cannot be combined with --no-write or --output %s", config.Output)
+	case config.Isolated && config.WSLSync:
+		return fmt.Errorf("--isolated leaves every credentials file untouched and cannot be combined with --wsl-sync")
+	case config.Offline:
+		return fmt.Errorf("--offline only applies to gredentures exec, gredentures status never calls AWS")
+	case config.Renew && config.TokenCommand == "" && !config.NoMFA:
+		return fmt.Errorf("--renew logs in again without asking, it needs a token command, e.g. one reading a TOTP secret or a YubiKey, or --no-mfa")
+	case config.Output == OutputK8sExec && config.Cluster == "":
+		return fmt.Errorf("--output %s requires --cluster", OutputK8sExec)
+	case config.All && len(config.Orgs) == 0 && !config.IsOrganization():
+		slog.Debug("Checking for configured orgs")
+		return fmt.Errorf("--all requires at least one org to be configured under Orgs in the config file, or an AWS Organization ID as the Org")
+	case config.AccountsCmd && !config.IsOrganization():
+		return fmt.Errorf("gredentures accounts requires the Org to be an AWS Organization ID such as o-a1b2c3d4e5, not %q", config.Org)
+	}
+
+	if err := config.LoadSessionPolicy(); err != nil {
+		return err
+	}
+	if err := config.validatePartitions(); err != nil {
+		return err
+	}
+
+	if err := ValidateExternalID(config.ExternalID); err != nil {
+		return fmt.Errorf("--external-id: %w", err)
+	}
+	if err := ValidateProxy(config.Proxy); err != nil {
+		return fmt.Errorf("--proxy: %w", err)
+	}
+
+	// Profile templates are checked up front, only AccountAlias has to wait for the login
+	for name, org := range config.Orgs {
+		if _, err := RenderProfile(org.Profile, org.ProfileData(name)); err != nil && !errors.Is(err, ErrAccountAliasUnknown) {
+			return fmt.Errorf("org %q: %w", name, err)
+		}
+	}
+
+	// Every org must name a role to assume when acquiring credentials for all of them
+	if config.All {
+		var rules []validate.Rule
+		for _, name := range slices.Sorted(maps.Keys(config.Orgs)) {
+			rules = append(rules, validate.RequiredString{Name: "RoleArn", Value: config.Orgs[name].RoleArn,
+				Err: fmt.Errorf("org %q must set a RoleArn to be used with --all", name)})
+		}
+		if err := validate.Check(rules...); err != nil {
+			return err
+		}
 	}
 
 	return nil
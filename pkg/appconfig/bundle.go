@@ -0,0 +1,232 @@
+package appconfig
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	y "gopkg.in/yaml.v3"
+)
+
+// bundleKeys are the keys of the gredentures mapping a config bundle carries: the orgs, recipes
+// and settings a team shares. Paths, files and the state of the machine, such as
+// CredentialsFiles, AuditLog or the Agent socket, are never exported nor imported.
+var bundleKeys = []string{
+	"Org", "Device", "Timeout", "KeyMaxAge", "RefreshMargin", "TokenCommand", "Prompt", "LoginMessage", "ManagedProfiles",
+	"Proxy", "SkipIMDS", "ConfigPublicKeys", "Orgs", "Recipes", "Organization", "OnePassword", "SDK",
+}
+
+// personalKeys are the bundleKeys naming the person who exported the bundle or the secrets
+// they use, left out of bundles unless asked for. The Device of recipes and the proxy user are
+// left out along with them.
+var personalKeys = []string{"Device", "TokenCommand", "OnePassword"}
+
+// confirmKeys are the bundleKeys that run commands, route the credentials through a proxy,
+// decide which signed configs are trusted or which profiles a login deletes. ImportBundle only
+// adds them once confirmed.
+var confirmKeys = []string{"TokenCommand", "Prompt", "Proxy", "ConfigPublicKeys", "ManagedProfiles"}
+
+// bundleHeader is the comment at the top of every exported bundle.
+const bundleHeader = "gredentures config bundle, add it to your config with: gredentures config import <file>"
+
+// ExportBundle returns the shareable part of the user's config file as a bundle, itself a
+// config file: the bundleKeys, with SourceFile left out of orgs and recipes and the password
+// left out of the proxy. The personalKeys and the proxy user are left out too, unless personal
+// is set. Comments are kept. Base configs are not included, the new hire gets them anyway.
+func (conf *AppConfig) ExportBundle(personal bool) ([]byte, error) {
+	conf.resolveConfigPath()
+	data, err := conf.readConfig(conf.Config, nil)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = conf.migrateConfigLayer(conf.Config, data); err != nil {
+		return nil, err
+	}
+	section, err := gredenturesSection(data)
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", conf.Config, err)
+	}
+
+	shared := &y.Node{Kind: y.MappingNode}
+	for i := 0; i+1 < len(section.Content); i += 2 {
+		key, value := section.Content[i], section.Content[i+1]
+		if !slices.Contains(bundleKeys, key.Value) || !personal && slices.Contains(personalKeys, key.Value) || unsetNode(value) {
+			continue
+		}
+		switch key.Value {
+		case "Orgs", "Recipes":
+			for j := 1; j < len(value.Content); j += 2 {
+				removeEntry(value.Content[j], "SourceFile")
+				if !personal {
+					removeEntry(value.Content[j], "Device")
+				}
+			}
+		case "Proxy":
+			value.SetString(redactProxy(value.Value, !personal))
+		}
+		shared.Content = append(shared.Content, key, value)
+	}
+
+	root := &y.Node{Kind: y.MappingNode, Content: []*y.Node{
+		{Kind: y.ScalarNode, Value: configVersionKey, HeadComment: bundleHeader},
+		{Kind: y.ScalarNode, Tag: "!!int", Value: fmt.Sprint(ConfigVersion)},
+		{Kind: y.ScalarNode, Value: "gredentures"}, shared,
+	}}
+	var out bytes.Buffer
+	encoder := y.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&y.Node{Kind: y.DocumentNode, Content: []*y.Node{root}}); err != nil {
+		return nil, fmt.Errorf("failed to marshal the bundle: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+// ImportBundle adds the settings of a bundle written by ExportBundle to the config file and
+// returns the dotted names of those added, then of those skipped. Settings the config file
+// already has, including orgs and recipes of the same name, are kept and skipped, as are keys
+// other than bundleKeys. The confirmKeys are left out of both unless confirmed, see
+// BundleConfirmations. Every other key and comment in the file is kept.
+func (conf *AppConfig) ImportBundle(data []byte, confirmed bool) (added, skipped []string, err error) {
+	bundle, err := bundleSection(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = conf.editConfig(func(section *y.Node) error {
+		for i := 0; i+1 < len(bundle.Content); i += 2 {
+			key, value := bundle.Content[i], bundle.Content[i+1]
+			if !slices.Contains(bundleKeys, key.Value) {
+				skipped = append(skipped, key.Value)
+				continue
+			}
+			if !confirmed && slices.Contains(confirmKeys, key.Value) {
+				continue
+			}
+			if value.Kind != y.MappingNode || key.Value != "Orgs" && key.Value != "Recipes" {
+				if current := entryValue(section, key.Value); current != nil && !unsetNode(current) {
+					skipped = append(skipped, key.Value)
+					continue
+				}
+				removeEntry(section, key.Value)
+				section.Content = append(section.Content, key, value)
+				added = append(added, key.Value)
+				continue
+			}
+
+			entries, err := mappingEntry(section, key.Value, y.MappingNode)
+			if err != nil {
+				return err
+			}
+			for j := 0; j+1 < len(value.Content); j += 2 {
+				name := key.Value + "." + value.Content[j].Value
+				if hasEntry(entries, value.Content[j].Value) {
+					skipped = append(skipped, name)
+					continue
+				}
+				entries.Content = append(entries.Content, value.Content[j], value.Content[j+1])
+				added = append(added, name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return added, skipped, nil
+}
+
+// BundleConfirmations returns the confirmKeys a bundle sets, as "<key>: <value>" lines to
+// show before asking whether ImportBundle may add them.
+func BundleConfirmations(data []byte) ([]string, error) {
+	bundle, err := bundleSection(data)
+	if err != nil {
+		return nil, err
+	}
+	var settings []string
+	for i := 0; i+1 < len(bundle.Content); i += 2 {
+		key, value := bundle.Content[i], bundle.Content[i+1]
+		if !slices.Contains(confirmKeys, key.Value) || unsetNode(value) {
+			continue
+		}
+		values := []string{value.Value}
+		if value.Kind == y.SequenceNode {
+			values = values[:0]
+			for _, entry := range value.Content {
+				values = append(values, entry.Value)
+			}
+		}
+		settings = append(settings, fmt.Sprintf("%s: %s", key.Value, strings.Join(values, ", ")))
+	}
+	return settings, nil
+}
+
+// bundleSection upgrades and validates the data of a bundle and returns its gredentures mapping.
+func bundleSection(data []byte) (*y.Node, error) {
+	data, _, err := migrateConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("bundle: %w", err)
+	}
+	if err := ValidateConfig(data); err != nil {
+		return nil, fmt.Errorf("invalid bundle:\n%w", err)
+	}
+	bundle, err := gredenturesSection(data)
+	if err != nil {
+		return nil, fmt.Errorf("bundle: %w", err)
+	}
+	return bundle, nil
+}
+
+// gredenturesSection returns the gredentures mapping of YAML config data, an empty one when
+// the data has none.
+func gredenturesSection(data []byte) (*y.Node, error) {
+	var doc y.Node
+	if err := y.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid YAML: %w", err)
+	}
+	if len(doc.Content) == 0 {
+		return &y.Node{Kind: y.MappingNode}, nil
+	}
+	return mappingEntry(doc.Content[0], "gredentures", y.MappingNode)
+}
+
+// entryValue returns the value of key in a YAML mapping, nil when it has none.
+func entryValue(mapping *y.Node, key string) *y.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// removeEntry removes key from a YAML mapping, if it holds it.
+func removeEntry(mapping *y.Node, key string) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content = slices.Delete(mapping.Content, i, i+2)
+			return
+		}
+	}
+}
+
+// unsetNode reports whether a YAML value is null or empty, e.g. the `Org: ""` written to a new
+// config file, and so counts as unset.
+func unsetNode(node *y.Node) bool {
+	return node.Kind == y.ScalarNode && (node.Tag == "!!null" || node.Value == "")
+}
+
+// redactProxy removes the password from a proxy URL, and with redact the user as well.
+func redactProxy(proxy string, redact bool) string {
+	parsed, err := url.Parse(proxy)
+	if err != nil || parsed.User == nil {
+		return proxy
+	}
+	if redact {
+		parsed.User = nil
+	} else {
+		parsed.User = url.User(parsed.User.Username())
+	}
+	return parsed.String()
+}
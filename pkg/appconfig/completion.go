@@ -0,0 +1,196 @@
+package appconfig
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// CompleteCommand is the hidden command the completion scripts run with the words typed so
+// far, printing the candidates for the last of them one per line, like kubectl __complete.
+const CompleteCommand = "__complete"
+
+// Shells lists the shells "gredentures completion" prints a script for.
+var Shells = []string{"bash", "zsh", "fish"}
+
+// completionScripts holds the completion script of each of Shells. Every script hands the
+// words to CompleteCommand, shows the description after the tab of a candidate where the shell
+// can, and falls back to file names when it offers nothing.
+var completionScripts = map[string]string{
+	"bash": `# gredentures completion for bash, load with: source <(gredentures completion bash)
+_gredentures() {
+    local IFS=$'\n'
+    COMPREPLY=($(gredentures __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null | cut -f1))
+}
+complete -o default -F _gredentures gredentures
+`,
+	"zsh": `#compdef gredentures
+# gredentures completion for zsh, load with: source <(gredentures completion zsh)
+_gredentures() {
+    local -a candidates names descriptions
+    candidates=("${(@f)$(gredentures __complete "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+    if [[ -n ${candidates[1]} ]]; then
+        names=("${(@)candidates%%$'\t'*}")
+        descriptions=("${(@)candidates//$'\t'/  -- }")
+        compadd -l -d descriptions -a names
+    else
+        _files
+    fi
+}
+compdef _gredentures gredentures
+`,
+	"fish": `# gredentures completion for fish, load with: gredentures completion fish | source
+function __gredentures_complete
+    gredentures __complete (commandline -opc)[2..-1] (commandline -ct) 2>/dev/null
+end
+complete -c gredentures -a '(__gredentures_complete)'
+`,
+}
+
+// CompletionScript returns the completion script of shell, one of Shells.
+func CompletionScript(shell string) (string, error) {
+	script, ok := completionScripts[shell]
+	if !ok {
+		return "", fmt.Errorf("no completion script for %s, expected one of %s", shell, strings.Join(Shells, ", "))
+	}
+	return script, nil
+}
+
+// optionPattern matches an option of the Options section of Usage and the placeholder of its
+// value, if it takes one.
+var optionPattern = regexp.MustCompile(`^(--?[\w-]+)( <[^>]+>)?(, )?`)
+
+// usageWords returns the commands of Usage, each with the words that may follow it such as
+// config's migrate and explain, and the long options with whether each takes a value.
+func usageWords() (commands map[string][]string, options map[string]bool) {
+	commands, options = map[string][]string{}, map[string]bool{}
+	section := ""
+	for _, line := range strings.Split(Usage, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasSuffix(trimmed, ":") {
+			section = trimmed
+			continue
+		}
+		switch section {
+		case "Usage:":
+			words := strings.Fields(strings.NewReplacer("(", " ", ")", " ", "|", " ", "[", " ", "]", " ").Replace(trimmed))
+			if len(words) < 2 || strings.HasPrefix(words[1], "-") {
+				continue
+			}
+			command := words[1]
+			if _, ok := commands[command]; !ok {
+				commands[command] = nil
+			}
+			for _, word := range words[2:] {
+				if !strings.HasPrefix(word, "-") && !strings.HasPrefix(word, "<") && !slices.Contains(commands[command], word) && word != "options" {
+					commands[command] = append(commands[command], word)
+				}
+			}
+		case "Options:":
+			for rest := trimmed; ; {
+				match := optionPattern.FindStringSubmatch(rest)
+				if match == nil {
+					break
+				}
+				if strings.HasPrefix(match[1], "--") {
+					options[match[1]] = match[2] != ""
+				}
+				rest = rest[len(match[0]):]
+			}
+		}
+	}
+	return commands, options
+}
+
+// Complete loads the config file named in words, or found as usual, without ever creating or
+// upgrading it, and returns the Completions of words. It runs on every Tab, so a remote config
+// file is read from the cache without asking the server, and SystemMode is not set up.
+func Complete(words []string) []string {
+	config := &AppConfig{Profile: "default-mfa", NoConfigWrite: true, completing: true}
+	for i, word := range words[:max(len(words)-1, 0)] {
+		if word == "-c" || word == "--config" {
+			config.Config = expandPath(words[i+1])
+		} else if value, ok := strings.CutPrefix(word, "--config="); ok {
+			config.Config = expandPath(value)
+		}
+	}
+	if err := config.GetGredenturesConfig(); err != nil {
+		slog.Debug("Completing without the config file", "err", err)
+	}
+	return config.Completions(words)
+}
+
+// Completions returns the candidates for the last of words, the command line typed so far
+// without the program name: the commands and options of Usage, and the org names, login
+// recipes and managed profiles of the config file where an option or command takes one. An org
+// is followed by a tab and the name of its role, which the scripts show as its description.
+// Nothing is returned where a file name or free-form value goes, leaving it to the shell.
+func (config AppConfig) Completions(words []string) []string {
+	commands, options := usageWords()
+	current, typed := "", words
+	if len(words) > 0 {
+		current, typed = words[len(words)-1], words[:len(words)-1]
+	}
+
+	// Find the commands typed so far, skipping the options and their values
+	var args []string
+	valueOf := ""
+	for _, word := range typed {
+		if word == "--" {
+			return nil // The exec command is completed by the shell
+		}
+		if valueOf != "" {
+			valueOf = ""
+			continue
+		}
+		if long, ok := shortFlags[word]; ok {
+			word = long
+		}
+		if strings.HasPrefix(word, "-") {
+			if options[word] {
+				valueOf = word
+			}
+			continue
+		}
+		args = append(args, word)
+	}
+
+	var candidates []string
+	describe := func(string) string { return "" }
+	switch {
+	case valueOf == "--org":
+		candidates = slices.Sorted(maps.Keys(config.Orgs))
+		describe = func(name string) string { return config.Orgs[name].ProfileData(name).Role }
+	case valueOf == "--profile":
+		candidates = config.ManagedProfiles()
+	case valueOf == "--output":
+		candidates = Outputs
+	case valueOf != "":
+		return nil
+	case strings.HasPrefix(current, "-"):
+		candidates = slices.Sorted(maps.Keys(options))
+	case len(args) == 0:
+		candidates = slices.Sorted(maps.Keys(commands))
+	case len(args) == 1 && args[0] == "login":
+		candidates = slices.Sorted(maps.Keys(config.Recipes))
+	case len(args) == 1 && slices.Contains([]string{"show", "switch", "import"}, args[0]):
+		candidates = config.ManagedProfiles()
+	case len(args) == 1:
+		candidates = commands[args[0]]
+	}
+
+	var matches []string
+	for _, candidate := range candidates {
+		if !strings.HasPrefix(candidate, current) || slices.Contains(matches, candidate) {
+			continue
+		}
+		if description := describe(candidate); description != "" {
+			candidate += "\t" + description
+		}
+		matches = append(matches, candidate)
+	}
+	return matches
+}
@@ -0,0 +1,78 @@
+package appconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompletions(t *testing.T) {
+	config := AppConfig{
+		Profile: "default-mfa",
+		Orgs: map[string]OrgConfig{
+			"prod": {RoleArn: "arn:aws:iam::111111111111:role/Admin"},
+			"dev":  {RoleArn: "arn:aws:iam::222222222222:role/Dev"},
+		},
+		Recipes: map[string]RecipeConfig{"prod-admin": {}},
+	}
+
+	tests := []struct {
+		name  string
+		words []string
+		want  []string
+	}{
+		{"Commands", []string{"s"}, []string{"serve", "sessions", "show", "stats", "status", "switch"}},
+		{"Subcommands", []string{"config", ""}, []string{"migrate", "explain", "path", "lint", "export", "import"}},
+		{"Shells", []string{"completion", ""}, Shells},
+		{"Long options", []string{"--pro"}, []string{"--profile", "--prompt", "--proxy"}},
+		{"Org names with their roles", []string{"-o", ""}, []string{"dev\tDev", "prod\tAdmin"}},
+		{"Org names after other options", []string{"login", "-c", "config.yml", "--org", "p"}, []string{"prod\tAdmin"}},
+		{"Recipes", []string{"-v", "login", ""}, []string{"prod-admin"}},
+		{"Managed profiles", []string{"--profile", "prod"}, []string{"prod-mfa", "prod-admin"}},
+		{"Profiles to switch to", []string{"switch", "def"}, []string{"default-mfa"}},
+		{"Outputs", []string{"--output", "k"}, []string{"keychain", "k8s-exec"}},
+		{"Free-form values", []string{"--token", ""}, nil},
+		{"Exec commands", []string{"exec", "--", "a"}, nil},
+		{"Nothing after a full command", []string{"status", ""}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := config.Completions(test.words)
+			if test.name == "Managed profiles" {
+				assert.ElementsMatch(t, test.want, got)
+				return
+			}
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestComplete(t *testing.T) {
+	resetLogging()
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	t.Setenv("XDG_CONFIG_HOME", dir)
+
+	path := filepath.Join(dir, "custom.yml")
+	require.NoError(t, os.WriteFile(path, []byte(`gredentures:
+  Orgs:
+    acme:
+      RoleArn: arn:aws:iam::111111111111:role/Admin
+`), 0o600))
+	assert.Equal(t, []string{"acme\tAdmin"}, Complete([]string{"--config", path, "--org", ""}))
+	assert.Nil(t, Complete([]string{"-c", filepath.Join(dir, "missing.yml"), "-o", ""}))
+	assert.NoFileExists(t, filepath.Join(dir, "missing.yml"), "completing never creates the config file")
+}
+
+func TestCompletionScript(t *testing.T) {
+	for _, shell := range Shells {
+		script, err := CompletionScript(shell)
+		require.NoError(t, err)
+		assert.Contains(t, script, "gredentures "+CompleteCommand, "the %s script calls back for candidates", shell)
+	}
+	_, err := CompletionScript("tcsh")
+	assert.Error(t, err)
+}
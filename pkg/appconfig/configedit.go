@@ -0,0 +1,204 @@
+package appconfig
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	y "gopkg.in/yaml.v3"
+)
+
+// SaveDevice stores device as the Device in the config file and in conf. Only that key is
+// changed, every other key and comment in the file is kept.
+func (conf *AppConfig) SaveDevice(device string) error {
+	if err := ValidateDevice(device); err != nil {
+		return err
+	}
+	if err := conf.setConfigValue("Device", device); err != nil {
+		return err
+	}
+	conf.Device = device
+	conf.setSource("Device", SourceConfig)
+	return nil
+}
+
+// SaveOrgs adds orgs to the Orgs of the config file and of conf, writing the RoleArn and
+// Profile of each, and returns the names of the orgs added. Orgs that already exist are left
+// untouched, every other key and comment in the file is kept.
+func (conf *AppConfig) SaveOrgs(orgs map[string]OrgConfig) ([]string, error) {
+	for name := range orgs {
+		if strings.Contains(name, ".") {
+			return nil, fmt.Errorf("org name %q must not contain a dot", name)
+		}
+	}
+
+	var added []string
+	err := conf.editConfig(func(section *y.Node) error {
+		orgsNode, err := mappingEntry(section, "Orgs", y.MappingNode)
+		if err != nil {
+			return err
+		}
+		for _, name := range slices.Sorted(maps.Keys(orgs)) {
+			if _, ok := conf.Orgs[name]; ok || hasEntry(orgsNode, name) {
+				continue
+			}
+			org, err := mappingEntry(orgsNode, name, y.MappingNode)
+			if err != nil {
+				return err
+			}
+			for _, field := range [][2]string{{"RoleArn", orgs[name].RoleArn}, {"Profile", orgs[name].Profile}} {
+				if field[1] == "" {
+					continue
+				}
+				value, err := mappingEntry(org, field[0], y.ScalarNode)
+				if err != nil {
+					return err
+				}
+				value.SetString(field[1])
+			}
+			added = append(added, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if conf.Orgs == nil {
+		conf.Orgs = map[string]OrgConfig{}
+	}
+	for _, name := range added {
+		conf.Orgs[name] = orgs[name]
+	}
+	conf.setSource("Orgs", SourceConfig)
+	return added, nil
+}
+
+// SaveFavorite adds profile to the Favorites of the config file and of conf, or with pin unset
+// removes it from them. Every other key and comment in the file is kept.
+func (conf *AppConfig) SaveFavorite(profile string, pin bool) error {
+	err := conf.editConfig(func(section *y.Node) error {
+		favorites, err := mappingEntry(section, "Favorites", y.SequenceNode)
+		if err != nil {
+			return err
+		}
+		index := slices.IndexFunc(favorites.Content, func(item *y.Node) bool { return item.Value == profile })
+		switch {
+		case pin && index < 0:
+			favorites.Content = append(favorites.Content, &y.Node{Kind: y.ScalarNode, Value: profile})
+		case !pin && index >= 0:
+			favorites.Content = slices.Delete(favorites.Content, index, index+1)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	conf.Favorites = slices.DeleteFunc(conf.Favorites, func(name string) bool { return name == profile })
+	if pin {
+		conf.Favorites = append(conf.Favorites, profile)
+	}
+	conf.setSource("Favorites", SourceConfig)
+	return nil
+}
+
+// setConfigValue sets a scalar key under gredentures in the config file, creating the file
+// and the gredentures mapping when they do not exist yet.
+func (conf *AppConfig) setConfigValue(key, value string) error {
+	return conf.editConfig(func(section *y.Node) error {
+		field, err := mappingEntry(section, key, y.ScalarNode)
+		if err != nil {
+			return err
+		}
+		field.SetString(value)
+		return nil
+	})
+}
+
+// editConfig applies change to the gredentures mapping of the config file and writes the
+// file back, creating the file and the mapping when they do not exist yet.
+func (conf *AppConfig) editConfig(change func(section *y.Node) error) error {
+	conf.resolveConfigPath()
+	if err := conf.checkConfigWritable(); err != nil {
+		return err
+	}
+
+	var doc y.Node
+	data, err := os.ReadFile(conf.Config)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	if err := y.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("invalid YAML in config file %s: %w", conf.Config, err)
+	}
+	if len(doc.Content) == 0 {
+		doc = y.Node{Kind: y.DocumentNode, Content: []*y.Node{{Kind: y.MappingNode}}}
+	}
+
+	section, err := mappingEntry(doc.Content[0], "gredentures", y.MappingNode)
+	if err != nil {
+		return err
+	}
+	if err := change(section); err != nil {
+		return err
+	}
+	return conf.writeConfigDocument(&doc)
+}
+
+// writeConfigDocument writes a YAML document to the config file, creating its directory.
+func (conf *AppConfig) writeConfigDocument(doc *y.Node) error {
+	var out bytes.Buffer
+	encoder := y.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return fmt.Errorf("failed to marshal configuration to YAML: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(conf.Config), 0o755); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	if err := os.WriteFile(conf.Config, out.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("failed to write configuration to file: %w", err)
+	}
+	return nil
+}
+
+// hasEntry reports whether a YAML mapping holds key.
+func hasEntry(mapping *y.Node, key string) bool {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return true
+		}
+	}
+	return false
+}
+
+// mappingEntry returns the value of key in a YAML mapping, adding an empty value of the given
+// kind when the key is missing or unset.
+func mappingEntry(mapping *y.Node, key string, kind y.Kind) (*y.Node, error) {
+	if mapping.Kind != y.MappingNode {
+		return nil, fmt.Errorf("line %d: expected a mapping to hold %q", mapping.Line, key)
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value != key {
+			continue
+		}
+		value := mapping.Content[i+1]
+		if value.Kind == y.ScalarNode && value.Tag == "!!null" {
+			*value = y.Node{Kind: kind}
+		}
+		if value.Kind != kind {
+			return nil, fmt.Errorf("line %d: %q has an unexpected type", value.Line, key)
+		}
+		return value, nil
+	}
+
+	value := &y.Node{Kind: kind}
+	mapping.Content = append(mapping.Content, &y.Node{Kind: y.ScalarNode, Value: key}, value)
+	return value, nil
+}
@@ -0,0 +1,140 @@
+package appconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSaveDevice(t *testing.T) {
+	const device = "arn:aws:iam::123456789012:mfa/alice"
+
+	t.Run("Keeps other keys and comments", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config.yml")
+		assert.NoError(t, os.WriteFile(path, []byte(`# Team settings
+gredentures:
+  Org: my-org # primary org
+  Device: arn:aws:iam::123456789012:mfa/old
+  Orgs:
+    prod:
+      RoleArn: arn:aws:iam::111111111111:role/Prod
+`), 0o644))
+
+		conf := &AppConfig{Config: path}
+		assert.NoError(t, conf.SaveDevice(device))
+		assert.Equal(t, device, conf.Device)
+
+		data, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.Equal(t, `# Team settings
+gredentures:
+  Org: my-org # primary org
+  Device: arn:aws:iam::123456789012:mfa/alice
+  Orgs:
+    prod:
+      RoleArn: arn:aws:iam::111111111111:role/Prod
+`, string(data))
+		assert.NoError(t, ValidateConfig(data))
+	})
+
+	t.Run("Creates the file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "gredentures", "config.yml")
+		assert.NoError(t, (&AppConfig{Config: path}).SaveDevice(device))
+
+		data, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.Equal(t, "gredentures:\n  Device: "+device+"\n", string(data))
+	})
+
+	t.Run("Fills an empty gredentures section", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config.yml")
+		assert.NoError(t, os.WriteFile(path, []byte("gredentures:\n"), 0o644))
+		assert.NoError(t, (&AppConfig{Config: path}).SaveDevice(device))
+
+		data, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.Equal(t, "gredentures:\n  Device: "+device+"\n", string(data))
+	})
+
+	t.Run("Rejects invalid devices and files", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config.yml")
+		assert.ErrorIs(t, (&AppConfig{Config: path}).SaveDevice("not a device"), ErrInvalidDevice)
+
+		assert.NoError(t, os.WriteFile(path, []byte("gredentures: [a, b]\n"), 0o644))
+		assert.ErrorContains(t, (&AppConfig{Config: path}).SaveDevice(device), `"gredentures" has an unexpected type`)
+
+		remote := &AppConfig{Config: "https://example.com/config.yml"}
+		assert.ErrorContains(t, remote.SaveDevice(device), "cannot write to the remote config file")
+	})
+}
+
+func TestSaveOrgs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	assert.NoError(t, os.WriteFile(path, []byte(`gredentures:
+  Orgs:
+    prod: # hand written
+      RoleArn: arn:aws:iam::111111111111:role/Prod
+`), 0o644))
+
+	conf := &AppConfig{Config: path}
+	added, err := conf.SaveOrgs(map[string]OrgConfig{
+		"prod":    {RoleArn: "arn:aws:iam::111111111111:role/Other"},
+		"staging": {RoleArn: "arn:aws:iam::222222222222:role/Deploy"},
+		"audit":   {RoleArn: "arn:aws:iam::333333333333:role/ReadOnly", Profile: "audit"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"audit", "staging"}, added)
+	assert.Equal(t, "arn:aws:iam::222222222222:role/Deploy", conf.Orgs["staging"].RoleArn)
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, `gredentures:
+  Orgs:
+    prod: # hand written
+      RoleArn: arn:aws:iam::111111111111:role/Prod
+    audit:
+      RoleArn: arn:aws:iam::333333333333:role/ReadOnly
+      Profile: audit
+    staging:
+      RoleArn: arn:aws:iam::222222222222:role/Deploy
+`, string(data))
+	assert.NoError(t, ValidateConfig(data))
+
+	_, err = conf.SaveOrgs(map[string]OrgConfig{"a.b": {RoleArn: "arn:aws:iam::111111111111:role/Prod"}})
+	assert.ErrorContains(t, err, "must not contain a dot")
+}
+
+func TestSaveFavorite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	assert.NoError(t, os.WriteFile(path, []byte(`version: 2
+gredentures:
+  Org: my-org # primary org
+  Favorites:
+    - dev-mfa
+`), 0o644))
+
+	conf := &AppConfig{Config: path}
+	assert.NoError(t, conf.LoadGredenturesConfig())
+	assert.NoError(t, conf.SaveFavorite("prod-mfa", true))
+	assert.NoError(t, conf.SaveFavorite("prod-mfa", true))
+	assert.Equal(t, []string{"dev-mfa", "prod-mfa"}, conf.Favorites)
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, `version: 2
+gredentures:
+  Org: my-org # primary org
+  Favorites:
+    - dev-mfa
+    - prod-mfa
+`, string(data))
+	assert.NoError(t, ValidateConfig(data))
+
+	assert.NoError(t, conf.SaveFavorite("dev-mfa", false))
+	assert.Equal(t, []string{"prod-mfa"}, conf.Favorites)
+	reloaded := &AppConfig{Config: path}
+	assert.NoError(t, reloaded.LoadGredenturesConfig())
+	assert.Equal(t, []string{"prod-mfa"}, reloaded.Favorites)
+}
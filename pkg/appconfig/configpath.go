@@ -0,0 +1,84 @@
+package appconfig
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"gredentures/pkg/sysuser"
+)
+
+// Origins of the config file path reported by ConfigPath.
+const (
+	ConfigOriginFlag    = "--config flag"
+	ConfigOriginXDG     = "XDG config directory"
+	ConfigOriginDotfile = "legacy dotfile in the home directory"
+	ConfigOriginNew     = "no config file found, a new one is created in the XDG config directory"
+)
+
+// XDGConfigPath returns the config file location under the XDG base directory spec,
+// $XDG_CONFIG_HOME/gredentures/config.yml, falling back to ~/.config when the variable is unset.
+func XDGConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if !filepath.IsAbs(dir) { // The spec says relative paths are invalid and must be ignored
+		dir = filepath.Join(sysuser.Home(), ".config")
+	}
+	return filepath.Join(dir, "gredentures", "config.yml")
+}
+
+// DotfileConfigPath returns the config file location used by older releases, ~/.gredentures.yml.
+func DotfileConfigPath() string {
+	return filepath.Join(sysuser.Home(), ".gredentures.yml")
+}
+
+// configCandidate is a config file location that is used when it exists.
+type configCandidate struct {
+	path   string // Config file path.
+	origin string // Reported by ConfigPath when the file is used.
+}
+
+// configCandidates lists the config files looked for, in order, when --config is not given.
+func configCandidates() []configCandidate {
+	return []configCandidate{
+		{XDGConfigPath(), ConfigOriginXDG},
+		{DotfileConfigPath(), ConfigOriginDotfile},
+	}
+}
+
+// ConfigSearchPath lists the config files looked for, in order, when --config is not given.
+func ConfigSearchPath() []string {
+	var paths []string
+	for _, candidate := range configCandidates() {
+		paths = append(paths, candidate.path)
+	}
+	return paths
+}
+
+// ConfigPath resolves the config file in use and returns it along with the reason it was chosen.
+func (conf *AppConfig) ConfigPath() (path, origin string) {
+	conf.resolveConfigPath()
+	return conf.Config, conf.configOrigin
+}
+
+// resolveConfigPath picks the config file: an explicit --config path wins, then the first file
+// on ConfigSearchPath that exists. When none exists the XDG location is used, so new config
+// files no longer land in the home directory. Environment variables in the path are expanded.
+func (conf *AppConfig) resolveConfigPath() {
+	if conf.configOrigin != "" {
+		return // Already resolved
+	}
+
+	if conf.Config != "" {
+		conf.Config, conf.configOrigin = os.ExpandEnv(conf.Config), ConfigOriginFlag
+		return
+	}
+
+	conf.Config, conf.configOrigin = XDGConfigPath(), ConfigOriginNew
+	for _, candidate := range configCandidates() {
+		if _, err := os.Stat(candidate.path); err == nil {
+			conf.Config, conf.configOrigin = candidate.path, candidate.origin
+			break
+		}
+	}
+	slog.Debug("Resolved config file", "path", conf.Config, "origin", conf.configOrigin)
+}
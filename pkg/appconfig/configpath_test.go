@@ -0,0 +1,84 @@
+package appconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestXDGConfigPath(t *testing.T) {
+	t.Setenv("HOME", "/home/test")
+
+	t.Setenv("XDG_CONFIG_HOME", "/xdg")
+	assert.Equal(t, "/xdg/gredentures/config.yml", XDGConfigPath())
+
+	t.Setenv("XDG_CONFIG_HOME", "")
+	assert.Equal(t, "/home/test/.config/gredentures/config.yml", XDGConfigPath())
+
+	t.Setenv("XDG_CONFIG_HOME", "relative/dir")
+	assert.Equal(t, "/home/test/.config/gredentures/config.yml", XDGConfigPath(), "relative paths are ignored")
+}
+
+func TestConfigPath(t *testing.T) {
+	setup := func(t *testing.T, files ...string) string {
+		home := t.TempDir()
+		t.Setenv("HOME", home)
+		t.Setenv("XDG_CONFIG_HOME", "")
+		for _, file := range files {
+			path := filepath.Join(home, file)
+			assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+			assert.NoError(t, os.WriteFile(path, nil, 0o644))
+		}
+		return home
+	}
+
+	t.Run("Explicit path wins", func(t *testing.T) {
+		home := setup(t, ".config/gredentures/config.yml", ".gredentures.yml")
+		conf := &AppConfig{Config: "$HOME/custom.yml"}
+		path, origin := conf.ConfigPath()
+		assert.Equal(t, filepath.Join(home, "custom.yml"), path)
+		assert.Equal(t, ConfigOriginFlag, origin)
+	})
+
+	t.Run("XDG before the dotfile", func(t *testing.T) {
+		home := setup(t, ".config/gredentures/config.yml", ".gredentures.yml")
+		path, origin := (&AppConfig{}).ConfigPath()
+		assert.Equal(t, filepath.Join(home, ".config", "gredentures", "config.yml"), path)
+		assert.Equal(t, ConfigOriginXDG, origin)
+	})
+
+	t.Run("Falls back to the dotfile", func(t *testing.T) {
+		home := setup(t, ".gredentures.yml")
+		path, origin := (&AppConfig{}).ConfigPath()
+		assert.Equal(t, filepath.Join(home, ".gredentures.yml"), path)
+		assert.Equal(t, ConfigOriginDotfile, origin)
+	})
+
+	t.Run("New files go to the XDG directory", func(t *testing.T) {
+		home := setup(t)
+		conf := &AppConfig{Org: "new-org"}
+		path, origin := conf.ConfigPath()
+		assert.Equal(t, filepath.Join(home, ".config", "gredentures", "config.yml"), path)
+		assert.Equal(t, ConfigOriginNew, origin)
+
+		assert.NoError(t, conf.GetGredenturesConfig())
+		assert.FileExists(t, path)
+		assert.NoFileExists(t, filepath.Join(home, ".gredentures.yml"))
+
+		// Resolving again keeps the path instead of treating it as a --config flag
+		_, origin = conf.ConfigPath()
+		assert.Equal(t, ConfigOriginNew, origin)
+	})
+}
+
+func TestParseConfigPath(t *testing.T) {
+	resetLogging()
+
+	config := &AppConfig{}
+	assert.NoError(t, config.Parse([]string{"config", "path"}))
+	assert.True(t, config.ConfigCmd)
+	assert.True(t, config.ShowPath)
+	assert.Empty(t, config.Config, "no default path is bound, so the search path applies")
+}
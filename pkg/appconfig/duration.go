@@ -0,0 +1,75 @@
+package appconfig
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// STS issues session tokens lasting between 15 minutes and 36 hours.
+const (
+	minSessionTimeout = 15 * time.Minute
+	maxSessionTimeout = 36 * time.Hour
+)
+
+// ParseTimeout converts a timeout value into whole seconds. It accepts raw seconds
+// ("43200") as well as Go durations ("12h", "90m") extended with a day unit ("1d", "1d12h").
+func ParseTimeout(value string) (int32, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, fmt.Errorf("timeout must not be empty")
+	}
+
+	// Raw seconds remain supported for backwards compatibility
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return checkTimeout(value, time.Duration(seconds)*time.Second)
+	}
+
+	var total time.Duration
+	rest := value
+	if days, after, found := strings.Cut(value, "d"); found {
+		n, err := strconv.ParseUint(days, 10, 16)
+		if err != nil {
+			return 0, fmt.Errorf("invalid timeout %q: bad day count", value)
+		}
+		total = time.Duration(n) * 24 * time.Hour
+		rest = after
+	}
+	if rest != "" {
+		d, err := time.ParseDuration(rest)
+		if err != nil {
+			return 0, fmt.Errorf("invalid timeout %q: %w", value, err)
+		}
+		total += d
+	}
+
+	return checkTimeout(value, total)
+}
+
+// checkTimeout ensures a parsed timeout is positive and fits in the int32 seconds used by STS.
+func checkTimeout(value string, d time.Duration) (int32, error) {
+	seconds := int64(d / time.Second)
+	if seconds <= 0 {
+		return 0, fmt.Errorf("invalid timeout %q: must be at least one second", value)
+	}
+	if seconds > math.MaxInt32 {
+		return 0, fmt.Errorf("invalid timeout %q: too large", value)
+	}
+	return int32(seconds), nil
+}
+
+// timeoutHookFunc lets timeout fields decoded from the config file use the same
+// duration syntax as --timeout. Every int32 in the config schema is a timeout.
+func timeoutHookFunc() mapstructure.DecodeHookFuncType {
+	return func(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+		if from.Kind() != reflect.String || to.Kind() != reflect.Int32 {
+			return data, nil
+		}
+		return ParseTimeout(data.(string))
+	}
+}
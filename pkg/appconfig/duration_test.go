@@ -0,0 +1,41 @@
+package appconfig
+
+import (
+	"testing"
+)
+
+func TestParseTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected int32
+		wantErr  bool
+	}{
+		{"Raw seconds", "43200", 43200, false},
+		{"Hours", "12h", 43200, false},
+		{"Minutes", "90m", 5400, false},
+		{"Days", "1d", 86400, false},
+		{"Days and hours", "1d12h", 129600, false},
+		{"Surrounding whitespace", " 15m ", 900, false},
+		{"Fractional seconds are truncated", "1500ms", 1, false},
+		{"Empty", "", 0, true},
+		{"Zero seconds", "0", 0, true},
+		{"Negative duration", "-1h", 0, true},
+		{"Sub-second duration", "10ms", 0, true},
+		{"Unknown unit", "3w", 0, true},
+		{"Bad day count", "xd", 0, true},
+		{"Too large", "99999d", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTimeout(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTimeout(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if got != tt.expected {
+				t.Errorf("ParseTimeout(%q) = %v, expected %v", tt.value, got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,18 @@
+package appconfig
+
+import "errors"
+
+// Sentinel errors returned by ValidateOptions, so callers can branch with errors.Is instead
+// of matching on messages.
+var (
+	// ErrMissingToken is returned when no MFA token was given and no token command produced one.
+	ErrMissingToken = errors.New("token must be supplied for MFA")
+	// ErrInvalidDevice is returned when the MFA device is neither an MFA ARN nor a serial number.
+	ErrInvalidDevice = errors.New("invalid MFA device")
+	// ErrSecurityKeyDevice is returned when the MFA device is a FIDO security key, which STS does not accept.
+	ErrSecurityKeyDevice = errors.New("unsupported MFA device")
+	// ErrInvalidToken is returned when the MFA token is not a six digit code.
+	ErrInvalidToken = errors.New("invalid MFA token")
+	// ErrInvalidProfile is returned when a profile name cannot be an INI section of the credentials file.
+	ErrInvalidProfile = errors.New("invalid profile name")
+)
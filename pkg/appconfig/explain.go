@@ -0,0 +1,187 @@
+package appconfig
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docopt/docopt-go"
+)
+
+// Option sources reported by ExplainOptions.
+const (
+	SourceFlag       = "flag"
+	SourceEnv        = "env"
+	SourceConfig     = "config file"
+	SourceBaseConfig = "base config"
+	SourceDefault    = "default"
+)
+
+// flagOptions maps command-line flags to the option names reported by ExplainOptions.
+var flagOptions = map[string]string{
+	"--token":              "Token",
+	"--token-command":      "TokenCommand",
+	"--config":             "Config",
+	"--org":                "Org",
+	"--device":             "Device",
+	"--profile":            "Profile",
+	"--timeout":            "Timeout",
+	"--output":             "Output",
+	"--cluster":            "Cluster",
+	"--policy-arns":        "PolicyArns",
+	"--policy-file":        "PolicyFile",
+	"--external-id":        "ExternalID",
+	"--proxy":              "Proxy",
+	"--ca-bundle":          "CABundle",
+	"--skip-imds":          "SkipIMDS",
+	"--allow-argv-secrets": "AllowArgvSecrets",
+	"--no-mfa":             "NoMFA",
+	"--prompt":             "Prompt",
+	"--remote-path":        "Push.RemotePath",
+}
+
+// shortFlags maps short flags to their long form.
+var shortFlags = map[string]string{
+	"-t": "--token",
+	"-c": "--config",
+	"-o": "--org",
+	"-d": "--device",
+	"-p": "--profile",
+}
+
+// ExplainedOption is an effective option value and where it came from.
+type ExplainedOption struct {
+	Name   string // Option name as used in the config file.
+	Value  string // Effective value, with secrets redacted.
+	Source string // One of SourceFlag, SourceEnv, SourceConfig or SourceDefault.
+}
+
+// setSource records where an option's value came from.
+func (config *AppConfig) setSource(name, source string) {
+	if config.sources == nil {
+		config.sources = map[string]string{}
+	}
+	config.sources[name] = source
+}
+
+// source returns where an option's value came from, SourceDefault if it was never set.
+func (config *AppConfig) source(name string) string {
+	if source, ok := config.sources[name]; ok {
+		return source
+	}
+	return SourceDefault
+}
+
+// usageWithoutDefaults is Usage with the defaults of its options left out, so parsing with it
+// leaves the options that were not given unset.
+var usageWithoutDefaults = regexp.MustCompile(` *\[default: [^\]]*\]`).ReplaceAllString(Usage, "")
+
+// recordFlags marks the options given in args as coming from the command line. docopt fills
+// in defaults for flags that were not given, so args are parsed again without the defaults.
+// docopt tells the values of options, such as a --token-command starting with a dash, and the
+// words of the exec command apart from the flags.
+func (config *AppConfig) recordFlags(args []string) {
+	parser := &docopt.Parser{HelpHandler: docopt.NoHelpHandler}
+	opts, err := parser.ParseArgs(usageWithoutDefaults, args, "")
+	if err != nil {
+		slog.Debug("Not recording the flags of unparsable arguments", "err", err)
+		return
+	}
+	for flag, name := range flagOptions {
+		if value := opts[flag]; value != nil && value != false {
+			config.setSource(name, SourceFlag)
+		}
+	}
+}
+
+// ExplainOptions loads the config file and returns every effective option, its final value, and
+// which source provided it.
+func (config *AppConfig) ExplainOptions() ([]ExplainedOption, error) {
+	if err := config.GetGredenturesConfig(); err != nil {
+		return nil, fmt.Errorf("error getting gredentures config: %w", err)
+	}
+
+	orgs := make([]string, 0, len(config.Orgs))
+	for name := range config.Orgs {
+		orgs = append(orgs, name)
+	}
+	sort.Strings(orgs)
+	recipes := make([]string, 0, len(config.Recipes))
+	for name := range config.Recipes {
+		recipes = append(recipes, name)
+	}
+	sort.Strings(recipes)
+	var tags []string
+	for _, key := range slices.Sorted(maps.Keys(config.Organization.Tags)) {
+		tags = append(tags, key+"="+config.Organization.Tags[key])
+	}
+
+	// The 1Password Connect host falls back to the environment, see onepassword.NewFromEnv
+	connectHost, connectSource := config.OnePassword.ConnectHost, config.source("OnePassword")
+	if host := os.Getenv("OP_CONNECT_HOST"); connectHost == "" && host != "" {
+		connectHost, connectSource = host, SourceEnv
+	}
+
+	return []ExplainedOption{
+		{"Config", config.Config, config.source("Config")},
+		{"BaseConfigs", strings.Join(config.BaseConfigs, ","), config.source("BaseConfigs")},
+		{"Org", config.Org, config.source("Org")},
+		{"Device", config.Device, config.source("Device")},
+		{"Profile", config.Profile, config.source("Profile")},
+		{"Timeout", fmt.Sprintf("%ds", config.Timeout), config.source("Timeout")},
+		{"KeyMaxAge", fmt.Sprintf("%ds", int64(config.KeyMaxAge()/time.Second)), config.source("KeyMaxAge")},
+		{"RefreshMargin", fmt.Sprintf("%ds", int64(config.RefreshMargin(config.Profile)/time.Second)), config.source("RefreshMargin")},
+		{"Token", config.Token.String(), config.source("Token")},
+		{"TokenCommand", config.TokenCommand, config.source("TokenCommand")},
+		{"NoMFA", fmt.Sprint(config.NoMFA), config.source("NoMFA")},
+		{"Prompt", config.Prompt, config.source("Prompt")},
+		{"SourceProfile", config.SourceProfile, config.source("SourceProfile")},
+		{"SourceFile", config.SourceFile, config.source("SourceFile")},
+		{"CredentialsFile", config.CredentialsFile, config.source("CredentialsFile")},
+		{"CredentialsFiles", strings.Join(config.CredentialsFiles, ","), config.source("CredentialsFiles")},
+		{"CurrentProfile", config.CurrentProfile, config.source("CurrentProfile")},
+		{"Favorites", strings.Join(config.Favorites, ","), config.source("Favorites")},
+		{"ManagedProfiles", strings.Join(config.ManagedPatterns, ","), config.source("ManagedProfiles")},
+		{"AuditLog", config.AuditLog, config.source("AuditLog")},
+		{"Output", config.Output, config.source("Output")},
+		{"PolicyArns", strings.Join(config.PolicyArns, ","), config.source("PolicyArns")},
+		{"PolicyFile", config.PolicyFile, config.source("PolicyFile")},
+		{"ExternalID", config.ExternalID, config.source("ExternalID")},
+		{"Proxy", config.Proxy, config.source("Proxy")},
+		{"CABundle", config.CABundle, config.source("CABundle")},
+		{"SkipIMDS", fmt.Sprint(config.SkipIMDS), config.source("SkipIMDS")},
+		{"CompatMode", fmt.Sprint(config.CompatMode), config.source("CompatMode")},
+		{"STSRegions", strings.Join(config.STSRegions, ","), config.source("STSRegions")},
+		{"AllowArgvSecrets", fmt.Sprint(config.AllowArgvSecrets), config.source("AllowArgvSecrets")},
+		{"SystemMode", fmt.Sprint(config.SystemMode), config.source("SystemMode")},
+		{"LoginMessage", config.LoginMessage, config.source("LoginMessage")},
+		{"OnePassword.Item", config.OnePassword.Item, config.source("OnePassword")},
+		{"OnePassword.Vault", config.OnePassword.Vault, config.source("OnePassword")},
+		{"OnePassword.ConnectHost", connectHost, connectSource},
+		{"KeyStore.Backend", config.KeyStore.Backend, config.source("KeyStore")},
+		{"KeyStore.File", config.KeyStore.File, config.source("KeyStore")},
+		{"Stats.Enabled", fmt.Sprint(config.Stats.Enabled), config.source("Stats")},
+		{"Stats.Endpoint", config.Stats.Endpoint, config.source("Stats")},
+		{"Stats.File", config.Stats.File, config.source("Stats")},
+		{"Push.RemotePath", config.RemotePath, config.source("Push.RemotePath")},
+		{"SDK.RetryMode", config.SDK.RetryMode, config.source("SDK")},
+		{"SDK.MaxAttempts", fmt.Sprint(config.SDK.MaxAttempts), config.source("SDK")},
+		{"SDK.CallTimeout", fmt.Sprintf("%ds", config.SDK.CallTimeout), config.source("SDK")},
+		{"SDK.Log", strings.Join(config.SDK.Log, ","), config.source("SDK")},
+		{"SDK.Commands", strings.Join(slices.Sorted(maps.Keys(config.SDK.Commands)), ","), config.source("SDK")},
+		{"Organization.RoleName", config.Organization.Role(), config.source("Organization")},
+		{"Organization.Tags", strings.Join(tags, ","), config.source("Organization")},
+		{"Agent.Socket", config.Agent.Socket, config.source("Agent")},
+		{"Agent.AllowUIDs", strings.Trim(fmt.Sprint(config.Agent.AllowUIDs), "[]"), config.source("Agent")},
+		{"Agent.AllowBinaries", strings.Join(config.Agent.AllowBinaries, ","), config.source("Agent")},
+		{"Agent.WatchCredentials", fmt.Sprint(config.Agent.WatchCredentials), config.source("Agent")},
+		{"Orgs", strings.Join(orgs, ","), config.source("Orgs")},
+		{"Recipes", strings.Join(recipes, ","), config.source("Recipes")},
+	}, nil
+}
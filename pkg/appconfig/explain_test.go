@@ -0,0 +1,59 @@
+package appconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordFlags(t *testing.T) {
+	config := &AppConfig{}
+	config.recordFlags([]string{"-t123456", "--org=acme", "-p", "work", "--token-command", "-d", "exec", "--", "aws", "--profile", "other"})
+
+	assert.Equal(t, SourceFlag, config.source("Token"))
+	assert.Equal(t, SourceFlag, config.source("Org"))
+	assert.Equal(t, SourceFlag, config.source("Profile"))
+	assert.Equal(t, SourceFlag, config.source("TokenCommand"))
+	assert.Equal(t, SourceDefault, config.source("Device"), "the value of --token-command is no flag")
+	assert.Equal(t, SourceDefault, config.source("Timeout"), "defaults are not given")
+	assert.Len(t, config.sources, 4)
+}
+
+func TestExplainOptions(t *testing.T) {
+	resetLogging()
+	t.Setenv("OP_CONNECT_HOST", "https://connect.example.com")
+
+	path := filepath.Join(t.TempDir(), "config.yml")
+	assert.NoError(t, os.WriteFile(path, []byte(`
+gredentures:
+  Org: file-org
+  Device: file-device
+  OnePassword:
+    Item: aws
+  Orgs:
+    prod:
+      RoleArn: arn:aws:iam::111111111111:role/Admin
+`), 0600))
+
+	config := &AppConfig{}
+	assert.NoError(t, config.Parse([]string{"config", "explain", "-c", path, "-o", "flag-org", "-t", "123456"}))
+
+	options, err := config.ExplainOptions()
+	assert.NoError(t, err)
+
+	explained := map[string]ExplainedOption{}
+	for _, option := range options {
+		explained[option.Name] = option
+	}
+	assert.Equal(t, ExplainedOption{"Config", path, SourceFlag}, explained["Config"])
+	assert.Equal(t, ExplainedOption{"Org", "flag-org", SourceFlag}, explained["Org"])
+	assert.Equal(t, ExplainedOption{"Device", "file-device", SourceConfig}, explained["Device"])
+	assert.Equal(t, ExplainedOption{"Profile", "default-mfa", SourceDefault}, explained["Profile"])
+	assert.Equal(t, ExplainedOption{"Timeout", "86400s", SourceDefault}, explained["Timeout"])
+	assert.Equal(t, ExplainedOption{"Token", "<redacted>", SourceFlag}, explained["Token"])
+	assert.Equal(t, ExplainedOption{"OnePassword.Item", "aws", SourceConfig}, explained["OnePassword.Item"])
+	assert.Equal(t, ExplainedOption{"OnePassword.ConnectHost", "https://connect.example.com", SourceEnv}, explained["OnePassword.ConnectHost"])
+	assert.Equal(t, ExplainedOption{"Orgs", "prod", SourceConfig}, explained["Orgs"])
+}
@@ -0,0 +1,148 @@
+package appconfig
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gredentures/pkg/interrupt"
+	"gredentures/pkg/remoteconfig"
+
+	"github.com/knadh/koanf"
+	"github.com/knadh/koanf/parsers/yaml"
+	"github.com/knadh/koanf/providers/confmap"
+	"github.com/knadh/koanf/providers/rawbytes"
+)
+
+// DefaultSystemConfigPath is where a machine-wide config, typically installed by a platform
+// team, is read from when GREDENTURES_SYSTEM_CONFIG is not set.
+const DefaultSystemConfigPath = "/etc/gredentures/config.yml"
+
+// ConfigKeysEnv names the environment variable holding the comma-separated public keys trusted
+// to sign remote config files, including a remote --config or system config.
+const ConfigKeysEnv = "GREDENTURES_CONFIG_PUBLIC_KEYS"
+
+// SystemConfigPath returns the machine-wide base config file, which is merged underneath
+// every user's own config file when it exists. It may be an https URL.
+func SystemConfigPath() string {
+	if path := os.Getenv("GREDENTURES_SYSTEM_CONFIG"); path != "" {
+		return path
+	}
+	return DefaultSystemConfigPath
+}
+
+// readConfig reads a config file from disk or, for an https URL, through remoteconfig. Remote
+// files must be signed by one of keys or of the keys in GREDENTURES_CONFIG_PUBLIC_KEYS, and only
+// their cached copies are read while completing.
+func (conf *AppConfig) readConfig(location string, keys []string) ([]byte, error) {
+	if !remoteconfig.IsRemote(location) {
+		data, err := os.ReadFile(location)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		return data, nil
+	}
+
+	if env := os.Getenv(ConfigKeysEnv); env != "" {
+		keys = append(keys, strings.Split(env, ",")...)
+	}
+	trusted, err := remoteconfig.ParseKeys(keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config public keys: %w", err)
+	}
+	fetcher := remoteconfig.New(trusted)
+	if conf.httpClient != nil {
+		fetcher.Client = conf.httpClient
+	}
+	fetcher.Offline = conf.completing
+	slog.Debug("Fetching remote config file", "url", location)
+	return fetcher.Fetch(interrupt.Context(), location)
+}
+
+// loadConfigLayer upgrades a single YAML config file to ConfigVersion, validates it against
+// the config schema and loads it.
+func (conf *AppConfig) loadConfigLayer(location string, keys []string) (*koanf.Koanf, error) {
+	data, err := conf.readConfig(location, keys)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = conf.migrateConfigLayer(location, data); err != nil {
+		return nil, err
+	}
+	if err := ValidateConfig(data); err != nil {
+		return nil, fmt.Errorf("invalid config file %s:\n%w", location, err)
+	}
+
+	layer := koanf.New(".")
+	if err := layer.Load(rawbytes.Provider(data), yaml.Parser()); err != nil {
+		return nil, fmt.Errorf("failed to load YAML file into koanf: %w", err)
+	}
+	return layer, nil
+}
+
+// baseConfigPaths lists the base configs to merge underneath the user's config file, lowest
+// precedence first: the system config when it exists, then the BaseConfigs it names. Relative
+// local BaseConfigs are resolved against the directory of the user's config file.
+func (conf *AppConfig) baseConfigPaths(user *koanf.Koanf) []string {
+	var paths []string
+	if _, err := os.Stat(SystemConfigPath()); err == nil || remoteconfig.IsRemote(SystemConfigPath()) {
+		paths = append(paths, SystemConfigPath())
+	}
+	for _, path := range user.Strings("gredentures.BaseConfigs") {
+		path = expandPath(path)
+		if !remoteconfig.IsRemote(path) && !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(conf.Config), path)
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// mergeLayer merges a config layer over k. Empty values, such as the `Org: ""` written to a
+// new config file, count as unset so they do not hide a value provided by a base config.
+func mergeLayer(k, layer *koanf.Koanf) error {
+	values := map[string]interface{}{}
+	for key, value := range layer.All() {
+		if !unsetValue(value) {
+			values[key] = value
+		}
+	}
+	return k.Load(confmap.Provider(values, "."), nil)
+}
+
+// unsetValue reports whether a config value is empty and falls through to lower layers.
+func unsetValue(value interface{}) bool {
+	return value == nil || value == "" || value == 0
+}
+
+// loadConfigLayers loads the user's config file over its base configs and returns the merged
+// values, along with the user's file alone so option sources can be told apart.
+func (conf *AppConfig) loadConfigLayers() (merged, user *koanf.Koanf, err error) {
+	user, err = conf.loadConfigLayer(conf.Config, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	merged = koanf.New(".")
+	conf.BaseConfigs = nil
+	if user.Exists("gredentures.BaseConfigs") {
+		conf.setSource("BaseConfigs", SourceConfig)
+	}
+	for _, path := range conf.baseConfigPaths(user) {
+		slog.Debug("Loading base config file", "path", path)
+		layer, err := conf.loadConfigLayer(path, user.Strings("gredentures.ConfigPublicKeys"))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load base config: %w", err)
+		}
+		if err := mergeLayer(merged, layer); err != nil {
+			return nil, nil, fmt.Errorf("failed to merge base config %s: %w", path, err)
+		}
+		conf.BaseConfigs = append(conf.BaseConfigs, path)
+	}
+	if err := mergeLayer(merged, user); err != nil {
+		return nil, nil, fmt.Errorf("failed to merge config file %s: %w", conf.Config, err)
+	}
+	return merged, user, nil
+}
@@ -0,0 +1,179 @@
+package appconfig
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gredentures/pkg/remoteconfig"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeLayer writes a config file into dir and returns its path.
+func writeLayer(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func TestSystemConfigPath(t *testing.T) {
+	t.Setenv("GREDENTURES_SYSTEM_CONFIG", "")
+	assert.Equal(t, DefaultSystemConfigPath, SystemConfigPath())
+
+	t.Setenv("GREDENTURES_SYSTEM_CONFIG", "/opt/gredentures.yml")
+	assert.Equal(t, "/opt/gredentures.yml", SystemConfigPath())
+}
+
+func TestLoadGredenturesConfigLayers(t *testing.T) {
+	dir := t.TempDir()
+	system := writeLayer(t, dir, "system.yml", `
+gredentures:
+  Org: platform-org
+  Device: arn:aws:iam::123456789012:mfa/shared
+  Orgs:
+    prod:
+      RoleArn: arn:aws:iam::111111111111:role/Prod
+      Profile: prod-mfa
+    staging:
+      RoleArn: arn:aws:iam::222222222222:role/Staging
+`)
+	t.Setenv("GREDENTURES_SYSTEM_CONFIG", system)
+	team := writeLayer(t, dir, "repo/team.yml", `
+gredentures:
+  Orgs:
+    prod:
+      RoleArn: arn:aws:iam::111111111111:role/TeamProd
+  TokenCommand: op item get aws --otp
+`)
+
+	t.Run("User config overlays the base configs", func(t *testing.T) {
+		user := writeLayer(t, dir, "user/config.yml", `
+gredentures:
+  Org: ""
+  Device: arn:aws:iam::123456789012:mfa/me
+  BaseConfigs:
+    - ../repo/team.yml
+  Orgs:
+    staging:
+      Profile: my-staging
+`)
+		conf := &AppConfig{Config: user}
+		assert.NoError(t, conf.LoadGredenturesConfig())
+
+		assert.Equal(t, []string{system, team}, conf.BaseConfigs)
+		assert.Equal(t, "platform-org", conf.Org, "empty values do not hide the base config")
+		assert.Equal(t, "arn:aws:iam::123456789012:mfa/me", conf.Device)
+		assert.Equal(t, "op item get aws --otp", conf.TokenCommand)
+		assert.Equal(t, OrgConfig{RoleArn: "arn:aws:iam::111111111111:role/TeamProd", Profile: "prod-mfa"}, conf.Orgs["prod"])
+		assert.Equal(t, OrgConfig{RoleArn: "arn:aws:iam::222222222222:role/Staging", Profile: "my-staging"}, conf.Orgs["staging"])
+
+		assert.Equal(t, SourceBaseConfig, conf.source("Org"))
+		assert.Equal(t, SourceBaseConfig, conf.source("TokenCommand"))
+		assert.Equal(t, SourceConfig, conf.source("Device"))
+		assert.Equal(t, SourceConfig, conf.source("Orgs"))
+		assert.Equal(t, SourceConfig, conf.source("BaseConfigs"))
+	})
+
+	t.Run("Missing base config", func(t *testing.T) {
+		user := writeLayer(t, dir, "missing.yml", "gredentures:\n  BaseConfigs: [nowhere.yml]\n")
+		err := (&AppConfig{Config: user}).LoadGredenturesConfig()
+		assert.ErrorContains(t, err, "failed to load base config")
+	})
+
+	t.Run("Invalid base config names the file", func(t *testing.T) {
+		broken := writeLayer(t, dir, "broken.yml", "gredentures:\n  Devcie: x\n")
+		user := writeLayer(t, dir, "invalid.yml", "gredentures:\n  BaseConfigs: ["+broken+"]\n")
+		err := (&AppConfig{Config: user}).LoadGredenturesConfig()
+		assert.ErrorContains(t, err, "invalid config file "+broken)
+	})
+
+	t.Run("New config files pick up the system config", func(t *testing.T) {
+		conf := &AppConfig{Config: filepath.Join(dir, "new", "config.yml")}
+		assert.NoError(t, conf.GetGredenturesConfig())
+		assert.FileExists(t, conf.Config)
+		assert.Equal(t, "platform-org", conf.Org)
+		assert.Len(t, conf.Orgs, 2)
+	})
+}
+
+// signedConfigServer serves config at /config.yml with its signature and returns the server
+// and the base64 public key that verifies it.
+func signedConfigServer(t *testing.T, config string) (*httptest.Server, string) {
+	public, private, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(private, []byte(config)))
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/config.yml":
+			w.Write([]byte(config))
+		case "/config.yml" + remoteconfig.SignatureSuffix:
+			w.Write([]byte(signature))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, base64.StdEncoding.EncodeToString(public)
+}
+
+// failingTransport fails the test on every request made through it.
+type failingTransport struct{ t *testing.T }
+
+func (f failingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	f.t.Errorf("unexpected request for %s", r.URL)
+	return nil, errors.New("no requests expected")
+}
+
+func TestLoadGredenturesConfigRemote(t *testing.T) {
+	t.Setenv("GREDENTURES_SYSTEM_CONFIG", filepath.Join(t.TempDir(), "none.yml"))
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	server, key := signedConfigServer(t, `
+gredentures:
+  Org: platform-org
+  Orgs:
+    prod:
+      RoleArn: arn:aws:iam::111111111111:role/Prod
+`)
+
+	t.Run("Remote base config", func(t *testing.T) {
+		user := writeLayer(t, t.TempDir(), "config.yml", "gredentures:\n  BaseConfigs: ["+server.URL+"/config.yml]\n  ConfigPublicKeys: ["+key+"]\n")
+		conf := &AppConfig{Config: user, httpClient: server.Client()}
+		assert.NoError(t, conf.LoadGredenturesConfig())
+		assert.Equal(t, "platform-org", conf.Org)
+		assert.Equal(t, []string{server.URL + "/config.yml"}, conf.BaseConfigs)
+	})
+
+	t.Run("Remote config file with a key from the environment", func(t *testing.T) {
+		t.Setenv(ConfigKeysEnv, key)
+		conf := &AppConfig{Config: server.URL + "/config.yml", httpClient: server.Client()}
+		assert.NoError(t, conf.GetGredenturesConfig())
+		assert.Equal(t, "platform-org", conf.Org)
+		assert.ErrorContains(t, conf.WriteGredenturesConfig(), "cannot write to the remote config file")
+	})
+
+	t.Run("Only the cached copy while completing", func(t *testing.T) {
+		t.Setenv(ConfigKeysEnv, key)
+		t.Setenv("XDG_CACHE_HOME", t.TempDir())
+		conf := &AppConfig{Config: server.URL + "/config.yml", httpClient: server.Client(), completing: true}
+		assert.ErrorContains(t, conf.GetGredenturesConfig(), "is not cached")
+		assert.NoError(t, (&AppConfig{Config: server.URL + "/config.yml", httpClient: server.Client()}).GetGredenturesConfig())
+
+		conf = &AppConfig{Config: server.URL + "/config.yml", httpClient: &http.Client{Transport: failingTransport{t}}, completing: true}
+		assert.NoError(t, conf.GetGredenturesConfig())
+		assert.Equal(t, "platform-org", conf.Org)
+	})
+
+	t.Run("Untrusted remote config", func(t *testing.T) {
+		t.Setenv(ConfigKeysEnv, "")
+		conf := &AppConfig{Config: server.URL + "/config.yml", httpClient: server.Client()}
+		assert.ErrorContains(t, conf.LoadGredenturesConfig(), "no public key is trusted")
+	})
+}
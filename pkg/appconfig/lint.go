@@ -0,0 +1,80 @@
+package appconfig
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	y "gopkg.in/yaml.v3"
+)
+
+// LintIssue is a problem config lint found in the config file or in a credentials file.
+type LintIssue struct {
+	File    string // File the problem is in.
+	Line    int    // Line of the problem, starting at 1, 0 when it concerns the whole file.
+	Problem string // What is wrong.
+	Fix     string // What --fix does about it, empty when it has to be fixed by hand.
+	Fixed   bool   // Set when --fix resolved it.
+}
+
+// LintConfig checks the config file against the config schema, then loads it over its base
+// configs, and returns every problem found. Unknown keys within two edits of a known one are
+// fixable: with fix set they are renamed in the file, keeping its comments, unless the known
+// key is already set next to them. Once the file is valid its values are loaded into conf.
+func (conf *AppConfig) LintConfig(fix bool) ([]LintIssue, error) {
+	conf.resolveConfigPath()
+	path := conf.Config
+	data, err := conf.readConfig(path, nil)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil // Nothing to lint before the first login creates it
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Upgraded in place, so the problems are reported at their lines in the file as it is
+	var doc y.Node
+	if err := y.Unmarshal(data, &doc); err != nil {
+		return []LintIssue{{File: path, Problem: fmt.Sprintf("invalid YAML: %v", err)}}, nil
+	}
+	if _, err := upgradeDocument(&doc, ConfigVersion, configMigrations); err != nil {
+		return []LintIssue{{File: path, Problem: err.Error()}}, nil
+	}
+	errs := validateDocument(&doc)
+
+	var issues []LintIssue
+	renamed, unfixed := false, false
+	for _, err := range errs {
+		var schemaErr *SchemaError
+		if !errors.As(err, &schemaErr) {
+			continue
+		}
+		issue := LintIssue{File: path, Line: schemaErr.Line, Problem: schemaErr.Message}
+		if schemaErr.Suggestion != "" && !hasEntry(schemaErr.mapping, schemaErr.Suggestion) && conf.checkConfigWritable() == nil {
+			issue.Fix = fmt.Sprintf("rename it to %s", schemaErr.Suggestion)
+			if fix {
+				schemaErr.key.Value = schemaErr.Suggestion
+				issue.Fixed, renamed = true, true
+			}
+		}
+		unfixed = unfixed || !issue.Fixed
+		issues = append(issues, issue)
+	}
+	if renamed {
+		if err := conf.writeConfigDocument(&doc); err != nil {
+			return issues, err
+		}
+	}
+	if unfixed {
+		return issues, nil // Loading would only report the same problems again
+	}
+
+	// The schema passes, what is left are the base configs and the checks across keys
+	if err := conf.LoadGredenturesConfig(); err != nil {
+		return append(issues, LintIssue{File: path, Problem: err.Error()}), nil
+	}
+	if err := conf.validatePartitions(); err != nil {
+		issues = append(issues, LintIssue{File: path, Problem: err.Error()})
+	}
+	return issues, nil
+}
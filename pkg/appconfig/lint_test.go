@@ -0,0 +1,88 @@
+package appconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLintConfig(t *testing.T) {
+	const data = `gredentures:
+  Devcie: arn:aws:iam::123456789012:mfa/alice # my phone
+  Tiemout: 1h
+  Timeout: 2h
+  Bogus: true
+`
+
+	t.Run("Reports the problems without changing the file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config.yml")
+		require.NoError(t, os.WriteFile(path, []byte(data), 0o644))
+
+		conf := &AppConfig{Config: path}
+		issues, err := conf.LintConfig(false)
+		require.NoError(t, err)
+		assert.Equal(t, []LintIssue{
+			{File: path, Line: 2, Problem: `unknown key "gredentures.Devcie" (did you mean "Device"?)`, Fix: "rename it to Device"},
+			{File: path, Line: 3, Problem: `unknown key "gredentures.Tiemout" (did you mean "Timeout"?)`},
+			{File: path, Line: 5, Problem: `unknown key "gredentures.Bogus"`},
+		}, issues, "a key already set next to the misspelling is not fixable")
+
+		written, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, data, string(written))
+	})
+
+	t.Run("Renames misspelled keys with --fix", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config.yml")
+		require.NoError(t, os.WriteFile(path, []byte("gredentures:\n  Devcie: arn:aws:iam::123456789012:mfa/alice # my phone\n"), 0o644))
+
+		conf := &AppConfig{Config: path}
+		issues, err := conf.LintConfig(true)
+		require.NoError(t, err)
+		require.Len(t, issues, 1)
+		assert.True(t, issues[0].Fixed)
+		assert.Equal(t, "arn:aws:iam::123456789012:mfa/alice", conf.Device, "the fixed file is loaded")
+
+		written, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, "version: 2\ngredentures:\n  Device: arn:aws:iam::123456789012:mfa/alice # my phone\n", string(written), "the fixed file is upgraded too")
+	})
+
+	t.Run("Leaves the file alone with --no-config-write", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config.yml")
+		require.NoError(t, os.WriteFile(path, []byte("gredentures:\n  Devcie: x\n"), 0o644))
+
+		conf := &AppConfig{Config: path, NoConfigWrite: true}
+		issues, err := conf.LintConfig(true)
+		require.NoError(t, err)
+		require.Len(t, issues, 1)
+		assert.Empty(t, issues[0].Fix)
+		assert.False(t, issues[0].Fixed)
+	})
+
+	t.Run("Reports problems across keys once the schema passes", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config.yml")
+		require.NoError(t, os.WriteFile(path, []byte(`gredentures:
+  Orgs:
+    gov:
+      Partition: aws-us-gov
+      RoleArn: arn:aws:iam::111111111111:role/Admin
+`), 0o644))
+
+		conf := &AppConfig{Config: path}
+		issues, err := conf.LintConfig(false)
+		require.NoError(t, err)
+		require.Len(t, issues, 1)
+		assert.Contains(t, issues[0].Problem, "not in the Partition aws-us-gov")
+	})
+
+	t.Run("Has nothing to report without a config file", func(t *testing.T) {
+		conf := &AppConfig{Config: filepath.Join(t.TempDir(), "config.yml")}
+		issues, err := conf.LintConfig(true)
+		require.NoError(t, err)
+		assert.Empty(t, issues)
+	})
+}
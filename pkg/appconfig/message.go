@@ -0,0 +1,62 @@
+package appconfig
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// DefaultLoginMessage provides instructions for setting the AWS_PROFILE environment variable
+// to use the session credentials by default.
+const DefaultLoginMessage = `
+***********************************************************************************
+* To use your session creds by default please add the following to your profile:  *
+*                                                                                 *
+* export AWS_PROFILE={{.Profile}}                                                 *
+* then source your profile                                                        *
+***********************************************************************************
+`
+
+// LoginMessageData is the data available to the LoginMessage template.
+type LoginMessageData struct {
+	Profile  string   // Profile the session credentials were written to.
+	Org      string   // Organization name.
+	Profiles []string // Every profile gredentures manages.
+}
+
+// parseLoginMessage parses a login message template.
+func parseLoginMessage(text string) (*template.Template, error) {
+	tmpl, err := template.New("LoginMessage").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// RenderLoginMessage returns the message to print after a successful login. A LoginMessage
+// from the config file is always shown, while the default AWS_PROFILE advice is only shown
+// when AWS_PROFILE doesn't already select the session profile. Quiet suppresses both.
+func (config AppConfig) RenderLoginMessage() (string, error) {
+	text := config.LoginMessage
+	switch {
+	case config.Quiet:
+		return "", nil
+	case text == "" && os.Getenv("AWS_PROFILE") == config.Profile:
+		return "", nil
+	case text == "":
+		text = DefaultLoginMessage
+	}
+
+	tmpl, err := parseLoginMessage(text)
+	if err != nil {
+		return "", err
+	}
+
+	var out strings.Builder
+	data := LoginMessageData{Profile: config.Profile, Org: config.Org, Profiles: config.ManagedProfiles()}
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("failed to render login message: %w", err)
+	}
+	return out.String(), nil
+}
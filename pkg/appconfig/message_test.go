@@ -0,0 +1,61 @@
+package appconfig
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderLoginMessage(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     AppConfig
+		awsProfile string
+		expected   string
+		contains   string
+	}{
+		{
+			name:     "Default advice",
+			config:   AppConfig{Profile: "default-mfa"},
+			contains: "export AWS_PROFILE=default-mfa",
+		},
+		{
+			name:       "Default advice skipped when profile is active",
+			config:     AppConfig{Profile: "default-mfa"},
+			awsProfile: "default-mfa",
+		},
+		{
+			name: "Custom template",
+			config: AppConfig{
+				Profile:      "default-mfa",
+				Org:          "acme",
+				Orgs:         map[string]OrgConfig{"prod": {}},
+				LoginMessage: "{{.Org}}: {{range .Profiles}}{{.}} {{end}}\n",
+			},
+			awsProfile: "default-mfa",
+			expected:   "acme: default-mfa prod-mfa \n",
+		},
+		{
+			name:   "Quiet",
+			config: AppConfig{Profile: "default-mfa", Quiet: true, LoginMessage: "hello"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("AWS_PROFILE", tt.awsProfile)
+			message, err := tt.config.RenderLoginMessage()
+			assert.NoError(t, err)
+			if tt.contains != "" {
+				assert.Contains(t, message, tt.contains)
+				return
+			}
+			assert.Equal(t, tt.expected, message)
+		})
+	}
+}
+
+func TestRenderLoginMessageInvalid(t *testing.T) {
+	_, err := AppConfig{LoginMessage: "{{.Missing}}"}.RenderLoginMessage()
+	assert.ErrorContains(t, err, "failed to render login message")
+}
@@ -0,0 +1,66 @@
+package appconfig
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"gredentures/pkg/sysuser"
+
+	"gopkg.in/ini.v1"
+)
+
+// LegacyConfigPath returns the location of the legacy INI config file, ~/.gredentures.
+func LegacyConfigPath() string {
+	return fmt.Sprintf("%s/.gredentures", sysuser.Home())
+}
+
+// MigrateLegacyConfig converts the legacy INI config file at legacyPath into the YAML
+// config file used by AppConfig. The legacy file is renamed with a .bak suffix once the
+// YAML file has been written. An existing YAML config file is never overwritten.
+func (conf *AppConfig) MigrateLegacyConfig(legacyPath string) error {
+	conf.resolveConfigPath()
+
+	slog.Debug("Checking for legacy config file", "path", legacyPath)
+	if _, err := os.Stat(legacyPath); err != nil {
+		return fmt.Errorf("no legacy config file to migrate: %w", err)
+	}
+	if _, err := os.Stat(conf.Config); err == nil {
+		return fmt.Errorf("config file %s already exists, refusing to overwrite it", conf.Config)
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("error checking config file: %w", err)
+	}
+
+	// Key names are matched case-insensitively so "org" and "Org" are both accepted
+	legacy, err := ini.LoadSources(ini.LoadOptions{Insensitive: true}, legacyPath)
+	if err != nil {
+		return fmt.Errorf("failed to parse legacy config file: %w", err)
+	}
+	section := legacy.Section("gredentures")
+	if len(section.Keys()) == 0 {
+		section = legacy.Section(ini.DefaultSection) // Keys written without a section header
+	}
+
+	conf.Org = section.Key("org").String()
+	conf.Device = section.Key("device").String()
+	if raw := section.Key("timeout").String(); raw != "" {
+		timeout, err := ParseTimeout(raw)
+		if err != nil {
+			return fmt.Errorf("invalid timeout in legacy config file: %w", err)
+		}
+		conf.Timeout = timeout
+	}
+
+	slog.Debug("Writing migrated config file", "path", conf.Config)
+	if err := conf.WriteGredenturesConfig(); err != nil {
+		return err
+	}
+
+	backupPath := legacyPath + ".bak"
+	slog.Debug("Backing up legacy config file", "path", backupPath)
+	if err := os.Rename(legacyPath, backupPath); err != nil {
+		return fmt.Errorf("failed to back up legacy config file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package appconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/knadh/koanf"
+	"github.com/knadh/koanf/parsers/yaml"
+	"github.com/knadh/koanf/providers/file"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrateLegacyConfig(t *testing.T) {
+	t.Run("Converts the legacy INI file and backs it up", func(t *testing.T) {
+		tempDir := t.TempDir()
+		legacyPath := filepath.Join(tempDir, ".gredentures")
+		assert.NoError(t, os.WriteFile(legacyPath, []byte("[gredentures]\norg = legacy-org\nDevice = arn:aws:iam::123456789012:mfa/legacy\ntimeout = 12h\n"), 0o600))
+
+		conf := &AppConfig{Config: filepath.Join(tempDir, ".gredentures.yml")}
+		assert.NoError(t, conf.MigrateLegacyConfig(legacyPath))
+
+		k := koanf.New(".")
+		assert.NoError(t, k.Load(file.Provider(conf.Config), yaml.Parser()))
+		assert.Equal(t, "legacy-org", k.String("gredentures.Org"))
+		assert.Equal(t, "arn:aws:iam::123456789012:mfa/legacy", k.String("gredentures.Device"))
+		assert.Equal(t, 43200, k.Int("gredentures.Timeout"))
+
+		assert.NoFileExists(t, legacyPath)
+		assert.FileExists(t, legacyPath+".bak")
+	})
+
+	t.Run("Accepts keys without a section header", func(t *testing.T) {
+		tempDir := t.TempDir()
+		legacyPath := filepath.Join(tempDir, ".gredentures")
+		assert.NoError(t, os.WriteFile(legacyPath, []byte("org = legacy-org\ndevice = GAHT12345678\n"), 0o600))
+
+		conf := &AppConfig{Config: filepath.Join(tempDir, ".gredentures.yml")}
+		assert.NoError(t, conf.MigrateLegacyConfig(legacyPath))
+		assert.Equal(t, "legacy-org", conf.Org)
+		assert.Equal(t, "GAHT12345678", conf.Device)
+	})
+
+	t.Run("Refuses to overwrite an existing YAML config", func(t *testing.T) {
+		tempDir := t.TempDir()
+		legacyPath := filepath.Join(tempDir, ".gredentures")
+		assert.NoError(t, os.WriteFile(legacyPath, []byte("[gredentures]\norg = legacy-org\n"), 0o600))
+		yamlPath := filepath.Join(tempDir, ".gredentures.yml")
+		assert.NoError(t, os.WriteFile(yamlPath, []byte("gredentures:\n  Org: yaml-org\n"), 0o600))
+
+		conf := &AppConfig{Config: yamlPath}
+		assert.ErrorContains(t, conf.MigrateLegacyConfig(legacyPath), "already exists")
+		assert.FileExists(t, legacyPath)
+	})
+
+	t.Run("Errors without a legacy config", func(t *testing.T) {
+		tempDir := t.TempDir()
+		conf := &AppConfig{Config: filepath.Join(tempDir, ".gredentures.yml")}
+		assert.ErrorContains(t, conf.MigrateLegacyConfig(filepath.Join(tempDir, ".gredentures")), "no legacy config file")
+	})
+}
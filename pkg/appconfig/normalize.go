@@ -0,0 +1,68 @@
+package appconfig
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/knadh/koanf"
+)
+
+// verbatimKeys lists the config keys whose values keep their surrounding whitespace, such as the
+// trailing newline of a login message.
+var verbatimKeys = []string{"gredentures.LoginMessage"}
+
+// NormalizeToken removes the spaces and hyphens authenticator apps group MFA codes with, so
+// "123 456" and "123-456" are sent to STS as 123456.
+func NormalizeToken(token string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, token)
+}
+
+// trimFlags removes the whitespace and newlines pasted around the values of the string
+// options and arguments docopt bound. The arguments of an exec command are left as given.
+func (config *AppConfig) trimFlags() {
+	value := reflect.ValueOf(config).Elem()
+	for i := range value.NumField() {
+		field := value.Field(i)
+		if _, ok := value.Type().Field(i).Tag.Lookup("docopt"); ok && field.Kind() == reflect.String {
+			field.SetString(strings.TrimSpace(field.String()))
+		}
+	}
+}
+
+// trimConfigValues removes the whitespace and newlines around the string values of k, those
+// of lists included, except for the verbatimKeys.
+func trimConfigValues(k *koanf.Koanf) error {
+	for key, value := range k.All() {
+		if slices.Contains(verbatimKeys, key) {
+			continue
+		}
+		switch v := value.(type) {
+		case string:
+			if trimmed := strings.TrimSpace(v); trimmed != v {
+				if err := k.Set(key, trimmed); err != nil {
+					return err
+				}
+			}
+		case []any:
+			items, trimmed := slices.Clone(v), false
+			for i, item := range items {
+				if s, ok := item.(string); ok && strings.TrimSpace(s) != s {
+					items[i], trimmed = strings.TrimSpace(s), true
+				}
+			}
+			if trimmed {
+				if err := k.Set(key, items); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package appconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeToken(t *testing.T) {
+	for _, token := range []string{"123456", "123 456", "123-456", " 12 34 56\n", "123 456"} {
+		assert.Equal(t, "123456", NormalizeToken(token), "token %q", token)
+	}
+	assert.Equal(t, "12a456", NormalizeToken("12a 456"), "other characters are left for validation")
+}
+
+func TestValidateOptionsGroupedToken(t *testing.T) {
+	resetLogging()
+
+	config := &AppConfig{Config: filepath.Join(t.TempDir(), "config.yml"), Org: "org", Device: "arn:aws:iam::123456789012:mfa/user", Token: "123 456", NoWrite: true}
+	assert.NoError(t, config.ValidateOptions())
+	assert.Equal(t, "123456", config.Token.Reveal())
+
+	config.Token = "123 45"
+	assert.ErrorIs(t, config.ValidateOptions(), ErrInvalidToken)
+}
+
+func TestParseTrimsFlags(t *testing.T) {
+	resetLogging()
+
+	config := &AppConfig{}
+	require.NoError(t, config.Parse([]string{"-t", " 987654\n", "-o", "prod\n", "--device", " arn:aws:iam::123456789012:mfa/user ",
+		"exec", "--", "printf", " %s\n", "kept "}))
+	assert.Equal(t, "987654", config.Token.Reveal())
+	assert.Equal(t, "prod", config.Org)
+	assert.Equal(t, "arn:aws:iam::123456789012:mfa/user", config.Device)
+	assert.Equal(t, []string{"printf", " %s\n", "kept "}, config.Command, "exec arguments are passed on as given")
+}
+
+func TestLoadGredenturesConfigTrimsValues(t *testing.T) {
+	resetLogging()
+
+	path := filepath.Join(t.TempDir(), "config.yml")
+	require.NoError(t, os.WriteFile(path, []byte(`gredentures:
+  Org: "prod "
+  Device: "arn:aws:iam::123456789012:mfa/user\n"
+  Favorites: [" prod-mfa"]
+  LoginMessage: |
+    Logged in to {{.Org}}
+  Orgs:
+    prod:
+      RoleArn: " arn:aws:iam::111111111111:role/Admin"
+`), 0o600))
+	conf := &AppConfig{Config: path}
+	require.NoError(t, conf.LoadGredenturesConfig())
+	assert.Equal(t, "prod", conf.Org)
+	assert.Equal(t, "arn:aws:iam::123456789012:mfa/user", conf.Device)
+	assert.Equal(t, []string{"prod-mfa"}, conf.Favorites)
+	assert.Equal(t, "arn:aws:iam::111111111111:role/Admin", conf.Orgs["prod"].RoleArn)
+	assert.Equal(t, "Logged in to {{.Org}}\n", conf.LoginMessage, "login messages keep their layout")
+}
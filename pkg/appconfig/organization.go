@@ -0,0 +1,44 @@
+package appconfig
+
+import (
+	"regexp"
+	"strings"
+)
+
+// DefaultOrganizationRole is the role AWS Organizations creates in every account it creates.
+const DefaultOrganizationRole = "OrganizationAccountAccessRole"
+
+var (
+	// organizationIDPattern matches AWS Organization IDs such as o-a1b2c3d4e5.
+	organizationIDPattern = regexp.MustCompile(`^o-[a-z0-9]{10,32}$`)
+	// orgNameInvalid matches the characters an org name generated from an AWS name should not
+	// hold. Dots in particular would split the name in the config file.
+	orgNameInvalid = regexp.MustCompile(`[^a-z0-9_-]+`)
+)
+
+// OrganizationConfig controls the orgs generated for the accounts of an AWS Organization,
+// used when Org is an organization ID.
+type OrganizationConfig struct {
+	RoleName string            `koanf:"RoleName"` // Role assumed in each account, DefaultOrganizationRole when empty.
+	Tags     map[string]string `koanf:"Tags"`     // Only accounts with all of these tags, any value when a value is empty.
+}
+
+// Role returns the role assumed in each account of the organization.
+func (org OrganizationConfig) Role() string {
+	if org.RoleName != "" {
+		return org.RoleName
+	}
+	return DefaultOrganizationRole
+}
+
+// IsOrganization reports whether Org names an AWS Organization by its ID rather than an entry
+// of Orgs.
+func (config AppConfig) IsOrganization() bool {
+	return organizationIDPattern.MatchString(config.Org)
+}
+
+// OrgName turns a name from AWS, such as an account or role name, into an org name: lower
+// case, with every run of other characters than letters, digits, "_" and "-" replaced by "-".
+func OrgName(name string) string {
+	return strings.Trim(orgNameInvalid.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
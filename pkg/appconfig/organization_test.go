@@ -0,0 +1,24 @@
+package appconfig
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsOrganization(t *testing.T) {
+	assert.True(t, AppConfig{Org: "o-a1b2c3d4e5"}.IsOrganization())
+	assert.False(t, AppConfig{Org: "my-org"}.IsOrganization())
+	assert.False(t, AppConfig{Org: "o-short"}.IsOrganization())
+}
+
+func TestOrganizationRole(t *testing.T) {
+	assert.Equal(t, DefaultOrganizationRole, OrganizationConfig{}.Role())
+	assert.Equal(t, "Admin", OrganizationConfig{RoleName: "Admin"}.Role())
+}
+
+func TestOrgName(t *testing.T) {
+	assert.Equal(t, "prod-eu", OrgName("Prod EU"))
+	assert.Equal(t, "team-data-staging", OrgName("Team.Data (Staging)"))
+	assert.Equal(t, "ops_admin", OrgName("ops_admin"))
+}
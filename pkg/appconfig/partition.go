@@ -0,0 +1,95 @@
+package appconfig
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+)
+
+// AWS partitions the account of an org can be in.
+const (
+	PartitionAWS      = "aws"        // The commercial regions.
+	PartitionGovCloud = "aws-us-gov" // AWS GovCloud (US), reached with keys of its own.
+	PartitionChina    = "aws-cn"     // The China regions, reached with keys of their own.
+)
+
+// Partitions lists every supported Partition of an org.
+var Partitions = []string{PartitionAWS, PartitionGovCloud, PartitionChina}
+
+// STSGlobal names the global STS endpoint of the commercial partition in STSRegions.
+const STSGlobal = "global"
+
+// regionPattern matches AWS region names such as us-west-2 or us-gov-east-1.
+var regionPattern = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-\d$`)
+
+// accountIDPattern matches twelve digit AWS account IDs.
+var accountIDPattern = regexp.MustCompile(`^\d{12}$`)
+
+// PartitionName returns the partition of the org's account: its Partition, else the one of its
+// RoleArn or, without one, of its Device. It is the commercial partition when none tells.
+func (org OrgConfig) PartitionName() string {
+	switch {
+	case org.Partition != "":
+		return org.Partition
+	case org.RoleArn != "":
+		return arnPartition(org.RoleArn)
+	}
+	return arnPartition(org.Device)
+}
+
+// Partition returns the partition the session is requested in: that of the selected org, or
+// without one that of the MFA device.
+func (config AppConfig) Partition() string {
+	if org, ok := config.Orgs[config.Org]; ok {
+		return org.PartitionName()
+	}
+	return arnPartition(config.Device)
+}
+
+// applyOrg fills in the MFA device of the selected org, unless a recipe or the command line
+// gives one. Accounts in GovCloud and China have MFA devices of their own.
+func (config *AppConfig) applyOrg() {
+	org, ok := config.Orgs[config.Org]
+	if ok && org.Device != "" && config.Recipe == "" && config.source("Device") != SourceFlag {
+		config.Device = org.Device
+	}
+}
+
+// validatePartitions checks that the RoleArn, Device and LinkedAccount of every org fit in its
+// partition, and that the MFA device used for the selected org is one of its partition.
+func (config AppConfig) validatePartitions() error {
+	for _, name := range slices.Sorted(maps.Keys(config.Orgs)) {
+		org := config.Orgs[name]
+		partition := org.PartitionName()
+		switch {
+		case org.RoleArn != "" && arnPartition(org.RoleArn) != partition:
+			return fmt.Errorf("org %q: RoleArn %s is in partition %s, not in the Partition %s", name, org.RoleArn, arnPartition(org.RoleArn), partition)
+		case strings.HasPrefix(org.Device, "arn:") && arnPartition(org.Device) != partition:
+			return fmt.Errorf("org %q: Device %s is in partition %s, not in the Partition %s", name, org.Device, arnPartition(org.Device), partition)
+		case org.LinkedAccount != "" && partition == PartitionAWS:
+			return fmt.Errorf("org %q: LinkedAccount names the commercial account of a GovCloud or China account, set Partition to %s or %s", name, PartitionGovCloud, PartitionChina)
+		}
+	}
+
+	if _, ok := config.Orgs[config.Org]; !ok || config.NoMFA || config.Recipe != "" || !strings.HasPrefix(config.Device, "arn:") {
+		return nil // Only the device of an org is checked, serial numbers carry no partition
+	}
+	if partition := config.Partition(); arnPartition(config.Device) != partition {
+		return fmt.Errorf("the MFA device %s is in partition %s, org %q is in %s, set the Device of the org to one of its account", config.Device, arnPartition(config.Device), config.Org, partition)
+	}
+	return nil
+}
+
+// arnPartition returns the partition of an ARN, the commercial partition when it is empty or
+// no ARN.
+func arnPartition(value string) string {
+	parsed, err := arn.Parse(value)
+	if err != nil || !slices.Contains(Partitions, parsed.Partition) {
+		return PartitionAWS
+	}
+	return parsed.Partition
+}
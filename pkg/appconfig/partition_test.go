@@ -0,0 +1,61 @@
+package appconfig
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartitionName(t *testing.T) {
+	assert.Equal(t, PartitionAWS, OrgConfig{}.PartitionName())
+	assert.Equal(t, PartitionAWS, OrgConfig{RoleArn: "arn:aws:iam::111111111111:role/Admin"}.PartitionName())
+	assert.Equal(t, PartitionGovCloud, OrgConfig{RoleArn: "arn:aws-us-gov:iam::333333333333:role/Admin"}.PartitionName())
+	assert.Equal(t, PartitionChina, OrgConfig{Device: "arn:aws-cn:iam::444444444444:mfa/me"}.PartitionName())
+	assert.Equal(t, PartitionGovCloud, OrgConfig{Partition: PartitionGovCloud, Device: "GAHT12345678"}.PartitionName())
+
+	conf := AppConfig{Device: "arn:aws-us-gov:iam::333333333333:mfa/me"}
+	assert.Equal(t, PartitionGovCloud, conf.Partition(), "without an org the device tells")
+	conf.Orgs = map[string]OrgConfig{"prod": {RoleArn: "arn:aws:iam::111111111111:role/Admin"}}
+	conf.Org = "prod"
+	assert.Equal(t, PartitionAWS, conf.Partition())
+}
+
+func TestValidateOptionsPartition(t *testing.T) {
+	resetLogging()
+	gov := OrgConfig{
+		RoleArn:       "arn:aws-us-gov:iam::333333333333:role/Admin",
+		Device:        "arn:aws-us-gov:iam::333333333333:mfa/me",
+		LinkedAccount: "111111111111",
+	}
+	base := AppConfig{Config: filepath.Join(t.TempDir(), "missing.yml"), Token: "123456", Org: "gov",
+		Device: "arn:aws:iam::111111111111:mfa/me", Orgs: map[string]OrgConfig{"gov": gov}}
+
+	conf := base
+	assert.NoError(t, conf.ValidateOptions())
+	assert.Equal(t, gov.Device, conf.Device, "the org brings its own device")
+
+	conf = base
+	conf.setSource("Device", SourceFlag)
+	assert.ErrorContains(t, conf.ValidateOptions(), `the MFA device arn:aws:iam::111111111111:mfa/me is in partition aws, org "gov" is in aws-us-gov`)
+
+	tests := []struct {
+		name     string
+		org      OrgConfig
+		expected string
+	}{
+		{"RoleArn of another partition", OrgConfig{RoleArn: gov.RoleArn, Partition: PartitionChina},
+			`org "gov": RoleArn arn:aws-us-gov:iam::333333333333:role/Admin is in partition aws-us-gov, not in the Partition aws-cn`},
+		{"Device of another partition", OrgConfig{RoleArn: gov.RoleArn, Device: "arn:aws:iam::111111111111:mfa/me"},
+			`org "gov": Device arn:aws:iam::111111111111:mfa/me is in partition aws, not in the Partition aws-us-gov`},
+		{"Linked commercial account", OrgConfig{RoleArn: "arn:aws:iam::111111111111:role/Admin", LinkedAccount: "222222222222"},
+			`org "gov": LinkedAccount names the commercial account of a GovCloud or China account`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := base
+			conf.Orgs = map[string]OrgConfig{"gov": tt.org}
+			assert.ErrorContains(t, conf.ValidateOptions(), tt.expected)
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package appconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// maxSessionPolicyArns is the number of managed session policies STS accepts per call.
+const maxSessionPolicyArns = 10
+
+// policyARNPattern matches AWS managed and customer managed IAM policy ARNs in any partition.
+var policyARNPattern = regexp.MustCompile(`^arn:aws[a-z-]*:iam::(aws|\d{12}):policy/[\w+=,.@/-]+$`)
+
+// LoadSessionPolicy validates the managed session policy ARNs and reads the inline policy
+// document from PolicyFile. STS only accepts session policies on AssumeRole, not on
+// GetSessionToken, so they require --all or a recipe with roles, where they apply to the last.
+func (config *AppConfig) LoadSessionPolicy() error {
+	if len(config.PolicyArns) == 0 && config.PolicyFile == "" {
+		return nil
+	}
+	if recipe, _ := config.SelectedRecipe(); !config.All && len(recipe.Roles) == 0 {
+		return fmt.Errorf("session policies only apply to assumed roles and require --all or a recipe with roles")
+	}
+
+	if len(config.PolicyArns) > maxSessionPolicyArns {
+		return fmt.Errorf("at most %d policy ARNs may be given, got %d", maxSessionPolicyArns, len(config.PolicyArns))
+	}
+	for _, arn := range config.PolicyArns {
+		if !policyARNPattern.MatchString(arn) {
+			return fmt.Errorf("%q is not a valid policy ARN (expected arn:aws:iam::<account-id>:policy/<name>)", arn)
+		}
+	}
+
+	if config.PolicyFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(expandPath(config.PolicyFile))
+	if err != nil {
+		return fmt.Errorf("failed to read policy file: %w", err)
+	}
+	if !json.Valid(data) {
+		return fmt.Errorf("policy file %s is not valid JSON", config.PolicyFile)
+	}
+	config.Policy = string(data)
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package appconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePolicyArns(t *testing.T) {
+	resetLogging()
+
+	config := &AppConfig{}
+	assert.NoError(t, config.Parse([]string{"--all", "-t", "123456", "--policy-arns", "arn:aws:iam::aws:policy/ReadOnlyAccess, arn:aws:iam::111111111111:policy/Team"}))
+	assert.Equal(t, []string{"arn:aws:iam::aws:policy/ReadOnlyAccess", "arn:aws:iam::111111111111:policy/Team"}, config.PolicyArns)
+}
+
+func TestLoadSessionPolicy(t *testing.T) {
+	dir := t.TempDir()
+	policyFile := filepath.Join(dir, "policy.json")
+	assert.NoError(t, os.WriteFile(policyFile, []byte(`{"Version":"2012-10-17","Statement":[]}`), 0600))
+	invalidFile := filepath.Join(dir, "invalid.json")
+	assert.NoError(t, os.WriteFile(invalidFile, []byte(`{"Version":`), 0600))
+
+	tests := []struct {
+		name        string
+		config      AppConfig
+		expectedErr string
+	}{
+		{name: "No policies", config: AppConfig{}},
+		{
+			name:   "Managed and inline policies",
+			config: AppConfig{All: true, PolicyArns: []string{"arn:aws:iam::aws:policy/ReadOnlyAccess"}, PolicyFile: policyFile},
+		},
+		{
+			name: "Recipe with roles",
+			config: AppConfig{
+				Recipe:     "prod",
+				Recipes:    map[string]RecipeConfig{"prod": {Roles: []string{"arn:aws:iam::111111111111:role/Admin"}}},
+				PolicyArns: []string{"arn:aws:iam::aws:policy/ReadOnlyAccess"},
+			},
+		},
+		{
+			name:        "Requires --all",
+			config:      AppConfig{PolicyArns: []string{"arn:aws:iam::aws:policy/ReadOnlyAccess"}},
+			expectedErr: "require --all",
+		},
+		{
+			name:        "Invalid ARN",
+			config:      AppConfig{All: true, PolicyArns: []string{"arn:aws:iam::aws:role/Admin"}},
+			expectedErr: "not a valid policy ARN",
+		},
+		{
+			name:        "Too many ARNs",
+			config:      AppConfig{All: true, PolicyArns: make([]string, 11)},
+			expectedErr: "at most 10 policy ARNs",
+		},
+		{
+			name:        "Missing file",
+			config:      AppConfig{All: true, PolicyFile: filepath.Join(dir, "missing.json")},
+			expectedErr: "failed to read policy file",
+		},
+		{
+			name:        "Invalid JSON",
+			config:      AppConfig{All: true, PolicyFile: invalidFile},
+			expectedErr: "is not valid JSON",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.LoadSessionPolicy()
+			if tt.expectedErr != "" {
+				assert.ErrorContains(t, err, tt.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+			if tt.config.PolicyFile != "" {
+				assert.Equal(t, `{"Version":"2012-10-17","Statement":[]}`, tt.config.Policy)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package appconfig
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+)
+
+// ErrAccountAliasUnknown is returned when a profile name template uses AccountAlias but the
+// alias can only be looked up with the credentials issued at login.
+var ErrAccountAliasUnknown = errors.New("the account alias is only known at login")
+
+// ProfileData is the data available to profile name templates such as "{{.Org}}-{{.Role}}-mfa".
+type ProfileData struct {
+	Org       string // Org of the credentials, the recipe name for the profile of a recipe.
+	Role      string // Name of the role assumed, empty for a plain MFA session.
+	AccountID string // Account of the role, or of the MFA device for a plain session.
+
+	alias func() (string, error) // Looks up the account alias, see WithAccountAlias.
+}
+
+// AccountAlias returns the IAM alias of the account, which is looked up with the credentials
+// the profile is written with. It is empty for accounts that have no alias.
+func (data ProfileData) AccountAlias() (string, error) {
+	if data.alias == nil {
+		return "", ErrAccountAliasUnknown
+	}
+	return data.alias()
+}
+
+// WithAccountAlias returns data with AccountAlias answered by lookup, which is only called
+// when the template uses it.
+func (data ProfileData) WithAccountAlias(lookup func() (string, error)) ProfileData {
+	data.alias = lookup
+	return data
+}
+
+// RenderProfile evaluates a profile name template. Names without "{{" are returned as they
+// are, so plain profile names need no escaping.
+func RenderProfile(pattern string, data ProfileData) (string, error) {
+	if !strings.Contains(pattern, "{{") {
+		return pattern, nil
+	}
+	tmpl, err := template.New("Profile").Option("missingkey=error").Parse(pattern)
+	if err != nil {
+		return "", fmt.Errorf("invalid profile name template %q: %w", pattern, err)
+	}
+	var out strings.Builder
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("failed to render profile name %q: %w", pattern, err)
+	}
+	profile := strings.TrimSpace(out.String())
+	if err := ValidateProfileName(profile); err != nil {
+		return "", fmt.Errorf("profile name template %q renders to the %w", pattern, err)
+	}
+	return profile, nil
+}
+
+// ValidateProfileName returns an error wrapping ErrInvalidProfile when name cannot be read back
+// as the name of an INI section: it is empty, has whitespace around it, holds brackets or line
+// breaks, or starts like a comment.
+func ValidateProfileName(name string) error {
+	var problem string
+	switch {
+	case name == "":
+		problem = "it is empty"
+	case strings.TrimSpace(name) != name:
+		problem = "it starts or ends with whitespace"
+	case strings.ContainsAny(name, "[]\r\n"):
+		problem = "it contains a bracket or line break"
+	case strings.HasPrefix(name, "#"), strings.HasPrefix(name, ";"):
+		problem = "it starts with a comment character"
+	default:
+		return nil
+	}
+	return fmt.Errorf("%w %q: %s", ErrInvalidProfile, name, problem)
+}
+
+// SessionProfileData returns the data for the template of the session profile. For a recipe
+// with roles it describes the last role of the chain, whose credentials end up in the profile.
+func (config AppConfig) SessionProfileData() ProfileData {
+	if recipe, ok := config.SelectedRecipe(); ok && len(recipe.Roles) > 0 {
+		data := roleProfileData(recipe.Roles[len(recipe.Roles)-1])
+		data.Org = config.Recipe
+		return data
+	}
+	data := ProfileData{Org: config.Org}
+	if device, err := arn.Parse(config.Device); err == nil {
+		data.AccountID = device.AccountID
+	}
+	return data
+}
+
+// ProfileData returns the data for the profile name template of the org called name.
+func (org OrgConfig) ProfileData(name string) ProfileData {
+	data := roleProfileData(org.RoleArn)
+	data.Org = name
+	return data
+}
+
+// roleProfileData returns the role name and account of a role ARN.
+func roleProfileData(roleArn string) ProfileData {
+	role, err := arn.Parse(roleArn)
+	if err != nil {
+		return ProfileData{}
+	}
+	return ProfileData{Role: role.Resource[strings.LastIndex(role.Resource, "/")+1:], AccountID: role.AccountID}
+}
+
+// renderSessionProfile evaluates a template given as the session profile and checks the name
+// it gives. A template using AccountAlias is kept as it is, to be evaluated at login once the
+// alias can be looked up.
+func (config *AppConfig) renderSessionProfile() error {
+	profile, err := RenderProfile(config.Profile, config.SessionProfileData())
+	switch {
+	case errors.Is(err, ErrAccountAliasUnknown):
+		return nil
+	case err != nil:
+		return err
+	}
+	config.Profile = profile
+	if profile == "" {
+		return nil // Parse sets default-mfa, and the writers fall back to it too
+	}
+	return ValidateProfileName(profile)
+}
@@ -0,0 +1,93 @@
+package appconfig
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderProfile(t *testing.T) {
+	data := OrgConfig{RoleArn: "arn:aws:iam::111111111111:role/team/Admin"}.ProfileData("prod")
+
+	profile, err := RenderProfile("{{.Org}}-{{.Role}}-mfa", data)
+	assert.NoError(t, err)
+	assert.Equal(t, "prod-Admin-mfa", profile)
+
+	profile, err = RenderProfile("plain-name", ProfileData{})
+	assert.NoError(t, err)
+	assert.Equal(t, "plain-name", profile)
+
+	_, err = RenderProfile("{{.AccountAlias}}", data)
+	assert.ErrorIs(t, err, ErrAccountAliasUnknown)
+
+	profile, err = RenderProfile("{{.AccountAlias}}-{{.AccountID}}", data.WithAccountAlias(func() (string, error) { return "acme", nil }))
+	assert.NoError(t, err)
+	assert.Equal(t, "acme-111111111111", profile)
+
+	_, err = RenderProfile("{{.Account}}", data)
+	assert.ErrorContains(t, err, "failed to render profile name")
+	_, err = RenderProfile("{{.Org", data)
+	assert.ErrorContains(t, err, "invalid profile name template")
+	_, err = RenderProfile("{{.Role}}", ProfileData{})
+	assert.ErrorContains(t, err, "renders to the invalid profile name")
+}
+
+func TestValidateProfileName(t *testing.T) {
+	for _, name := range []string{"default-mfa", "prod.admin@acme", "team/dev_1"} {
+		assert.NoError(t, ValidateProfileName(name), name)
+	}
+	for name, problem := range map[string]string{
+		"":       "it is empty",
+		" prod":  "it starts or ends with whitespace",
+		"prod]x": "it contains a bracket or line break",
+		"a\nb":   "it contains a bracket or line break",
+		"#prod":  "it starts with a comment character",
+		";prod":  "it starts with a comment character",
+	} {
+		err := ValidateProfileName(name)
+		assert.ErrorIs(t, err, ErrInvalidProfile, name)
+		assert.ErrorContains(t, err, problem, name)
+	}
+}
+
+func TestSessionProfileData(t *testing.T) {
+	config := AppConfig{Org: "work", Device: "arn:aws:iam::123456789012:mfa/me"}
+	assert.Equal(t, ProfileData{Org: "work", AccountID: "123456789012"}, config.SessionProfileData())
+
+	config.Recipe = "admin"
+	config.Recipes = map[string]RecipeConfig{"admin": {Roles: []string{"arn:aws:iam::111111111111:role/Jump", "arn:aws:iam::222222222222:role/Admin"}}}
+	assert.Equal(t, ProfileData{Org: "admin", Role: "Admin", AccountID: "222222222222"}, config.SessionProfileData())
+}
+
+func TestValidateOptionsProfileTemplates(t *testing.T) {
+	resetLogging()
+
+	base := AppConfig{Token: "123456", Org: "work", Device: "arn:aws:iam::123456789012:mfa/me", Config: filepath.Join(t.TempDir(), "missing.yml"), NoWrite: true}
+
+	conf := base
+	conf.Profile = "{{.Org}}-{{.AccountID}}"
+	assert.NoError(t, conf.ValidateOptions())
+	assert.Equal(t, "work-123456789012", conf.Profile)
+
+	conf = base
+	conf.Profile = "[prod]"
+	assert.ErrorIs(t, conf.ValidateOptions(), ErrInvalidProfile)
+
+	conf = base
+	conf.Profile = "{{.AccountAlias}}"
+	assert.NoError(t, conf.ValidateOptions())
+	assert.Equal(t, "{{.AccountAlias}}", conf.Profile, "evaluated at login")
+
+	conf = base
+	conf.Orgs = map[string]OrgConfig{"prod": {RoleArn: "arn:aws:iam::111111111111:role/Admin", Profile: "{{.Acount}}"}}
+	assert.ErrorContains(t, conf.ValidateOptions(), `org "prod"`)
+	assert.Equal(t, "{{.Acount}}", conf.Orgs["prod"].ProfileName("prod"))
+}
+
+func TestOrgProfileName(t *testing.T) {
+	org := OrgConfig{RoleArn: "arn:aws:iam::111111111111:role/Admin", Profile: "{{.AccountAlias}}-{{.Role}}"}
+	assert.Equal(t, "111111111111-Admin", org.ProfileName("prod"), "the account ID stands in for the alias before login")
+	assert.Equal(t, "{{.AccountAlias}}-{{.Role}}", org.ProfileTemplate("prod"))
+	assert.Equal(t, "prod-mfa", OrgConfig{}.ProfileTemplate("prod"))
+}
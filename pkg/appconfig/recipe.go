@@ -0,0 +1,75 @@
+package appconfig
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// RecipeConfig is a named login recipe, run with "gredentures login <recipe>". The MFA session
+// is started from the recipe's source profile and each role in Roles is then assumed with the
+// credentials of the previous step. The final credentials are written to the recipe's profile.
+type RecipeConfig struct {
+	SourceProfile string   `koanf:"SourceProfile"` // Profile holding the long-lived keys, overrides the top-level one.
+	SourceFile    string   `koanf:"SourceFile"`    // Credentials file holding SourceProfile.
+	Device        string   `koanf:"Device"`        // MFA device, overrides the top-level one.
+	Roles         []string `koanf:"Roles"`         // Role ARNs assumed in order, none for a plain MFA session.
+	Region        string   `koanf:"Region"`        // Region for the STS calls, also written to the profile.
+	Timeout       int32    `koanf:"Timeout"`       // Duration of the final credentials in seconds (STS default when zero).
+	Profile       string   `koanf:"Profile"`       // Profile name to write the credentials to.
+	ExternalID    string   `koanf:"ExternalID"`    // External ID required by the last role, for a third party's account.
+	RefreshMargin int32    `koanf:"RefreshMargin"` // How long before expiry the credentials are expiring soon, in seconds.
+}
+
+// ProfileName returns the profile the recipe's credentials are written to, defaulting to the
+// recipe name when no profile is configured.
+func (recipe RecipeConfig) ProfileName(name string) string {
+	if recipe.Profile != "" {
+		return recipe.Profile
+	}
+	return name
+}
+
+// SelectedRecipe returns the recipe named on the command line, if any.
+func (config AppConfig) SelectedRecipe() (RecipeConfig, bool) {
+	if config.Recipe == "" {
+		return RecipeConfig{}, false
+	}
+	recipe, ok := config.Recipes[config.Recipe]
+	return recipe, ok
+}
+
+// applyRecipe fills in the device, profile and duration of the selected recipe. Values given
+// on the command line still take precedence.
+func (config *AppConfig) applyRecipe() error {
+	if config.Recipe == "" {
+		return nil
+	}
+	recipe, ok := config.SelectedRecipe()
+	if !ok {
+		names := make([]string, 0, len(config.Recipes))
+		for name := range config.Recipes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			return fmt.Errorf("unknown recipe %q, no recipes are configured under Recipes in the config file", config.Recipe)
+		}
+		return fmt.Errorf("unknown recipe %q, expected one of %s", config.Recipe, strings.Join(names, ", "))
+	}
+	if config.All {
+		return fmt.Errorf("--all cannot be combined with a recipe")
+	}
+
+	if recipe.Device != "" && config.source("Device") != SourceFlag {
+		config.Device = recipe.Device
+	}
+	if config.source("Profile") != SourceFlag {
+		config.Profile = recipe.ProfileName(config.Recipe)
+	}
+	// With roles the duration applies to the last role instead of the MFA session
+	if recipe.Timeout > 0 && len(recipe.Roles) == 0 && config.source("Timeout") != SourceFlag {
+		config.Timeout = recipe.Timeout
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package appconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRecipe(t *testing.T) {
+	resetLogging()
+
+	config := &AppConfig{}
+	assert.NoError(t, config.Parse([]string{"login", "prod-admin", "-t", "123456"}))
+	assert.True(t, config.Login)
+	assert.Equal(t, "prod-admin", config.Recipe)
+}
+
+func TestLoadGredenturesConfigRecipes(t *testing.T) {
+	resetLogging()
+
+	path := filepath.Join(t.TempDir(), "config.yml")
+	assert.NoError(t, os.WriteFile(path, []byte(`
+gredentures:
+  Recipes:
+    prod-admin:
+      SourceProfile: work
+      Region: eu-west-1
+      Timeout: 1h
+      Roles:
+        - arn:aws:iam::111111111111:role/Jump
+        - arn:aws:iam::222222222222:role/Admin
+`), 0600))
+
+	conf := &AppConfig{Config: path}
+	assert.NoError(t, conf.LoadGredenturesConfig())
+	assert.Equal(t, map[string]RecipeConfig{
+		"prod-admin": {
+			SourceProfile: "work",
+			Region:        "eu-west-1",
+			Timeout:       3600,
+			Roles:         []string{"arn:aws:iam::111111111111:role/Jump", "arn:aws:iam::222222222222:role/Admin"},
+		},
+	}, conf.Recipes)
+}
+
+func TestApplyRecipe(t *testing.T) {
+	recipes := map[string]RecipeConfig{
+		"prod-admin": {Device: "arn:aws:iam::123456789012:mfa/work", Roles: []string{"arn:aws:iam::111111111111:role/Admin"}, Timeout: 900},
+		"dev":        {Profile: "dev-session", Timeout: 7200},
+	}
+
+	t.Run("Fills in the device and profile", func(t *testing.T) {
+		config := &AppConfig{Recipe: "prod-admin", Recipes: recipes, Device: "file-device", Profile: "default-mfa", Timeout: 86400}
+		assert.NoError(t, config.applyRecipe())
+		assert.Equal(t, "arn:aws:iam::123456789012:mfa/work", config.Device)
+		assert.Equal(t, "prod-admin", config.Profile)
+		assert.Equal(t, int32(86400), config.Timeout, "the duration applies to the last role")
+	})
+
+	t.Run("Applies the duration to a plain MFA session", func(t *testing.T) {
+		config := &AppConfig{Recipe: "dev", Recipes: recipes, Profile: "default-mfa", Timeout: 86400}
+		assert.NoError(t, config.applyRecipe())
+		assert.Equal(t, "dev-session", config.Profile)
+		assert.Equal(t, int32(7200), config.Timeout)
+	})
+
+	t.Run("Command line flags take precedence", func(t *testing.T) {
+		config := &AppConfig{Recipe: "prod-admin", Recipes: recipes, Device: "flag-device", Profile: "mine"}
+		config.setSource("Device", SourceFlag)
+		config.setSource("Profile", SourceFlag)
+		assert.NoError(t, config.applyRecipe())
+		assert.Equal(t, "flag-device", config.Device)
+		assert.Equal(t, "mine", config.Profile)
+	})
+
+	t.Run("Unknown recipe", func(t *testing.T) {
+		config := &AppConfig{Recipe: "prod", Recipes: recipes}
+		assert.EqualError(t, config.applyRecipe(), `unknown recipe "prod", expected one of dev, prod-admin`)
+	})
+
+	t.Run("Cannot be combined with --all", func(t *testing.T) {
+		config := &AppConfig{Recipe: "dev", Recipes: recipes, All: true}
+		assert.ErrorContains(t, config.applyRecipe(), "--all")
+	})
+}
+
+func TestRecipeSource(t *testing.T) {
+	config := AppConfig{
+		SourceProfile: "default",
+		Recipe:        "prod-admin",
+		Recipes:       map[string]RecipeConfig{"prod-admin": {SourceProfile: "work", SourceFile: "/keys/credentials"}},
+	}
+	profile, file := config.Source()
+	assert.Equal(t, "work", profile)
+	assert.Equal(t, "/keys/credentials", file)
+}
@@ -0,0 +1,292 @@
+package appconfig
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	y "gopkg.in/yaml.v3"
+)
+
+// RosterEntry is a user of the roster admin bootstrap generates configs for.
+type RosterEntry struct {
+	User    string            `json:"user"`    // IAM user name, naming the config and the placeholder MFA device.
+	Email   string            `json:"email"`   // Address the config is mailed to with --mail.
+	Org     string            `json:"org"`     // Org selected by default, the Org of the template when empty.
+	Account string            `json:"account"` // Account of the IAM user, that of the Device of the template when empty.
+	Roles   map[string]string `json:"roles"`   // RoleArn of each org the user gets, empty to keep that of the template. Every org of the template when nil.
+}
+
+// rosterColumns are the columns of a CSV roster, the user column required.
+var rosterColumns = []string{"user", "email", "org", "account", "roles"}
+
+// iamUserPattern matches IAM user names, which config files are named after.
+var iamUserPattern = regexp.MustCompile(`^[\w+=,.@-]{1,64}$`)
+
+// ReadRoster reads the users of a roster file: a JSON array of RosterEntry objects when path
+// ends in .json, else CSV with a header row naming rosterColumns. The roles of a CSV user are
+// separated by semicolons, each an org of the template or org=RoleArn, e.g.
+// "prod;dev=arn:aws:iam::222222222222:role/Developer".
+func ReadRoster(path string) ([]RosterEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read roster: %w", err)
+	}
+	var entries []RosterEntry
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&entries); err != nil {
+			return nil, fmt.Errorf("invalid roster %s: %w", path, err)
+		}
+	} else if entries, err = parseRosterCSV(data); err != nil {
+		return nil, fmt.Errorf("invalid roster %s: %w", path, err)
+	}
+
+	seen := map[string]bool{}
+	for i, entry := range entries {
+		switch {
+		case !iamUserPattern.MatchString(entry.User):
+			return nil, fmt.Errorf("roster %s: user %d: %q is not an IAM user name", path, i+1, entry.User)
+		case seen[entry.User]:
+			return nil, fmt.Errorf("roster %s: user %s is listed twice", path, entry.User)
+		case entry.Account != "" && !accountIDPattern.MatchString(entry.Account):
+			return nil, fmt.Errorf("roster %s: user %s: %q is not a twelve digit account ID", path, entry.User, entry.Account)
+		}
+		if entry.Email != "" {
+			address, err := mail.ParseAddress(entry.Email)
+			if err != nil {
+				return nil, fmt.Errorf("roster %s: user %s: invalid email %q: %w", path, entry.User, entry.Email, err)
+			}
+			entries[i].Email = address.String()
+		}
+		seen[entry.User] = true
+	}
+	return entries, nil
+}
+
+// parseRosterCSV parses a CSV roster, see ReadRoster.
+func parseRosterCSV(data []byte) ([]RosterEntry, error) {
+	// Spreadsheets exporting UTF-8 CSV start the file with a byte order mark
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\ufeff"))))
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !slices.Contains(rosterColumns, name) {
+			return nil, fmt.Errorf("unknown column %q, expected %s", name, strings.Join(rosterColumns, ", "))
+		}
+		columns[name] = i
+	}
+	if _, ok := columns["user"]; !ok {
+		return nil, fmt.Errorf("the header has no user column")
+	}
+
+	var entries []RosterEntry
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		entry := RosterEntry{User: field("user"), Email: field("email"), Org: field("org"), Account: field("account")}
+		for _, role := range strings.Split(field("roles"), ";") {
+			if role = strings.TrimSpace(role); role == "" {
+				continue
+			}
+			if entry.Roles == nil {
+				entry.Roles = map[string]string{}
+			}
+			org, roleArn, _ := strings.Cut(role, "=")
+			entry.Roles[strings.TrimSpace(org)] = strings.TrimSpace(roleArn)
+		}
+		entries = append(entries, entry)
+	}
+}
+
+// bootstrapHeader is the comment at the top of every config admin bootstrap generates.
+const bootstrapHeader = "gredentures config of %s, save it as ~/.config/gredentures/config.yml"
+
+// UserConfig returns the config file of a roster user: the redacted bundle of conf's config
+// file, see ExportBundle, with the Org of the user, the orgs of their Roles, and a Device
+// naming a virtual MFA device after the user in their account. The MFA device of conf gives
+// the account and partition when the user has none, and the first org of the user stands in
+// for the Org of conf when they have no role in it. The result is validated like any config
+// file.
+func (conf *AppConfig) UserConfig(entry RosterEntry) ([]byte, error) {
+	data, err := conf.ExportBundle(false)
+	if err != nil {
+		return nil, err
+	}
+	var doc y.Node
+	if err := y.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid bundle: %w", err)
+	}
+	root := doc.Content[0]
+	root.Content[0].HeadComment = fmt.Sprintf(bootstrapHeader, entry.User)
+	section, err := mappingEntry(root, "gredentures", y.MappingNode)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry.Org != "" {
+		field, _ := mappingEntry(section, "Org", y.ScalarNode)
+		field.SetString(entry.Org)
+	}
+	if device := userDevice(entry, conf.Device); device != "" {
+		// Next to the Org, where a config file written by gredentures has it
+		field := &y.Node{LineComment: "Placeholder until gredentures device enroll creates your MFA device"}
+		field.SetString(device)
+		at := 0
+		for i := 0; i+1 < len(section.Content); i += 2 {
+			if section.Content[i].Value == "Org" {
+				at = i + 2
+			}
+		}
+		section.Content = slices.Insert(section.Content, at, &y.Node{Kind: y.ScalarNode, Value: "Device"}, field)
+	}
+	if entry.Roles != nil {
+		if err := userOrgs(section, entry); err != nil {
+			return nil, err
+		}
+	}
+	if err := userOrg(section, entry); err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	encoder := y.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to marshal the config of %s: %w", entry.User, err)
+	}
+	if err := ValidateConfig(out.Bytes()); err != nil {
+		return nil, fmt.Errorf("invalid config for %s:\n%w", entry.User, err)
+	}
+	return out.Bytes(), nil
+}
+
+// userDevice returns the placeholder MFA device of a roster user, named after the user in their
+// account, or "" when neither the user nor the template device names an account.
+func userDevice(entry RosterEntry, template string) string {
+	device := arn.ARN{Partition: PartitionAWS, Service: "iam", AccountID: entry.Account, Resource: "mfa/" + entry.User}
+	if parsed, err := arn.Parse(template); err == nil {
+		device.Partition, device.AccountID = parsed.Partition, cmp.Or(entry.Account, parsed.AccountID)
+	}
+	if device.AccountID == "" {
+		return ""
+	}
+	return device.String()
+}
+
+// userOrgs keeps the orgs of a bundle's gredentures mapping that the roster user has a role in,
+// in their order, setting the RoleArn the roster gives, then adds the orgs the template lacks.
+func userOrgs(section *y.Node, entry RosterEntry) error {
+	orgs, err := mappingEntry(section, "Orgs", y.MappingNode)
+	if err != nil {
+		return err
+	}
+	kept := &y.Node{Kind: y.MappingNode}
+	for i := 0; i+1 < len(orgs.Content); i += 2 {
+		if _, ok := entry.Roles[orgs.Content[i].Value]; ok {
+			kept.Content = append(kept.Content, orgs.Content[i], orgs.Content[i+1])
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(entry.Roles)) {
+		org := entryValue(kept, name)
+		switch roleArn := entry.Roles[name]; {
+		case org == nil && roleArn == "":
+			return fmt.Errorf("user %s: org %q is not in the config file, give its RoleArn as %s=<role-arn>", entry.User, name, name)
+		case org == nil:
+			kept.Content = append(kept.Content, &y.Node{Kind: y.ScalarNode, Value: name},
+				&y.Node{Kind: y.MappingNode, Content: []*y.Node{{Kind: y.ScalarNode, Value: "RoleArn"}, {Kind: y.ScalarNode, Value: roleArn}}})
+		case roleArn != "":
+			field, err := mappingEntry(org, "RoleArn", y.ScalarNode)
+			if err != nil {
+				return fmt.Errorf("user %s: org %q: %w", entry.User, name, err)
+			}
+			field.SetString(roleArn)
+		}
+	}
+	*orgs = *kept
+	return nil
+}
+
+// userOrg checks that the Org of a bundle's gredentures mapping is one of the orgs left to the
+// roster user. The Org of the template is replaced by the first of them when the roster gives
+// the user none, only an Org the roster gives is refused.
+func userOrg(section *y.Node, entry RosterEntry) error {
+	orgs := entryValue(section, "Orgs")
+	field := entryValue(section, "Org")
+	if orgs == nil || orgs.Kind != y.MappingNode || field == nil || entryValue(orgs, field.Value) != nil {
+		return nil
+	}
+	switch {
+	case entry.Org != "":
+		return fmt.Errorf("user %s: org %q is not one of their orgs", entry.User, entry.Org)
+	case len(orgs.Content) > 0:
+		field.SetString(orgs.Content[0].Value)
+	}
+	return nil
+}
+
+// BootstrapMail returns the mail carrying the config of a roster user, for sendmail -t: a short
+// note on installing it and the config as an attachment.
+func BootstrapMail(entry RosterEntry, config []byte) ([]byte, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	note, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(note, "Hi %s,\n\nattached is your gredentures config. To set up gredentures:\n\n"+
+		"1. Save the attachment as ~/.config/gredentures/config.yml.\n"+
+		"2. Run gredentures device enroll to create your MFA device, it replaces the placeholder Device.\n"+
+		"3. Run gredentures to log in, it asks for your access keys on the first run.\n", entry.User)
+	attachment, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":        {`application/yaml; name="config.yml"`},
+		"Content-Disposition": {`attachment; filename="config.yml"`},
+	})
+	if err != nil {
+		return nil, err
+	}
+	attachment.Write(config)
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	var mail bytes.Buffer
+	fmt.Fprintf(&mail, "To: %s\r\nSubject: Your gredentures config\r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=%s\r\n\r\n",
+		entry.Email, writer.Boundary())
+	mail.Write(body.Bytes())
+	return mail.Bytes(), nil
+}
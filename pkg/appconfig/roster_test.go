@@ -0,0 +1,146 @@
+package appconfig
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadRoster(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(data), 0o644))
+		return path
+	}
+
+	t.Run("Reads a CSV roster", func(t *testing.T) {
+		path := write("roster.csv", "\ufeffUser, Email, Org, Account, Roles\n"+
+			"alice,alice@example.com,prod,,\n"+
+			"bob,Bob <bob@example.com>,dev,444444444444,\"dev; sandbox=arn:aws:iam::333333333333:role/Sandbox\"\n")
+		entries, err := ReadRoster(path)
+		require.NoError(t, err)
+		assert.Equal(t, []RosterEntry{
+			{User: "alice", Email: "<alice@example.com>", Org: "prod"},
+			{User: "bob", Email: `"Bob" <bob@example.com>`, Org: "dev", Account: "444444444444",
+				Roles: map[string]string{"dev": "", "sandbox": "arn:aws:iam::333333333333:role/Sandbox"}},
+		}, entries)
+	})
+
+	t.Run("Reads a JSON roster", func(t *testing.T) {
+		path := write("roster.json", `[{"user": "carol", "roles": {"prod": ""}}]`)
+		entries, err := ReadRoster(path)
+		require.NoError(t, err)
+		assert.Equal(t, []RosterEntry{{User: "carol", Roles: map[string]string{"prod": ""}}}, entries)
+	})
+
+	tests := []struct {
+		name, file, data, err string
+	}{
+		{"Unknown column", "roster.csv", "user,team\nalice,ops\n", `unknown column "team"`},
+		{"No user column", "roster.csv", "email\nalice@example.com\n", "no user column"},
+		{"Bad user name", "roster.csv", "user\n../alice\n", `"../alice" is not an IAM user name`},
+		{"User listed twice", "roster.csv", "user\nalice\nalice\n", "user alice is listed twice"},
+		{"Bad account", "roster.csv", "user,account\nalice,123\n", `"123" is not a twelve digit account ID`},
+		{"Bad email", "roster.csv", "user,email\nalice,alice\n", `invalid email "alice"`},
+		{"Unknown JSON field", "roster.json", `[{"user": "alice", "team": "ops"}]`, `unknown field "team"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadRoster(write(tt.file, tt.data))
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+}
+
+func TestUserConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	require.NoError(t, os.WriteFile(path, []byte(`gredentures:
+  Org: prod
+  Device: arn:aws:iam::123456789012:mfa/admin
+  TokenCommand: op item get aws --otp
+  Orgs:
+    # Production
+    prod:
+      RoleArn: arn:aws:iam::111111111111:role/Admin
+    dev:
+      RoleArn: arn:aws:iam::222222222222:role/Developer
+`), 0o644))
+	conf := &AppConfig{Config: path}
+	require.NoError(t, conf.LoadGredenturesConfig())
+
+	t.Run("Gets every org of the template without roles", func(t *testing.T) {
+		data, err := conf.UserConfig(RosterEntry{User: "alice"})
+		require.NoError(t, err)
+		assert.Equal(t, `# gredentures config of alice, save it as ~/.config/gredentures/config.yml
+version: 2
+gredentures:
+  Org: prod
+  Device: arn:aws:iam::123456789012:mfa/alice # Placeholder until gredentures device enroll creates your MFA device
+  Orgs:
+    # Production
+    prod:
+      RoleArn: arn:aws:iam::111111111111:role/Admin
+    dev:
+      RoleArn: arn:aws:iam::222222222222:role/Developer
+`, string(data), "the token command of the admin is left out")
+	})
+
+	t.Run("Gets the orgs of their roles", func(t *testing.T) {
+		data, err := conf.UserConfig(RosterEntry{User: "bob", Org: "sandbox", Account: "444444444444", Roles: map[string]string{
+			"dev":     "arn:aws:iam::222222222222:role/ReadOnly",
+			"sandbox": "arn:aws:iam::333333333333:role/Sandbox",
+		}})
+		require.NoError(t, err)
+		assert.Equal(t, `# gredentures config of bob, save it as ~/.config/gredentures/config.yml
+version: 2
+gredentures:
+  Org: sandbox
+  Device: arn:aws:iam::444444444444:mfa/bob # Placeholder until gredentures device enroll creates your MFA device
+  Orgs:
+    dev:
+      RoleArn: arn:aws:iam::222222222222:role/ReadOnly
+    sandbox:
+      RoleArn: arn:aws:iam::333333333333:role/Sandbox
+`, string(data))
+	})
+
+	t.Run("Defaults to their first org without a role in that of the template", func(t *testing.T) {
+		data, err := conf.UserConfig(RosterEntry{User: "erin", Roles: map[string]string{"dev": ""}})
+		require.NoError(t, err)
+		assert.Contains(t, string(data), "\n  Org: dev\n")
+	})
+
+	t.Run("Refuses an Org they have no role in", func(t *testing.T) {
+		_, err := conf.UserConfig(RosterEntry{User: "frank", Org: "prod", Roles: map[string]string{"dev": ""}})
+		assert.ErrorContains(t, err, `user frank: org "prod" is not one of their orgs`)
+		_, err = conf.UserConfig(RosterEntry{User: "frank", Org: "staging"})
+		assert.ErrorContains(t, err, `user frank: org "staging" is not one of their orgs`)
+	})
+
+	t.Run("Needs the RoleArn of an org the template lacks", func(t *testing.T) {
+		_, err := conf.UserConfig(RosterEntry{User: "carol", Roles: map[string]string{"sandbox": ""}})
+		assert.ErrorContains(t, err, `org "sandbox" is not in the config file`)
+	})
+
+	t.Run("Refuses a malformed RoleArn", func(t *testing.T) {
+		_, err := conf.UserConfig(RosterEntry{User: "dave", Roles: map[string]string{"prod": "arn:aws:iam::111111111111:user/Admin"}})
+		assert.ErrorContains(t, err, "is not a valid role ARN")
+	})
+}
+
+func TestBootstrapMail(t *testing.T) {
+	mail, err := BootstrapMail(RosterEntry{User: "alice", Email: "<alice@example.com>"}, []byte("gredentures:\n  Org: prod\n"))
+	require.NoError(t, err)
+	header, body, ok := strings.Cut(string(mail), "\r\n\r\n")
+	require.True(t, ok)
+	assert.Contains(t, header, "To: <alice@example.com>\r\n")
+	assert.Contains(t, header, "Content-Type: multipart/mixed; boundary=")
+	assert.Contains(t, body, "gredentures device enroll")
+	assert.Contains(t, body, `Content-Disposition: attachment; filename="config.yml"`)
+	assert.Contains(t, body, "gredentures:\n  Org: prod\n")
+}
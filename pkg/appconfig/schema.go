@@ -0,0 +1,456 @@
+package appconfig
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gredentures/pkg/validate"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	y "gopkg.in/yaml.v3"
+)
+
+// schemaKind identifies how a value in the config file is validated.
+type schemaKind int
+
+const (
+	kindString     schemaKind = iota // Any scalar string.
+	kindBool                         // true or false.
+	kindTimeout                      // Seconds or a duration accepted by ParseTimeout.
+	kindDevice                       // MFA device ARN or hardware token serial number.
+	kindRoleARN                      // IAM role ARN.
+	kindExternalID                   // External ID sent with AssumeRole.
+	kindProxy                        // http, https or socks5 proxy URL.
+	kindRetryMode                    // One of RetryModes.
+	kindSDKLog                       // One of SDKLogModes.
+	kindOutput                       // One of ConfigOutputs.
+	kindCount                        // Whole number of at least 1.
+	kindPartition                    // One of Partitions.
+	kindAccountID                    // Twelve digit AWS account ID.
+	kindSTSRegion                    // Region name or STSGlobal.
+	kindTemplate                     // text/template accepted by RenderLoginMessage.
+	kindStringList                   // Sequence of strings.
+	kindMapping                      // Mapping with a fixed set of keys.
+	kindEntries                      // Mapping of arbitrary names to values of the same shape.
+)
+
+// schemaField describes the expected shape of a single config value.
+type schemaField struct {
+	kind   schemaKind
+	fields map[string]schemaField // Allowed keys for kindMapping.
+	entry  *schemaField           // Shape of every value for kindEntries, or of every item for kindStringList.
+}
+
+// orgSchema describes a single entry under Orgs.
+var orgSchema = schemaField{kind: kindMapping, fields: map[string]schemaField{
+	"RoleArn":       {kind: kindRoleARN},
+	"Profile":       {kind: kindString},
+	"Timeout":       {kind: kindTimeout},
+	"SourceProfile": {kind: kindString},
+	"SourceFile":    {kind: kindString},
+	"ExternalID":    {kind: kindExternalID},
+	"KeyMaxAge":     {kind: kindTimeout},
+	"RefreshMargin": {kind: kindTimeout},
+	"Device":        {kind: kindDevice},
+	"Partition":     {kind: kindPartition},
+	"LinkedAccount": {kind: kindAccountID},
+}}
+
+// recipeSchema describes a single entry under Recipes.
+var recipeSchema = schemaField{kind: kindMapping, fields: map[string]schemaField{
+	"SourceProfile": {kind: kindString},
+	"SourceFile":    {kind: kindString},
+	"Device":        {kind: kindDevice},
+	"Roles":         {kind: kindStringList, entry: &schemaField{kind: kindRoleARN}},
+	"Region":        {kind: kindString},
+	"Timeout":       {kind: kindTimeout},
+	"Profile":       {kind: kindString},
+	"ExternalID":    {kind: kindExternalID},
+	"RefreshMargin": {kind: kindTimeout},
+}}
+
+// sdkSchema describes the SDK settings, and those of a single command under SDK.Commands.
+var sdkSchema = schemaField{kind: kindMapping, fields: map[string]schemaField{
+	"RetryMode":   {kind: kindRetryMode},
+	"MaxAttempts": {kind: kindCount},
+	"CallTimeout": {kind: kindTimeout},
+	"Log":         {kind: kindStringList, entry: &schemaField{kind: kindSDKLog}},
+}}
+
+// configSchema describes the layout of the gredentures config file.
+var configSchema = schemaField{kind: kindMapping, fields: map[string]schemaField{
+	"gredentures": {kind: kindMapping, fields: map[string]schemaField{
+		"Org":              {kind: kindString},
+		"Device":           {kind: kindDevice},
+		"Timeout":          {kind: kindTimeout},
+		"KeyMaxAge":        {kind: kindTimeout},
+		"RefreshMargin":    {kind: kindTimeout},
+		"SourceProfile":    {kind: kindString},
+		"SourceFile":       {kind: kindString},
+		"TokenCommand":     {kind: kindString},
+		"Prompt":           {kind: kindString},
+		"LoginMessage":     {kind: kindTemplate},
+		"Output":           {kind: kindOutput},
+		"CredentialsFile":  {kind: kindString},
+		"CredentialsFiles": {kind: kindStringList},
+		"CurrentProfile":   {kind: kindString},
+		"ManagedProfiles":  {kind: kindStringList},
+		"Favorites":        {kind: kindStringList},
+		"AuditLog":         {kind: kindString},
+		"Proxy":            {kind: kindProxy},
+		"CABundle":         {kind: kindString},
+		"SkipIMDS":         {kind: kindBool},
+		"CompatMode":       {kind: kindBool},
+		"STSRegions":       {kind: kindStringList, entry: &schemaField{kind: kindSTSRegion}},
+		"AllowArgvSecrets": {kind: kindBool},
+		"SystemMode":       {kind: kindBool},
+		"BaseConfigs":      {kind: kindStringList},
+		"ConfigPublicKeys": {kind: kindStringList},
+		"Orgs":             {kind: kindEntries, entry: &orgSchema},
+		"Recipes":          {kind: kindEntries, entry: &recipeSchema},
+		"OnePassword": {kind: kindMapping, fields: map[string]schemaField{
+			"Item":        {kind: kindString},
+			"Vault":       {kind: kindString},
+			"ConnectHost": {kind: kindString},
+		}},
+		"KeyStore": {kind: kindMapping, fields: map[string]schemaField{
+			"Backend": {kind: kindString},
+			"File":    {kind: kindString},
+		}},
+		"Push": {kind: kindMapping, fields: map[string]schemaField{
+			"RemotePath": {kind: kindString},
+		}},
+		"Agent": {kind: kindMapping, fields: map[string]schemaField{
+			"Socket":           {kind: kindString},
+			"AllowUIDs":        {kind: kindStringList},
+			"AllowBinaries":    {kind: kindStringList},
+			"WatchCredentials": {kind: kindBool},
+		}},
+		"Organization": {kind: kindMapping, fields: map[string]schemaField{
+			"RoleName": {kind: kindString},
+			"Tags":     {kind: kindEntries, entry: &schemaField{kind: kindString}},
+		}},
+		"Stats": {kind: kindMapping, fields: map[string]schemaField{
+			"Enabled":  {kind: kindBool},
+			"Endpoint": {kind: kindString},
+			"File":     {kind: kindString},
+		}},
+		"SDK": {kind: kindMapping, fields: map[string]schemaField{
+			"RetryMode":   sdkSchema.fields["RetryMode"],
+			"MaxAttempts": sdkSchema.fields["MaxAttempts"],
+			"CallTimeout": sdkSchema.fields["CallTimeout"],
+			"Log":         sdkSchema.fields["Log"],
+			"Commands":    {kind: kindEntries, entry: &sdkSchema},
+		}},
+	}},
+	configVersionKey: {kind: kindString}, // A whole number, checked by migrateConfig
+}}
+
+var (
+	// mfaSerialPattern matches hardware MFA serial numbers as accepted by STS.
+	mfaSerialPattern = regexp.MustCompile(`^[\w+=/:,.@-]{9,256}$`)
+	// externalIDPattern matches the external IDs accepted by AssumeRole.
+	externalIDPattern = regexp.MustCompile(`^[\w+=,.@:/-]+$`)
+)
+
+// SchemaError describes a single problem found while validating the config file.
+type SchemaError struct {
+	Line    int    // Line of the offending node, starting at 1.
+	Column  int    // Column of the offending node, starting at 1.
+	Message string // Description of the problem.
+
+	Suggestion string  // Known key an unknown key is likely a misspelling of, empty otherwise.
+	key        *y.Node // Unknown key, renamed to Suggestion by LintConfig.
+	mapping    *y.Node // Mapping holding key.
+}
+
+// Error implements the error interface.
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Message)
+}
+
+// ValidateConfig checks YAML config data against the config schema. It reports every
+// unknown key, wrong type, malformed ARN, and invalid duration it finds, joined into one error.
+func ValidateConfig(data []byte) error {
+	var doc y.Node
+	if err := y.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("invalid YAML: %w", err)
+	}
+	return errors.Join(validateDocument(&doc)...)
+}
+
+// validateDocument checks a parsed YAML config document against the config schema and returns
+// every problem found.
+func validateDocument(doc *y.Node) []error {
+	if doc.Kind != y.DocumentNode || len(doc.Content) == 0 {
+		return nil // Empty file
+	}
+
+	var errs []error
+	root := doc.Content[0]
+	// Files in the flat layout of older releases are checked as if nested. A key set both ways
+	// stays flat and is reported as unknown.
+	_ = nestFlatKeys(root, "gredentures")
+	validateNode(root, "", configSchema, &errs)
+	return errs
+}
+
+// validateNode validates a single node against its schema, appending any problems to errs.
+func validateNode(node *y.Node, path string, field schemaField, errs *[]error) {
+	fail := func(n *y.Node, format string, args ...any) {
+		*errs = append(*errs, &SchemaError{Line: n.Line, Column: n.Column, Message: fmt.Sprintf(format, args...)})
+	}
+
+	// Unset values are allowed everywhere and fall back to defaults
+	if node.Kind == y.ScalarNode && node.Tag == "!!null" {
+		return
+	}
+
+	switch field.kind {
+	case kindMapping, kindEntries:
+		if node.Kind != y.MappingNode {
+			fail(node, "%s must be a mapping", describePath(path))
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			keyPath := joinPath(path, key.Value)
+			if field.kind == kindEntries {
+				validateNode(value, keyPath, *field.entry, errs)
+				continue
+			}
+			child, ok := field.fields[key.Value]
+			if !ok {
+				if suggestion := suggestKey(key.Value, field.fields); suggestion != "" {
+					*errs = append(*errs, &SchemaError{Line: key.Line, Column: key.Column, Suggestion: suggestion, key: key, mapping: node,
+						Message: fmt.Sprintf("unknown key %q (did you mean %q?)", keyPath, suggestion)})
+				} else {
+					fail(key, "unknown key %q", keyPath)
+				}
+				continue
+			}
+			validateNode(value, keyPath, child, errs)
+		}
+		return
+	}
+
+	if field.kind == kindStringList {
+		if node.Kind != y.SequenceNode {
+			fail(node, "%s must be a list", describePath(path))
+			return
+		}
+		for i, item := range node.Content {
+			if item.Kind != y.ScalarNode {
+				fail(item, "%s entries must be single values", path)
+			} else if field.entry != nil {
+				validateNode(item, fmt.Sprintf("%s[%d]", path, i), *field.entry, errs)
+			}
+		}
+		return
+	}
+
+	if node.Kind != y.ScalarNode {
+		fail(node, "%s must be a single value", describePath(path))
+		return
+	}
+
+	// Values are checked as LoadGredenturesConfig reads them, with the whitespace around them trimmed
+	value := strings.TrimSpace(node.Value)
+	switch field.kind {
+	case kindBool:
+		if node.Tag != "!!bool" {
+			fail(node, "%s: %q must be true or false", path, value)
+		}
+	case kindTimeout:
+		if value == "0" {
+			return // Written by gredentures when no timeout is set
+		}
+		if _, err := ParseTimeout(value); err != nil {
+			fail(node, "%s: %v", path, err)
+		}
+	case kindDevice:
+		// Security keys are refused when logging in, the config must still load to enroll another device
+		if problem := deviceProblem(value); value != "" && problem != "" && !IsSecurityKey(value) {
+			fail(node, "%s: %s", path, problem)
+		}
+	case kindTemplate:
+		if _, err := parseLoginMessage(node.Value); err != nil {
+			fail(node, "%s: %v", path, err)
+		}
+	case kindExternalID:
+		if err := ValidateExternalID(value); err != nil {
+			fail(node, "%s: %v", path, err)
+		}
+	case kindProxy:
+		if err := ValidateProxy(value); err != nil {
+			fail(node, "%s: %v", path, err)
+		}
+	case kindRetryMode:
+		if !slices.Contains(RetryModes, value) {
+			fail(node, "%s: %q must be one of %s", path, value, strings.Join(RetryModes, ", "))
+		}
+	case kindSDKLog:
+		if !slices.Contains(SDKLogModes, value) {
+			fail(node, "%s: %q must be one of %s", path, value, strings.Join(SDKLogModes, ", "))
+		}
+	case kindOutput:
+		if !slices.Contains(ConfigOutputs, value) {
+			fail(node, "%s: %q must be one of %s", path, value, strings.Join(ConfigOutputs, ", "))
+		}
+	case kindPartition:
+		if !slices.Contains(Partitions, value) {
+			fail(node, "%s: %q must be one of %s", path, value, strings.Join(Partitions, ", "))
+		}
+	case kindAccountID:
+		if !accountIDPattern.MatchString(value) {
+			fail(node, "%s: %q is not a twelve digit account ID", path, value)
+		}
+	case kindSTSRegion:
+		if value != STSGlobal && !regionPattern.MatchString(value) {
+			fail(node, "%s: %q must be a region name or %s", path, value, STSGlobal)
+		}
+	case kindCount:
+		if n, err := strconv.Atoi(value); err != nil || n < 1 {
+			fail(node, "%s: %q must be a whole number of at least 1", path, value)
+		}
+	case kindRoleARN:
+		if err := roleARN("role", value).Check(); err != nil {
+			fail(node, "%s: %v", path, err)
+		}
+	}
+}
+
+// ValidateDevice returns an error wrapping ErrInvalidDevice when device is neither a virtual
+// MFA ARN nor a hardware serial number, and ErrSecurityKeyDevice when it is a FIDO security key.
+func ValidateDevice(device string) error {
+	problem := deviceProblem(device)
+	switch {
+	case problem == "":
+		return nil
+	case IsSecurityKey(device):
+		return fmt.Errorf("%w: %s", ErrSecurityKeyDevice, problem)
+	}
+	return fmt.Errorf("%w: %s", ErrInvalidDevice, problem)
+}
+
+// IsSecurityKey reports whether device is the ARN of a FIDO security key, e.g.
+// arn:aws:iam::123456789012:u2f/user/alice/fidosecuritykey-ABCDEFGHIJ. IAM registers them
+// like other MFA devices, but STS only accepts the codes of TOTP devices, so they cannot be
+// used to request a session.
+func IsSecurityKey(device string) bool {
+	parsed, err := arn.Parse(device)
+	return err == nil && parsed.Service == "iam" && strings.HasPrefix(parsed.Resource, "u2f/")
+}
+
+// deviceProblem describes why an MFA device is neither a virtual MFA ARN nor a hardware
+// serial number, or returns "" when it is valid.
+func deviceProblem(device string) string {
+	switch {
+	case IsSecurityKey(device):
+		return fmt.Sprintf("%s is a FIDO security key, STS only accepts virtual and hardware TOTP devices", device)
+	case strings.HasPrefix(device, "arn:"):
+		if err := (validate.ARNFormat{Name: "MFA device", Value: device, Service: "iam", Resource: "mfa"}).Check(); err != nil {
+			return err.Error()
+		}
+	case !mfaSerialPattern.MatchString(device):
+		return fmt.Sprintf("%q is not a valid MFA device ARN or serial number", device)
+	}
+	return ""
+}
+
+// ValidateExternalID returns an error when id is not accepted by AssumeRole as an external
+// ID. An empty id, which sends none, is valid.
+func ValidateExternalID(id string) error {
+	if id != "" && (len(id) < 2 || len(id) > 1224 || !externalIDPattern.MatchString(id)) {
+		return fmt.Errorf("%q is not a valid external ID (2 to 1224 letters, digits and any of +=,.@:/-)", id)
+	}
+	return nil
+}
+
+// proxySchemes lists the proxy URL schemes the HTTP client of the AWS SDK supports.
+var proxySchemes = []string{"http", "https", "socks5"}
+
+// ValidateProxy returns an error when proxy is not a URL of a proxy with a host, e.g.
+// http://proxy.example.com:3128. An empty proxy, which leaves HTTPS_PROXY in charge, is valid.
+func ValidateProxy(proxy string) error {
+	if proxy == "" {
+		return nil
+	}
+	parsed, err := url.Parse(proxy)
+	if err != nil || !slices.Contains(proxySchemes, parsed.Scheme) || parsed.Host == "" {
+		return fmt.Errorf("%q is not a valid proxy URL (expected e.g. http://proxy.example.com:3128, with scheme %s)", proxy, strings.Join(proxySchemes, ", "))
+	}
+	return nil
+}
+
+// roleARN returns the rule accepting the IAM role ARN value, or an empty one.
+func roleARN(name, value string) validate.Rule {
+	return validate.ARNFormat{Name: name, Value: value, Service: "iam", Resource: "role"}
+}
+
+// suggestKey returns the known key closest to an unknown one, or "" when nothing is close.
+func suggestKey(key string, fields map[string]schemaField) string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names) // Stable choice between equally close keys
+
+	best, bestDistance := "", 3 // Only suggest keys within two edits
+	for _, name := range names {
+		distance := editDistance(strings.ToLower(key), strings.ToLower(name))
+		if distance < bestDistance {
+			best, bestDistance = name, distance
+		}
+	}
+	return best
+}
+
+// editDistance computes the Damerau-Levenshtein (optimal string alignment) distance
+// between a and b, so transposed letters like "Devcie" count as a single edit.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
+
+// joinPath appends a key to a dotted config path.
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// describePath names a config path in error messages, including the document root.
+func describePath(path string) string {
+	if path == "" {
+		return "the config file"
+	}
+	return path
+}
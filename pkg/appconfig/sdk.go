@@ -0,0 +1,57 @@
+package appconfig
+
+// Retry modes selectable as SDK.RetryMode, those of the AWS SDK.
+const (
+	RetryStandard = "standard" // Retries with exponential backoff, the SDK default.
+	RetryAdaptive = "adaptive" // Standard retries that also slow down once AWS throttles requests.
+)
+
+// RetryModes lists every supported SDK.RetryMode value.
+var RetryModes = []string{RetryStandard, RetryAdaptive}
+
+// Internals of the AWS SDK selectable as SDK.Log, logged at trace level with -vvv. Bodies are
+// left out, they carry MFA codes and credentials.
+const (
+	SDKLogRequest    = "request"    // Requests as sent, headers included.
+	SDKLogResponse   = "response"   // Responses as received, headers included.
+	SDKLogRetries    = "retries"    // Every retried attempt and why.
+	SDKLogSigning    = "signing"    // Canonical request and string to sign of the SigV4 signature.
+	SDKLogDeprecated = "deprecated" // Use of deprecated SDK features.
+)
+
+// SDKLogModes lists every supported SDK.Log value.
+var SDKLogModes = []string{SDKLogRequest, SDKLogResponse, SDKLogRetries, SDKLogSigning, SDKLogDeprecated}
+
+// DefaultSDKLog is what -vvv logs of the AWS SDK without SDK.Log.
+var DefaultSDKLog = []string{SDKLogRequest, SDKLogResponse, SDKLogRetries}
+
+// SDKConfig tunes how the AWS SDK retries and times out its calls, e.g. over a flaky VPN, and
+// what it logs while tracing.
+// Zero values leave the SDK defaults in place.
+type SDKConfig struct {
+	RetryMode   string               `koanf:"RetryMode"`   // RetryStandard or RetryAdaptive.
+	MaxAttempts int                  `koanf:"MaxAttempts"` // Attempts of each call, including the first.
+	CallTimeout int32                `koanf:"CallTimeout"` // Seconds each call may take, all its attempts included.
+	Log         []string             `koanf:"Log"`         // SDKLogModes logged with -vvv, DefaultSDKLog when empty.
+	Commands    map[string]SDKConfig `koanf:"Commands"`    // Settings of single commands, keyed by CommandName.
+}
+
+// ForCommand returns the settings used while running command: those set under Commands for
+// it, and the others for the rest.
+func (sdk SDKConfig) ForCommand(command string) SDKConfig {
+	override := sdk.Commands[command]
+	if override.RetryMode != "" {
+		sdk.RetryMode = override.RetryMode
+	}
+	if override.MaxAttempts != 0 {
+		sdk.MaxAttempts = override.MaxAttempts
+	}
+	if override.CallTimeout != 0 {
+		sdk.CallTimeout = override.CallTimeout
+	}
+	if override.Log != nil {
+		sdk.Log = override.Log
+	}
+	sdk.Commands = nil
+	return sdk
+}
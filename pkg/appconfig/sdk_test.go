@@ -0,0 +1,62 @@
+package appconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSDKForCommand(t *testing.T) {
+	sdk := SDKConfig{RetryMode: RetryStandard, MaxAttempts: 3, CallTimeout: 10, Commands: map[string]SDKConfig{
+		"sessions": {CallTimeout: 120},
+		"login":    {RetryMode: RetryAdaptive, MaxAttempts: 8},
+		"agent":    {Log: []string{SDKLogSigning}},
+	}}
+
+	assert.Equal(t, SDKConfig{RetryMode: RetryStandard, MaxAttempts: 3, CallTimeout: 120}, sdk.ForCommand("sessions"))
+	assert.Equal(t, SDKConfig{RetryMode: RetryAdaptive, MaxAttempts: 8, CallTimeout: 10}, sdk.ForCommand("login"))
+	assert.Equal(t, SDKConfig{RetryMode: RetryStandard, MaxAttempts: 3, CallTimeout: 10}, sdk.ForCommand("exec"))
+	assert.Equal(t, SDKConfig{RetryMode: RetryStandard, MaxAttempts: 3, CallTimeout: 10, Log: []string{SDKLogSigning}}, sdk.ForCommand("agent"))
+	assert.Equal(t, SDKConfig{}, SDKConfig{}.ForCommand("login"))
+}
+
+func TestLoadSDKConfig(t *testing.T) {
+	resetLogging()
+	path := filepath.Join(t.TempDir(), "config.yml")
+
+	t.Run("Loads durations and overrides", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(path, []byte(`gredentures:
+  SDK:
+    RetryMode: adaptive
+    MaxAttempts: 6
+    CallTimeout: 20s
+    Log: [retries, signing]
+    Commands:
+      sessions:
+        CallTimeout: 2m
+`), 0o644))
+		conf := &AppConfig{Config: path}
+		require.NoError(t, conf.LoadGredenturesConfig())
+		assert.Equal(t, SDKConfig{RetryMode: RetryAdaptive, MaxAttempts: 6, CallTimeout: 20, Log: []string{SDKLogRetries, SDKLogSigning}, Commands: map[string]SDKConfig{
+			"sessions": {CallTimeout: 120},
+		}}, conf.SDK)
+	})
+
+	t.Run("Rejects unknown retry modes, log modes and attempts", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(path, []byte(`gredentures:
+  SDK:
+    RetryMode: eager
+    Log: [bodies]
+    Commands:
+      login:
+        MaxAttempts: 0
+`), 0o644))
+		err := (&AppConfig{Config: path}).LoadGredenturesConfig()
+		assert.ErrorContains(t, err, `gredentures.SDK.RetryMode: "eager" must be one of standard, adaptive`)
+		assert.ErrorContains(t, err, `gredentures.SDK.Log[0]: "bodies" must be one of request, response, retries, signing, deprecated`)
+		assert.ErrorContains(t, err, `gredentures.SDK.Commands.login.MaxAttempts: "0" must be a whole number of at least 1`)
+	})
+}
@@ -0,0 +1,333 @@
+package appconfig
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gredentures/pkg/interrupt"
+	"gredentures/pkg/remoteconfig"
+	"gredentures/pkg/sysuser"
+
+	y "gopkg.in/yaml.v3"
+)
+
+// ConfigVersion is the version of the config file layout this release reads and writes. It
+// is stored as the top-level version key. Files without one are version 1.
+const ConfigVersion = 2
+
+// configVersionKey is the top-level key holding the version of a config file.
+const configVersionKey = "version"
+
+// configMigration upgrades the config file layout by one version.
+type configMigration struct {
+	version int         // Version the migration upgrades to.
+	summary string      // What changed, logged when the migration runs.
+	nest    []string    // Top-level mappings whose keys were written flat, as <name>.<key>, nested before the moves.
+	moves   [][2]string // Dotted paths of keys moved to another path, in order. A * matches every entry of a mapping.
+}
+
+// configMigrations lists the migrations in version order, the last one upgrading to
+// ConfigVersion. A release changing the layout, e.g. renaming a key or nesting the keys of
+// Orgs, adds one here, so older files keep loading.
+var configMigrations = []configMigration{
+	{version: 2, summary: "the flat gredentures.<key> keys are nested under gredentures", nest: []string{"gredentures"}},
+}
+
+// ErrConfigTooNew is returned for config files written by a later release of gredentures.
+var ErrConfigTooNew = errors.New("config file is newer than this release of gredentures")
+
+// migrateConfig upgrades YAML config data to ConfigVersion and returns it with the version it
+// was at. Data already at ConfigVersion is returned as it is.
+func migrateConfig(data []byte) ([]byte, int, error) {
+	return upgradeConfig(data, ConfigVersion, configMigrations)
+}
+
+// upgradeConfig applies the migrations that take YAML config data up to version current. Keys
+// are moved along with their comments.
+func upgradeConfig(data []byte, current int, migrations []configMigration) ([]byte, int, error) {
+	var doc y.Node
+	if err := y.Unmarshal(data, &doc); err != nil {
+		return nil, 0, fmt.Errorf("invalid YAML: %w", err)
+	}
+	version, err := upgradeDocument(&doc, current, migrations)
+	if err != nil || version == current {
+		return data, version, err
+	}
+
+	var out bytes.Buffer
+	encoder := y.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return nil, version, fmt.Errorf("failed to marshal the migrated config file: %w", err)
+	}
+	return out.Bytes(), version, nil
+}
+
+// upgradeDocument applies the migrations that take a parsed YAML config document up to
+// version current and returns the version it was at. Moved keys keep their line numbers.
+func upgradeDocument(doc *y.Node, current int, migrations []configMigration) (int, error) {
+	if doc.Kind != y.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != y.MappingNode {
+		return current, nil // Empty, or reported by ValidateConfig
+	}
+	root := doc.Content[0]
+
+	version, err := configVersion(root)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case version > current:
+		return version, fmt.Errorf("%w: it has version %d, this release reads up to version %d", ErrConfigTooNew, version, current)
+	case version == current:
+		return version, nil
+	}
+
+	for _, migration := range migrations {
+		if migration.version <= version || migration.version > current {
+			continue
+		}
+		slog.Debug("Migrating config file", "to", migration.version, "change", migration.summary)
+		for _, name := range migration.nest {
+			if err := nestFlatKeys(root, name); err != nil {
+				return version, fmt.Errorf("failed to migrate the config file to version %d: %w", migration.version, err)
+			}
+		}
+		for _, move := range migration.moves {
+			if err := moveConfigKey(root, strings.Split(move[0], "."), strings.Split(move[1], ".")); err != nil {
+				return version, fmt.Errorf("failed to migrate the config file to version %d: %w", migration.version, err)
+			}
+		}
+	}
+	setConfigVersion(root, current)
+	return version, nil
+}
+
+// configVersion returns the version of a config file's root mapping, 1 when it has none.
+func configVersion(root *y.Node) (int, error) {
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != configVersionKey {
+			continue
+		}
+		value := root.Content[i+1]
+		version, err := strconv.Atoi(value.Value)
+		if value.Kind != y.ScalarNode || err != nil || version < 1 {
+			return 0, fmt.Errorf("line %d: %s must be a whole number from 1, not %q", value.Line, configVersionKey, value.Value)
+		}
+		return version, nil
+	}
+	return 1, nil
+}
+
+// setConfigVersion stores version in a config file's root mapping, as its first key when new.
+func setConfigVersion(root *y.Node, version int) {
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == configVersionKey {
+			root.Content[i+1].SetString(strconv.Itoa(version))
+			root.Content[i+1].Tag = "!!int"
+			return
+		}
+	}
+	key := &y.Node{Kind: y.ScalarNode, Value: configVersionKey}
+	value := &y.Node{Kind: y.ScalarNode, Tag: "!!int", Value: strconv.Itoa(version)}
+	root.Content = append([]*y.Node{key, value}, root.Content...)
+}
+
+// nestFlatKeys moves the top-level <name>.<key> keys of root into its name mapping, creating
+// it when needed. Older releases wrote the config file in this flat layout, which koanf reads
+// like the nested one. A flat key also set in the mapping fails and stays where it is.
+func nestFlatKeys(root *y.Node, name string) error {
+	prefix := name + "."
+	var flat []int // Indexes of the flat keys in root.Content
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if key := root.Content[i].Value; strings.HasPrefix(key, prefix) && len(key) > len(prefix) {
+			flat = append(flat, i)
+		}
+	}
+	if len(flat) == 0 {
+		return nil
+	}
+
+	mapping, err := mappingEntry(root, name, y.MappingNode)
+	if err != nil {
+		return err
+	}
+	var kept []*y.Node
+	var errs []error
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		if !slices.Contains(flat, i) {
+			kept = append(kept, key, value)
+			continue
+		}
+		nested := strings.TrimPrefix(key.Value, prefix)
+		if hasEntry(mapping, nested) {
+			errs = append(errs, fmt.Errorf("line %d: cannot move %s, %s.%s is already set", key.Line, key.Value, name, nested))
+			kept = append(kept, key, value)
+			continue
+		}
+		key.Value = nested
+		mapping.Content = append(mapping.Content, key, value)
+	}
+	root.Content = kept
+	return errors.Join(errs...)
+}
+
+// moveConfigKey moves the key at path from to path to, creating the mappings on the way. A *
+// in from matches every key of a mapping and stands for the same key in to. Paths that match
+// nothing are skipped, a move onto an existing key fails.
+func moveConfigKey(mapping *y.Node, from, to []string) error {
+	if mapping.Kind != y.MappingNode {
+		return nil
+	}
+	if from[0] == "*" {
+		var names []string // Taken first, moves change the mapping
+		for i := 0; i+1 < len(mapping.Content); i += 2 {
+			names = append(names, mapping.Content[i].Value)
+		}
+		for _, name := range names {
+			if err := moveConfigKey(mapping, append([]string{name}, from[1:]...), replaceWildcard(to, name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	// Descend while both paths share their keys, so moves within an entry stay there
+	if len(from) > 1 && len(to) > 1 && from[0] == to[0] {
+		for i := 0; i+1 < len(mapping.Content); i += 2 {
+			if mapping.Content[i].Value == from[0] {
+				return moveConfigKey(mapping.Content[i+1], from[1:], to[1:])
+			}
+		}
+		return nil
+	}
+
+	key, value := takeConfigKey(mapping, from)
+	if key == nil {
+		return nil
+	}
+	parent := mapping
+	for _, name := range to[:len(to)-1] {
+		next, err := mappingEntry(parent, name, y.MappingNode)
+		if err != nil {
+			return err
+		}
+		parent = next
+	}
+	if hasEntry(parent, to[len(to)-1]) {
+		return fmt.Errorf("line %d: cannot move %s, %s is already set", key.Line, strings.Join(from, "."), strings.Join(to, "."))
+	}
+	key.Value = to[len(to)-1]
+	parent.Content = append(parent.Content, key, value)
+	return nil
+}
+
+// takeConfigKey removes the key at path from mapping and returns it with its value, nil when
+// the path does not exist. Wildcards are expanded by moveConfigKey beforehand.
+func takeConfigKey(mapping *y.Node, path []string) (*y.Node, *y.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value != path[0] {
+			continue
+		}
+		if len(path) > 1 {
+			if mapping.Content[i+1].Kind != y.MappingNode {
+				return nil, nil
+			}
+			return takeConfigKey(mapping.Content[i+1], path[1:])
+		}
+		key, value := mapping.Content[i], mapping.Content[i+1]
+		mapping.Content = append(mapping.Content[:i:i], mapping.Content[i+2:]...)
+		return key, value
+	}
+	return nil, nil
+}
+
+// replaceWildcard returns path with its first * replaced by name.
+func replaceWildcard(path []string, name string) []string {
+	replaced := append([]string(nil), path...)
+	for i, key := range replaced {
+		if key == "*" {
+			replaced[i] = name
+			break
+		}
+	}
+	return replaced
+}
+
+// migrateConfigLayer upgrades the data of a config file read from location. The user's own
+// config file is rewritten with the upgrade, after copying the old file next to it. Base
+// configs, remote files and runs with --no-write or --no-config-write are only upgraded in memory.
+func (conf *AppConfig) migrateConfigLayer(location string, data []byte) ([]byte, error) {
+	migrated, version, err := migrateConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", location, err)
+	}
+	if version == ConfigVersion || location != conf.Config || remoteconfig.IsRemote(location) || conf.NoWrite || conf.NoConfigWrite {
+		return migrated, nil
+	}
+
+	backup, err := backupConfig(location, version, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeConfigAtomic(location, migrated); err != nil {
+		return nil, fmt.Errorf("failed to write the migrated config file: %w", err)
+	}
+	slog.Warn("Upgraded the config file to a new version", "path", location, "from", version, "to", ConfigVersion, "backup", backup)
+	return migrated, nil
+}
+
+// writeConfigAtomic replaces the config file at path with data through a temporary file in
+// the same directory, so an interrupted write never leaves it half written. The file keeps
+// its permissions.
+func writeConfigAtomic(path string, data []byte) error {
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".config-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // No-op once the rename has succeeded
+	defer interrupt.OnInterrupt(func() { os.Remove(tmp.Name()) })()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set permissions on temporary file: %w", err)
+	}
+	if err := sysuser.Chown(tmp.Name()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary file: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// backupConfig writes data, the config file at path before its migration from version, to
+// <path>.v<version>.bak and returns that path. An existing backup is kept, the new one then
+// gets the time in its name.
+func backupConfig(path string, version int, data []byte) (string, error) {
+	backup := fmt.Sprintf("%s.v%d.bak", path, version)
+	if _, err := os.Stat(backup); err == nil {
+		backup = fmt.Sprintf("%s.v%d.%s.bak", path, version, time.Now().Format("20060102150405"))
+	}
+	if err := os.WriteFile(backup, data, 0o600); err != nil {
+		return "", fmt.Errorf("failed to back up the config file before migrating it: %w", err)
+	}
+	return backup, nil
+}
@@ -0,0 +1,192 @@
+package appconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// nestRoles is a migration like the ones a later layout change would add.
+var nestRoles = configMigration{version: 2, summary: "nest roles", moves: [][2]string{
+	{"gredentures.Orgs.*.RoleArn", "gredentures.Orgs.*.Role.Arn"},
+	{"gredentures.Timeout", "gredentures.Session.Timeout"},
+	{"gredentures.Missing", "gredentures.Elsewhere"},
+}}
+
+func TestMigrateConfig(t *testing.T) {
+	t.Run("Leaves current files alone", func(t *testing.T) {
+		data := []byte("version: 2\ngredentures:\n  Org: acme\n")
+		migrated, version, err := migrateConfig(data)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, version)
+		assert.Equal(t, data, migrated)
+	})
+
+	t.Run("Treats unversioned files as version 1", func(t *testing.T) {
+		migrated, version, err := migrateConfig([]byte("gredentures:\n  Org: acme\n"))
+		assert.NoError(t, err)
+		assert.Equal(t, 1, version)
+		assert.Equal(t, "version: 2\ngredentures:\n  Org: acme\n", string(migrated))
+	})
+
+	t.Run("Nests the flat keys of older releases", func(t *testing.T) {
+		// As WriteGredenturesConfig wrote the file before it nested the keys
+		data := []byte("gredentures.Device: arn:aws:iam::123456789012:mfa/alice\ngredentures.Org: acme # mine\ngredentures.Timeout: 3600\n")
+		migrated, version, err := migrateConfig(data)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, version)
+		assert.Equal(t, `version: 2
+gredentures:
+  Device: arn:aws:iam::123456789012:mfa/alice
+  Org: acme # mine
+  Timeout: 3600
+`, string(migrated))
+		assert.NoError(t, ValidateConfig(migrated))
+	})
+
+	t.Run("Merges flat keys into the nested ones", func(t *testing.T) {
+		migrated, _, err := migrateConfig([]byte("gredentures:\n  Org: acme\ngredentures.Timeout: 1h\n"))
+		assert.NoError(t, err)
+		assert.Equal(t, "version: 2\ngredentures:\n  Org: acme\n  Timeout: 1h\n", string(migrated))
+	})
+
+	t.Run("Refuses flat keys also set nested", func(t *testing.T) {
+		_, _, err := migrateConfig([]byte("gredentures:\n  Org: acme\ngredentures.Org: other\n"))
+		assert.ErrorContains(t, err, "failed to migrate the config file to version 2: line 3: cannot move gredentures.Org, gredentures.Org is already set")
+	})
+
+	t.Run("Refuses newer files", func(t *testing.T) {
+		_, version, err := migrateConfig([]byte("version: 7\n"))
+		assert.ErrorIs(t, err, ErrConfigTooNew)
+		assert.ErrorContains(t, err, "it has version 7, this release reads up to version 2")
+		assert.Equal(t, 7, version)
+	})
+
+	t.Run("Refuses invalid versions", func(t *testing.T) {
+		_, _, err := migrateConfig([]byte("version: two\n"))
+		assert.ErrorContains(t, err, `line 1: version must be a whole number from 1, not "two"`)
+		_, _, err = migrateConfig([]byte("version: 0\n"))
+		assert.Error(t, err)
+	})
+
+	t.Run("Every migration follows the previous one", func(t *testing.T) {
+		for i, migration := range configMigrations {
+			assert.Equal(t, i+2, migration.version)
+		}
+		assert.Equal(t, len(configMigrations)+1, ConfigVersion)
+	})
+}
+
+func TestUpgradeConfig(t *testing.T) {
+	data := []byte(`gredentures:
+  # Session length
+  Timeout: 1h
+  Orgs:
+    acme:
+      RoleArn: arn:aws:iam::123456789012:role/Admin # the admin role
+    other:
+      Region: eu-west-1
+`)
+	migrated, version, err := upgradeConfig(data, 2, []configMigration{nestRoles})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, version)
+	assert.Equal(t, `version: 2
+gredentures:
+  Orgs:
+    acme:
+      Role:
+        Arn: arn:aws:iam::123456789012:role/Admin # the admin role
+    other:
+      Region: eu-west-1
+  Session:
+    # Session length
+    Timeout: 1h
+`, string(migrated), "keys move with their comments")
+
+	t.Run("Skips migrations already applied", func(t *testing.T) {
+		data := []byte("version: 2\ngredentures:\n  Timeout: 1h\n")
+		migrated, version, err := upgradeConfig(data, 3, []configMigration{nestRoles,
+			{version: 3, moves: [][2]string{{"gredentures.Timeout", "gredentures.SessionTimeout"}}}})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, version)
+		assert.Equal(t, "version: 3\ngredentures:\n  SessionTimeout: 1h\n", string(migrated))
+	})
+
+	t.Run("Refuses to overwrite keys", func(t *testing.T) {
+		_, _, err := upgradeConfig([]byte("gredentures:\n  Timeout: 1h\n  Session:\n    Timeout: 2h\n"), 2, []configMigration{nestRoles})
+		assert.ErrorContains(t, err, "failed to migrate the config file to version 2: line 2: cannot move Timeout, Session.Timeout is already set")
+	})
+}
+
+func TestBackupConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+
+	backup, err := backupConfig(path, 1, []byte("first"))
+	assert.NoError(t, err)
+	assert.Equal(t, path+".v1.bak", backup)
+
+	again, err := backupConfig(path, 1, []byte("second"))
+	assert.NoError(t, err)
+	assert.NotEqual(t, backup, again, "earlier backups are kept")
+	assert.Regexp(t, `config\.yml\.v1\.\d{14}\.bak$`, again)
+
+	data, err := os.ReadFile(backup)
+	assert.NoError(t, err)
+	assert.Equal(t, "first", string(data))
+}
+
+func TestLoadGredenturesConfigVersion(t *testing.T) {
+	t.Setenv("GREDENTURES_SYSTEM_CONFIG", filepath.Join(t.TempDir(), "none.yml"))
+	dir := t.TempDir()
+
+	t.Run("Loads versioned files", func(t *testing.T) {
+		path := writeLayer(t, dir, "current.yml", "version: 2\ngredentures:\n  Org: acme\n")
+		conf := AppConfig{Config: path}
+		assert.NoError(t, conf.LoadGredenturesConfig())
+		assert.Equal(t, "acme", conf.Org)
+	})
+
+	t.Run("Refuses files of later releases", func(t *testing.T) {
+		path := writeLayer(t, dir, "newer.yml", "version: 3\ngredentures:\n  Org: acme\n")
+		conf := AppConfig{Config: path}
+		err := conf.LoadGredenturesConfig()
+		assert.ErrorIs(t, err, ErrConfigTooNew)
+		assert.ErrorContains(t, err, "newer.yml")
+	})
+
+	t.Run("Upgrades files written by older releases", func(t *testing.T) {
+		flat := "gredentures.Device: arn:aws:iam::123456789012:mfa/alice\ngredentures.Org: acme\ngredentures.Timeout: 3600\n"
+		path := writeLayer(t, dir, "flat.yml", flat)
+		assert.NoError(t, os.Chmod(path, 0o600))
+		conf := AppConfig{Config: path}
+		assert.NoError(t, conf.LoadGredenturesConfig())
+		assert.Equal(t, "acme", conf.Org)
+		assert.Equal(t, int32(3600), conf.Timeout)
+
+		data, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.Equal(t, "version: 2\ngredentures:\n  Device: arn:aws:iam::123456789012:mfa/alice\n  Org: acme\n  Timeout: 3600\n", string(data))
+		backup, err := os.ReadFile(path + ".v1.bak")
+		assert.NoError(t, err)
+		assert.Equal(t, flat, string(backup))
+
+		info, err := os.Stat(path)
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o600), info.Mode().Perm(), "the rewrite keeps the permissions")
+		leftovers, err := filepath.Glob(filepath.Join(dir, ".config-*"))
+		assert.NoError(t, err)
+		assert.Empty(t, leftovers, "the temporary file is renamed into place")
+	})
+
+	t.Run("Writes the version", func(t *testing.T) {
+		path := filepath.Join(dir, "written.yml")
+		conf := AppConfig{Config: path, Org: "acme"}
+		assert.NoError(t, conf.WriteGredenturesConfig())
+		data, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.Contains(t, string(data), "version: 2\n")
+		assert.NoError(t, ValidateConfig(data))
+	})
+}
@@ -0,0 +1,112 @@
+// Package audit keeps an append-only JSON lines trail of the credentials gredentures issues
+// and the files it modifies, so shared hosts have evidence of who refreshed what and when.
+// Events never contain secrets: only profile names, expiry times, ARNs and paths.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gredentures/pkg/sysuser"
+)
+
+// Event actions.
+const (
+	ActionIssue = "issue" // Credentials were obtained from STS.
+	ActionWrite = "write" // A credentials file was modified.
+)
+
+// Event is a single audit record.
+type Event struct {
+	Time       time.Time  `json:"time"`
+	User       string     `json:"user"`
+	Host       string     `json:"host"`
+	Action     string     `json:"action"`
+	Profile    string     `json:"profile,omitempty"`    // Profile the credentials belong to, for issue events.
+	Expiration *time.Time `json:"expiration,omitempty"` // Expiry of the issued credentials.
+	CallerARN  string     `json:"caller_arn,omitempty"` // Identity the credentials act as.
+	Path       string     `json:"path,omitempty"`       // File modified, for write events.
+	Profiles   []string   `json:"profiles,omitempty"`   // Profiles written, for write events.
+}
+
+// Append adds events to the audit log at path, creating it and its directory as needed. The
+// time, local user and host are filled in for events that don't set them.
+func Append(path string, events ...Event) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer file.Close()
+
+	now := time.Now().UTC()
+	username, host := currentUser(), hostname()
+	for _, event := range events {
+		if event.Time.IsZero() {
+			event.Time = now
+		}
+		if event.User == "" {
+			event.User = username
+		}
+		if event.Host == "" {
+			event.Host = host
+		}
+
+		line, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("failed to marshal audit event: %w", err)
+		}
+		// A single write per line keeps concurrent appends from interleaving
+		if _, err := file.Write(append(line, '\n')); err != nil {
+			return fmt.Errorf("failed to write audit log: %w", err)
+		}
+	}
+	return nil
+}
+
+// Read returns every event in the audit log at path, oldest first. A missing log is empty.
+func Read(path string) ([]Event, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer file.Close()
+
+	var events []Event
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, fmt.Errorf("failed to parse audit log line %d: %w", line, err)
+		}
+		events = append(events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	return events, nil
+}
+
+// currentUser returns the local user name, that of the user who ran sudo under sudo.
+func currentUser() string {
+	return sysuser.Invoking().Name
+}
+
+// hostname returns the host name, or "" when it cannot be determined.
+func hostname() string {
+	host, _ := os.Hostname()
+	return host
+}
@@ -0,0 +1,67 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAppendAndRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit", "audit.jsonl")
+	expiration := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	assert.NoError(t, Append(path, Event{
+		Action:     ActionIssue,
+		Profile:    "default-mfa",
+		Expiration: &expiration,
+		CallerARN:  "arn:aws:iam::123456789012:user/me",
+	}))
+	assert.NoError(t, Append(path, Event{
+		Time:     time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+		User:     "someone",
+		Host:     "jump-1",
+		Action:   ActionWrite,
+		Path:     "/home/me/.aws/credentials",
+		Profiles: []string{"default-mfa"},
+	}))
+
+	events, err := Read(path)
+	assert.NoError(t, err)
+	assert.Len(t, events, 2)
+
+	assert.Equal(t, ActionIssue, events[0].Action)
+	assert.WithinDuration(t, time.Now(), events[0].Time, time.Minute)
+	assert.NotEmpty(t, events[0].User)
+	assert.Equal(t, expiration, *events[0].Expiration)
+	assert.Equal(t, "arn:aws:iam::123456789012:user/me", events[0].CallerARN)
+
+	assert.Equal(t, Event{
+		Time:     time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+		User:     "someone",
+		Host:     "jump-1",
+		Action:   ActionWrite,
+		Path:     "/home/me/.aws/credentials",
+		Profiles: []string{"default-mfa"},
+	}, events[1])
+
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+}
+
+func TestReadMissing(t *testing.T) {
+	events, err := Read(filepath.Join(t.TempDir(), "missing.jsonl"))
+	assert.NoError(t, err)
+	assert.Empty(t, events)
+}
+
+func TestReadInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	assert.NoError(t, os.WriteFile(path, []byte("{\"action\":\"issue\"}\nnot json\n"), 0o600))
+
+	_, err := Read(path)
+	assert.ErrorContains(t, err, "line 2")
+}
@@ -0,0 +1,96 @@
+package awsconfig
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Columns of the CSV files the IAM console offers for download when it creates an access key:
+// accessKeys.csv has only the key pair, the older credentials.csv the user name too.
+const (
+	csvUserName        = "user name"
+	csvAccessKeyID     = "access key id"
+	csvSecretAccessKey = "secret access key"
+)
+
+// ReadAccessKeysCSV reads the long-lived key pair of an accessKeys.csv or credentials.csv file
+// downloaded from the IAM console, as aws configure import does. Columns are matched without
+// regard to case, other columns such as the console password are ignored, and the profile is
+// named after the IAM user when the file gives one. The file must hold a single key pair, of
+// the long-lived kind.
+func ReadAccessKeysCSV(path string) (Profile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Profile{}, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	profile, err := parseAccessKeysCSV(data)
+	if err != nil {
+		return Profile{}, fmt.Errorf("invalid access keys file %s: %w", path, err)
+	}
+	return profile, nil
+}
+
+// parseAccessKeysCSV parses the CSV of ReadAccessKeysCSV.
+func parseAccessKeysCSV(data []byte) (Profile, error) {
+	// Spreadsheet programs saving the file again add a byte order mark
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\ufeff"))))
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return Profile{}, fmt.Errorf("the file is empty")
+	}
+	if err != nil {
+		return Profile{}, err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{csvAccessKeyID, csvSecretAccessKey} {
+		if _, ok := columns[name]; !ok {
+			return Profile{}, fmt.Errorf("the header has no %q column, download the keys again from the IAM console", name)
+		}
+	}
+
+	var profiles []Profile
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return Profile{}, err
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		profile := Profile{Name: field(csvUserName)}
+		profile.Credentials.AccessKeyID, profile.Credentials.SecretAccessKey = field(csvAccessKeyID), field(csvSecretAccessKey)
+		if profile.Credentials.AccessKeyID == "" && profile.Credentials.SecretAccessKey == "" {
+			continue // Users of credentials.csv created without an access key
+		}
+		profiles = append(profiles, profile)
+	}
+
+	if len(profiles) != 1 {
+		return Profile{}, fmt.Errorf("the file holds %d access key pairs, import exactly one", len(profiles))
+	}
+	profile := profiles[0]
+	switch id := profile.Credentials.AccessKeyID; {
+	case id == "" || profile.Credentials.SecretAccessKey == "":
+		return Profile{}, fmt.Errorf("the key pair needs both an access key ID and a secret access key")
+	case strings.HasPrefix(id, "ASIA"):
+		return Profile{}, fmt.Errorf("%s is a temporary access key, import temporary credentials with gredentures import", id)
+	case !strings.HasPrefix(id, "AKIA"):
+		return Profile{}, fmt.Errorf("%q is not the ID of a long-lived access key, those start with AKIA", id)
+	}
+	return profile, nil
+}
@@ -0,0 +1,63 @@
+package awsconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadAccessKeysCSV(t *testing.T) {
+	write := func(t *testing.T, content string) string {
+		path := filepath.Join(t.TempDir(), "accessKeys.csv")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		return path
+	}
+
+	t.Run("accessKeys.csv", func(t *testing.T) {
+		profile, err := ReadAccessKeysCSV(write(t, "\ufeffAccess key ID,Secret access key\r\nAKIAEXAMPLE,secret\r\n"))
+		require.NoError(t, err)
+		assert.Empty(t, profile.Name)
+		assert.Equal(t, "AKIAEXAMPLE", profile.Credentials.AccessKeyID)
+		assert.Equal(t, "secret", profile.Credentials.SecretAccessKey)
+	})
+
+	t.Run("credentials.csv", func(t *testing.T) {
+		profile, err := ReadAccessKeysCSV(write(t, "User name,Password,Access key ID,Secret access key,Console login link\n"+
+			"alice,hunter2,AKIAEXAMPLE,secret,https://123456789012.signin.aws.amazon.com/console\n"))
+		require.NoError(t, err)
+		assert.Equal(t, "alice", profile.Name)
+		assert.Equal(t, "AKIAEXAMPLE", profile.Credentials.AccessKeyID)
+		assert.Equal(t, "secret", profile.Credentials.SecretAccessKey)
+	})
+
+	t.Run("Users without keys are skipped", func(t *testing.T) {
+		profile, err := ReadAccessKeysCSV(write(t, "User Name,Access Key Id,Secret Access Key\nbob,,\nalice,AKIAEXAMPLE,secret\n"))
+		require.NoError(t, err)
+		assert.Equal(t, "alice", profile.Name)
+	})
+
+	t.Run("Invalid files", func(t *testing.T) {
+		for name, content := range map[string]string{
+			"empty":          "",
+			"no key columns": "User name,Password\nalice,hunter2\n",
+			"no key pairs":   "Access key ID,Secret access key\n",
+			"two key pairs":  "Access key ID,Secret access key\nAKIAONE,secret\nAKIATWO,secret\n",
+			"half a pair":    "Access key ID,Secret access key\nAKIAEXAMPLE,\n",
+			"temporary key":  "Access key ID,Secret access key\nASIAEXAMPLE,secret\n",
+			"not a key ID":   "Access key ID,Secret access key\nexample,secret\n",
+		} {
+			t.Run(name, func(t *testing.T) {
+				_, err := ReadAccessKeysCSV(write(t, content))
+				assert.Error(t, err)
+			})
+		}
+	})
+
+	t.Run("Missing file", func(t *testing.T) {
+		_, err := ReadAccessKeysCSV(filepath.Join(t.TempDir(), "missing.csv"))
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
@@ -0,0 +1,182 @@
+package awsconfig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gredentures/pkg/audit"
+	"gredentures/pkg/interrupt"
+	"gredentures/pkg/sysuser"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+)
+
+// AliasCacheTTL is how long a cached account alias is shown before login looks it up again.
+// Aliases rarely change, so most logins make no IAM call for them.
+const AliasCacheTTL = 7 * 24 * time.Hour
+
+// AccountCache remembers the IAM alias of every account gredentures issued credentials for,
+// and the account of each profile, so commands that make no AWS call can still name accounts
+// by their alias instead of a 12-digit ID.
+type AccountCache struct {
+	Aliases  map[string]CachedAlias `json:"aliases"`  // Keyed by account ID.
+	Profiles map[string]string      `json:"profiles"` // Account ID of each profile at its last login.
+}
+
+// CachedAlias is an account alias and when it was looked up. An empty Alias records an
+// account without one.
+type CachedAlias struct {
+	Alias   string    `json:"alias"`
+	Updated time.Time `json:"updated"`
+}
+
+// AccountCachePath returns the path of the account cache,
+// $XDG_CACHE_HOME/gredentures/accounts.json, falling back to ~/.cache when the variable is unset.
+func AccountCachePath() string {
+	if dir := sysuser.StateDir(); dir != "" {
+		return filepath.Join(dir, "accounts.json")
+	}
+	dir := os.Getenv("XDG_CACHE_HOME")
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(sysuser.Home(), ".cache")
+	}
+	return filepath.Join(dir, "gredentures", "accounts.json")
+}
+
+// LoadAccountCache reads the account cache at path. A missing or unreadable cache is returned
+// empty, it is only ever a convenience.
+func LoadAccountCache(path string) *AccountCache {
+	cache := &AccountCache{Aliases: map[string]CachedAlias{}, Profiles: map[string]string{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cache
+	}
+	if err == nil {
+		err = json.Unmarshal(data, cache)
+	}
+	if err != nil {
+		slog.Debug("Ignoring unreadable account cache", "path", path, "error", err)
+		return &AccountCache{Aliases: map[string]CachedAlias{}, Profiles: map[string]string{}}
+	}
+	if cache.Aliases == nil {
+		cache.Aliases = map[string]CachedAlias{}
+	}
+	if cache.Profiles == nil {
+		cache.Profiles = map[string]string{}
+	}
+	return cache
+}
+
+// Save writes the cache to path, replacing the file in one step.
+func (c *AccountCache) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal account cache: %w", err)
+	}
+	if err := sysuser.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".accounts-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // No-op once the rename has succeeded
+	defer interrupt.OnInterrupt(func() { os.Remove(tmp.Name()) })()
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := sysuser.Chown(tmp.Name()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary file: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Alias returns the cached alias of accountID, "" when it is unknown or the account has none.
+// Stale aliases are still returned, an old name is more useful than none.
+func (c *AccountCache) Alias(accountID string) string {
+	return c.Aliases[accountID].Alias
+}
+
+// ProfileAccount returns the account ID profile belonged to at its last login, "" when unknown.
+func (c *AccountCache) ProfileAccount(profile string) string {
+	return c.Profiles[profile]
+}
+
+// DisplayAccount returns accountID with its alias in front when one is cached, e.g.
+// "acme-prod (123456789012)".
+func (c *AccountCache) DisplayAccount(accountID string) string {
+	if alias := c.Alias(accountID); alias != "" && accountID != "" {
+		return fmt.Sprintf("%s (%s)", alias, accountID)
+	}
+	return accountID
+}
+
+// fresh reports whether the alias of accountID was looked up within AliasCacheTTL of now.
+func (c *AccountCache) fresh(accountID string, now time.Time) bool {
+	cached, ok := c.Aliases[accountID]
+	return ok && now.Sub(cached.Updated) < AliasCacheTTL
+}
+
+// CacheAccounts records the account of every profile in events, the issuance events of this
+// login, and looks up the aliases of accounts not cached within AliasCacheTTL with the
+// credentials just issued for them. Failed lookups, e.g. for roles without
+// iam:ListAccountAliases, are skipped and retried at the next login.
+func (conf *AwsConfig) CacheAccounts(events []audit.Event, path string) error {
+	set, err := conf.credentialSet()
+	if err != nil {
+		return err
+	}
+	cache := LoadAccountCache(path)
+	conf.cacheAccounts(interrupt.Context(), cache, set, events, time.Now())
+	return cache.Save(path)
+}
+
+// cacheAccounts updates cache from events as of now, see CacheAccounts.
+func (conf *AwsConfig) cacheAccounts(ctx context.Context, cache *AccountCache, set CredentialSet, events []audit.Event, now time.Time) {
+	profiles := map[string]Profile{set.Session.Name: set.Session}
+	for _, role := range set.Roles {
+		profiles[role.Name] = role
+	}
+
+	for _, event := range events {
+		parsed, err := arn.Parse(event.CallerARN)
+		if err != nil {
+			continue
+		}
+		cache.Profiles[event.Profile] = parsed.AccountID
+		profile, ok := profiles[event.Profile]
+		if !ok || cache.fresh(parsed.AccountID, now) {
+			continue
+		}
+
+		client, err := conf.aliasClient(&types.Credentials{
+			AccessKeyId:     aws.String(profile.Credentials.AccessKeyID),
+			SecretAccessKey: aws.String(profile.Credentials.SecretAccessKey),
+			SessionToken:    aws.String(profile.Credentials.SessionToken),
+		})
+		if err == nil {
+			var alias string
+			if alias, err = accountAlias(ctx, client); err == nil {
+				cache.Aliases[parsed.AccountID] = CachedAlias{Alias: alias, Updated: now}
+			}
+		}
+		if err != nil {
+			slog.Debug("Could not look up the account alias", "profile", event.Profile, "account", parsed.AccountID, "error", err)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package awsconfig
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gredentures/pkg/audit"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccountCachePath(t *testing.T) {
+	t.Setenv("HOME", "/home/alice")
+	t.Setenv("XDG_CACHE_HOME", "")
+	assert.Equal(t, "/home/alice/.cache/gredentures/accounts.json", AccountCachePath())
+
+	t.Setenv("XDG_CACHE_HOME", "/var/cache/alice")
+	assert.Equal(t, "/var/cache/alice/gredentures/accounts.json", AccountCachePath())
+}
+
+func TestAccountCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gredentures", "accounts.json")
+	cache := LoadAccountCache(path)
+	assert.Empty(t, cache.Alias("111111111111"))
+
+	cache.Aliases["111111111111"] = CachedAlias{Alias: "acme-prod", Updated: time.Now()}
+	cache.Profiles["prod-mfa"] = "111111111111"
+	assert.NoError(t, cache.Save(path))
+
+	loaded := LoadAccountCache(path)
+	assert.Equal(t, "acme-prod", loaded.Alias("111111111111"))
+	assert.Equal(t, "111111111111", loaded.ProfileAccount("prod-mfa"))
+	assert.Equal(t, "acme-prod (111111111111)", loaded.DisplayAccount("111111111111"))
+	assert.Equal(t, "222222222222", loaded.DisplayAccount("222222222222"))
+	assert.Empty(t, loaded.DisplayAccount(""))
+
+	t.Run("Ignores a broken cache", func(t *testing.T) {
+		assert.NoError(t, os.WriteFile(path, []byte("{"), 0o600))
+		assert.Empty(t, LoadAccountCache(path).Aliases)
+	})
+}
+
+func TestCacheAccounts(t *testing.T) {
+	now := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	conf := &AwsConfig{
+		sessionCreds: &sts.GetSessionTokenOutput{Credentials: &types.Credentials{AccessKeyId: aws.String("session")}},
+		roleCreds:    map[string]*types.Credentials{"prod-mfa": {AccessKeyId: aws.String("prod")}},
+	}
+	set, err := conf.credentialSet()
+	assert.NoError(t, err)
+	events := []audit.Event{
+		{Profile: "default-mfa", CallerARN: "arn:aws:iam::123456789012:user/alice"},
+		{Profile: "prod-mfa", CallerARN: "arn:aws:sts::111111111111:assumed-role/Admin/gredentures-prod"},
+	}
+
+	aliases := &aliasesByKey{aliases: map[string]string{"any": "acme"}}
+	conf.aliases = aliases
+	cache := &AccountCache{
+		Aliases:  map[string]CachedAlias{"111111111111": {Alias: "acme-prod", Updated: now.Add(-time.Hour)}},
+		Profiles: map[string]string{},
+	}
+	conf.cacheAccounts(context.Background(), cache, set, events, now)
+
+	assert.Equal(t, 1, aliases.calls, "fresh aliases are not looked up again")
+	assert.Equal(t, "acme", cache.Alias("123456789012"))
+	assert.Equal(t, "acme-prod", cache.Alias("111111111111"))
+	assert.Equal(t, map[string]string{"default-mfa": "123456789012", "prod-mfa": "111111111111"}, cache.Profiles)
+
+	t.Run("Looks up stale aliases again", func(t *testing.T) {
+		conf.cacheAccounts(context.Background(), cache, set, events, now.Add(AliasCacheTTL))
+		assert.Equal(t, 3, aliases.calls)
+		assert.Equal(t, "acme", cache.Alias("111111111111"))
+	})
+}
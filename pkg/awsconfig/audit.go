@@ -0,0 +1,32 @@
+package awsconfig
+
+import "gredentures/pkg/audit"
+
+// IssueEvents returns an audit event for the session credentials and for every assumed role.
+// No call is made for them: the session carries the caller ARN only when the login looked it
+// up anyway, as --no-mfa does, and roles the ARN of the assumed role user.
+func (conf *AwsConfig) IssueEvents() []audit.Event {
+	if conf.sessionCreds == nil || conf.sessionCreds.Credentials == nil {
+		return nil
+	}
+	set, err := conf.credentialSet()
+	if err != nil {
+		return nil
+	}
+
+	events := []audit.Event{issueEvent(set.Session, conf.callerARN)}
+	for _, role := range set.Roles {
+		events = append(events, issueEvent(role, conf.roleARNs[role.Name]))
+	}
+	return events
+}
+
+// issueEvent describes issued credentials without any of their secrets.
+func issueEvent(profile Profile, callerARN string) audit.Event {
+	event := audit.Event{Action: audit.ActionIssue, Profile: profile.Name, CallerARN: callerARN}
+	if profile.Credentials.CanExpire {
+		expiration := profile.Credentials.Expires
+		event.Expiration = &expiration
+	}
+	return event
+}
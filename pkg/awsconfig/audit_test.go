@@ -0,0 +1,50 @@
+package awsconfig
+
+import (
+	"testing"
+	"time"
+
+	"gredentures/pkg/audit"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIssueEvents(t *testing.T) {
+	expiration := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	conf := &AwsConfig{
+		sessionProfile: "work-mfa",
+		sessionCreds: &sts.GetSessionTokenOutput{Credentials: &types.Credentials{
+			AccessKeyId:     aws.String("sessionKey"),
+			SecretAccessKey: aws.String("sessionSecret"),
+			SessionToken:    aws.String("sessionToken"),
+			Expiration:      &expiration,
+		}},
+		roleCreds: map[string]*types.Credentials{
+			"prod-mfa": {AccessKeyId: aws.String("roleKey"), SecretAccessKey: aws.String("roleSecret"), Expiration: &expiration},
+		},
+		roleARNs: map[string]string{"prod-mfa": "arn:aws:sts::111111111111:assumed-role/Admin/gredentures-prod"},
+	}
+
+	t.Run("Includes the known caller ARN and expiry of every profile", func(t *testing.T) {
+		conf := *conf
+		conf.callerARN = "arn:aws:iam::123456789012:user/me"
+		assert.Equal(t, []audit.Event{
+			{Action: audit.ActionIssue, Profile: "work-mfa", Expiration: &expiration, CallerARN: "arn:aws:iam::123456789012:user/me"},
+			{Action: audit.ActionIssue, Profile: "prod-mfa", Expiration: &expiration, CallerARN: "arn:aws:sts::111111111111:assumed-role/Admin/gredentures-prod"},
+		}, conf.IssueEvents())
+	})
+
+	t.Run("Records the session without looking up its caller", func(t *testing.T) {
+		events := conf.IssueEvents()
+		assert.Len(t, events, 2)
+		assert.Equal(t, "work-mfa", events[0].Profile)
+		assert.Empty(t, events[0].CallerARN)
+	})
+
+	t.Run("Empty without session credentials", func(t *testing.T) {
+		assert.Empty(t, (&AwsConfig{}).IssueEvents())
+	})
+}
@@ -4,112 +4,520 @@
 package awsconfig
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"gredentures/pkg/appconfig"
+	"gredentures/pkg/interrupt"
+	"gredentures/pkg/secret"
+	"gredentures/pkg/sysuser"
+	"io"
 	"log/slog"
+	"maps"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
 	"gopkg.in/ini.v1"
 )
 
+// lockStaleAfter is the age after which a credentials file lock is considered abandoned.
+const lockStaleAfter = time.Minute
+
+// maxRoleWorkers bounds the number of concurrent AssumeRole calls made by GetRoleCreds.
+const maxRoleWorkers = 4
+
+// stsAPI is the subset of the STS client used by AwsConfig, allowing it to be mocked in tests.
+type stsAPI interface {
+	GetSessionToken(ctx context.Context, params *sts.GetSessionTokenInput, optFns ...func(*sts.Options)) (*sts.GetSessionTokenOutput, error)
+	AssumeRole(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error)
+	GetCallerIdentity(ctx context.Context, params *sts.GetCallerIdentityInput, optFns ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error)
+}
+
+// Profile names used when none are configured.
+const (
+	defaultSourceProfile  = "default"
+	defaultSessionProfile = "default-mfa"
+)
+
 // AwsConfig represents the AWS configuration and credentials.
 // It includes default credentials and session credentials for MFA authentication.
 type AwsConfig struct {
-	defaultCreds aws.Credentials            // Default AWS credentials.
-	sessionCreds *sts.GetSessionTokenOutput // Session credentials for MFA authentication.
+	defaultCreds   aws.Credentials               // Default AWS credentials.
+	sessionCreds   *sts.GetSessionTokenOutput    // Session credentials for MFA authentication.
+	callerAccount  string                        // Account of the session, from the MFA device ARN, "" when not known.
+	callerARN      string                        // Caller of the session, "" unless the login looked it up anyway.
+	roleCreds      map[string]*types.Credentials // Assumed role credentials keyed by profile name.
+	roleARNs       map[string]string             // Assumed role user ARNs keyed by profile name.
+	roleResults    []RoleResult                  // Outcome of each org of the last GetRoleCreds, see RoleResults.
+	softFail       bool                          // Keep the roles that were assumed when others fail, see assumeRoles.
+	sourceProfile  string                        // Profile holding the long-lived credentials.
+	sourceFile     string                        // Credentials file holding sourceProfile, if not the default.
+	sessionProfile string                        // Profile the session credentials are written to.
+	externalSource bool                          // Default credentials came from an external secret store.
+	policyArns     []string                      // Managed session policies applied to assumed roles.
+	policy         string                        // Inline session policy applied to assumed roles.
+	externalID     string                        // External ID sent for assumed roles that set none of their own.
+	region         string                        // Region for STS calls and the written profiles, defaultRegion for the calls when empty.
+	proxy          string                        // Proxy URL for AWS requests, see httpOptions.
+	caBundle       string                        // PEM file of extra CA certificates, see httpOptions.
+	orgProfiles    map[string]string             // Evaluated profile name of each assumed org, see ApplyProfileNames.
+	aliases        aliasAPI                      // Account alias lookups, replaced in tests.
+	skipIMDS       bool                          // Never query the EC2 instance metadata service, see loadOptions.
+	sdk            appconfig.SDKConfig           // Retries and timeouts of the selected command, see tuneSDK.
+	managed        []string                      // Further profiles gredentures owns, see CredentialSet.Managed.
+	currentProfile string                        // Alias mirroring the last session, see CredentialSet.Current.
+	compatMode     bool                          // Leave the profiles of other credential helpers alone, see CredentialSet.Protect.
+	force          bool                          // Overwrite unmanaged profiles holding long-lived keys, see CredentialSet.Force.
+	partition      string                        // Partition of the session, whose orgs GetRoleCreds assumes, see appconfig.AppConfig.Partition.
+	stsRegions     []string                      // Regions, or appconfig.STSGlobal, STS calls fail over to, see stsClient.
+	source         *aws.Config                   // Source profile config loaded once per run by sourceAccount, never modified.
+}
+
+// sessionEnvKeys lists the environment variables that SessionEnv strips from the
+// inherited environment so they cannot shadow the injected session credentials.
+var sessionEnvKeys = []string{
+	"AWS_ACCESS_KEY_ID",
+	"AWS_SECRET_ACCESS_KEY",
+	"AWS_SESSION_TOKEN",
+	"AWS_SECURITY_TOKEN",
+	"AWS_CREDENTIAL_EXPIRATION",
+	"AWS_PROFILE",
+	"AWS_DEFAULT_PROFILE",
 }
 
 // GetDefaultAccount loads the default AWS configuration using the "default" profile.
 // It returns the AWS configuration or an error if the configuration cannot be loaded.
 func GetDefaultAccount() (aws.Config, error) {
-	slog.Debug("Loading default AWS config")
-	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion("us-west-2"),
-		config.WithSharedConfigProfile("default"))
+	return GetAccount(defaultSourceProfile)
+}
+
+// GetAccount loads the AWS configuration using the given shared config profile, optionally
+// read from the given credentials files instead of the default ~/.aws/credentials.
+// It returns the AWS configuration or an error if the configuration cannot be loaded.
+func GetAccount(profile string, credentialsFiles ...string) (aws.Config, error) {
+	return getAccount(profile, credentialsFiles, nil)
+}
+
+// getAccount is GetAccount with extra load options, such as those of httpOptions.
+func getAccount(profile string, credentialsFiles []string, extra []func(*config.LoadOptions) error) (aws.Config, error) {
+	slog.Debug("Loading AWS config", "profile", profile, "credentials_files", credentialsFiles)
+	opts := []func(*config.LoadOptions) error{
+		config.WithSharedConfigProfile(profile),
+	}
+	opts = append(opts, sdkLogOptions()...)
+	opts = append(opts, traceOptions()...)
+	opts = append(opts, extra...)
+	if len(credentialsFiles) > 0 {
+		opts = append(opts, config.WithSharedCredentialsFiles(credentialsFiles))
+	}
+	// The SDK finds $AWS_CONFIG_FILE itself, only --aws-config-file has to be passed on
+	if awsConfigPath != "" {
+		opts = append(opts, config.WithSharedConfigFiles([]string{awsConfigPath}))
+	}
+	cfg, err := config.LoadDefaultConfig(interrupt.Context(), opts...)
 	if err != nil {
 		return aws.Config{}, fmt.Errorf("unable to load SDK config, %v", err)
 	}
+	cfg.Region = cmp.Or(cfg.Region, defaultRegion)
 
 	return cfg, nil
 }
 
+// SetSourceProfile selects the profile, and optionally the credentials file, the long-lived
+// credentials are read from, including any override for the selected org. When AWS_PROFILE
+// points at a profile gredentures manages, it is ignored with a warning, since session
+// credentials cannot be used to request another MFA session.
+func (conf *AwsConfig) SetSourceProfile(appconfig appconfig.AppConfig) {
+	conf.sourceProfile, conf.sourceFile = appconfig.Source()
+	conf.proxy, conf.caBundle = appconfig.Proxy, appconfig.CABundle
+	conf.skipIMDS = appconfig.SkipIMDS
+	conf.sdk = appconfig.SDK.ForCommand(appconfig.CommandName())
+	conf.managed = appconfig.ManagedPatterns
+	conf.currentProfile = appconfig.CurrentProfile
+	conf.compatMode = appconfig.CompatMode
+	conf.force = appconfig.Force
+	conf.stsRegions = appconfig.STSRegions
+	conf.source = nil // Loaded again by sourceAccount for the new source
+	conf.partition = appconfig.Partition()
+	if conf.sourceProfile == "" {
+		conf.sourceProfile = defaultSourceProfile
+	}
+	if recipe, ok := appconfig.SelectedRecipe(); ok {
+		conf.region = recipe.Region
+	}
+	// Sessions inherit the region of the long-lived keys, so AWS tools call the same one with them
+	if conf.region == "" {
+		conf.region = SourceRegion(conf.sourceProfile, conf.SourceCredentialsPath(), conf.partition)
+	}
+	// The STS endpoints of the commercial regions do not know the keys of other partitions
+	if conf.region == "" {
+		conf.region = PartitionRegion(conf.partition)
+	}
+
+	// AWS_PROFILE naming the CurrentProfile alias is what the alias is for, so it is not warned about
+	envProfile := os.Getenv("AWS_PROFILE")
+	if envProfile != "" && envProfile != appconfig.CurrentProfile && slices.Contains(appconfig.ManagedProfiles(), envProfile) {
+		slog.Warn("AWS_PROFILE points at a gredentures session profile, using the source profile instead",
+			"aws_profile", envProfile, "source_profile", conf.sourceProfile)
+	}
+}
+
+// SetSourceCreds supplies the long-lived credentials from an external secret store, such as
+// 1Password, instead of the shared credentials file. They are used for the STS calls but
+// are never written to disk.
+func (conf *AwsConfig) SetSourceCreds(accessKeyID string, secretAccessKey secret.Value) {
+	conf.defaultCreds = aws.Credentials{
+		AccessKeyID:     accessKeyID,
+		SecretAccessKey: secretAccessKey.Reveal(),
+		Source:          "gredentures-external",
+	}
+	conf.externalSource = true
+	conf.source = nil // Loaded again by sourceAccount for the new source
+}
+
+// SourceExternal reports whether the long-lived credentials were supplied with SetSourceCreds
+// rather than read from the credentials file.
+func (conf *AwsConfig) SourceExternal() bool {
+	return conf.externalSource
+}
+
+// sourceAccount returns the AWS configuration for the selected source profile. Loading it
+// parses the shared config files, so it is loaded once and reused for the rest of the run.
+func (conf *AwsConfig) sourceAccount() (aws.Config, error) {
+	if conf.source == nil {
+		cfg, err := conf.loadSourceAccount()
+		if err != nil {
+			return aws.Config{}, err
+		}
+		conf.source = &cfg
+	}
+
+	cfg := *conf.source // A copy, sessionAccount replaces its credentials
+	if conf.region != "" {
+		cfg.Region = conf.region
+	}
+	return cfg, nil
+}
+
+// loadSourceAccount loads the AWS configuration for the selected source profile.
+func (conf *AwsConfig) loadSourceAccount() (aws.Config, error) {
+	httpOpts, err := conf.loadOptions()
+	if err != nil {
+		return aws.Config{}, err
+	}
+
+	var cfg aws.Config
+	switch {
+	case conf.externalSource:
+		slog.Debug("Loading AWS config with external source credentials")
+		opts := append([]func(*config.LoadOptions) error{config.WithRegion(defaultRegion),
+			config.WithCredentialsProvider(staticCredentials(conf.defaultCreds))}, sdkLogOptions()...)
+		opts = append(opts, traceOptions()...)
+		// The settings of the default profile still come from the file of --aws-config-file
+		if awsConfigPath != "" {
+			opts = append(opts, config.WithSharedConfigFiles([]string{awsConfigPath}))
+		}
+		cfg, err = config.LoadDefaultConfig(interrupt.Context(), append(opts, httpOpts...)...)
+		if err != nil {
+			return aws.Config{}, fmt.Errorf("unable to load SDK config, %v", err)
+		}
+	case conf.sourceFile != "":
+		cfg, err = getAccount(conf.SourceProfileName(), []string{conf.sourceFile}, httpOpts)
+	default:
+		cfg, err = getAccount(conf.SourceProfileName(), nil, httpOpts)
+	}
+	return cfg, err
+}
+
 // CreateUpdatedConfig creates an updated AWS credentials file with default and session credentials.
 // It writes the credentials to the ~/.aws/credentials file and returns an error if the operation fails.
+// Nothing is written when any of the credentials is missing or empty, see ErrIncompleteCredentials.
 func (conf *AwsConfig) CreateUpdatedConfig() error {
-	inidata := ini.Empty()
+	_, err := conf.writeCredentialsFile()
+	return err
+}
 
-	// Helper function to create a section and add keys.
-	addKeysToSection := func(sectionName string, keys map[string]string) error {
-		slog.Debug("Creating section", "section", sectionName)
-		sec, err := inidata.NewSection(sectionName)
-		if err != nil {
-			return fmt.Errorf("failed to create section '%s': %w", sectionName, err)
+// writeCredentialsFile is CreateUpdatedConfig, also reporting whether a stale session token
+// was removed from the source profile, see SharedCredentialsWriter.RemovedToken.
+func (conf *AwsConfig) writeCredentialsFile() (removedToken bool, err error) {
+	writer := &SharedCredentialsWriter{Path: CredentialsPath()}
+	err = conf.WriteCredentials(writer)
+	return writer.RemovedToken, err
+}
+
+// credentialsPath is the credentials file set with SetCredentialsPath, empty for the default.
+var credentialsPath string
+
+// SetCredentialsPath has the sessions written to and read from path instead of
+// ~/.aws/credentials, for the CredentialsFile of the config file. The long-lived source keys
+// stay where they are. An empty path restores the default.
+func SetCredentialsPath(path string) {
+	credentialsPath = path
+}
+
+// CredentialsPath returns the location of the shared credentials file the sessions are written
+// to, ~/.aws/credentials unless SetCredentialsPath moved it.
+func CredentialsPath() string {
+	if credentialsPath != "" {
+		return credentialsPath
+	}
+	return homeCredentialsPath()
+}
+
+// homeCredentialsPath returns the default shared credentials file, ~/.aws/credentials.
+func homeCredentialsPath() string {
+	return fmt.Sprintf("%s/.aws/credentials", sysuser.Home())
+}
+
+// awsConfigPath is the shared config file set with SetAWSConfigPath, empty for the default.
+var awsConfigPath string
+
+// SetAWSConfigPath has the profiles of the shared config file read from and written to path,
+// for --aws-config-file. An empty path restores the default.
+func SetAWSConfigPath(path string) {
+	awsConfigPath = path
+}
+
+// AWSConfigPath returns the shared config file the source profile's settings are read from
+// and generated profiles are written to: the one set with SetAWSConfigPath, $AWS_CONFIG_FILE
+// or ~/.aws/config.
+func AWSConfigPath() string {
+	return cmp.Or(awsConfigPath, os.Getenv("AWS_CONFIG_FILE"), filepath.Join(sysuser.Home(), ".aws", "config"))
+}
+
+// SourceConfigured reports whether the credentials file contains long-lived keys for the
+// source profile. A missing credentials file is not an error, it simply isn't configured.
+func (conf *AwsConfig) SourceConfigured() (bool, error) {
+	if conf.externalSource {
+		return true, nil
+	}
+
+	inidata, err := ini.Load(conf.SourceCredentialsPath())
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to read credentials file: %w", err)
+	}
+
+	section, err := inidata.GetSection(conf.SourceProfileName())
+	if err != nil {
+		return false, nil
+	}
+	return section.HasKey("aws_access_key_id") && section.HasKey("aws_secret_access_key"), nil
+}
+
+// SourceKeys returns the long-lived key pair of the source profile, from the external secret
+// store when one supplied it and from the credentials file otherwise.
+func (conf *AwsConfig) SourceKeys() (Profile, error) {
+	if conf.externalSource {
+		return Profile{Name: conf.SourceProfileName(), Credentials: conf.defaultCreds}, nil
+	}
+	profile, err := ReadProfile(conf.SourceCredentialsPath(), conf.SourceProfileName())
+	if err != nil {
+		return Profile{}, err
+	}
+	if profile.Credentials.AccessKeyID == "" || profile.Credentials.SecretAccessKey == "" {
+		return Profile{}, fmt.Errorf("%w: profile %s in %s holds no access key pair", ErrIncompleteCredentials, profile.Name, conf.SourceCredentialsPath())
+	}
+	return profile, nil
+}
+
+// BootstrapCredentials stores a long-lived key pair in the source profile of the credentials
+// file, creating ~/.aws and the file itself if needed, and drops any session token left in the
+// profile, which STS would reject next to the new pair. Other sections are left untouched.
+func (conf *AwsConfig) BootstrapCredentials(accessKeyID string, secretAccessKey secret.Value) error {
+	credentialsPath := conf.SourceCredentialsPath()
+
+	inidata, err := ini.Load(credentialsPath)
+	if os.IsNotExist(err) {
+		slog.Debug("Creating credentials file", "path", credentialsPath)
+		inidata = ini.Empty()
+	} else if err != nil {
+		return fmt.Errorf("failed to read credentials file: %w", err)
+	}
+
+	section := inidata.Section(conf.SourceProfileName())
+	section.Key("aws_access_key_id").SetValue(accessKeyID)
+	section.Key("aws_secret_access_key").SetValue(secretAccessKey.Reveal())
+	for _, key := range sessionTokenKeys {
+		section.DeleteKey(key)
+	}
+
+	slog.Debug("Saving credentials file", "path", credentialsPath, "section", section.Name())
+	if err := saveAtomic(inidata, credentialsPath); err != nil {
+		return fmt.Errorf("failed to save file: %w", err)
+	}
+
+	return nil
+}
+
+// RemoveSourceKeys deletes the long-lived key pair from the source profile of the credentials
+// file, once a KeyStore holds it. The section goes too when nothing else is left in it.
+func (conf *AwsConfig) RemoveSourceKeys() error {
+	credentialsPath := conf.SourceCredentialsPath()
+	inidata, err := ini.Load(credentialsPath)
+	if err != nil {
+		return fmt.Errorf("failed to read credentials file: %w", err)
+	}
+	section, err := inidata.GetSection(conf.SourceProfileName())
+	if err != nil {
+		return nil // Nothing to remove
+	}
+	section.DeleteKey("aws_access_key_id")
+	section.DeleteKey("aws_secret_access_key")
+	if len(section.Keys()) == 0 {
+		inidata.DeleteSection(section.Name())
+	}
+
+	slog.Debug("Saving credentials file", "path", credentialsPath, "removed", section.Name())
+	if err := saveAtomic(inidata, credentialsPath); err != nil {
+		return fmt.Errorf("failed to save file: %w", err)
+	}
+	return nil
+}
+
+// SourceCredentialsPath returns the credentials file holding the source profile.
+func (conf *AwsConfig) SourceCredentialsPath() string {
+	if conf.sourceFile != "" {
+		return conf.sourceFile
+	}
+	return homeCredentialsPath()
+}
+
+// SourceProfileName returns the source profile, defaulting to "default" when unset.
+func (conf *AwsConfig) SourceProfileName() string {
+	if conf.sourceProfile == "" {
+		return defaultSourceProfile
+	}
+	return conf.sourceProfile
+}
+
+// lockFile takes an advisory lock on path by creating path.lock exclusively, so concurrent
+// gredentures runs cannot interleave their writes. Locks older than lockStaleAfter are left
+// over from a crashed run and are taken over. The returned function releases the lock.
+func lockFile(path string) (func(), error) {
+	lockPath := path + ".lock"
+	for attempt := 0; ; attempt++ {
+		lock, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			lock.Close()
+			// An interrupted run must not leave the lock behind for the next one
+			forget := interrupt.OnInterrupt(func() { os.Remove(lockPath) })
+			return func() { forget(); os.Remove(lockPath) }, nil
 		}
-		for key, value := range keys {
-			slog.Debug("Creating key", "key", key, "value", value)
-			if _, err := sec.NewKey(key, value); err != nil {
-				return fmt.Errorf("failed to create key '%s' in section '%s': %w", key, sectionName, err)
-			}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to lock %s: %w", path, err)
 		}
-		return nil
-	}
 
-	// Add keys to the "default" section.
-	defaultKeys := map[string]string{
-		"aws_access_key_id":     conf.defaultCreds.AccessKeyID,
-		"aws_secret_access_key": conf.defaultCreds.SecretAccessKey,
+		info, statErr := os.Stat(lockPath)
+		if attempt == 0 && statErr == nil && time.Since(info.ModTime()) > lockStaleAfter {
+			slog.Debug("Removing stale lock", "path", lockPath)
+			os.Remove(lockPath)
+			continue
+		}
+		return nil, fmt.Errorf("%w: %s is held by another gredentures process", ErrCredentialsFileLocked, lockPath)
 	}
-	if err := addKeysToSection("default", defaultKeys); err != nil {
+}
+
+// saveAtomic writes data, usually an INI file, to a temporary file next to path and renames
+// it into place, so readers never observe a partially written credentials file.
+func saveAtomic(data io.WriterTo, path string) error {
+	// Never write the credentials file of another user, e.g. a HOME kept by sudo
+	if err := sysuser.CheckOwner(path); err != nil {
 		return err
 	}
 
-	// Add keys to the "default-mfa" section.
-	defaultMfaKeys := map[string]string{
-		"aws_session_token":     *conf.sessionCreds.Credentials.SessionToken,
-		"aws_access_key_id":     *conf.sessionCreds.Credentials.AccessKeyId,
-		"aws_secret_access_key": *conf.sessionCreds.Credentials.SecretAccessKey,
+	// Create ~/.aws on first run, readable by the owner only
+	if err := sysuser.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
 	}
-	if err := addKeysToSection("default-mfa", defaultMfaKeys); err != nil {
+
+	unlock, err := lockFile(path)
+	if err != nil {
 		return err
 	}
+	defer unlock()
 
-	// Save the new ~/.aws/credentials file.
-	credentialsPath := fmt.Sprintf("%s/.aws/credentials", os.Getenv("HOME"))
-	slog.Debug("Saving credentials file", "path", credentialsPath)
-	if err := inidata.SaveTo(credentialsPath); err != nil {
-		return fmt.Errorf("failed to save file: %w", err)
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".credentials-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
 	}
+	defer os.Remove(tmp.Name()) // No-op once the rename has succeeded
+	defer interrupt.OnInterrupt(func() { os.Remove(tmp.Name()) })()
 
-	return nil
+	if _, err := data.WriteTo(tmp); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set permissions on temporary file: %w", err)
+	}
+	if err := sysuser.Chown(tmp.Name()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary file: %w", err)
+	}
+
+	return os.Rename(tmp.Name(), path)
 }
 
 // GetSessionCreds retrieves session credentials using MFA authentication.
 // It uses the provided AppConfig to generate a session token and stores the credentials in AwsConfig.
+// With NoMFA set the session is requested without a device and token, after checking that the
+// source user's policies do not depend on MFA.
 func (conf *AwsConfig) GetSessionCreds(appconfig appconfig.AppConfig) error {
-	config, err := GetDefaultAccount()
+	config, err := conf.sourceAccount()
 	if err != nil {
 		return fmt.Errorf("failed to get default account: %w", err)
 	}
 
-	client := sts.NewFromConfig(config)
+	client := conf.stsClient(config)
 
-	slog.Debug("Getting session token", "device", appconfig.Device, "org", appconfig.Org)
-	input := &sts.GetSessionTokenInput{
-		DurationSeconds: aws.Int32(appconfig.Timeout),
-		SerialNumber:    aws.String(appconfig.Device),
-		TokenCode:       aws.String(appconfig.Token),
+	// GetSessionToken succeeds without MFA even where the policies deny everything without it,
+	// so the enforcement is detected up front instead of surfacing as later AccessDenied errors
+	var callerARN string
+	if appconfig.NoMFA {
+		var required bool
+		callerARN, required, err = mfaRequired(interrupt.Context(), client, conf.clients(config).iam())
+		switch {
+		case err != nil:
+			slog.Warn("Could not detect whether MFA is enforced, requesting the session anyway", "error", err)
+		case required:
+			return fmt.Errorf("%w: the policies of the source user only allow API calls with MFA", ErrMFARequired)
+		}
 	}
 
-	slog.Debug("Getting session token", "serial_number", appconfig.Device, "token_code", appconfig.Token)
-	creds, err := client.GetSessionToken(context.TODO(), input)
+	slog.Debug("Getting session token", "device", appconfig.Device, "org", appconfig.Org, "mfa", !appconfig.NoMFA)
+	creds, err := client.GetSessionToken(interrupt.Context(), sessionTokenInput(appconfig))
 	if err != nil {
-		return fmt.Errorf("failed to get session token: %w", err)
+		return fmt.Errorf("failed to get session token: %w", checkClockSkew(classifySTSError(err), time.Now()))
 	}
 
+	profile, err := conf.profileName(interrupt.Context(), appconfig.Profile, appconfig.SessionProfileData(), creds.Credentials)
+	if err != nil {
+		return err
+	}
 	conf.sessionCreds = creds
+	conf.sessionProfile = profile
+	conf.callerARN = callerARN
+	conf.callerAccount = ""
+	if device, err := arn.Parse(appconfig.Device); err == nil {
+		conf.callerAccount = device.AccountID // Virtual MFA devices belong to the account of their user
+	}
 
 	return nil
 }
@@ -117,18 +525,277 @@ func (conf *AwsConfig) GetSessionCreds(appconfig appconfig.AppConfig) error {
 // GetDefaultCreds retrieves the default AWS credentials and stores them in AwsConfig.
 // It uses the default AWS configuration to retrieve the credentials.
 func (conf *AwsConfig) GetDefaultCreds() error {
-	config, err := GetDefaultAccount()
+	if conf.externalSource {
+		slog.Debug("Using externally sourced default credentials")
+		return nil
+	}
+
+	config, err := conf.sourceAccount()
 	if err != nil {
 		return fmt.Errorf("failed to get default account: %w", err)
 	}
 
 	slog.Debug("Getting default credentials")
-	creds, err := config.Credentials.Retrieve(context.TODO())
+	creds, err := config.Credentials.Retrieve(interrupt.Context())
 	if err != nil {
 		return fmt.Errorf("failed to retrieve default credentials: %w", err)
 	}
 
+	// A session token left next to long-lived keys, e.g. by another tool, is sent along with
+	// them and STS rejects the request, so it is dropped and removed when the file is written
+	if staleSessionToken(creds) {
+		slog.Warn("Ignoring the session token stored with the long-lived keys", "profile", conf.SourceProfileName())
+		creds.SessionToken = ""
+		conf.source.Credentials = aws.NewCredentialsCache(staticCredentials(creds))
+	}
 	conf.defaultCreds = creds
 
 	return nil
 }
+
+// staleSessionToken reports whether creds hold a session token along with long-lived keys,
+// whose access key IDs start with AKIA. Those of temporary credentials start with ASIA.
+func staleSessionToken(creds aws.Credentials) bool {
+	return creds.SessionToken != "" && strings.HasPrefix(creds.AccessKeyID, "AKIA")
+}
+
+// SessionEnv returns a copy of the given environment with the session credentials injected.
+// Any inherited AWS credential or profile variables are removed so the child process
+// always uses the session credentials. It returns an error if no session credentials are held.
+func (conf *AwsConfig) SessionEnv(environ []string) ([]string, error) {
+	if conf.sessionCreds == nil || conf.sessionCreds.Credentials == nil {
+		return nil, fmt.Errorf("no session credentials available")
+	}
+	creds := conf.sessionCreds.Credentials
+
+	env := make([]string, 0, len(environ)+4)
+	for _, kv := range environ {
+		name, _, _ := strings.Cut(kv, "=")
+		if slices.Contains(sessionEnvKeys, name) {
+			slog.Debug("Removing inherited environment variable", "name", name)
+			continue
+		}
+		env = append(env, kv)
+	}
+
+	env = append(env,
+		"AWS_ACCESS_KEY_ID="+aws.ToString(creds.AccessKeyId),
+		"AWS_SECRET_ACCESS_KEY="+aws.ToString(creds.SecretAccessKey),
+		"AWS_SESSION_TOKEN="+aws.ToString(creds.SessionToken),
+	)
+	if creds.Expiration != nil {
+		env = append(env, "AWS_CREDENTIAL_EXPIRATION="+creds.Expiration.UTC().Format(time.RFC3339))
+	}
+	if conf.region != "" {
+		env = append(env, "AWS_REGION="+conf.region, "AWS_DEFAULT_REGION="+conf.region)
+	}
+	if awsConfigPath != "" {
+		env = append(env, "AWS_CONFIG_FILE="+awsConfigPath)
+	}
+
+	return env, nil
+}
+
+// GetRoleCreds assumes the role of every org configured in the AppConfig using the MFA
+// session credentials, so a single token covers all accounts. Roles are assumed concurrently
+// and the resulting credentials are stored in AwsConfig keyed by their profile name.
+func (conf *AwsConfig) GetRoleCreds(appconfig appconfig.AppConfig) error {
+	if conf.sessionCreds == nil || conf.sessionCreds.Credentials == nil {
+		return fmt.Errorf("session credentials are required to assume roles")
+	}
+
+	config, err := conf.sessionAccount()
+	if err != nil {
+		return err
+	}
+
+	conf.policyArns = appconfig.PolicyArns
+	conf.policy = appconfig.Policy
+	conf.externalID = appconfig.ExternalID
+	conf.softFail = appconfig.SoftFail
+	return conf.assumeRoles(interrupt.Context(), conf.stsClient(config), conf.clients(config).iam(), conf.partitionOrgs(appconfig.Orgs))
+}
+
+// partitionOrgs returns the orgs in the partition of the session. A session cannot assume the
+// roles of another partition, so the orgs there are left out with a warning, to be logged in
+// to with --org and the long-lived keys of their partition.
+func (conf *AwsConfig) partitionOrgs(orgs map[string]appconfig.OrgConfig) map[string]appconfig.OrgConfig {
+	partition := cmp.Or(conf.partition, appconfig.PartitionAWS)
+	same := make(map[string]appconfig.OrgConfig, len(orgs))
+	for name, org := range orgs {
+		if org.PartitionName() != partition {
+			slog.Warn("Skipping org in another partition, log in to it with --org", "org", name,
+				"partition", org.PartitionName(), "session_partition", partition, "linked_account", org.LinkedAccount)
+			continue
+		}
+		same[name] = org
+	}
+	return same
+}
+
+// sessionAccount loads the source AWS configuration but authenticates with the MFA session
+// credentials rather than the long-lived keys.
+func (conf *AwsConfig) sessionAccount() (aws.Config, error) {
+	config, err := conf.sourceAccount()
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("failed to get default account: %w", err)
+	}
+
+	session := conf.sessionCreds.Credentials
+	config.Credentials = staticCredentials(aws.Credentials{
+		AccessKeyID:     aws.ToString(session.AccessKeyId),
+		SecretAccessKey: aws.ToString(session.SecretAccessKey),
+		SessionToken:    aws.ToString(session.SessionToken),
+	})
+
+	return config, nil
+}
+
+// staticCredentials adapts fixed credentials to an aws.CredentialsProvider.
+func staticCredentials(creds aws.Credentials) aws.CredentialsProvider {
+	return aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		return creds, nil
+	})
+}
+
+// RoleResult is the outcome of assuming the role of one org.
+type RoleResult struct {
+	Org     string // Name of the org.
+	Profile string // Profile the role credentials are written to, empty when the role failed.
+	Err     error  // Why the role could not be assumed, nil when it was.
+}
+
+// RoleResults returns the outcome of every org of the last GetRoleCreds, sorted by org.
+func (conf *AwsConfig) RoleResults() []RoleResult {
+	return conf.roleResults
+}
+
+// assumeRoles assumes each org's role with a bounded pool of workers. Credentials are only
+// stored if every role succeeds, so a partial failure never results in a partial write. In
+// soft-fail mode the roles that were assumed are stored anyway, and ErrPartialRoles is
+// returned along with the failures of the others. roles is used to discover a role's maximum
+// session duration and may be nil.
+func (conf *AwsConfig) assumeRoles(ctx context.Context, client stsAPI, roles iamAPI, orgs map[string]appconfig.OrgConfig) error {
+	type result struct {
+		org     string
+		profile string
+		creds   *types.Credentials
+		arn     string
+		err     error
+	}
+
+	names := make(chan string)
+	results := make(chan result, len(orgs))
+
+	var wg sync.WaitGroup
+	for range min(maxRoleWorkers, len(orgs)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range names {
+				org := orgs[name]
+				input := &sts.AssumeRoleInput{
+					RoleArn:         aws.String(org.RoleArn),
+					RoleSessionName: aws.String("gredentures-" + name),
+				}
+				if org.Timeout > 0 {
+					input.DurationSeconds = aws.Int32(org.Timeout)
+				}
+				for _, arn := range conf.policyArns {
+					input.PolicyArns = append(input.PolicyArns, types.PolicyDescriptorType{Arn: aws.String(arn)})
+				}
+				if conf.policy != "" {
+					input.Policy = aws.String(conf.policy)
+				}
+				if externalID := cmp.Or(org.ExternalID, conf.externalID); externalID != "" {
+					input.ExternalId = aws.String(externalID)
+				}
+
+				slog.Debug("Assuming role", "org", name, "role_arn", org.RoleArn)
+				out, err := assumeRole(ctx, client, roles, conf.callerAccount, input)
+				if err != nil {
+					results <- result{org: name, err: fmt.Errorf("failed to assume role for org %q: %w", name, checkExternalID(classifySTSError(err), input))}
+					continue
+				}
+				var arn string
+				if out.AssumedRoleUser != nil {
+					arn = aws.ToString(out.AssumedRoleUser.Arn)
+				}
+				profile, err := conf.profileName(ctx, org.ProfileTemplate(name), org.ProfileData(name), out.Credentials)
+				if err != nil {
+					results <- result{org: name, err: fmt.Errorf("org %q: %w", name, err)}
+					continue
+				}
+				results <- result{org: name, profile: profile, creds: out.Credentials, arn: arn}
+			}
+		}()
+	}
+
+	for name := range orgs {
+		names <- name
+	}
+	close(names)
+	wg.Wait()
+	close(results)
+
+	assumed := map[string]result{}
+	conf.roleResults = nil
+	var errs []error
+	for r := range results {
+		if r.err != nil {
+			errs = append(errs, r.err)
+			conf.roleResults = append(conf.roleResults, RoleResult{Org: r.org, Err: r.err})
+			continue
+		}
+		assumed[r.org] = r
+	}
+
+	// Templates evaluated per login may give several orgs the same profile, which would
+	// silently overwrite all but one of them
+	owners := map[string][]string{}
+	for _, org := range slices.Sorted(maps.Keys(assumed)) {
+		owners[assumed[org].profile] = append(owners[assumed[org].profile], org)
+	}
+	for _, profile := range slices.Sorted(maps.Keys(owners)) {
+		shared := owners[profile]
+		if len(shared) < 2 {
+			continue
+		}
+		quoted := make([]string, len(shared))
+		for i, org := range shared {
+			quoted[i] = strconv.Quote(org)
+		}
+		err := fmt.Errorf("orgs %s and %s are written to the same profile %q, make their Profile templates distinct", strings.Join(quoted[:len(quoted)-1], ", "), quoted[len(quoted)-1], profile)
+		errs = append(errs, err)
+		for _, org := range shared {
+			conf.roleResults = append(conf.roleResults, RoleResult{Org: org, Err: err})
+			delete(assumed, org)
+		}
+	}
+	for org, r := range assumed {
+		conf.roleResults = append(conf.roleResults, RoleResult{Org: org, Profile: r.profile})
+	}
+	slices.SortFunc(conf.roleResults, func(a, b RoleResult) int { return strings.Compare(a.Org, b.Org) })
+
+	if len(errs) > 0 && (!conf.softFail || len(assumed) == 0) {
+		return errors.Join(errs...)
+	}
+
+	roleCreds := make(map[string]*types.Credentials, len(assumed))
+	roleARNs := make(map[string]string, len(assumed))
+	orgProfiles := make(map[string]string, len(assumed))
+	for org, r := range assumed {
+		roleCreds[r.profile] = r.creds
+		roleARNs[r.profile] = r.arn
+		orgProfiles[org] = r.profile
+	}
+
+	conf.roleCreds = roleCreds
+	conf.roleARNs = roleARNs
+	conf.orgProfiles = orgProfiles
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%w, %d of %d failed: %w", ErrPartialRoles, len(orgs)-len(assumed), len(orgs), errors.Join(errs...))
+	}
+	return nil
+}
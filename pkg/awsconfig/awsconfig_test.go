@@ -1,19 +1,27 @@
 package awsconfig
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"log/slog"
+	"maps"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"gredentures/pkg/appconfig"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"gopkg.in/ini.v1"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/aws/aws-sdk-go-v2/service/sts/types"
 )
@@ -131,13 +139,23 @@ func TestCreateUpdatedConfig(t *testing.T) {
 
 // Mock STS client
 type MockSTSClient struct {
-	GetSessionTokenFunc func(ctx context.Context, params *sts.GetSessionTokenInput, optFns ...func(*sts.Options)) (*sts.GetSessionTokenOutput, error)
+	GetSessionTokenFunc   func(ctx context.Context, params *sts.GetSessionTokenInput, optFns ...func(*sts.Options)) (*sts.GetSessionTokenOutput, error)
+	AssumeRoleFunc        func(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error)
+	GetCallerIdentityFunc func(ctx context.Context, params *sts.GetCallerIdentityInput, optFns ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error)
 }
 
 func (m *MockSTSClient) GetSessionToken(ctx context.Context, params *sts.GetSessionTokenInput, optFns ...func(*sts.Options)) (*sts.GetSessionTokenOutput, error) {
 	return m.GetSessionTokenFunc(ctx, params, optFns...)
 }
 
+func (m *MockSTSClient) AssumeRole(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+	return m.AssumeRoleFunc(ctx, params, optFns...)
+}
+
+func (m *MockSTSClient) GetCallerIdentity(ctx context.Context, params *sts.GetCallerIdentityInput, optFns ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error) {
+	return m.GetCallerIdentityFunc(ctx, params, optFns...)
+}
+
 func TestGetSessionCreds(t *testing.T) {
 	mockSTS := &MockSTSClient{
 		GetSessionTokenFunc: func(ctx context.Context, params *sts.GetSessionTokenInput, optFns ...func(*sts.Options)) (*sts.GetSessionTokenOutput, error) {
@@ -164,7 +182,7 @@ func TestGetSessionCreds(t *testing.T) {
 	creds, err := mockSTS.GetSessionToken(context.TODO(), &sts.GetSessionTokenInput{
 		DurationSeconds: aws.Int32(appConfig.Timeout),
 		SerialNumber:    aws.String(appConfig.Device),
-		TokenCode:       aws.String(appConfig.Token),
+		TokenCode:       aws.String(appConfig.Token.Reveal()),
 	})
 	assert.NoError(t, err)
 
@@ -177,3 +195,699 @@ func TestGetSessionCreds(t *testing.T) {
 	assert.Equal(t, "mockSecretKey", *conf.sessionCreds.Credentials.SecretAccessKey)
 	assert.Equal(t, "mockSessionToken", *conf.sessionCreds.Credentials.SessionToken)
 }
+
+func TestSessionEnv(t *testing.T) {
+	t.Run("Injects session credentials and strips inherited ones", func(t *testing.T) {
+		expiration := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+		conf := AwsConfig{
+			sessionCreds: &sts.GetSessionTokenOutput{
+				Credentials: &types.Credentials{
+					AccessKeyId:     aws.String("mockAccessKey"),
+					SecretAccessKey: aws.String("mockSecretKey"),
+					SessionToken:    aws.String("mockSessionToken"),
+					Expiration:      &expiration,
+				},
+			},
+		}
+
+		env, err := conf.SessionEnv([]string{
+			"HOME=/home/test",
+			"AWS_PROFILE=default-mfa",
+			"AWS_ACCESS_KEY_ID=staleAccessKey",
+			"AWS_REGION=us-east-1",
+		})
+		assert.NoError(t, err)
+
+		assert.Equal(t, []string{
+			"HOME=/home/test",
+			"AWS_REGION=us-east-1",
+			"AWS_ACCESS_KEY_ID=mockAccessKey",
+			"AWS_SECRET_ACCESS_KEY=mockSecretKey",
+			"AWS_SESSION_TOKEN=mockSessionToken",
+			"AWS_CREDENTIAL_EXPIRATION=2030-01-02T03:04:05Z",
+		}, env)
+	})
+
+	t.Run("Errors without session credentials", func(t *testing.T) {
+		conf := AwsConfig{}
+		_, err := conf.SessionEnv(os.Environ())
+		assert.Error(t, err)
+	})
+}
+
+func TestAssumeRoles(t *testing.T) {
+	orgs := map[string]appconfig.OrgConfig{
+		"prod":    {RoleArn: "arn:aws:iam::111111111111:role/Admin", Timeout: 900},
+		"staging": {RoleArn: "arn:aws:iam::222222222222:role/Admin", Profile: "stage"},
+	}
+
+	t.Run("Assumes every configured role", func(t *testing.T) {
+		mockSTS := &MockSTSClient{
+			AssumeRoleFunc: func(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+				if *params.RoleArn == orgs["prod"].RoleArn {
+					assert.Equal(t, int32(900), *params.DurationSeconds)
+				} else {
+					assert.Nil(t, params.DurationSeconds)
+				}
+				return &sts.AssumeRoleOutput{
+					Credentials: &types.Credentials{
+						AccessKeyId:     aws.String("key-" + *params.RoleSessionName),
+						SecretAccessKey: aws.String("mockSecretKey"),
+						SessionToken:    aws.String("mockSessionToken"),
+					},
+					AssumedRoleUser: &types.AssumedRoleUser{
+						Arn: aws.String("arn:aws:sts::111111111111:assumed-role/Admin/" + *params.RoleSessionName),
+					},
+				}, nil
+			},
+		}
+
+		conf := &AwsConfig{}
+		err := conf.assumeRoles(context.TODO(), mockSTS, nil, orgs)
+		assert.NoError(t, err)
+
+		assert.Len(t, conf.roleCreds, 2)
+		assert.Equal(t, "key-gredentures-prod", *conf.roleCreds["prod-mfa"].AccessKeyId)
+		assert.Equal(t, "key-gredentures-staging", *conf.roleCreds["stage"].AccessKeyId)
+		assert.Equal(t, "arn:aws:sts::111111111111:assumed-role/Admin/gredentures-prod", conf.roleARNs["prod-mfa"])
+	})
+
+	t.Run("Applies session policies", func(t *testing.T) {
+		mockSTS := &MockSTSClient{
+			AssumeRoleFunc: func(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+				assert.Equal(t, []types.PolicyDescriptorType{
+					{Arn: aws.String("arn:aws:iam::aws:policy/ReadOnlyAccess")},
+				}, params.PolicyArns)
+				assert.Equal(t, `{"Version":"2012-10-17"}`, aws.ToString(params.Policy))
+				return &sts.AssumeRoleOutput{Credentials: &types.Credentials{}}, nil
+			},
+		}
+
+		conf := &AwsConfig{
+			policyArns: []string{"arn:aws:iam::aws:policy/ReadOnlyAccess"},
+			policy:     `{"Version":"2012-10-17"}`,
+		}
+		assert.NoError(t, conf.assumeRoles(context.TODO(), mockSTS, nil, orgs))
+	})
+
+	t.Run("Sends the external ID of the org or the flag", func(t *testing.T) {
+		orgs := map[string]appconfig.OrgConfig{
+			"prod":    {RoleArn: "arn:aws:iam::111111111111:role/Admin", ExternalID: "vendor-42"},
+			"staging": {RoleArn: "arn:aws:iam::222222222222:role/Admin"},
+		}
+		mockSTS := &MockSTSClient{
+			AssumeRoleFunc: func(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+				if *params.RoleArn == orgs["prod"].RoleArn {
+					assert.Equal(t, "vendor-42", aws.ToString(params.ExternalId))
+				} else {
+					assert.Equal(t, "shared-id", aws.ToString(params.ExternalId))
+				}
+				return &sts.AssumeRoleOutput{Credentials: &types.Credentials{}}, nil
+			},
+		}
+
+		conf := &AwsConfig{externalID: "shared-id"}
+		assert.NoError(t, conf.assumeRoles(context.TODO(), mockSTS, nil, orgs))
+	})
+
+	t.Run("Stores nothing if any role fails", func(t *testing.T) {
+		mockSTS := &MockSTSClient{
+			AssumeRoleFunc: func(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+				if *params.RoleArn == orgs["staging"].RoleArn {
+					return nil, fmt.Errorf("access denied")
+				}
+				return &sts.AssumeRoleOutput{Credentials: &types.Credentials{}}, nil
+			},
+		}
+
+		conf := &AwsConfig{}
+		err := conf.assumeRoles(context.TODO(), mockSTS, nil, orgs)
+		assert.ErrorContains(t, err, `org "staging"`)
+		assert.NotErrorIs(t, err, ErrPartialRoles)
+		assert.Nil(t, conf.roleCreds)
+	})
+
+	t.Run("Keeps the assumed roles in soft-fail mode", func(t *testing.T) {
+		mockSTS := &MockSTSClient{
+			AssumeRoleFunc: func(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+				if *params.RoleArn == orgs["staging"].RoleArn {
+					return nil, fmt.Errorf("access denied")
+				}
+				return &sts.AssumeRoleOutput{Credentials: &types.Credentials{AccessKeyId: aws.String("key-prod")}}, nil
+			},
+		}
+
+		conf := &AwsConfig{softFail: true}
+		err := conf.assumeRoles(context.TODO(), mockSTS, nil, orgs)
+		assert.ErrorIs(t, err, ErrPartialRoles)
+		assert.ErrorContains(t, err, `1 of 2 failed`)
+		assert.Equal(t, []string{"prod-mfa"}, slices.Collect(maps.Keys(conf.roleCreds)))
+		assert.Equal(t, map[string]string{"prod": "prod-mfa"}, conf.orgProfiles)
+
+		results := conf.RoleResults()
+		require.Len(t, results, 2)
+		assert.Equal(t, RoleResult{Org: "prod", Profile: "prod-mfa"}, results[0])
+		assert.Equal(t, "staging", results[1].Org)
+		assert.ErrorContains(t, results[1].Err, "access denied")
+	})
+
+	t.Run("Stores nothing in soft-fail mode when every role fails", func(t *testing.T) {
+		mockSTS := &MockSTSClient{
+			AssumeRoleFunc: func(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+				return nil, fmt.Errorf("access denied")
+			},
+		}
+
+		conf := &AwsConfig{softFail: true}
+		err := conf.assumeRoles(context.TODO(), mockSTS, nil, orgs)
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, ErrPartialRoles)
+		assert.Nil(t, conf.roleCreds)
+		assert.Len(t, conf.RoleResults(), 2)
+	})
+
+	t.Run("Fails both orgs of a shared profile in soft-fail mode", func(t *testing.T) {
+		orgs := map[string]appconfig.OrgConfig{
+			"prod":    {RoleArn: "arn:aws:iam::111111111111:role/Admin"},
+			"staging": {RoleArn: "arn:aws:iam::222222222222:role/Admin", Profile: "shared"},
+			"qa":      {RoleArn: "arn:aws:iam::333333333333:role/Admin", Profile: "shared"},
+		}
+		mockSTS := &MockSTSClient{
+			AssumeRoleFunc: func(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+				return &sts.AssumeRoleOutput{Credentials: &types.Credentials{}}, nil
+			},
+		}
+
+		conf := &AwsConfig{softFail: true}
+		err := conf.assumeRoles(context.TODO(), mockSTS, nil, orgs)
+		assert.ErrorIs(t, err, ErrPartialRoles)
+		assert.ErrorContains(t, err, `orgs "qa" and "staging" are written to the same profile "shared"`)
+		assert.Equal(t, []string{"prod-mfa"}, slices.Collect(maps.Keys(conf.roleCreds)))
+	})
+
+	t.Run("Fails every org of a profile shared by three", func(t *testing.T) {
+		orgs := map[string]appconfig.OrgConfig{
+			"prod":    {RoleArn: "arn:aws:iam::111111111111:role/Admin"},
+			"staging": {RoleArn: "arn:aws:iam::222222222222:role/Admin", Profile: "shared"},
+			"qa":      {RoleArn: "arn:aws:iam::333333333333:role/Admin", Profile: "shared"},
+			"dev":     {RoleArn: "arn:aws:iam::444444444444:role/Admin", Profile: "shared"},
+		}
+		mockSTS := &MockSTSClient{
+			AssumeRoleFunc: func(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+				return &sts.AssumeRoleOutput{Credentials: &types.Credentials{}}, nil
+			},
+		}
+
+		conf := &AwsConfig{softFail: true}
+		err := conf.assumeRoles(context.TODO(), mockSTS, nil, orgs)
+		assert.ErrorIs(t, err, ErrPartialRoles)
+		assert.ErrorContains(t, err, `3 of 4 failed: orgs "dev", "qa" and "staging" are written to the same profile "shared"`)
+		assert.Equal(t, []string{"prod-mfa"}, slices.Collect(maps.Keys(conf.roleCreds)))
+
+		var failed []string
+		for _, result := range conf.RoleResults() {
+			if result.Err != nil {
+				failed = append(failed, result.Org)
+			}
+		}
+		assert.Equal(t, []string{"dev", "qa", "staging"}, failed, "every org is listed once")
+	})
+}
+
+func TestCreateUpdatedConfigWithRoles(t *testing.T) {
+	conf := AwsConfig{
+		defaultCreds: aws.Credentials{AccessKeyID: "mockAccessKeyID", SecretAccessKey: "mockSecretAccessKey"},
+		sessionCreds: &sts.GetSessionTokenOutput{
+			Credentials: &types.Credentials{
+				AccessKeyId:     aws.String("mockSessionAccessKeyID"),
+				SecretAccessKey: aws.String("mockSessionSecretAccessKey"),
+				SessionToken:    aws.String("mockSessionToken"),
+			},
+		},
+		roleCreds: map[string]*types.Credentials{
+			"prod-mfa": {
+				AccessKeyId:     aws.String("mockRoleAccessKeyID"),
+				SecretAccessKey: aws.String("mockRoleSecretAccessKey"),
+				SessionToken:    aws.String("mockRoleSessionToken"),
+			},
+		},
+	}
+
+	tempDir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(tempDir+"/.aws", 0755))
+	t.Setenv("HOME", tempDir)
+
+	assert.NoError(t, conf.CreateUpdatedConfig())
+
+	credentialsPath := tempDir + "/.aws/credentials"
+	info, err := os.Stat(credentialsPath)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	inidata, err := ini.Load(credentialsPath)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{ini.DefaultSection, "default", "default-mfa", "prod-mfa"}, inidata.SectionStrings())
+	assert.Equal(t, "mockRoleSessionToken", inidata.Section("prod-mfa").Key("aws_session_token").String())
+}
+
+func TestSetSourceProfile(t *testing.T) {
+	defer resetLogging()
+
+	app := appconfig.AppConfig{
+		Profile: "default-mfa",
+		Orgs:    map[string]appconfig.OrgConfig{"prod": {RoleArn: "arn:aws:iam::111111111111:role/Admin"}},
+	}
+
+	tests := []struct {
+		name           string
+		awsProfile     string
+		sourceProfile  string
+		expectedSource string
+		expectWarning  bool
+	}{
+		{"No AWS_PROFILE", "", "", "default", false},
+		{"Configured source profile", "", "personal", "personal", false},
+		{"AWS_PROFILE is the session profile", "default-mfa", "personal", "personal", true},
+		{"AWS_PROFILE is an org profile", "prod-mfa", "", "default", true},
+		{"AWS_PROFILE is unmanaged", "other", "", "default", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+			t.Setenv("AWS_PROFILE", tt.awsProfile)
+
+			app.SourceProfile = tt.sourceProfile
+			conf := &AwsConfig{}
+			conf.SetSourceProfile(app)
+
+			assert.Equal(t, tt.expectedSource, conf.sourceProfile)
+			assert.Equal(t, tt.expectWarning, strings.Contains(buf.String(), "level=WARN"))
+		})
+	}
+}
+
+func TestCreateUpdatedConfigCustomProfiles(t *testing.T) {
+	conf := AwsConfig{
+		defaultCreds: aws.Credentials{AccessKeyID: "mockAccessKeyID", SecretAccessKey: "mockSecretAccessKey"},
+		sessionCreds: &sts.GetSessionTokenOutput{
+			Credentials: &types.Credentials{
+				AccessKeyId:     aws.String("mockSessionAccessKeyID"),
+				SecretAccessKey: aws.String("mockSessionSecretAccessKey"),
+				SessionToken:    aws.String("mockSessionToken"),
+			},
+		},
+		sourceProfile:  "personal",
+		sessionProfile: "personal-mfa",
+	}
+
+	tempDir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(tempDir+"/.aws", 0755))
+	t.Setenv("HOME", tempDir)
+
+	assert.NoError(t, conf.CreateUpdatedConfig())
+
+	inidata, err := ini.Load(tempDir + "/.aws/credentials")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{ini.DefaultSection, "personal", "personal-mfa"}, inidata.SectionStrings())
+	assert.Equal(t, "mockAccessKeyID", inidata.Section("personal").Key("aws_access_key_id").String())
+	assert.Equal(t, "mockSessionToken", inidata.Section("personal-mfa").Key("aws_session_token").String())
+}
+
+func TestCreateUpdatedConfigExternalSource(t *testing.T) {
+	conf := AwsConfig{
+		sessionCreds: &sts.GetSessionTokenOutput{
+			Credentials: &types.Credentials{
+				AccessKeyId:     aws.String("mockSessionAccessKeyID"),
+				SecretAccessKey: aws.String("mockSessionSecretAccessKey"),
+				SessionToken:    aws.String("mockSessionToken"),
+			},
+		},
+	}
+	assert.False(t, conf.SourceExternal())
+	conf.SetSourceCreds("mockAccessKeyID", "mockSecretAccessKey")
+	assert.True(t, conf.SourceExternal())
+
+	// Nothing to load from the shared config files
+	assert.NoError(t, conf.GetDefaultCreds())
+	assert.Equal(t, "mockAccessKeyID", conf.defaultCreds.AccessKeyID)
+
+	tempDir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(tempDir+"/.aws", 0755))
+	t.Setenv("HOME", tempDir)
+
+	assert.NoError(t, conf.CreateUpdatedConfig())
+
+	inidata, err := ini.Load(tempDir + "/.aws/credentials")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{ini.DefaultSection, "default-mfa"}, inidata.SectionStrings())
+}
+
+func TestCreateUpdatedConfigMissingAwsDir(t *testing.T) {
+	conf := AwsConfig{
+		defaultCreds: aws.Credentials{AccessKeyID: "mockAccessKeyID", SecretAccessKey: "mockSecretAccessKey"},
+		sessionCreds: &sts.GetSessionTokenOutput{
+			Credentials: &types.Credentials{
+				AccessKeyId:     aws.String("mockSessionAccessKeyID"),
+				SecretAccessKey: aws.String("mockSessionSecretAccessKey"),
+				SessionToken:    aws.String("mockSessionToken"),
+			},
+		},
+	}
+
+	tempDir := t.TempDir()
+	t.Setenv("HOME", tempDir)
+
+	assert.NoError(t, conf.CreateUpdatedConfig())
+
+	info, err := os.Stat(tempDir + "/.aws")
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o700), info.Mode().Perm())
+	assert.FileExists(t, tempDir+"/.aws/credentials")
+}
+
+func TestCreateUpdatedConfigIncomplete(t *testing.T) {
+	session := func() *types.Credentials {
+		return &types.Credentials{
+			AccessKeyId:     aws.String("mockSessionAccessKeyID"),
+			SecretAccessKey: aws.String("mockSessionSecretAccessKey"),
+			SessionToken:    aws.String("mockSessionToken"),
+		}
+	}
+	defaultCreds := aws.Credentials{AccessKeyID: "mockAccessKeyID", SecretAccessKey: "mockSecretAccessKey"}
+
+	emptyToken := session()
+	emptyToken.SessionToken = aws.String("")
+	nilSecret := session()
+	nilSecret.SecretAccessKey = nil
+
+	tests := []struct {
+		name    string
+		conf    AwsConfig
+		message string
+	}{
+		{"No session", AwsConfig{defaultCreds: defaultCreds}, "no session credentials available"},
+		{"Nil session credentials", AwsConfig{defaultCreds: defaultCreds, sessionCreds: &sts.GetSessionTokenOutput{}}, "no session credentials available"},
+		{"Empty session token", AwsConfig{defaultCreds: defaultCreds, sessionCreds: &sts.GetSessionTokenOutput{Credentials: emptyToken}}, "profile default-mfa has no session token"},
+		{"Nil secret access key", AwsConfig{defaultCreds: defaultCreds, sessionCreds: &sts.GetSessionTokenOutput{Credentials: nilSecret}}, "profile default-mfa has no secret access key"},
+		{"Empty default credentials", AwsConfig{sessionCreds: &sts.GetSessionTokenOutput{Credentials: session()}}, "default credentials of profile default are empty"},
+		{"Nil role credentials", AwsConfig{
+			defaultCreds: defaultCreds,
+			sessionCreds: &sts.GetSessionTokenOutput{Credentials: session()},
+			roleCreds:    map[string]*types.Credentials{"prod-mfa": nil},
+		}, "no credentials for profile prod-mfa"},
+		{"Empty role credentials", AwsConfig{
+			defaultCreds: defaultCreds,
+			sessionCreds: &sts.GetSessionTokenOutput{Credentials: session()},
+			roleCreds:    map[string]*types.Credentials{"prod-mfa": {}},
+		}, "profile prod-mfa has no access key ID, secret access key, session token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			t.Setenv("HOME", tempDir)
+			credentialsPath := tempDir + "/.aws/credentials"
+			assert.NoError(t, os.MkdirAll(tempDir+"/.aws", 0o700))
+			assert.NoError(t, os.WriteFile(credentialsPath, []byte("[default]\naws_access_key_id = keep-me\n"), 0o600))
+
+			err := tt.conf.CreateUpdatedConfig()
+			assert.ErrorIs(t, err, ErrIncompleteCredentials)
+			assert.ErrorContains(t, err, tt.message)
+
+			data, err := os.ReadFile(credentialsPath)
+			assert.NoError(t, err)
+			assert.Equal(t, "[default]\naws_access_key_id = keep-me\n", string(data), "the credentials file must be left alone")
+		})
+	}
+}
+
+func TestCreateUpdatedConfigLocked(t *testing.T) {
+	conf := writerTestConfig()
+
+	tempDir := t.TempDir()
+	t.Setenv("HOME", tempDir)
+	lockPath := tempDir + "/.aws/credentials.lock"
+	assert.NoError(t, os.MkdirAll(tempDir+"/.aws", 0o700))
+
+	t.Run("Fails while another run holds the lock", func(t *testing.T) {
+		assert.NoError(t, os.WriteFile(lockPath, nil, 0o600))
+		assert.ErrorIs(t, conf.CreateUpdatedConfig(), ErrCredentialsFileLocked)
+		assert.NoFileExists(t, tempDir+"/.aws/credentials")
+	})
+
+	t.Run("Takes over stale locks", func(t *testing.T) {
+		stale := time.Now().Add(-2 * lockStaleAfter)
+		assert.NoError(t, os.Chtimes(lockPath, stale, stale))
+		assert.NoError(t, conf.CreateUpdatedConfig())
+		assert.FileExists(t, tempDir+"/.aws/credentials")
+		assert.NoFileExists(t, lockPath)
+	})
+}
+
+func TestBootstrapCredentials(t *testing.T) {
+	t.Run("Creates the credentials file on first run", func(t *testing.T) {
+		t.Setenv("HOME", t.TempDir())
+		conf := &AwsConfig{}
+
+		configured, err := conf.SourceConfigured()
+		assert.NoError(t, err)
+		assert.False(t, configured)
+
+		assert.NoError(t, conf.BootstrapCredentials("AKIAEXAMPLE", "secretExample"))
+
+		configured, err = conf.SourceConfigured()
+		assert.NoError(t, err)
+		assert.True(t, configured)
+
+		inidata, err := ini.Load(CredentialsPath())
+		assert.NoError(t, err)
+		assert.Equal(t, "AKIAEXAMPLE", inidata.Section("default").Key("aws_access_key_id").String())
+		assert.Equal(t, "secretExample", inidata.Section("default").Key("aws_secret_access_key").String())
+	})
+
+	t.Run("Adds the source profile to an existing file", func(t *testing.T) {
+		tempDir := t.TempDir()
+		t.Setenv("HOME", tempDir)
+		assert.NoError(t, os.MkdirAll(tempDir+"/.aws", 0o700))
+		assert.NoError(t, os.WriteFile(CredentialsPath(), []byte("[other]\naws_access_key_id = AKIAOTHER\n"), 0o600))
+
+		conf := &AwsConfig{sourceProfile: "personal"}
+		configured, err := conf.SourceConfigured()
+		assert.NoError(t, err)
+		assert.False(t, configured)
+
+		assert.NoError(t, conf.BootstrapCredentials("AKIAEXAMPLE", "secretExample"))
+
+		inidata, err := ini.Load(CredentialsPath())
+		assert.NoError(t, err)
+		assert.Equal(t, "AKIAOTHER", inidata.Section("other").Key("aws_access_key_id").String())
+		assert.Equal(t, "AKIAEXAMPLE", inidata.Section("personal").Key("aws_access_key_id").String())
+	})
+
+	t.Run("Replaces the keys and drops a stale session token", func(t *testing.T) {
+		tempDir := t.TempDir()
+		t.Setenv("HOME", tempDir)
+		assert.NoError(t, os.MkdirAll(tempDir+"/.aws", 0o700))
+		assert.NoError(t, os.WriteFile(CredentialsPath(), []byte("[default]\naws_access_key_id = AKIAOLD\naws_secret_access_key = old\naws_session_token = token\nregion = eu-west-1\n"), 0o600))
+
+		conf := &AwsConfig{}
+		assert.NoError(t, conf.BootstrapCredentials("AKIANEW", "new"))
+
+		inidata, err := ini.Load(CredentialsPath())
+		assert.NoError(t, err)
+		section := inidata.Section("default")
+		assert.Equal(t, "AKIANEW", section.Key("aws_access_key_id").String())
+		assert.Equal(t, "new", section.Key("aws_secret_access_key").String())
+		assert.False(t, section.HasKey("aws_session_token"))
+		assert.Equal(t, "eu-west-1", section.Key("region").String())
+	})
+}
+
+func TestGetDefaultCredsFromSourceFile(t *testing.T) {
+	tempDir := t.TempDir()
+	sourceFile := tempDir + "/work-credentials"
+	assert.NoError(t, os.WriteFile(sourceFile, []byte("[work]\naws_access_key_id = AKIAWORK\naws_secret_access_key = workSecret\n"), 0o600))
+
+	conf := &AwsConfig{}
+	conf.SetSourceProfile(appconfig.AppConfig{
+		Org:  "work",
+		Orgs: map[string]appconfig.OrgConfig{"work": {SourceProfile: "work", SourceFile: sourceFile}},
+	})
+	assert.Equal(t, sourceFile, conf.SourceCredentialsPath())
+
+	assert.NoError(t, conf.GetDefaultCreds())
+	assert.Equal(t, "AKIAWORK", conf.defaultCreds.AccessKeyID)
+	assert.Equal(t, "workSecret", conf.defaultCreds.SecretAccessKey)
+
+	configured, err := conf.SourceConfigured()
+	assert.NoError(t, err)
+	assert.True(t, configured)
+
+	// Keys that live in another file are not copied into ~/.aws/credentials
+	conf.sessionCreds = &sts.GetSessionTokenOutput{
+		Credentials: &types.Credentials{
+			AccessKeyId:     aws.String("mockSessionAccessKeyID"),
+			SecretAccessKey: aws.String("mockSessionSecretAccessKey"),
+			SessionToken:    aws.String("mockSessionToken"),
+		},
+	}
+	t.Setenv("HOME", tempDir)
+	assert.NoError(t, conf.CreateUpdatedConfig())
+
+	inidata, err := ini.Load(CredentialsPath())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{ini.DefaultSection, "default-mfa"}, inidata.SectionStrings())
+}
+
+func TestGetDefaultCredsStaleToken(t *testing.T) {
+	sourceFile := filepath.Join(t.TempDir(), "credentials")
+	app := appconfig.AppConfig{Org: "work", Orgs: map[string]appconfig.OrgConfig{"work": {SourceProfile: "work", SourceFile: sourceFile}}}
+	load := func(keys string) *AwsConfig {
+		assert.NoError(t, os.WriteFile(sourceFile, []byte("[work]\n"+keys), 0o600))
+		conf := &AwsConfig{}
+		conf.SetSourceProfile(app)
+		assert.NoError(t, conf.GetDefaultCreds())
+		return conf
+	}
+
+	conf := load("aws_access_key_id = AKIAWORK\naws_secret_access_key = secret\naws_session_token = leftover\n")
+	assert.Empty(t, conf.defaultCreds.SessionToken)
+	cfg, err := conf.sourceAccount()
+	assert.NoError(t, err)
+	creds, err := cfg.Credentials.Retrieve(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "AKIAWORK", creds.AccessKeyID)
+	assert.Empty(t, creds.SessionToken, "STS is called without the stale token")
+
+	conf = load("aws_access_key_id = ASIATEMP\naws_secret_access_key = secret\naws_session_token = current\n")
+	assert.Equal(t, "current", conf.defaultCreds.SessionToken, "temporary credentials keep their token")
+}
+
+func TestSourceAccountLoadedOnce(t *testing.T) {
+	sourceFile := filepath.Join(t.TempDir(), "credentials")
+	writeKeys := func(key string) {
+		assert.NoError(t, os.WriteFile(sourceFile, []byte("[work]\naws_access_key_id = "+key+"\naws_secret_access_key = secret\n"), 0o600))
+	}
+	writeKeys("AKIAFIRST")
+	app := appconfig.AppConfig{Org: "work", Orgs: map[string]appconfig.OrgConfig{"work": {SourceProfile: "work", SourceFile: sourceFile}}}
+
+	conf := &AwsConfig{}
+	conf.SetSourceProfile(app)
+	assert.NoError(t, conf.GetDefaultCreds())
+	assert.Equal(t, "AKIAFIRST", conf.defaultCreds.AccessKeyID)
+
+	writeKeys("AKIASECOND")
+	conf.sessionCreds = &sts.GetSessionTokenOutput{Credentials: &types.Credentials{AccessKeyId: aws.String("ASIASESSION")}}
+	session, err := conf.sessionAccount()
+	assert.NoError(t, err)
+	creds, err := session.Credentials.Retrieve(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "ASIASESSION", creds.AccessKeyID)
+
+	assert.NoError(t, conf.GetDefaultCreds())
+	assert.Equal(t, "AKIAFIRST", conf.defaultCreds.AccessKeyID, "the config is not loaded again, nor changed by sessionAccount")
+
+	conf.region = "eu-west-1"
+	cfg, err := conf.sourceAccount()
+	assert.NoError(t, err)
+	assert.Equal(t, "eu-west-1", cfg.Region)
+
+	conf.SetSourceProfile(app)
+	assert.NoError(t, conf.GetDefaultCreds())
+	assert.Equal(t, "AKIASECOND", conf.defaultCreds.AccessKeyID, "selecting the source again loads it again")
+}
+
+func TestLoadOptions(t *testing.T) {
+	loaded := func(conf *AwsConfig) config.LoadOptions {
+		opts, err := conf.loadOptions()
+		assert.NoError(t, err)
+		var loaded config.LoadOptions
+		for _, opt := range opts {
+			assert.NoError(t, opt(&loaded))
+		}
+		return loaded
+	}
+
+	assert.Equal(t, imds.ClientDefaultEnableState, loaded(&AwsConfig{}).EC2IMDSClientEnableState)
+	assert.Equal(t, imds.ClientDisabled, loaded(&AwsConfig{skipIMDS: true}).EC2IMDSClientEnableState)
+
+	conf := &AwsConfig{}
+	conf.SetSourceProfile(appconfig.AppConfig{SkipIMDS: true})
+	assert.True(t, conf.skipIMDS)
+}
+
+func TestSetCredentialsPath(t *testing.T) {
+	t.Setenv("HOME", "/home/test")
+	conf := &AwsConfig{}
+	assert.Equal(t, "/home/test/.aws/credentials", CredentialsPath())
+
+	SetCredentialsPath("/home/test/.aws/gredentures")
+	defer SetCredentialsPath("")
+	assert.Equal(t, "/home/test/.aws/gredentures", CredentialsPath())
+	assert.Equal(t, "/home/test/.aws/credentials", conf.SourceCredentialsPath())
+}
+
+func TestSetAWSConfigPath(t *testing.T) {
+	t.Setenv("HOME", "/home/test")
+	t.Setenv("AWS_CONFIG_FILE", "")
+	assert.Equal(t, "/home/test/.aws/config", AWSConfigPath())
+
+	t.Setenv("AWS_CONFIG_FILE", "/home/test/.config/aws/config")
+	assert.Equal(t, "/home/test/.config/aws/config", AWSConfigPath())
+
+	SetAWSConfigPath("/home/test/aws/config")
+	defer SetAWSConfigPath("")
+	assert.Equal(t, "/home/test/aws/config", AWSConfigPath(), "--aws-config-file overrides AWS_CONFIG_FILE")
+
+	t.Run("Loads the source profile from it", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "config")
+		require.NoError(t, os.WriteFile(path, []byte("[profile work]\nmfa_serial = arn:aws:iam::123456789012:mfa/work\n"), 0o600))
+		t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "other"))
+		t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+		SetAWSConfigPath(path)
+
+		cfg, err := GetAccount("work")
+		require.NoError(t, err)
+		var shared []config.SharedConfig
+		for _, source := range cfg.ConfigSources {
+			if s, ok := source.(config.SharedConfig); ok {
+				shared = append(shared, s)
+			}
+		}
+		require.Len(t, shared, 1)
+		assert.Equal(t, "arn:aws:iam::123456789012:mfa/work", shared[0].MFASerial)
+	})
+
+	t.Run("Loads the settings of externally sourced keys from it", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "config")
+		require.NoError(t, os.WriteFile(path, []byte("[default]\nregion = eu-central-1\n"), 0o600))
+		t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "other"))
+		t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+		t.Setenv("AWS_PROFILE", "")
+		SetAWSConfigPath(path)
+
+		conf := &AwsConfig{externalSource: true, defaultCreds: aws.Credentials{AccessKeyID: "AKIAEXAMPLE", SecretAccessKey: "secret"}}
+		cfg, err := conf.loadSourceAccount()
+		require.NoError(t, err)
+		var shared []config.SharedConfig
+		for _, source := range cfg.ConfigSources {
+			if s, ok := source.(config.SharedConfig); ok {
+				shared = append(shared, s)
+			}
+		}
+		require.Len(t, shared, 1)
+		assert.Equal(t, "eu-central-1", shared[0].Region)
+	})
+
+	t.Run("Passes it on to exec", func(t *testing.T) {
+		SetAWSConfigPath("/home/test/aws/config")
+		conf := AwsConfig{sessionCreds: &sts.GetSessionTokenOutput{Credentials: &types.Credentials{}}}
+		env, err := conf.SessionEnv(nil)
+		require.NoError(t, err)
+		assert.Contains(t, env, "AWS_CONFIG_FILE=/home/test/aws/config")
+	})
+}
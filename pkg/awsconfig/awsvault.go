@@ -0,0 +1,199 @@
+package awsconfig
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"sort"
+	"strings"
+
+	"gredentures/pkg/appconfig"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"gopkg.in/ini.v1"
+)
+
+// aws-vault keeps long-lived keys with the keyring library of 99designs: on macOS in a
+// keychain of its own, on Linux in a Secret Service collection of its own.
+const (
+	awsVaultService    = "aws-vault"
+	awsVaultKeychain   = "aws-vault.keychain"
+	awsVaultCollection = "/org/freedesktop/secrets/collection/awsvault"
+)
+
+// AWSVault reads and writes long-lived keys in the storage of aws-vault, for users moving
+// between it and gredentures. Only profiles holding an access key pair are touched; the
+// sessions aws-vault caches in the same storage are skipped. Secrets are passed on stdin, or
+// read from stdout, so they never appear in argv.
+type AWSVault struct {
+	OS  string                                                // Operating system, runtime.GOOS unless replaced in tests.
+	Run func(stdin string, command ...string) (string, error) // Runs a keychain command and returns its output.
+}
+
+// NewAWSVault returns an AWSVault using the security tool on macOS and secret-tool on Linux.
+func NewAWSVault() *AWSVault {
+	return &AWSVault{OS: runtime.GOOS, Run: outputWithStdin}
+}
+
+// awsVaultItem is an item as the keyring library stores it in the Secret Service, with the
+// credentials JSON in Data. The macOS keychain holds the credentials JSON directly.
+type awsVaultItem struct {
+	Key   string
+	Data  []byte
+	Label string
+}
+
+// ReadKeys returns the access key pair aws-vault stores for profile.
+func (v *AWSVault) ReadKeys(profile string) (Profile, error) {
+	var out string
+	var err error
+	switch v.OS {
+	case "darwin":
+		out, err = v.Run("", "security", "find-generic-password", "-s", awsVaultService, "-a", profile, "-w", awsVaultKeychain)
+	case "linux":
+		out, err = v.Run("", "secret-tool", "lookup", "profile", profile)
+	default:
+		return Profile{}, fmt.Errorf("aws-vault storage is not supported on %s", v.OS)
+	}
+	if err != nil {
+		return Profile{}, fmt.Errorf("failed to read profile %s from aws-vault: %w", profile, err)
+	}
+	if strings.TrimSpace(out) == "" {
+		return Profile{}, fmt.Errorf("aws-vault holds no keys for profile %s", profile)
+	}
+
+	creds, err := parseAWSVaultItem([]byte(strings.TrimSpace(out)))
+	if err != nil {
+		return Profile{}, fmt.Errorf("profile %s in aws-vault: %w", profile, err)
+	}
+	return Profile{Name: profile, Credentials: creds}, nil
+}
+
+// parseAWSVaultItem returns the access key pair of a stored item, unwrapping the keyring item
+// of the Secret Service first. Items holding a session token are sessions aws-vault cached,
+// not keys to move.
+func parseAWSVaultItem(data []byte) (aws.Credentials, error) {
+	var item awsVaultItem
+	if err := json.Unmarshal(data, &item); err == nil && len(item.Data) > 0 {
+		data = item.Data
+	}
+	// aws-vault 7 stores aws.Credentials, older releases the same fields of the SDK v1
+	var creds aws.Credentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return aws.Credentials{}, fmt.Errorf("unreadable item: %w", err)
+	}
+	switch {
+	case creds.AccessKeyID == "" || creds.SecretAccessKey == "":
+		return aws.Credentials{}, fmt.Errorf("the item holds no access key pair")
+	case creds.SessionToken != "":
+		return aws.Credentials{}, fmt.Errorf("the item holds a cached session, not long-lived keys")
+	}
+	return aws.Credentials{AccessKeyID: creds.AccessKeyID, SecretAccessKey: creds.SecretAccessKey, Source: awsVaultService}, nil
+}
+
+// WriteKeys stores the access key pair of profile in aws-vault under profile.Name, replacing
+// any keys it held for it. On Linux, the awsvault collection must already exist, which it
+// does once aws-vault has stored any profile.
+func (v *AWSVault) WriteKeys(profile Profile) error {
+	if profile.Credentials.AccessKeyID == "" || profile.Credentials.SecretAccessKey == "" {
+		return fmt.Errorf("%w: profile %s holds no access key pair", ErrIncompleteCredentials, profile.Name)
+	}
+	creds, err := json.Marshal(aws.Credentials{
+		AccessKeyID:     profile.Credentials.AccessKeyID,
+		SecretAccessKey: profile.Credentials.SecretAccessKey,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal credentials: %w", err)
+	}
+
+	slog.Debug("Storing keys in aws-vault", "profile", profile.Name)
+	label := fmt.Sprintf("%s (%s)", awsVaultService, profile.Name)
+	switch v.OS {
+	case "darwin":
+		command, quoteErr := addGenericPassword(awsVaultService, profile.Name, label, string(creds), awsVaultKeychain)
+		if quoteErr != nil {
+			return fmt.Errorf("profile %q cannot be stored in the keychain: %w", profile.Name, quoteErr)
+		}
+		_, err = v.Run(command, "security", "-i")
+	case "linux":
+		var item []byte
+		item, err = json.Marshal(awsVaultItem{Key: profile.Name, Data: creds, Label: label})
+		if err != nil {
+			return fmt.Errorf("failed to marshal keyring item: %w", err)
+		}
+		_, err = v.Run(string(item), "secret-tool", "store", "--collection", awsVaultCollection, "--label", label,
+			"profile", profile.Name)
+	default:
+		return fmt.Errorf("aws-vault storage is not supported on %s", v.OS)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store profile %s in aws-vault: %w", profile.Name, err)
+	}
+	return nil
+}
+
+// ConfigProfile holds the settings of a ~/.aws/config profile that gredentures has options for.
+type ConfigProfile struct {
+	MFASerial string // MFA device of the profile, gredentures' Device.
+	Region    string // Region of the profile.
+}
+
+// ReadConfigProfile returns the settings of profile in the shared config file at path, empty
+// when the file or the profile does not exist.
+func ReadConfigProfile(path, profile string) (ConfigProfile, error) {
+	file, err := ini.LooseLoad(path)
+	if err != nil {
+		return ConfigProfile{}, fmt.Errorf("failed to load %s: %w", path, err)
+	}
+	name := "profile " + profile
+	if profile == defaultSourceProfile {
+		name = profile
+	}
+	section, err := file.GetSection(name)
+	if err != nil {
+		return ConfigProfile{}, nil
+	}
+	return ConfigProfile{MFASerial: section.Key("mfa_serial").String(), Region: section.Key("region").String()}, nil
+}
+
+// AWSVaultConfig returns ~/.aws/config stanzas letting aws-vault log in like gredentures
+// does: profile holds the keys and the MFA device, and every org with a role becomes a
+// profile assuming it from there. Stanzas are only printed, profiles already in the file are
+// better merged by hand.
+func AWSVaultConfig(app appconfig.AppConfig, profile string) string {
+	var buf strings.Builder
+	buf.WriteString("# Generated by gredentures aws-vault export, paste into ~/.aws/config.\n")
+
+	var settings [][2]string
+	if app.Device != "" && !app.NoMFA {
+		settings = append(settings, [2]string{"mfa_serial", app.Device})
+	}
+	writeStanza(&buf, stanza{profile, "Long-lived keys stored in aws-vault", settings})
+
+	names := make([]string, 0, len(app.Orgs))
+	for name := range app.Orgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		org := app.Orgs[name]
+		if org.RoleArn == "" {
+			continue
+		}
+		if org.PartitionName() != app.Partition() {
+			fmt.Fprintf(&buf, "\n# Org %s is in partition %s and needs long-lived keys of its own, it is left out.\n", name, org.PartitionName())
+			continue
+		}
+		settings := [][2]string{{"source_profile", profile}, {"role_arn", org.RoleArn}}
+		if org.Timeout > 0 {
+			settings = append(settings, [2]string{"duration_seconds", fmt.Sprint(org.Timeout)})
+		}
+		if externalID := cmp.Or(org.ExternalID, app.ExternalID); externalID != "" {
+			settings = append(settings, [2]string{"external_id", externalID})
+		}
+		writeStanza(&buf, stanza{name, "Org " + name, settings})
+	}
+	return buf.String()
+}
@@ -0,0 +1,207 @@
+package awsconfig
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gredentures/pkg/appconfig"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeAWSVault returns an AWSVault for goos answering every command with output and err,
+// recording the commands run and the stdin they were given.
+func fakeAWSVault(goos, output string, err error) (*AWSVault, *[][]string, *[]string) {
+	var commands [][]string
+	var stdins []string
+	return &AWSVault{OS: goos, Run: func(stdin string, command ...string) (string, error) {
+		commands = append(commands, command)
+		stdins = append(stdins, stdin)
+		return output, err
+	}}, &commands, &stdins
+}
+
+func TestReadAWSVaultKeys(t *testing.T) {
+	keys := `{"AccessKeyID":"AKIAVAULT","SecretAccessKey":"vaultSecret","SessionToken":"","Source":"","CanExpire":false,"Expires":"0001-01-01T00:00:00Z"}`
+
+	t.Run("Reads the keychain on macOS", func(t *testing.T) {
+		vault, commands, _ := fakeAWSVault("darwin", keys+"\n", nil)
+		profile, err := vault.ReadKeys("work")
+		assert.NoError(t, err)
+		assert.Equal(t, "work", profile.Name)
+		assert.Equal(t, "AKIAVAULT", profile.Credentials.AccessKeyID)
+		assert.Equal(t, "vaultSecret", profile.Credentials.SecretAccessKey)
+		assert.Equal(t, [][]string{{"security", "find-generic-password", "-s", "aws-vault", "-a", "work", "-w", "aws-vault.keychain"}}, *commands)
+	})
+
+	t.Run("Unwraps the keyring item on Linux", func(t *testing.T) {
+		item := `{"Key":"work","Data":"` + base64.StdEncoding.EncodeToString([]byte(keys)) + `","Label":"aws-vault (work)"}`
+		vault, commands, _ := fakeAWSVault("linux", item, nil)
+		profile, err := vault.ReadKeys("work")
+		assert.NoError(t, err)
+		assert.Equal(t, "AKIAVAULT", profile.Credentials.AccessKeyID)
+		assert.Equal(t, [][]string{{"secret-tool", "lookup", "profile", "work"}}, *commands)
+	})
+
+	tests := []struct {
+		name     string
+		goos     string
+		output   string
+		err      error
+		expected string
+	}{
+		{name: "Missing profile", goos: "linux", output: "", expected: "aws-vault holds no keys for profile work"},
+		{name: "Command failure", goos: "darwin", err: errors.New("security failed"), expected: "failed to read profile work from aws-vault: security failed"},
+		{name: "Cached session", goos: "darwin", output: `{"AccessKeyID":"ASIAVAULT","SecretAccessKey":"s","SessionToken":"t"}`, expected: "holds a cached session"},
+		{name: "No key pair", goos: "darwin", output: `{"AccessKeyID":"AKIAVAULT"}`, expected: "holds no access key pair"},
+		{name: "Garbage", goos: "darwin", output: "not json", expected: "unreadable item"},
+		{name: "Unsupported system", goos: "windows", expected: "not supported on windows"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vault, _, _ := fakeAWSVault(tt.goos, tt.output, tt.err)
+			_, err := vault.ReadKeys("work")
+			assert.ErrorContains(t, err, tt.expected)
+		})
+	}
+}
+
+func TestWriteAWSVaultKeys(t *testing.T) {
+	profile := Profile{Name: "work"}
+	profile.Credentials.AccessKeyID = "AKIAWORK"
+	profile.Credentials.SecretAccessKey = "workSecret"
+
+	t.Run("Adds the password to the keychain on macOS", func(t *testing.T) {
+		vault, commands, stdins := fakeAWSVault("darwin", "", nil)
+		assert.NoError(t, vault.WriteKeys(profile))
+		assert.Equal(t, [][]string{{"security", "-i"}}, *commands)
+		assert.Equal(t, `add-generic-password -U -s 'aws-vault' -a 'work' -l 'aws-vault (work)' -w '{"AccessKeyID":"AKIAWORK","SecretAccessKey":"workSecret","SessionToken":"","Source":"","CanExpire":false,"Expires":"0001-01-01T00:00:00Z","AccountID":""}' 'aws-vault.keychain'`+"\n", (*stdins)[0])
+	})
+
+	t.Run("Stores a keyring item on Linux", func(t *testing.T) {
+		vault, commands, stdins := fakeAWSVault("linux", "", nil)
+		assert.NoError(t, vault.WriteKeys(profile))
+		assert.Equal(t, [][]string{{"secret-tool", "store", "--collection", awsVaultCollection, "--label", "aws-vault (work)", "profile", "work"}}, *commands)
+
+		creds, err := parseAWSVaultItem([]byte((*stdins)[0]))
+		assert.NoError(t, err)
+		assert.Equal(t, "AKIAWORK", creds.AccessKeyID)
+		assert.Equal(t, "workSecret", creds.SecretAccessKey)
+	})
+
+	t.Run("Keeps secrets out of argv", func(t *testing.T) {
+		for _, goos := range []string{"darwin", "linux"} {
+			vault, commands, _ := fakeAWSVault(goos, "", nil)
+			assert.NoError(t, vault.WriteKeys(profile))
+			assert.NotContains(t, strings.Join((*commands)[0], " "), "workSecret")
+		}
+	})
+
+	t.Run("Refuses quotes in keychain profiles", func(t *testing.T) {
+		vault, commands, _ := fakeAWSVault("darwin", "", nil)
+		quoted := profile
+		quoted.Name = "it's"
+		assert.ErrorContains(t, vault.WriteKeys(quoted), "cannot be stored in the keychain")
+		assert.Empty(t, *commands)
+	})
+
+	t.Run("Refuses incomplete keys", func(t *testing.T) {
+		vault, _, _ := fakeAWSVault("linux", "", nil)
+		assert.ErrorIs(t, vault.WriteKeys(Profile{Name: "work"}), ErrIncompleteCredentials)
+	})
+
+	t.Run("Reports command failures", func(t *testing.T) {
+		vault, _, _ := fakeAWSVault("linux", "", errors.New("secret-tool failed: no such collection"))
+		assert.ErrorContains(t, vault.WriteKeys(profile), "failed to store profile work in aws-vault: secret-tool failed: no such collection")
+	})
+}
+
+func TestReadConfigProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	assert.NoError(t, os.WriteFile(path, []byte(`[default]
+mfa_serial = arn:aws:iam::123456789012:mfa/default
+[profile work]
+mfa_serial = arn:aws:iam::123456789012:mfa/alice
+region = eu-west-1
+`), 0o600))
+
+	settings, err := ReadConfigProfile(path, "work")
+	assert.NoError(t, err)
+	assert.Equal(t, ConfigProfile{MFASerial: "arn:aws:iam::123456789012:mfa/alice", Region: "eu-west-1"}, settings)
+
+	settings, err = ReadConfigProfile(path, "default")
+	assert.NoError(t, err)
+	assert.Equal(t, "arn:aws:iam::123456789012:mfa/default", settings.MFASerial)
+
+	settings, err = ReadConfigProfile(path, "missing")
+	assert.NoError(t, err)
+	assert.Empty(t, settings)
+
+	settings, err = ReadConfigProfile(filepath.Join(t.TempDir(), "none"), "work")
+	assert.NoError(t, err)
+	assert.Empty(t, settings)
+}
+
+func TestAWSVaultConfig(t *testing.T) {
+	app := appconfig.AppConfig{
+		Device:     "arn:aws:iam::123456789012:mfa/alice",
+		ExternalID: "default-id",
+		Orgs: map[string]appconfig.OrgConfig{
+			"prod":    {RoleArn: "arn:aws:iam::111111111111:role/Admin", Timeout: 3600, ExternalID: "vendor-42"},
+			"staging": {RoleArn: "arn:aws:iam::222222222222:role/Admin"},
+			"keys":    {SourceProfile: "other"},
+			"gov":     {RoleArn: "arn:aws-us-gov:iam::333333333333:role/Admin"},
+		},
+	}
+
+	assert.Equal(t, `# Generated by gredentures aws-vault export, paste into ~/.aws/config.
+
+# Long-lived keys stored in aws-vault
+[profile work]
+mfa_serial = arn:aws:iam::123456789012:mfa/alice
+
+# Org gov is in partition aws-us-gov and needs long-lived keys of its own, it is left out.
+
+# Org prod
+[profile prod]
+source_profile = work
+role_arn = arn:aws:iam::111111111111:role/Admin
+duration_seconds = 3600
+external_id = vendor-42
+
+# Org staging
+[profile staging]
+source_profile = work
+role_arn = arn:aws:iam::222222222222:role/Admin
+external_id = default-id
+`, AWSVaultConfig(app, "work"))
+
+	t.Run("Leaves out the device without MFA", func(t *testing.T) {
+		noMFA := appconfig.AppConfig{Device: app.Device, NoMFA: true}
+		assert.NotContains(t, AWSVaultConfig(noMFA, "work"), "mfa_serial")
+	})
+}
+
+func TestSourceKeys(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	conf := &AwsConfig{sourceProfile: "work"}
+	_, err := conf.SourceKeys()
+	assert.Error(t, err)
+
+	assert.NoError(t, conf.BootstrapCredentials("AKIAWORK", "workSecret"))
+	profile, err := conf.SourceKeys()
+	assert.NoError(t, err)
+	assert.Equal(t, "work", profile.Name)
+	assert.Equal(t, "AKIAWORK", profile.Credentials.AccessKeyID)
+	assert.Equal(t, "workSecret", profile.Credentials.SecretAccessKey)
+
+	t.Run("Profiles without a key pair", func(t *testing.T) {
+		assert.NoError(t, os.WriteFile(CredentialsPath(), []byte("[work]\nregion = eu-west-1\n"), 0o600))
+		_, err := conf.SourceKeys()
+		assert.ErrorIs(t, err, ErrIncompleteCredentials)
+	})
+}
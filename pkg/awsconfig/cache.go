@@ -0,0 +1,155 @@
+package awsconfig
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"gredentures/pkg/appconfig"
+	"gredentures/pkg/interrupt"
+	"gredentures/pkg/secret"
+
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// sessionExpiryWindow is how long before expiry a cached session is requested anew, so
+// callers never receive a session that lapses mid-request.
+const sessionExpiryWindow = time.Minute
+
+// SessionKey identifies the MFA sessions a SessionCache treats as interchangeable.
+type SessionKey struct {
+	SourceProfile string        // Profile holding the long-lived credentials.
+	Device        string        // MFA device, empty for sessions without MFA.
+	Duration      time.Duration // Requested lifetime of the session.
+}
+
+// CachedSession is a session held by a SessionCache.
+type CachedSession struct {
+	Output  *sts.GetSessionTokenOutput // Session credentials as returned by STS.
+	Profile string                     // Evaluated name of the session profile.
+}
+
+// SessionCache holds MFA sessions in memory for programs using gredentures as a library. It is
+// safe for concurrent use: goroutines asking for the same session while it is being requested
+// wait for that one request instead of each prompting for an MFA code and calling STS. Failed
+// requests are not cached, the next caller tries again. The zero value is ready to use.
+type SessionCache struct {
+	mu       sync.Mutex
+	sessions map[SessionKey]CachedSession
+	inflight map[SessionKey]*sessionCall
+	now      func() time.Time // Replaced in tests.
+}
+
+// sessionCall is a session request other goroutines wait for.
+type sessionCall struct {
+	done    chan struct{} // Closed once session and err are set.
+	session CachedSession
+	err     error
+}
+
+// Get returns the session cached for key, or requests it with fetch when none is cached or
+// it expires within a minute. Only one fetch runs per key at a time; concurrent callers share
+// its result. A caller whose ctx ends while waiting returns ctx.Err(), the fetch carries on.
+func (c *SessionCache) Get(ctx context.Context, key SessionKey, fetch func() (CachedSession, error)) (CachedSession, error) {
+	c.mu.Lock()
+	if session, ok := c.sessions[key]; ok && c.valid(session) {
+		c.mu.Unlock()
+		slog.Debug("Using cached session", "source_profile", key.SourceProfile, "device", key.Device)
+		return session, nil
+	}
+	if call, ok := c.inflight[key]; ok {
+		c.mu.Unlock()
+		slog.Debug("Waiting for the session already being requested", "source_profile", key.SourceProfile, "device", key.Device)
+		select {
+		case <-call.done:
+			return call.session, call.err
+		case <-ctx.Done():
+			return CachedSession{}, ctx.Err()
+		}
+	}
+
+	call := &sessionCall{done: make(chan struct{})}
+	if c.inflight == nil {
+		c.inflight = map[SessionKey]*sessionCall{}
+	}
+	c.inflight[key] = call
+	c.mu.Unlock()
+
+	// Waiters are released even when fetch panics, with an error instead of an empty session
+	call.err = errors.New("the session request panicked")
+	defer func() {
+		c.mu.Lock()
+		delete(c.inflight, key)
+		if call.err == nil {
+			if c.sessions == nil {
+				c.sessions = map[SessionKey]CachedSession{}
+			}
+			c.sessions[key] = call.session
+		}
+		c.mu.Unlock()
+		close(call.done)
+	}()
+	call.session, call.err = fetch()
+	return call.session, call.err
+}
+
+// Invalidate drops the session cached for key, e.g. after AWS rejected it.
+func (c *SessionCache) Invalidate(key SessionKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.sessions, key)
+}
+
+// valid reports whether session is usable for longer than sessionExpiryWindow. Sessions
+// without an expiry never expire.
+func (c *SessionCache) valid(session CachedSession) bool {
+	if session.Output == nil || session.Output.Credentials == nil {
+		return false
+	}
+	expires := session.Output.Credentials.Expiration
+	if expires == nil {
+		return true
+	}
+	now := time.Now
+	if c.now != nil {
+		now = c.now
+	}
+	return expires.After(now().Add(sessionExpiryWindow))
+}
+
+// SessionKey returns the key of the session appconfig asks for, given the source profile
+// selected with SetSourceProfile.
+func (conf *AwsConfig) SessionKey(appconfig appconfig.AppConfig) SessionKey {
+	key := SessionKey{SourceProfile: conf.SourceProfileName(), Duration: time.Duration(appconfig.Timeout) * time.Second}
+	if !appconfig.NoMFA {
+		key.Device = appconfig.Device
+	}
+	return key
+}
+
+// CachedSessionCreds is GetSessionCreds taking the session from cache when one for the same
+// source profile, device and duration is held or being requested. token, when not nil, is
+// only called for the MFA code once a request is made, so a prompt behind it is shown once
+// however many goroutines ask for the session. The cache may be shared, conf may not.
+func (conf *AwsConfig) CachedSessionCreds(cache *SessionCache, appconfig appconfig.AppConfig, token func() (secret.Value, error)) error {
+	session, err := cache.Get(interrupt.Context(), conf.SessionKey(appconfig), func() (CachedSession, error) {
+		if token != nil && !appconfig.NoMFA {
+			code, err := token()
+			if err != nil {
+				return CachedSession{}, err
+			}
+			appconfig.Token = code
+		}
+		if err := conf.GetSessionCreds(appconfig); err != nil {
+			return CachedSession{}, err
+		}
+		return CachedSession{Output: conf.sessionCreds, Profile: conf.sessionProfile}, nil
+	})
+	if err != nil {
+		return err
+	}
+	conf.sessionCreds, conf.sessionProfile = session.Output, session.Profile
+	return nil
+}
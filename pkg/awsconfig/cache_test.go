@@ -0,0 +1,193 @@
+package awsconfig
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gredentures/pkg/appconfig"
+	"gredentures/pkg/secret"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// cachedSession returns a session expiring at expires.
+func cachedSession(accessKey string, expires time.Time) CachedSession {
+	return CachedSession{
+		Output:  &sts.GetSessionTokenOutput{Credentials: &types.Credentials{AccessKeyId: aws.String(accessKey), Expiration: aws.Time(expires)}},
+		Profile: "default-mfa",
+	}
+}
+
+func TestSessionCacheSingleflight(t *testing.T) {
+	now := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	cache := &SessionCache{now: func() time.Time { return now }}
+	key := SessionKey{SourceProfile: "default", Device: "arn:aws:iam::123456789012:mfa/alice", Duration: time.Hour}
+
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	fetch := func() (CachedSession, error) {
+		fetches.Add(1)
+		<-release
+		return cachedSession("ASIASESSION", now.Add(time.Hour)), nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([]CachedSession, 20)
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			session, err := cache.Get(context.Background(), key, fetch)
+			assert.NoError(t, err)
+			results[i] = session
+		}()
+	}
+	// Let the goroutines pile up behind the first fetch before it returns
+	assert.Eventually(t, func() bool { return fetches.Load() == 1 }, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), fetches.Load())
+	for _, session := range results {
+		assert.Equal(t, "ASIASESSION", aws.ToString(session.Output.Credentials.AccessKeyId))
+	}
+
+	t.Run("Serves the cached session", func(t *testing.T) {
+		_, err := cache.Get(context.Background(), key, fetch)
+		assert.NoError(t, err)
+		assert.Equal(t, int32(1), fetches.Load())
+	})
+
+	t.Run("Other keys get their own session", func(t *testing.T) {
+		other := key
+		other.Duration = 12 * time.Hour
+		_, err := cache.Get(context.Background(), other, fetch)
+		assert.NoError(t, err)
+		assert.Equal(t, int32(2), fetches.Load())
+	})
+
+	t.Run("Requests sessions about to expire again", func(t *testing.T) {
+		now = now.Add(time.Hour - sessionExpiryWindow)
+		_, err := cache.Get(context.Background(), key, fetch)
+		assert.NoError(t, err)
+		assert.Equal(t, int32(3), fetches.Load())
+	})
+
+	t.Run("Invalidate drops the session", func(t *testing.T) {
+		cache.Invalidate(key)
+		_, err := cache.Get(context.Background(), key, fetch)
+		assert.NoError(t, err)
+		assert.Equal(t, int32(4), fetches.Load())
+	})
+}
+
+func TestSessionCacheErrors(t *testing.T) {
+	cache := &SessionCache{}
+	key := SessionKey{SourceProfile: "default"}
+
+	t.Run("Failed requests are not cached", func(t *testing.T) {
+		denied := errors.New("AccessDenied")
+		_, err := cache.Get(context.Background(), key, func() (CachedSession, error) { return CachedSession{}, denied })
+		assert.ErrorIs(t, err, denied)
+
+		session, err := cache.Get(context.Background(), key, func() (CachedSession, error) {
+			return cachedSession("ASIARETRY", time.Now().Add(time.Hour)), nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "ASIARETRY", aws.ToString(session.Output.Credentials.AccessKeyId))
+		cache.Invalidate(key)
+	})
+
+	t.Run("Waiters give up with their context", func(t *testing.T) {
+		release := make(chan struct{})
+		started := make(chan struct{})
+		finished := make(chan struct{})
+		go func() {
+			defer close(finished)
+			cache.Get(context.Background(), key, func() (CachedSession, error) {
+				close(started)
+				<-release
+				return cachedSession("ASIASLOW", time.Now().Add(time.Hour)), nil
+			})
+		}()
+		<-started
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := cache.Get(ctx, key, func() (CachedSession, error) {
+			t.Error("a second request was made")
+			return CachedSession{}, nil
+		})
+		assert.ErrorIs(t, err, context.Canceled)
+		close(release)
+		<-finished
+		cache.Invalidate(key)
+	})
+
+	t.Run("A panicking request releases its waiters", func(t *testing.T) {
+		started := make(chan struct{})
+		release := make(chan struct{})
+		go func() {
+			defer func() { recover() }()
+			cache.Get(context.Background(), key, func() (CachedSession, error) {
+				close(started)
+				<-release
+				panic("boom")
+			})
+		}()
+		<-started
+
+		waited := make(chan error)
+		go func() {
+			_, err := cache.Get(context.Background(), key, func() (CachedSession, error) {
+				return cachedSession("ASIAAFTER", time.Now().Add(time.Hour)), nil
+			})
+			waited <- err
+		}()
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+		// The waiter either saw the panic or, arriving late, made its own request
+		if err := <-waited; err != nil {
+			assert.ErrorContains(t, err, "panicked")
+		}
+	})
+}
+
+func TestCachedSessionCreds(t *testing.T) {
+	app := appconfig.AppConfig{Device: "arn:aws:iam::123456789012:mfa/alice", Timeout: 3600, Profile: "default-mfa"}
+	conf := &AwsConfig{sourceProfile: "work"}
+	assert.Equal(t, SessionKey{SourceProfile: "work", Device: app.Device, Duration: time.Hour}, conf.SessionKey(app))
+
+	noMFA := app
+	noMFA.NoMFA = true
+	assert.Empty(t, conf.SessionKey(noMFA).Device)
+
+	cache := &SessionCache{}
+	cached := cachedSession("ASIACACHED", time.Now().Add(time.Hour))
+	_, err := cache.Get(context.Background(), conf.SessionKey(app), func() (CachedSession, error) { return cached, nil })
+	assert.NoError(t, err)
+
+	token := func() (secret.Value, error) {
+		t.Error("the MFA code was asked for although a session is cached")
+		return "", nil
+	}
+	assert.NoError(t, conf.CachedSessionCreds(cache, app, token))
+	assert.Same(t, cached.Output, conf.sessionCreds)
+	assert.Equal(t, "default-mfa", conf.sessionProfile)
+
+	t.Run("Token errors are returned without calling STS", func(t *testing.T) {
+		other := app
+		other.Timeout = 7200
+		cancelled := errors.New("prompt cancelled")
+		err := (&AwsConfig{sourceProfile: "work"}).CachedSessionCreds(cache, other, func() (secret.Value, error) { return "", cancelled })
+		assert.ErrorIs(t, err, cancelled)
+	})
+}
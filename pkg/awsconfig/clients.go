@@ -0,0 +1,104 @@
+package awsconfig
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"time"
+
+	"gredentures/pkg/appconfig"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+	"github.com/aws/aws-sdk-go-v2/service/cloudtrail"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/organizations"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// clientFactory builds the AWS service clients of one SDK config. Every client of the
+// package is built by one, so the SDK settings of the command apply to all of them.
+type clientFactory struct {
+	cfg aws.Config
+}
+
+// clients returns the factory of the clients of cfg, tuned with the SDK settings of conf.
+func (conf *AwsConfig) clients(cfg aws.Config) clientFactory {
+	return clientFactory{cfg: tuneSDK(cfg, conf.sdk)}
+}
+
+// sts returns an STS client, see stsClient for the ones failing over to other endpoints.
+func (f clientFactory) sts(optFns ...func(*sts.Options)) *sts.Client {
+	return sts.NewFromConfig(f.cfg, optFns...)
+}
+
+// iam returns an IAM client.
+func (f clientFactory) iam() *iam.Client {
+	return iam.NewFromConfig(f.cfg)
+}
+
+// organizations returns an AWS Organizations client.
+func (f clientFactory) organizations() *organizations.Client {
+	return organizations.NewFromConfig(f.cfg)
+}
+
+// cloudTrail returns a CloudTrail client of region, which keeps the events of its region only.
+func (f clientFactory) cloudTrail(region string) *cloudtrail.Client {
+	return cloudtrail.NewFromConfig(f.cfg, func(o *cloudtrail.Options) { o.Region = region })
+}
+
+// loadOptions returns the load options every AWS configuration of conf is loaded with: those
+// of httpOptions and, with skipIMDS, a disabled EC2 instance metadata client. The metadata service only answers on EC2, elsewhere
+// the SDK waits for its lookups to time out.
+func (conf *AwsConfig) loadOptions() ([]func(*config.LoadOptions) error, error) {
+	opts, err := conf.httpOptions()
+	if err != nil {
+		return nil, err
+	}
+	if conf.skipIMDS {
+		slog.Debug("Skipping EC2 instance metadata lookups")
+		opts = append(opts, config.WithEC2IMDSClientEnableState(imds.ClientDisabled))
+	}
+	return opts, nil
+}
+
+// tuneSDK returns a copy of cfg with the retry mode, attempts, call timeout and, while
+// tracing, log modes of sdk, leaving the settings sdk does not set as loaded.
+// AWS_RETRY_MODE and AWS_MAX_ATTEMPTS so still apply to those.
+func tuneSDK(cfg aws.Config, sdk appconfig.SDKConfig) aws.Config {
+	cfg = cfg.Copy()
+	if sdk.RetryMode != "" {
+		cfg.RetryMode = aws.RetryMode(sdk.RetryMode)
+	}
+	if sdk.MaxAttempts != 0 {
+		cfg.RetryMaxAttempts = sdk.MaxAttempts
+	}
+	if sdk.CallTimeout != 0 {
+		// Clone the options, the copy shares them with cfg
+		cfg.APIOptions = append(slices.Clone(cfg.APIOptions), callTimeout(time.Duration(sdk.CallTimeout)*time.Second))
+	}
+	if sdk.RetryMode != "" || sdk.MaxAttempts != 0 || sdk.CallTimeout != 0 {
+		slog.Debug("Tuning AWS SDK calls", "retry_mode", sdk.RetryMode, "max_attempts", sdk.MaxAttempts, "call_timeout", sdk.CallTimeout)
+	}
+	// This replaces the default modes of sdkLogOptions
+	if len(sdk.Log) > 0 && tracing() {
+		cfg.ClientLogMode = sdkLogMode(sdk.Log)
+	}
+	return cfg
+}
+
+// callTimeout returns an API option limiting each call to timeout, all its retries included.
+// Unlike a timeout of the HTTP client, which applies to every attempt, a call that keeps
+// failing over a dropped VPN gives up once timeout has passed.
+func callTimeout(timeout time.Duration) func(*middleware.Stack) error {
+	return func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("gredenturesCallTimeout",
+			func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				ctx, cancel := context.WithTimeout(ctx, timeout)
+				defer cancel()
+				return next.HandleInitialize(ctx, in)
+			}), middleware.Before)
+	}
+}
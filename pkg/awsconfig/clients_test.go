@@ -0,0 +1,94 @@
+package awsconfig
+
+import (
+	"context"
+	"gredentures/pkg/appconfig"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sdkClients returns the client factory of a config with static credentials, tuned with sdk.
+func sdkClients(sdk appconfig.SDKConfig) clientFactory {
+	cfg := aws.Config{
+		Region:      "us-west-2",
+		Credentials: credentials.NewStaticCredentialsProvider("AKIALONGLIVED", "longLivedSecret", ""),
+	}
+	return (&AwsConfig{sdk: sdk}).clients(cfg)
+}
+
+func TestClientFactory(t *testing.T) {
+	t.Run("Leaves the SDK defaults alone", func(t *testing.T) {
+		clients := sdkClients(appconfig.SDKConfig{})
+		assert.Empty(t, clients.cfg.RetryMode)
+		assert.Zero(t, clients.cfg.RetryMaxAttempts)
+		assert.Empty(t, clients.cfg.APIOptions)
+	})
+
+	t.Run("Sets the retry mode and attempts of every client", func(t *testing.T) {
+		clients := sdkClients(appconfig.SDKConfig{RetryMode: appconfig.RetryAdaptive, MaxAttempts: 7})
+		assert.Equal(t, aws.RetryModeAdaptive, clients.sts().Options().RetryMode)
+		assert.Equal(t, 7, clients.sts().Options().Retryer.MaxAttempts())
+		assert.Equal(t, 7, clients.iam().Options().Retryer.MaxAttempts())
+		assert.Equal(t, 7, clients.organizations().Options().Retryer.MaxAttempts())
+		assert.Equal(t, 7, clients.cloudTrail("us-east-1").Options().Retryer.MaxAttempts())
+	})
+
+	t.Run("Keeps the retry settings of the environment", func(t *testing.T) {
+		t.Setenv("AWS_MAX_ATTEMPTS", "5")
+		cfg, err := config.LoadDefaultConfig(context.Background(), config.WithRegion("us-west-2"))
+		require.NoError(t, err)
+		clients := (&AwsConfig{sdk: appconfig.SDKConfig{RetryMode: appconfig.RetryAdaptive}}).clients(cfg)
+		assert.Equal(t, aws.RetryModeAdaptive, clients.iam().Options().RetryMode)
+		assert.Equal(t, 5, clients.iam().Options().Retryer.MaxAttempts())
+	})
+
+	t.Run("Leaves the config it tunes alone", func(t *testing.T) {
+		cfg := aws.Config{Region: "us-west-2"}
+		(&AwsConfig{sdk: appconfig.SDKConfig{CallTimeout: 1}}).clients(cfg)
+		assert.Empty(t, cfg.APIOptions)
+	})
+
+	t.Run("Builds CloudTrail clients of the region", func(t *testing.T) {
+		assert.Equal(t, "eu-west-1", sdkClients(appconfig.SDKConfig{}).cloudTrail("eu-west-1").Options().Region)
+	})
+
+	t.Run("Gives up on calls that take too long", func(t *testing.T) {
+		release := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+		}))
+		defer server.Close()
+		defer close(release)
+
+		client := sdkClients(appconfig.SDKConfig{CallTimeout: 1}).sts(func(o *sts.Options) { o.BaseEndpoint = aws.String(server.URL) })
+		start := time.Now()
+		_, err := client.GetCallerIdentity(context.Background(), &sts.GetCallerIdentityInput{})
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Less(t, time.Since(start), 5*time.Second)
+	})
+
+	t.Run("Tunes the failover STS clients", func(t *testing.T) {
+		conf := &AwsConfig{partition: appconfig.PartitionAWS, sdk: appconfig.SDKConfig{MaxAttempts: 4}}
+		for _, endpoint := range conf.stsClient(aws.Config{Region: "us-west-2"}).(*failoverSTS).endpoints {
+			assert.Equal(t, 4, endpoint.client.(*sts.Client).Options().Retryer.MaxAttempts(), endpoint.host)
+		}
+	})
+}
+
+func TestSetSourceProfileSDK(t *testing.T) {
+	conf := &AwsConfig{}
+	conf.SetSourceProfile(appconfig.AppConfig{SessionsCmd: true, SDK: appconfig.SDKConfig{
+		MaxAttempts: 3,
+		Commands:    map[string]appconfig.SDKConfig{"sessions": {CallTimeout: 120}},
+	}})
+	assert.Equal(t, appconfig.SDKConfig{MaxAttempts: 3, CallTimeout: 120}, conf.sdk)
+}
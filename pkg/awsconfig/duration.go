@@ -0,0 +1,98 @@
+package awsconfig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+)
+
+// chainedSessionLimit is the longest session, in seconds, STS issues for a role assumed with
+// the credentials of another role.
+const chainedSessionLimit = 3600
+
+// iamAPI is the subset of the IAM client used to discover a role's maximum session duration.
+type iamAPI interface {
+	GetRole(ctx context.Context, params *iam.GetRoleInput, optFns ...func(*iam.Options)) (*iam.GetRoleOutput, error)
+}
+
+// durationExceeded reports whether err is STS rejecting the requested DurationSeconds, and
+// whether the limit hit is the fixed one for role chaining rather than the role's own maximum.
+func durationExceeded(err error) (exceeded, chained bool) {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) || apiErr.ErrorCode() != "ValidationError" {
+		return false, false
+	}
+	switch message := apiErr.ErrorMessage(); {
+	case strings.Contains(message, "MaxSessionDuration"):
+		return true, false
+	case strings.Contains(message, "role chaining"):
+		return true, true
+	}
+	return false, false
+}
+
+// assumeRole calls AssumeRole and, when the requested duration exceeds what the role allows,
+// retries once with the longest duration permitted. The role's MaxSessionDuration is looked up
+// with iam:GetRole, which only works for roles in caller, the account of client's credentials,
+// and when the caller may read the role; without it the original STS error is returned. roles
+// may be nil, and caller "" when the account is not known.
+func assumeRole(ctx context.Context, client stsAPI, roles iamAPI, caller string, input *sts.AssumeRoleInput) (*sts.AssumeRoleOutput, error) {
+	out, err := client.AssumeRole(ctx, input)
+	exceeded, chained := durationExceeded(err)
+	if !exceeded || input.DurationSeconds == nil {
+		return out, err
+	}
+
+	limit := int32(chainedSessionLimit)
+	if !chained {
+		if roles == nil {
+			return nil, err
+		}
+		var lookupErr error
+		if limit, lookupErr = maxSessionDuration(ctx, roles, caller, aws.ToString(input.RoleArn)); lookupErr != nil {
+			return nil, fmt.Errorf("%w (the role's maximum session duration could not be looked up: %v)", err, lookupErr)
+		}
+	}
+	if limit >= *input.DurationSeconds {
+		return nil, err
+	}
+
+	slog.Warn("Requested session duration exceeds the role's maximum, retrying with the maximum",
+		"role_arn", aws.ToString(input.RoleArn),
+		"requested", time.Duration(*input.DurationSeconds)*time.Second,
+		"maximum", time.Duration(limit)*time.Second)
+	retry := *input
+	retry.DurationSeconds = aws.Int32(limit)
+	return client.AssumeRole(ctx, &retry)
+}
+
+// maxSessionDuration returns the MaxSessionDuration of the role with the given ARN. GetRole
+// takes a role name only and reads the roles of caller, so the roles of other accounts are
+// not looked up, and a role of the same name found instead of the one asked for is refused.
+func maxSessionDuration(ctx context.Context, roles iamAPI, caller, roleArn string) (int32, error) {
+	if parsed, err := arn.Parse(roleArn); err == nil && caller != "" && parsed.AccountID != caller {
+		return 0, fmt.Errorf("role %s is not in the caller's account %s", roleArn, caller)
+	}
+	// Role names are the last element of the ARN, after any path
+	name := roleArn[strings.LastIndex(roleArn, "/")+1:]
+	out, err := roles.GetRole(ctx, &iam.GetRoleInput{RoleName: aws.String(name)})
+	if err != nil {
+		return 0, err
+	}
+	if out.Role == nil || out.Role.MaxSessionDuration == nil {
+		return 0, fmt.Errorf("role %s has no maximum session duration", name)
+	}
+	if found := aws.ToString(out.Role.Arn); found != "" && found != roleArn {
+		return 0, fmt.Errorf("found role %s instead of %s in the caller's account", found, roleArn)
+	}
+	return *out.Role.MaxSessionDuration, nil
+}
@@ -0,0 +1,140 @@
+package awsconfig
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/aws/smithy-go"
+	"github.com/stretchr/testify/assert"
+)
+
+type MockIAMClient struct {
+	GetRoleFunc func(ctx context.Context, params *iam.GetRoleInput, optFns ...func(*iam.Options)) (*iam.GetRoleOutput, error)
+}
+
+func (m *MockIAMClient) GetRole(ctx context.Context, params *iam.GetRoleInput, optFns ...func(*iam.Options)) (*iam.GetRoleOutput, error) {
+	return m.GetRoleFunc(ctx, params, optFns...)
+}
+
+var (
+	maxDurationErr = &smithy.GenericAPIError{Code: "ValidationError", Message: "The requested DurationSeconds exceeds the MaxSessionDuration set for this role."}
+	chainedErr     = &smithy.GenericAPIError{Code: "ValidationError", Message: "The requested DurationSeconds exceeds the 1 hour session limit for roles assumed by role chaining."}
+)
+
+func TestDurationExceeded(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		exceeded bool
+		chained  bool
+	}{
+		{"Role maximum", maxDurationErr, true, false},
+		{"Role chaining", chainedErr, true, true},
+		{"Other validation error", &smithy.GenericAPIError{Code: "ValidationError", Message: "1 validation error detected"}, false, false},
+		{"Not an API error", fmt.Errorf("connection refused"), false, false},
+		{"No error", nil, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exceeded, chained := durationExceeded(tt.err)
+			assert.Equal(t, tt.exceeded, exceeded)
+			assert.Equal(t, tt.chained, chained)
+		})
+	}
+}
+
+// durationLimitedSTS rejects durations above limit with err and records every duration requested.
+func durationLimitedSTS(limit int32, err error, requested *[]int32) *MockSTSClient {
+	return &MockSTSClient{
+		AssumeRoleFunc: func(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+			*requested = append(*requested, aws.ToInt32(params.DurationSeconds))
+			if aws.ToInt32(params.DurationSeconds) > limit {
+				return nil, err
+			}
+			return &sts.AssumeRoleOutput{Credentials: &types.Credentials{}}, nil
+		},
+	}
+}
+
+func TestAssumeRoleMaxDuration(t *testing.T) {
+	input := func() *sts.AssumeRoleInput {
+		return &sts.AssumeRoleInput{RoleArn: aws.String("arn:aws:iam::111111111111:role/team/Admin"), DurationSeconds: aws.Int32(43200)}
+	}
+
+	t.Run("Retries with the role's maximum", func(t *testing.T) {
+		var requested []int32
+		roles := &MockIAMClient{
+			GetRoleFunc: func(ctx context.Context, params *iam.GetRoleInput, optFns ...func(*iam.Options)) (*iam.GetRoleOutput, error) {
+				assert.Equal(t, "Admin", aws.ToString(params.RoleName))
+				return &iam.GetRoleOutput{Role: &iamtypes.Role{MaxSessionDuration: aws.Int32(7200)}}, nil
+			},
+		}
+
+		_, err := assumeRole(context.TODO(), durationLimitedSTS(7200, maxDurationErr, &requested), roles, "", input())
+		assert.NoError(t, err)
+		assert.Equal(t, []int32{43200, 7200}, requested)
+	})
+
+	t.Run("Retries chained roles with one hour", func(t *testing.T) {
+		var requested []int32
+		_, err := assumeRole(context.TODO(), durationLimitedSTS(3600, chainedErr, &requested), nil, "", input())
+		assert.NoError(t, err)
+		assert.Equal(t, []int32{43200, 3600}, requested)
+	})
+
+	t.Run("Returns the STS error when the role cannot be read", func(t *testing.T) {
+		var requested []int32
+		roles := &MockIAMClient{
+			GetRoleFunc: func(ctx context.Context, params *iam.GetRoleInput, optFns ...func(*iam.Options)) (*iam.GetRoleOutput, error) {
+				return nil, fmt.Errorf("not authorized to perform iam:GetRole")
+			},
+		}
+
+		_, err := assumeRole(context.TODO(), durationLimitedSTS(7200, maxDurationErr, &requested), roles, "", input())
+		assert.ErrorIs(t, err, maxDurationErr)
+		assert.ErrorContains(t, err, "iam:GetRole")
+		assert.Equal(t, []int32{43200}, requested)
+	})
+
+	t.Run("Does not look up the roles of other accounts", func(t *testing.T) {
+		var requested []int32
+		roles := &MockIAMClient{
+			GetRoleFunc: func(ctx context.Context, params *iam.GetRoleInput, optFns ...func(*iam.Options)) (*iam.GetRoleOutput, error) {
+				panic("unexpected GetRole")
+			},
+		}
+
+		_, err := assumeRole(context.TODO(), durationLimitedSTS(7200, maxDurationErr, &requested), roles, "222222222222", input())
+		assert.ErrorIs(t, err, maxDurationErr)
+		assert.ErrorContains(t, err, "not in the caller's account 222222222222")
+		assert.Equal(t, []int32{43200}, requested)
+	})
+
+	t.Run("Refuses a role of the same name found instead", func(t *testing.T) {
+		var requested []int32
+		roles := &MockIAMClient{
+			GetRoleFunc: func(ctx context.Context, params *iam.GetRoleInput, optFns ...func(*iam.Options)) (*iam.GetRoleOutput, error) {
+				return &iam.GetRoleOutput{Role: &iamtypes.Role{Arn: aws.String("arn:aws:iam::222222222222:role/Admin"), MaxSessionDuration: aws.Int32(43200)}}, nil
+			},
+		}
+
+		_, err := assumeRole(context.TODO(), durationLimitedSTS(7200, maxDurationErr, &requested), roles, "", input())
+		assert.ErrorIs(t, err, maxDurationErr)
+		assert.ErrorContains(t, err, "found role arn:aws:iam::222222222222:role/Admin instead")
+		assert.Equal(t, []int32{43200}, requested)
+	})
+
+	t.Run("Does not retry other errors", func(t *testing.T) {
+		var requested []int32
+		_, err := assumeRole(context.TODO(), durationLimitedSTS(0, fmt.Errorf("access denied"), &requested), nil, "", input())
+		assert.EqualError(t, err, "access denied")
+		assert.Equal(t, []int32{43200}, requested)
+	})
+}
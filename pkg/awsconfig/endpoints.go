@@ -0,0 +1,149 @@
+package awsconfig
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"strings"
+
+	"gredentures/pkg/appconfig"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// globalSTSEndpoint is the STS endpoint of the commercial partition that is bound to no region.
+const globalSTSEndpoint = "https://sts.amazonaws.com"
+
+// stsEndpoint is one of the endpoints a failoverSTS sends its calls to.
+type stsEndpoint struct {
+	host   string // Host name of the endpoint, logged with every call it serves.
+	client stsAPI
+}
+
+// failoverSTS is an stsAPI sending every call to the regional STS endpoint of the session
+// first and, when that cannot be reached or fails with a server error, to each failover
+// endpoint in turn. Errors STS answers with, such as a rejected MFA code, are returned
+// straight away.
+type failoverSTS struct {
+	endpoints []stsEndpoint
+}
+
+// stsClient returns the STS client of cfg, failing over to the STSRegions of the config, or
+// to the global endpoint in the commercial partition when none are configured. Regions outside
+// the partition of the session are skipped, their STS would not know the keys. A custom
+// endpoint, such as one set with AWS_ENDPOINT_URL_STS, is used alone.
+func (conf *AwsConfig) stsClient(cfg aws.Config) stsAPI {
+	clients := conf.clients(cfg)
+	primary := clients.sts()
+	if custom := primary.Options().BaseEndpoint; custom != nil {
+		return &failoverSTS{endpoints: []stsEndpoint{{host: aws.ToString(custom), client: primary}}}
+	}
+	endpoints := []stsEndpoint{{host: stsHost(cfg.Region), client: primary}}
+
+	partition := cmp.Or(conf.partition, appconfig.PartitionAWS)
+	regions := conf.stsRegions
+	if len(regions) == 0 && partition == appconfig.PartitionAWS {
+		regions = []string{appconfig.STSGlobal}
+	}
+	for _, region := range regions {
+		switch {
+		case region == appconfig.STSGlobal && partition != appconfig.PartitionAWS,
+			region != appconfig.STSGlobal && regionPartition(region) != partition:
+			slog.Debug("Skipping STS region of another partition", "region", region, "partition", partition)
+		case region == appconfig.STSGlobal:
+			endpoints = append(endpoints, stsEndpoint{host: strings.TrimPrefix(globalSTSEndpoint, "https://"),
+				client: clients.sts(func(o *sts.Options) {
+					o.Region = globalSTSRegion
+					o.BaseEndpoint = aws.String(globalSTSEndpoint)
+				})})
+		case region != cfg.Region:
+			endpoints = append(endpoints, stsEndpoint{host: stsHost(region),
+				client: clients.sts(func(o *sts.Options) { o.Region = region })})
+		}
+	}
+	return &failoverSTS{endpoints: endpoints}
+}
+
+// STSEndpoint returns the URL of the regional STS endpoint sessions are requested from, in the
+// region of the source keys or, without one, the default region of their partition. Call it
+// after SetSourceProfile.
+func (conf *AwsConfig) STSEndpoint() string {
+	return "https://" + stsHost(cmp.Or(conf.region, defaultRegion)) + "/"
+}
+
+// stsHost returns the host name of the regional STS endpoint of region.
+func stsHost(region string) string {
+	if strings.HasPrefix(region, "cn-") {
+		return "sts." + region + ".amazonaws.com.cn"
+	}
+	return "sts." + region + ".amazonaws.com"
+}
+
+// GetSessionToken implements stsAPI. It only fails over while the request cannot have been
+// sent: an endpoint that received the MFA code may have used it, so the next one would reject
+// it and hide why the first one failed.
+func (f *failoverSTS) GetSessionToken(ctx context.Context, params *sts.GetSessionTokenInput, optFns ...func(*sts.Options)) (*sts.GetSessionTokenOutput, error) {
+	return failover(f, "GetSessionToken", requestNotSent, func(client stsAPI) (*sts.GetSessionTokenOutput, error) {
+		return client.GetSessionToken(ctx, params, optFns...)
+	})
+}
+
+// AssumeRole implements stsAPI.
+func (f *failoverSTS) AssumeRole(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+	return failover(f, "AssumeRole", endpointFailed, func(client stsAPI) (*sts.AssumeRoleOutput, error) {
+		return client.AssumeRole(ctx, params, optFns...)
+	})
+}
+
+// GetCallerIdentity implements stsAPI.
+func (f *failoverSTS) GetCallerIdentity(ctx context.Context, params *sts.GetCallerIdentityInput, optFns ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error) {
+	return failover(f, "GetCallerIdentity", endpointFailed, func(client stsAPI) (*sts.GetCallerIdentityOutput, error) {
+		return client.GetCallerIdentity(ctx, params, optFns...)
+	})
+}
+
+// failover makes call with the client of each endpoint of f in turn, until one serves it or
+// fails with an error for which next is false. The endpoint serving it is logged.
+func failover[T any](f *failoverSTS, operation string, next func(error) bool, call func(stsAPI) (T, error)) (T, error) {
+	var out T
+	var err error
+	for i, endpoint := range f.endpoints {
+		if out, err = call(endpoint.client); err == nil {
+			slog.Info("STS request served", "operation", operation, "endpoint", endpoint.host)
+			return out, nil
+		}
+		if i+1 == len(f.endpoints) || !next(err) {
+			break
+		}
+		slog.Warn("STS endpoint failed, trying the next one", "operation", operation, "endpoint", endpoint.host,
+			"next", f.endpoints[i+1].host, "error", err)
+	}
+	return out, err
+}
+
+// endpointFailed reports whether err came from the endpoint rather than from the request:
+// it could not be reached, or answered with a server error.
+func endpointFailed(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var sendErr *smithyhttp.RequestSendError
+	var respErr *smithyhttp.ResponseError
+	return errors.As(err, &sendErr) || errors.As(err, &respErr) && respErr.HTTPStatusCode() >= 500
+}
+
+// requestNotSent reports whether err shows the request never left the machine: the host name
+// of the endpoint did not resolve, or no connection to it could be made.
+func requestNotSent(err error) bool {
+	var sendErr *smithyhttp.RequestSendError
+	if !errors.As(err, &sendErr) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	return errors.As(err, &dnsErr) || errors.As(err, &opErr) && opErr.Op == "dial"
+}
@@ -0,0 +1,172 @@
+package awsconfig
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+
+	"gredentures/pkg/appconfig"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// identityClient returns an STS client answering GetCallerIdentity with arn, or err when set,
+// counting its calls in calls.
+func identityClient(arn string, err error, calls *int) stsAPI {
+	return &MockSTSClient{
+		GetCallerIdentityFunc: func(ctx context.Context, params *sts.GetCallerIdentityInput, optFns ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error) {
+			*calls++
+			if err != nil {
+				return nil, err
+			}
+			return &sts.GetCallerIdentityOutput{Arn: aws.String(arn)}, nil
+		},
+	}
+}
+
+func TestFailoverSTS(t *testing.T) {
+	unreachable := &smithyhttp.RequestSendError{Err: fmt.Errorf("dial tcp: lookup sts.us-west-2.amazonaws.com: no such host")}
+	response := func(status int) error {
+		return &smithyhttp.ResponseError{Response: &smithyhttp.Response{Response: &http.Response{StatusCode: status}}}
+	}
+
+	tests := []struct {
+		name     string
+		err      error  // Returned by the regional endpoint.
+		expected string // ARN returned, empty for the error of the regional endpoint.
+		fallback int    // Calls to the failover endpoint.
+	}{
+		{"Served by the regional endpoint", nil, "arn:aws:iam::111111111111:user/regional", 0},
+		{"Fails over when the endpoint cannot be reached", unreachable, "arn:aws:iam::111111111111:user/global", 1},
+		{"Fails over on server errors", response(http.StatusServiceUnavailable), "arn:aws:iam::111111111111:user/global", 1},
+		{"Returns client errors", response(http.StatusForbidden), "", 0},
+		{"Returns cancelled calls", fmt.Errorf("%w", context.Canceled), "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var regional, global int
+			client := &failoverSTS{endpoints: []stsEndpoint{
+				{host: "sts.us-west-2.amazonaws.com", client: identityClient("arn:aws:iam::111111111111:user/regional", tt.err, &regional)},
+				{host: "sts.amazonaws.com", client: identityClient("arn:aws:iam::111111111111:user/global", nil, &global)},
+			}}
+			out, err := client.GetCallerIdentity(context.Background(), &sts.GetCallerIdentityInput{})
+			if tt.expected == "" {
+				assert.ErrorIs(t, err, tt.err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expected, aws.ToString(out.Arn))
+			}
+			assert.Equal(t, 1, regional)
+			assert.Equal(t, tt.fallback, global)
+		})
+	}
+
+	t.Run("Returns the error of the last endpoint", func(t *testing.T) {
+		var regional, global int
+		client := &failoverSTS{endpoints: []stsEndpoint{
+			{host: "sts.us-west-2.amazonaws.com", client: identityClient("", unreachable, &regional)},
+			{host: "sts.amazonaws.com", client: identityClient("", response(http.StatusInternalServerError), &global)},
+		}}
+		_, err := client.GetCallerIdentity(context.Background(), &sts.GetCallerIdentityInput{})
+		assert.ErrorContains(t, err, "500")
+		assert.Equal(t, 1, global)
+	})
+}
+
+func TestFailoverSTSSessionToken(t *testing.T) {
+	sendErr := func(err error) error { return &smithyhttp.RequestSendError{Err: err} }
+	tests := []struct {
+		name     string
+		err      error // Returned by the regional endpoint.
+		fallback int   // Calls to the failover endpoint.
+	}{
+		{"Fails over when the host does not resolve", sendErr(&net.DNSError{Err: "no such host", Name: "sts.us-west-2.amazonaws.com"}), 1},
+		{"Fails over when no connection can be made", sendErr(&net.OpError{Op: "dial", Net: "tcp", Err: fmt.Errorf("connection refused")}), 1},
+		{"Keeps the error once the code may have been sent", sendErr(&net.OpError{Op: "read", Net: "tcp", Err: fmt.Errorf("connection reset by peer")}), 0},
+		{"Keeps server errors", &smithyhttp.ResponseError{Response: &smithyhttp.Response{Response: &http.Response{StatusCode: http.StatusServiceUnavailable}}}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var regional, global int
+			session := func(err error, calls *int) stsAPI {
+				return &MockSTSClient{
+					GetSessionTokenFunc: func(ctx context.Context, params *sts.GetSessionTokenInput, optFns ...func(*sts.Options)) (*sts.GetSessionTokenOutput, error) {
+						*calls++
+						if err != nil {
+							return nil, err
+						}
+						return &sts.GetSessionTokenOutput{}, nil
+					},
+				}
+			}
+			client := &failoverSTS{endpoints: []stsEndpoint{
+				{host: "sts.us-west-2.amazonaws.com", client: session(tt.err, &regional)},
+				{host: "sts.amazonaws.com", client: session(nil, &global)},
+			}}
+			_, err := client.GetSessionToken(context.Background(), &sts.GetSessionTokenInput{TokenCode: aws.String("123456")})
+			if tt.fallback == 0 {
+				assert.ErrorIs(t, err, tt.err, "the error of the endpoint that got the code is reported")
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.fallback, global)
+		})
+	}
+}
+
+func TestSTSEndpoint(t *testing.T) {
+	assert.Equal(t, "https://sts.us-west-2.amazonaws.com/", (&AwsConfig{}).STSEndpoint())
+	assert.Equal(t, "https://sts.us-gov-west-1.amazonaws.com/", (&AwsConfig{region: "us-gov-west-1"}).STSEndpoint())
+	assert.Equal(t, "https://sts.cn-north-1.amazonaws.com.cn/", (&AwsConfig{region: "cn-north-1"}).STSEndpoint())
+}
+
+func TestSTSClient(t *testing.T) {
+	hosts := func(client stsAPI) []string {
+		var names []string
+		for _, endpoint := range client.(*failoverSTS).endpoints {
+			names = append(names, endpoint.host)
+		}
+		return names
+	}
+	cfg := aws.Config{Region: "us-west-2"}
+
+	t.Run("Fails over to the global endpoint by default", func(t *testing.T) {
+		conf := &AwsConfig{partition: appconfig.PartitionAWS}
+		assert.Equal(t, []string{"sts.us-west-2.amazonaws.com", "sts.amazonaws.com"}, hosts(conf.stsClient(cfg)))
+	})
+
+	t.Run("Fails over to the configured regions", func(t *testing.T) {
+		conf := &AwsConfig{stsRegions: []string{"us-west-2", "us-east-2", appconfig.STSGlobal}}
+		assert.Equal(t, []string{"sts.us-west-2.amazonaws.com", "sts.us-east-2.amazonaws.com", "sts.amazonaws.com"}, hosts(conf.stsClient(cfg)))
+	})
+
+	t.Run("Has no global endpoint in other partitions", func(t *testing.T) {
+		conf := &AwsConfig{partition: appconfig.PartitionChina}
+		assert.Equal(t, []string{"sts.cn-north-1.amazonaws.com.cn"}, hosts(conf.stsClient(aws.Config{Region: "cn-north-1"})))
+	})
+
+	t.Run("Skips the regions of other partitions", func(t *testing.T) {
+		conf := &AwsConfig{partition: appconfig.PartitionGovCloud, stsRegions: []string{"us-east-2", "us-gov-east-1", appconfig.STSGlobal}}
+		assert.Equal(t, []string{"sts.us-gov-west-1.amazonaws.com", "sts.us-gov-east-1.amazonaws.com"}, hosts(conf.stsClient(aws.Config{Region: "us-gov-west-1"})))
+	})
+
+	t.Run("Uses a custom endpoint alone", func(t *testing.T) {
+		conf := &AwsConfig{stsRegions: []string{"us-east-2"}}
+		custom := aws.Config{Region: "us-west-2", BaseEndpoint: aws.String("http://localhost:4566")}
+		assert.Equal(t, []string{"http://localhost:4566"}, hosts(conf.stsClient(custom)))
+	})
+
+	t.Run("Uses the STS endpoint of the environment alone", func(t *testing.T) {
+		t.Setenv("AWS_ENDPOINT_URL_STS", "http://localhost:4566")
+		cfg, err := config.LoadDefaultConfig(context.Background(), config.WithRegion("us-west-2"))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"http://localhost:4566"}, hosts((&AwsConfig{}).stsClient(cfg)))
+	})
+}
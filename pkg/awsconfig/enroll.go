@@ -0,0 +1,161 @@
+package awsconfig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gredentures/pkg/interrupt"
+	"gredentures/pkg/secret"
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+)
+
+// mfaAPI is the subset of the IAM client used to enroll and resync MFA devices, so it can be mocked in tests.
+type mfaAPI interface {
+	GetUser(ctx context.Context, params *iam.GetUserInput, optFns ...func(*iam.Options)) (*iam.GetUserOutput, error)
+	CreateVirtualMFADevice(ctx context.Context, params *iam.CreateVirtualMFADeviceInput, optFns ...func(*iam.Options)) (*iam.CreateVirtualMFADeviceOutput, error)
+	EnableMFADevice(ctx context.Context, params *iam.EnableMFADeviceInput, optFns ...func(*iam.Options)) (*iam.EnableMFADeviceOutput, error)
+	DeleteVirtualMFADevice(ctx context.Context, params *iam.DeleteVirtualMFADeviceInput, optFns ...func(*iam.Options)) (*iam.DeleteVirtualMFADeviceOutput, error)
+	ResyncMFADevice(ctx context.Context, params *iam.ResyncMFADeviceInput, optFns ...func(*iam.Options)) (*iam.ResyncMFADeviceOutput, error)
+}
+
+// MFADevice is a virtual MFA device created for an IAM user but not yet enabled.
+type MFADevice struct {
+	SerialNumber string       // Device ARN, saved as the gredentures Device once enabled.
+	UserName     string       // IAM user the device belongs to.
+	Seed         secret.Value // Base32 TOTP seed, for authenticator apps that cannot scan the QR code.
+	QRCodePNG    []byte       // PNG of a QR code holding the seed.
+}
+
+// CreateMFADevice creates a virtual MFA device, named after the IAM user the source
+// credentials belong to, ready to be added to an authenticator app.
+func (conf *AwsConfig) CreateMFADevice() (*MFADevice, error) {
+	config, err := conf.sourceAccount()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get default account: %w", err)
+	}
+	return createMFADevice(interrupt.Context(), conf.clients(config).iam())
+}
+
+// EnableMFADevice checks two consecutive codes from the authenticator app and associates the
+// device with its IAM user.
+func (conf *AwsConfig) EnableMFADevice(device *MFADevice, code1, code2 secret.Value) error {
+	config, err := conf.sourceAccount()
+	if err != nil {
+		return fmt.Errorf("failed to get default account: %w", err)
+	}
+	return enableMFADevice(interrupt.Context(), conf.clients(config).iam(), device, code1, code2)
+}
+
+// DeleteMFADevice removes a virtual MFA device that was never enabled, so enrolling again
+// can reuse its name.
+func (conf *AwsConfig) DeleteMFADevice(device *MFADevice) error {
+	config, err := conf.sourceAccount()
+	if err != nil {
+		return fmt.Errorf("failed to get default account: %w", err)
+	}
+	return deleteMFADevice(interrupt.Context(), conf.clients(config).iam(), device)
+}
+
+// ResyncMFADevice resynchronizes the MFA device with serial number serial, e.g. a hardware
+// token whose clock has drifted so far that its codes are rejected, from two consecutive codes.
+func (conf *AwsConfig) ResyncMFADevice(serial string, code1, code2 secret.Value) error {
+	config, err := conf.sourceAccount()
+	if err != nil {
+		return fmt.Errorf("failed to get default account: %w", err)
+	}
+	return resyncMFADevice(interrupt.Context(), conf.clients(config).iam(), serial, code1, code2)
+}
+
+// createMFADevice looks up the calling IAM user and creates a virtual MFA device named after it.
+func createMFADevice(ctx context.Context, client mfaAPI) (*MFADevice, error) {
+	user, err := client.GetUser(ctx, &iam.GetUserInput{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up the IAM user of the source credentials: %w", err)
+	}
+	if user.User == nil || aws.ToString(user.User.UserName) == "" {
+		return nil, fmt.Errorf("the source credentials do not belong to an IAM user")
+	}
+	name := aws.ToString(user.User.UserName)
+
+	slog.Debug("Creating virtual MFA device", "user", name)
+	out, err := client.CreateVirtualMFADevice(ctx, &iam.CreateVirtualMFADeviceInput{VirtualMFADeviceName: aws.String(name)})
+	var exists *iamtypes.EntityAlreadyExistsException
+	if errors.As(err, &exists) {
+		return nil, fmt.Errorf("a virtual MFA device named %q already exists, delete it before enrolling again: %w", name, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create virtual MFA device: %w", err)
+	}
+
+	return &MFADevice{
+		SerialNumber: aws.ToString(out.VirtualMFADevice.SerialNumber),
+		UserName:     name,
+		Seed:         secret.Value(out.VirtualMFADevice.Base32StringSeed),
+		QRCodePNG:    out.VirtualMFADevice.QRCodePNG,
+	}, nil
+}
+
+// enableMFADevice associates the device with its user, which IAM only allows for two
+// consecutive valid codes.
+func enableMFADevice(ctx context.Context, client mfaAPI, device *MFADevice, code1, code2 secret.Value) error {
+	slog.Debug("Enabling virtual MFA device", "serial_number", device.SerialNumber, "user", device.UserName)
+	_, err := client.EnableMFADevice(ctx, &iam.EnableMFADeviceInput{
+		UserName:            aws.String(device.UserName),
+		SerialNumber:        aws.String(device.SerialNumber),
+		AuthenticationCode1: aws.String(code1.Reveal()),
+		AuthenticationCode2: aws.String(code2.Reveal()),
+	})
+	var invalid *iamtypes.InvalidAuthenticationCodeException
+	if errors.As(err, &invalid) {
+		return fmt.Errorf("the codes were not accepted, enter two consecutive codes: %w", err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to enable MFA device: %w", err)
+	}
+	return nil
+}
+
+// resyncMFADevice looks up the calling IAM user and resynchronizes its device serial, which
+// IAM only allows for two consecutive valid codes.
+func resyncMFADevice(ctx context.Context, client mfaAPI, serial string, code1, code2 secret.Value) error {
+	user, err := client.GetUser(ctx, &iam.GetUserInput{})
+	if err != nil {
+		return fmt.Errorf("failed to look up the IAM user of the source credentials: %w", err)
+	}
+	if user.User == nil || aws.ToString(user.User.UserName) == "" {
+		return fmt.Errorf("the source credentials do not belong to an IAM user")
+	}
+
+	slog.Debug("Resynchronizing MFA device", "serial_number", serial, "user", aws.ToString(user.User.UserName))
+	_, err = client.ResyncMFADevice(ctx, &iam.ResyncMFADeviceInput{
+		UserName:            user.User.UserName,
+		SerialNumber:        aws.String(serial),
+		AuthenticationCode1: aws.String(code1.Reveal()),
+		AuthenticationCode2: aws.String(code2.Reveal()),
+	})
+	var invalid *iamtypes.InvalidAuthenticationCodeException
+	if errors.As(err, &invalid) {
+		return fmt.Errorf("the codes were not accepted, enter two consecutive codes: %w", err)
+	}
+	var missing *iamtypes.NoSuchEntityException
+	if errors.As(err, &missing) {
+		return fmt.Errorf("MFA device %s is not assigned to IAM user %s: %w", serial, aws.ToString(user.User.UserName), err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to resync MFA device: %w", err)
+	}
+	return nil
+}
+
+// deleteMFADevice deletes a virtual MFA device.
+func deleteMFADevice(ctx context.Context, client mfaAPI, device *MFADevice) error {
+	slog.Debug("Deleting virtual MFA device", "serial_number", device.SerialNumber)
+	if _, err := client.DeleteVirtualMFADevice(ctx, &iam.DeleteVirtualMFADeviceInput{SerialNumber: aws.String(device.SerialNumber)}); err != nil {
+		return fmt.Errorf("failed to delete virtual MFA device: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package awsconfig
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type MockMFAClient struct {
+	GetUserFunc                func(ctx context.Context, params *iam.GetUserInput, optFns ...func(*iam.Options)) (*iam.GetUserOutput, error)
+	CreateVirtualMFADeviceFunc func(ctx context.Context, params *iam.CreateVirtualMFADeviceInput, optFns ...func(*iam.Options)) (*iam.CreateVirtualMFADeviceOutput, error)
+	EnableMFADeviceFunc        func(ctx context.Context, params *iam.EnableMFADeviceInput, optFns ...func(*iam.Options)) (*iam.EnableMFADeviceOutput, error)
+	DeleteVirtualMFADeviceFunc func(ctx context.Context, params *iam.DeleteVirtualMFADeviceInput, optFns ...func(*iam.Options)) (*iam.DeleteVirtualMFADeviceOutput, error)
+	ResyncMFADeviceFunc        func(ctx context.Context, params *iam.ResyncMFADeviceInput, optFns ...func(*iam.Options)) (*iam.ResyncMFADeviceOutput, error)
+}
+
+func (m *MockMFAClient) GetUser(ctx context.Context, params *iam.GetUserInput, optFns ...func(*iam.Options)) (*iam.GetUserOutput, error) {
+	return m.GetUserFunc(ctx, params, optFns...)
+}
+
+func (m *MockMFAClient) CreateVirtualMFADevice(ctx context.Context, params *iam.CreateVirtualMFADeviceInput, optFns ...func(*iam.Options)) (*iam.CreateVirtualMFADeviceOutput, error) {
+	return m.CreateVirtualMFADeviceFunc(ctx, params, optFns...)
+}
+
+func (m *MockMFAClient) EnableMFADevice(ctx context.Context, params *iam.EnableMFADeviceInput, optFns ...func(*iam.Options)) (*iam.EnableMFADeviceOutput, error) {
+	return m.EnableMFADeviceFunc(ctx, params, optFns...)
+}
+
+func (m *MockMFAClient) DeleteVirtualMFADevice(ctx context.Context, params *iam.DeleteVirtualMFADeviceInput, optFns ...func(*iam.Options)) (*iam.DeleteVirtualMFADeviceOutput, error) {
+	return m.DeleteVirtualMFADeviceFunc(ctx, params, optFns...)
+}
+
+func (m *MockMFAClient) ResyncMFADevice(ctx context.Context, params *iam.ResyncMFADeviceInput, optFns ...func(*iam.Options)) (*iam.ResyncMFADeviceOutput, error) {
+	return m.ResyncMFADeviceFunc(ctx, params, optFns...)
+}
+
+func mockUser(name string) func(context.Context, *iam.GetUserInput, ...func(*iam.Options)) (*iam.GetUserOutput, error) {
+	return func(context.Context, *iam.GetUserInput, ...func(*iam.Options)) (*iam.GetUserOutput, error) {
+		return &iam.GetUserOutput{User: &iamtypes.User{UserName: aws.String(name)}}, nil
+	}
+}
+
+func TestCreateMFADevice(t *testing.T) {
+	t.Run("Creates a device named after the user", func(t *testing.T) {
+		client := &MockMFAClient{
+			GetUserFunc: mockUser("alice"),
+			CreateVirtualMFADeviceFunc: func(ctx context.Context, params *iam.CreateVirtualMFADeviceInput, optFns ...func(*iam.Options)) (*iam.CreateVirtualMFADeviceOutput, error) {
+				assert.Equal(t, "alice", aws.ToString(params.VirtualMFADeviceName))
+				return &iam.CreateVirtualMFADeviceOutput{VirtualMFADevice: &iamtypes.VirtualMFADevice{
+					SerialNumber:     aws.String("arn:aws:iam::123456789012:mfa/alice"),
+					Base32StringSeed: []byte("JBSWY3DPEHPK3PXP"),
+					QRCodePNG:        []byte("png"),
+				}}, nil
+			},
+		}
+
+		device, err := createMFADevice(context.Background(), client)
+		assert.NoError(t, err)
+		assert.Equal(t, &MFADevice{
+			SerialNumber: "arn:aws:iam::123456789012:mfa/alice",
+			UserName:     "alice",
+			Seed:         "JBSWY3DPEHPK3PXP",
+			QRCodePNG:    []byte("png"),
+		}, device)
+		assert.NotContains(t, fmt.Sprintf("%v", device), "JBSWY3DPEHPK3PXP", "the seed is never printed")
+	})
+
+	t.Run("Existing device", func(t *testing.T) {
+		client := &MockMFAClient{
+			GetUserFunc: mockUser("alice"),
+			CreateVirtualMFADeviceFunc: func(context.Context, *iam.CreateVirtualMFADeviceInput, ...func(*iam.Options)) (*iam.CreateVirtualMFADeviceOutput, error) {
+				return nil, &iamtypes.EntityAlreadyExistsException{Message: aws.String("exists")}
+			},
+		}
+		_, err := createMFADevice(context.Background(), client)
+		assert.ErrorContains(t, err, `a virtual MFA device named "alice" already exists`)
+	})
+
+	t.Run("Not an IAM user", func(t *testing.T) {
+		client := &MockMFAClient{GetUserFunc: func(context.Context, *iam.GetUserInput, ...func(*iam.Options)) (*iam.GetUserOutput, error) {
+			return nil, fmt.Errorf("must specify userName when calling with non-User credentials")
+		}}
+		_, err := createMFADevice(context.Background(), client)
+		assert.ErrorContains(t, err, "failed to look up the IAM user")
+	})
+}
+
+func TestEnableMFADevice(t *testing.T) {
+	device := &MFADevice{SerialNumber: "arn:aws:iam::123456789012:mfa/alice", UserName: "alice"}
+
+	var input *iam.EnableMFADeviceInput
+	client := &MockMFAClient{EnableMFADeviceFunc: func(ctx context.Context, params *iam.EnableMFADeviceInput, optFns ...func(*iam.Options)) (*iam.EnableMFADeviceOutput, error) {
+		input = params
+		if aws.ToString(params.AuthenticationCode2) == "000000" {
+			return nil, &iamtypes.InvalidAuthenticationCodeException{Message: aws.String("invalid")}
+		}
+		return &iam.EnableMFADeviceOutput{}, nil
+	}}
+
+	assert.NoError(t, enableMFADevice(context.Background(), client, device, "123456", "654321"))
+	assert.Equal(t, "alice", aws.ToString(input.UserName))
+	assert.Equal(t, device.SerialNumber, aws.ToString(input.SerialNumber))
+	assert.Equal(t, "123456", aws.ToString(input.AuthenticationCode1))
+	assert.Equal(t, "654321", aws.ToString(input.AuthenticationCode2))
+
+	err := enableMFADevice(context.Background(), client, device, "123456", "000000")
+	assert.ErrorContains(t, err, "enter two consecutive codes")
+}
+
+func TestDeleteMFADevice(t *testing.T) {
+	var deleted string
+	client := &MockMFAClient{DeleteVirtualMFADeviceFunc: func(ctx context.Context, params *iam.DeleteVirtualMFADeviceInput, optFns ...func(*iam.Options)) (*iam.DeleteVirtualMFADeviceOutput, error) {
+		deleted = aws.ToString(params.SerialNumber)
+		return &iam.DeleteVirtualMFADeviceOutput{}, nil
+	}}
+	assert.NoError(t, deleteMFADevice(context.Background(), client, &MFADevice{SerialNumber: "arn:aws:iam::123456789012:mfa/alice"}))
+	assert.Equal(t, "arn:aws:iam::123456789012:mfa/alice", deleted)
+}
+
+func TestResyncMFADevice(t *testing.T) {
+	var input *iam.ResyncMFADeviceInput
+	client := &MockMFAClient{
+		GetUserFunc: mockUser("alice"),
+		ResyncMFADeviceFunc: func(ctx context.Context, params *iam.ResyncMFADeviceInput, optFns ...func(*iam.Options)) (*iam.ResyncMFADeviceOutput, error) {
+			input = params
+			switch aws.ToString(params.AuthenticationCode2) {
+			case "000000":
+				return nil, &iamtypes.InvalidAuthenticationCodeException{Message: aws.String("invalid")}
+			case "999999":
+				return nil, &iamtypes.NoSuchEntityException{Message: aws.String("no such device")}
+			}
+			return &iam.ResyncMFADeviceOutput{}, nil
+		},
+	}
+
+	assert.NoError(t, resyncMFADevice(context.Background(), client, "GAHT12345678", "123456", "654321"))
+	assert.Equal(t, "alice", aws.ToString(input.UserName))
+	assert.Equal(t, "GAHT12345678", aws.ToString(input.SerialNumber))
+	assert.Equal(t, "123456", aws.ToString(input.AuthenticationCode1))
+	assert.Equal(t, "654321", aws.ToString(input.AuthenticationCode2))
+
+	err := resyncMFADevice(context.Background(), client, "GAHT12345678", "123456", "000000")
+	assert.ErrorContains(t, err, "enter two consecutive codes")
+
+	err = resyncMFADevice(context.Background(), client, "GAHT12345678", "123456", "999999")
+	assert.ErrorContains(t, err, "MFA device GAHT12345678 is not assigned to IAM user alice")
+}
@@ -0,0 +1,67 @@
+package awsconfig
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// conflictingEnvKeys lists the environment variables that make the AWS CLI and SDKs use
+// static credentials, ignoring AWS_PROFILE and the profiles gredentures writes.
+var conflictingEnvKeys = []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_SECURITY_TOKEN"}
+
+// ConflictingEnv returns the variables of conflictingEnvKeys that are set in environ, a list
+// of key=value entries as returned by os.Environ.
+func ConflictingEnv(environ []string) []string {
+	return setEnv(environ, conflictingEnvKeys)
+}
+
+// UnsetCommand returns a POSIX shell command clearing the static credential variables set in
+// environ, including a leftover AWS_CREDENTIAL_EXPIRATION, or "" when none are set.
+func UnsetCommand(environ []string) string {
+	keys := setEnv(environ, slices.Concat(conflictingEnvKeys, []string{"AWS_CREDENTIAL_EXPIRATION"}))
+	if len(keys) == 0 {
+		return ""
+	}
+	return "unset " + strings.Join(keys, " ") + "\n"
+}
+
+// SwitchExports returns the shell export statements pointing AWS tools at profile, for use
+// with eval. AWS_SHARED_CREDENTIALS_FILE is exported too when the sessions are written to a
+// CredentialsFile of their own, see SetCredentialsPath, and AWS_CONFIG_FILE when the profiles
+// live in the file of --aws-config-file, see SetAWSConfigPath. The static credential variables
+// set in environ are unset first, see UnsetCommand, as they would take precedence over the
+// profile.
+func SwitchExports(profile string, environ []string) string {
+	vars := []envVar{{"AWS_PROFILE", profile}}
+	if CredentialsPath() != homeCredentialsPath() {
+		vars = append([]envVar{{"AWS_SHARED_CREDENTIALS_FILE", CredentialsPath()}}, vars...)
+	}
+	if awsConfigPath != "" {
+		vars = append([]envVar{{"AWS_CONFIG_FILE", awsConfigPath}}, vars...)
+	}
+	var buf strings.Builder
+	buf.WriteString(UnsetCommand(environ))
+	for _, v := range vars {
+		fmt.Fprintf(&buf, "export %s=%s\n", v.name, shellQuote(v.value))
+	}
+	return buf.String()
+}
+
+// setEnv returns the keys that have a non-empty value in environ, in the order of keys.
+func setEnv(environ, keys []string) []string {
+	env := map[string]string{}
+	for _, entry := range environ {
+		if key, value, ok := strings.Cut(entry, "="); ok {
+			env[key] = value
+		}
+	}
+
+	var set []string
+	for _, key := range keys {
+		if env[key] != "" {
+			set = append(set, key)
+		}
+	}
+	return set
+}
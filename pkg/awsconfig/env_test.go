@@ -0,0 +1,34 @@
+package awsconfig
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConflictingEnv(t *testing.T) {
+	environ := []string{"HOME=/home/me", "AWS_SESSION_TOKEN=token", "AWS_ACCESS_KEY_ID=ASIA", "AWS_SECURITY_TOKEN=", "AWS_PROFILE=default-mfa"}
+	assert.Equal(t, []string{"AWS_ACCESS_KEY_ID", "AWS_SESSION_TOKEN"}, ConflictingEnv(environ))
+	assert.Empty(t, ConflictingEnv([]string{"AWS_PROFILE=default-mfa"}))
+}
+
+func TestUnsetCommand(t *testing.T) {
+	environ := []string{"AWS_ACCESS_KEY_ID=ASIA", "AWS_SECRET_ACCESS_KEY=secret", "AWS_CREDENTIAL_EXPIRATION=2024-01-01T00:00:00Z"}
+	assert.Equal(t, "unset AWS_ACCESS_KEY_ID AWS_SECRET_ACCESS_KEY AWS_CREDENTIAL_EXPIRATION\n", UnsetCommand(environ))
+	assert.Empty(t, UnsetCommand([]string{"AWS_REGION=us-west-2"}))
+}
+
+func TestSwitchExports(t *testing.T) {
+	t.Setenv("HOME", "/home/me")
+	assert.Equal(t, "export AWS_PROFILE='prod-mfa'\n", SwitchExports("prod-mfa", nil))
+	assert.Equal(t, "unset AWS_ACCESS_KEY_ID AWS_SECRET_ACCESS_KEY AWS_SESSION_TOKEN\nexport AWS_PROFILE='prod-mfa'\n",
+		SwitchExports("prod-mfa", []string{"AWS_ACCESS_KEY_ID=ASIA", "AWS_SECRET_ACCESS_KEY=secret", "AWS_SESSION_TOKEN=token"}))
+
+	SetCredentialsPath("/home/me/.aws/gredentures")
+	defer SetCredentialsPath("")
+	assert.Equal(t, "export AWS_SHARED_CREDENTIALS_FILE='/home/me/.aws/gredentures'\nexport AWS_PROFILE='prod-mfa'\n", SwitchExports("prod-mfa", nil))
+
+	SetAWSConfigPath("/home/me/aws/config")
+	defer SetAWSConfigPath("")
+	assert.Equal(t, "export AWS_CONFIG_FILE='/home/me/aws/config'\nexport AWS_SHARED_CREDENTIALS_FILE='/home/me/.aws/gredentures'\nexport AWS_PROFILE='prod-mfa'\n", SwitchExports("prod-mfa", nil))
+}
@@ -0,0 +1,132 @@
+package awsconfig
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// MaxClockSkew is the drift beyond which TOTP codes stop matching: one 30 second period.
+const MaxClockSkew = 30 * time.Second
+
+// Sentinel errors wrapped around failures, so callers can branch with errors.Is instead of
+// matching on messages. The underlying error stays available to errors.As.
+var (
+	// ErrSTSThrottled is returned when STS rejects a request because of rate limiting.
+	ErrSTSThrottled = errors.New("STS request throttled")
+	// ErrExpiredToken is returned when the credentials used to call STS have expired.
+	ErrExpiredToken = errors.New("expired token")
+	// ErrClockSkew is returned when an STS request failed and the local clock differs from
+	// AWS by more than a TOTP period, so MFA codes generated from it are likely rejected.
+	ErrClockSkew = errors.New("local clock skewed")
+	// ErrCredentialsFileLocked is returned when another gredentures process is writing the
+	// credentials file.
+	ErrCredentialsFileLocked = errors.New("credentials file locked")
+	// ErrMFARequired is returned when a session without MFA is requested for a user whose
+	// policies only allow API calls made with MFA.
+	ErrMFARequired = errors.New("MFA required")
+	// ErrIncompleteCredentials is returned instead of writing credentials with an empty key,
+	// secret or session token, which would leave an unusable profile behind.
+	ErrIncompleteCredentials = errors.New("incomplete credentials")
+	// ErrForeignProfile is returned in compatibility mode instead of overwriting a profile
+	// another credential helper, such as saml2aws or aws sso, manages.
+	ErrForeignProfile = errors.New("profile of another credential helper")
+	// ErrProfileCollision is returned instead of overwriting a profile gredentures does not manage
+	// that holds long-lived keys, unless --force is given.
+	ErrProfileCollision = errors.New("profile holds long-lived keys")
+	// ErrPartialRoles is returned in soft-fail mode when some of the roles of --all were
+	// assumed and others failed. The assumed ones are kept and written.
+	ErrPartialRoles = errors.New("some roles could not be assumed")
+	// ErrSTSUnreachable is returned when a request to STS could not be sent at all, e.g.
+	// without a network or with the VPN down, as opposed to STS rejecting it.
+	ErrSTSUnreachable = errors.New("STS unreachable")
+	// ErrNoStoredKeys is returned by a KeyStore holding no keys under the requested name.
+	ErrNoStoredKeys = errors.New("no keys stored")
+	// ErrKeyFilePassphrase is returned when the key file of the file key store cannot be
+	// decrypted, because the passphrase is wrong or the file was altered.
+	ErrKeyFilePassphrase = errors.New("wrong passphrase or damaged key file")
+)
+
+// STS error codes mapped to the sentinel errors.
+var (
+	throttlingCodes   = []string{"Throttling", "ThrottlingException", "RequestLimitExceeded", "TooManyRequestsException"}
+	expiredTokenCodes = []string{"ExpiredToken", "ExpiredTokenException", "RequestExpired"}
+)
+
+// classifySTSError wraps err in the sentinel matching its STS error code, if any, or in
+// ErrSTSUnreachable when the request never reached STS.
+func classifySTSError(err error) error {
+	var sendErr *smithyhttp.RequestSendError
+	if errors.As(err, &sendErr) {
+		return fmt.Errorf("%w: %w", ErrSTSUnreachable, err)
+	}
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+
+	switch code := apiErr.ErrorCode(); {
+	case slices.Contains(throttlingCodes, code):
+		return fmt.Errorf("%w: %w", ErrSTSThrottled, err)
+	case slices.Contains(expiredTokenCodes, code):
+		return fmt.Errorf("%w: %w", ErrExpiredToken, err)
+	}
+	return err
+}
+
+// externalIDHint marks an AssumeRole denial sent without an external ID. It changes neither
+// the message nor the class of the error, it only selects the hint of MissingExternalID.
+type externalIDHint struct {
+	err error
+}
+
+func (e *externalIDHint) Error() string { return e.err.Error() }
+func (e *externalIDHint) Unwrap() error { return e.err }
+
+// MissingExternalID reports whether err is an AssumeRole denial sent without an external ID,
+// the usual way a role of a third party refuses callers that do not send the one it expects.
+func MissingExternalID(err error) bool {
+	var hint *externalIDHint
+	return errors.As(err, &hint)
+}
+
+// checkExternalID marks err for MissingExternalID when input sent no external ID and STS
+// denied it. STS answers a missing or wrong external ID with the same AccessDenied as any
+// other trust policy mismatch, so this is a hint rather than a diagnosis.
+func checkExternalID(err error, input *sts.AssumeRoleInput) error {
+	var apiErr smithy.APIError
+	if input.ExternalId != nil || !errors.As(err, &apiErr) || apiErr.ErrorCode() != "AccessDenied" {
+		return err
+	}
+	return &externalIDHint{err: err}
+}
+
+// checkClockSkew compares now against the Date header of the failed STS response in err and
+// wraps err in ErrClockSkew when they differ by more than MaxClockSkew. Without it, tokens
+// rejected because of clock drift look exactly like mistyped ones.
+func checkClockSkew(err error, now time.Time) error {
+	var respErr *smithyhttp.ResponseError
+	if !errors.As(err, &respErr) || respErr.Response == nil || respErr.Response.Response == nil {
+		return err
+	}
+	date, parseErr := http.ParseTime(respErr.Response.Header.Get("Date"))
+	if parseErr != nil {
+		return err
+	}
+
+	skew := now.Sub(date)
+	if skew.Abs() <= MaxClockSkew {
+		return err
+	}
+	direction := "ahead of"
+	if skew < 0 {
+		direction = "behind"
+	}
+	return fmt.Errorf("%w: the local clock is %s %s AWS, sync it with NTP: %w", ErrClockSkew, skew.Abs().Round(time.Second), direction, err)
+}
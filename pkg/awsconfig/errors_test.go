@@ -0,0 +1,127 @@
+package awsconfig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/stretchr/testify/assert"
+	"gredentures/pkg/appconfig"
+)
+
+func TestClassifySTSError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected error
+	}{
+		{"Throttled", &smithy.GenericAPIError{Code: "Throttling", Message: "Rate exceeded"}, ErrSTSThrottled},
+		{"Expired token", &smithy.GenericAPIError{Code: "ExpiredToken", Message: "token expired"}, ErrExpiredToken},
+		{"Other API error", &smithy.GenericAPIError{Code: "AccessDenied"}, nil},
+		{"Not an API error", fmt.Errorf("connection refused"), nil},
+		{"Unreachable", &smithyhttp.RequestSendError{Err: fmt.Errorf("dial tcp: lookup sts.amazonaws.com: no such host")}, ErrSTSUnreachable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := classifySTSError(tt.err)
+			assert.ErrorIs(t, err, tt.err)
+			for _, sentinel := range []error{ErrSTSThrottled, ErrExpiredToken, ErrSTSUnreachable} {
+				assert.Equal(t, sentinel == tt.expected, errors.Is(err, sentinel), sentinel.Error())
+			}
+		})
+	}
+}
+
+func TestAssumeRolesThrottled(t *testing.T) {
+	mockSTS := &MockSTSClient{
+		AssumeRoleFunc: func(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+			return nil, &smithy.GenericAPIError{Code: "Throttling", Message: "Rate exceeded"}
+		},
+	}
+
+	conf := &AwsConfig{}
+	err := conf.assumeRoles(context.TODO(), mockSTS, nil, map[string]appconfig.OrgConfig{
+		"prod": {RoleArn: "arn:aws:iam::111111111111:role/Admin"},
+	})
+	assert.ErrorIs(t, err, ErrSTSThrottled)
+
+	var apiErr smithy.APIError
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, "Throttling", apiErr.ErrorCode())
+}
+
+func TestCheckExternalID(t *testing.T) {
+	denied := &smithy.GenericAPIError{Code: "AccessDenied", Message: "not authorized to perform: sts:AssumeRole"}
+	mockSTS := &MockSTSClient{
+		AssumeRoleFunc: func(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+			return nil, denied
+		},
+	}
+	orgs := map[string]appconfig.OrgConfig{"vendor": {RoleArn: "arn:aws:iam::111111111111:role/Vendor"}}
+
+	conf := &AwsConfig{}
+	err := conf.assumeRoles(context.TODO(), mockSTS, nil, orgs)
+	assert.True(t, MissingExternalID(err))
+	assert.ErrorIs(t, err, denied)
+	assert.Equal(t, `failed to assume role for org "vendor": `+denied.Error(), err.Error())
+
+	t.Run("Leaves denials with an external ID alone", func(t *testing.T) {
+		conf := &AwsConfig{externalID: "wrong"}
+		err := conf.assumeRoles(context.TODO(), mockSTS, nil, orgs)
+		assert.False(t, MissingExternalID(err))
+		assert.ErrorIs(t, err, denied)
+	})
+
+	t.Run("Leaves other errors alone", func(t *testing.T) {
+		input := &sts.AssumeRoleInput{}
+		err := &smithy.GenericAPIError{Code: "Throttling"}
+		assert.Same(t, err, checkExternalID(err, input))
+	})
+}
+
+func TestCheckClockSkew(t *testing.T) {
+	awsTime := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	rejected := func(date string) error {
+		header := http.Header{}
+		if date != "" {
+			header.Set("Date", date)
+		}
+		return &smithyhttp.ResponseError{
+			Response: &smithyhttp.Response{Response: &http.Response{StatusCode: http.StatusForbidden, Header: header}},
+			Err:      &smithy.GenericAPIError{Code: "AccessDenied", Message: "MultiFactorAuthentication failed with invalid MFA one time pass code."},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		err      error
+		now      time.Time
+		expected string
+	}{
+		{"Ahead", rejected(awsTime.Format(http.TimeFormat)), awsTime.Add(2 * time.Minute), "the local clock is 2m0s ahead of AWS"},
+		{"Behind", rejected(awsTime.Format(http.TimeFormat)), awsTime.Add(-45 * time.Second), "the local clock is 45s behind AWS"},
+		{"Within tolerance", rejected(awsTime.Format(http.TimeFormat)), awsTime.Add(10 * time.Second), ""},
+		{"No Date header", rejected(""), awsTime.Add(time.Hour), ""},
+		{"No response", fmt.Errorf("dial tcp: timeout"), awsTime.Add(time.Hour), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkClockSkew(tt.err, tt.now)
+			assert.ErrorIs(t, err, tt.err)
+			if tt.expected == "" {
+				assert.NotErrorIs(t, err, ErrClockSkew)
+				return
+			}
+			assert.ErrorIs(t, err, ErrClockSkew)
+			assert.ErrorContains(t, err, tt.expected)
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package awsconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"gopkg.in/ini.v1"
+)
+
+// Export formats supported by Export.
+const (
+	FormatDockerEnv    = "docker-env"
+	FormatDevcontainer = "devcontainer"
+)
+
+// mountTarget is where exported credentials files are expected to be mounted in a container.
+const mountTarget = "/run/gredentures"
+
+// Export renders the session credentials for consumption by containers. The docker-env
+// format is suitable for `docker run --env-file`, and the devcontainer format is a
+// devcontainer.json snippet. When mount is true, a credentials file is also written to a
+// new temporary directory and the output points the container at it instead of embedding
+// the keys as environment variables.
+func (conf *AwsConfig) Export(format string, mount bool) ([]byte, error) {
+	if conf.sessionCreds == nil || conf.sessionCreds.Credentials == nil {
+		return nil, fmt.Errorf("no session credentials available")
+	}
+	if format != FormatDockerEnv && format != FormatDevcontainer {
+		return nil, fmt.Errorf("unknown export format %q, expected %s or %s", format, FormatDockerEnv, FormatDevcontainer)
+	}
+
+	env := conf.exportEnv()
+	var mountDir string
+	if mount {
+		var err error
+		if mountDir, err = conf.writeMountFile(); err != nil {
+			return nil, err
+		}
+		env = []envVar{
+			{"AWS_SHARED_CREDENTIALS_FILE", mountTarget + "/credentials"},
+			{"AWS_PROFILE", "default"},
+		}
+	}
+
+	var buf bytes.Buffer
+	switch format {
+	case FormatDockerEnv:
+		if mountDir != "" {
+			fmt.Fprintf(&buf, "# Mount with: -v %s:%s:ro\n", mountDir, mountTarget)
+		}
+		for _, v := range env {
+			fmt.Fprintf(&buf, "%s=%s\n", v.name, v.value)
+		}
+	case FormatDevcontainer:
+		containerEnv := make(map[string]string, len(env))
+		for _, v := range env {
+			containerEnv[v.name] = v.value
+		}
+		snippet := map[string]any{"containerEnv": containerEnv}
+		if mountDir != "" {
+			snippet["mounts"] = []string{fmt.Sprintf("source=%s,target=%s,type=bind,readonly", mountDir, mountTarget)}
+		}
+		data, err := json.MarshalIndent(snippet, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal devcontainer snippet: %w", err)
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+
+	return buf.Bytes(), nil
+}
+
+// envVar is a single environment variable in export output, kept ordered for stable output.
+type envVar struct {
+	name  string
+	value string
+}
+
+// exportEnv returns the session credentials as environment variables.
+func (conf *AwsConfig) exportEnv() []envVar {
+	creds := conf.sessionCreds.Credentials
+	env := []envVar{
+		{"AWS_ACCESS_KEY_ID", aws.ToString(creds.AccessKeyId)},
+		{"AWS_SECRET_ACCESS_KEY", aws.ToString(creds.SecretAccessKey)},
+		{"AWS_SESSION_TOKEN", aws.ToString(creds.SessionToken)},
+	}
+	if creds.Expiration != nil {
+		env = append(env, envVar{"AWS_CREDENTIAL_EXPIRATION", creds.Expiration.UTC().Format(time.RFC3339)})
+	}
+	return env
+}
+
+// writeMountFile writes the session credentials as the default profile of a credentials
+// file in a new private temporary directory and returns the directory.
+func (conf *AwsConfig) writeMountFile() (string, error) {
+	dir, err := os.MkdirTemp("", "gredentures-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create mount directory: %w", err)
+	}
+
+	creds := conf.sessionCreds.Credentials
+	inidata := ini.Empty()
+	section := inidata.Section("default")
+	section.Key("aws_access_key_id").SetValue(aws.ToString(creds.AccessKeyId))
+	section.Key("aws_secret_access_key").SetValue(aws.ToString(creds.SecretAccessKey))
+	section.Key("aws_session_token").SetValue(aws.ToString(creds.SessionToken))
+
+	path := filepath.Join(dir, "credentials")
+	slog.Debug("Writing mountable credentials file", "path", path)
+	if err := saveAtomic(inidata, path); err != nil {
+		return "", fmt.Errorf("failed to write mountable credentials file: %w", err)
+	}
+
+	return dir, nil
+}
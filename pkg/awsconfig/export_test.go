@@ -0,0 +1,96 @@
+package awsconfig
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ini.v1"
+)
+
+func exportTestConfig() *AwsConfig {
+	expiration := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	return &AwsConfig{
+		sessionCreds: &sts.GetSessionTokenOutput{
+			Credentials: &types.Credentials{
+				AccessKeyId:     aws.String("mockAccessKey"),
+				SecretAccessKey: aws.String("mockSecretKey"),
+				SessionToken:    aws.String("mockSessionToken"),
+				Expiration:      &expiration,
+			},
+		},
+	}
+}
+
+func TestExportDockerEnv(t *testing.T) {
+	out, err := exportTestConfig().Export(FormatDockerEnv, false)
+	assert.NoError(t, err)
+	assert.Equal(t, `AWS_ACCESS_KEY_ID=mockAccessKey
+AWS_SECRET_ACCESS_KEY=mockSecretKey
+AWS_SESSION_TOKEN=mockSessionToken
+AWS_CREDENTIAL_EXPIRATION=2030-01-02T03:04:05Z
+`, string(out))
+}
+
+func TestExportDevcontainer(t *testing.T) {
+	out, err := exportTestConfig().Export(FormatDevcontainer, false)
+	assert.NoError(t, err)
+
+	var snippet struct {
+		ContainerEnv map[string]string `json:"containerEnv"`
+		Mounts       []string          `json:"mounts"`
+	}
+	assert.NoError(t, json.Unmarshal(out, &snippet))
+	assert.Equal(t, "mockAccessKey", snippet.ContainerEnv["AWS_ACCESS_KEY_ID"])
+	assert.Equal(t, "mockSessionToken", snippet.ContainerEnv["AWS_SESSION_TOKEN"])
+	assert.Empty(t, snippet.Mounts)
+}
+
+func TestExportMount(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+
+	out, err := exportTestConfig().Export(FormatDevcontainer, true)
+	assert.NoError(t, err)
+
+	var snippet struct {
+		ContainerEnv map[string]string `json:"containerEnv"`
+		Mounts       []string          `json:"mounts"`
+	}
+	assert.NoError(t, json.Unmarshal(out, &snippet))
+	assert.Equal(t, map[string]string{
+		"AWS_SHARED_CREDENTIALS_FILE": "/run/gredentures/credentials",
+		"AWS_PROFILE":                 "default",
+	}, snippet.ContainerEnv)
+	assert.Len(t, snippet.Mounts, 1)
+
+	// The mount source holds a private credentials file with the session keys
+	source := strings.TrimPrefix(strings.Split(snippet.Mounts[0], ",")[0], "source=")
+	info, err := os.Stat(filepath.Join(source, "credentials"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	inidata, err := ini.Load(filepath.Join(source, "credentials"))
+	assert.NoError(t, err)
+	assert.Equal(t, "mockSessionToken", inidata.Section("default").Key("aws_session_token").String())
+
+	out, err = exportTestConfig().Export(FormatDockerEnv, true)
+	assert.NoError(t, err)
+	assert.Contains(t, string(out), ":/run/gredentures:ro\n")
+	assert.Contains(t, string(out), "AWS_SHARED_CREDENTIALS_FILE=/run/gredentures/credentials\n")
+	assert.NotContains(t, string(out), "mockSecretKey")
+}
+
+func TestExportErrors(t *testing.T) {
+	_, err := exportTestConfig().Export("yaml", false)
+	assert.ErrorContains(t, err, `unknown export format "yaml"`)
+
+	_, err = (&AwsConfig{}).Export(FormatDockerEnv, false)
+	assert.ErrorContains(t, err, "no session credentials")
+}
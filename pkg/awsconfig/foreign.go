@@ -0,0 +1,71 @@
+package awsconfig
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// Credential helpers recognised by the fingerprints they leave in the shared files.
+const (
+	ToolAWSVault = "aws-vault" // credential_process running aws-vault in the config file.
+	ToolSAML2AWS = "saml2aws"  // x_principal_arn in the credentials file.
+	ToolAWSSSO   = "aws sso"   // sso_start_url or sso_session in the config file.
+)
+
+// ForeignProfile is a profile another credential helper manages, which gredentures must not
+// overwrite or remove in compatibility mode.
+type ForeignProfile struct {
+	Name string // Profile name, without the "profile " prefix of the config file.
+	Tool string // Helper managing it, one of the Tool constants.
+	File string // Credentials or config file its fingerprint was found in.
+}
+
+// ForeignProfiles returns the profiles of other credential helpers found in the shared
+// credentials file and the shared config file, sorted by name. Missing files hold none.
+func ForeignProfiles(credentialsPath, configPath string) ([]ForeignProfile, error) {
+	credentials, err := ini.LooseLoad(credentialsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", credentialsPath, err)
+	}
+	foreign, err := foreignProfiles(credentials, credentialsPath, configPath)
+	if err != nil {
+		return nil, err
+	}
+	return slices.SortedFunc(maps.Values(foreign), func(a, b ForeignProfile) int { return strings.Compare(a.Name, b.Name) }), nil
+}
+
+// foreignProfiles returns the profiles of other credential helpers keyed by name, from the
+// loaded credentials file at credentialsPath and the config file at configPath. A profile of
+// the credentials file wins over one of the same name in the config file. Sections carrying
+// managedMarker are gredentures' own, whatever keys they share with other helpers.
+func foreignProfiles(credentials *ini.File, credentialsPath, configPath string) (map[string]ForeignProfile, error) {
+	config, err := ini.LooseLoad(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", configPath, err)
+	}
+
+	foreign := map[string]ForeignProfile{}
+	for _, section := range config.Sections() {
+		name, ok := strings.CutPrefix(section.Name(), "profile ")
+		if !ok && section.Name() != defaultSourceProfile {
+			continue // sso-session and services sections are no profiles
+		}
+		switch {
+		case section.HasKey("sso_start_url"), section.HasKey("sso_session"):
+			foreign[name] = ForeignProfile{Name: name, Tool: ToolAWSSSO, File: configPath}
+		case strings.Contains(section.Key("credential_process").String(), "aws-vault"):
+			foreign[name] = ForeignProfile{Name: name, Tool: ToolAWSVault, File: configPath}
+		}
+	}
+	for _, section := range credentials.Sections() {
+		// saml2aws also writes x_security_token_expires, but so does gredentures
+		if section.HasKey("x_principal_arn") && !managedSection(section, nil) {
+			foreign[section.Name()] = ForeignProfile{Name: section.Name(), Tool: ToolSAML2AWS, File: credentialsPath}
+		}
+	}
+	return foreign, nil
+}
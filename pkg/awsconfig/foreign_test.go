@@ -0,0 +1,50 @@
+package awsconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForeignProfiles(t *testing.T) {
+	dir := t.TempDir()
+	credentialsPath := filepath.Join(dir, "credentials")
+	assert.NoError(t, os.WriteFile(credentialsPath, []byte(`[default]
+aws_access_key_id = AKIA
+
+[saml]
+aws_access_key_id = ASIA
+x_principal_arn = arn:aws:sts::123456789012:assumed-role/Admin/me
+`), 0o600))
+	configPath := filepath.Join(dir, "config")
+	assert.NoError(t, os.WriteFile(configPath, []byte(`[profile sso-dev]
+sso_session = work
+
+[profile legacy-sso]
+sso_start_url = https://example.awsapps.com/start
+
+[profile vault]
+credential_process = aws-vault export --format=json vault
+
+[profile plain]
+region = eu-west-1
+
+[sso-session work]
+sso_start_url = https://example.awsapps.com/start
+`), 0o600))
+
+	foreign, err := ForeignProfiles(credentialsPath, configPath)
+	assert.NoError(t, err)
+	assert.Equal(t, []ForeignProfile{
+		{Name: "legacy-sso", Tool: ToolAWSSSO, File: configPath},
+		{Name: "saml", Tool: ToolSAML2AWS, File: credentialsPath},
+		{Name: "sso-dev", Tool: ToolAWSSSO, File: configPath},
+		{Name: "vault", Tool: ToolAWSVault, File: configPath},
+	}, foreign)
+
+	foreign, err = ForeignProfiles(filepath.Join(dir, "missing"), filepath.Join(dir, "missing-config"))
+	assert.NoError(t, err)
+	assert.Empty(t, foreign)
+}
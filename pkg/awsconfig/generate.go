@@ -0,0 +1,255 @@
+package awsconfig
+
+import (
+	"cmp"
+	"fmt"
+	"gredentures/pkg/appconfig"
+	"log/slog"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// generatedSessionProfile names the session profile of GenerateConfig when the configured
+// one is a template that can only be evaluated at login.
+const generatedSessionProfile = "gredentures"
+
+// stanza is a profile of the shared config file, or a note about a left out org when name
+// is empty.
+type stanza struct {
+	name     string      // Profile name, without the "profile " prefix.
+	comment  string      // Comment above the profile.
+	settings [][2]string // Keys and values of the profile, in order.
+}
+
+// GenerateConfig returns ~/.aws/config stanzas for the MFA session and every configured org
+// and recipe, for "gredentures generate aws-config". An AWS SDK reading them gets the session
+// from gredentures through credential_process and assumes the role of each org itself, with
+// role_arn and the session as source_profile. Recipes run gredentures for the whole role
+// chain instead, as they may start from other long-lived keys and device. executable is the
+// gredentures binary to run.
+func GenerateConfig(app appconfig.AppConfig, executable string) string {
+	var buf strings.Builder
+	buf.WriteString("# Generated by gredentures generate aws-config, paste into ~/.aws/config.\n")
+	buf.WriteString("# Profiles of the same name in ~/.aws/credentials take precedence over these.\n")
+	for _, s := range generateStanzas(app, executable) {
+		if s.name == "" {
+			fmt.Fprintf(&buf, "\n# %s\n", s.comment)
+			continue
+		}
+		writeStanza(&buf, s)
+	}
+	return buf.String()
+}
+
+// generateStanzas returns the profiles of GenerateConfig, in the order they are written.
+func generateStanzas(app appconfig.AppConfig, executable string) []stanza {
+	session, err := appconfig.RenderProfile(app.Profile, app.SessionProfileData())
+	if err != nil || session == "" {
+		session = generatedSessionProfile
+	}
+	// Every profile inherits the region of the long-lived keys its session starts from
+	sourceProfile, sourceFile := app.Source()
+	sourceProfile, sourceFile = cmp.Or(sourceProfile, defaultSourceProfile), cmp.Or(sourceFile, homeCredentialsPath())
+	sessionRegion := cmp.Or(SourceRegion(sourceProfile, sourceFile, app.Partition()), PartitionRegion(app.Partition()))
+	sessionSettings := [][2]string{{"credential_process", credentialProcess(app, executable)}}
+	if sessionRegion != "" {
+		sessionSettings = append(sessionSettings, [2]string{"region", sessionRegion})
+	}
+	stanzas := []stanza{{session, "MFA session", sessionSettings}}
+
+	names := make([]string, 0, len(app.Orgs))
+	for name := range app.Orgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		org := app.Orgs[name]
+		if org.RoleArn == "" {
+			stanzas = append(stanzas, stanza{comment: fmt.Sprintf("Org %s has no RoleArn and is left out.", name)})
+			continue
+		}
+		profile := org.ProfileName(name)
+		if strings.Contains(profile, "{{") {
+			profile = name + "-mfa" // A template that does not render
+		}
+
+		// Other long-lived keys make a session of their own, selected with --org, as does every
+		// org in another partition, which cannot be reached from the main session
+		source := session
+		region := sessionRegion
+		if org.SourceProfile != "" || org.SourceFile != "" || org.PartitionName() != app.Partition() {
+			source = name + "-session"
+			region = cmp.Or(SourceRegion(cmp.Or(org.SourceProfile, sourceProfile), cmp.Or(org.SourceFile, sourceFile), org.PartitionName()),
+				PartitionRegion(org.PartitionName()))
+			sessionSettings := [][2]string{{"credential_process", credentialProcess(app, executable, "--org", name)}}
+			if region != "" {
+				sessionSettings = append(sessionSettings, [2]string{"region", region})
+			}
+			stanzas = append(stanzas, stanza{source, "MFA session of org " + name, sessionSettings})
+		}
+		settings := [][2]string{{"role_arn", org.RoleArn}, {"source_profile", source}}
+		if region != "" {
+			settings = append(settings, [2]string{"region", region})
+		}
+		if org.Timeout > 0 {
+			settings = append(settings, [2]string{"duration_seconds", fmt.Sprint(org.Timeout)})
+		}
+		if externalID := cmp.Or(org.ExternalID, app.ExternalID); externalID != "" {
+			settings = append(settings, [2]string{"external_id", externalID})
+		}
+		stanzas = append(stanzas, stanza{profile, "Org " + name, settings})
+	}
+
+	names = names[:0]
+	for name := range app.Recipes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		recipe := app.Recipes[name]
+		settings := [][2]string{{"credential_process", credentialProcess(app, executable, "login", name)}}
+		if region := cmp.Or(recipe.Region, sessionRegion); region != "" {
+			settings = append(settings, [2]string{"region", region})
+		}
+		stanzas = append(stanzas, stanza{recipe.ProfileName(name), "Recipe " + name, settings})
+	}
+	return stanzas
+}
+
+// writeStanza writes the profile section of s with its comment and settings.
+func writeStanza(buf *strings.Builder, s stanza) {
+	fmt.Fprintf(buf, "\n# %s\n[profile %s]\n", s.comment, s.name)
+	for _, setting := range s.settings {
+		fmt.Fprintf(buf, "%s = %s\n", setting[0], setting[1])
+	}
+}
+
+// WriteGeneratedConfig merges the profiles of GenerateConfig into the shared config file at
+// path, for "gredentures generate aws-config --write", and returns their names. Each is
+// written below managedMarker, and the marked profiles of an earlier run are replaced, so
+// profiles of orgs dropped from the config file go away. Everything else in the file is kept
+// as it is, byte for byte, since the AWS CLI allows nested settings no INI library preserves.
+// A profile of the same name that gredentures does not manage is only replaced with force.
+func WriteGeneratedConfig(app appconfig.AppConfig, executable, path string, force bool) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	stanzas := generateStanzas(app, executable)
+	var names []string
+	for _, s := range stanzas {
+		if s.name != "" {
+			names = append(names, s.name)
+		}
+	}
+
+	// Split the file into the lines before the first section and a block per section, each
+	// starting with the comments right above its header
+	var head []string
+	var blocks [][]string
+	var pending []string
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]"):
+			blocks = append(blocks, append(pending, line))
+			pending = nil
+		case strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";"):
+			pending = append(pending, line)
+		case len(blocks) == 0:
+			head = append(head, pending...)
+			head = append(head, line)
+			pending = nil
+		default:
+			blocks[len(blocks)-1] = append(blocks[len(blocks)-1], pending...)
+			blocks[len(blocks)-1] = append(blocks[len(blocks)-1], line)
+			pending = nil
+		}
+	}
+
+	var out strings.Builder
+	for _, line := range head {
+		out.WriteString(line)
+	}
+	for _, block := range blocks {
+		managed, header := false, ""
+		for _, line := range block {
+			trimmed := strings.TrimSpace(line)
+			if trimmed == managedMarker {
+				managed = true
+			}
+			if strings.HasPrefix(trimmed, "[") {
+				header = strings.TrimSpace(strings.TrimPrefix(strings.Trim(trimmed, "[]"), "profile "))
+			}
+		}
+		if !managed && slices.Contains(names, header) {
+			if !force {
+				return nil, fmt.Errorf("profile %s in %s is not managed by gredentures, give it another name, remove it or pass --force", header, path)
+			}
+			slog.Warn("Replacing a profile gredentures does not manage", "profile", header, "path", path)
+			managed = true
+		}
+		if managed {
+			slog.Debug("Replacing managed profile", "profile", header, "path", path)
+			continue
+		}
+		for _, line := range block {
+			out.WriteString(line)
+		}
+	}
+	for _, line := range pending {
+		out.WriteString(line)
+	}
+
+	// Keep a blank line between the kept content and the generated profiles
+	text := strings.TrimRight(out.String(), "\n")
+	out.Reset()
+	if text != "" {
+		out.WriteString(text + "\n")
+	}
+	for _, s := range stanzas {
+		if s.name == "" {
+			continue
+		}
+		var buf strings.Builder
+		writeStanza(&buf, stanza{s.name, s.comment + "\n" + managedMarker, s.settings})
+		if out.Len() == 0 {
+			out.WriteString(strings.TrimPrefix(buf.String(), "\n"))
+			continue
+		}
+		out.WriteString(buf.String())
+	}
+
+	if err := saveAtomic(strings.NewReader(out.String()), path); err != nil {
+		return nil, fmt.Errorf("failed to save %s: %w", path, err)
+	}
+	return names, nil
+}
+
+// credentialProcess returns the command line printing the credentials of app, with args
+// before the options. An explicit config file and --no-mfa are passed on, everything else is
+// read from the config file when the SDK runs it.
+func credentialProcess(app appconfig.AppConfig, executable string, args ...string) string {
+	words := append([]string{quoteWord(executable)}, args...)
+	if path, origin := app.ConfigPath(); origin == appconfig.ConfigOriginFlag {
+		words = append(words, "--config", quoteWord(path))
+	}
+	if app.NoMFA {
+		words = append(words, "--no-mfa")
+	}
+	return strings.Join(append(words, "--quiet", "--output", appconfig.OutputCredentialProcess), " ")
+}
+
+// quoteWord double-quotes a word of credential_process holding spaces or quotes. The AWS CLI
+// splits the setting like a POSIX shell, the Go SDK hands it to one.
+func quoteWord(word string) string {
+	if !strings.ContainsAny(word, " \t\"'\\$`") {
+		return word
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`").Replace(word) + `"`
+}
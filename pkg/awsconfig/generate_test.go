@@ -0,0 +1,198 @@
+package awsconfig
+
+import (
+	"gredentures/pkg/appconfig"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateConfig(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("AWS_CONFIG_FILE", "")
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "")
+
+	app := appconfig.AppConfig{
+		Profile: "default-mfa",
+		Orgs: map[string]appconfig.OrgConfig{
+			"prod":    {RoleArn: "arn:aws:iam::111111111111:role/Admin", Timeout: 3600, ExternalID: "vendor-42"},
+			"staging": {RoleArn: "arn:aws:iam::222222222222:role/Admin", Profile: "stage-{{.AccountAlias}}", SourceProfile: "staging-keys"},
+			"broken":  {Profile: "broken"},
+			"gov":     {RoleArn: "arn:aws-us-gov:iam::333333333333:role/Admin", SourceProfile: "gov-keys"},
+		},
+		Recipes: map[string]appconfig.RecipeConfig{
+			"prod-admin": {Roles: []string{"arn:aws:iam::111111111111:role/Admin"}, Region: "eu-west-1"},
+		},
+	}
+
+	assert.Equal(t, `# Generated by gredentures generate aws-config, paste into ~/.aws/config.
+# Profiles of the same name in ~/.aws/credentials take precedence over these.
+
+# MFA session
+[profile default-mfa]
+credential_process = /usr/local/bin/gredentures --quiet --output credential-process
+
+# Org broken has no RoleArn and is left out.
+
+# MFA session of org gov
+[profile gov-session]
+credential_process = /usr/local/bin/gredentures --org gov --quiet --output credential-process
+region = us-gov-west-1
+
+# Org gov
+[profile gov-mfa]
+role_arn = arn:aws-us-gov:iam::333333333333:role/Admin
+source_profile = gov-session
+region = us-gov-west-1
+
+# Org prod
+[profile prod-mfa]
+role_arn = arn:aws:iam::111111111111:role/Admin
+source_profile = default-mfa
+duration_seconds = 3600
+external_id = vendor-42
+
+# MFA session of org staging
+[profile staging-session]
+credential_process = /usr/local/bin/gredentures --org staging --quiet --output credential-process
+
+# Org staging
+[profile stage-222222222222]
+role_arn = arn:aws:iam::222222222222:role/Admin
+source_profile = staging-session
+
+# Recipe prod-admin
+[profile prod-admin]
+credential_process = /usr/local/bin/gredentures login prod-admin --quiet --output credential-process
+region = eu-west-1
+`, GenerateConfig(app, "/usr/local/bin/gredentures"))
+
+	t.Run("Passes on an explicit config file and --no-mfa", func(t *testing.T) {
+		config := filepath.Join(t.TempDir(), "my config.yml")
+		app := appconfig.AppConfig{Profile: "{{.AccountAlias}}-mfa", Config: config, NoMFA: true}
+		assert.Contains(t, GenerateConfig(app, "/opt/My Tools/gredentures"), `
+# MFA session
+[profile gredentures]
+credential_process = "/opt/My Tools/gredentures" --config "`+config+`" --no-mfa --quiet --output credential-process
+`)
+	})
+
+	t.Run("Inherits the region of the source profile", func(t *testing.T) {
+		t.Setenv("AWS_REGION", "eu-west-1")
+		app := appconfig.AppConfig{
+			Profile: "default-mfa",
+			Orgs: map[string]appconfig.OrgConfig{
+				"prod": {RoleArn: "arn:aws:iam::111111111111:role/Admin"},
+				"gov":  {RoleArn: "arn:aws-us-gov:iam::333333333333:role/Admin"},
+			},
+			Recipes: map[string]appconfig.RecipeConfig{"admin": {}},
+		}
+		generated := GenerateConfig(app, "gredentures")
+		assert.Contains(t, generated, "[profile default-mfa]\ncredential_process = gredentures --quiet --output credential-process\nregion = eu-west-1\n")
+		assert.Contains(t, generated, "[profile prod-mfa]\nrole_arn = arn:aws:iam::111111111111:role/Admin\nsource_profile = default-mfa\nregion = eu-west-1\n")
+		assert.Contains(t, generated, "[profile admin]\ncredential_process = gredentures login admin --quiet --output credential-process\nregion = eu-west-1\n")
+		assert.Contains(t, generated, "[profile gov-mfa]\nrole_arn = arn:aws-us-gov:iam::333333333333:role/Admin\nsource_profile = gov-session\nregion = us-gov-west-1\n",
+			"orgs of another partition keep the region of theirs")
+	})
+}
+
+func TestQuoteWord(t *testing.T) {
+	assert.Equal(t, "/usr/bin/gredentures", quoteWord("/usr/bin/gredentures"))
+	assert.Equal(t, `"C:\\Program Files\\gredentures.exe"`, quoteWord(`C:\Program Files\gredentures.exe`))
+	assert.Equal(t, `"/tmp/a \"b\" \$c"`, quoteWord(`/tmp/a "b" $c`))
+}
+
+func TestWriteGeneratedConfig(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("AWS_CONFIG_FILE", "")
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "")
+
+	path := filepath.Join(t.TempDir(), "config")
+	require.NoError(t, os.WriteFile(path, []byte(`# My AWS config
+[default]
+region = eu-west-1
+
+# Uploads
+[profile data]
+s3 =
+    max_concurrent_requests = 20
+credential_process = "/opt/my tool" --profile data
+
+# Org old
+# gredentures:managed
+[profile old-mfa]
+role_arn = arn:aws:iam::999999999999:role/Admin
+source_profile = default-mfa
+`), 0o600))
+
+	app := appconfig.AppConfig{
+		Profile: "default-mfa",
+		Orgs:    map[string]appconfig.OrgConfig{"prod": {RoleArn: "arn:aws:iam::111111111111:role/Admin"}},
+	}
+	want := `# My AWS config
+[default]
+region = eu-west-1
+
+# Uploads
+[profile data]
+s3 =
+    max_concurrent_requests = 20
+credential_process = "/opt/my tool" --profile data
+
+# MFA session
+# gredentures:managed
+[profile default-mfa]
+credential_process = gredentures --quiet --output credential-process
+
+# Org prod
+# gredentures:managed
+[profile prod-mfa]
+role_arn = arn:aws:iam::111111111111:role/Admin
+source_profile = default-mfa
+`
+
+	names, err := WriteGeneratedConfig(app, "gredentures", path, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"default-mfa", "prod-mfa"}, names)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, want, string(data), "the other profiles are kept as they are and the dropped org is removed")
+
+	_, err = WriteGeneratedConfig(app, "gredentures", path, false)
+	require.NoError(t, err)
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, want, string(data), "writing again changes nothing")
+
+	t.Run("Creates the file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "aws", "config")
+		_, err := WriteGeneratedConfig(appconfig.AppConfig{Profile: "default-mfa"}, "gredentures", path, false)
+		require.NoError(t, err)
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, "# MFA session\n# gredentures:managed\n[profile default-mfa]\ncredential_process = gredentures --quiet --output credential-process\n", string(data))
+	})
+
+	t.Run("Replaces a profile of the user only with force", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config")
+		require.NoError(t, os.WriteFile(path, []byte("[profile prod-mfa]\nregion = us-east-1\n"), 0o600))
+
+		_, err := WriteGeneratedConfig(app, "gredentures", path, false)
+		assert.ErrorContains(t, err, "profile prod-mfa")
+		data, _ := os.ReadFile(path)
+		assert.Equal(t, "[profile prod-mfa]\nregion = us-east-1\n", string(data))
+
+		_, err = WriteGeneratedConfig(app, "gredentures", path, true)
+		require.NoError(t, err)
+		data, _ = os.ReadFile(path)
+		assert.NotContains(t, string(data), "us-east-1")
+		assert.Contains(t, string(data), "[profile prod-mfa]\nrole_arn")
+	})
+}
@@ -0,0 +1,75 @@
+package awsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+)
+
+// httpOptions returns the SDK load options sending requests through the configured proxy and
+// trusting the configured CA bundle, or none when neither is set. Without them the SDK uses
+// HTTPS_PROXY, NO_PROXY and AWS_CA_BUNDLE from the environment as usual.
+func (conf *AwsConfig) httpOptions() ([]func(*config.LoadOptions) error, error) {
+	if conf.proxy == "" && conf.caBundle == "" {
+		return nil, nil
+	}
+
+	var proxy *url.URL
+	if conf.proxy != "" {
+		parsed, err := url.Parse(conf.proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy %q: %w", conf.proxy, err)
+		}
+		proxy = parsed
+		slog.Debug("Sending AWS requests through proxy", "proxy", parsed.Redacted())
+	}
+
+	var roots *x509.CertPool
+	if conf.caBundle != "" {
+		pool, err := caBundlePool(conf.caBundle)
+		if err != nil {
+			return nil, err
+		}
+		roots = pool
+	}
+
+	client := awshttp.NewBuildableClient().WithTransportOptions(func(tr *http.Transport) {
+		if proxy != nil {
+			tr.Proxy = http.ProxyURL(proxy)
+		}
+		if roots != nil {
+			if tr.TLSClientConfig == nil {
+				tr.TLSClientConfig = &tls.Config{}
+			}
+			tr.TLSClientConfig.RootCAs = roots
+		}
+	})
+	return []func(*config.LoadOptions) error{config.WithHTTPClient(client)}, nil
+}
+
+// caBundlePool returns the system CA certificates together with those of the PEM file at
+// path. Unlike AWS_CA_BUNDLE, which replaces the system certificates, hosts that are not
+// intercepted keep working.
+func caBundlePool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		slog.Debug("System CA certificates unavailable, trusting the CA bundle only", "error", err)
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("CA bundle %s holds no PEM certificates", path)
+	}
+	slog.Debug("Trusting CA bundle", "path", path)
+	return pool, nil
+}
@@ -0,0 +1,209 @@
+package awsconfig
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gredentures/pkg/secret"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// traceHeaders lists the headers whose values --debug-http replaces with secret.Redacted,
+// as they carry signatures, session tokens or cookies.
+var traceHeaders = []string{"Authorization", "X-Amz-Security-Token", "Cookie", "Set-Cookie"}
+
+// traceParams lists the form parameters whose values --debug-http keeps, as they name the
+// call. All others, such as TokenCode and Policy, are only listed by name.
+var traceParams = []string{"Action", "Version", "DurationSeconds", "RoleArn", "RoleSessionName", "SerialNumber"}
+
+// httpTracer writes a sanitized trace of every AWS request and response to a file.
+type httpTracer struct {
+	mu  sync.Mutex
+	out io.Writer
+	now func() time.Time
+}
+
+// tracer is the tracer installed by TraceHTTP, nil when --debug-http is not given.
+var tracer *httpTracer
+
+// TraceHTTP writes a sanitized trace of every STS, IAM and Organizations call made from now
+// on to the file at path, for gredentures --debug-http. The file is replaced and only
+// readable by the user. Signatures, session tokens and request and response bodies never
+// appear in it, so it can be attached to a support ticket as it is.
+func TraceHTTP(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create trace directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open trace file: %w", err)
+	}
+	tracer = &httpTracer{out: file, now: time.Now}
+	return nil
+}
+
+// traceOptions adds the middleware of the installed tracer to every SDK client.
+func traceOptions() []func(*config.LoadOptions) error {
+	if tracer == nil {
+		return nil
+	}
+	return []func(*config.LoadOptions) error{config.WithAPIOptions([]func(*middleware.Stack) error{tracer.addMiddleware})}
+}
+
+// addMiddleware adds the tracer at the end of the deserialize step, next to the transport.
+// It sees every retry attempt, the signed request and the response before it is parsed.
+func (t *httpTracer) addMiddleware(stack *middleware.Stack) error {
+	return stack.Deserialize.Add(middleware.DeserializeMiddlewareFunc("GredenturesHTTPTrace", t.handle), middleware.After)
+}
+
+// handle passes the request on and writes the trace entry of both.
+func (t *httpTracer) handle(ctx context.Context, in middleware.DeserializeInput, next middleware.DeserializeHandler) (
+	middleware.DeserializeOutput, middleware.Metadata, error,
+) {
+	req, ok := in.Request.(*smithyhttp.Request)
+	if !ok {
+		return next.HandleDeserialize(ctx, in)
+	}
+	var entry strings.Builder
+	started := t.now()
+	fmt.Fprintf(&entry, "=== %s %s.%s\n", started.UTC().Format(time.RFC3339Nano),
+		awsmiddleware.GetServiceID(ctx), awsmiddleware.GetOperationName(ctx))
+	fmt.Fprintf(&entry, "> %s %s\n", req.Method, redactSDKLog(req.URL.String()))
+	writeTraceHeaders(&entry, ">", req.Header)
+	fmt.Fprintf(&entry, "> Body: %s\n", summarizeRequestBody(req))
+
+	out, metadata, err := next.HandleDeserialize(ctx, in)
+	elapsed := t.now().Sub(started).Round(time.Millisecond)
+	resp, ok := out.RawResponse.(*smithyhttp.Response)
+	switch {
+	case ok && resp != nil && resp.Response != nil && resp.StatusCode != 0:
+		fmt.Fprintf(&entry, "< %s in %s\n", resp.Status, elapsed)
+		writeTraceHeaders(&entry, "<", resp.Header)
+		fmt.Fprintf(&entry, "< Body: %s\n", summarizeResponseBody(resp))
+	case err != nil:
+		// No response arrived, e.g. the proxy or endpoint could not be reached
+		fmt.Fprintf(&entry, "! %s after %s\n", redactSDKLog(err.Error()), elapsed)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintln(t.out, entry.String())
+	return out, metadata, err
+}
+
+// writeTraceHeaders writes headers sorted by name, with the values of traceHeaders redacted.
+func writeTraceHeaders(entry *strings.Builder, prefix string, header http.Header) {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		value := strings.Join(header.Values(name), ", ")
+		if slices.ContainsFunc(traceHeaders, func(h string) bool { return strings.EqualFold(h, name) }) {
+			value = secret.Redacted
+		}
+		fmt.Fprintf(entry, "%s %s: %s\n", prefix, name, value)
+	}
+}
+
+// summarizeRequestBody describes the request body without its secrets: its length and, for
+// the form bodies of STS and IAM, the values of traceParams and the names of all others.
+func summarizeRequestBody(req *smithyhttp.Request) string {
+	if req.GetStream() == nil {
+		return "empty"
+	}
+	if !req.IsStreamSeekable() {
+		return "not captured"
+	}
+	body, err := io.ReadAll(req.GetStream())
+	if rewindErr := req.RewindStream(); err == nil {
+		err = rewindErr
+	}
+	if err != nil {
+		return fmt.Sprintf("not captured: %v", err)
+	}
+
+	summary := fmt.Sprintf("%d bytes", len(body))
+	if !strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		return summary
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return summary
+	}
+	var kept, withheld []string
+	for name := range form {
+		if slices.Contains(traceParams, name) {
+			kept = append(kept, name+"="+form.Get(name))
+		} else {
+			withheld = append(withheld, name)
+		}
+	}
+	slices.Sort(kept)
+	slices.Sort(withheld)
+	if len(kept) > 0 {
+		summary += ", " + strings.Join(kept, " ")
+	}
+	if len(withheld) > 0 {
+		summary += ", withheld " + strings.Join(withheld, " ")
+	}
+	return summary
+}
+
+// traceError holds the fields of an AWS error response, XML or JSON, that explain a failure.
+type traceError struct {
+	Code    string `xml:"Error>Code" json:"-"`
+	Message string `xml:"Error>Message" json:"-"`
+	Type    string `xml:"-" json:"__type"`
+	Text    string `xml:"-" json:"message"`
+}
+
+// summarizeResponseBody describes the response body without the credentials it may hold: its
+// length, the root element of XML responses and the code and message of errors. The body is
+// put back for the SDK to parse.
+func summarizeResponseBody(resp *smithyhttp.Response) string {
+	if resp.Body == nil {
+		return "empty"
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return fmt.Sprintf("not captured: %v", err)
+	}
+
+	summary := fmt.Sprintf("%d bytes", len(body))
+	var parsed traceError
+	switch trimmed := bytes.TrimSpace(body); {
+	case bytes.HasPrefix(trimmed, []byte("<")):
+		var root struct{ XMLName xml.Name }
+		if xml.Unmarshal(trimmed, &root) == nil {
+			summary += ", " + root.XMLName.Local
+		}
+		_ = xml.Unmarshal(trimmed, &parsed)
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		_ = json.Unmarshal(trimmed, &parsed)
+		parsed.Code, parsed.Message = parsed.Type, parsed.Text
+	}
+	if resp.StatusCode >= 300 && parsed.Code != "" {
+		summary += fmt.Sprintf(", error %s: %s", parsed.Code, parsed.Message)
+	}
+	return summary
+}
@@ -0,0 +1,133 @@
+package awsconfig
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/stretchr/testify/assert"
+)
+
+const traceSessionResponse = `<GetSessionTokenResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <GetSessionTokenResult>
+    <Credentials>
+      <AccessKeyId>ASIASESSIONKEY</AccessKeyId>
+      <SecretAccessKey>sessionSecretKey</SecretAccessKey>
+      <SessionToken>sessionToken</SessionToken>
+      <Expiration>2030-01-01T00:00:00Z</Expiration>
+    </Credentials>
+  </GetSessionTokenResult>
+</GetSessionTokenResponse>`
+
+const traceErrorResponse = `<ErrorResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <Error><Type>Sender</Type><Code>AccessDenied</Code><Message>MultiFactorAuthentication failed with invalid MFA one time pass code.</Message></Error>
+  <RequestId>req-2</RequestId>
+</ErrorResponse>`
+
+func TestTraceHTTP(t *testing.T) {
+	defer func() { tracer = nil }()
+	assert.Empty(t, traceOptions(), "nothing is traced without --debug-http")
+
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("X-Amzn-Requestid", "req-1")
+		w.Header().Set("Content-Type", "text/xml")
+		if calls > 1 {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(traceErrorResponse))
+			return
+		}
+		w.Write([]byte(traceSessionResponse))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "traces", "http.log")
+	assert.NoError(t, TraceHTTP(path))
+	opts := append(traceOptions(),
+		config.WithRegion("us-west-2"),
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider("AKIALONGLIVED", "longLivedSecret", "")),
+	)
+	cfg, err := config.LoadDefaultConfig(context.Background(), opts...)
+	assert.NoError(t, err)
+	client := sts.NewFromConfig(cfg, func(o *sts.Options) { o.BaseEndpoint = aws.String(server.URL) })
+
+	input := &sts.GetSessionTokenInput{
+		DurationSeconds: aws.Int32(3600),
+		SerialNumber:    aws.String("arn:aws:iam::123456789012:mfa/alice"),
+		TokenCode:       aws.String("654321"),
+	}
+	out, err := client.GetSessionToken(context.Background(), input)
+	assert.NoError(t, err)
+	assert.Equal(t, "sessionToken", aws.ToString(out.Credentials.SessionToken), "the SDK still parses the response")
+	_, err = client.GetSessionToken(context.Background(), input)
+	assert.Error(t, err)
+
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	trace := string(data)
+
+	assert.Contains(t, trace, " STS.GetSessionToken\n")
+	assert.Contains(t, trace, "> POST "+server.URL)
+	assert.Contains(t, trace, "> Authorization: <redacted>\n")
+	assert.Contains(t, trace, "Action=GetSessionToken DurationSeconds=3600 SerialNumber=arn:aws:iam::123456789012:mfa/alice Version=2011-06-15, withheld TokenCode\n")
+	assert.Contains(t, trace, "< 200 OK in ")
+	assert.Contains(t, trace, "< X-Amzn-Requestid: req-1\n")
+	assert.Contains(t, trace, "bytes, GetSessionTokenResponse\n")
+	assert.Contains(t, trace, "< 403 Forbidden in ")
+	assert.Contains(t, trace, "ErrorResponse, error AccessDenied: MultiFactorAuthentication failed with invalid MFA one time pass code.\n")
+	for _, secret := range []string{"654321", "AKIALONGLIVED", "longLivedSecret", "ASIASESSIONKEY", "sessionSecretKey", "sessionToken"} {
+		assert.NotContains(t, trace, secret)
+	}
+
+	t.Run("Reports failed requests", func(t *testing.T) {
+		server.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, err := client.GetSessionToken(ctx, input, func(o *sts.Options) { o.RetryMaxAttempts = 1 })
+		assert.Error(t, err)
+
+		data, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.Contains(t, string(data), "\n! request send failed, ")
+	})
+}
+
+func TestSummarizeResponseBody(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		expected string
+	}{
+		{name: "Empty", status: 200, body: "", expected: "0 bytes"},
+		{name: "XML result", status: 200, body: traceSessionResponse, expected: ", GetSessionTokenResponse"},
+		{name: "JSON error", status: 400, body: `{"__type":"AccessDeniedException","message":"not allowed"}`, expected: ", error AccessDeniedException: not allowed"},
+		{name: "JSON result", status: 200, body: `{"Accounts":[]}`, expected: "15 bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &smithyhttp.Response{Response: &http.Response{StatusCode: tt.status, Body: io.NopCloser(strings.NewReader(tt.body))}}
+			summary := summarizeResponseBody(resp)
+			assert.True(t, strings.HasSuffix(summary, tt.expected), summary)
+
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.body, string(body), "the body is put back")
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package awsconfig
+
+import (
+	"context"
+	"fmt"
+
+	"gredentures/pkg/interrupt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// SourceIdentity returns the ARN the long-lived source credentials belong to, confirming
+// that STS accepts them.
+func (conf *AwsConfig) SourceIdentity() (string, error) {
+	config, err := conf.sourceAccount()
+	if err != nil {
+		return "", fmt.Errorf("failed to get default account: %w", err)
+	}
+	return callerIdentity(interrupt.Context(), conf.stsClient(config))
+}
+
+// ProfileIdentity returns the ARN the credentials of profile in the shared credentials file
+// belong to. Expired session credentials are reported as ErrExpiredToken.
+func (conf *AwsConfig) ProfileIdentity(profile string) (string, error) {
+	httpOpts, err := conf.loadOptions()
+	if err != nil {
+		return "", err
+	}
+	config, err := getAccount(profile, []string{CredentialsPath()}, httpOpts)
+	if err != nil {
+		return "", err
+	}
+	return callerIdentity(interrupt.Context(), conf.stsClient(config))
+}
+
+// callerIdentity asks STS who the credentials of client belong to.
+func callerIdentity(ctx context.Context, client stsAPI) (string, error) {
+	identity, err := client.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get caller identity: %w", classifySTSError(err))
+	}
+	return aws.ToString(identity.Arn), nil
+}
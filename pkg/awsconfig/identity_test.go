@@ -0,0 +1,36 @@
+package awsconfig
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCallerIdentity(t *testing.T) {
+	t.Run("Returns the caller ARN", func(t *testing.T) {
+		mockSTS := &MockSTSClient{
+			GetCallerIdentityFunc: func(ctx context.Context, params *sts.GetCallerIdentityInput, optFns ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error) {
+				return &sts.GetCallerIdentityOutput{Arn: aws.String("arn:aws:iam::123456789012:user/me")}, nil
+			},
+		}
+
+		arn, err := callerIdentity(context.TODO(), mockSTS)
+		assert.NoError(t, err)
+		assert.Equal(t, "arn:aws:iam::123456789012:user/me", arn)
+	})
+
+	t.Run("Classifies expired sessions", func(t *testing.T) {
+		mockSTS := &MockSTSClient{
+			GetCallerIdentityFunc: func(ctx context.Context, params *sts.GetCallerIdentityInput, optFns ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error) {
+				return nil, &smithy.GenericAPIError{Code: "ExpiredToken", Message: "The security token included in the request is expired"}
+			},
+		}
+
+		_, err := callerIdentity(context.TODO(), mockSTS)
+		assert.ErrorIs(t, err, ErrExpiredToken)
+	})
+}
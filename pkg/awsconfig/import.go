@@ -0,0 +1,105 @@
+package awsconfig
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+)
+
+// importExportPrefixes start the variable assignments the AWS access portal offers for
+// macOS and Linux, Windows cmd and PowerShell.
+var importExportPrefixes = []string{"export ", "set ", "$env:"}
+
+// ParseImport reads temporary credentials pasted from the AWS access portal, either the
+// "Add a profile to your AWS credentials file" block or its export, set or $Env: lines. Keys
+// are matched without regard to case, so aws_access_key_id and AWS_ACCESS_KEY_ID are the same,
+// and lines that are neither are skipped. The profile is named after the pasted section, if
+// any, and carries an expiry only when the paste includes one.
+func ParseImport(text string) (Profile, error) {
+	var profile Profile
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			if profile.Name != "" {
+				return Profile{}, fmt.Errorf("the pasted text holds several profiles, import them one at a time")
+			}
+			profile.Name = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+
+		for _, prefix := range importExportPrefixes {
+			if len(line) > len(prefix) && strings.EqualFold(line[:len(prefix)], prefix) {
+				line = line[len(prefix):]
+				break
+			}
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			slog.Debug("Skipping pasted line without a value")
+			continue
+		}
+		value = unquote(strings.TrimSpace(value))
+
+		switch key := strings.ToLower(strings.TrimSpace(key)); {
+		case key == "aws_access_key_id":
+			profile.Credentials.AccessKeyID = value
+		case key == "aws_secret_access_key":
+			profile.Credentials.SecretAccessKey = value
+		case key == "aws_session_token", key == "aws_security_token":
+			profile.Credentials.SessionToken = value
+		case key == "region", key == "aws_region", key == "aws_default_region":
+			profile.Region = value
+		case slices.Contains(expiryKeys, key):
+			expires, err := parseExpiry(value)
+			if err != nil {
+				return Profile{}, fmt.Errorf("invalid expiry %q: %w", value, err)
+			}
+			profile.Credentials.CanExpire, profile.Credentials.Expires = true, expires
+		default:
+			slog.Debug("Skipping unknown pasted key", "key", key)
+		}
+	}
+
+	switch {
+	case profile.Credentials.AccessKeyID == "" && profile.Credentials.SecretAccessKey == "":
+		return Profile{}, fmt.Errorf("no credentials found, paste the credentials block or the export lines of the AWS access portal")
+	case profile.Credentials.AccessKeyID == "" || profile.Credentials.SecretAccessKey == "":
+		return Profile{}, fmt.Errorf("the pasted credentials need both an access key ID and a secret access key")
+	case profile.Credentials.SessionToken == "":
+		return Profile{}, fmt.Errorf("the pasted credentials have no session token, only temporary credentials can be imported")
+	}
+	return profile, nil
+}
+
+// ImportProfile writes profile to the shared credentials file at path, replacing any profile
+// of the same name and keeping every other one. Credentials without an expiry of their own
+// expire after lifetime, so their expiry is tracked like that of the sessions gredentures
+// requests itself.
+func ImportProfile(path string, profile Profile, lifetime time.Duration, now time.Time) (Profile, error) {
+	if !profile.Credentials.CanExpire {
+		profile.Credentials.CanExpire, profile.Credentials.Expires = true, now.Add(lifetime).UTC()
+	}
+	if !profile.Credentials.Expires.After(now) {
+		return Profile{}, fmt.Errorf("the pasted credentials expired at %s", profile.Credentials.Expires.Format(time.RFC3339))
+	}
+
+	// The profile was named to import into, so long-lived keys in it are replaced as asked
+	writer := &SharedCredentialsWriter{Path: path, Merge: true, Unmanaged: true}
+	if err := writer.WriteCredentials(CredentialSet{Session: profile, Force: true}); err != nil {
+		return Profile{}, err
+	}
+	return profile, nil
+}
+
+// unquote removes one pair of matching single or double quotes around value.
+func unquote(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
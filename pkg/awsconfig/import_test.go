@@ -0,0 +1,129 @@
+package awsconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ini.v1"
+)
+
+func TestParseImport(t *testing.T) {
+	t.Run("Credentials file block", func(t *testing.T) {
+		profile, err := ParseImport(`
+[123456789012_AdministratorAccess]
+aws_access_key_id=ASIAEXAMPLE
+aws_secret_access_key=secret
+aws_session_token=token
+`)
+		assert.NoError(t, err)
+		assert.Equal(t, "123456789012_AdministratorAccess", profile.Name)
+		assert.Equal(t, "ASIAEXAMPLE", profile.Credentials.AccessKeyID)
+		assert.Equal(t, "secret", profile.Credentials.SecretAccessKey)
+		assert.Equal(t, "token", profile.Credentials.SessionToken)
+		assert.False(t, profile.Credentials.CanExpire)
+	})
+
+	t.Run("Export lines", func(t *testing.T) {
+		profile, err := ParseImport(`export AWS_ACCESS_KEY_ID="ASIAEXAMPLE"
+export AWS_SECRET_ACCESS_KEY='secret'
+export AWS_SESSION_TOKEN="token"
+export AWS_CREDENTIAL_EXPIRATION=2026-01-02T03:04:05Z
+export AWS_REGION=eu-west-1
+`)
+		assert.NoError(t, err)
+		assert.Empty(t, profile.Name)
+		assert.Equal(t, "ASIAEXAMPLE", profile.Credentials.AccessKeyID)
+		assert.Equal(t, "secret", profile.Credentials.SecretAccessKey)
+		assert.Equal(t, "eu-west-1", profile.Region)
+		assert.True(t, profile.Credentials.CanExpire)
+		assert.Equal(t, time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), profile.Credentials.Expires)
+	})
+
+	t.Run("Windows lines", func(t *testing.T) {
+		for _, text := range []string{
+			"set AWS_ACCESS_KEY_ID=ASIAEXAMPLE\r\nset AWS_SECRET_ACCESS_KEY=secret\r\nset AWS_SESSION_TOKEN=token\r\n",
+			"$Env:AWS_ACCESS_KEY_ID=\"ASIAEXAMPLE\"\r\n$Env:AWS_SECRET_ACCESS_KEY=\"secret\"\r\n$Env:AWS_SESSION_TOKEN=\"token\"\r\n",
+		} {
+			profile, err := ParseImport(text)
+			assert.NoError(t, err)
+			assert.Equal(t, "ASIAEXAMPLE", profile.Credentials.AccessKeyID)
+			assert.Equal(t, "token", profile.Credentials.SessionToken)
+		}
+	})
+
+	t.Run("Skips surrounding text", func(t *testing.T) {
+		profile, err := ParseImport("Option 2: Add a profile to your AWS credentials file\n[dev]\naws_access_key_id = id\naws_secret_access_key = secret\naws_session_token = token\nCopy")
+		assert.NoError(t, err)
+		assert.Equal(t, "dev", profile.Name)
+		assert.Equal(t, "id", profile.Credentials.AccessKeyID)
+	})
+
+	t.Run("Rejects incomplete credentials", func(t *testing.T) {
+		_, err := ParseImport("hello")
+		assert.ErrorContains(t, err, "no credentials found")
+
+		_, err = ParseImport("aws_access_key_id=id\naws_session_token=token")
+		assert.ErrorContains(t, err, "secret access key")
+
+		_, err = ParseImport("aws_access_key_id=AKIAEXAMPLE\naws_secret_access_key=secret")
+		assert.ErrorContains(t, err, "only temporary credentials")
+	})
+
+	t.Run("Rejects several profiles", func(t *testing.T) {
+		_, err := ParseImport("[one]\naws_access_key_id=id\n[two]\naws_access_key_id=id")
+		assert.ErrorContains(t, err, "several profiles")
+	})
+
+	t.Run("Rejects a malformed expiry", func(t *testing.T) {
+		_, err := ParseImport("AWS_CREDENTIAL_EXPIRATION=tomorrow")
+		assert.ErrorContains(t, err, "invalid expiry")
+	})
+}
+
+func TestImportProfile(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC)
+	pasted := Profile{Name: "sso-dev", Region: "eu-west-1"}
+	pasted.Credentials.AccessKeyID, pasted.Credentials.SecretAccessKey, pasted.Credentials.SessionToken = "id", "secret", "token"
+
+	t.Run("Merges into the credentials file with an expiry", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "credentials")
+		assert.NoError(t, os.WriteFile(path, []byte("[default]\naws_access_key_id = keep-me\n\n[sso-dev]\naws_access_key_id = stale\n"), 0o600))
+
+		imported, err := ImportProfile(path, pasted, time.Hour, now)
+		assert.NoError(t, err)
+		assert.Equal(t, now.Add(time.Hour), imported.Credentials.Expires)
+
+		cfg, err := ini.Load(path)
+		assert.NoError(t, err)
+		assert.Equal(t, "keep-me", cfg.Section("default").Key("aws_access_key_id").String())
+		assert.Equal(t, "id", cfg.Section("sso-dev").Key("aws_access_key_id").String())
+		assert.Equal(t, "eu-west-1", cfg.Section("sso-dev").Key("region").String())
+
+		read, err := ReadProfile(path, "sso-dev")
+		assert.NoError(t, err)
+		assert.True(t, read.Credentials.CanExpire)
+		assert.Equal(t, now.Add(time.Hour), read.Credentials.Expires)
+	})
+
+	t.Run("Keeps a pasted expiry", func(t *testing.T) {
+		profile := pasted
+		profile.Credentials.CanExpire, profile.Credentials.Expires = true, now.Add(10*time.Minute)
+
+		imported, err := ImportProfile(filepath.Join(t.TempDir(), "credentials"), profile, time.Hour, now)
+		assert.NoError(t, err)
+		assert.Equal(t, now.Add(10*time.Minute), imported.Credentials.Expires)
+	})
+
+	t.Run("Rejects expired credentials", func(t *testing.T) {
+		profile := pasted
+		profile.Credentials.CanExpire, profile.Credentials.Expires = true, now.Add(-time.Minute)
+
+		path := filepath.Join(t.TempDir(), "credentials")
+		_, err := ImportProfile(path, profile, time.Hour, now)
+		assert.ErrorContains(t, err, "expired at 2026-01-02T02:59:00Z")
+		assert.NoFileExists(t, path)
+	})
+}
@@ -0,0 +1,38 @@
+package awsconfig
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// WriteIsolated writes the session and role profiles to a credentials file in a new private
+// temporary directory and returns the path of the file. ~/.aws/credentials is left untouched
+// and the long-lived source keys are never written, so the file can be handed to tools that
+// should only ever see the sessions. The directory is kept after gredentures exits.
+func (conf *AwsConfig) WriteIsolated() (string, error) {
+	dir, err := os.MkdirTemp("", "gredentures-isolated-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create isolated credentials directory: %w", err)
+	}
+
+	path := filepath.Join(dir, "credentials")
+	slog.Debug("Writing isolated credentials file", "path", path)
+	if err := conf.WriteCredentials(&SharedCredentialsWriter{Path: path, Merge: true}); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return path, nil
+}
+
+// IsolatedExports returns the shell export statements pointing AWS tools at the isolated
+// credentials file at path and its session profile, for use with eval.
+func IsolatedExports(path, profile string) string {
+	var buf strings.Builder
+	for _, v := range []envVar{{"AWS_SHARED_CREDENTIALS_FILE", path}, {"AWS_PROFILE", profile}} {
+		fmt.Fprintf(&buf, "export %s=%s\n", v.name, shellQuote(v.value))
+	}
+	return buf.String()
+}
@@ -0,0 +1,46 @@
+package awsconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ini.v1"
+)
+
+func TestWriteIsolated(t *testing.T) {
+	home, tmp := t.TempDir(), t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("TMPDIR", tmp)
+
+	path, err := writerTestConfig().WriteIsolated()
+	assert.NoError(t, err)
+	assert.Equal(t, tmp, filepath.Dir(filepath.Dir(path)))
+	assert.NoFileExists(t, CredentialsPath(), "the real credentials file is left untouched")
+
+	info, err := os.Stat(filepath.Dir(path))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o700), info.Mode().Perm())
+
+	inidata, err := ini.Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{ini.DefaultSection, "default-mfa", "prod-mfa"}, inidata.SectionStrings(), "the source keys are never written")
+	assert.Equal(t, "mockSessionToken", inidata.Section("default-mfa").Key("aws_session_token").String())
+
+	t.Run("Removes the directory when nothing can be written", func(t *testing.T) {
+		dir := t.TempDir()
+		t.Setenv("TMPDIR", dir)
+
+		_, err := (&AwsConfig{}).WriteIsolated()
+		assert.ErrorIs(t, err, ErrIncompleteCredentials)
+		entries, err := os.ReadDir(dir)
+		assert.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+}
+
+func TestIsolatedExports(t *testing.T) {
+	assert.Equal(t, "export AWS_SHARED_CREDENTIALS_FILE='/tmp/gredentures-isolated-1/credentials'\nexport AWS_PROFILE='default-mfa'\n",
+		IsolatedExports("/tmp/gredentures-isolated-1/credentials", "default-mfa"))
+}
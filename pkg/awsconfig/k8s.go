@@ -0,0 +1,90 @@
+package awsconfig
+
+import (
+	"cmp"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"gredentures/pkg/interrupt"
+
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+const (
+	// execCredentialAPIVersion is the Kubernetes client authentication API gredentures speaks.
+	execCredentialAPIVersion = "client.authentication.k8s.io/v1"
+	// eksTokenPrefix marks a bearer token as a presigned STS request, as EKS expects.
+	eksTokenPrefix = "k8s-aws-v1."
+	// eksClusterHeader binds the presigned request to a single cluster.
+	eksClusterHeader = "x-k8s-aws-id"
+	// eksTokenLifetime is how long EKS accepts a token, less a minute of margin for clock skew.
+	eksTokenLifetime = 14 * time.Minute
+)
+
+// execCredential is the ExecCredential object read by kubectl from exec plugins.
+type execCredential struct {
+	APIVersion string               `json:"apiVersion"`
+	Kind       string               `json:"kind"`
+	Status     execCredentialStatus `json:"status"`
+}
+
+// execCredentialStatus carries the bearer token and its expiry.
+type execCredentialStatus struct {
+	ExpirationTimestamp string `json:"expirationTimestamp"`
+	Token               string `json:"token"`
+}
+
+// ExecCredentialWriter prints a client.authentication.k8s.io/v1 ExecCredential holding an EKS
+// bearer token for the cluster, signed with the MFA session credentials in the same way as
+// aws-iam-authenticator, so a kubeconfig exec block can call gredentures directly.
+type ExecCredentialWriter struct {
+	Out     io.Writer
+	Cluster string // EKS cluster name the token is scoped to.
+}
+
+// WriteCredentials implements CredentialWriter.
+func (w *ExecCredentialWriter) WriteCredentials(set CredentialSet) error {
+	if w.Cluster == "" {
+		return fmt.Errorf("a cluster name is required for k8s-exec output")
+	}
+
+	// Presigned for the region of the session, which is in its partition, as EKS verifies the
+	// token with the STS endpoint of the cluster's partition
+	region := cmp.Or(set.Session.Region, defaultRegion)
+	client := sts.New(sts.Options{Region: region, Credentials: staticCredentials(set.Session.Credentials)})
+	token, err := eksToken(interrupt.Context(), sts.NewPresignClient(client), w.Cluster)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w.Out, execCredential{
+		APIVersion: execCredentialAPIVersion,
+		Kind:       "ExecCredential",
+		Status: execCredentialStatus{
+			ExpirationTimestamp: time.Now().Add(eksTokenLifetime).UTC().Format(time.RFC3339),
+			Token:               token,
+		},
+	})
+}
+
+// eksToken presigns an STS GetCallerIdentity request scoped to the cluster and encodes it
+// as an EKS bearer token.
+func eksToken(ctx context.Context, presigner *sts.PresignClient, cluster string) (string, error) {
+	slog.Debug("Presigning EKS token", "cluster", cluster)
+	req, err := presigner.PresignGetCallerIdentity(ctx, &sts.GetCallerIdentityInput{}, func(o *sts.PresignOptions) {
+		o.ClientOptions = append(o.ClientOptions, sts.WithAPIOptions(
+			smithyhttp.SetHeaderValue(eksClusterHeader, cluster),
+			smithyhttp.SetHeaderValue("X-Amz-Expires", "60"),
+		))
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to presign EKS token: %w", err)
+	}
+
+	return eksTokenPrefix + base64.RawURLEncoding.EncodeToString([]byte(req.URL)), nil
+}
@@ -0,0 +1,71 @@
+package awsconfig
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecCredential(t *testing.T) {
+	var out bytes.Buffer
+	assert.NoError(t, exportTestConfig().WriteCredentials(&ExecCredentialWriter{Out: &out, Cluster: "prod-cluster"}))
+
+	var cred execCredential
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &cred))
+	assert.Equal(t, "client.authentication.k8s.io/v1", cred.APIVersion)
+	assert.Equal(t, "ExecCredential", cred.Kind)
+
+	expiry, err := time.Parse(time.RFC3339, cred.Status.ExpirationTimestamp)
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(eksTokenLifetime), expiry, time.Minute)
+
+	assert.True(t, strings.HasPrefix(cred.Status.Token, "k8s-aws-v1."))
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(cred.Status.Token, "k8s-aws-v1."))
+	assert.NoError(t, err)
+
+	presigned, err := url.Parse(string(decoded))
+	assert.NoError(t, err)
+	query := presigned.Query()
+	assert.Equal(t, "GetCallerIdentity", query.Get("Action"))
+	assert.Equal(t, "60", query.Get("X-Amz-Expires"))
+	assert.NotEmpty(t, query.Get("X-Amz-Signature"))
+	assert.Contains(t, query.Get("X-Amz-SignedHeaders"), "x-k8s-aws-id")
+	assert.Equal(t, "mockSessionToken", query.Get("X-Amz-Security-Token"))
+	assert.Equal(t, "sts.us-west-2.amazonaws.com", presigned.Host, "the default region without one of the session")
+}
+
+func TestExecCredentialRegion(t *testing.T) {
+	for region, host := range map[string]string{
+		"eu-central-1":  "sts.eu-central-1.amazonaws.com",
+		"us-gov-west-1": "sts.us-gov-west-1.amazonaws.com",
+		"cn-north-1":    "sts.cn-north-1.amazonaws.com.cn",
+	} {
+		conf := exportTestConfig()
+		conf.region = region
+		var out bytes.Buffer
+		assert.NoError(t, conf.WriteCredentials(&ExecCredentialWriter{Out: &out, Cluster: "prod-cluster"}))
+
+		var cred execCredential
+		assert.NoError(t, json.Unmarshal(out.Bytes(), &cred))
+		decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(cred.Status.Token, "k8s-aws-v1."))
+		assert.NoError(t, err)
+		presigned, err := url.Parse(string(decoded))
+		assert.NoError(t, err)
+		assert.Equal(t, host, presigned.Host, region)
+	}
+}
+
+func TestExecCredentialErrors(t *testing.T) {
+	var out bytes.Buffer
+	err := (&AwsConfig{}).WriteCredentials(&ExecCredentialWriter{Out: &out, Cluster: "prod-cluster"})
+	assert.ErrorContains(t, err, "no session credentials")
+
+	err = exportTestConfig().WriteCredentials(&ExecCredentialWriter{Out: &out})
+	assert.ErrorContains(t, err, "cluster name is required")
+}
@@ -0,0 +1,66 @@
+package awsconfig
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// errSecurityQuote is returned for a keychain item the macOS security tool cannot be given.
+var errSecurityQuote = errors.New("security -i cannot quote a value holding a quote or a line break")
+
+// addGenericPassword returns the command adding or updating the keychain item of service and
+// account to password, for the interactive mode of the macOS security tool. It reads commands
+// from stdin, so the password never appears in argv. The label and the keychain are left out
+// when empty, the item is then labelled with the service and added to the default keychain.
+func addGenericPassword(service, account, label, password, keychain string) (string, error) {
+	// security -i splits its commands on whitespace and has no escape for quotes inside quotes
+	for _, value := range []string{service, account, label, password, keychain} {
+		if strings.ContainsAny(value, "'\n") {
+			return "", errSecurityQuote
+		}
+	}
+	flags := [][2]string{{"-s", service}, {"-a", account}}
+	if label != "" {
+		flags = append(flags, [2]string{"-l", label})
+	}
+	flags = append(flags, [2]string{"-w", password})
+
+	command := []string{"add-generic-password", "-U"}
+	for _, flag := range flags {
+		command = append(command, flag[0], "'"+flag[1]+"'")
+	}
+	if keychain != "" {
+		command = append(command, "'"+keychain+"'")
+	}
+	return strings.Join(command, " ") + "\n", nil
+}
+
+// commandError is the failure of a command run by outputWithStdin, with what it wrote to
+// stderr, which keychain tools explain their exit codes with.
+type commandError struct {
+	name   string
+	err    error
+	stderr string
+}
+
+func (e *commandError) Error() string {
+	return fmt.Sprintf("%s failed: %v: %s", e.name, e.err, e.stderr)
+}
+
+func (e *commandError) Unwrap() error { return e.err }
+
+// outputWithStdin runs a command with the given standard input and returns its output. Every
+// keychain tool is run with it, so secrets are passed on stdin rather than in argv.
+func outputWithStdin(stdin string, command ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin = strings.NewReader(stdin)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", &commandError{name: command[0], err: err, stderr: strings.TrimSpace(stderr.String())}
+	}
+	return stdout.String(), nil
+}
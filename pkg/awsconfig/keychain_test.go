@@ -0,0 +1,52 @@
+package awsconfig
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddGenericPassword(t *testing.T) {
+	t.Run("Quotes every value", func(t *testing.T) {
+		command, err := addGenericPassword("gredentures", "work profile", "gredentures (work)", `{"Secret":"a b"}`, "aws-vault.keychain")
+		require.NoError(t, err)
+		assert.Equal(t, `add-generic-password -U -s 'gredentures' -a 'work profile' -l 'gredentures (work)' -w '{"Secret":"a b"}' 'aws-vault.keychain'`+"\n", command)
+	})
+
+	t.Run("Leaves out the label and keychain when empty", func(t *testing.T) {
+		command, err := addGenericPassword("gredentures", "work", "", "secret", "")
+		require.NoError(t, err)
+		assert.Equal(t, "add-generic-password -U -s 'gredentures' -a 'work' -w 'secret'\n", command)
+	})
+
+	t.Run("Refuses what it cannot quote", func(t *testing.T) {
+		for _, value := range []string{"it's", "two\nlines"} {
+			_, err := addGenericPassword("gredentures", value, "", "secret", "")
+			assert.ErrorIs(t, err, errSecurityQuote, value)
+			_, err = addGenericPassword("gredentures", "work", "", value, "")
+			assert.ErrorIs(t, err, errSecurityQuote, value)
+		}
+	})
+}
+
+func TestOutputWithStdin(t *testing.T) {
+	t.Run("Passes stdin and returns stdout", func(t *testing.T) {
+		out, err := outputWithStdin("secret\n", "cat")
+		require.NoError(t, err)
+		assert.Equal(t, "secret\n", out)
+	})
+
+	t.Run("Reports the exit code and stderr", func(t *testing.T) {
+		_, err := outputWithStdin("", "sh", "-c", "echo 'item not found' >&2; exit 44")
+		assert.EqualError(t, err, "sh failed: exit status 44: item not found")
+
+		var failed *commandError
+		require.ErrorAs(t, err, &failed)
+		assert.Equal(t, "item not found", failed.stderr)
+		var exit *exec.ExitError
+		require.ErrorAs(t, err, &exit)
+		assert.Equal(t, 44, exit.ExitCode())
+	})
+}
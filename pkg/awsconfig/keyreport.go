@@ -0,0 +1,99 @@
+package awsconfig
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"gredentures/pkg/interrupt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+)
+
+// accessKeysAPI is the subset of the IAM client used to report on access keys, so it can be mocked in tests.
+type accessKeysAPI interface {
+	ListAccessKeys(ctx context.Context, params *iam.ListAccessKeysInput, optFns ...func(*iam.Options)) (*iam.ListAccessKeysOutput, error)
+	GetAccessKeyLastUsed(ctx context.Context, params *iam.GetAccessKeyLastUsedInput, optFns ...func(*iam.Options)) (*iam.GetAccessKeyLastUsedOutput, error)
+}
+
+// AccessKey is an access key of the IAM user the source credentials belong to.
+type AccessKey struct {
+	AccessKeyID string    // ID of the key.
+	UserName    string    // IAM user the key belongs to.
+	Status      string    // Active or Inactive.
+	Created     time.Time // When the key was created.
+	LastUsed    time.Time // When the key was last used, zero when it never was.
+	LastService string    // Service the key was last used with, empty when it never was.
+	LastRegion  string    // Region the key was last used in, empty when it never was.
+	Current     bool      // Whether this is the key of the source profile.
+}
+
+// Age returns how long ago the key was created.
+func (key AccessKey) Age(now time.Time) time.Duration {
+	return now.Sub(key.Created)
+}
+
+// Expired reports whether the key is active and older than maxAge. No maxAge means no
+// rotation policy, so no key is expired.
+func (key AccessKey) Expired(now time.Time, maxAge time.Duration) bool {
+	return maxAge > 0 && key.Status == string(iamtypes.StatusTypeActive) && key.Age(now) > maxAge
+}
+
+// AccessKeys lists the access keys of the IAM user the source credentials belong to, oldest
+// first, with when each was last used. It requires iam:ListAccessKeys and
+// iam:GetAccessKeyLastUsed on the user, which policies commonly grant to every user for
+// their own keys.
+func (conf *AwsConfig) AccessKeys() ([]AccessKey, error) {
+	config, err := conf.sourceAccount()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get default account: %w", err)
+	}
+	creds, err := config.Credentials.Retrieve(interrupt.Context())
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve the long-lived credentials: %w", err)
+	}
+	return accessKeys(interrupt.Context(), conf.clients(config).iam(), creds.AccessKeyID)
+}
+
+// accessKeys lists the access keys of the caller and looks up when each was last used,
+// marking the one with ID current.
+func accessKeys(ctx context.Context, client accessKeysAPI, current string) ([]AccessKey, error) {
+	var keys []AccessKey
+	pages := iam.NewListAccessKeysPaginator(client, &iam.ListAccessKeysInput{})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list access keys: %w", err)
+		}
+		for _, metadata := range page.AccessKeyMetadata {
+			keys = append(keys, AccessKey{
+				AccessKeyID: aws.ToString(metadata.AccessKeyId),
+				UserName:    aws.ToString(metadata.UserName),
+				Status:      string(metadata.Status),
+				Created:     aws.ToTime(metadata.CreateDate),
+				Current:     aws.ToString(metadata.AccessKeyId) == current,
+			})
+		}
+	}
+
+	for i := range keys {
+		out, err := client.GetAccessKeyLastUsed(ctx, &iam.GetAccessKeyLastUsedInput{AccessKeyId: aws.String(keys[i].AccessKeyID)})
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up when %s was last used: %w", keys[i].AccessKeyID, err)
+		}
+		if used := out.AccessKeyLastUsed; used != nil {
+			keys[i].LastUsed = aws.ToTime(used.LastUsedDate)
+			// IAM reports N/A for keys that were never used
+			if !keys[i].LastUsed.IsZero() {
+				keys[i].LastService = aws.ToString(used.ServiceName)
+				keys[i].LastRegion = aws.ToString(used.Region)
+			}
+		}
+	}
+
+	sort.SliceStable(keys, func(i, j int) bool { return keys[i].Created.Before(keys[j].Created) })
+	return keys, nil
+}
@@ -0,0 +1,69 @@
+package awsconfig
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeAccessKeys serves the access keys of a user and when each was last used.
+type fakeAccessKeys struct {
+	keys     []iamtypes.AccessKeyMetadata
+	lastUsed map[string]*iamtypes.AccessKeyLastUsed
+	err      error
+}
+
+func (f *fakeAccessKeys) ListAccessKeys(ctx context.Context, params *iam.ListAccessKeysInput, optFns ...func(*iam.Options)) (*iam.ListAccessKeysOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &iam.ListAccessKeysOutput{AccessKeyMetadata: f.keys}, nil
+}
+
+func (f *fakeAccessKeys) GetAccessKeyLastUsed(ctx context.Context, params *iam.GetAccessKeyLastUsedInput, optFns ...func(*iam.Options)) (*iam.GetAccessKeyLastUsedOutput, error) {
+	return &iam.GetAccessKeyLastUsedOutput{AccessKeyLastUsed: f.lastUsed[aws.ToString(params.AccessKeyId)]}, nil
+}
+
+func TestAccessKeys(t *testing.T) {
+	created := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	client := &fakeAccessKeys{
+		keys: []iamtypes.AccessKeyMetadata{
+			{AccessKeyId: aws.String("AKIANEW"), UserName: aws.String("alice"), Status: iamtypes.StatusTypeActive, CreateDate: aws.Time(created.Add(100 * 24 * time.Hour))},
+			{AccessKeyId: aws.String("AKIAOLD"), UserName: aws.String("alice"), Status: iamtypes.StatusTypeInactive, CreateDate: aws.Time(created)},
+		},
+		lastUsed: map[string]*iamtypes.AccessKeyLastUsed{
+			"AKIANEW": {LastUsedDate: aws.Time(created.Add(101 * 24 * time.Hour)), ServiceName: aws.String("sts"), Region: aws.String("eu-west-1")},
+			"AKIAOLD": {ServiceName: aws.String("N/A"), Region: aws.String("N/A")},
+		},
+	}
+
+	keys, err := accessKeys(context.Background(), client, "AKIANEW")
+	assert.NoError(t, err)
+	assert.Equal(t, []AccessKey{
+		{AccessKeyID: "AKIAOLD", UserName: "alice", Status: "Inactive", Created: created},
+		{AccessKeyID: "AKIANEW", UserName: "alice", Status: "Active", Created: created.Add(100 * 24 * time.Hour),
+			LastUsed: created.Add(101 * 24 * time.Hour), LastService: "sts", LastRegion: "eu-west-1", Current: true},
+	}, keys, "oldest first, never used keys without a service")
+
+	client.err = errors.New("AccessDenied")
+	_, err = accessKeys(context.Background(), client, "AKIANEW")
+	assert.ErrorContains(t, err, "failed to list access keys")
+}
+
+func TestAccessKeyExpired(t *testing.T) {
+	now := time.Date(2030, 6, 1, 0, 0, 0, 0, time.UTC)
+	old := AccessKey{Status: "Active", Created: now.Add(-91 * 24 * time.Hour)}
+
+	assert.Equal(t, 91*24*time.Hour, old.Age(now))
+	assert.True(t, old.Expired(now, 90*24*time.Hour))
+	assert.False(t, old.Expired(now, 0), "no rotation policy")
+	assert.False(t, old.Expired(now, 100*24*time.Hour))
+	old.Status = "Inactive"
+	assert.False(t, old.Expired(now, 90*24*time.Hour), "inactive keys cannot be used anyway")
+}
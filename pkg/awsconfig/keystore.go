@@ -0,0 +1,453 @@
+package awsconfig
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+
+	"gredentures/pkg/appconfig"
+	"gredentures/pkg/interrupt"
+	"gredentures/pkg/secret"
+	"gredentures/pkg/sysuser"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// keyStoreService is the service name the keychain backend stores long-lived keys under, apart
+// from the session credentials of the keychain output.
+const keyStoreService = "gredentures-keys"
+
+// KeyStore keeps the long-lived key pairs gredentures logs in with, one per org, so they never
+// have to be written to the shared credentials file in plain text.
+type KeyStore interface {
+	Keys(name string) (Profile, error)         // Keys stored under name, ErrNoStoredKeys when there are none.
+	StoreKeys(name string, keys Profile) error // Stores keys under name, replacing any stored before.
+	DeleteKeys(name string) error              // Deletes the keys stored under name, ErrNoStoredKeys when there are none.
+	Names() ([]string, error)                  // Names keys are stored under, sorted.
+}
+
+// NewKeyStore returns the key store selected by the KeyStore settings of app, nil when none
+// is configured. passphrase is asked for the key file of the file backend the first time it
+// is opened, with confirm set when the file is created.
+func NewKeyStore(app appconfig.AppConfig, passphrase func(confirm bool) (secret.Value, error)) (KeyStore, error) {
+	switch app.KeyStore.Backend {
+	case "":
+		return nil, nil
+	case appconfig.KeyStoreKeychain:
+		return &KeychainKeyStore{OS: runtime.GOOS, Service: keyStoreService, Run: keychainOutput}, nil
+	case appconfig.KeyStoreFile:
+		path := app.KeyStore.File
+		if path == "" {
+			path = KeyFilePath()
+		}
+		return &FileKeyStore{Path: path, Passphrase: passphrase}, nil
+	}
+	return nil, fmt.Errorf("unknown KeyStore.Backend %q, expected one of %s", app.KeyStore.Backend, strings.Join(appconfig.KeyStores, ", "))
+}
+
+// KeyFilePath returns the default key file of the file backend,
+// $XDG_DATA_HOME/gredentures/keys.enc, falling back to ~/.local/share when the variable is unset.
+func KeyFilePath() string {
+	if dir := sysuser.StateDir(); dir != "" {
+		return filepath.Join(dir, "keys.enc")
+	}
+	dir := os.Getenv("XDG_DATA_HOME")
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(sysuser.Home(), ".local", "share")
+	}
+	return filepath.Join(dir, "gredentures", "keys.enc")
+}
+
+// storedKeys is a long-lived key pair as a KeyStore holds it.
+type storedKeys struct {
+	AccessKeyID     string
+	SecretAccessKey string
+}
+
+// newStoredKeys returns the key pair of keys, refusing incomplete ones.
+func newStoredKeys(name string, keys Profile) (storedKeys, error) {
+	if keys.Credentials.AccessKeyID == "" || keys.Credentials.SecretAccessKey == "" {
+		return storedKeys{}, fmt.Errorf("%w: no access key pair to store for %s", ErrIncompleteCredentials, name)
+	}
+	return storedKeys{AccessKeyID: keys.Credentials.AccessKeyID, SecretAccessKey: keys.Credentials.SecretAccessKey}, nil
+}
+
+// profile returns the stored keys as the profile name.
+func (k storedKeys) profile(name, source string) Profile {
+	return Profile{Name: name, Credentials: aws.Credentials{AccessKeyID: k.AccessKeyID, SecretAccessKey: k.SecretAccessKey, Source: source}}
+}
+
+// KeychainKeyStore keeps the long-lived keys in the OS keychain, one item per org holding the
+// key pair as JSON, using the macOS security tool or the freedesktop secret-tool. Secrets are
+// passed on stdin, or read from stdout, so they never appear in argv.
+type KeychainKeyStore struct {
+	OS      string                                                // Operating system, runtime.GOOS unless replaced in tests.
+	Service string                                                // Keychain service name.
+	Run     func(stdin string, command ...string) (string, error) // Runs a keychain command, ErrNoStoredKeys for missing items.
+}
+
+// Keys implements KeyStore.
+func (s *KeychainKeyStore) Keys(name string) (Profile, error) {
+	var out string
+	var err error
+	switch s.OS {
+	case "darwin":
+		out, err = s.Run("", "security", "find-generic-password", "-s", s.Service, "-a", name, "-w")
+	case "linux":
+		out, err = s.Run("", "secret-tool", "lookup", "service", s.Service, "org", name)
+	default:
+		return Profile{}, fmt.Errorf("keychain key store is not supported on %s", s.OS)
+	}
+	if err == nil && strings.TrimSpace(out) == "" {
+		err = ErrNoStoredKeys
+	}
+	if err != nil {
+		return Profile{}, fmt.Errorf("failed to read the keys of %s from the keychain: %w", name, err)
+	}
+
+	var keys storedKeys
+	if err := json.Unmarshal([]byte(strings.TrimSpace(out)), &keys); err != nil {
+		return Profile{}, fmt.Errorf("keychain item of %s is unreadable: %w", name, err)
+	}
+	if keys.AccessKeyID == "" || keys.SecretAccessKey == "" {
+		return Profile{}, fmt.Errorf("%w: keychain item of %s holds no access key pair", ErrIncompleteCredentials, name)
+	}
+	return keys.profile(name, s.Service), nil
+}
+
+// StoreKeys implements KeyStore.
+func (s *KeychainKeyStore) StoreKeys(name string, keys Profile) error {
+	stored, err := newStoredKeys(name, keys)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return fmt.Errorf("failed to marshal keys: %w", err)
+	}
+
+	slog.Debug("Storing keys in keychain", "service", s.Service, "org", name)
+	label := fmt.Sprintf("%s (%s)", s.Service, name)
+	switch s.OS {
+	case "darwin":
+		command, quoteErr := addGenericPassword(s.Service, name, label, string(data), "")
+		if quoteErr != nil {
+			return fmt.Errorf("keys of %q cannot be stored in the keychain: %w", name, quoteErr)
+		}
+		_, err = s.Run(command, "security", "-i")
+	case "linux":
+		_, err = s.Run(string(data), "secret-tool", "store", "--label", label, "service", s.Service, "org", name)
+	default:
+		return fmt.Errorf("keychain key store is not supported on %s", s.OS)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store the keys of %s in the keychain: %w", name, err)
+	}
+	return nil
+}
+
+// DeleteKeys implements KeyStore.
+func (s *KeychainKeyStore) DeleteKeys(name string) error {
+	var err error
+	switch s.OS {
+	case "darwin":
+		_, err = s.Run("", "security", "delete-generic-password", "-s", s.Service, "-a", name)
+	case "linux":
+		// secret-tool clear succeeds whether or not there is an item, so look it up first
+		if _, err = s.Keys(name); err != nil {
+			return err
+		}
+		_, err = s.Run("", "secret-tool", "clear", "service", s.Service, "org", name)
+	default:
+		return fmt.Errorf("keychain key store is not supported on %s", s.OS)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete the keys of %s from the keychain: %w", name, err)
+	}
+	return nil
+}
+
+// Attributes of keychain items printed by security dump-keychain and secret-tool search.
+var (
+	keychainItemService = regexp.MustCompile(`"svce"<blob>="([^"]*)"`)
+	keychainItemAccount = regexp.MustCompile(`"acct"<blob>="([^"]*)"`)
+	secretToolOrg       = regexp.MustCompile(`(?m)^attribute\.org = (.*)$`)
+)
+
+// Names implements KeyStore.
+func (s *KeychainKeyStore) Names() ([]string, error) {
+	var names []string
+	switch s.OS {
+	case "darwin":
+		out, err := s.Run("", "security", "dump-keychain")
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the keychain: %w", err)
+		}
+		// Every item starts with the keychain it is in, followed by its attributes
+		for _, item := range strings.Split(out, "keychain: ")[1:] {
+			service, account := keychainItemService.FindStringSubmatch(item), keychainItemAccount.FindStringSubmatch(item)
+			if service != nil && account != nil && service[1] == s.Service {
+				names = append(names, account[1])
+			}
+		}
+	case "linux":
+		out, err := s.Run("", "secret-tool", "search", "--all", "service", s.Service)
+		if errors.Is(err, ErrNoStoredKeys) {
+			return nil, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to list the keychain: %w", err)
+		}
+		for _, match := range secretToolOrg.FindAllStringSubmatch(out, -1) {
+			names = append(names, strings.TrimSpace(match[1]))
+		}
+	default:
+		return nil, fmt.Errorf("keychain key store is not supported on %s", s.OS)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// keychainOutput runs a keychain command like outputWithStdin, reporting the failures of the
+// security tool and secret-tool for missing items as ErrNoStoredKeys.
+func keychainOutput(stdin string, command ...string) (string, error) {
+	out, err := outputWithStdin(stdin, command...)
+	var failed *commandError
+	var exit *exec.ExitError
+	if errors.As(err, &failed) && errors.As(err, &exit) && missingKeychainItem(command[0], exit.ExitCode(), failed.stderr) {
+		return "", ErrNoStoredKeys
+	}
+	return out, err
+}
+
+// missingKeychainItem reports whether a keychain tool exited with code because the item it
+// was asked for does not exist: the security tool exits with 44, secret-tool with 1 and no
+// message at all.
+func missingKeychainItem(tool string, code int, stderr string) bool {
+	switch tool {
+	case "security":
+		return code == 44
+	case "secret-tool":
+		return code == 1 && strings.TrimSpace(stderr) == ""
+	}
+	return false
+}
+
+// keyFileIterations is the PBKDF2-SHA256 iteration count new key files are written with, as
+// recommended by OWASP. Files keep the count they were written with.
+var keyFileIterations = 600_000
+
+// keyFile is the layout of the key file of the file backend. Data is the JSON of the stored
+// keys by name, sealed with AES-256-GCM under a key derived from the passphrase.
+type keyFile struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Data       []byte `json:"data"`
+}
+
+// keyFileKDF names the key derivation of keyFile version 1.
+const keyFileKDF = "pbkdf2-sha256"
+
+// FileKeyStore keeps the long-lived keys in one file encrypted with a passphrase, for machines
+// without a keychain. Each write uses a new salt and nonce.
+type FileKeyStore struct {
+	Path       string                                   // Key file.
+	Passphrase func(confirm bool) (secret.Value, error) // Asked for once per run, with confirm set when the file is created.
+
+	passphrase secret.Value // Passphrase given for this run.
+}
+
+// Keys implements KeyStore.
+func (s *FileKeyStore) Keys(name string) (Profile, error) {
+	keys, err := s.load()
+	if err != nil {
+		return Profile{}, err
+	}
+	stored, ok := keys[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("%w for %s in %s", ErrNoStoredKeys, name, s.Path)
+	}
+	return stored.profile(name, "gredentures-keyfile"), nil
+}
+
+// StoreKeys implements KeyStore.
+func (s *FileKeyStore) StoreKeys(name string, keys Profile) error {
+	stored, err := newStoredKeys(name, keys)
+	if err != nil {
+		return err
+	}
+	all, err := s.load()
+	if err != nil {
+		return err
+	}
+	all[name] = stored
+	slog.Debug("Storing keys in key file", "path", s.Path, "org", name)
+	return s.save(all)
+}
+
+// DeleteKeys implements KeyStore.
+func (s *FileKeyStore) DeleteKeys(name string) error {
+	all, err := s.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := all[name]; !ok {
+		return fmt.Errorf("%w for %s in %s", ErrNoStoredKeys, name, s.Path)
+	}
+	delete(all, name)
+	return s.save(all)
+}
+
+// Names implements KeyStore.
+func (s *FileKeyStore) Names() ([]string, error) {
+	if _, err := os.Stat(s.Path); os.IsNotExist(err) {
+		return nil, nil // Nothing to decrypt, so no passphrase to ask for
+	}
+	all, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(all))
+	for name := range all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// getPassphrase returns the passphrase of the key file, asking for it the first time.
+func (s *FileKeyStore) getPassphrase(confirm bool) (secret.Value, error) {
+	if s.passphrase != "" {
+		return s.passphrase, nil
+	}
+	passphrase, err := s.Passphrase(confirm)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the passphrase of the key file: %w", err)
+	}
+	if passphrase == "" {
+		return "", fmt.Errorf("the key file needs a passphrase")
+	}
+	s.passphrase = passphrase
+	return passphrase, nil
+}
+
+// load decrypts the key file, returning no keys when it does not exist yet.
+func (s *FileKeyStore) load() (map[string]storedKeys, error) {
+	data, err := os.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return map[string]storedKeys{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	var file keyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("key file %s is unreadable: %w", s.Path, err)
+	}
+	if file.Version != 1 || file.KDF != keyFileKDF || file.Iterations < 1 {
+		return nil, fmt.Errorf("key file %s has version %d with %s, this release reads version 1 with %s", s.Path, file.Version, file.KDF, keyFileKDF)
+	}
+	passphrase, err := s.getPassphrase(false)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := keyFileCipher(passphrase, file.Salt, file.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	if len(file.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("%w: %s", ErrKeyFilePassphrase, s.Path)
+	}
+	plain, err := aead.Open(nil, file.Nonce, file.Data, []byte(keyFileKDF))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrKeyFilePassphrase, s.Path)
+	}
+
+	keys := map[string]storedKeys{}
+	if err := json.Unmarshal(plain, &keys); err != nil {
+		return nil, fmt.Errorf("key file %s holds unreadable keys: %w", s.Path, err)
+	}
+	return keys, nil
+}
+
+// save encrypts keys into the key file, replacing it in one step.
+func (s *FileKeyStore) save(keys map[string]storedKeys) error {
+	_, statErr := os.Stat(s.Path)
+	passphrase, err := s.getPassphrase(os.IsNotExist(statErr))
+	if err != nil {
+		return err
+	}
+	plain, err := json.Marshal(keys)
+	if err != nil {
+		return fmt.Errorf("failed to marshal keys: %w", err)
+	}
+
+	file := keyFile{Version: 1, KDF: keyFileKDF, Iterations: keyFileIterations, Salt: make([]byte, 16)}
+	if _, err := rand.Read(file.Salt); err != nil {
+		return fmt.Errorf("failed to generate salt: %w", err)
+	}
+	aead, err := keyFileCipher(passphrase, file.Salt, file.Iterations)
+	if err != nil {
+		return err
+	}
+	file.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(file.Nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	file.Data = aead.Seal(nil, file.Nonce, plain, []byte(keyFileKDF))
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal key file: %w", err)
+	}
+
+	if err := sysuser.MkdirAll(filepath.Dir(s.Path), 0o700); err != nil {
+		return fmt.Errorf("failed to create key file directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.Path), ".keys-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // No-op once the rename has succeeded
+	defer interrupt.OnInterrupt(func() { os.Remove(tmp.Name()) })()
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := sysuser.Chown(tmp.Name()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary file: %w", err)
+	}
+	return os.Rename(tmp.Name(), s.Path)
+}
+
+// keyFileCipher returns the AES-256-GCM cipher keyed with the passphrase and salt.
+func keyFileCipher(passphrase secret.Value, salt []byte, iterations int) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase.Reveal(), salt, iterations, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive the key file key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,296 @@
+package awsconfig
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gredentures/pkg/appconfig"
+	"gredentures/pkg/secret"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ini.v1"
+)
+
+// fakeKeychainKeyStore returns a KeychainKeyStore for goos answering every command with output
+// and err, recording the commands run and the stdin they were given.
+func fakeKeychainKeyStore(goos, output string, err error) (*KeychainKeyStore, *[][]string, *[]string) {
+	var commands [][]string
+	var stdins []string
+	return &KeychainKeyStore{OS: goos, Service: keyStoreService, Run: func(stdin string, command ...string) (string, error) {
+		commands = append(commands, command)
+		stdins = append(stdins, stdin)
+		return output, err
+	}}, &commands, &stdins
+}
+
+// keyPair returns a profile holding a long-lived key pair.
+func keyPair(accessKeyID, secretAccessKey string) Profile {
+	return Profile{Credentials: aws.Credentials{AccessKeyID: accessKeyID, SecretAccessKey: secretAccessKey}}
+}
+
+func TestNewKeyStore(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", "/data")
+
+	store, err := NewKeyStore(appconfig.AppConfig{}, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, store)
+
+	store, err = NewKeyStore(appconfig.AppConfig{KeyStore: appconfig.KeyStoreConfig{Backend: appconfig.KeyStoreKeychain}}, nil)
+	assert.NoError(t, err)
+	assert.IsType(t, &KeychainKeyStore{}, store)
+
+	store, err = NewKeyStore(appconfig.AppConfig{KeyStore: appconfig.KeyStoreConfig{Backend: appconfig.KeyStoreFile}}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "/data/gredentures/keys.enc", store.(*FileKeyStore).Path)
+
+	store, err = NewKeyStore(appconfig.AppConfig{KeyStore: appconfig.KeyStoreConfig{Backend: appconfig.KeyStoreFile, File: "/keys.enc"}}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "/keys.enc", store.(*FileKeyStore).Path)
+
+	_, err = NewKeyStore(appconfig.AppConfig{KeyStore: appconfig.KeyStoreConfig{Backend: "vault"}}, nil)
+	assert.ErrorContains(t, err, `unknown KeyStore.Backend "vault"`)
+}
+
+func TestKeyFilePath(t *testing.T) {
+	t.Setenv("HOME", "/home/test")
+	t.Setenv("XDG_DATA_HOME", "")
+	assert.Equal(t, "/home/test/.local/share/gredentures/keys.enc", KeyFilePath())
+	t.Setenv("XDG_DATA_HOME", "relative")
+	assert.Equal(t, "/home/test/.local/share/gredentures/keys.enc", KeyFilePath(), "relative paths are ignored")
+}
+
+func TestKeychainKeyStore(t *testing.T) {
+	stored := `{"AccessKeyID":"AKIAACME","SecretAccessKey":"acmeSecret"}`
+
+	t.Run("Reads the keychain on macOS", func(t *testing.T) {
+		store, commands, _ := fakeKeychainKeyStore("darwin", stored+"\n", nil)
+		keys, err := store.Keys("acme")
+		assert.NoError(t, err)
+		assert.Equal(t, "acme", keys.Name)
+		assert.Equal(t, "AKIAACME", keys.Credentials.AccessKeyID)
+		assert.Equal(t, "acmeSecret", keys.Credentials.SecretAccessKey)
+		assert.Equal(t, [][]string{{"security", "find-generic-password", "-s", "gredentures-keys", "-a", "acme", "-w"}}, *commands)
+	})
+
+	t.Run("Reads the Secret Service on Linux", func(t *testing.T) {
+		store, commands, _ := fakeKeychainKeyStore("linux", stored, nil)
+		_, err := store.Keys("acme")
+		assert.NoError(t, err)
+		assert.Equal(t, [][]string{{"secret-tool", "lookup", "service", "gredentures-keys", "org", "acme"}}, *commands)
+	})
+
+	t.Run("Reports missing keys", func(t *testing.T) {
+		store, _, _ := fakeKeychainKeyStore("darwin", "", ErrNoStoredKeys)
+		_, err := store.Keys("acme")
+		assert.ErrorIs(t, err, ErrNoStoredKeys)
+		store, _, _ = fakeKeychainKeyStore("linux", "", nil)
+		_, err = store.Keys("acme")
+		assert.ErrorIs(t, err, ErrNoStoredKeys)
+	})
+
+	t.Run("Refuses incomplete items", func(t *testing.T) {
+		store, _, _ := fakeKeychainKeyStore("darwin", `{"AccessKeyID":"AKIAACME"}`, nil)
+		_, err := store.Keys("acme")
+		assert.ErrorIs(t, err, ErrIncompleteCredentials)
+	})
+
+	t.Run("Stores the keys on stdin", func(t *testing.T) {
+		store, commands, stdins := fakeKeychainKeyStore("darwin", "", nil)
+		assert.NoError(t, store.StoreKeys("acme", keyPair("AKIAACME", "acmeSecret")))
+		assert.Equal(t, [][]string{{"security", "-i"}}, *commands)
+		assert.Equal(t, "add-generic-password -U -s 'gredentures-keys' -a 'acme' -l 'gredentures-keys (acme)' -w '"+stored+"'\n", (*stdins)[0])
+
+		store, commands, stdins = fakeKeychainKeyStore("linux", "", nil)
+		assert.NoError(t, store.StoreKeys("acme", keyPair("AKIAACME", "acmeSecret")))
+		assert.Equal(t, [][]string{{"secret-tool", "store", "--label", "gredentures-keys (acme)", "service", "gredentures-keys", "org", "acme"}}, *commands)
+		assert.Equal(t, stored, (*stdins)[0])
+	})
+
+	t.Run("Refuses to store what it cannot quote", func(t *testing.T) {
+		store, commands, _ := fakeKeychainKeyStore("darwin", "", nil)
+		assert.ErrorContains(t, store.StoreKeys("it's", keyPair("AKIAACME", "acmeSecret")), "cannot be stored in the keychain")
+		assert.ErrorIs(t, store.StoreKeys("acme", keyPair("AKIAACME", "")), ErrIncompleteCredentials)
+		assert.Empty(t, *commands)
+	})
+
+	t.Run("Deletes the keys", func(t *testing.T) {
+		store, commands, _ := fakeKeychainKeyStore("darwin", "", nil)
+		assert.NoError(t, store.DeleteKeys("acme"))
+		assert.Equal(t, [][]string{{"security", "delete-generic-password", "-s", "gredentures-keys", "-a", "acme"}}, *commands)
+
+		store, commands, _ = fakeKeychainKeyStore("linux", stored, nil)
+		assert.NoError(t, store.DeleteKeys("acme"))
+		assert.Equal(t, []string{"secret-tool", "clear", "service", "gredentures-keys", "org", "acme"}, (*commands)[1])
+
+		store, _, _ = fakeKeychainKeyStore("linux", "", nil)
+		assert.ErrorIs(t, store.DeleteKeys("acme"), ErrNoStoredKeys)
+	})
+
+	t.Run("Lists the keychain items on macOS", func(t *testing.T) {
+		store, _, _ := fakeKeychainKeyStore("darwin", `keychain: "/Users/alice/Library/Keychains/login.keychain-db"
+class: "genp"
+attributes:
+    "acct"<blob>="staging"
+    "svce"<blob>="gredentures-keys"
+keychain: "/Users/alice/Library/Keychains/login.keychain-db"
+class: "genp"
+attributes:
+    "acct"<blob>="default-mfa"
+    "svce"<blob>="gredentures"
+keychain: "/Users/alice/Library/Keychains/login.keychain-db"
+class: "genp"
+attributes:
+    "acct"<blob>="acme"
+    "svce"<blob>="gredentures-keys"
+`, nil)
+		names, err := store.Names()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"acme", "staging"}, names)
+	})
+
+	t.Run("Lists the Secret Service items on Linux", func(t *testing.T) {
+		store, commands, _ := fakeKeychainKeyStore("linux", `[/org/freedesktop/secrets/collection/login/12]
+label = gredentures-keys (staging)
+attribute.org = staging
+attribute.service = gredentures-keys
+[/org/freedesktop/secrets/collection/login/9]
+label = gredentures-keys (acme)
+attribute.service = gredentures-keys
+attribute.org = acme
+`, nil)
+		names, err := store.Names()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"acme", "staging"}, names)
+		assert.Equal(t, [][]string{{"secret-tool", "search", "--all", "service", "gredentures-keys"}}, *commands)
+
+		store, _, _ = fakeKeychainKeyStore("linux", "", ErrNoStoredKeys)
+		names, err = store.Names()
+		assert.NoError(t, err)
+		assert.Empty(t, names)
+	})
+
+	t.Run("Unsupported system", func(t *testing.T) {
+		store, _, _ := fakeKeychainKeyStore("windows", "", nil)
+		_, err := store.Keys("acme")
+		assert.ErrorContains(t, err, "not supported on windows")
+		assert.ErrorContains(t, store.StoreKeys("acme", keyPair("AKIAACME", "acmeSecret")), "not supported on windows")
+	})
+}
+
+func TestMissingKeychainItem(t *testing.T) {
+	assert.True(t, missingKeychainItem("security", 44, "security: SecKeychainSearchCopyNext: The specified item could not be found in the keychain."))
+	assert.False(t, missingKeychainItem("security", 51, "user interaction is not allowed"))
+	assert.True(t, missingKeychainItem("secret-tool", 1, ""))
+	assert.False(t, missingKeychainItem("secret-tool", 1, "Cannot autolaunch D-Bus without X11 $DISPLAY"))
+	assert.False(t, missingKeychainItem("op", 1, ""))
+}
+
+func TestFileKeyStore(t *testing.T) {
+	defer func(iterations int) { keyFileIterations = iterations }(keyFileIterations)
+	keyFileIterations = 1000 // Derivation at the real count is much too slow for tests
+
+	path := filepath.Join(t.TempDir(), "gredentures", "keys.enc")
+	var asked []bool
+	passphrase := func(confirm bool) (secret.Value, error) {
+		asked = append(asked, confirm)
+		return "correct horse", nil
+	}
+
+	store := &FileKeyStore{Path: path, Passphrase: passphrase}
+	names, err := store.Names()
+	assert.NoError(t, err)
+	assert.Empty(t, names)
+	assert.Empty(t, asked, "there is nothing to decrypt yet")
+	_, err = store.Keys("acme")
+	assert.ErrorIs(t, err, ErrNoStoredKeys)
+
+	assert.NoError(t, store.StoreKeys("acme", keyPair("AKIAACME", "acmeSecret")))
+	assert.NoError(t, store.StoreKeys("staging", keyPair("AKIASTAGING", "stagingSecret")))
+	assert.Equal(t, []bool{true}, asked, "the passphrase is asked for once per run, confirmed for the new file")
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "acmeSecret", "the keys are encrypted")
+	assert.NotContains(t, string(data), "AKIAACME")
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	t.Run("Reads the keys with the passphrase", func(t *testing.T) {
+		asked = nil
+		reopened := &FileKeyStore{Path: path, Passphrase: passphrase}
+		keys, err := reopened.Keys("acme")
+		assert.NoError(t, err)
+		assert.Equal(t, "AKIAACME", keys.Credentials.AccessKeyID)
+		assert.Equal(t, "acmeSecret", keys.Credentials.SecretAccessKey)
+		names, err := reopened.Names()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"acme", "staging"}, names)
+		assert.Equal(t, []bool{false}, asked)
+	})
+
+	t.Run("Confirms the passphrase of new files", func(t *testing.T) {
+		asked = nil
+		created := &FileKeyStore{Path: filepath.Join(t.TempDir(), "keys.enc"), Passphrase: passphrase}
+		assert.NoError(t, created.StoreKeys("acme", keyPair("AKIAACME", "acmeSecret")))
+		assert.Equal(t, []bool{true}, asked)
+	})
+
+	t.Run("Refuses the wrong passphrase", func(t *testing.T) {
+		wrong := &FileKeyStore{Path: path, Passphrase: func(bool) (secret.Value, error) { return "battery staple", nil }}
+		_, err := wrong.Keys("acme")
+		assert.ErrorIs(t, err, ErrKeyFilePassphrase)
+		assert.ErrorIs(t, wrong.StoreKeys("other", keyPair("AKIAOTHER", "otherSecret")), ErrKeyFilePassphrase)
+
+		empty := &FileKeyStore{Path: path, Passphrase: func(bool) (secret.Value, error) { return "", nil }}
+		_, err = empty.Keys("acme")
+		assert.ErrorContains(t, err, "needs a passphrase")
+
+		failing := &FileKeyStore{Path: path, Passphrase: func(bool) (secret.Value, error) { return "", errors.New("no tty") }}
+		_, err = failing.Keys("acme")
+		assert.ErrorContains(t, err, "no tty")
+	})
+
+	t.Run("Deletes the keys", func(t *testing.T) {
+		assert.NoError(t, store.DeleteKeys("staging"))
+		assert.ErrorIs(t, store.DeleteKeys("staging"), ErrNoStoredKeys)
+		names, err := store.Names()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"acme"}, names)
+	})
+
+	t.Run("Refuses unknown layouts", func(t *testing.T) {
+		other := filepath.Join(t.TempDir(), "keys.enc")
+		assert.NoError(t, os.WriteFile(other, []byte(`{"version":2,"kdf":"argon2id"}`), 0o600))
+		_, err := (&FileKeyStore{Path: other, Passphrase: passphrase}).Keys("acme")
+		assert.ErrorContains(t, err, "has version 2 with argon2id")
+	})
+}
+
+func TestRemoveSourceKeys(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	assert.NoError(t, os.MkdirAll(filepath.Dir(CredentialsPath()), 0o700))
+	assert.NoError(t, os.WriteFile(CredentialsPath(), []byte(`[default]
+aws_access_key_id = AKIADEFAULT
+aws_secret_access_key = defaultSecret
+[work]
+aws_access_key_id = AKIAWORK
+aws_secret_access_key = workSecret
+region = eu-west-1
+[default-mfa]
+aws_access_key_id = ASIASESSION
+`), 0o600))
+
+	assert.NoError(t, (&AwsConfig{}).RemoveSourceKeys())
+	assert.NoError(t, (&AwsConfig{sourceProfile: "work"}).RemoveSourceKeys())
+	assert.NoError(t, (&AwsConfig{sourceProfile: "missing"}).RemoveSourceKeys())
+
+	inidata, err := ini.Load(CredentialsPath())
+	assert.NoError(t, err)
+	assert.False(t, inidata.HasSection("default"), "empty sections are removed")
+	assert.Equal(t, []string{"region"}, inidata.Section("work").KeyStrings(), "other settings are kept")
+	assert.Equal(t, "ASIASESSION", inidata.Section("default-mfa").Key("aws_access_key_id").String())
+}
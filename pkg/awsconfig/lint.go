@@ -0,0 +1,165 @@
+package awsconfig
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"gredentures/pkg/appconfig"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"gopkg.in/ini.v1"
+)
+
+// LintCredentials checks the shared credentials file at path for problems the AWS tools trip
+// over: profiles defined twice, which the AWS CLI refuses to read, keys set twice or outside of
+// any profile, incomplete key pairs, session tokens left next to long-lived keys, and
+// permissions letting other users read the file. With fix set, the fixable ones are resolved
+// by rewriting the file atomically: profiles defined twice are merged into one, the later keys
+// winning as they do for the SDKs, keys outside of any profile and stale session tokens are
+// removed, and the file is made readable by its owner only. A missing file has no problems.
+func LintCredentials(path string, fix bool) ([]appconfig.LintIssue, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	raw, err := ini.LoadSources(ini.LoadOptions{AllowNonUniqueSections: true}, data)
+	if err != nil {
+		return []appconfig.LintIssue{{File: path, Problem: err.Error()}}, nil
+	}
+
+	issues := scanCredentials(path, data)
+	rewrite := len(issues) > 0 // Everything the scan finds is fixed by merging
+	merged := mergeSections(raw)
+	for _, section := range merged.Sections() {
+		name := section.Name()
+		if name == ini.DefaultSection {
+			continue
+		}
+		// Key would add the keys it is asked for to the file
+		value := func(key string) string {
+			if key, err := section.GetKey(key); err == nil {
+				return key.String()
+			}
+			return ""
+		}
+		creds := aws.Credentials{
+			AccessKeyID:     value("aws_access_key_id"),
+			SecretAccessKey: value("aws_secret_access_key"),
+			SessionToken:    cmp.Or(value("aws_session_token"), value("aws_security_token")),
+		}
+		switch {
+		case (creds.AccessKeyID == "") != (creds.SecretAccessKey == ""):
+			issues = append(issues, appconfig.LintIssue{File: path,
+				Problem: fmt.Sprintf("profile %s holds only half of an access key pair", name)})
+		case creds.SessionToken != "" && creds.AccessKeyID == "":
+			issues = append(issues, appconfig.LintIssue{File: path,
+				Problem: fmt.Sprintf("profile %s holds a session token without access keys", name)})
+		case staleSessionToken(creds):
+			issues = append(issues, appconfig.LintIssue{File: path, Fix: "remove the session token",
+				Problem: fmt.Sprintf("profile %s holds a session token next to the long-lived key %s, STS rejects them together", name, creds.AccessKeyID)})
+			for _, key := range sessionTokenKeys {
+				section.DeleteKey(key)
+			}
+			rewrite = true
+		}
+	}
+	if mode := info.Mode().Perm(); mode&0o077 != 0 {
+		issues = append(issues, appconfig.LintIssue{File: path, Fix: "chmod 600",
+			Problem: fmt.Sprintf("mode %04o lets other users read the keys", mode)})
+	}
+	if !fix {
+		return issues, nil
+	}
+
+	switch {
+	case rewrite:
+		// The temporary file replacing it is created with mode 0600
+		if err := saveAtomic(merged, path); err != nil {
+			return issues, fmt.Errorf("failed to save %s: %w", path, err)
+		}
+	case info.Mode().Perm()&0o077 != 0:
+		if err := os.Chmod(path, 0o600); err != nil {
+			return issues, fmt.Errorf("failed to set permissions on %s: %w", path, err)
+		}
+	}
+	for i := range issues {
+		issues[i].Fixed = issues[i].Fix != ""
+	}
+	return issues, nil
+}
+
+// scanCredentials reports, with their lines, the profiles of a credentials file defined more
+// than once, the keys set again within a profile, and the keys above the first profile, which
+// every SDK ignores.
+func scanCredentials(path string, data []byte) []appconfig.LintIssue {
+	var issues []appconfig.LintIssue
+	sections := map[string]int{}
+	keys := map[string]map[string]int{}
+	section := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		switch {
+		case text == "" || strings.HasPrefix(text, "#") || strings.HasPrefix(text, ";"):
+		case strings.HasPrefix(text, "[") && strings.Contains(text, "]"):
+			section = strings.TrimSpace(text[1:strings.Index(text, "]")])
+			if first, ok := sections[section]; ok {
+				issues = append(issues, appconfig.LintIssue{File: path, Line: line, Fix: "merge it into the first",
+					Problem: fmt.Sprintf("profile %s is defined again, first at line %d", section, first)})
+				continue
+			}
+			sections[section] = line
+			keys[section] = map[string]int{}
+		case strings.Contains(text, "="):
+			key := strings.TrimSpace(text[:strings.Index(text, "=")])
+			if section == "" {
+				issues = append(issues, appconfig.LintIssue{File: path, Line: line, Fix: "remove it",
+					Problem: fmt.Sprintf("key %s is outside of any profile", key)})
+				continue
+			}
+			if first, ok := keys[section][key]; ok {
+				issues = append(issues, appconfig.LintIssue{File: path, Line: line, Fix: "keep the last value",
+					Problem: fmt.Sprintf("key %s of profile %s is set again, first at line %d", key, section, first)})
+				continue
+			}
+			keys[section][key] = line
+		}
+	}
+	return issues
+}
+
+// mergeSections returns the sections of a credentials file loaded with repeated sections as one
+// section each, with the keys of the later ones winning. The keys outside of any profile are
+// dropped. Comments, such as managedMarker, are kept from the first section carrying one.
+func mergeSections(raw *ini.File) *ini.File {
+	merged := ini.Empty()
+	for _, section := range raw.Sections() {
+		if section.Name() == ini.DefaultSection {
+			continue
+		}
+		target, _ := merged.NewSection(section.Name()) // Returns the section already merged into
+		if target.Comment == "" {
+			target.Comment = section.Comment
+		}
+		for _, key := range section.Keys() {
+			merge := target.Key(key.Name())
+			merge.SetValue(key.Value())
+			if key.Comment != "" {
+				merge.Comment = key.Comment
+			}
+		}
+	}
+	return merged
+}
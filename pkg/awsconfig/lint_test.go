@@ -0,0 +1,115 @@
+package awsconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gredentures/pkg/appconfig"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLintCredentials(t *testing.T) {
+	const data = `region = us-east-1
+
+# gredentures:managed
+[default-mfa]
+aws_access_key_id = ASIAOLD
+aws_access_key_id = ASIANEW
+
+[default]
+aws_access_key_id = AKIAKEYS
+aws_secret_access_key = secret
+aws_session_token = stale
+
+[default-mfa]
+aws_secret_access_key = sessionsecret
+aws_session_token = token
+
+[half]
+aws_access_key_id = AKIAHALF
+`
+
+	t.Run("Reports the problems without changing the file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "credentials")
+		require.NoError(t, os.WriteFile(path, []byte(data), 0o644))
+		require.NoError(t, os.Chmod(path, 0o644))
+
+		issues, err := LintCredentials(path, false)
+		require.NoError(t, err)
+		assert.Equal(t, []appconfig.LintIssue{
+			{File: path, Line: 1, Problem: "key region is outside of any profile", Fix: "remove it"},
+			{File: path, Line: 6, Problem: "key aws_access_key_id of profile default-mfa is set again, first at line 5", Fix: "keep the last value"},
+			{File: path, Line: 13, Problem: "profile default-mfa is defined again, first at line 4", Fix: "merge it into the first"},
+			{File: path, Problem: "profile default holds a session token next to the long-lived key AKIAKEYS, STS rejects them together", Fix: "remove the session token"},
+			{File: path, Problem: "profile half holds only half of an access key pair"},
+			{File: path, Problem: "mode 0644 lets other users read the keys", Fix: "chmod 600"},
+		}, issues)
+
+		written, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, data, string(written))
+	})
+
+	t.Run("Fixes what it can with --fix", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "credentials")
+		require.NoError(t, os.WriteFile(path, []byte(data), 0o644))
+		require.NoError(t, os.Chmod(path, 0o644))
+
+		issues, err := LintCredentials(path, true)
+		require.NoError(t, err)
+		for _, issue := range issues {
+			assert.Equal(t, issue.Fix != "", issue.Fixed, issue.Problem)
+		}
+
+		written, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, `# gredentures:managed
+[default-mfa]
+aws_access_key_id     = ASIANEW
+aws_secret_access_key = sessionsecret
+aws_session_token     = token
+
+[default]
+aws_access_key_id     = AKIAKEYS
+aws_secret_access_key = secret
+
+[half]
+aws_access_key_id = AKIAHALF
+`, string(written), "the marker of the merged profile is kept")
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+		issues, err = LintCredentials(path, false)
+		require.NoError(t, err)
+		assert.Equal(t, []appconfig.LintIssue{{File: path, Problem: "profile half holds only half of an access key pair"}}, issues)
+	})
+
+	t.Run("Only fixes the permissions of a healthy file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "credentials")
+		healthy := "[default]\naws_access_key_id = AKIAKEYS # keys\naws_secret_access_key = secret\n"
+		require.NoError(t, os.WriteFile(path, []byte(healthy), 0o644))
+		require.NoError(t, os.Chmod(path, 0o644))
+
+		issues, err := LintCredentials(path, true)
+		require.NoError(t, err)
+		require.Len(t, issues, 1)
+		assert.True(t, issues[0].Fixed)
+
+		written, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, healthy, string(written), "the file is not rewritten")
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	})
+
+	t.Run("Has nothing to report without a file", func(t *testing.T) {
+		issues, err := LintCredentials(filepath.Join(t.TempDir(), "credentials"), true)
+		require.NoError(t, err)
+		assert.Empty(t, issues)
+	})
+}
@@ -0,0 +1,93 @@
+package awsconfig
+
+import (
+	"context"
+	"fmt"
+	"gredentures/pkg/appconfig"
+	"log/slog"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// mfaProbeActions are simulated to decide whether the source user's policies depend on MFA.
+// They cover the common shapes of an MFA-enforcing policy: denying everything without MFA,
+// or only allowing role assumption and read access with it.
+var mfaProbeActions = []string{"sts:AssumeRole", "iam:ListAccountAliases", "s3:ListAllMyBuckets", "ec2:DescribeRegions"}
+
+// policySimulator is the subset of the IAM client used to detect MFA enforcement.
+type policySimulator interface {
+	SimulatePrincipalPolicy(ctx context.Context, params *iam.SimulatePrincipalPolicyInput, optFns ...func(*iam.Options)) (*iam.SimulatePrincipalPolicyOutput, error)
+}
+
+// sessionTokenInput builds the GetSessionToken request, leaving out the MFA device and token
+// for --no-mfa sessions.
+func sessionTokenInput(appconfig appconfig.AppConfig) *sts.GetSessionTokenInput {
+	input := &sts.GetSessionTokenInput{DurationSeconds: aws.Int32(appconfig.Timeout)}
+	if !appconfig.NoMFA {
+		input.SerialNumber = aws.String(appconfig.Device)
+		input.TokenCode = aws.String(appconfig.Token.Reveal())
+	}
+	return input
+}
+
+// mfaRequired reports whether the policies of the calling IAM user allow any of
+// mfaProbeActions only when aws:MultiFactorAuthPresent is true. A session obtained without MFA
+// is issued regardless, but every call it makes would then be denied. Callers that are not IAM
+// users, such as the root user, cannot be simulated and are reported as not requiring MFA.
+// The caller's ARN is returned as well, also when only the simulation failed.
+func mfaRequired(ctx context.Context, client stsAPI, simulator policySimulator) (arn string, required bool, err error) {
+	arn, err = callerIdentity(ctx, client)
+	if err != nil {
+		return "", false, err
+	}
+	if !strings.Contains(arn, ":user/") {
+		slog.Debug("Caller is not an IAM user, skipping MFA detection", "arn", arn)
+		return arn, false, nil
+	}
+
+	withMFA, err := allowedActions(ctx, simulator, arn, "true")
+	if err != nil {
+		return arn, false, err
+	}
+	withoutMFA, err := allowedActions(ctx, simulator, arn, "false")
+	if err != nil {
+		return arn, false, err
+	}
+
+	for action := range withMFA {
+		if !withoutMFA[action] {
+			slog.Debug("Action requires MFA", "action", action, "arn", arn)
+			return arn, true, nil
+		}
+	}
+	return arn, false, nil
+}
+
+// allowedActions simulates mfaProbeActions for arn with aws:MultiFactorAuthPresent set to
+// present and returns the actions that are allowed.
+func allowedActions(ctx context.Context, simulator policySimulator, arn, present string) (map[string]bool, error) {
+	out, err := simulator.SimulatePrincipalPolicy(ctx, &iam.SimulatePrincipalPolicyInput{
+		PolicySourceArn: aws.String(arn),
+		ActionNames:     mfaProbeActions,
+		ContextEntries: []iamtypes.ContextEntry{{
+			ContextKeyName:   aws.String("aws:MultiFactorAuthPresent"),
+			ContextKeyType:   iamtypes.ContextKeyTypeEnumBoolean,
+			ContextKeyValues: []string{present},
+		}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to simulate the policies of %s: %w", arn, err)
+	}
+
+	allowed := map[string]bool{}
+	for _, result := range out.EvaluationResults {
+		if result.EvalDecision == iamtypes.PolicyEvaluationDecisionTypeAllowed {
+			allowed[aws.ToString(result.EvalActionName)] = true
+		}
+	}
+	return allowed, nil
+}
@@ -0,0 +1,90 @@
+package awsconfig
+
+import (
+	"context"
+	"fmt"
+	"gredentures/pkg/appconfig"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockSimulator allows the given actions, plus mfaActions when MFA is present.
+type mockSimulator struct {
+	allowed    []string
+	mfaActions []string
+	err        error
+}
+
+func (m *mockSimulator) SimulatePrincipalPolicy(ctx context.Context, params *iam.SimulatePrincipalPolicyInput, optFns ...func(*iam.Options)) (*iam.SimulatePrincipalPolicyOutput, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	present := params.ContextEntries[0].ContextKeyValues[0] == "true"
+
+	out := &iam.SimulatePrincipalPolicyOutput{}
+	for _, action := range params.ActionNames {
+		decision := iamtypes.PolicyEvaluationDecisionTypeImplicitDeny
+		if slices.Contains(m.allowed, action) || (present && slices.Contains(m.mfaActions, action)) {
+			decision = iamtypes.PolicyEvaluationDecisionTypeAllowed
+		}
+		out.EvaluationResults = append(out.EvaluationResults, iamtypes.EvaluationResult{EvalActionName: aws.String(action), EvalDecision: decision})
+	}
+	return out, nil
+}
+
+// callerSTS returns an STS mock whose caller is arn.
+func callerSTS(arn string) *MockSTSClient {
+	return &MockSTSClient{
+		GetCallerIdentityFunc: func(ctx context.Context, params *sts.GetCallerIdentityInput, optFns ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error) {
+			return &sts.GetCallerIdentityOutput{Arn: aws.String(arn)}, nil
+		},
+	}
+}
+
+func TestMFARequired(t *testing.T) {
+	user := "arn:aws:iam::123456789012:user/me"
+
+	t.Run("Policies allowing calls only with MFA", func(t *testing.T) {
+		caller, required, err := mfaRequired(context.TODO(), callerSTS(user), &mockSimulator{allowed: []string{"iam:ListAccountAliases"}, mfaActions: []string{"sts:AssumeRole"}})
+		assert.NoError(t, err)
+		assert.True(t, required)
+		assert.Equal(t, user, caller)
+	})
+
+	t.Run("Policies ignoring MFA", func(t *testing.T) {
+		_, required, err := mfaRequired(context.TODO(), callerSTS(user), &mockSimulator{allowed: mfaProbeActions})
+		assert.NoError(t, err)
+		assert.False(t, required)
+	})
+
+	t.Run("Callers other than IAM users are not simulated", func(t *testing.T) {
+		_, required, err := mfaRequired(context.TODO(), callerSTS("arn:aws:iam::123456789012:root"), &mockSimulator{err: fmt.Errorf("not called")})
+		assert.NoError(t, err)
+		assert.False(t, required)
+	})
+
+	t.Run("Simulation denied", func(t *testing.T) {
+		caller, _, err := mfaRequired(context.TODO(), callerSTS(user), &mockSimulator{err: fmt.Errorf("AccessDenied")})
+		assert.ErrorContains(t, err, "failed to simulate the policies of "+user)
+		assert.Equal(t, user, caller, "the caller is known all the same")
+	})
+}
+
+func TestSessionTokenInput(t *testing.T) {
+	app := appconfig.AppConfig{Timeout: 3600, Device: "arn:aws:iam::123456789012:mfa/me", Token: "123456"}
+	input := sessionTokenInput(app)
+	assert.Equal(t, int32(3600), aws.ToInt32(input.DurationSeconds))
+	assert.Equal(t, app.Device, aws.ToString(input.SerialNumber))
+	assert.Equal(t, "123456", aws.ToString(input.TokenCode))
+
+	app.NoMFA = true
+	input = sessionTokenInput(app)
+	assert.Nil(t, input.SerialNumber)
+	assert.Nil(t, input.TokenCode)
+}
@@ -0,0 +1,43 @@
+package awsconfig
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+)
+
+// UseProfile takes the session credentials from profile in the credentials file at path
+// instead of requesting them from STS, for gredentures exec --offline. No AWS call is made,
+// so it works when STS cannot be reached. Credentials that expired before now are refused, a
+// command would only fail with them later; those without an expiry are used as they are.
+func (conf *AwsConfig) UseProfile(path, profile string, now time.Time) (Profile, error) {
+	read, err := ReadProfile(path, profile)
+	if err != nil {
+		return Profile{}, err
+	}
+	if read.Credentials.AccessKeyID == "" || read.Credentials.SecretAccessKey == "" {
+		return Profile{}, fmt.Errorf("%w: profile %s in %s holds no access key", ErrIncompleteCredentials, profile, path)
+	}
+	if read.Credentials.CanExpire && !read.Credentials.Expires.After(now) {
+		return Profile{}, fmt.Errorf("the credentials of profile %s expired at %s, log in again once STS can be reached",
+			profile, read.Credentials.Expires.Local().Format(time.RFC3339))
+	}
+
+	creds := &types.Credentials{
+		AccessKeyId:     aws.String(read.Credentials.AccessKeyID),
+		SecretAccessKey: aws.String(read.Credentials.SecretAccessKey),
+		SessionToken:    aws.String(read.Credentials.SessionToken),
+	}
+	if read.Credentials.CanExpire {
+		creds.Expiration = aws.Time(read.Credentials.Expires)
+	}
+	conf.sessionCreds = &sts.GetSessionTokenOutput{Credentials: creds}
+	conf.sessionProfile = profile
+	if read.Region != "" {
+		conf.region = read.Region
+	}
+	return read, nil
+}
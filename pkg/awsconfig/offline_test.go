@@ -0,0 +1,56 @@
+package awsconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUseProfile(t *testing.T) {
+	now := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "credentials")
+	assert.NoError(t, os.WriteFile(path, []byte(`[default-mfa]
+aws_access_key_id = ASIAEXAMPLE
+aws_secret_access_key = secret
+aws_session_token = token
+region = eu-west-1
+x_security_token_expires = 2030-01-02T05:00:00Z
+
+[stale-mfa]
+aws_access_key_id = ASIASTALE
+aws_secret_access_key = secret
+aws_session_token = token
+x_security_token_expires = 2030-01-02T03:00:00Z
+
+[empty]
+region = us-east-1
+`), 0o600))
+
+	conf := &AwsConfig{}
+	profile, err := conf.UseProfile(path, "default-mfa", now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2030, 1, 2, 5, 0, 0, 0, time.UTC), profile.Credentials.Expires)
+
+	env, err := conf.SessionEnv([]string{"AWS_PROFILE=other"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"AWS_ACCESS_KEY_ID=ASIAEXAMPLE",
+		"AWS_SECRET_ACCESS_KEY=secret",
+		"AWS_SESSION_TOKEN=token",
+		"AWS_CREDENTIAL_EXPIRATION=2030-01-02T05:00:00Z",
+		"AWS_REGION=eu-west-1",
+		"AWS_DEFAULT_REGION=eu-west-1",
+	}, env)
+
+	_, err = (&AwsConfig{}).UseProfile(path, "stale-mfa", now)
+	assert.ErrorContains(t, err, "the credentials of profile stale-mfa expired")
+
+	_, err = (&AwsConfig{}).UseProfile(path, "empty", now)
+	assert.ErrorIs(t, err, ErrIncompleteCredentials)
+
+	_, err = (&AwsConfig{}).UseProfile(path, "missing", now)
+	assert.ErrorContains(t, err, "profile missing not found")
+}
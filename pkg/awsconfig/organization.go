@@ -0,0 +1,140 @@
+package awsconfig
+
+import (
+	"context"
+	"fmt"
+	"gredentures/pkg/appconfig"
+	"gredentures/pkg/interrupt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/organizations"
+	orgtypes "github.com/aws/aws-sdk-go-v2/service/organizations/types"
+)
+
+// organizationsAPI is the subset of the Organizations client used to list accounts, so it can be mocked in tests.
+type organizationsAPI interface {
+	DescribeOrganization(ctx context.Context, params *organizations.DescribeOrganizationInput, optFns ...func(*organizations.Options)) (*organizations.DescribeOrganizationOutput, error)
+	ListAccounts(ctx context.Context, params *organizations.ListAccountsInput, optFns ...func(*organizations.Options)) (*organizations.ListAccountsOutput, error)
+	ListTagsForResource(ctx context.Context, params *organizations.ListTagsForResourceInput, optFns ...func(*organizations.Options)) (*organizations.ListTagsForResourceOutput, error)
+}
+
+// OrganizationAccount is an active account of an AWS Organization and the org generated for it.
+type OrganizationAccount struct {
+	ID      string            // Account ID.
+	Name    string            // Account name as shown in Organizations.
+	Org     string            // Generated org name, the account name made safe for the config.
+	RoleArn string            // Role assumed in the account, see appconfig.OrganizationConfig.
+	Tags    map[string]string // Account tags, only read when a tag filter is configured.
+}
+
+// OrganizationAccounts lists the active accounts of the AWS Organization named by the Org
+// option with the MFA session credentials, keeping only those carrying every tag of
+// Organization.Tags. The session has to belong to the management account or a delegated
+// administrator.
+func (conf *AwsConfig) OrganizationAccounts(app appconfig.AppConfig) ([]OrganizationAccount, error) {
+	if conf.sessionCreds == nil || conf.sessionCreds.Credentials == nil {
+		return nil, fmt.Errorf("session credentials are required to list organization accounts")
+	}
+
+	config, err := conf.sessionAccount()
+	if err != nil {
+		return nil, err
+	}
+	return organizationAccounts(interrupt.Context(), conf.clients(config).organizations(), app.Org, app.Organization)
+}
+
+// organizationAccounts lists the active accounts of organization id that match settings.Tags,
+// sorted by their generated org name. Account names that turn into the same org name are
+// suffixed with the account ID.
+func organizationAccounts(ctx context.Context, client organizationsAPI, id string, settings appconfig.OrganizationConfig) ([]OrganizationAccount, error) {
+	org, err := client.DescribeOrganization(ctx, &organizations.DescribeOrganizationInput{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe the organization: %w", err)
+	}
+	if actual := aws.ToString(org.Organization.Id); actual != id {
+		return nil, fmt.Errorf("the session belongs to organization %s, not %s", actual, id)
+	}
+	partition := "aws"
+	if parsed, err := arn.Parse(aws.ToString(org.Organization.Arn)); err == nil {
+		partition = parsed.Partition
+	}
+
+	var accounts []OrganizationAccount
+	pages := organizations.NewListAccountsPaginator(client, &organizations.ListAccountsInput{})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the accounts of organization %s: %w", id, err)
+		}
+		for _, account := range page.Accounts {
+			if account.Status != orgtypes.AccountStatusActive {
+				continue
+			}
+			accounts = append(accounts, OrganizationAccount{
+				ID:      aws.ToString(account.Id),
+				Name:    aws.ToString(account.Name),
+				RoleArn: fmt.Sprintf("arn:%s:iam::%s:role/%s", partition, aws.ToString(account.Id), settings.Role()),
+			})
+		}
+	}
+
+	if len(settings.Tags) > 0 {
+		matched := accounts[:0]
+		for _, account := range accounts {
+			if account.Tags, err = accountTags(ctx, client, account.ID); err != nil {
+				return nil, err
+			}
+			if tagsMatch(account.Tags, settings.Tags) {
+				matched = append(matched, account)
+			} else {
+				slog.Debug("Skipping account without the configured tags", "account", account.ID)
+			}
+		}
+		accounts = matched
+	}
+
+	counts := map[string]int{}
+	for _, account := range accounts {
+		counts[appconfig.OrgName(account.Name)]++
+	}
+	for i, account := range accounts {
+		accounts[i].Org = appconfig.OrgName(account.Name)
+		if counts[accounts[i].Org] > 1 || accounts[i].Org == "" {
+			accounts[i].Org = strings.TrimPrefix(accounts[i].Org+"-"+account.ID, "-")
+		}
+	}
+	slices.SortFunc(accounts, func(a, b OrganizationAccount) int { return strings.Compare(a.Org, b.Org) })
+	return accounts, nil
+}
+
+// accountTags returns the tags of an account.
+func accountTags(ctx context.Context, client organizationsAPI, accountID string) (map[string]string, error) {
+	tags := map[string]string{}
+	pages := organizations.NewListTagsForResourcePaginator(client, &organizations.ListTagsForResourceInput{ResourceId: aws.String(accountID)})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the tags of account %s: %w", accountID, err)
+		}
+		for _, tag := range page.Tags {
+			tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+		}
+	}
+	return tags, nil
+}
+
+// tagsMatch reports whether tags holds every key of filter with the same value. An empty
+// filter value accepts any value of the key.
+func tagsMatch(tags, filter map[string]string) bool {
+	for key, want := range filter {
+		value, ok := tags[key]
+		if !ok || (want != "" && value != want) {
+			return false
+		}
+	}
+	return true
+}
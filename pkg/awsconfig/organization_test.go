@@ -0,0 +1,85 @@
+package awsconfig
+
+import (
+	"context"
+	"gredentures/pkg/appconfig"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/organizations"
+	orgtypes "github.com/aws/aws-sdk-go-v2/service/organizations/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeOrganizations serves organization o-a1b2c3d4e5 with accounts and their tags.
+type fakeOrganizations struct {
+	accounts []orgtypes.Account
+	tags     map[string]map[string]string
+}
+
+func (f *fakeOrganizations) DescribeOrganization(ctx context.Context, params *organizations.DescribeOrganizationInput, optFns ...func(*organizations.Options)) (*organizations.DescribeOrganizationOutput, error) {
+	return &organizations.DescribeOrganizationOutput{Organization: &orgtypes.Organization{
+		Id:  aws.String("o-a1b2c3d4e5"),
+		Arn: aws.String("arn:aws-us-gov:organizations::111111111111:organization/o-a1b2c3d4e5"),
+	}}, nil
+}
+
+func (f *fakeOrganizations) ListAccounts(ctx context.Context, params *organizations.ListAccountsInput, optFns ...func(*organizations.Options)) (*organizations.ListAccountsOutput, error) {
+	return &organizations.ListAccountsOutput{Accounts: f.accounts}, nil
+}
+
+func (f *fakeOrganizations) ListTagsForResource(ctx context.Context, params *organizations.ListTagsForResourceInput, optFns ...func(*organizations.Options)) (*organizations.ListTagsForResourceOutput, error) {
+	out := &organizations.ListTagsForResourceOutput{}
+	for key, value := range f.tags[*params.ResourceId] {
+		out.Tags = append(out.Tags, orgtypes.Tag{Key: aws.String(key), Value: aws.String(value)})
+	}
+	return out, nil
+}
+
+func TestOrganizationAccounts(t *testing.T) {
+	account := func(id, name string, status orgtypes.AccountStatus) orgtypes.Account {
+		return orgtypes.Account{Id: aws.String(id), Name: aws.String(name), Status: status}
+	}
+	client := &fakeOrganizations{
+		accounts: []orgtypes.Account{
+			account("111111111111", "Management", orgtypes.AccountStatusActive),
+			account("222222222222", "Prod EU", orgtypes.AccountStatusActive),
+			account("333333333333", "sandbox", orgtypes.AccountStatusActive),
+			account("444444444444", "Sandbox", orgtypes.AccountStatusActive),
+			account("555555555555", "Closed", orgtypes.AccountStatusSuspended),
+		},
+		tags: map[string]map[string]string{
+			"222222222222": {"env": "prod", "team": "platform"},
+			"333333333333": {"env": "dev", "team": "platform"},
+			"444444444444": {"env": "dev"},
+		},
+	}
+
+	t.Run("Every active account", func(t *testing.T) {
+		accounts, err := organizationAccounts(context.TODO(), client, "o-a1b2c3d4e5", appconfig.OrganizationConfig{})
+		assert.NoError(t, err)
+		var orgs []string
+		for _, account := range accounts {
+			orgs = append(orgs, account.Org)
+		}
+		assert.Equal(t, []string{"management", "prod-eu", "sandbox-333333333333", "sandbox-444444444444"}, orgs)
+		assert.Equal(t, "arn:aws-us-gov:iam::222222222222:role/OrganizationAccountAccessRole", accounts[1].RoleArn)
+	})
+
+	t.Run("Tag filter", func(t *testing.T) {
+		accounts, err := organizationAccounts(context.TODO(), client, "o-a1b2c3d4e5", appconfig.OrganizationConfig{
+			RoleName: "Admin",
+			Tags:     map[string]string{"team": "platform", "env": ""},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []OrganizationAccount{
+			{ID: "222222222222", Name: "Prod EU", Org: "prod-eu", RoleArn: "arn:aws-us-gov:iam::222222222222:role/Admin", Tags: map[string]string{"env": "prod", "team": "platform"}},
+			{ID: "333333333333", Name: "sandbox", Org: "sandbox", RoleArn: "arn:aws-us-gov:iam::333333333333:role/Admin", Tags: map[string]string{"env": "dev", "team": "platform"}},
+		}, accounts)
+	})
+
+	t.Run("Other organization", func(t *testing.T) {
+		_, err := organizationAccounts(context.TODO(), client, "o-zzzzzzzzzz", appconfig.OrganizationConfig{})
+		assert.ErrorContains(t, err, "the session belongs to organization o-a1b2c3d4e5, not o-zzzzzzzzzz")
+	})
+}
@@ -0,0 +1,16 @@
+package awsconfig
+
+import "gredentures/pkg/appconfig"
+
+// partitionRegions are the regions the sessions of partitions other than the commercial one
+// are requested in, and written with, when nothing else names a region.
+var partitionRegions = map[string]string{
+	appconfig.PartitionGovCloud: "us-gov-west-1",
+	appconfig.PartitionChina:    "cn-north-1",
+}
+
+// PartitionRegion returns the region of partition, "" for the commercial partition, which
+// keeps the default region.
+func PartitionRegion(partition string) string {
+	return partitionRegions[partition]
+}
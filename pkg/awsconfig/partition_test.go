@@ -0,0 +1,59 @@
+package awsconfig
+
+import (
+	"bytes"
+	"log/slog"
+	"maps"
+	"slices"
+	"testing"
+
+	"gredentures/pkg/appconfig"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartitionRegion(t *testing.T) {
+	assert.Equal(t, "us-gov-west-1", PartitionRegion(appconfig.PartitionGovCloud))
+	assert.Equal(t, "cn-north-1", PartitionRegion(appconfig.PartitionChina))
+	assert.Empty(t, PartitionRegion(appconfig.PartitionAWS))
+}
+
+func TestSetSourceProfilePartition(t *testing.T) {
+	app := appconfig.AppConfig{
+		Org: "gov",
+		Orgs: map[string]appconfig.OrgConfig{
+			"gov":  {RoleArn: "arn:aws-us-gov:iam::333333333333:role/Admin", SourceProfile: "gov-keys"},
+			"prod": {RoleArn: "arn:aws:iam::111111111111:role/Admin"},
+		},
+	}
+	conf := &AwsConfig{}
+	conf.SetSourceProfile(app)
+	assert.Equal(t, appconfig.PartitionGovCloud, conf.partition)
+	assert.Equal(t, "us-gov-west-1", conf.region)
+	assert.Equal(t, "gov-keys", conf.SourceProfileName())
+
+	app.Org = "prod"
+	conf = &AwsConfig{}
+	conf.SetSourceProfile(app)
+	assert.Equal(t, appconfig.PartitionAWS, conf.partition)
+	assert.Empty(t, conf.region, "the default region is kept")
+}
+
+func TestPartitionOrgs(t *testing.T) {
+	var logs bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	defer resetLogging()
+
+	orgs := map[string]appconfig.OrgConfig{
+		"prod": {RoleArn: "arn:aws:iam::111111111111:role/Admin"},
+		"gov":  {RoleArn: "arn:aws-us-gov:iam::333333333333:role/Admin", LinkedAccount: "111111111111"},
+	}
+
+	conf := &AwsConfig{}
+	assert.Equal(t, []string{"prod"}, slices.Collect(maps.Keys(conf.partitionOrgs(orgs))))
+	assert.Contains(t, logs.String(), "Skipping org in another partition")
+	assert.Contains(t, logs.String(), "linked_account=111111111111")
+
+	conf.partition = appconfig.PartitionGovCloud
+	assert.Equal(t, []string{"gov"}, slices.Collect(maps.Keys(conf.partitionOrgs(orgs))))
+}
@@ -0,0 +1,77 @@
+package awsconfig
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"gopkg.in/ini.v1"
+)
+
+// ReadProfile reads the credentials of profile from the shared credentials file at path. The
+// expiry is read from the first of expiryKeys the profile has, so it is known for profiles
+// written by gredentures and by helpers such as saml2aws and awsume. Profiles without any of
+// them are returned with CanExpire unset.
+func ReadProfile(path, profile string) (Profile, error) {
+	file, err := ini.Load(path)
+	if err != nil {
+		return Profile{}, fmt.Errorf("failed to load %s: %w", path, err)
+	}
+	section, err := file.GetSection(profile)
+	if err != nil {
+		return Profile{}, fmt.Errorf("profile %s not found in %s", profile, path)
+	}
+
+	read := Profile{
+		Name:   profile,
+		Region: section.Key("region").String(),
+		Credentials: aws.Credentials{
+			AccessKeyID:     section.Key("aws_access_key_id").String(),
+			SecretAccessKey: section.Key("aws_secret_access_key").String(),
+			SessionToken:    section.Key("aws_session_token").String(),
+			Source:          path,
+		},
+	}
+	for _, key := range expiryKeys {
+		value := section.Key(key).String()
+		if value == "" {
+			continue
+		}
+		expires, err := parseExpiry(value)
+		if err != nil {
+			return Profile{}, fmt.Errorf("profile %s in %s: invalid %s %q: %w", profile, path, key, value, err)
+		}
+		read.Credentials.CanExpire, read.Credentials.Expires = true, expires
+		break
+	}
+	return read, nil
+}
+
+// RedactedSecret returns the secret access key with all but its last four characters hidden, the
+// same way --no-write prints it, so keys can be told apart without being revealed.
+func (p Profile) RedactedSecret() string {
+	return redact(p.Credentials.SecretAccessKey)
+}
+
+// Freshness is how usable the credentials of a profile are at a point in time.
+type Freshness int
+
+const (
+	FreshnessValid        Freshness = iota // Long-lived, of unknown expiry, or valid beyond the window.
+	FreshnessExpiringSoon                  // Still valid, but expiring within the window.
+	FreshnessExpired                       // Past their expiry.
+)
+
+// Freshness reports how usable the credentials of p are at now, treating those expiring
+// within window as expiring soon.
+func (p Profile) Freshness(now time.Time, window time.Duration) Freshness {
+	switch {
+	case !p.Credentials.CanExpire:
+		return FreshnessValid
+	case !p.Credentials.Expires.After(now):
+		return FreshnessExpired
+	case !p.Credentials.Expires.After(now.Add(window)):
+		return FreshnessExpiringSoon
+	}
+	return FreshnessValid
+}
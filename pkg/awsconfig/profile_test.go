@@ -0,0 +1,81 @@
+package awsconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials")
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	t.Run("Reads a profile written by gredentures", func(t *testing.T) {
+		writer := &SharedCredentialsWriter{Path: path}
+		assert.NoError(t, writer.WriteCredentials(CredentialSet{Session: Profile{
+			Name:        "default-mfa",
+			Region:      "eu-west-1",
+			Credentials: aws.Credentials{AccessKeyID: "ASIAEXAMPLE", SecretAccessKey: "wJalrXUtnFEMIK7MDENG", SessionToken: "token", CanExpire: true, Expires: expires},
+		}}))
+
+		profile, err := ReadProfile(path, "default-mfa")
+		assert.NoError(t, err)
+		assert.Equal(t, "ASIAEXAMPLE", profile.Credentials.AccessKeyID)
+		assert.Equal(t, "token", profile.Credentials.SessionToken)
+		assert.Equal(t, "eu-west-1", profile.Region)
+		assert.True(t, profile.Credentials.CanExpire)
+		assert.Equal(t, expires, profile.Credentials.Expires)
+		assert.Equal(t, "********DENG", profile.RedactedSecret())
+	})
+
+	t.Run("Profiles without an expiry", func(t *testing.T) {
+		assert.NoError(t, os.WriteFile(path, []byte("[default]\naws_access_key_id = AKIAEXAMPLE\naws_secret_access_key = secret\n"), 0o600))
+		profile, err := ReadProfile(path, "default")
+		assert.NoError(t, err)
+		assert.False(t, profile.Credentials.CanExpire)
+		assert.Equal(t, "******", profile.RedactedSecret())
+
+		_, err = ReadProfile(path, "missing")
+		assert.ErrorContains(t, err, "profile missing not found")
+	})
+
+	t.Run("Reads the expiry keys of other tools", func(t *testing.T) {
+		for content, want := range map[string]time.Time{
+			"aws_session_expiration = 2030-01-02T03:04:05Z\n":                                                  expires,
+			"aws_credential_expiration = 2030-01-02T04:04:05+01:00\n":                                          expires,
+			"expiration = 2030-01-02 03:04:05\n":                                                               time.Date(2030, 1, 2, 3, 4, 5, 0, time.Local).UTC(),
+			"x_security_token_expires = 2030-01-02T03:04:05Z\naws_session_expiration = 2031-01-01T00:00:00Z\n": expires,
+		} {
+			assert.NoError(t, os.WriteFile(path, []byte("[saml]\naws_access_key_id = ASIAEXAMPLE\n"+content), 0o600))
+			profile, err := ReadProfile(path, "saml")
+			assert.NoError(t, err, content)
+			assert.True(t, profile.Credentials.CanExpire, content)
+			assert.Equal(t, want, profile.Credentials.Expires, content)
+		}
+	})
+
+	t.Run("Invalid expiry", func(t *testing.T) {
+		assert.NoError(t, os.WriteFile(path, []byte("[default-mfa]\nx_security_token_expires = soon\n"), 0o600))
+		_, err := ReadProfile(path, "default-mfa")
+		assert.ErrorContains(t, err, `invalid x_security_token_expires "soon"`)
+	})
+}
+
+func TestProfileFreshness(t *testing.T) {
+	now := time.Date(2030, 1, 2, 3, 0, 0, 0, time.UTC)
+	expiring := func(at time.Time) Profile {
+		return Profile{Credentials: aws.Credentials{CanExpire: true, Expires: at}}
+	}
+
+	assert.Equal(t, FreshnessValid, Profile{}.Freshness(now, 15*time.Minute), "long-lived keys never expire")
+	assert.Equal(t, FreshnessValid, expiring(now.Add(time.Hour)).Freshness(now, 15*time.Minute))
+	assert.Equal(t, FreshnessExpiringSoon, expiring(now.Add(15*time.Minute)).Freshness(now, 15*time.Minute))
+	assert.Equal(t, FreshnessExpiringSoon, expiring(now.Add(time.Second)).Freshness(now, 15*time.Minute))
+	assert.Equal(t, FreshnessValid, expiring(now.Add(time.Second)).Freshness(now, 0), "no window")
+	assert.Equal(t, FreshnessExpired, expiring(now).Freshness(now, 15*time.Minute))
+	assert.Equal(t, FreshnessExpired, expiring(now.Add(-time.Hour)).Freshness(now, 15*time.Minute))
+}
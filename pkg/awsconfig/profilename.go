@@ -0,0 +1,75 @@
+package awsconfig
+
+import (
+	"context"
+	"fmt"
+	"gredentures/pkg/appconfig"
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+)
+
+// aliasAPI is the subset of the IAM client used to look up account aliases, so it can be mocked in tests.
+type aliasAPI interface {
+	ListAccountAliases(ctx context.Context, params *iam.ListAccountAliasesInput, optFns ...func(*iam.Options)) (*iam.ListAccountAliasesOutput, error)
+}
+
+// profileName evaluates the profile name template pattern with data. AccountAlias is looked up
+// with creds, the credentials the profile is written with, and only when the template uses it.
+func (conf *AwsConfig) profileName(ctx context.Context, pattern string, data appconfig.ProfileData, creds *types.Credentials) (string, error) {
+	return appconfig.RenderProfile(pattern, data.WithAccountAlias(func() (string, error) {
+		client, err := conf.aliasClient(creds)
+		if err != nil {
+			return "", err
+		}
+		return accountAlias(ctx, client)
+	}))
+}
+
+// aliasClient returns an IAM client authenticating with creds, or the client set for tests.
+func (conf *AwsConfig) aliasClient(creds *types.Credentials) (aliasAPI, error) {
+	if conf.aliases != nil {
+		return conf.aliases, nil
+	}
+	config, err := conf.sourceAccount()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get default account: %w", err)
+	}
+	config.Credentials = staticCredentials(aws.Credentials{
+		AccessKeyID:     aws.ToString(creds.AccessKeyId),
+		SecretAccessKey: aws.ToString(creds.SecretAccessKey),
+		SessionToken:    aws.ToString(creds.SessionToken),
+	})
+	return conf.clients(config).iam(), nil
+}
+
+// accountAlias returns the alias of the account client's credentials belong to, or "" when
+// the account has none.
+func accountAlias(ctx context.Context, client aliasAPI) (string, error) {
+	out, err := client.ListAccountAliases(ctx, &iam.ListAccountAliasesInput{})
+	if err != nil {
+		return "", fmt.Errorf("failed to look up the account alias: %w", err)
+	}
+	if len(out.AccountAliases) == 0 {
+		slog.Debug("Account has no alias")
+		return "", nil
+	}
+	return out.AccountAliases[0], nil
+}
+
+// ApplyProfileNames replaces the session and org profiles of app with the names their
+// templates were evaluated to at login, so later output such as the login message and the
+// WSL sync use the profiles actually written.
+func (conf *AwsConfig) ApplyProfileNames(app *appconfig.AppConfig) {
+	if conf.sessionProfile != "" {
+		app.Profile = conf.sessionProfile
+	}
+	for name, profile := range conf.orgProfiles {
+		if org, ok := app.Orgs[name]; ok {
+			org.Profile = profile
+			app.Orgs[name] = org
+		}
+	}
+}
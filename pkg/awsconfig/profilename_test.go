@@ -0,0 +1,64 @@
+package awsconfig
+
+import (
+	"context"
+	"gredentures/pkg/appconfig"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// aliasesByKey answers ListAccountAliases with an alias per access key, standing in for the
+// account each set of credentials belongs to.
+type aliasesByKey struct {
+	aliases map[string]string
+	calls   int
+}
+
+func (a *aliasesByKey) ListAccountAliases(ctx context.Context, params *iam.ListAccountAliasesInput, optFns ...func(*iam.Options)) (*iam.ListAccountAliasesOutput, error) {
+	a.calls++
+	out := &iam.ListAccountAliasesOutput{}
+	for _, alias := range a.aliases {
+		out.AccountAliases = append(out.AccountAliases, alias)
+	}
+	return out, nil
+}
+
+func TestAssumeRolesProfileTemplates(t *testing.T) {
+	mockSTS := &MockSTSClient{
+		AssumeRoleFunc: func(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+			return &sts.AssumeRoleOutput{Credentials: &types.Credentials{AccessKeyId: aws.String("key-" + *params.RoleSessionName)}}, nil
+		},
+	}
+
+	t.Run("Static fields and the account alias", func(t *testing.T) {
+		aliases := &aliasesByKey{aliases: map[string]string{"prod": "acme-prod"}}
+		conf := &AwsConfig{aliases: aliases}
+		err := conf.assumeRoles(context.TODO(), mockSTS, nil, map[string]appconfig.OrgConfig{
+			"prod":    {RoleArn: "arn:aws:iam::111111111111:role/Admin", Profile: "{{.AccountAlias}}"},
+			"staging": {RoleArn: "arn:aws:iam::222222222222:role/ops/Deploy", Profile: "{{.Org}}-{{.Role}}-{{.AccountID}}"},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "key-gredentures-prod", *conf.roleCreds["acme-prod"].AccessKeyId)
+		assert.Contains(t, conf.roleCreds, "staging-Deploy-222222222222")
+		assert.Equal(t, 1, aliases.calls, "the alias is only looked up for templates using it")
+
+		app := &appconfig.AppConfig{Orgs: map[string]appconfig.OrgConfig{"prod": {Profile: "{{.AccountAlias}}"}}}
+		conf.ApplyProfileNames(app)
+		assert.Equal(t, "acme-prod", app.Orgs["prod"].Profile)
+	})
+
+	t.Run("Colliding profiles", func(t *testing.T) {
+		conf := &AwsConfig{aliases: &aliasesByKey{aliases: map[string]string{"all": "acme"}}}
+		err := conf.assumeRoles(context.TODO(), mockSTS, nil, map[string]appconfig.OrgConfig{
+			"prod":    {RoleArn: "arn:aws:iam::111111111111:role/Admin", Profile: "{{.AccountAlias}}"},
+			"staging": {RoleArn: "arn:aws:iam::222222222222:role/Admin", Profile: "{{.AccountAlias}}"},
+		})
+		assert.ErrorContains(t, err, `orgs "prod" and "staging" are written to the same profile "acme"`)
+		assert.Nil(t, conf.roleCreds)
+	})
+}
@@ -0,0 +1,95 @@
+package awsconfig
+
+import (
+	"bytes"
+	"fmt"
+	"gredentures/pkg/interrupt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// DefaultRemotePath is the credentials file written on the remote host when none is configured.
+const DefaultRemotePath = "~/.aws/credentials"
+
+// sshCommand runs ssh with args, feeding it stdin and returning what it prints. The terminal
+// stays attached to stderr, so host key questions and password prompts still reach the user.
+// It is replaced in tests.
+var sshCommand = func(stdin []byte, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(interrupt.Context(), "ssh", args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ssh %s failed: %w", args[0], err)
+	}
+	return out, nil
+}
+
+// PushProfile copies the session profile from the local credentials file into the credentials
+// file at remotePath on destination, a [user@]host or ssh config alias, replacing the profile
+// there and keeping all other remote profiles. Only profiles holding a session token are
+// pushed, so long-lived keys never leave the machine. remotePath may start with ~/ for the
+// remote user's home directory.
+func PushProfile(destination, remotePath, profile string) error {
+	if destination == "" || strings.HasPrefix(destination, "-") {
+		return fmt.Errorf("invalid destination %q, expected [user@]host", destination)
+	}
+
+	local, err := ini.Load(CredentialsPath())
+	if err != nil {
+		return fmt.Errorf("failed to load %s: %w", CredentialsPath(), err)
+	}
+	section, err := local.GetSection(profile)
+	if err != nil {
+		return fmt.Errorf("profile %s not found in %s, log in first", profile, CredentialsPath())
+	}
+	if !section.HasKey("aws_session_token") {
+		return fmt.Errorf("profile %s holds no session token, only session credentials are pushed", profile)
+	}
+
+	remote := remoteShellPath(remotePath)
+	slog.Debug("Reading remote credentials file", "destination", destination, "path", remotePath)
+	existing, err := sshCommand(nil, destination, fmt.Sprintf("if [ -f %s ]; then cat %s; fi", remote, remote))
+	if err != nil {
+		return fmt.Errorf("failed to read %s on %s: %w", remotePath, destination, err)
+	}
+	dest, err := ini.Load(existing)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s on %s: %w", remotePath, destination, err)
+	}
+	dest.DeleteSection(profile)
+	if err := copySection(dest, section); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if _, err := dest.WriteTo(&buf); err != nil {
+		return fmt.Errorf("failed to render credentials file: %w", err)
+	}
+
+	// Written to a private temporary file first, so a dropped connection never truncates it
+	tmp := remoteShellPath(remotePath + ".gredentures-tmp")
+	script := fmt.Sprintf("umask 077 && mkdir -p %s && cat > %s && mv %s %s", remoteShellPath(path.Dir(remotePath)), tmp, tmp, remote)
+	slog.Debug("Writing remote credentials file", "destination", destination, "path", remotePath, "profile", profile)
+	if _, err := sshCommand(buf.Bytes(), destination, script); err != nil {
+		return fmt.Errorf("failed to write %s on %s: %w", remotePath, destination, err)
+	}
+	return nil
+}
+
+// remoteShellPath quotes path for the remote shell, leaving a leading ~ to expand to the
+// remote user's home directory.
+func remoteShellPath(path string) string {
+	switch {
+	case path == "~":
+		return `"$HOME"`
+	case strings.HasPrefix(path, "~/"):
+		return `"$HOME"/` + shellQuote(strings.TrimPrefix(path, "~/"))
+	}
+	return shellQuote(path)
+}
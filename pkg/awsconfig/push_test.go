@@ -0,0 +1,92 @@
+package awsconfig
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ini.v1"
+)
+
+// fakeSSH stubs sshCommand with a remote credentials file held in memory, recording the
+// remote commands that were run.
+func fakeSSH(t *testing.T, remote string) (file *string, commands *[]string) {
+	file, commands = &remote, &[]string{}
+	orig := sshCommand
+	sshCommand = func(stdin []byte, args ...string) ([]byte, error) {
+		assert.Equal(t, "admin@bastion", args[0])
+		*commands = append(*commands, args[1])
+		if stdin == nil {
+			return []byte(*file), nil
+		}
+		*file = string(stdin)
+		return nil, nil
+	}
+	t.Cleanup(func() { sshCommand = orig })
+	return file, commands
+}
+
+// writeLocalCredentials creates ~/.aws/credentials in a temporary HOME.
+func writeLocalCredentials(t *testing.T, content string) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	assert.NoError(t, os.MkdirAll(filepath.Join(home, ".aws"), 0o700))
+	assert.NoError(t, os.WriteFile(filepath.Join(home, ".aws", "credentials"), []byte(content), 0o600))
+}
+
+func TestPushProfile(t *testing.T) {
+	local := "[default]\naws_access_key_id = AKIALONGLIVED\naws_secret_access_key = longlived\n\n" +
+		"[default-mfa]\naws_access_key_id = ASIANEW\naws_secret_access_key = new\naws_session_token = newtoken\n"
+
+	t.Run("Replaces only the session profile", func(t *testing.T) {
+		writeLocalCredentials(t, local)
+		file, commands := fakeSSH(t, "[default]\naws_access_key_id = AKIAREMOTE\n\n[default-mfa]\naws_access_key_id = ASIAOLD\n")
+
+		assert.NoError(t, PushProfile("admin@bastion", DefaultRemotePath, "default-mfa"))
+
+		remote, err := ini.Load([]byte(*file))
+		assert.NoError(t, err)
+		assert.Equal(t, "AKIAREMOTE", remote.Section("default").Key("aws_access_key_id").String(), "remote profiles are kept")
+		assert.Equal(t, "ASIANEW", remote.Section("default-mfa").Key("aws_access_key_id").String())
+		assert.Equal(t, "newtoken", remote.Section("default-mfa").Key("aws_session_token").String())
+		assert.NotContains(t, *file, "longlived")
+
+		assert.Equal(t, []string{
+			`if [ -f "$HOME"/'.aws/credentials' ]; then cat "$HOME"/'.aws/credentials'; fi`,
+			`umask 077 && mkdir -p "$HOME"/'.aws' && cat > "$HOME"/'.aws/credentials.gredentures-tmp' && mv "$HOME"/'.aws/credentials.gredentures-tmp' "$HOME"/'.aws/credentials'`,
+		}, *commands)
+	})
+
+	t.Run("Creates a missing remote file", func(t *testing.T) {
+		writeLocalCredentials(t, local)
+		file, commands := fakeSSH(t, "")
+
+		assert.NoError(t, PushProfile("admin@bastion", "/srv/app's/credentials", "default-mfa"))
+		assert.Contains(t, *file, "[default-mfa]")
+		assert.Contains(t, (*commands)[1], `mkdir -p '/srv/app'\''s'`)
+	})
+
+	t.Run("Never pushes long-lived keys", func(t *testing.T) {
+		writeLocalCredentials(t, local)
+		_, commands := fakeSSH(t, "")
+		assert.ErrorContains(t, PushProfile("admin@bastion", DefaultRemotePath, "default"), "holds no session token")
+		assert.ErrorContains(t, PushProfile("admin@bastion", DefaultRemotePath, "missing"), "profile missing not found")
+		assert.Empty(t, *commands)
+	})
+
+	t.Run("Rejects option-like destinations", func(t *testing.T) {
+		assert.ErrorContains(t, PushProfile("-oProxyCommand=evil", DefaultRemotePath, "default-mfa"), "invalid destination")
+	})
+
+	t.Run("Reports ssh failures", func(t *testing.T) {
+		writeLocalCredentials(t, local)
+		orig := sshCommand
+		sshCommand = func(stdin []byte, args ...string) ([]byte, error) {
+			return nil, fmt.Errorf("ssh admin@bastion failed: exit status 255")
+		}
+		t.Cleanup(func() { sshCommand = orig })
+		assert.ErrorContains(t, PushProfile("admin@bastion", DefaultRemotePath, "default-mfa"), "failed to read ~/.aws/credentials on admin@bastion")
+	})
+}
@@ -0,0 +1,106 @@
+package awsconfig
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+
+	"gredentures/pkg/appconfig"
+	"gredentures/pkg/interrupt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+)
+
+// GetRecipeCreds runs the role chain of the selected login recipe, starting from the MFA
+// session credentials. The final credentials replace the session credentials, so they are
+// what gets written to the recipe's profile, exported, or passed to exec.
+func (conf *AwsConfig) GetRecipeCreds(appconfig appconfig.AppConfig) error {
+	recipe, ok := appconfig.SelectedRecipe()
+	if !ok || len(recipe.Roles) == 0 {
+		return nil
+	}
+	if conf.sessionCreds == nil || conf.sessionCreds.Credentials == nil {
+		return fmt.Errorf("session credentials are required to run a recipe")
+	}
+
+	config, err := conf.sessionAccount()
+	if err != nil {
+		return err
+	}
+
+	conf.policyArns = appconfig.PolicyArns
+	conf.policy = appconfig.Policy
+	conf.externalID = appconfig.ExternalID
+	newClients := func(creds *types.Credentials) (stsAPI, iamAPI) {
+		cfg := config.Copy()
+		cfg.Credentials = staticCredentials(aws.Credentials{
+			AccessKeyID:     aws.ToString(creds.AccessKeyId),
+			SecretAccessKey: aws.ToString(creds.SecretAccessKey),
+			SessionToken:    aws.ToString(creds.SessionToken),
+		})
+		return conf.stsClient(cfg), conf.clients(cfg).iam()
+	}
+	if err := conf.chainRoles(interrupt.Context(), newClients, appconfig.Recipe, recipe); err != nil {
+		return err
+	}
+
+	// The profile now holds the credentials of the last role, which a template describes
+	profile, err := conf.profileName(interrupt.Context(), appconfig.Profile, appconfig.SessionProfileData(), conf.sessionCreds.Credentials)
+	if err != nil {
+		return err
+	}
+	conf.sessionProfile = profile
+	return nil
+}
+
+// chainRoles assumes each role of a recipe in turn, authenticating every call with the
+// credentials returned by the previous one, with clients from newClients. The recipe's duration
+// session policies and external ID apply to the last role only, intermediate hops use the STS
+// default.
+func (conf *AwsConfig) chainRoles(ctx context.Context, newClients func(*types.Credentials) (stsAPI, iamAPI), name string, recipe appconfig.RecipeConfig) error {
+	creds := conf.sessionCreds.Credentials
+	var assumedARN string
+	for i, roleArn := range recipe.Roles {
+		input := &sts.AssumeRoleInput{
+			RoleArn:         aws.String(roleArn),
+			RoleSessionName: aws.String("gredentures-" + name),
+		}
+		if i == len(recipe.Roles)-1 {
+			if recipe.Timeout > 0 {
+				input.DurationSeconds = aws.Int32(recipe.Timeout)
+			}
+			for _, arn := range conf.policyArns {
+				input.PolicyArns = append(input.PolicyArns, types.PolicyDescriptorType{Arn: aws.String(arn)})
+			}
+			if conf.policy != "" {
+				input.Policy = aws.String(conf.policy)
+			}
+			if externalID := cmp.Or(recipe.ExternalID, conf.externalID); externalID != "" {
+				input.ExternalId = aws.String(externalID)
+			}
+		}
+
+		slog.Debug("Assuming recipe role", "recipe", name, "step", i+1, "role_arn", roleArn)
+		client, roles := newClients(creds)
+		caller := conf.callerAccount
+		if parsed, err := arn.Parse(assumedARN); err == nil {
+			caller = parsed.AccountID
+		}
+		out, err := assumeRole(ctx, client, roles, caller, input)
+		if err != nil {
+			return fmt.Errorf("failed to assume role %s in recipe %q: %w", roleArn, name, checkExternalID(classifySTSError(err), input))
+		}
+		creds = out.Credentials
+		if out.AssumedRoleUser != nil {
+			assumedARN = aws.ToString(out.AssumedRoleUser.Arn)
+		}
+	}
+
+	slog.Debug("Recipe complete", "recipe", name, "assumed_role", assumedARN)
+	conf.sessionCreds = &sts.GetSessionTokenOutput{Credentials: creds}
+	return nil
+}
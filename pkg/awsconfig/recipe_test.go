@@ -0,0 +1,87 @@
+package awsconfig
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"gredentures/pkg/appconfig"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChainRoles(t *testing.T) {
+	recipe := appconfig.RecipeConfig{
+		Roles:      []string{"arn:aws:iam::111111111111:role/Jump", "arn:aws:iam::222222222222:role/Admin"},
+		Timeout:    900,
+		ExternalID: "vendor-42",
+	}
+	session := &sts.GetSessionTokenOutput{Credentials: &types.Credentials{AccessKeyId: aws.String("session")}}
+
+	t.Run("Assumes each role with the previous credentials", func(t *testing.T) {
+		var calls []string
+		newClients := func(creds *types.Credentials) (stsAPI, iamAPI) {
+			return &MockSTSClient{
+				AssumeRoleFunc: func(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+					calls = append(calls, aws.ToString(creds.AccessKeyId)+"->"+aws.ToString(params.RoleArn))
+					assert.Equal(t, "gredentures-prod-admin", aws.ToString(params.RoleSessionName))
+					if aws.ToString(params.RoleArn) == recipe.Roles[1] {
+						assert.Equal(t, int32(900), aws.ToInt32(params.DurationSeconds))
+						assert.Len(t, params.PolicyArns, 1)
+						assert.Equal(t, "vendor-42", aws.ToString(params.ExternalId))
+					} else {
+						assert.Nil(t, params.DurationSeconds)
+						assert.Empty(t, params.PolicyArns)
+						assert.Nil(t, params.ExternalId)
+					}
+					return &sts.AssumeRoleOutput{Credentials: &types.Credentials{AccessKeyId: params.RoleArn}}, nil
+				},
+			}, nil
+		}
+
+		conf := &AwsConfig{sessionCreds: session, policyArns: []string{"arn:aws:iam::aws:policy/ReadOnlyAccess"}}
+		assert.NoError(t, conf.chainRoles(context.TODO(), newClients, "prod-admin", recipe))
+		assert.Equal(t, []string{
+			"session->arn:aws:iam::111111111111:role/Jump",
+			"arn:aws:iam::111111111111:role/Jump->arn:aws:iam::222222222222:role/Admin",
+		}, calls)
+		assert.Equal(t, recipe.Roles[1], aws.ToString(conf.sessionCreds.Credentials.AccessKeyId))
+	})
+
+	t.Run("Keeps the session when a role fails", func(t *testing.T) {
+		newClients := func(creds *types.Credentials) (stsAPI, iamAPI) {
+			return &MockSTSClient{
+				AssumeRoleFunc: func(ctx context.Context, params *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+					return nil, fmt.Errorf("access denied")
+				},
+			}, nil
+		}
+
+		conf := &AwsConfig{sessionCreds: session}
+		err := conf.chainRoles(context.TODO(), newClients, "prod-admin", recipe)
+		assert.ErrorContains(t, err, `role arn:aws:iam::111111111111:role/Jump in recipe "prod-admin"`)
+		assert.Same(t, session, conf.sessionCreds)
+	})
+}
+
+func TestRecipeRegion(t *testing.T) {
+	conf := &AwsConfig{}
+	conf.SetSourceProfile(appconfig.AppConfig{
+		Profile: "prod-admin",
+		Recipe:  "prod-admin",
+		Recipes: map[string]appconfig.RecipeConfig{"prod-admin": {Region: "eu-west-1"}},
+	})
+	conf.sessionCreds = &sts.GetSessionTokenOutput{Credentials: &types.Credentials{AccessKeyId: aws.String("key")}}
+
+	set, err := conf.credentialSet()
+	assert.NoError(t, err)
+	assert.Equal(t, "eu-west-1", set.Session.Region)
+	assert.Contains(t, profileEnv(set.Session), envVar{"AWS_REGION", "eu-west-1"})
+
+	env, err := conf.SessionEnv(nil)
+	assert.NoError(t, err)
+	assert.Contains(t, env, "AWS_DEFAULT_REGION=eu-west-1")
+}
@@ -0,0 +1,52 @@
+package awsconfig
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+
+	"gredentures/pkg/appconfig"
+
+	"gopkg.in/ini.v1"
+)
+
+// defaultRegion is the region of the STS calls when neither the source profile, the
+// environment nor a recipe names one. It is never written to a profile.
+const defaultRegion = "us-west-2"
+
+// SourceRegion returns the region the sessions of the long-lived keys in profile inherit: the
+// region of the profile in the AWS config file, or in the credentials file at credentialsPath,
+// then AWS_REGION and AWS_DEFAULT_REGION. Regions outside partition are skipped, as the keys
+// of one partition are unknown to the others. It is empty when none of them names one.
+func SourceRegion(profile, credentialsPath, partition string) string {
+	var regions []string
+	if settings, err := ReadConfigProfile(AWSConfigPath(), profile); err != nil {
+		slog.Debug("Not reading the region of the source profile", "err", err)
+	} else {
+		regions = append(regions, settings.Region)
+	}
+	if file, err := ini.LooseLoad(credentialsPath); err == nil {
+		if section, err := file.GetSection(profile); err == nil {
+			regions = append(regions, section.Key("region").String())
+		}
+	}
+	regions = append(regions, os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"))
+
+	for _, region := range regions {
+		if region != "" && regionPartition(region) == partition {
+			return region
+		}
+	}
+	return ""
+}
+
+// regionPartition returns the partition region is in.
+func regionPartition(region string) string {
+	switch {
+	case strings.HasPrefix(region, "us-gov-"):
+		return appconfig.PartitionGovCloud
+	case strings.HasPrefix(region, "cn-"):
+		return appconfig.PartitionChina
+	}
+	return appconfig.PartitionAWS
+}
@@ -0,0 +1,80 @@
+package awsconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gredentures/pkg/appconfig"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSourceRegion(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config")
+	credentialsPath := filepath.Join(dir, "credentials")
+	require.NoError(t, os.WriteFile(configPath, []byte("[default]\nregion = eu-west-1\n\n[profile work]\nregion = ap-southeast-2\n"), 0o600))
+	require.NoError(t, os.WriteFile(credentialsPath, []byte("[keys]\naws_access_key_id = AKIA\nregion = ca-central-1\n\n[bare]\naws_access_key_id = AKIA\n"), 0o600))
+	t.Setenv("AWS_CONFIG_FILE", configPath)
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "")
+
+	assert.Equal(t, "eu-west-1", SourceRegion("default", credentialsPath, appconfig.PartitionAWS))
+	assert.Equal(t, "ap-southeast-2", SourceRegion("work", credentialsPath, appconfig.PartitionAWS))
+	assert.Equal(t, "ca-central-1", SourceRegion("keys", credentialsPath, appconfig.PartitionAWS), "a region next to the keys counts too")
+	assert.Empty(t, SourceRegion("bare", credentialsPath, appconfig.PartitionAWS))
+
+	t.Setenv("AWS_DEFAULT_REGION", "sa-east-1")
+	assert.Equal(t, "sa-east-1", SourceRegion("bare", credentialsPath, appconfig.PartitionAWS))
+	t.Setenv("AWS_REGION", "us-east-2")
+	assert.Equal(t, "us-east-2", SourceRegion("bare", credentialsPath, appconfig.PartitionAWS), "AWS_REGION comes before AWS_DEFAULT_REGION")
+	assert.Equal(t, "ap-southeast-2", SourceRegion("work", credentialsPath, appconfig.PartitionAWS), "the profile comes before the environment")
+
+	t.Setenv("AWS_DEFAULT_REGION", "us-gov-east-1")
+	assert.Equal(t, "us-gov-east-1", SourceRegion("work", credentialsPath, appconfig.PartitionGovCloud), "regions of other partitions are skipped")
+	assert.Empty(t, SourceRegion("bare", credentialsPath, appconfig.PartitionChina))
+}
+
+func TestSetSourceProfileRegion(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config")
+	require.NoError(t, os.WriteFile(configPath, []byte("[profile work]\nregion = eu-central-1\n"), 0o600))
+	t.Setenv("HOME", dir)
+	t.Setenv("AWS_CONFIG_FILE", configPath)
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "")
+
+	tests := []struct {
+		name string
+		app  appconfig.AppConfig
+		want string
+	}{
+		{"Inherits the region of the source profile", appconfig.AppConfig{SourceProfile: "work"}, "eu-central-1"},
+		{"Leaves the region unset without one", appconfig.AppConfig{}, ""},
+		{"Prefers the region of the recipe", appconfig.AppConfig{SourceProfile: "work", Recipe: "admin",
+			Recipes: map[string]appconfig.RecipeConfig{"admin": {Region: "eu-west-3"}}}, "eu-west-3"},
+		{"Uses the region of the partition without one", appconfig.AppConfig{Org: "gov",
+			Orgs: map[string]appconfig.OrgConfig{"gov": {Partition: appconfig.PartitionGovCloud}}}, "us-gov-west-1"},
+		{"Skips a source region of another partition", appconfig.AppConfig{SourceProfile: "work", Org: "gov",
+			Orgs: map[string]appconfig.OrgConfig{"gov": {Partition: appconfig.PartitionGovCloud}}}, "us-gov-west-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &AwsConfig{}
+			conf.SetSourceProfile(tt.app)
+			assert.Equal(t, tt.want, conf.region)
+		})
+	}
+
+	t.Run("Writes it to the session and role profiles", func(t *testing.T) {
+		conf := writerTestConfig()
+		conf.SetSourceProfile(appconfig.AppConfig{SourceProfile: "work"})
+		set, err := conf.credentialSet()
+		require.NoError(t, err)
+		assert.Equal(t, "eu-central-1", set.Session.Region)
+		require.NotEmpty(t, set.Roles)
+		assert.Equal(t, "eu-central-1", set.Roles[0].Region)
+	})
+}
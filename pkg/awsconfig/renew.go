@@ -0,0 +1,142 @@
+package awsconfig
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/ini.v1"
+)
+
+// renewProfile is the profile of the config file written by NewRenewFiles.
+const renewProfile = "gredentures-exec"
+
+// renewEnvKeys lists the variables RenewFiles.Env replaces, so neither inherited credentials
+// nor other config files can take precedence over the renewed session.
+var renewEnvKeys = slices.Concat(sessionEnvKeys, []string{"AWS_CONFIG_FILE", "AWS_SHARED_CREDENTIALS_FILE"})
+
+// RenewFiles hands a session that is renewed while a command runs to that command. Environment
+// variables cannot change once a process has started, so the command is given an AWS config
+// file whose profile reads the credentials through credential_process from a file instead.
+// The AWS SDKs run credential_process again once the credentials they hold expire, and so
+// find the renewed session. Like SharedCredentialsWriter it implements CredentialWriter.
+type RenewFiles struct {
+	Dir string // Private temporary directory holding both files.
+}
+
+// NewRenewFiles creates the directory and the config file, whose profile uses the region of
+// the login recipe when it names one. The credentials are written with WriteCredentials.
+func (conf *AwsConfig) NewRenewFiles() (*RenewFiles, error) {
+	dir, err := os.MkdirTemp("", "gredentures-renew-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create renewal directory: %w", err)
+	}
+	files := &RenewFiles{Dir: dir}
+
+	inidata := ini.Empty()
+	section := inidata.Section("profile " + renewProfile)
+	section.Key("credential_process").SetValue(readFileCommand(files.credentialsPath()))
+	if conf.region != "" {
+		section.Key("region").SetValue(conf.region)
+	}
+	if err := saveAtomic(inidata, files.configPath()); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to write renewal config file: %w", err)
+	}
+	return files, nil
+}
+
+// readFileCommand returns the credential_process command printing the file at path.
+func readFileCommand(path string) string {
+	if runtime.GOOS == "windows" {
+		return fmt.Sprintf(`cmd.exe /C type "%s"`, path)
+	}
+	return "cat " + shellQuote(path)
+}
+
+// configPath returns the path of the AWS config file.
+func (f *RenewFiles) configPath() string {
+	return filepath.Join(f.Dir, "config")
+}
+
+// credentialsPath returns the path of the credential_process JSON.
+func (f *RenewFiles) credentialsPath() string {
+	return filepath.Join(f.Dir, "credentials.json")
+}
+
+// WriteCredentials implements CredentialWriter, replacing the session credentials read by
+// credential_process. The file is replaced in one step, so it is never read half-written.
+func (f *RenewFiles) WriteCredentials(set CredentialSet) error {
+	tmp, err := os.CreateTemp(f.Dir, ".credentials-*")
+	if err != nil {
+		return fmt.Errorf("failed to write renewed credentials: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	err = writeJSON(tmp, newProcessCredentials(set.Session))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write renewed credentials: %w", err)
+	}
+	slog.Debug("Writing renewed session credentials", "path", f.credentialsPath())
+	return os.Rename(tmp.Name(), f.credentialsPath())
+}
+
+// Env returns a copy of environ selecting the profile of the config file. Inherited
+// credential, profile and config file variables are removed; the shared credentials file is
+// pointed at the renewal directory, so no other profile of the same name can shadow it.
+func (f *RenewFiles) Env(environ []string) []string {
+	env := make([]string, 0, len(environ)+3)
+	for _, kv := range environ {
+		name, _, _ := strings.Cut(kv, "=")
+		if slices.Contains(renewEnvKeys, name) {
+			slog.Debug("Removing inherited environment variable", "name", name)
+			continue
+		}
+		env = append(env, kv)
+	}
+	return append(env,
+		"AWS_CONFIG_FILE="+f.configPath(),
+		"AWS_SHARED_CREDENTIALS_FILE="+filepath.Join(f.Dir, "credentials"),
+		"AWS_PROFILE="+renewProfile,
+	)
+}
+
+// Remove deletes the directory with both files.
+func (f *RenewFiles) Remove() error {
+	return os.RemoveAll(f.Dir)
+}
+
+// SessionExpires returns when the session credentials expire, false when none with an
+// expiry are held.
+func (conf *AwsConfig) SessionExpires() (time.Time, bool) {
+	if conf.sessionCreds == nil || conf.sessionCreds.Credentials == nil || conf.sessionCreds.Credentials.Expiration == nil {
+		return time.Time{}, false
+	}
+	return *conf.sessionCreds.Credentials.Expiration, true
+}
+
+// RenewDelay returns how long to wait at now before renewing a session expiring at expires,
+// zero once the session expires within margin, its appconfig.AppConfig.RefreshMargin. The
+// margin leaves the command's SDK time to pick up the new session before the old one stops
+// working.
+func RenewDelay(expires, now time.Time, margin time.Duration) time.Duration {
+	return max(expires.Sub(now)-margin, 0)
+}
+
+// RenewMargin caps margin to half of lifetime, how long the credentials are valid for once
+// issued. A margin as long as the session itself, such as the default 15 minutes for a
+// Timeout of 15 minutes, would otherwise renew it right after every renewal.
+func RenewMargin(margin, lifetime time.Duration) time.Duration {
+	if lifetime <= 0 {
+		return margin
+	}
+	return min(margin, lifetime/2)
+}
@@ -0,0 +1,93 @@
+package awsconfig
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenewFiles(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+
+	conf := exportTestConfig()
+	conf.region = "eu-west-1"
+	files, err := conf.NewRenewFiles()
+	assert.NoError(t, err)
+	defer files.Remove()
+	assert.NoError(t, conf.WriteCredentials(files))
+
+	info, err := os.Stat(files.Dir)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o700), info.Mode().Perm())
+
+	env := files.Env([]string{"PATH=/bin", "AWS_ACCESS_KEY_ID=stale", "AWS_PROFILE=other", "AWS_CONFIG_FILE=/home/me/.aws/config"})
+	assert.Equal(t, []string{
+		"PATH=/bin",
+		"AWS_CONFIG_FILE=" + filepath.Join(files.Dir, "config"),
+		"AWS_SHARED_CREDENTIALS_FILE=" + filepath.Join(files.Dir, "credentials"),
+		"AWS_PROFILE=gredentures-exec",
+	}, env)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("credential_process reads the file with cat")
+	}
+
+	// Load the profile the way an SDK in the command does, before and after a renewal
+	for _, kv := range env[1:] {
+		name, value, _ := strings.Cut(kv, "=")
+		t.Setenv(name, value)
+	}
+	retrieve := func() aws.Credentials {
+		cfg, err := config.LoadDefaultConfig(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, "eu-west-1", cfg.Region)
+		creds, err := cfg.Credentials.Retrieve(context.Background())
+		assert.NoError(t, err)
+		return creds
+	}
+
+	creds := retrieve()
+	assert.Equal(t, "mockAccessKey", creds.AccessKeyID)
+	assert.Equal(t, "mockSessionToken", creds.SessionToken)
+	assert.Equal(t, time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC), creds.Expires.UTC())
+
+	conf.sessionCreds.Credentials.AccessKeyId = aws.String("renewedAccessKey")
+	assert.NoError(t, conf.WriteCredentials(files))
+	assert.Equal(t, "renewedAccessKey", retrieve().AccessKeyID)
+}
+
+func TestSessionExpires(t *testing.T) {
+	expires, ok := exportTestConfig().SessionExpires()
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC), expires)
+
+	_, ok = (&AwsConfig{}).SessionExpires()
+	assert.False(t, ok)
+}
+
+func TestRenewDelay(t *testing.T) {
+	now := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, 45*time.Minute, RenewDelay(now.Add(time.Hour), now, 15*time.Minute))
+	assert.Equal(t, 55*time.Minute, RenewDelay(now.Add(time.Hour), now, 5*time.Minute))
+	assert.Equal(t, time.Duration(0), RenewDelay(now.Add(5*time.Minute), now, 15*time.Minute))
+	assert.Equal(t, time.Duration(0), RenewDelay(now.Add(-time.Minute), now, 15*time.Minute))
+
+	t.Run("Waits half of a session shorter than twice the margin", func(t *testing.T) {
+		expires := now.Add(15 * time.Minute)
+		assert.Equal(t, 7*time.Minute+30*time.Second, RenewDelay(expires, now, RenewMargin(15*time.Minute, expires.Sub(now))))
+	})
+}
+
+func TestRenewMargin(t *testing.T) {
+	assert.Equal(t, 15*time.Minute, RenewMargin(15*time.Minute, time.Hour))
+	assert.Equal(t, 30*time.Minute, RenewMargin(time.Hour, time.Hour))
+	assert.Equal(t, 15*time.Minute, RenewMargin(15*time.Minute, 0), "an unknown lifetime leaves the margin alone")
+}
@@ -0,0 +1,366 @@
+package awsconfig
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gredentures/pkg/interrupt"
+	"log/slog"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+)
+
+// Where a discovered role was found, see DiscoveredRole.
+const (
+	RoleSourceIdentityPolicy = "identity policy" // An sts:AssumeRole grant in a policy of the user or its groups.
+	RoleSourceTrustPolicy    = "trust policy"    // A role of the user's account trusting the user or the account.
+)
+
+// roleDiscoveryAPI is the subset of the IAM client used to discover assumable roles, so it can be mocked in tests.
+type roleDiscoveryAPI interface {
+	ListRoles(ctx context.Context, params *iam.ListRolesInput, optFns ...func(*iam.Options)) (*iam.ListRolesOutput, error)
+	ListUserPolicies(ctx context.Context, params *iam.ListUserPoliciesInput, optFns ...func(*iam.Options)) (*iam.ListUserPoliciesOutput, error)
+	GetUserPolicy(ctx context.Context, params *iam.GetUserPolicyInput, optFns ...func(*iam.Options)) (*iam.GetUserPolicyOutput, error)
+	ListAttachedUserPolicies(ctx context.Context, params *iam.ListAttachedUserPoliciesInput, optFns ...func(*iam.Options)) (*iam.ListAttachedUserPoliciesOutput, error)
+	ListGroupsForUser(ctx context.Context, params *iam.ListGroupsForUserInput, optFns ...func(*iam.Options)) (*iam.ListGroupsForUserOutput, error)
+	ListGroupPolicies(ctx context.Context, params *iam.ListGroupPoliciesInput, optFns ...func(*iam.Options)) (*iam.ListGroupPoliciesOutput, error)
+	GetGroupPolicy(ctx context.Context, params *iam.GetGroupPolicyInput, optFns ...func(*iam.Options)) (*iam.GetGroupPolicyOutput, error)
+	ListAttachedGroupPolicies(ctx context.Context, params *iam.ListAttachedGroupPoliciesInput, optFns ...func(*iam.Options)) (*iam.ListAttachedGroupPoliciesOutput, error)
+	GetPolicy(ctx context.Context, params *iam.GetPolicyInput, optFns ...func(*iam.Options)) (*iam.GetPolicyOutput, error)
+	GetPolicyVersion(ctx context.Context, params *iam.GetPolicyVersionInput, optFns ...func(*iam.Options)) (*iam.GetPolicyVersionOutput, error)
+}
+
+// DiscoveredRole is a role the source user is allowed to assume.
+type DiscoveredRole struct {
+	Arn       string // Role ARN, usable as an org's RoleArn.
+	Name      string // Role name without its path.
+	AccountID string // Account the role belongs to.
+	Source    string // RoleSourceIdentityPolicy or RoleSourceTrustPolicy.
+}
+
+// DiscoverRoles lists the roles the source user can assume with the MFA session credentials,
+// sorted by account and name. See discoverRoles for where they are looked for.
+func (conf *AwsConfig) DiscoverRoles() ([]DiscoveredRole, error) {
+	if conf.sessionCreds == nil || conf.sessionCreds.Credentials == nil {
+		return nil, fmt.Errorf("session credentials are required to discover roles")
+	}
+
+	config, err := conf.sessionAccount()
+	if err != nil {
+		return nil, err
+	}
+	return discoverRoles(interrupt.Context(), conf.stsClient(config), conf.clients(config).iam())
+}
+
+// discoverRoles collects the roles named by sts:AssumeRole grants in the policies of the
+// calling IAM user and its groups, which covers roles in other accounts, and the roles of the
+// user's own account whose trust policy names the user or the account. IAM cannot list the
+// roles of other accounts, and grants with wildcards in the role ARN cannot be expanded, so
+// neither is reported. When only one of the two sources can be read, the roles of the other
+// are still returned.
+func discoverRoles(ctx context.Context, client stsAPI, roles roleDiscoveryAPI) ([]DiscoveredRole, error) {
+	callerArn, err := callerIdentity(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	caller, err := arn.Parse(callerArn)
+	if err != nil || caller.Service != "iam" || !strings.HasPrefix(caller.Resource, "user/") {
+		return nil, fmt.Errorf("role discovery requires the credentials of an IAM user, not %s", callerArn)
+	}
+	userName := caller.Resource[strings.LastIndex(caller.Resource, "/")+1:]
+
+	found := map[string]DiscoveredRole{}
+	grantErr := identityPolicyRoles(ctx, roles, userName, found)
+	if grantErr != nil {
+		slog.Warn("Could not read the identity policies", "user", userName, "error", grantErr)
+	}
+	trustErr := trustPolicyRoles(ctx, roles, caller, found)
+	if trustErr != nil {
+		slog.Warn("Could not list the roles of the account", "account", caller.AccountID, "error", trustErr)
+	}
+	if grantErr != nil && trustErr != nil {
+		return nil, errors.Join(grantErr, trustErr)
+	}
+
+	discovered := make([]DiscoveredRole, 0, len(found))
+	for _, role := range found {
+		discovered = append(discovered, role)
+	}
+	slices.SortFunc(discovered, func(a, b DiscoveredRole) int {
+		return cmp.Or(cmp.Compare(a.AccountID, b.AccountID), cmp.Compare(a.Name, b.Name))
+	})
+	return discovered, nil
+}
+
+// identityPolicyRoles adds the roles granted by the inline and managed policies of the user and
+// of its groups to found.
+func identityPolicyRoles(ctx context.Context, client roleDiscoveryAPI, userName string, found map[string]DiscoveredRole) error {
+	var documents []string
+	managed := map[string]bool{}
+
+	inline := iam.NewListUserPoliciesPaginator(client, &iam.ListUserPoliciesInput{UserName: aws.String(userName)})
+	for inline.HasMorePages() {
+		page, err := inline.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list the inline policies of %s: %w", userName, err)
+		}
+		for _, name := range page.PolicyNames {
+			out, err := client.GetUserPolicy(ctx, &iam.GetUserPolicyInput{UserName: aws.String(userName), PolicyName: aws.String(name)})
+			if err != nil {
+				return fmt.Errorf("failed to read policy %s of %s: %w", name, userName, err)
+			}
+			documents = append(documents, aws.ToString(out.PolicyDocument))
+		}
+	}
+	attached := iam.NewListAttachedUserPoliciesPaginator(client, &iam.ListAttachedUserPoliciesInput{UserName: aws.String(userName)})
+	for attached.HasMorePages() {
+		page, err := attached.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list the managed policies of %s: %w", userName, err)
+		}
+		for _, policy := range page.AttachedPolicies {
+			managed[aws.ToString(policy.PolicyArn)] = true
+		}
+	}
+
+	groups := iam.NewListGroupsForUserPaginator(client, &iam.ListGroupsForUserInput{UserName: aws.String(userName)})
+	for groups.HasMorePages() {
+		page, err := groups.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list the groups of %s: %w", userName, err)
+		}
+		for _, group := range page.Groups {
+			groupDocuments, err := groupPolicies(ctx, client, aws.ToString(group.GroupName), managed)
+			if err != nil {
+				return err
+			}
+			documents = append(documents, groupDocuments...)
+		}
+	}
+
+	for policyArn := range managed {
+		document, err := managedPolicyDocument(ctx, client, policyArn)
+		if err != nil {
+			return err
+		}
+		documents = append(documents, document)
+	}
+
+	for _, document := range documents {
+		doc, err := parsePolicyDocument(document)
+		if err != nil {
+			return err
+		}
+		for _, statement := range doc.Statement {
+			if !statement.allows("sts:AssumeRole") {
+				continue
+			}
+			for _, resource := range statement.Resource {
+				if role, ok := roleFromArn(resource); ok {
+					role.Source = RoleSourceIdentityPolicy
+					found[role.Arn] = role
+				} else {
+					slog.Debug("Skipping sts:AssumeRole grant that names no single role", "resource", resource)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// groupPolicies returns the inline policy documents of group and adds the ARNs of its managed
+// policies to managed.
+func groupPolicies(ctx context.Context, client roleDiscoveryAPI, group string, managed map[string]bool) ([]string, error) {
+	var documents []string
+	inline := iam.NewListGroupPoliciesPaginator(client, &iam.ListGroupPoliciesInput{GroupName: aws.String(group)})
+	for inline.HasMorePages() {
+		page, err := inline.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the inline policies of group %s: %w", group, err)
+		}
+		for _, name := range page.PolicyNames {
+			out, err := client.GetGroupPolicy(ctx, &iam.GetGroupPolicyInput{GroupName: aws.String(group), PolicyName: aws.String(name)})
+			if err != nil {
+				return nil, fmt.Errorf("failed to read policy %s of group %s: %w", name, group, err)
+			}
+			documents = append(documents, aws.ToString(out.PolicyDocument))
+		}
+	}
+
+	attached := iam.NewListAttachedGroupPoliciesPaginator(client, &iam.ListAttachedGroupPoliciesInput{GroupName: aws.String(group)})
+	for attached.HasMorePages() {
+		page, err := attached.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the managed policies of group %s: %w", group, err)
+		}
+		for _, policy := range page.AttachedPolicies {
+			managed[aws.ToString(policy.PolicyArn)] = true
+		}
+	}
+	return documents, nil
+}
+
+// managedPolicyDocument returns the default version of a managed policy.
+func managedPolicyDocument(ctx context.Context, client roleDiscoveryAPI, policyArn string) (string, error) {
+	policy, err := client.GetPolicy(ctx, &iam.GetPolicyInput{PolicyArn: aws.String(policyArn)})
+	if err != nil {
+		return "", fmt.Errorf("failed to read policy %s: %w", policyArn, err)
+	}
+	version, err := client.GetPolicyVersion(ctx, &iam.GetPolicyVersionInput{PolicyArn: aws.String(policyArn), VersionId: policy.Policy.DefaultVersionId})
+	if err != nil {
+		return "", fmt.Errorf("failed to read the default version of policy %s: %w", policyArn, err)
+	}
+	return aws.ToString(version.PolicyVersion.Document), nil
+}
+
+// trustPolicyRoles adds the roles of the caller's account that trust the caller, or any
+// principal of its account, to found. A role whose trust policy cannot be parsed is skipped
+// with a warning, leaving the others to be found.
+func trustPolicyRoles(ctx context.Context, client roleDiscoveryAPI, caller arn.ARN, found map[string]DiscoveredRole) error {
+	trusted := []string{caller.String(), caller.AccountID, fmt.Sprintf("arn:%s:iam::%s:root", caller.Partition, caller.AccountID)}
+
+	roles := iam.NewListRolesPaginator(client, &iam.ListRolesInput{})
+	for roles.HasMorePages() {
+		page, err := roles.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list roles: %w", err)
+		}
+		for _, role := range page.Roles {
+			doc, err := parsePolicyDocument(aws.ToString(role.AssumeRolePolicyDocument))
+			if err != nil {
+				slog.Warn("Skipping role with an unreadable trust policy", "role", aws.ToString(role.Arn), "error", err)
+				continue
+			}
+			roleArn := aws.ToString(role.Arn)
+			if _, ok := found[roleArn]; ok || !doc.trusts(trusted) {
+				continue
+			}
+			found[roleArn] = DiscoveredRole{Arn: roleArn, Name: aws.ToString(role.RoleName), AccountID: caller.AccountID, Source: RoleSourceTrustPolicy}
+		}
+	}
+	return nil
+}
+
+// roleFromArn returns the role named by resource, if it is the ARN of a single role.
+func roleFromArn(resource string) (DiscoveredRole, bool) {
+	parsed, err := arn.Parse(resource)
+	if err != nil || parsed.Service != "iam" || !strings.HasPrefix(parsed.Resource, "role/") || strings.ContainsAny(resource, "*?") {
+		return DiscoveredRole{}, false
+	}
+	return DiscoveredRole{
+		Arn:       resource,
+		Name:      parsed.Resource[strings.LastIndex(parsed.Resource, "/")+1:],
+		AccountID: parsed.AccountID,
+	}, true
+}
+
+// policyDocument is the part of an IAM policy document used to discover roles.
+type policyDocument struct {
+	Statement policyStatements `json:"Statement"`
+}
+
+// policyStatement is a single statement of a policyDocument.
+type policyStatement struct {
+	Effect    string          `json:"Effect"`
+	Action    stringList      `json:"Action"`
+	Resource  stringList      `json:"Resource"`
+	Principal policyPrincipal `json:"Principal"`
+}
+
+// policyStatements accepts both a single statement and a list of them.
+type policyStatements []policyStatement
+
+func (s *policyStatements) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '{' {
+		var statement policyStatement
+		if err := json.Unmarshal(data, &statement); err != nil {
+			return err
+		}
+		*s = policyStatements{statement}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]policyStatement)(s))
+}
+
+// stringList accepts both a single string and a list of them.
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var value string
+		if err := json.Unmarshal(data, &value); err != nil {
+			return err
+		}
+		*l = stringList{value}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(l))
+}
+
+// policyPrincipal holds the AWS principals of a statement. A "*" principal is kept out of
+// AWS, as it trusts everyone rather than the caller in particular.
+type policyPrincipal struct {
+	AWS stringList
+}
+
+func (p *policyPrincipal) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return nil
+	}
+	var principals struct {
+		AWS stringList `json:"AWS"`
+	}
+	if err := json.Unmarshal(data, &principals); err != nil {
+		return err
+	}
+	p.AWS = principals.AWS
+	return nil
+}
+
+// parsePolicyDocument decodes a policy document as returned by IAM, which URL-encodes it.
+func parsePolicyDocument(document string) (policyDocument, error) {
+	if decoded, err := url.PathUnescape(document); err == nil {
+		document = decoded
+	}
+	var doc policyDocument
+	if err := json.Unmarshal([]byte(document), &doc); err != nil {
+		return policyDocument{}, fmt.Errorf("invalid policy document: %w", err)
+	}
+	return doc, nil
+}
+
+// allows reports whether the statement allows action, matching wildcards in its actions.
+func (s policyStatement) allows(action string) bool {
+	if s.Effect != "Allow" {
+		return false
+	}
+	for _, pattern := range s.Action {
+		if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(action)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// trusts reports whether the trust policy allows any of principals to assume the role.
+func (doc policyDocument) trusts(principals []string) bool {
+	for _, statement := range doc.Statement {
+		if !statement.allows("sts:AssumeRole") {
+			continue
+		}
+		for _, principal := range statement.Principal.AWS {
+			if slices.Contains(principals, principal) {
+				return true
+			}
+		}
+	}
+	return false
+}
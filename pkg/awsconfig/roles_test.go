@@ -0,0 +1,150 @@
+package awsconfig
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeIAM serves the policies of user "me" in group "devs" and the roles of its account.
+type fakeIAM struct {
+	userPolicies  map[string]string // Inline policy documents of the user by name.
+	groupPolicies map[string]string // Inline policy documents of group devs by name.
+	managed       map[string]string // Managed policy documents attached to the group by ARN.
+	roles         []iamtypes.Role
+	policyErr     error
+	rolesErr      error
+}
+
+func (f *fakeIAM) ListRoles(ctx context.Context, params *iam.ListRolesInput, optFns ...func(*iam.Options)) (*iam.ListRolesOutput, error) {
+	return &iam.ListRolesOutput{Roles: f.roles}, f.rolesErr
+}
+
+func (f *fakeIAM) ListUserPolicies(ctx context.Context, params *iam.ListUserPoliciesInput, optFns ...func(*iam.Options)) (*iam.ListUserPoliciesOutput, error) {
+	out := &iam.ListUserPoliciesOutput{}
+	for name := range f.userPolicies {
+		out.PolicyNames = append(out.PolicyNames, name)
+	}
+	return out, f.policyErr
+}
+
+func (f *fakeIAM) GetUserPolicy(ctx context.Context, params *iam.GetUserPolicyInput, optFns ...func(*iam.Options)) (*iam.GetUserPolicyOutput, error) {
+	return &iam.GetUserPolicyOutput{PolicyDocument: aws.String(url.PathEscape(f.userPolicies[*params.PolicyName]))}, nil
+}
+
+func (f *fakeIAM) ListAttachedUserPolicies(ctx context.Context, params *iam.ListAttachedUserPoliciesInput, optFns ...func(*iam.Options)) (*iam.ListAttachedUserPoliciesOutput, error) {
+	return &iam.ListAttachedUserPoliciesOutput{}, nil
+}
+
+func (f *fakeIAM) ListGroupsForUser(ctx context.Context, params *iam.ListGroupsForUserInput, optFns ...func(*iam.Options)) (*iam.ListGroupsForUserOutput, error) {
+	return &iam.ListGroupsForUserOutput{Groups: []iamtypes.Group{{GroupName: aws.String("devs")}}}, nil
+}
+
+func (f *fakeIAM) ListGroupPolicies(ctx context.Context, params *iam.ListGroupPoliciesInput, optFns ...func(*iam.Options)) (*iam.ListGroupPoliciesOutput, error) {
+	out := &iam.ListGroupPoliciesOutput{}
+	for name := range f.groupPolicies {
+		out.PolicyNames = append(out.PolicyNames, name)
+	}
+	return out, nil
+}
+
+func (f *fakeIAM) GetGroupPolicy(ctx context.Context, params *iam.GetGroupPolicyInput, optFns ...func(*iam.Options)) (*iam.GetGroupPolicyOutput, error) {
+	return &iam.GetGroupPolicyOutput{PolicyDocument: aws.String(url.PathEscape(f.groupPolicies[*params.PolicyName]))}, nil
+}
+
+func (f *fakeIAM) ListAttachedGroupPolicies(ctx context.Context, params *iam.ListAttachedGroupPoliciesInput, optFns ...func(*iam.Options)) (*iam.ListAttachedGroupPoliciesOutput, error) {
+	out := &iam.ListAttachedGroupPoliciesOutput{}
+	for policyArn := range f.managed {
+		out.AttachedPolicies = append(out.AttachedPolicies, iamtypes.AttachedPolicy{PolicyArn: aws.String(policyArn)})
+	}
+	return out, nil
+}
+
+func (f *fakeIAM) GetPolicy(ctx context.Context, params *iam.GetPolicyInput, optFns ...func(*iam.Options)) (*iam.GetPolicyOutput, error) {
+	return &iam.GetPolicyOutput{Policy: &iamtypes.Policy{Arn: params.PolicyArn, DefaultVersionId: aws.String("v2")}}, nil
+}
+
+func (f *fakeIAM) GetPolicyVersion(ctx context.Context, params *iam.GetPolicyVersionInput, optFns ...func(*iam.Options)) (*iam.GetPolicyVersionOutput, error) {
+	return &iam.GetPolicyVersionOutput{PolicyVersion: &iamtypes.PolicyVersion{Document: aws.String(url.PathEscape(f.managed[*params.PolicyArn]))}}, nil
+}
+
+// trustRole returns a role of account 123456789012 trusting principal.
+func trustRole(name, principal string) iamtypes.Role {
+	return iamtypes.Role{
+		RoleName: aws.String(name),
+		Arn:      aws.String("arn:aws:iam::123456789012:role/" + name),
+		AssumeRolePolicyDocument: aws.String(url.PathEscape(fmt.Sprintf(
+			`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":%s,"Action":"sts:AssumeRole"}]}`, principal))),
+	}
+}
+
+func TestDiscoverRoles(t *testing.T) {
+	user := "arn:aws:iam::123456789012:user/me"
+	newIAM := func() *fakeIAM {
+		return &fakeIAM{
+			userPolicies: map[string]string{
+				"prod": `{"Statement":{"Effect":"Allow","Action":"sts:AssumeRole","Resource":"arn:aws:iam::210987654321:role/ops/Admin"}}`,
+			},
+			groupPolicies: map[string]string{
+				"deny": `{"Statement":[{"Effect":"Deny","Action":"sts:*","Resource":"arn:aws:iam::333333333333:role/Denied"}]}`,
+			},
+			managed: map[string]string{
+				"arn:aws:iam::123456789012:policy/Staging": `{"Statement":[{"Effect":"Allow","Action":["s3:*","STS:Assume*"],"Resource":["arn:aws:iam::444444444444:role/ReadOnly","arn:aws:iam::*:role/Any"]}]}`,
+			},
+			roles: []iamtypes.Role{
+				trustRole("Deploy", `{"AWS":"arn:aws:iam::123456789012:root"}`),
+				trustRole("Mine", `{"AWS":["arn:aws:iam::123456789012:user/me"]}`),
+				trustRole("Lambda", `{"Service":"lambda.amazonaws.com"}`),
+				trustRole("Public", `"*"`),
+			},
+		}
+	}
+
+	t.Run("Policies and trust policies", func(t *testing.T) {
+		roles, err := discoverRoles(context.TODO(), callerSTS(user), newIAM())
+		assert.NoError(t, err)
+		assert.Equal(t, []DiscoveredRole{
+			{Arn: "arn:aws:iam::123456789012:role/Deploy", Name: "Deploy", AccountID: "123456789012", Source: RoleSourceTrustPolicy},
+			{Arn: "arn:aws:iam::123456789012:role/Mine", Name: "Mine", AccountID: "123456789012", Source: RoleSourceTrustPolicy},
+			{Arn: "arn:aws:iam::210987654321:role/ops/Admin", Name: "Admin", AccountID: "210987654321", Source: RoleSourceIdentityPolicy},
+			{Arn: "arn:aws:iam::444444444444:role/ReadOnly", Name: "ReadOnly", AccountID: "444444444444", Source: RoleSourceIdentityPolicy},
+		}, roles)
+	})
+
+	t.Run("Unreadable policies still list trusting roles", func(t *testing.T) {
+		client := newIAM()
+		client.policyErr = fmt.Errorf("AccessDenied")
+		roles, err := discoverRoles(context.TODO(), callerSTS(user), client)
+		assert.NoError(t, err)
+		assert.Len(t, roles, 2)
+	})
+
+	t.Run("Skips a role with an unreadable trust policy", func(t *testing.T) {
+		client := newIAM()
+		broken := trustRole("Broken", `{"AWS":"arn:aws:iam::123456789012:root"}`)
+		broken.AssumeRolePolicyDocument = aws.String("{not json")
+		client.roles = append([]iamtypes.Role{broken}, client.roles...)
+		roles, err := discoverRoles(context.TODO(), callerSTS(user), client)
+		assert.NoError(t, err)
+		assert.Len(t, roles, 4)
+	})
+
+	t.Run("Nothing readable", func(t *testing.T) {
+		client := newIAM()
+		client.policyErr = fmt.Errorf("AccessDenied")
+		client.rolesErr = fmt.Errorf("AccessDenied")
+		_, err := discoverRoles(context.TODO(), callerSTS(user), client)
+		assert.ErrorContains(t, err, "failed to list roles")
+	})
+
+	t.Run("Not an IAM user", func(t *testing.T) {
+		_, err := discoverRoles(context.TODO(), callerSTS("arn:aws:sts::123456789012:assumed-role/Admin/me"), newIAM())
+		assert.ErrorContains(t, err, "requires the credentials of an IAM user")
+	})
+}
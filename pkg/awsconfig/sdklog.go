@@ -0,0 +1,72 @@
+package awsconfig
+
+import (
+	"context"
+	"fmt"
+	"gredentures/pkg/appconfig"
+	"gredentures/pkg/secret"
+	"log/slog"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/smithy-go/logging"
+)
+
+var (
+	// sensitiveHeaders matches request headers carrying signatures or session tokens.
+	sensitiveHeaders = regexp.MustCompile(`(?im)^((?:Authorization|X-Amz-Security-Token):[ \t]*)[^\r\n]*`)
+	// sensitiveParams matches presigned URL parameters carrying signatures or session tokens.
+	sensitiveParams = regexp.MustCompile(`(?i)(X-Amz-(?:Security-Token|Signature|Credential)=)[^&\s]*`)
+)
+
+// sdkLogger hands AWS SDK request and response logs to slog at trace level.
+type sdkLogger struct{}
+
+// Logf implements logging.Logger, redacting signatures and session tokens first.
+func (sdkLogger) Logf(classification logging.Classification, format string, v ...interface{}) {
+	slog.Log(context.Background(), appconfig.LevelTrace, redactSDKLog(fmt.Sprintf(format, v...)),
+		"source", "aws-sdk", "classification", string(classification))
+}
+
+// redactSDKLog removes signatures and session tokens from an SDK request or response dump.
+// Bodies are never logged, so the MFA token code and returned credentials do not appear.
+func redactSDKLog(message string) string {
+	message = sensitiveHeaders.ReplaceAllString(message, "${1}"+secret.Redacted)
+	return sensitiveParams.ReplaceAllString(message, "${1}"+secret.Redacted)
+}
+
+// sdkLogModes maps the SDK.Log values to the log modes of the AWS SDK.
+var sdkLogModes = map[string]aws.ClientLogMode{
+	appconfig.SDKLogRequest:    aws.LogRequest,
+	appconfig.SDKLogResponse:   aws.LogResponse,
+	appconfig.SDKLogRetries:    aws.LogRetries,
+	appconfig.SDKLogSigning:    aws.LogSigning,
+	appconfig.SDKLogDeprecated: aws.LogDeprecatedUsage,
+}
+
+// sdkLogOptions turns on the AWS SDK logging of appconfig.DefaultSDKLog when tracing with
+// -vvv. The SDK.Log of the config file replaces the modes, see sdkLogMode.
+func sdkLogOptions() []func(*config.LoadOptions) error {
+	if !tracing() {
+		return nil
+	}
+	return []func(*config.LoadOptions) error{
+		config.WithClientLogMode(sdkLogMode(appconfig.DefaultSDKLog)),
+		config.WithLogger(sdkLogger{}),
+	}
+}
+
+// sdkLogMode returns the log mode of the AWS SDK logging the given SDK.Log values.
+func sdkLogMode(modes []string) aws.ClientLogMode {
+	var mode aws.ClientLogMode
+	for _, name := range modes {
+		mode |= sdkLogModes[name]
+	}
+	return mode
+}
+
+// tracing reports whether -vvv asked for trace logging.
+func tracing() bool {
+	return slog.Default().Enabled(context.Background(), appconfig.LevelTrace)
+}
@@ -0,0 +1,58 @@
+package awsconfig
+
+import (
+	"bytes"
+	"gredentures/pkg/appconfig"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/smithy-go/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactSDKLog(t *testing.T) {
+	dump := "Request\r\nPOST / HTTP/1.1\r\nHost: sts.us-west-2.amazonaws.com\r\n" +
+		"Authorization: AWS4-HMAC-SHA256 Credential=AKIAEXAMPLE/20301231/us-west-2/sts/aws4_request, Signature=abc123\r\n" +
+		"X-Amz-Security-Token: mockSessionToken\r\n\r\n" +
+		"GET /?X-Amz-Credential=AKIAEXAMPLE&X-Amz-Security-Token=mockSessionToken&X-Amz-Signature=abc123 HTTP/1.1"
+
+	redacted := redactSDKLog(dump)
+	for _, secret := range []string{"AKIAEXAMPLE", "abc123", "mockSessionToken"} {
+		assert.NotContains(t, redacted, secret)
+	}
+	assert.Contains(t, redacted, "Host: sts.us-west-2.amazonaws.com\r\n")
+	assert.Contains(t, redacted, "Authorization: <redacted>\r\n")
+	assert.Contains(t, redacted, "X-Amz-Security-Token=<redacted>&")
+
+	// The canonical request of signing logs lists the headers in lower case
+	signing := "Request Signature:\n---[ CANONICAL STRING  ]-----------------------------\nPOST\n/\n\n" +
+		"host:sts.us-west-2.amazonaws.com\nx-amz-security-token:mockSessionToken\n"
+	assert.NotContains(t, redactSDKLog(signing), "mockSessionToken")
+}
+
+func TestSDKLogMode(t *testing.T) {
+	assert.Equal(t, aws.LogRequest|aws.LogResponse|aws.LogRetries, sdkLogMode(appconfig.DefaultSDKLog))
+	assert.Equal(t, aws.LogSigning|aws.LogDeprecatedUsage, sdkLogMode([]string{appconfig.SDKLogSigning, appconfig.SDKLogDeprecated}))
+	assert.Zero(t, sdkLogMode(nil))
+}
+
+func TestSDKLogger(t *testing.T) {
+	defer slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, nil)))
+
+	var logs bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	assert.Empty(t, sdkLogOptions(), "SDK logging is off below trace")
+	signing := appconfig.SDKConfig{Log: []string{appconfig.SDKLogSigning}}
+	assert.Zero(t, tuneSDK(aws.Config{}, signing).ClientLogMode, "SDK.Log only applies while tracing")
+
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: appconfig.LevelTrace})))
+	assert.Len(t, sdkLogOptions(), 2)
+	assert.Equal(t, aws.LogSigning, tuneSDK(aws.Config{ClientLogMode: aws.LogRequest}, signing).ClientLogMode)
+
+	sdkLogger{}.Logf(logging.Debug, "Request\nX-Amz-Security-Token: %s", "mockSessionToken")
+	assert.Contains(t, logs.String(), "source=aws-sdk")
+	assert.Contains(t, logs.String(), "classification=DEBUG")
+	assert.NotContains(t, logs.String(), "mockSessionToken")
+}
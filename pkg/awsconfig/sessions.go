@@ -0,0 +1,185 @@
+package awsconfig
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"gredentures/pkg/interrupt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/cloudtrail"
+	cttypes "github.com/aws/aws-sdk-go-v2/service/cloudtrail/types"
+)
+
+// globalSTSRegion is where CloudTrail records requests to the global STS endpoint, which
+// older tools still use instead of a regional one.
+const globalSTSRegion = "us-east-1"
+
+// sessionEvents are the STS events that issue the sessions listed by IssuedSessions.
+var sessionEvents = []string{"GetSessionToken", "AssumeRole"}
+
+// cloudTrailAPI is the subset of the CloudTrail client used to look up issued sessions, so it can be mocked in tests.
+type cloudTrailAPI interface {
+	LookupEvents(ctx context.Context, params *cloudtrail.LookupEventsInput, optFns ...func(*cloudtrail.Options)) (*cloudtrail.LookupEventsOutput, error)
+}
+
+// IssuedSession is a GetSessionToken or AssumeRole request of the caller recorded by CloudTrail.
+type IssuedSession struct {
+	Time        time.Time // When the request was made.
+	Event       string    // GetSessionToken or AssumeRole.
+	Region      string    // Region CloudTrail recorded the request in.
+	RoleArn     string    // Role assumed, empty for GetSessionToken.
+	AccessKeyID string    // Access key of the issued session, empty when the request failed.
+	Expires     time.Time // Expiry of the issued session, zero when unknown.
+	SourceIP    string    // Address the request came from.
+	UserAgent   string    // User agent of the requesting tool.
+	Error       string    // Error code of a refused request, no session was issued then.
+	Current     bool      // Whether this is the session gredentures just acquired.
+}
+
+// IssuedSessions lists the sessions CloudTrail recorded as issued to the caller of the MFA
+// session since the given time, newest first. Both the region of the session and us-east-1,
+// where requests to the global STS endpoint are recorded, are searched. Reading them requires
+// cloudtrail:LookupEvents, and CloudTrail keeps events for 90 days.
+func (conf *AwsConfig) IssuedSessions(since time.Time) ([]IssuedSession, error) {
+	if conf.sessionCreds == nil || conf.sessionCreds.Credentials == nil {
+		return nil, fmt.Errorf("session credentials are required to look up sessions")
+	}
+
+	config, err := conf.sessionAccount()
+	if err != nil {
+		return nil, err
+	}
+	trails := conf.clients(config)
+	clients := func(region string) cloudTrailAPI { return trails.cloudTrail(region) }
+	sessions, err := issuedSessions(interrupt.Context(), conf.stsClient(config), clients, sessionRegions(config.Region), since)
+	if err != nil {
+		return nil, err
+	}
+	current := aws.ToString(conf.sessionCreds.Credentials.AccessKeyId)
+	for i := range sessions {
+		sessions[i].Current = sessions[i].AccessKeyID == current
+	}
+	return sessions, nil
+}
+
+// sessionRegions returns the regions to search for the sessions of a client in region: the
+// region itself and, in the commercial partition, the region of the global STS endpoint.
+func sessionRegions(region string) []string {
+	region = cmp.Or(region, globalSTSRegion)
+	if region == globalSTSRegion || strings.HasPrefix(region, "cn-") || strings.HasPrefix(region, "us-gov-") {
+		return []string{region}
+	}
+	return []string{region, globalSTSRegion}
+}
+
+// issuedSessions looks up the session events of the caller of client in each region since the
+// given time. CloudTrail only filters on one attribute per lookup, so events are looked up by
+// the caller's name and the STS events picked from them.
+func issuedSessions(ctx context.Context, client stsAPI, clients func(region string) cloudTrailAPI, regions []string, since time.Time) ([]IssuedSession, error) {
+	callerArn, err := callerIdentity(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	username, err := cloudTrailUsername(callerArn)
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []IssuedSession
+	seen := map[string]bool{}
+	for _, region := range regions {
+		slog.Debug("Looking up sessions in CloudTrail", "region", region, "username", username, "since", since)
+		input := &cloudtrail.LookupEventsInput{
+			LookupAttributes: []cttypes.LookupAttribute{{AttributeKey: cttypes.LookupAttributeKeyUsername, AttributeValue: aws.String(username)}},
+			StartTime:        aws.Time(since),
+		}
+		pages := cloudtrail.NewLookupEventsPaginator(clients(region), input)
+		for pages.HasMorePages() {
+			page, err := pages.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to look up the events of %s in %s: %w", username, region, err)
+			}
+			for _, event := range page.Events {
+				id := aws.ToString(event.EventId)
+				if aws.ToString(event.EventSource) != "sts.amazonaws.com" || !slices.Contains(sessionEvents, aws.ToString(event.EventName)) || seen[id] {
+					continue
+				}
+				seen[id] = true
+				session, err := parseSessionEvent(event, region)
+				if err != nil {
+					slog.Debug("Skipping unreadable CloudTrail event", "id", id, "error", err)
+					continue
+				}
+				sessions = append(sessions, session)
+			}
+		}
+	}
+	slices.SortStableFunc(sessions, func(a, b IssuedSession) int { return b.Time.Compare(a.Time) })
+	return sessions, nil
+}
+
+// cloudTrailUsername returns the user name CloudTrail records the requests of callerArn
+// under: the name of an IAM user, or the session name of an assumed role.
+func cloudTrailUsername(callerArn string) (string, error) {
+	caller, err := arn.Parse(callerArn)
+	if err != nil {
+		return "", fmt.Errorf("unexpected caller %s: %w", callerArn, err)
+	}
+	if strings.HasPrefix(caller.Resource, "user/") || strings.HasPrefix(caller.Resource, "assumed-role/") {
+		return caller.Resource[strings.LastIndex(caller.Resource, "/")+1:], nil
+	}
+	return "", fmt.Errorf("looking up sessions requires the credentials of an IAM user or role, not %s", callerArn)
+}
+
+// sessionRecord holds the fields of a CloudTrail record of an STS request that
+// IssuedSession reports. The session token and secret it may hold are never decoded.
+type sessionRecord struct {
+	SourceIPAddress   string `json:"sourceIPAddress"`
+	UserAgent         string `json:"userAgent"`
+	ErrorCode         string `json:"errorCode"`
+	RequestParameters struct {
+		RoleArn string `json:"roleArn"`
+	} `json:"requestParameters"`
+	ResponseElements struct {
+		Credentials struct {
+			AccessKeyID string `json:"accessKeyId"`
+			Expiration  string `json:"expiration"`
+		} `json:"credentials"`
+	} `json:"responseElements"`
+}
+
+// cloudTrailTimeLayouts are the layouts CloudTrail writes session expiries in, always UTC.
+var cloudTrailTimeLayouts = []string{"Jan 2, 2006, 3:04:05 PM", "Jan 2, 2006 3:04:05 PM", time.RFC3339}
+
+// parseSessionEvent returns the session an STS event recorded in region issued.
+func parseSessionEvent(event cttypes.Event, region string) (IssuedSession, error) {
+	var record sessionRecord
+	if err := json.Unmarshal([]byte(aws.ToString(event.CloudTrailEvent)), &record); err != nil {
+		return IssuedSession{}, err
+	}
+	session := IssuedSession{
+		Time:        aws.ToTime(event.EventTime),
+		Event:       aws.ToString(event.EventName),
+		Region:      region,
+		RoleArn:     record.RequestParameters.RoleArn,
+		AccessKeyID: record.ResponseElements.Credentials.AccessKeyID,
+		SourceIP:    record.SourceIPAddress,
+		UserAgent:   record.UserAgent,
+		Error:       record.ErrorCode,
+	}
+	for _, layout := range cloudTrailTimeLayouts {
+		if expires, err := time.Parse(layout, record.ResponseElements.Credentials.Expiration); err == nil {
+			session.Expires = expires
+			break
+		}
+	}
+	return session, nil
+}
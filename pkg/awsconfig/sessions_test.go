@@ -0,0 +1,136 @@
+package awsconfig
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudtrail"
+	cttypes "github.com/aws/aws-sdk-go-v2/service/cloudtrail/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeCloudTrail serves events one page at a time, recording the lookups made.
+type fakeCloudTrail struct {
+	pages   [][]cttypes.Event
+	err     error
+	lookups []*cloudtrail.LookupEventsInput
+}
+
+func (f *fakeCloudTrail) LookupEvents(ctx context.Context, params *cloudtrail.LookupEventsInput, optFns ...func(*cloudtrail.Options)) (*cloudtrail.LookupEventsOutput, error) {
+	f.lookups = append(f.lookups, params)
+	if f.err != nil {
+		return nil, f.err
+	}
+	page := len(f.lookups) - 1
+	out := &cloudtrail.LookupEventsOutput{}
+	if page < len(f.pages) {
+		out.Events = f.pages[page]
+	}
+	if page+1 < len(f.pages) {
+		out.NextToken = aws.String("next")
+	}
+	return out, nil
+}
+
+// trailEvent returns an STS event recorded at the given time with record as its CloudTrail JSON.
+func trailEvent(id, name string, at time.Time, record string) cttypes.Event {
+	return cttypes.Event{
+		EventId:         aws.String(id),
+		EventName:       aws.String(name),
+		EventSource:     aws.String("sts.amazonaws.com"),
+		EventTime:       aws.Time(at),
+		CloudTrailEvent: aws.String(record),
+	}
+}
+
+func TestIssuedSessions(t *testing.T) {
+	since := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	regional := &fakeCloudTrail{pages: [][]cttypes.Event{
+		{
+			trailEvent("1", "GetSessionToken", since.Add(time.Hour), `{"sourceIPAddress":"203.0.113.7","userAgent":"aws-sdk-go-v2/1.36.3",
+				"responseElements":{"credentials":{"accessKeyId":"ASIASESSION","sessionToken":"secretToken","expiration":"Jan 2, 2030, 1:00:00 AM"}}}`),
+			{EventId: aws.String("2"), EventName: aws.String("ListBuckets"), EventSource: aws.String("s3.amazonaws.com"), EventTime: aws.Time(since.Add(2 * time.Hour))},
+		},
+		{
+			trailEvent("3", "AssumeRole", since.Add(3*time.Hour), `{"sourceIPAddress":"203.0.113.7",
+				"requestParameters":{"roleArn":"arn:aws:iam::111111111111:role/Admin"},
+				"responseElements":{"credentials":{"accessKeyId":"ASIAROLE","expiration":"Jan 1, 2030 4:00:00 AM"}}}`),
+		},
+	}}
+	global := &fakeCloudTrail{pages: [][]cttypes.Event{{
+		trailEvent("4", "AssumeRole", since.Add(2*time.Hour), `{"sourceIPAddress":"198.51.100.1","errorCode":"AccessDenied",
+			"requestParameters":{"roleArn":"arn:aws:iam::222222222222:role/Admin"},"responseElements":null}`),
+		trailEvent("1", "GetSessionToken", since.Add(time.Hour), `{}`),
+		trailEvent("5", "GetSessionToken", since.Add(4*time.Hour), `not json`),
+	}}}
+	clients := func(region string) cloudTrailAPI {
+		if region == globalSTSRegion {
+			return global
+		}
+		return regional
+	}
+
+	sessions, err := issuedSessions(context.Background(), callerSTS("arn:aws:iam::123456789012:user/alice"), clients, []string{"eu-west-1", globalSTSRegion}, since)
+	assert.NoError(t, err)
+	assert.Equal(t, []IssuedSession{
+		{Time: since.Add(3 * time.Hour), Event: "AssumeRole", Region: "eu-west-1", RoleArn: "arn:aws:iam::111111111111:role/Admin",
+			AccessKeyID: "ASIAROLE", Expires: since.Add(4 * time.Hour), SourceIP: "203.0.113.7"},
+		{Time: since.Add(2 * time.Hour), Event: "AssumeRole", Region: globalSTSRegion, RoleArn: "arn:aws:iam::222222222222:role/Admin",
+			SourceIP: "198.51.100.1", Error: "AccessDenied"},
+		{Time: since.Add(time.Hour), Event: "GetSessionToken", Region: "eu-west-1", AccessKeyID: "ASIASESSION",
+			Expires: since.Add(25 * time.Hour), SourceIP: "203.0.113.7", UserAgent: "aws-sdk-go-v2/1.36.3"},
+	}, sessions, "events are merged newest first, each once")
+
+	assert.Len(t, regional.lookups, 2, "every page is read")
+	assert.Equal(t, "alice", aws.ToString(regional.lookups[0].LookupAttributes[0].AttributeValue))
+	assert.Equal(t, cttypes.LookupAttributeKeyUsername, regional.lookups[0].LookupAttributes[0].AttributeKey)
+	assert.Equal(t, since, aws.ToTime(regional.lookups[0].StartTime))
+
+	t.Run("Reports lookup failures", func(t *testing.T) {
+		denied := &fakeCloudTrail{err: errors.New("AccessDeniedException")}
+		_, err := issuedSessions(context.Background(), callerSTS("arn:aws:iam::123456789012:user/alice"),
+			func(string) cloudTrailAPI { return denied }, []string{"eu-west-1"}, since)
+		assert.ErrorContains(t, err, "failed to look up the events of alice in eu-west-1: AccessDeniedException")
+	})
+}
+
+func TestCloudTrailUsername(t *testing.T) {
+	tests := []struct {
+		arn      string
+		expected string
+		err      bool
+	}{
+		{arn: "arn:aws:iam::123456789012:user/alice", expected: "alice"},
+		{arn: "arn:aws:iam::123456789012:user/engineering/bob", expected: "bob"},
+		{arn: "arn:aws:sts::123456789012:assumed-role/Admin/carol@example.com", expected: "carol@example.com"},
+		{arn: "arn:aws:iam::123456789012:root", err: true},
+		{arn: "not an arn", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.arn, func(t *testing.T) {
+			username, err := cloudTrailUsername(tt.arn)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, username)
+		})
+	}
+}
+
+func TestSessionRegions(t *testing.T) {
+	assert.Equal(t, []string{"eu-west-1", "us-east-1"}, sessionRegions("eu-west-1"))
+	assert.Equal(t, []string{"us-east-1"}, sessionRegions("us-east-1"))
+	assert.Equal(t, []string{"us-east-1"}, sessionRegions(""))
+	assert.Equal(t, []string{"us-gov-west-1"}, sessionRegions("us-gov-west-1"))
+	assert.Equal(t, []string{"cn-north-1"}, sessionRegions("cn-north-1"))
+}
+
+func TestIssuedSessionsRequiresSession(t *testing.T) {
+	_, err := (&AwsConfig{}).IssuedSessions(time.Now())
+	assert.ErrorContains(t, err, "session credentials are required")
+}
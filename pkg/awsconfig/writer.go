@@ -0,0 +1,587 @@
+package awsconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gredentures/pkg/appconfig"
+	"gredentures/pkg/secret"
+	"io"
+	"log/slog"
+	"path"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"gopkg.in/ini.v1"
+)
+
+// keychainService is the service name credentials are stored under in the OS keychain.
+const keychainService = "gredentures"
+
+// Keys recording when the credentials of a profile expire in the shared credentials file. The
+// AWS SDKs ignore them, but other credential helpers read and write them: saml2aws and awsume
+// use expiresKey, others sessionExpirationKey. gredentures writes both and reads any of
+// expiryKeys, so profiles written by those tools have a known expiry too.
+const (
+	expiresKey           = "x_security_token_expires"
+	sessionExpirationKey = "aws_session_expiration"
+)
+
+// expiryKeys lists the keys an expiry is read from, in order of precedence. awsume's
+// autoawsume writes expiration, aws_credential_expiration mirrors AWS_CREDENTIAL_EXPIRATION.
+var expiryKeys = []string{expiresKey, sessionExpirationKey, "aws_credential_expiration", "expiration"}
+
+// expiryLayouts are the formats an expiry is parsed from: RFC 3339, and the local time of
+// awsume's expiration key.
+var expiryLayouts = []string{time.RFC3339, time.DateTime}
+
+// parseExpiry parses an expiry in one of expiryLayouts.
+func parseExpiry(value string) (time.Time, error) {
+	for _, layout := range expiryLayouts {
+		if expires, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return expires.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("not an RFC 3339 time")
+}
+
+// Profile is a named set of credentials handed to a CredentialWriter.
+type Profile struct {
+	Name        string          // Profile name, e.g. default-mfa.
+	Credentials aws.Credentials // Keys, session token and expiry.
+	Region      string          // Region to use with the credentials, empty to leave it to the AWS config.
+}
+
+// CredentialSet holds every set of credentials produced by a gredentures run.
+type CredentialSet struct {
+	Source  *Profile  // Long-lived keys to write back, nil when they are managed elsewhere.
+	Session Profile   // MFA session credentials.
+	Roles   []Profile // Assumed role credentials, sorted by profile name.
+	Managed []string  // Further profiles gredentures owns, names or path.Match patterns, see appconfig.AppConfig.ManagedPatterns.
+	Current *Profile  // The session again under the CurrentProfile alias, nil without one. Only credentials files hold it.
+	Protect bool      // Refuse to overwrite or remove the profiles of other credential helpers, see ForeignProfile.
+	Force   bool      // Overwrite unmanaged profiles holding long-lived keys, see ErrProfileCollision.
+}
+
+// String describes the profile with its secret access key and session token redacted.
+func (p Profile) String() string {
+	expires := ""
+	if p.Credentials.CanExpire {
+		expires = p.Credentials.Expires.UTC().Format(time.RFC3339)
+	}
+	return fmt.Sprintf("{Name:%s AccessKeyID:%s SecretAccessKey:%s SessionToken:%s Expires:%s Region:%s}",
+		p.Name, p.Credentials.AccessKeyID, secret.Value(p.Credentials.SecretAccessKey),
+		secret.Value(p.Credentials.SessionToken), expires, p.Region)
+}
+
+// Format implements fmt.Formatter so a Profile printed with any verb, on its own or inside a
+// CredentialSet, shows the redacted String form rather than its raw credentials.
+func (p Profile) Format(f fmt.State, _ rune) {
+	fmt.Fprint(f, p.String())
+}
+
+// LogValue implements slog.LogValuer, logging the profile with its secrets redacted.
+func (p Profile) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("name", p.Name),
+		slog.String("access_key_id", p.Credentials.AccessKeyID),
+		slog.Any("secret_access_key", secret.Value(p.Credentials.SecretAccessKey)),
+		slog.Any("session_token", secret.Value(p.Credentials.SessionToken)),
+	)
+}
+
+// CredentialWriter persists or prints a CredentialSet.
+type CredentialWriter interface {
+	WriteCredentials(set CredentialSet) error
+}
+
+// NewCredentialWriter returns the writer for an --output format. Writers that print send
+// their output to out, and cluster is only used by the k8s-exec output.
+func NewCredentialWriter(output string, out io.Writer, cluster string) (CredentialWriter, error) {
+	switch output {
+	case "", appconfig.OutputINI:
+		return &SharedCredentialsWriter{Path: CredentialsPath()}, nil
+	case appconfig.OutputEnv:
+		return &EnvWriter{Out: out}, nil
+	case appconfig.OutputJSON:
+		return &JSONWriter{Out: out}, nil
+	case appconfig.OutputKeychain:
+		return &KeychainWriter{Service: keychainService, Run: outputWithStdin}, nil
+	case appconfig.OutputCredentialProcess:
+		return &CredentialProcessWriter{Out: out}, nil
+	case appconfig.OutputK8sExec:
+		return &ExecCredentialWriter{Out: out, Cluster: cluster}, nil
+	}
+	return nil, fmt.Errorf("unknown output %q", output)
+}
+
+// WriteCredentials hands the current credentials to the writer, refusing with
+// ErrIncompleteCredentials when any of them is missing or empty.
+func (conf *AwsConfig) WriteCredentials(writer CredentialWriter) error {
+	set, err := conf.credentialSet()
+	if err != nil {
+		return err
+	}
+	if err := set.validate(); err != nil {
+		return err
+	}
+	return writer.WriteCredentials(set)
+}
+
+// credentialSet collects the source, session and role credentials. The source keys are left
+// out when they came from an external secret store or a separate credentials file, or the
+// sessions go to a credentials file of their own.
+func (conf *AwsConfig) credentialSet() (CredentialSet, error) {
+	if conf.sessionCreds == nil || conf.sessionCreds.Credentials == nil {
+		return CredentialSet{}, fmt.Errorf("%w: no session credentials available", ErrIncompleteCredentials)
+	}
+
+	set := CredentialSet{Managed: conf.managed, Protect: conf.compatMode, Force: conf.force}
+	if conf.externalSource || conf.sourceFile != "" || CredentialsPath() != homeCredentialsPath() {
+		slog.Debug("Not writing externally sourced credentials", "section", conf.SourceProfileName())
+	} else {
+		set.Source = &Profile{Name: conf.SourceProfileName(), Credentials: conf.defaultCreds}
+	}
+
+	sessionProfile := conf.sessionProfile
+	if sessionProfile == "" {
+		sessionProfile = defaultSessionProfile
+	}
+	set.Session = stsProfile(sessionProfile, conf.sessionCreds.Credentials.AccessKeyId,
+		conf.sessionCreds.Credentials.SecretAccessKey, conf.sessionCreds.Credentials.SessionToken,
+		conf.sessionCreds.Credentials.Expiration)
+	set.Session.Region = conf.region
+	if conf.currentProfile != "" && conf.currentProfile != sessionProfile {
+		current := set.Session
+		current.Name = conf.currentProfile
+		set.Current = &current
+	}
+
+	names := make([]string, 0, len(conf.roleCreds))
+	for name := range conf.roleCreds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		creds := conf.roleCreds[name]
+		if creds == nil {
+			return CredentialSet{}, fmt.Errorf("%w: no credentials for profile %s", ErrIncompleteCredentials, name)
+		}
+		role := stsProfile(name, creds.AccessKeyId, creds.SecretAccessKey, creds.SessionToken, creds.Expiration)
+		role.Region = conf.region
+		set.Roles = append(set.Roles, role)
+	}
+
+	return set, nil
+}
+
+// validate checks that every session and role profile of the set has all of its
+// credentials. A failed STS call must not end up as a profile with empty keys, which the AWS
+// tools only reject at their next call.
+func (set CredentialSet) validate() error {
+	for _, profile := range append([]Profile{set.Session}, set.Roles...) {
+		var missing []string
+		if profile.Credentials.AccessKeyID == "" {
+			missing = append(missing, "access key ID")
+		}
+		if profile.Credentials.SecretAccessKey == "" {
+			missing = append(missing, "secret access key")
+		}
+		if profile.Credentials.SessionToken == "" {
+			missing = append(missing, "session token")
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("%w: profile %s has no %s", ErrIncompleteCredentials, profile.Name, strings.Join(missing, ", "))
+		}
+	}
+	return nil
+}
+
+// stsProfile converts the credential fields returned by STS into a Profile.
+func stsProfile(name string, accessKeyID, secretAccessKey, sessionToken *string, expiration *time.Time) Profile {
+	creds := aws.Credentials{
+		AccessKeyID:     aws.ToString(accessKeyID),
+		SecretAccessKey: aws.ToString(secretAccessKey),
+		SessionToken:    aws.ToString(sessionToken),
+	}
+	if expiration != nil {
+		creds.CanExpire = true
+		creds.Expires = expiration.UTC()
+	}
+	return Profile{Name: name, Credentials: creds}
+}
+
+// sessionTokenKeys are the keys of a session token in the shared credentials file,
+// aws_security_token being the one of older SDKs.
+var sessionTokenKeys = []string{"aws_session_token", "aws_security_token"}
+
+// managedMarker is the comment above every section gredentures writes, which tells its own
+// sections from those of the user or other tools.
+const managedMarker = "# gredentures:managed"
+
+// SharedCredentialsWriter writes every profile to a shared credentials INI file, replacing it
+// atomically. The sections gredentures manages, those carrying managedMarker or named by
+// CredentialSet.Managed, are replaced, so managed profiles no longer written are removed;
+// every other section is kept. With Merge set, only the session and role profiles written
+// are replaced and the long-lived source keys are never copied into the file. The
+// CredentialSet.Current alias is written after them. Profiles of other credential helpers
+// overlapping the managed ones are warned about, and with CredentialSet.Protect never
+// touched: writing one fails and cleaning one up keeps it. Writing over a profile of the user
+// holding long-lived keys fails unless CredentialSet.Force is set.
+type SharedCredentialsWriter struct {
+	Path      string // Credentials file, usually ~/.aws/credentials.
+	Merge     bool   // Update the written profiles in an existing file instead of cleaning up the managed ones.
+	Unmanaged bool   // Write the profiles without managedMarker, so later logins leave them alone.
+
+	RemovedToken bool // Set by WriteCredentials when it removed a stale session token from the source profile.
+}
+
+// WriteCredentials implements CredentialWriter.
+func (w *SharedCredentialsWriter) WriteCredentials(set CredentialSet) error {
+	inidata, err := ini.LooseLoad(w.Path)
+	if err != nil {
+		return fmt.Errorf("failed to load %s: %w", w.Path, err)
+	}
+	if w.Merge {
+		set.Source = nil
+	}
+	profiles := append([]Profile{set.Session}, set.Roles...)
+	if set.Current != nil {
+		profiles = append(profiles, *set.Current)
+	}
+	foreign, err := foreignProfiles(inidata, w.Path, AWSConfigPath())
+	if err != nil {
+		return err
+	}
+	written := []string{}
+	for _, profile := range profiles {
+		if other, ok := foreign[profile.Name]; ok {
+			if set.Protect {
+				return fmt.Errorf("%w: profile %s is managed by %s in %s, give the gredentures profile another name",
+					ErrForeignProfile, profile.Name, other.Tool, other.File)
+			}
+			slog.Warn("Overwriting a profile of another credential helper", "profile", profile.Name, "tool", other.Tool, "file", other.File)
+		}
+		if section, err := inidata.GetSection(profile.Name); err == nil && !managedSection(section, set.Managed) && set.longLived(section) {
+			if !set.Force {
+				return fmt.Errorf("%w: profile %s in %s holds the access key %s, which gredentures does not manage, give the session another name or pass --force",
+					ErrProfileCollision, profile.Name, w.Path, section.Key("aws_access_key_id").String())
+			}
+			slog.Warn("Overwriting the long-lived keys of a profile", "profile", profile.Name, "access_key_id", section.Key("aws_access_key_id").String())
+		}
+		written = append(written, profile.Name)
+	}
+	for _, section := range inidata.Sections() {
+		name := section.Name()
+		switch {
+		case name == ini.DefaultSection || set.Source != nil && name == set.Source.Name:
+			continue // Never managed, the source keys are updated in place below
+		case slices.Contains(written, name):
+			slog.Debug("Replacing managed section", "section", name)
+			inidata.DeleteSection(name)
+		case !w.Merge && managedSection(section, set.Managed):
+			if other, ok := foreign[name]; ok {
+				if set.Protect {
+					slog.Warn("Keeping a profile of another credential helper", "profile", name, "tool", other.Tool)
+					continue
+				}
+				slog.Warn("Removing a profile of another credential helper", "profile", name, "tool", other.Tool)
+			}
+			slog.Debug("Removing managed section", "section", name)
+			inidata.DeleteSection(name)
+		}
+	}
+
+	// Helper function to create a section and add keys.
+	addKeysToSection := func(sectionName string, keys map[string]string) error {
+		slog.Debug("Creating section", "section", sectionName)
+		sec, err := inidata.NewSection(sectionName)
+		if err != nil {
+			return fmt.Errorf("failed to create section '%s': %w", sectionName, err)
+		}
+		for key, value := range keys {
+			slog.Debug("Creating key", "key", key)
+			if _, err := sec.NewKey(key, value); err != nil {
+				return fmt.Errorf("failed to create key '%s' in section '%s': %w", key, sectionName, err)
+			}
+		}
+		return nil
+	}
+
+	// Update the keys of the source ("default") section, never replacing them with empty ones.
+	// It belongs to the user, so its other keys are kept and it gets no marker.
+	if set.Source != nil {
+		if set.Source.Credentials.AccessKeyID == "" || set.Source.Credentials.SecretAccessKey == "" {
+			return fmt.Errorf("%w: the default credentials of profile %s are empty", ErrIncompleteCredentials, set.Source.Name)
+		}
+		source := inidata.Section(set.Source.Name)
+		source.Key("aws_access_key_id").SetValue(set.Source.Credentials.AccessKeyID)
+		source.Key("aws_secret_access_key").SetValue(set.Source.Credentials.SecretAccessKey)
+		// A session token next to the long-lived keys is sent along with them and breaks GetSessionToken
+		for _, key := range sessionTokenKeys {
+			if source.HasKey(key) && set.Source.Credentials.SessionToken == "" {
+				slog.Debug("Removing stale session token", "section", set.Source.Name, "key", key)
+				source.DeleteKey(key)
+				w.RemovedToken = true
+			}
+		}
+	}
+
+	// Add keys to the session ("default-mfa") section, then one for every assumed role.
+	for _, profile := range profiles {
+		keys := map[string]string{
+			"aws_session_token":     profile.Credentials.SessionToken,
+			"aws_access_key_id":     profile.Credentials.AccessKeyID,
+			"aws_secret_access_key": profile.Credentials.SecretAccessKey,
+		}
+		if profile.Region != "" {
+			keys["region"] = profile.Region
+		}
+		if profile.Credentials.CanExpire {
+			keys[expiresKey] = profile.Credentials.Expires.UTC().Format(time.RFC3339)
+			keys[sessionExpirationKey] = keys[expiresKey]
+		}
+		if err := addKeysToSection(profile.Name, keys); err != nil {
+			return err
+		}
+		if !w.Unmanaged {
+			inidata.Section(profile.Name).Comment = managedMarker
+		}
+	}
+
+	slog.Debug("Saving credentials file", "path", w.Path)
+	if err := saveAtomic(inidata, w.Path); err != nil {
+		return fmt.Errorf("failed to save file: %w", err)
+	}
+
+	return nil
+}
+
+// longLived reports whether section holds long-lived keys other than the source keys of set:
+// an access key ID without a session token next to it. A copy of the source keys loses
+// nothing when it is overwritten.
+func (set CredentialSet) longLived(section *ini.Section) bool {
+	id := section.Key("aws_access_key_id").String()
+	if id == "" || set.Source != nil && id == set.Source.Credentials.AccessKeyID {
+		return false
+	}
+	for _, key := range sessionTokenKeys {
+		if section.HasKey(key) {
+			return false
+		}
+	}
+	return true
+}
+
+// managedSection reports whether gredentures owns section: it carries managedMarker, or its
+// name matches one of patterns. Sections written by older releases have no marker and are
+// only recognised by name.
+func managedSection(section *ini.Section, patterns []string) bool {
+	for _, line := range strings.Split(section.Comment, "\n") {
+		if strings.TrimSpace(line) == managedMarker {
+			return true
+		}
+	}
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, section.Name()); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// TargetResult reports the outcome of writing a single credentials file.
+type TargetResult struct {
+	Path         string // Credentials file written.
+	Err          error  // Failure, nil on success.
+	RemovedToken bool   // A stale session token was removed from the source profile, see SharedCredentialsWriter.
+}
+
+// WriteCredentialsFiles writes ~/.aws/credentials and merges the session and role profiles
+// into every extra file, e.g. the Windows credentials file of a WSL user. Every target is
+// attempted even when an earlier one fails.
+func (conf *AwsConfig) WriteCredentialsFiles(extra []string) []TargetResult {
+	removedToken, err := conf.writeCredentialsFile()
+	results := []TargetResult{{Path: CredentialsPath(), Err: err, RemovedToken: removedToken}}
+	for _, path := range extra {
+		slog.Debug("Writing extra credentials file", "path", path)
+		err := conf.WriteCredentials(&SharedCredentialsWriter{Path: path, Merge: true})
+		results = append(results, TargetResult{Path: path, Err: err})
+	}
+	return results
+}
+
+// EnvWriter prints the session credentials as shell export statements for use with eval.
+type EnvWriter struct {
+	Out io.Writer
+}
+
+// WriteCredentials implements CredentialWriter.
+func (w *EnvWriter) WriteCredentials(set CredentialSet) error {
+	var buf bytes.Buffer
+	for _, v := range profileEnv(set.Session) {
+		fmt.Fprintf(&buf, "export %s=%s\n", v.name, shellQuote(v.value))
+	}
+	_, err := w.Out.Write(buf.Bytes())
+	return err
+}
+
+// profileEnv returns a profile's credentials as environment variables.
+func profileEnv(profile Profile) []envVar {
+	env := []envVar{
+		{"AWS_ACCESS_KEY_ID", profile.Credentials.AccessKeyID},
+		{"AWS_SECRET_ACCESS_KEY", profile.Credentials.SecretAccessKey},
+		{"AWS_SESSION_TOKEN", profile.Credentials.SessionToken},
+	}
+	if profile.Credentials.CanExpire {
+		env = append(env, envVar{"AWS_CREDENTIAL_EXPIRATION", profile.Credentials.Expires.Format(time.RFC3339)})
+	}
+	if profile.Region != "" {
+		env = append(env, envVar{"AWS_REGION", profile.Region}, envVar{"AWS_DEFAULT_REGION", profile.Region})
+	}
+	return env
+}
+
+// shellQuote single-quotes a value for POSIX shells.
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// processCredentials is the JSON document read by the AWS credential_process setting.
+type processCredentials struct {
+	Version         int    `json:"Version"`
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	SessionToken    string `json:"SessionToken,omitempty"`
+	Expiration      string `json:"Expiration,omitempty"`
+}
+
+// newProcessCredentials converts a profile into the credential_process format.
+func newProcessCredentials(profile Profile) processCredentials {
+	creds := processCredentials{
+		Version:         1,
+		AccessKeyID:     profile.Credentials.AccessKeyID,
+		SecretAccessKey: profile.Credentials.SecretAccessKey,
+		SessionToken:    profile.Credentials.SessionToken,
+	}
+	if profile.Credentials.CanExpire {
+		creds.Expiration = profile.Credentials.Expires.Format(time.RFC3339)
+	}
+	return creds
+}
+
+// JSONWriter prints the session and role credentials as a JSON object keyed by profile name.
+type JSONWriter struct {
+	Out io.Writer
+}
+
+// WriteCredentials implements CredentialWriter.
+func (w *JSONWriter) WriteCredentials(set CredentialSet) error {
+	profiles := make(map[string]processCredentials, len(set.Roles)+1)
+	for _, profile := range append([]Profile{set.Session}, set.Roles...) {
+		profiles[profile.Name] = newProcessCredentials(profile)
+	}
+	return writeJSON(w.Out, profiles)
+}
+
+// CredentialProcessWriter prints the session credentials in the format expected by the
+// credential_process setting of an AWS config profile.
+type CredentialProcessWriter struct {
+	Out io.Writer
+}
+
+// WriteCredentials implements CredentialWriter.
+func (w *CredentialProcessWriter) WriteCredentials(set CredentialSet) error {
+	return writeJSON(w.Out, newProcessCredentials(set.Session))
+}
+
+// writeJSON writes v as indented JSON followed by a newline.
+func writeJSON(out io.Writer, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal credentials: %w", err)
+	}
+	_, err = out.Write(append(data, '\n'))
+	return err
+}
+
+// SummaryWriter prints the session and role credentials for inspection without persisting
+// them. Secrets and session tokens are redacted unless ShowSecrets is set.
+type SummaryWriter struct {
+	Out         io.Writer
+	ShowSecrets bool // Print secrets and session tokens in full.
+}
+
+// WriteCredentials implements CredentialWriter.
+func (w *SummaryWriter) WriteCredentials(set CredentialSet) error {
+	var buf bytes.Buffer
+	for _, profile := range append([]Profile{set.Session}, set.Roles...) {
+		secret, token := redact(profile.Credentials.SecretAccessKey), redact(profile.Credentials.SessionToken)
+		if w.ShowSecrets {
+			secret, token = profile.Credentials.SecretAccessKey, profile.Credentials.SessionToken
+		}
+
+		fmt.Fprintf(&buf, "[%s]\n", profile.Name)
+		fmt.Fprintf(&buf, "aws_access_key_id     = %s\n", profile.Credentials.AccessKeyID)
+		fmt.Fprintf(&buf, "aws_secret_access_key = %s\n", secret)
+		fmt.Fprintf(&buf, "aws_session_token     = %s\n", token)
+		if profile.Credentials.CanExpire {
+			fmt.Fprintf(&buf, "expiration            = %s\n", profile.Credentials.Expires.Format(time.RFC3339))
+		}
+		buf.WriteByte('\n')
+	}
+	buf.WriteString("No files were modified.\n")
+
+	_, err := w.Out.Write(buf.Bytes())
+	return err
+}
+
+// redact hides all but the last four characters of a secret.
+func redact(secret string) string {
+	if len(secret) <= 8 {
+		return strings.Repeat("*", len(secret))
+	}
+	return strings.Repeat("*", 8) + secret[len(secret)-4:]
+}
+
+// KeychainWriter stores the session and role credentials in the OS keychain, one item per
+// profile holding the credential_process JSON, using the macOS security tool or the
+// freedesktop secret-tool. Secrets are passed on stdin so they never appear in argv.
+type KeychainWriter struct {
+	Service string                                                // Keychain service name.
+	Run     func(stdin string, command ...string) (string, error) // Runs a keychain command, replaced in tests.
+}
+
+// WriteCredentials implements CredentialWriter.
+func (w *KeychainWriter) WriteCredentials(set CredentialSet) error {
+	for _, profile := range append([]Profile{set.Session}, set.Roles...) {
+		data, err := json.Marshal(newProcessCredentials(profile))
+		if err != nil {
+			return fmt.Errorf("failed to marshal credentials: %w", err)
+		}
+
+		slog.Debug("Storing credentials in keychain", "service", w.Service, "profile", profile.Name)
+		var runErr error
+		switch runtime.GOOS {
+		case "darwin":
+			command, err := addGenericPassword(w.Service, profile.Name, "", string(data), "")
+			if err != nil {
+				return fmt.Errorf("profile %q cannot be stored in the keychain: %w", profile.Name, err)
+			}
+			_, runErr = w.Run(command, "security", "-i")
+		case "linux":
+			_, runErr = w.Run(string(data), "secret-tool", "store", "--label", w.Service+" "+profile.Name,
+				"service", w.Service, "profile", profile.Name)
+		default:
+			return fmt.Errorf("keychain output is not supported on %s", runtime.GOOS)
+		}
+		if runErr != nil {
+			return fmt.Errorf("failed to store %s in keychain: %w", profile.Name, runErr)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,486 @@
+package awsconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gredentures/pkg/appconfig"
+	"gredentures/pkg/sysuser"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ini.v1"
+)
+
+func writerTestConfig() *AwsConfig {
+	conf := exportTestConfig()
+	conf.defaultCreds = aws.Credentials{AccessKeyID: "mockAccessKeyID", SecretAccessKey: "mockSecretAccessKey"}
+	conf.roleCreds = map[string]*types.Credentials{
+		"prod-mfa": {
+			AccessKeyId:     aws.String("mockRoleAccessKey"),
+			SecretAccessKey: aws.String("mockRoleSecretKey"),
+			SessionToken:    aws.String("mockRoleSessionToken"),
+		},
+	}
+	return conf
+}
+
+func TestCredentialSet(t *testing.T) {
+	set, err := writerTestConfig().credentialSet()
+	assert.NoError(t, err)
+
+	assert.Equal(t, &Profile{Name: "default", Credentials: aws.Credentials{AccessKeyID: "mockAccessKeyID", SecretAccessKey: "mockSecretAccessKey"}}, set.Source)
+	assert.Equal(t, "default-mfa", set.Session.Name)
+	assert.True(t, set.Session.Credentials.CanExpire)
+	assert.Equal(t, time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC), set.Session.Credentials.Expires)
+	assert.Len(t, set.Roles, 1)
+	assert.Equal(t, "prod-mfa", set.Roles[0].Name)
+	assert.False(t, set.Roles[0].Credentials.CanExpire)
+
+	conf := writerTestConfig()
+	conf.externalSource = true
+	set, err = conf.credentialSet()
+	assert.NoError(t, err)
+	assert.Nil(t, set.Source)
+
+	SetCredentialsPath(filepath.Join(t.TempDir(), "credentials"))
+	defer SetCredentialsPath("")
+	set, err = writerTestConfig().credentialSet()
+	assert.NoError(t, err)
+	assert.Nil(t, set.Source, "the source keys stay in ~/.aws/credentials")
+
+	conf = writerTestConfig()
+	conf.currentProfile = "current"
+	set, err = conf.credentialSet()
+	assert.NoError(t, err)
+	if assert.NotNil(t, set.Current) {
+		assert.Equal(t, "current", set.Current.Name)
+		assert.Equal(t, set.Session.Credentials, set.Current.Credentials)
+	}
+	assert.Equal(t, "default-mfa", set.Session.Name)
+
+	conf.currentProfile = "default-mfa"
+	set, err = conf.credentialSet()
+	assert.NoError(t, err)
+	assert.Nil(t, set.Current, "an alias naming the session profile is the session profile")
+}
+
+func TestProfileRedactsSecrets(t *testing.T) {
+	set, err := writerTestConfig().credentialSet()
+	assert.NoError(t, err)
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	logger.Debug("Credentials", "session", set.Session, "source", set.Source)
+
+	for _, out := range []string{
+		fmt.Sprintf("%v %+v %#v %s %x", set.Session, set, set.Roles, set.Session, set.Session),
+		fmt.Errorf("failed to write %v", set.Session).Error(),
+		logs.String(),
+	} {
+		for _, secret := range []string{"mockSecretKey", "mockSessionToken", "mockRoleSecretKey", "mockSecretAccessKey"} {
+			assert.NotContains(t, out, secret)
+		}
+	}
+	assert.Contains(t, set.Session.String(), "AccessKeyID:mockAccessKey SecretAccessKey:<redacted>")
+	assert.Contains(t, logs.String(), "session.secret_access_key=<redacted>")
+}
+
+func TestWriteCredentialsDoesNotLogSecrets(t *testing.T) {
+	var logs bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	defer slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, nil)))
+
+	path := filepath.Join(t.TempDir(), "credentials")
+	assert.NoError(t, writerTestConfig().WriteCredentials(&SharedCredentialsWriter{Path: path}))
+	assert.Contains(t, logs.String(), "Creating key")
+	for _, secret := range []string{"mockSecretKey", "mockSessionToken", "mockRoleSecretKey", "mockSecretAccessKey"} {
+		assert.NotContains(t, logs.String(), secret)
+	}
+}
+
+func TestEnvWriter(t *testing.T) {
+	var out bytes.Buffer
+	assert.NoError(t, writerTestConfig().WriteCredentials(&EnvWriter{Out: &out}))
+	assert.Equal(t, `export AWS_ACCESS_KEY_ID='mockAccessKey'
+export AWS_SECRET_ACCESS_KEY='mockSecretKey'
+export AWS_SESSION_TOKEN='mockSessionToken'
+export AWS_CREDENTIAL_EXPIRATION='2030-01-02T03:04:05Z'
+`, out.String())
+}
+
+func TestShellQuote(t *testing.T) {
+	assert.Equal(t, `'it'\''s'`, shellQuote("it's"))
+}
+
+func TestJSONWriter(t *testing.T) {
+	var out bytes.Buffer
+	assert.NoError(t, writerTestConfig().WriteCredentials(&JSONWriter{Out: &out}))
+	assert.JSONEq(t, `{
+  "default-mfa": {
+    "Version": 1,
+    "AccessKeyId": "mockAccessKey",
+    "SecretAccessKey": "mockSecretKey",
+    "SessionToken": "mockSessionToken",
+    "Expiration": "2030-01-02T03:04:05Z"
+  },
+  "prod-mfa": {
+    "Version": 1,
+    "AccessKeyId": "mockRoleAccessKey",
+    "SecretAccessKey": "mockRoleSecretKey",
+    "SessionToken": "mockRoleSessionToken"
+  }
+}`, out.String())
+}
+
+func TestCredentialProcessWriter(t *testing.T) {
+	var out bytes.Buffer
+	assert.NoError(t, writerTestConfig().WriteCredentials(&CredentialProcessWriter{Out: &out}))
+	assert.JSONEq(t, `{
+  "Version": 1,
+  "AccessKeyId": "mockAccessKey",
+  "SecretAccessKey": "mockSecretKey",
+  "SessionToken": "mockSessionToken",
+  "Expiration": "2030-01-02T03:04:05Z"
+}`, out.String())
+}
+
+func TestKeychainWriter(t *testing.T) {
+	if runtime.GOOS != "darwin" && runtime.GOOS != "linux" {
+		t.Skip("keychain output is only supported on macOS and Linux")
+	}
+
+	type call struct {
+		stdin   string
+		command []string
+	}
+	var calls []call
+	writer := &KeychainWriter{Service: "gredentures", Run: func(stdin string, command ...string) (string, error) {
+		calls = append(calls, call{stdin, command})
+		return "", nil
+	}}
+	assert.NoError(t, writerTestConfig().WriteCredentials(writer))
+	assert.Len(t, calls, 2)
+
+	for _, c := range calls {
+		// Secrets must only ever be passed on stdin
+		for _, arg := range c.command {
+			assert.NotContains(t, arg, "mockSecretKey")
+			assert.NotContains(t, arg, "mockRoleSecretKey")
+		}
+	}
+	if runtime.GOOS == "linux" {
+		assert.Equal(t, []string{"secret-tool", "store", "--label", "gredentures default-mfa", "service", "gredentures", "profile", "default-mfa"}, calls[0].command)
+		var creds processCredentials
+		assert.NoError(t, json.Unmarshal([]byte(calls[0].stdin), &creds))
+		assert.Equal(t, "mockSecretKey", creds.SecretAccessKey)
+	}
+}
+
+func TestNewCredentialWriter(t *testing.T) {
+	var out bytes.Buffer
+	for _, output := range appconfig.Outputs {
+		writer, err := NewCredentialWriter(output, &out, "cluster")
+		assert.NoError(t, err, output)
+		assert.NotNil(t, writer, output)
+	}
+
+	writer, err := NewCredentialWriter("", &out, "")
+	assert.NoError(t, err)
+	assert.Equal(t, &SharedCredentialsWriter{Path: CredentialsPath()}, writer)
+
+	_, err = NewCredentialWriter("yaml", &out, "")
+	assert.ErrorContains(t, err, `unknown output "yaml"`)
+}
+
+func TestSummaryWriter(t *testing.T) {
+	t.Run("Redacts secrets", func(t *testing.T) {
+		var out bytes.Buffer
+		assert.NoError(t, writerTestConfig().WriteCredentials(&SummaryWriter{Out: &out}))
+		assert.Equal(t, `[default-mfa]
+aws_access_key_id     = mockAccessKey
+aws_secret_access_key = ********tKey
+aws_session_token     = ********oken
+expiration            = 2030-01-02T03:04:05Z
+
+[prod-mfa]
+aws_access_key_id     = mockRoleAccessKey
+aws_secret_access_key = ********tKey
+aws_session_token     = ********oken
+
+No files were modified.
+`, out.String())
+	})
+
+	t.Run("Shows secrets", func(t *testing.T) {
+		var out bytes.Buffer
+		assert.NoError(t, writerTestConfig().WriteCredentials(&SummaryWriter{Out: &out, ShowSecrets: true}))
+		assert.Contains(t, out.String(), "aws_secret_access_key = mockSecretKey\n")
+		assert.Contains(t, out.String(), "aws_session_token     = mockRoleSessionToken\n")
+	})
+}
+
+func TestRedact(t *testing.T) {
+	assert.Equal(t, "", redact(""))
+	assert.Equal(t, "******", redact("secret"))
+	assert.Equal(t, "********7890", redact("abcdefghij1234567890"))
+}
+
+func TestSharedCredentialsWriterMerge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials")
+	assert.NoError(t, os.WriteFile(path, []byte(`[windows-only]
+aws_access_key_id = keep-me
+
+[default-mfa]
+aws_access_key_id = stale
+aws_session_token = stale
+`), 0o600))
+
+	assert.NoError(t, writerTestConfig().WriteCredentials(&SharedCredentialsWriter{Path: path, Merge: true}))
+
+	cfg, err := ini.Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "keep-me", cfg.Section("windows-only").Key("aws_access_key_id").String())
+	assert.Equal(t, "mockAccessKey", cfg.Section("default-mfa").Key("aws_access_key_id").String())
+	assert.Equal(t, "mockRoleAccessKey", cfg.Section("prod-mfa").Key("aws_access_key_id").String())
+	assert.False(t, cfg.HasSection("default"), "source keys must not be copied")
+}
+
+func TestSharedCredentialsWriterCurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials")
+	assert.NoError(t, os.WriteFile(path, []byte(`[current]
+aws_access_key_id = previous-org
+aws_session_token = previous-org
+`), 0o600))
+
+	conf := writerTestConfig()
+	conf.currentProfile = "current"
+	assert.NoError(t, conf.WriteCredentials(&SharedCredentialsWriter{Path: path, Merge: true}))
+
+	cfg, err := ini.Load(path)
+	assert.NoError(t, err)
+	current := cfg.Section("current")
+	assert.Equal(t, "mockAccessKey", current.Key("aws_access_key_id").String())
+	assert.Equal(t, cfg.Section("default-mfa").Key("aws_session_token").String(), current.Key("aws_session_token").String())
+	assert.Equal(t, managedMarker, current.Comment)
+}
+
+func TestSharedCredentialsWriterStaleToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials")
+	assert.NoError(t, os.WriteFile(path, []byte(`[default]
+aws_access_key_id = old
+aws_secret_access_key = old
+aws_session_token = leftover
+aws_security_token = leftover
+region = eu-west-1
+`), 0o600))
+
+	writer := &SharedCredentialsWriter{Path: path}
+	assert.NoError(t, writerTestConfig().WriteCredentials(writer))
+	assert.True(t, writer.RemovedToken)
+	cfg, err := ini.Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"aws_access_key_id", "aws_secret_access_key", "region"}, cfg.Section("default").KeyStrings())
+
+	writer = &SharedCredentialsWriter{Path: path}
+	assert.NoError(t, writerTestConfig().WriteCredentials(writer))
+	assert.False(t, writer.RemovedToken, "nothing is left to remove")
+}
+
+func TestSharedCredentialsWriterManaged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials")
+	assert.NoError(t, os.WriteFile(path, []byte(`[default]
+aws_access_key_id = old
+aws_secret_access_key = old
+region = eu-central-1
+
+[personal]
+aws_access_key_id = keep-me
+
+# gredentures:managed
+[old-role-mfa]
+aws_access_key_id = stale
+
+[legacy-mfa]
+aws_access_key_id = stale
+`), 0o600))
+
+	conf := writerTestConfig()
+	conf.managed = []string{"legacy-*"}
+	assert.NoError(t, conf.WriteCredentials(&SharedCredentialsWriter{Path: path}))
+
+	cfg, err := ini.Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "keep-me", cfg.Section("personal").Key("aws_access_key_id").String())
+	assert.False(t, cfg.HasSection("old-role-mfa"), "a stale marked section is removed")
+	assert.False(t, cfg.HasSection("legacy-mfa"), "a section matching ManagedProfiles is removed")
+	assert.Equal(t, "mockAccessKeyID", cfg.Section("default").Key("aws_access_key_id").String())
+	assert.Equal(t, "eu-central-1", cfg.Section("default").Key("region").String(), "other keys of the source are kept")
+	assert.Equal(t, managedMarker, cfg.Section("default-mfa").Comment)
+	assert.Equal(t, managedMarker, cfg.Section("prod-mfa").Comment)
+	assert.Empty(t, cfg.Section("default").Comment)
+
+	assert.NoError(t, os.WriteFile(path, []byte("# gredentures:managed\n[old-role-mfa]\naws_access_key_id = stale\n"), 0o600))
+	assert.NoError(t, conf.WriteCredentials(&SharedCredentialsWriter{Path: path, Merge: true}))
+	cfg, err = ini.Load(path)
+	assert.NoError(t, err)
+	assert.True(t, cfg.HasSection("old-role-mfa"), "merging never removes sections")
+}
+
+func TestSharedCredentialsWriterForeignProfiles(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "config"), []byte("[profile prod-mfa]\nsso_session = work\n"), 0o600))
+	path := filepath.Join(dir, "credentials")
+	saml := `[saml-prod]
+aws_access_key_id = saml2aws
+x_principal_arn = arn:aws:sts::123456789012:assumed-role/Admin/me
+`
+	assert.NoError(t, os.WriteFile(path, []byte(saml), 0o600))
+
+	conf := writerTestConfig()
+	conf.managed = []string{"saml-*"}
+	conf.compatMode = true
+	err := conf.WriteCredentials(&SharedCredentialsWriter{Path: path})
+	assert.ErrorIs(t, err, ErrForeignProfile)
+	assert.ErrorContains(t, err, "profile prod-mfa is managed by aws sso")
+	data, _ := os.ReadFile(path)
+	assert.Equal(t, saml, string(data), "the file is left alone")
+
+	delete(conf.roleCreds, "prod-mfa")
+	assert.NoError(t, conf.WriteCredentials(&SharedCredentialsWriter{Path: path}))
+	cfg, err := ini.Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "saml2aws", cfg.Section("saml-prod").Key("aws_access_key_id").String(), "matching ManagedProfiles does not remove it")
+
+	conf.compatMode = false
+	assert.NoError(t, conf.WriteCredentials(&SharedCredentialsWriter{Path: path}))
+	cfg, err = ini.Load(path)
+	assert.NoError(t, err)
+	assert.False(t, cfg.HasSection("saml-prod"), "without CompatMode the patterns are followed")
+}
+
+func TestSharedCredentialsWriterOwnProfiles(t *testing.T) {
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	path := filepath.Join(t.TempDir(), "credentials")
+
+	conf := writerTestConfig()
+	conf.compatMode = true
+	assert.NoError(t, conf.WriteCredentials(&SharedCredentialsWriter{Path: path}))
+	assert.NoError(t, conf.WriteCredentials(&SharedCredentialsWriter{Path: path}), "the profiles of the first login are no foreign ones")
+
+	foreign, err := ForeignProfiles(path, AWSConfigPath())
+	assert.NoError(t, err)
+	assert.Empty(t, foreign)
+}
+
+func TestSharedCredentialsWriterProfileCollision(t *testing.T) {
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	path := filepath.Join(t.TempDir(), "credentials")
+	keys := `[prod-mfa]
+aws_access_key_id = AKIAPRODUSER
+aws_secret_access_key = prodSecret
+`
+	assert.NoError(t, os.WriteFile(path, []byte(keys), 0o600))
+
+	conf := writerTestConfig()
+	err := conf.WriteCredentials(&SharedCredentialsWriter{Path: path})
+	assert.ErrorIs(t, err, ErrProfileCollision)
+	assert.ErrorContains(t, err, "profile prod-mfa in "+path+" holds the access key AKIAPRODUSER")
+	data, _ := os.ReadFile(path)
+	assert.Equal(t, keys, string(data), "the file is left alone")
+
+	conf.force = true
+	assert.NoError(t, conf.WriteCredentials(&SharedCredentialsWriter{Path: path}))
+	cfg, err := ini.Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "mockRoleAccessKey", cfg.Section("prod-mfa").Key("aws_access_key_id").String())
+
+	t.Run("Overwrites copies of the source keys", func(t *testing.T) {
+		assert.NoError(t, os.WriteFile(path, []byte("[prod-mfa]\naws_access_key_id = mockAccessKeyID\n"), 0o600))
+		assert.NoError(t, writerTestConfig().WriteCredentials(&SharedCredentialsWriter{Path: path}))
+	})
+}
+
+func TestManagedSection(t *testing.T) {
+	cfg := ini.Empty()
+	marked, _ := cfg.NewSection("marked")
+	marked.Comment = "# written by hand\n" + managedMarker
+	other, _ := cfg.NewSection("other")
+	legacy, _ := cfg.NewSection("legacy-mfa")
+
+	assert.True(t, managedSection(marked, nil))
+	assert.False(t, managedSection(other, []string{"legacy-*"}))
+	assert.True(t, managedSection(legacy, []string{"other", "legacy-*"}))
+}
+
+func TestSharedCredentialsWriterForeignFile(t *testing.T) {
+	if os.Geteuid() != 0 || runtime.GOOS == "windows" {
+		t.Skip("creating a file of another user needs root")
+	}
+	t.Setenv("SUDO_USER", "")
+	path := filepath.Join(t.TempDir(), "credentials")
+	assert.NoError(t, os.WriteFile(path, []byte("[other]\n"), 0o600))
+	assert.NoError(t, os.Chown(path, 4242, 4242))
+
+	err := writerTestConfig().WriteCredentials(&SharedCredentialsWriter{Path: path})
+	assert.ErrorIs(t, err, sysuser.ErrForeignFile)
+	data, _ := os.ReadFile(path)
+	assert.Equal(t, "[other]\n", string(data), "the file is left alone")
+}
+
+func TestSharedCredentialsWriterRegion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials")
+	conf := writerTestConfig()
+	conf.region = "eu-west-1"
+
+	assert.NoError(t, conf.WriteCredentials(&SharedCredentialsWriter{Path: path}))
+
+	cfg, err := ini.Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "eu-west-1", cfg.Section("default-mfa").Key("region").String())
+	assert.False(t, cfg.Section("default").HasKey("region"))
+}
+
+func TestSharedCredentialsWriterExpiry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials")
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	writer := &SharedCredentialsWriter{Path: path}
+	assert.NoError(t, writer.WriteCredentials(CredentialSet{Session: Profile{
+		Name:        "default-mfa",
+		Credentials: aws.Credentials{AccessKeyID: "ASIAEXAMPLE", SecretAccessKey: "secret", SessionToken: "token", CanExpire: true, Expires: expires},
+	}}))
+
+	cfg, err := ini.Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "2030-01-02T03:04:05Z", cfg.Section("default-mfa").Key("x_security_token_expires").String())
+	assert.Equal(t, "2030-01-02T03:04:05Z", cfg.Section("default-mfa").Key("aws_session_expiration").String())
+}
+
+func TestWriteCredentialsFiles(t *testing.T) {
+	tempDir := t.TempDir()
+	t.Setenv("HOME", tempDir)
+
+	blocker := filepath.Join(tempDir, "not-a-dir")
+	assert.NoError(t, os.WriteFile(blocker, nil, 0o600))
+	wsl := filepath.Join(tempDir, "windows", ".aws", "credentials")
+	broken := filepath.Join(blocker, "credentials")
+
+	results := writerTestConfig().WriteCredentialsFiles([]string{broken, wsl})
+	assert.Len(t, results, 3)
+	assert.Equal(t, TargetResult{Path: CredentialsPath()}, results[0])
+	assert.Equal(t, broken, results[1].Path)
+	assert.Error(t, results[1].Err)
+	assert.Equal(t, TargetResult{Path: wsl}, results[2])
+
+	cfg, err := ini.Load(wsl)
+	assert.NoError(t, err)
+	assert.Equal(t, "mockAccessKey", cfg.Section("default-mfa").Key("aws_access_key_id").String())
+}
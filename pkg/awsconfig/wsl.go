@@ -0,0 +1,106 @@
+package awsconfig
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// osReleasePath holds the kernel release, which names Microsoft under WSL.
+var osReleasePath = "/proc/sys/kernel/osrelease"
+
+// windowsCommand runs a Windows interop command from WSL, replaced in tests.
+var windowsCommand = func(name string, args ...string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s failed: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// IsWSL reports whether gredentures is running under the Windows Subsystem for Linux.
+func IsWSL() bool {
+	release, err := os.ReadFile(osReleasePath)
+	return err == nil && strings.Contains(strings.ToLower(string(release)), "microsoft")
+}
+
+// WindowsCredentialsPath locates %USERPROFILE%\.aws\credentials of the Windows user and
+// returns it as a WSL path, e.g. /mnt/c/Users/me/.aws/credentials.
+func WindowsCredentialsPath() (string, error) {
+	if !IsWSL() {
+		return "", fmt.Errorf("WSL sync requires running under the Windows Subsystem for Linux")
+	}
+
+	profile, err := windowsCommand("cmd.exe", "/c", "echo %USERPROFILE%")
+	if err != nil {
+		return "", fmt.Errorf("failed to find the Windows user profile: %w", err)
+	}
+	if profile == "" || profile == "%USERPROFILE%" {
+		return "", fmt.Errorf("USERPROFILE is not set on the Windows side")
+	}
+
+	dir, err := windowsCommand("wslpath", "-u", profile)
+	if err != nil {
+		return "", fmt.Errorf("failed to convert %s to a WSL path: %w", profile, err)
+	}
+
+	slog.Debug("Found Windows credentials file", "windows_profile", profile, "path", dir+"/.aws/credentials")
+	return dir + "/.aws/credentials", nil
+}
+
+// SyncProfiles copies the named profiles from one credentials file to another, replacing
+// them in the destination and keeping all its other profiles. Profiles missing from the
+// source are skipped. It returns the names of the profiles that were copied.
+func SyncProfiles(from, to string, profiles []string) ([]string, error) {
+	source, err := ini.Load(from)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", from, err)
+	}
+	dest, err := ini.LooseLoad(to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", to, err)
+	}
+
+	var copied []string
+	for _, profile := range profiles {
+		if !source.HasSection(profile) {
+			slog.Debug("Profile not found, not syncing", "profile", profile, "path", from)
+			continue
+		}
+		dest.DeleteSection(profile)
+		if err := copySection(dest, source.Section(profile)); err != nil {
+			return nil, err
+		}
+		copied = append(copied, profile)
+	}
+
+	if len(copied) == 0 {
+		return nil, nil
+	}
+	if err := saveAtomic(dest, to); err != nil {
+		return nil, fmt.Errorf("failed to save %s: %w", to, err)
+	}
+	return copied, nil
+}
+
+// copySection adds a copy of section and all its keys to dest.
+func copySection(dest *ini.File, section *ini.Section) error {
+	copied, err := dest.NewSection(section.Name())
+	if err != nil {
+		return fmt.Errorf("failed to create section '%s': %w", section.Name(), err)
+	}
+	for _, key := range section.Keys() {
+		if _, err := copied.NewKey(key.Name(), key.Value()); err != nil {
+			return fmt.Errorf("failed to create key '%s' in section '%s': %w", key.Name(), section.Name(), err)
+		}
+	}
+	return nil
+}
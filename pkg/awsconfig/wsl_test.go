@@ -0,0 +1,80 @@
+package awsconfig
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ini.v1"
+)
+
+// fakeWSL points IsWSL at a fake kernel release and stubs the Windows interop commands.
+func fakeWSL(t *testing.T, release string, run func(name string, args ...string) (string, error)) {
+	path := filepath.Join(t.TempDir(), "osrelease")
+	assert.NoError(t, os.WriteFile(path, []byte(release), 0o600))
+
+	origPath, origCommand := osReleasePath, windowsCommand
+	osReleasePath, windowsCommand = path, run
+	t.Cleanup(func() { osReleasePath, windowsCommand = origPath, origCommand })
+}
+
+func TestWindowsCredentialsPath(t *testing.T) {
+	t.Run("Converts USERPROFILE", func(t *testing.T) {
+		fakeWSL(t, "5.15.153.1-microsoft-standard-WSL2\n", func(name string, args ...string) (string, error) {
+			switch name {
+			case "cmd.exe":
+				return `C:\Users\me`, nil
+			case "wslpath":
+				assert.Equal(t, []string{"-u", `C:\Users\me`}, args)
+				return "/mnt/c/Users/me", nil
+			}
+			return "", fmt.Errorf("unexpected command %s", name)
+		})
+
+		path, err := WindowsCredentialsPath()
+		assert.NoError(t, err)
+		assert.Equal(t, "/mnt/c/Users/me/.aws/credentials", path)
+	})
+
+	t.Run("Requires WSL", func(t *testing.T) {
+		fakeWSL(t, "6.8.0-generic\n", nil)
+		assert.False(t, IsWSL())
+		_, err := WindowsCredentialsPath()
+		assert.ErrorContains(t, err, "requires running under the Windows Subsystem for Linux")
+	})
+
+	t.Run("Unset USERPROFILE", func(t *testing.T) {
+		fakeWSL(t, "microsoft", func(name string, args ...string) (string, error) {
+			return "%USERPROFILE%", nil
+		})
+		_, err := WindowsCredentialsPath()
+		assert.ErrorContains(t, err, "USERPROFILE is not set")
+	})
+}
+
+func TestSyncProfiles(t *testing.T) {
+	dir := t.TempDir()
+	from := filepath.Join(dir, "wsl")
+	to := filepath.Join(dir, "windows", "credentials")
+	assert.NoError(t, os.WriteFile(from, []byte(strings.Join([]string{
+		"[default]", "aws_access_key_id = long-lived", "",
+		"[default-mfa]", "aws_access_key_id = session", "aws_session_token = token", "",
+	}, "\n")), 0o600))
+
+	copied, err := SyncProfiles(from, to, []string{"default-mfa", "prod-mfa"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"default-mfa"}, copied)
+
+	cfg, err := ini.Load(to)
+	assert.NoError(t, err)
+	assert.Equal(t, "session", cfg.Section("default-mfa").Key("aws_access_key_id").String())
+	assert.Equal(t, "token", cfg.Section("default-mfa").Key("aws_session_token").String())
+	assert.False(t, cfg.HasSection("default"), "unmanaged profiles must not be copied")
+
+	copied, err = SyncProfiles(from, to, []string{"missing"})
+	assert.NoError(t, err)
+	assert.Empty(t, copied)
+}
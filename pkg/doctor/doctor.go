@@ -0,0 +1,340 @@
+// Package doctor checks the prerequisites for logging in with gredentures: the config file,
+// the credentials file and its permissions, the long-lived keys, the network path to STS, the
+// local clock, AWS_* environment variables that would shadow the written profiles, profiles of
+// other credential helpers the managed ones overlap, and the sessions already written. Every problem found comes with a suggested fix.
+package doctor
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"gredentures/pkg/appconfig"
+	"gredentures/pkg/awsconfig"
+	"gredentures/pkg/remoteconfig"
+
+	"gopkg.in/ini.v1"
+)
+
+// probeTimeout bounds how long the STS reachability probe may take.
+const probeTimeout = 5 * time.Second
+
+// Status is the outcome of a single check.
+type Status string
+
+// Check outcomes, from fine to blocking a login.
+const (
+	StatusOK   Status = "ok"
+	StatusWarn Status = "warn"
+	StatusFail Status = "fail"
+)
+
+// Finding is the result of a single check.
+type Finding struct {
+	Check   string // Short name of what was checked.
+	Status  Status // Outcome of the check.
+	Message string // What was found.
+	Fix     string // How to resolve a problem, empty when nothing needs doing.
+}
+
+// Failed reports whether any of findings failed.
+func Failed(findings []Finding) bool {
+	return slices.ContainsFunc(findings, func(f Finding) bool { return f.Status == StatusFail })
+}
+
+// Credentials is the part of the AWS credential handling the checks depend on, implemented
+// by *awsconfig.AwsConfig and replaced in tests.
+type Credentials interface {
+	SourceConfigured() (bool, error)
+	SourceCredentialsPath() string
+	SourceIdentity() (string, error)
+	ProfileIdentity(profile string) (string, error)
+	STSEndpoint() string
+}
+
+// Doctor runs the checks for one gredentures configuration.
+type Doctor struct {
+	App             *appconfig.AppConfig // Options and config file to check.
+	Creds           Credentials          // Source credentials, with the source profile already selected.
+	CredentialsPath string               // Shared credentials file sessions are written to.
+	ConfigPath      string               // Shared config file of the AWS CLI, awsconfig.AWSConfigPath() when empty.
+	Environ         []string             // Environment to check, usually os.Environ().
+	Client          *http.Client         // Client for the STS probe, http.DefaultClient when nil.
+	Endpoint        string               // STS endpoint to probe, the one sessions are requested from when empty.
+	Now             func() time.Time     // Clock compared against AWS, time.Now when nil.
+}
+
+// Run performs every check and returns the findings in the order they were made. Checks
+// that depend on an earlier failed one, such as calling STS without reaching it, are skipped.
+func (d *Doctor) Run(ctx context.Context) []Finding {
+	findings := []Finding{d.checkConfig()}
+	findings = append(findings, d.checkCredentialsFiles()...)
+	keys := d.checkSourceKeys()
+	findings = append(findings, keys)
+	findings = append(findings, d.checkEnvironment()...)
+	findings = append(findings, d.checkForeignProfiles()...)
+
+	reachable, clock := d.checkSTS(ctx)
+	findings = append(findings, reachable)
+	if reachable.Status != StatusOK {
+		return findings
+	}
+	findings = append(findings, clock)
+	if keys.Status == StatusOK {
+		findings = append(findings, d.checkSourceIdentity())
+	}
+	return append(findings, d.checkSessions()...)
+}
+
+// checkConfig loads the config file and its base configs, validating them against the schema,
+// and confirms an MFA device is configured.
+func (d *Doctor) checkConfig() Finding {
+	path, _ := d.App.ConfigPath()
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) && !remoteconfig.IsRemote(path) {
+		return Finding{Check: "config", Status: StatusWarn,
+			Message: fmt.Sprintf("no config file at %s", path),
+			Fix:     "Run gredentures device enroll, or gredentures with --org and --device, to create it."}
+	}
+	if err := d.App.LoadGredenturesConfig(); err != nil {
+		return Finding{Check: "config", Status: StatusFail, Message: err.Error(),
+			Fix: "Fix the reported keys, gredentures config explain shows the effective options."}
+	}
+	switch {
+	case d.App.Device == "":
+		return Finding{Check: "config", Status: StatusWarn, Message: fmt.Sprintf("%s sets no MFA Device", path),
+			Fix: "Run gredentures device enroll, or set Device to the ARN of your MFA device."}
+	case appconfig.IsSecurityKey(d.App.Device):
+		return Finding{Check: "config", Status: StatusFail, Message: appconfig.ValidateDevice(d.App.Device).Error(),
+			Fix: "Run gredentures device enroll to add a virtual MFA device next to the security key, and use that one."}
+	case appconfig.ValidateDevice(d.App.Device) != nil:
+		return Finding{Check: "config", Status: StatusFail, Message: appconfig.ValidateDevice(d.App.Device).Error(),
+			Fix: "Use the MFA device ARN shown in the IAM console, e.g. arn:aws:iam::123456789012:mfa/my-device."}
+	}
+	return Finding{Check: "config", Status: StatusOK, Message: fmt.Sprintf("%s is valid", path)}
+}
+
+// checkCredentialsFiles confirms the credentials files holding long-lived keys and sessions
+// are readable by their owner only.
+func (d *Doctor) checkCredentialsFiles() []Finding {
+	paths := []string{d.CredentialsPath}
+	if source := d.Creds.SourceCredentialsPath(); source != d.CredentialsPath {
+		paths = append(paths, source)
+	}
+
+	var findings []Finding
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			findings = append(findings, Finding{Check: "credentials file", Status: StatusOK,
+				Message: fmt.Sprintf("%s does not exist yet and will be created with mode 0600", path)})
+		case err != nil:
+			findings = append(findings, Finding{Check: "credentials file", Status: StatusFail, Message: err.Error(),
+				Fix: fmt.Sprintf("Make sure %s and its directory belong to you.", path)})
+		case info.Mode().Perm()&0o077 != 0:
+			findings = append(findings, Finding{Check: "credentials file", Status: StatusWarn,
+				Message: fmt.Sprintf("%s has mode %04o, other users can read it", path, info.Mode().Perm()),
+				Fix:     fmt.Sprintf("Run chmod 600 %s.", path)})
+		default:
+			findings = append(findings, Finding{Check: "credentials file", Status: StatusOK,
+				Message: fmt.Sprintf("%s is readable by you only", path)})
+		}
+	}
+	return findings
+}
+
+// checkSourceKeys confirms long-lived keys are available to request sessions with.
+func (d *Doctor) checkSourceKeys() Finding {
+	configured, err := d.Creds.SourceConfigured()
+	switch {
+	case err != nil:
+		return Finding{Check: "long-lived keys", Status: StatusFail, Message: err.Error(),
+			Fix: "Fix the syntax of the credentials file."}
+	case !configured:
+		return Finding{Check: "long-lived keys", Status: StatusFail,
+			Message: fmt.Sprintf("no aws_access_key_id and aws_secret_access_key for the source profile in %s", d.Creds.SourceCredentialsPath()),
+			Fix:     "Run gredentures in a terminal to enter an access key, or configure OnePassword."}
+	}
+	return Finding{Check: "long-lived keys", Status: StatusOK, Message: "found for the source profile"}
+}
+
+// checkEnvironment looks for AWS_* variables that make the AWS CLI and SDKs ignore the
+// profiles gredentures writes.
+func (d *Doctor) checkEnvironment() []Finding {
+	env := map[string]string{}
+	for _, entry := range d.Environ {
+		if key, value, ok := strings.Cut(entry, "="); ok && value != "" {
+			env[key] = value
+		}
+	}
+
+	var findings []Finding
+	if static := awsconfig.ConflictingEnv(d.Environ); len(static) > 0 {
+		findings = append(findings, Finding{Check: "environment", Status: StatusWarn,
+			Message: fmt.Sprintf("%s take precedence over AWS_PROFILE and the credentials file", strings.Join(static, ", ")),
+			Fix:     `Run eval "$(gredentures env --unset)", or use gredentures exec to run commands with a session.`})
+	}
+	if file := env["AWS_SHARED_CREDENTIALS_FILE"]; file != "" && file != d.CredentialsPath && !slices.Contains(d.App.CredentialsFiles, file) {
+		findings = append(findings, Finding{Check: "environment", Status: StatusWarn,
+			Message: fmt.Sprintf("AWS_SHARED_CREDENTIALS_FILE points AWS tools at %s, but sessions are written to %s", file, d.CredentialsPath),
+			Fix:     "Unset AWS_SHARED_CREDENTIALS_FILE, or add the file to CredentialsFiles in the config."})
+	}
+	sourceProfile, _ := d.App.Source()
+	if sourceProfile == "" {
+		sourceProfile = "default"
+	}
+	if profile := env["AWS_PROFILE"]; profile != "" && profile == sourceProfile {
+		findings = append(findings, Finding{Check: "environment", Status: StatusWarn,
+			Message: fmt.Sprintf("AWS_PROFILE selects the source profile %s, so AWS tools use the long-lived keys without MFA", profile),
+			Fix:     fmt.Sprintf("Run export AWS_PROFILE=%s.", d.App.Profile)})
+	}
+
+	if len(findings) == 0 {
+		findings = append(findings, Finding{Check: "environment", Status: StatusOK, Message: "no conflicting AWS_* variables"})
+	}
+	return findings
+}
+
+// checkForeignProfiles looks for profiles of other credential helpers, such as saml2aws or
+// aws sso, that gredentures would overwrite or clean up as one of its managed profiles.
+func (d *Doctor) checkForeignProfiles() []Finding {
+	configPath := cmp.Or(d.ConfigPath, awsconfig.AWSConfigPath())
+	foreign, err := awsconfig.ForeignProfiles(d.CredentialsPath, configPath)
+	if err != nil {
+		return []Finding{{Check: "credential helpers", Status: StatusWarn, Message: err.Error(),
+			Fix: "Fix the syntax of the credentials and config files."}}
+	}
+
+	var findings []Finding
+	for _, profile := range foreign {
+		if !d.managed(profile.Name) {
+			continue
+		}
+		fix := fmt.Sprintf("Give the gredentures profile another name, or set CompatMode to leave %s alone.", profile.Name)
+		if d.App.CompatMode {
+			fix = fmt.Sprintf("Give the gredentures profile another name, CompatMode refuses to write %s.", profile.Name)
+		}
+		findings = append(findings, Finding{Check: "credential helpers", Status: StatusWarn,
+			Message: fmt.Sprintf("profile %s is managed by %s in %s and by gredentures", profile.Name, profile.Tool, profile.File),
+			Fix:     fix})
+	}
+	switch {
+	case len(foreign) == 0:
+		findings = append(findings, Finding{Check: "credential helpers", Status: StatusOK,
+			Message: "no profiles of other credential helpers"})
+	case len(findings) == 0:
+		findings = append(findings, Finding{Check: "credential helpers", Status: StatusOK,
+			Message: fmt.Sprintf("%d profiles of other credential helpers, none managed by gredentures", len(foreign))})
+	}
+	return findings
+}
+
+// managed reports whether gredentures writes or cleans up profile: it is one of the managed
+// profiles, or matches one of the ManagedProfiles patterns.
+func (d *Doctor) managed(profile string) bool {
+	if slices.Contains(d.App.ManagedProfiles(), profile) {
+		return true
+	}
+	return slices.ContainsFunc(d.App.ManagedPatterns, func(pattern string) bool {
+		matched, _ := path.Match(pattern, profile)
+		return matched
+	})
+}
+
+// checkSTS probes the STS endpoint, returning a finding for its reachability and one for the
+// local clock compared with the Date of the response.
+func (d *Doctor) checkSTS(ctx context.Context) (reachable, clock Finding) {
+	endpoint, client, now := d.Endpoint, d.Client, d.Now
+	if endpoint == "" {
+		endpoint = d.Creds.STSEndpoint()
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if now == nil {
+		now = time.Now
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return Finding{Check: "sts", Status: StatusFail, Message: err.Error()}, Finding{}
+	}
+	start := now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return Finding{Check: "sts", Status: StatusFail, Message: fmt.Sprintf("cannot reach %s: %v", endpoint, err),
+			Fix: "Check your network, VPN and HTTPS_PROXY settings."}, Finding{}
+	}
+	resp.Body.Close()
+	end := now()
+	reachable = Finding{Check: "sts", Status: StatusOK,
+		Message: fmt.Sprintf("%s answered in %s", endpoint, end.Sub(start).Round(time.Millisecond))}
+
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return reachable, Finding{Check: "clock", Status: StatusWarn, Message: "STS sent no Date header to compare the clock with"}
+	}
+	// The Date header has second precision and was set somewhere between start and end
+	skew := start.Add(end.Sub(start) / 2).Sub(date)
+	if skew.Abs() > awsconfig.MaxClockSkew {
+		return reachable, Finding{Check: "clock", Status: StatusFail,
+			Message: fmt.Sprintf("the local clock is %s off from AWS, MFA codes will be rejected", skew.Abs().Round(time.Second)),
+			Fix:     "Enable time sync (e.g. timedatectl set-ntp true) and try again."}
+	}
+	return reachable, Finding{Check: "clock", Status: StatusOK,
+		Message: fmt.Sprintf("within %s of AWS", skew.Abs().Round(time.Second))}
+}
+
+// checkSourceIdentity confirms STS accepts the long-lived keys.
+func (d *Doctor) checkSourceIdentity() Finding {
+	arn, err := d.Creds.SourceIdentity()
+	if err != nil {
+		return Finding{Check: "long-lived keys", Status: StatusFail, Message: fmt.Sprintf("STS rejected the keys: %v", err),
+			Fix: "Check the access key is active in the IAM console, or create a new one."}
+	}
+	return Finding{Check: "long-lived keys", Status: StatusOK, Message: fmt.Sprintf("accepted by STS for %s", arn)}
+}
+
+// checkSessions asks STS about every managed profile in the credentials file, reporting the
+// expired ones.
+func (d *Doctor) checkSessions() []Finding {
+	inidata, err := ini.Load(d.CredentialsPath)
+	if err != nil {
+		return nil // Reported by checkCredentialsFiles
+	}
+
+	var findings []Finding
+	for _, profile := range d.App.ManagedProfiles() {
+		if !inidata.HasSection(profile) {
+			continue
+		}
+		_, err := d.Creds.ProfileIdentity(profile)
+		switch {
+		case errors.Is(err, awsconfig.ErrExpiredToken):
+			findings = append(findings, Finding{Check: "session", Status: StatusWarn,
+				Message: fmt.Sprintf("the session in profile %s has expired", profile),
+				Fix:     "Run gredentures to log in again."})
+		case err != nil:
+			findings = append(findings, Finding{Check: "session", Status: StatusWarn,
+				Message: fmt.Sprintf("the session in profile %s is not usable: %v", profile, err),
+				Fix:     "Run gredentures to log in again."})
+		default:
+			findings = append(findings, Finding{Check: "session", Status: StatusOK,
+				Message: fmt.Sprintf("profile %s holds a valid session", profile)})
+		}
+	}
+	if len(findings) == 0 {
+		findings = append(findings, Finding{Check: "session", Status: StatusOK,
+			Message: fmt.Sprintf("no sessions in %s yet", d.CredentialsPath)})
+	}
+	return findings
+}
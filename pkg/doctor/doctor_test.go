@@ -0,0 +1,191 @@
+package doctor
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gredentures/pkg/appconfig"
+	"gredentures/pkg/awsconfig"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeCredentials implements Credentials without calling AWS.
+type fakeCredentials struct {
+	configured bool
+	sourcePath string
+	sourceErr  error
+	profiles   map[string]error // ProfileIdentity result by profile
+	endpoint   string
+}
+
+func (f *fakeCredentials) SourceConfigured() (bool, error) { return f.configured, nil }
+func (f *fakeCredentials) SourceCredentialsPath() string   { return f.sourcePath }
+func (f *fakeCredentials) SourceIdentity() (string, error) {
+	return "arn:aws:iam::123456789012:user/me", f.sourceErr
+}
+func (f *fakeCredentials) ProfileIdentity(profile string) (string, error) {
+	return "arn:aws:sts::123456789012:assumed-role/me", f.profiles[profile]
+}
+func (f *fakeCredentials) STSEndpoint() string { return f.endpoint }
+
+// newDoctor returns a Doctor for a valid config and credentials file in a temporary
+// directory, probing an STS stand-in whose clock is offset by skew.
+func newDoctor(t *testing.T, skew time.Duration) *Doctor {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.yml")
+	assert.NoError(t, os.WriteFile(configPath, []byte("gredentures:\n  Org: work\n  Device: arn:aws:iam::123456789012:mfa/me\n"), 0o644))
+	credentialsPath := filepath.Join(dir, "credentials")
+	assert.NoError(t, os.WriteFile(credentialsPath, []byte("[default]\naws_access_key_id = AKIA\naws_secret_access_key = secret\n\n[default-mfa]\naws_access_key_id = ASIA\n"), 0o600))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(-skew).UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusFound)
+	}))
+	t.Cleanup(server.Close)
+
+	return &Doctor{
+		App:             &appconfig.AppConfig{Config: configPath, Profile: "default-mfa"},
+		Creds:           &fakeCredentials{configured: true, sourcePath: credentialsPath},
+		CredentialsPath: credentialsPath,
+		ConfigPath:      filepath.Join(dir, "aws-config"),
+		Client:          server.Client(),
+		Endpoint:        server.URL,
+	}
+}
+
+// find returns the findings of check.
+func find(findings []Finding, check string) []Finding {
+	var matched []Finding
+	for _, f := range findings {
+		if f.Check == check {
+			matched = append(matched, f)
+		}
+	}
+	return matched
+}
+
+func TestRun(t *testing.T) {
+	t.Run("Everything fine", func(t *testing.T) {
+		findings := newDoctor(t, 0).Run(context.Background())
+		for _, f := range findings {
+			assert.Equal(t, StatusOK, f.Status, "%s: %s", f.Check, f.Message)
+		}
+		assert.False(t, Failed(findings))
+		assert.Contains(t, find(findings, "session")[0].Message, "profile default-mfa holds a valid session")
+	})
+
+	t.Run("Clock skew", func(t *testing.T) {
+		clock := find(newDoctor(t, 2*time.Minute).Run(context.Background()), "clock")
+		assert.Equal(t, StatusFail, clock[0].Status)
+		assert.Contains(t, clock[0].Message, "the local clock is 2m") // The Date header drops the fraction of a second
+		assert.Contains(t, clock[0].Fix, "timedatectl")
+	})
+
+	t.Run("Probes the endpoint sessions are requested from", func(t *testing.T) {
+		d := newDoctor(t, 0)
+		d.Creds.(*fakeCredentials).endpoint, d.Endpoint = d.Endpoint, ""
+		sts := find(d.Run(context.Background()), "sts")
+		assert.Equal(t, StatusOK, sts[0].Status)
+		assert.Contains(t, sts[0].Message, d.Creds.STSEndpoint())
+	})
+
+	t.Run("STS unreachable skips the AWS checks", func(t *testing.T) {
+		d := newDoctor(t, 0)
+		d.Endpoint = "http://127.0.0.1:1"
+		findings := d.Run(context.Background())
+		assert.True(t, Failed(findings))
+		assert.Equal(t, StatusFail, find(findings, "sts")[0].Status)
+		assert.Empty(t, find(findings, "clock"))
+		assert.Empty(t, find(findings, "session"))
+	})
+
+	t.Run("Rejected keys and expired session", func(t *testing.T) {
+		d := newDoctor(t, 0)
+		d.Creds = &fakeCredentials{configured: true, sourcePath: d.CredentialsPath,
+			sourceErr: fmt.Errorf("InvalidClientTokenId"),
+			profiles:  map[string]error{"default-mfa": fmt.Errorf("%w: token expired", awsconfig.ErrExpiredToken)}}
+		findings := d.Run(context.Background())
+
+		keys := find(findings, "long-lived keys")
+		assert.Equal(t, StatusFail, keys[1].Status)
+		assert.Contains(t, keys[1].Message, "InvalidClientTokenId")
+		session := find(findings, "session")
+		assert.Equal(t, StatusWarn, session[0].Status)
+		assert.Contains(t, session[0].Message, "has expired")
+	})
+}
+
+func TestCheckConfig(t *testing.T) {
+	d := newDoctor(t, 0)
+	assert.NoError(t, os.WriteFile(d.App.Config, []byte("gredentures:\n  Devcie: arn:aws:iam::123456789012:mfa/me\n"), 0o644))
+	finding := d.checkConfig()
+	assert.Equal(t, StatusFail, finding.Status)
+	assert.Contains(t, finding.Message, `did you mean "Device"?`)
+
+	d.App = &appconfig.AppConfig{Config: filepath.Join(t.TempDir(), "missing.yml")}
+	assert.Equal(t, StatusWarn, d.checkConfig().Status)
+
+	d = newDoctor(t, 0)
+	assert.NoError(t, os.WriteFile(d.App.Config, []byte("gredentures:\n  Device: arn:aws:iam::123456789012:u2f/user/me/fidosecuritykey-ABCDEFGHIJ\n"), 0o644))
+	finding = d.checkConfig()
+	assert.Equal(t, StatusFail, finding.Status)
+	assert.Contains(t, finding.Message, "is a FIDO security key")
+	assert.Contains(t, finding.Fix, "gredentures device enroll")
+}
+
+func TestCheckCredentialsFiles(t *testing.T) {
+	d := newDoctor(t, 0)
+	assert.NoError(t, os.Chmod(d.CredentialsPath, 0o644))
+	findings := d.checkCredentialsFiles()
+	assert.Len(t, findings, 1)
+	assert.Equal(t, StatusWarn, findings[0].Status)
+	assert.Equal(t, "Run chmod 600 "+d.CredentialsPath+".", findings[0].Fix)
+
+	d.Creds = &fakeCredentials{}
+	assert.Equal(t, StatusFail, d.checkSourceKeys().Status)
+}
+
+func TestCheckEnvironment(t *testing.T) {
+	d := newDoctor(t, 0)
+	d.Environ = []string{"AWS_ACCESS_KEY_ID=AKIA", "AWS_SECRET_ACCESS_KEY=secret", "AWS_PROFILE=default",
+		"AWS_SHARED_CREDENTIALS_FILE=/elsewhere/credentials", "AWS_SESSION_TOKEN="}
+	findings := d.checkEnvironment()
+	assert.Len(t, findings, 3)
+	assert.Contains(t, findings[0].Message, "AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY take precedence")
+	assert.Contains(t, findings[0].Fix, "gredentures env --unset")
+	assert.Contains(t, findings[1].Message, "/elsewhere/credentials")
+	assert.Equal(t, "Run export AWS_PROFILE=default-mfa.", findings[2].Fix)
+
+	d.Environ = []string{"AWS_PROFILE=default-mfa", "AWS_REGION=us-east-1"}
+	assert.Equal(t, []Finding{{Check: "environment", Status: StatusOK, Message: "no conflicting AWS_* variables"}}, d.checkEnvironment())
+}
+
+func TestCheckForeignProfiles(t *testing.T) {
+	d := newDoctor(t, 0)
+	assert.NoError(t, os.WriteFile(d.ConfigPath, []byte("[profile default-mfa]\nsso_session = work\n\n[profile sandbox]\nsso_session = work\n"), 0o600))
+	d.App.ManagedPatterns = []string{"saml-*"}
+	f, err := os.OpenFile(d.CredentialsPath, os.O_APPEND|os.O_WRONLY, 0)
+	assert.NoError(t, err)
+	_, err = f.WriteString("\n[saml-prod]\nx_principal_arn = arn:aws:sts::123456789012:assumed-role/Admin/me\n")
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	findings := d.checkForeignProfiles()
+	assert.Len(t, findings, 2)
+	assert.Equal(t, StatusWarn, findings[0].Status)
+	assert.Equal(t, "profile default-mfa is managed by aws sso in "+d.ConfigPath+" and by gredentures", findings[0].Message)
+	assert.Contains(t, findings[0].Fix, "set CompatMode")
+	assert.Contains(t, findings[1].Message, "profile saml-prod is managed by saml2aws")
+
+	d.App.ManagedPatterns = nil
+	d.App.Profile = "work-mfa"
+	assert.Equal(t, []Finding{{Check: "credential helpers", Status: StatusOK,
+		Message: "3 profiles of other credential helpers, none managed by gredentures"}}, d.checkForeignProfiles())
+}
@@ -0,0 +1,151 @@
+// Package events follows the expiry of the managed profiles and broadcasts the changes, so a
+// tray applet can subscribe to them over the local API instead of polling the credentials file.
+//
+// Every event is a single JSON object, for example:
+//
+//	{"type":"expiring","profile":"default-mfa","expires":"2025-01-02T15:04:05Z","time":"2025-01-02T14:50:05Z"}
+//
+// A profile moves from issued to expiring to expired, and back to issued once new credentials
+// are written to it.
+package events
+
+import (
+	"cmp"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Event types, in the order a profile goes through them.
+const (
+	Issued   = "issued"   // New credentials were written to the profile.
+	Expiring = "expiring" // The credentials expire within the window of the Tracker.
+	Expired  = "expired"  // The credentials expired, or the profile was removed.
+)
+
+// defaultWindow is how long before the credentials expire Expiring is sent when no window is
+// given, the default RefreshMargin of the config file.
+const defaultWindow = 15 * time.Minute
+
+// subscriberBuffer is how many events a subscriber may fall behind before events are dropped.
+const subscriberBuffer = 64
+
+// Event is a change in the credentials of a profile.
+type Event struct {
+	Type    string    `json:"type"`             // Issued, Expiring or Expired.
+	Profile string    `json:"profile"`          // Name of the profile.
+	Expires time.Time `json:"expires,omitzero"` // When the credentials expire, zero once removed.
+	Time    time.Time `json:"time"`             // When the change was noticed.
+}
+
+// Tracker turns snapshots of the expiry of every profile into events.
+type Tracker struct {
+	Window  time.Duration            // How long before expiry Expiring is sent, defaultWindow when zero.
+	Windows map[string]time.Duration // Window of single profiles, overriding Window.
+
+	last map[string]Event // Latest event of each profile.
+}
+
+// Update compares expiries, when the credentials of each profile expire at now, to the previous
+// snapshot and returns the events of the profiles that changed. Profiles missing from expiries
+// are treated as removed.
+func (t *Tracker) Update(now time.Time, expiries map[string]time.Time) []Event {
+	if t.last == nil {
+		t.last = map[string]Event{}
+	}
+	window := t.Window
+	if window == 0 {
+		window = defaultWindow
+	}
+
+	var changes []Event
+	emit := func(kind, profile string, expires time.Time) {
+		event := Event{Type: kind, Profile: profile, Expires: expires, Time: now}
+		t.last[profile] = event
+		changes = append(changes, event)
+	}
+	for _, profile := range slices.Sorted(maps.Keys(expiries)) {
+		expires := expiries[profile]
+		state := Issued
+		if !expires.After(now) {
+			state = Expired
+		} else if expires.Sub(now) <= cmp.Or(t.Windows[profile], window) {
+			state = Expiring
+		}
+
+		prev, seen := t.last[profile]
+		renewed := seen && !prev.Expires.Equal(expires)
+		if renewed && state != Issued {
+			emit(Issued, profile, expires) // New credentials that expire soon are still news
+		}
+		if !seen || renewed || prev.Type != state {
+			emit(state, profile, expires)
+		}
+	}
+	for _, profile := range slices.Sorted(maps.Keys(t.last)) {
+		if _, ok := expiries[profile]; !ok {
+			if t.last[profile].Type != Expired {
+				emit(Expired, profile, time.Time{})
+			}
+			delete(t.last, profile)
+		}
+	}
+	return changes
+}
+
+// Latest returns the latest event of every profile still tracked, sorted by profile.
+func (t *Tracker) Latest() []Event {
+	latest := []Event{}
+	for _, profile := range slices.Sorted(maps.Keys(t.last)) {
+		latest = append(latest, t.last[profile])
+	}
+	return latest
+}
+
+// Stream tracks the profiles with a Tracker and sends the events to every subscriber.
+type Stream struct {
+	Window  time.Duration            // How long before expiry Expiring is sent, defaultWindow when zero.
+	Windows map[string]time.Duration // Window of single profiles, overriding Window.
+
+	mu          sync.Mutex // Guards tracker and subscribers.
+	tracker     Tracker
+	subscribers map[chan Event]struct{}
+}
+
+// Update updates the Tracker and sends the events to the subscribers. A subscriber that has
+// fallen too far behind misses them.
+func (s *Stream) Update(now time.Time, expiries map[string]time.Time) []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tracker.Window, s.tracker.Windows = s.Window, s.Windows
+	changes := s.tracker.Update(now, expiries)
+	for _, event := range changes {
+		for subscriber := range s.subscribers {
+			select {
+			case subscriber <- event:
+			default:
+				slog.Warn("Dropped event for a slow subscriber", "type", event.Type, "profile", event.Profile)
+			}
+		}
+	}
+	return changes
+}
+
+// Subscribe returns the latest event of every profile, so a subscriber starts from the current
+// state, and the channel of the events that follow. cancel ends the subscription.
+func (s *Stream) Subscribe() (latest []Event, events <-chan Event, cancel func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subscribers == nil {
+		s.subscribers = map[chan Event]struct{}{}
+	}
+	subscriber := make(chan Event, subscriberBuffer)
+	s.subscribers[subscriber] = struct{}{}
+	return s.tracker.Latest(), subscriber, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.subscribers, subscriber)
+	}
+}
@@ -0,0 +1,105 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrackerUpdate(t *testing.T) {
+	now := time.Date(2025, 1, 2, 12, 0, 0, 0, time.UTC)
+	expires := now.Add(time.Hour)
+	tracker := &Tracker{}
+
+	t.Run("First snapshot reports every profile", func(t *testing.T) {
+		assert.Equal(t, []Event{
+			{Type: Issued, Profile: "default-mfa", Expires: expires, Time: now},
+			{Type: Expired, Profile: "old-mfa", Expires: now.Add(-time.Minute), Time: now},
+		}, tracker.Update(now, map[string]time.Time{"default-mfa": expires, "old-mfa": now.Add(-time.Minute)}))
+	})
+
+	t.Run("Unchanged profiles report nothing", func(t *testing.T) {
+		later := now.Add(10 * time.Minute)
+		assert.Empty(t, tracker.Update(later, map[string]time.Time{"default-mfa": expires, "old-mfa": now.Add(-time.Minute)}))
+	})
+
+	t.Run("Expiring and expired", func(t *testing.T) {
+		soon := expires.Add(-defaultWindow)
+		assert.Equal(t, []Event{{Type: Expiring, Profile: "default-mfa", Expires: expires, Time: soon}},
+			tracker.Update(soon, map[string]time.Time{"default-mfa": expires, "old-mfa": now.Add(-time.Minute)}))
+		assert.Equal(t, []Event{{Type: Expired, Profile: "default-mfa", Expires: expires, Time: expires}},
+			tracker.Update(expires, map[string]time.Time{"default-mfa": expires, "old-mfa": now.Add(-time.Minute)}))
+	})
+
+	t.Run("New credentials are issued", func(t *testing.T) {
+		at := expires.Add(time.Minute)
+		renewed := at.Add(time.Hour)
+		assert.Equal(t, []Event{{Type: Issued, Profile: "default-mfa", Expires: renewed, Time: at}},
+			tracker.Update(at, map[string]time.Time{"default-mfa": renewed, "old-mfa": now.Add(-time.Minute)}))
+
+		short := at.Add(5 * time.Minute)
+		assert.Equal(t, []Event{
+			{Type: Issued, Profile: "old-mfa", Expires: short, Time: at},
+			{Type: Expiring, Profile: "old-mfa", Expires: short, Time: at},
+		}, tracker.Update(at, map[string]time.Time{"default-mfa": renewed, "old-mfa": short}))
+	})
+
+	t.Run("Removed profiles expire", func(t *testing.T) {
+		at := expires.Add(2 * time.Minute)
+		assert.Equal(t, []Event{{Type: Expired, Profile: "old-mfa", Time: at}},
+			tracker.Update(at, map[string]time.Time{"default-mfa": expires.Add(time.Hour + time.Minute)}))
+		assert.Equal(t, []string{"default-mfa"}, profiles(tracker.Latest()))
+	})
+}
+
+func TestTrackerWindow(t *testing.T) {
+	now := time.Now()
+	tracker := &Tracker{Window: 2 * time.Hour}
+	assert.Equal(t, Expiring, tracker.Update(now, map[string]time.Time{"default-mfa": now.Add(time.Hour)})[0].Type)
+
+	t.Run("Per profile", func(t *testing.T) {
+		tracker := &Tracker{Windows: map[string]time.Duration{"prod-mfa": 5 * time.Minute}}
+		changes := tracker.Update(now, map[string]time.Time{"default-mfa": now.Add(10 * time.Minute), "prod-mfa": now.Add(10 * time.Minute)})
+		assert.Equal(t, []Event{
+			{Type: Expiring, Profile: "default-mfa", Expires: now.Add(10 * time.Minute), Time: now},
+			{Type: Issued, Profile: "prod-mfa", Expires: now.Add(10 * time.Minute), Time: now},
+		}, changes, "prod-mfa is only expiring within its own window")
+	})
+}
+
+func TestStream(t *testing.T) {
+	now := time.Now()
+	stream := &Stream{}
+	stream.Update(now, map[string]time.Time{"default-mfa": now.Add(time.Hour)})
+
+	latest, events, cancel := stream.Subscribe()
+	assert.Equal(t, []string{"default-mfa"}, profiles(latest))
+
+	stream.Update(now, map[string]time.Time{"default-mfa": now.Add(time.Hour), "prod-mfa": now.Add(time.Hour)})
+	assert.Equal(t, Event{Type: Issued, Profile: "prod-mfa", Expires: now.Add(time.Hour), Time: now}, <-events)
+
+	cancel()
+	stream.Update(now, map[string]time.Time{})
+	assert.Empty(t, events, "nothing is sent once cancelled")
+}
+
+func TestStreamSlowSubscriber(t *testing.T) {
+	now := time.Now()
+	stream := &Stream{}
+	_, events, cancel := stream.Subscribe()
+	defer cancel()
+	for i := range subscriberBuffer + 1 {
+		stream.Update(now, map[string]time.Time{"default-mfa": now.Add(time.Duration(i+1) * time.Hour)})
+	}
+	assert.Len(t, events, subscriberBuffer, "events beyond the buffer are dropped")
+}
+
+// profiles returns the profiles of events.
+func profiles(events []Event) []string {
+	var names []string
+	for _, event := range events {
+		names = append(names, event.Profile)
+	}
+	return names
+}
@@ -0,0 +1,144 @@
+// Package history keeps a short record of the recent logins: when, with which org and MFA
+// device, for how long and whether the session was issued. It pre-selects the answers of the
+// interactive prompts and is shown by gredentures history. Entries never contain secrets or
+// account IDs beyond those of the MFA device ARN.
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"gredentures/pkg/interrupt"
+	"gredentures/pkg/sysuser"
+)
+
+// Login results.
+const (
+	ResultOK     = "ok"     // The MFA session was issued.
+	ResultFailed = "failed" // STS refused or could not be reached.
+)
+
+// DefaultKeep is how many logins the history holds, older ones are dropped.
+const DefaultKeep = 20
+
+// Entry is a single login.
+type Entry struct {
+	Time     time.Time `json:"time"`
+	Org      string    `json:"org,omitempty"`
+	Device   string    `json:"device,omitempty"` // MFA device ARN or serial number, empty with --no-mfa.
+	Duration int32     `json:"duration"`         // Requested session duration in seconds.
+	Result   string    `json:"result"`           // ResultOK or ResultFailed.
+}
+
+// file is the on-disk layout of the history file.
+type file struct {
+	Logins []Entry `json:"logins"`
+}
+
+// DefaultPath returns the history file, $XDG_STATE_HOME/gredentures/history.json, falling
+// back to ~/.local/state when the variable is unset.
+func DefaultPath() string {
+	if dir := sysuser.StateDir(); dir != "" {
+		return filepath.Join(dir, "history.json")
+	}
+	dir := os.Getenv("XDG_STATE_HOME")
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(sysuser.Home(), ".local", "state")
+	}
+	return filepath.Join(dir, "gredentures", "history.json")
+}
+
+// Load returns the logins in the history file at path, oldest first. A missing file is empty.
+func Load(path string) ([]Entry, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history file: %w", err)
+	}
+
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse history file %s: %w", path, err)
+	}
+	return f.Logins, nil
+}
+
+// Record appends entry to the history file at path, keeping only the last keep logins. The
+// time is filled in when entry does not set it.
+func Record(path string, entry Entry, keep int) error {
+	logins, err := Load(path)
+	if err != nil {
+		return err
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	logins = append(logins, entry)
+	if len(logins) > keep {
+		logins = logins[len(logins)-keep:]
+	}
+
+	data, err := json.MarshalIndent(file{Logins: logins}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal history: %w", err)
+	}
+	if err := sysuser.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create history directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".history-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // No-op once the rename has succeeded
+	defer interrupt.OnInterrupt(func() { os.Remove(tmp.Name()) })()
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := sysuser.Chown(tmp.Name()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary file: %w", err)
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// LastOrg returns the org of the last successful login, "" when there is none.
+func LastOrg(logins []Entry) string {
+	for _, entry := range slices.Backward(logins) {
+		if entry.Result == ResultOK && entry.Org != "" {
+			return entry.Org
+		}
+	}
+	return ""
+}
+
+// LastDevice returns the MFA device of the last successful login with org, or of the last
+// successful login with any org when org was never used. It returns "" when there is none.
+func LastDevice(logins []Entry, org string) string {
+	device := ""
+	for _, entry := range slices.Backward(logins) {
+		if entry.Result != ResultOK || entry.Device == "" {
+			continue
+		}
+		if entry.Org == org {
+			return entry.Device
+		}
+		if device == "" {
+			device = entry.Device
+		}
+	}
+	return device
+}
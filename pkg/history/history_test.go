@@ -0,0 +1,62 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gredentures", "history.json")
+	first := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+
+	for i := range 4 {
+		entry := Entry{Time: first.Add(time.Duration(i) * time.Hour), Org: "prod", Duration: 3600, Result: ResultOK}
+		require.NoError(t, Record(path, entry, 3))
+	}
+	require.NoError(t, Record(path, Entry{Org: "dev", Result: ResultFailed}, 3))
+
+	logins, err := Load(path)
+	require.NoError(t, err)
+	require.Len(t, logins, 3)
+	assert.Equal(t, first.Add(2*time.Hour), logins[0].Time, "the oldest logins are dropped")
+	assert.Equal(t, "dev", logins[2].Org)
+	assert.False(t, logins[2].Time.IsZero())
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("Missing file", func(t *testing.T) {
+		logins, err := Load(filepath.Join(t.TempDir(), "missing.json"))
+		assert.NoError(t, err)
+		assert.Empty(t, logins)
+	})
+
+	t.Run("Corrupt file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "history.json")
+		require.NoError(t, os.WriteFile(path, []byte("{"), 0o600))
+		_, err := Load(path)
+		assert.ErrorContains(t, err, "failed to parse history file")
+	})
+}
+
+func TestLastOrgAndDevice(t *testing.T) {
+	logins := []Entry{
+		{Org: "prod", Device: "arn:aws:iam::123456789012:mfa/phone", Result: ResultOK},
+		{Org: "dev", Device: "arn:aws:iam::123456789012:mfa/yubikey", Result: ResultOK},
+		{Org: "staging", Device: "arn:aws:iam::123456789012:mfa/typo", Result: ResultFailed},
+	}
+
+	assert.Equal(t, "dev", LastOrg(logins))
+	assert.Equal(t, "arn:aws:iam::123456789012:mfa/phone", LastDevice(logins, "prod"))
+	assert.Equal(t, "arn:aws:iam::123456789012:mfa/yubikey", LastDevice(logins, "sandbox"))
+	assert.Empty(t, LastOrg(nil))
+	assert.Empty(t, LastDevice(nil, "prod"))
+}
@@ -0,0 +1,135 @@
+// Package interrupt ends a gredentures run cleanly on SIGINT or SIGTERM. The run's context is
+// cancelled so in-flight AWS requests stop, registered cleanups restore the terminal and remove
+// temporary files, and the process exits with the shell convention of 128 plus the signal
+// number. While a child command runs, signals are forwarded to it instead, so it can shut down
+// on its own terms and its exit code is kept.
+package interrupt
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
+)
+
+// Exit codes used when a signal ends the run.
+const (
+	ExitInterrupt = 130 // 128 + SIGINT
+	ExitTerminate = 143 // 128 + SIGTERM
+)
+
+var (
+	mu       sync.Mutex
+	ctx      = context.Background()
+	cancel   = func() {}
+	cleanups []cleanup
+	nextID   int
+	child    *os.Process
+
+	// exit and stderr are replaced in tests.
+	exit             = os.Exit
+	stderr io.Writer = os.Stderr
+)
+
+// cleanup is a function registered with OnInterrupt.
+type cleanup struct {
+	id int
+	fn func()
+}
+
+// Notify installs the signal handler and returns a function that removes it again. Until it
+// is called, Context never ends and signals keep their default behaviour.
+func Notify() (stop func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	mu.Lock()
+	ctx, cancel = context.WithCancel(context.Background())
+	mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				handle(sig)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// Context returns the context of the current run, cancelled once a signal arrives.
+func Context() context.Context {
+	mu.Lock()
+	defer mu.Unlock()
+	return ctx
+}
+
+// OnInterrupt registers fn to run when a signal ends the run and returns a function that
+// unregisters it, to be deferred once whatever fn cleans up is gone. Cleanups run in reverse
+// order of registration.
+func OnInterrupt(fn func()) (remove func()) {
+	mu.Lock()
+	defer mu.Unlock()
+	id := nextID
+	nextID++
+	cleanups = append(cleanups, cleanup{id: id, fn: fn})
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		cleanups = slices.DeleteFunc(cleanups, func(c cleanup) bool { return c.id == id })
+	}
+}
+
+// Forward hands signals to process instead of ending the run, until the returned function
+// is called. The caller waits for the process and exits with its code.
+func Forward(process *os.Process) (stop func()) {
+	mu.Lock()
+	defer mu.Unlock()
+	child = process
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		child = nil
+	}
+}
+
+// ExitCode returns the exit code for a run ended by sig, 128 plus the signal number as shells
+// report it, so a signalled child command is reported the same way.
+func ExitCode(sig os.Signal) int {
+	if number, ok := sig.(syscall.Signal); ok {
+		return 128 + int(number)
+	}
+	return ExitInterrupt
+}
+
+// handle forwards sig to the child process when one is running, and otherwise cancels the
+// run, runs the cleanups and exits.
+func handle(sig os.Signal) {
+	mu.Lock()
+	if child != nil {
+		process := child
+		mu.Unlock()
+		process.Signal(sig)
+		return
+	}
+	cancel()
+	pending := slices.Clone(cleanups)
+	mu.Unlock()
+
+	for i := len(pending) - 1; i >= 0; i-- {
+		pending[i].fn()
+	}
+	fmt.Fprintln(stderr, "\nInterrupted.")
+	exit(ExitCode(sig))
+}
@@ -0,0 +1,78 @@
+package interrupt
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeExit replaces exit and stderr for the duration of a test and returns the recorded code.
+func fakeExit(t *testing.T) (code *int, out *bytes.Buffer) {
+	code, out = new(int), new(bytes.Buffer)
+	*code = -1
+	exit, stderr = func(c int) { *code = c }, out
+	t.Cleanup(func() { exit, stderr = os.Exit, os.Stderr })
+	return code, out
+}
+
+func TestHandle(t *testing.T) {
+	code, out := fakeExit(t)
+	stop := Notify()
+	defer stop()
+
+	var order []string
+	defer OnInterrupt(func() { order = append(order, "first") })()
+	removed := OnInterrupt(func() { order = append(order, "removed") })
+	defer OnInterrupt(func() { order = append(order, "last") })()
+	removed()
+
+	handle(syscall.SIGTERM)
+	assert.Equal(t, ExitTerminate, *code)
+	assert.Equal(t, []string{"last", "first"}, order, "cleanups run newest first")
+	assert.Error(t, Context().Err(), "the run's context is cancelled")
+	assert.Contains(t, out.String(), "Interrupted.")
+}
+
+func TestNotify(t *testing.T) {
+	code, _ := fakeExit(t)
+	stop := Notify()
+	defer stop()
+	assert.NoError(t, Context().Err())
+
+	assert.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGINT))
+	select {
+	case <-Context().Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("context not cancelled by SIGINT")
+	}
+	assert.Eventually(t, func() bool { return *code == ExitInterrupt }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestForward(t *testing.T) {
+	code, _ := fakeExit(t)
+	stop := Notify()
+	defer stop()
+
+	cmd := exec.Command("sleep", "30")
+	assert.NoError(t, cmd.Start())
+	unforward := Forward(cmd.Process)
+	handle(os.Interrupt)
+	unforward()
+
+	err := cmd.Wait()
+	var exitErr *exec.ExitError
+	assert.ErrorAs(t, err, &exitErr)
+	assert.Equal(t, -1, *code, "the run goes on while a child runs")
+	assert.NoError(t, Context().Err())
+}
+
+func TestExitCode(t *testing.T) {
+	assert.Equal(t, 130, ExitCode(os.Interrupt))
+	assert.Equal(t, 143, ExitCode(syscall.SIGTERM))
+	assert.Equal(t, 137, ExitCode(syscall.SIGKILL))
+}
@@ -0,0 +1,174 @@
+// Package jsonrpc serves JSON-RPC 2.0 requests over a pair of streams, one message per line,
+// so editor plugins can drive gredentures as a child process instead of scraping its output.
+// Requests are handled one at a time in the order they arrive. Batches are not supported.
+package jsonrpc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// Version is the protocol version sent in every response.
+const Version = "2.0"
+
+// maxMessageSize bounds a single request line.
+const maxMessageSize = 1 << 20
+
+// Error codes defined by JSON-RPC 2.0. CodeServerError is used for failures of the method
+// itself, such as STS rejecting a token.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+	CodeServerError    = -32000
+)
+
+// Error is a JSON-RPC error object. Handlers return it to choose the code sent to the client;
+// any other error is sent as CodeServerError with the error text as message.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+// Error implements error.
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// InvalidParams returns the error for params that cannot be decoded or are missing a value.
+func InvalidParams(format string, args ...any) *Error {
+	return &Error{Code: CodeInvalidParams, Message: fmt.Sprintf(format, args...)}
+}
+
+// Handler answers a single method call. params is null when the request had none.
+type Handler func(ctx context.Context, params json.RawMessage) (any, error)
+
+// request is an incoming message. ID is absent for notifications, which get no response.
+type request struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// response is sent for every request with an ID, and for messages that cannot be parsed.
+type response struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// Server dispatches requests to the registered handlers.
+type Server struct {
+	methods map[string]Handler
+}
+
+// NewServer returns a Server without any methods.
+func NewServer() *Server {
+	return &Server{methods: map[string]Handler{}}
+}
+
+// Handle registers handler for method, replacing any previous one.
+func (s *Server) Handle(method string, handler Handler) {
+	s.methods[method] = handler
+}
+
+// Serve reads requests from in and writes responses to out until in is closed or ctx is
+// cancelled. It only returns an error when reading or writing fails, or when ctx ends.
+func (s *Server) Serve(ctx context.Context, in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxMessageSize)
+	encoder := json.NewEncoder(out)
+
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if resp := s.dispatch(ctx, line); resp != nil {
+			if err := encoder.Encode(resp); err != nil {
+				return fmt.Errorf("failed to write response: %w", err)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read request: %w", err)
+	}
+	return ctx.Err()
+}
+
+// dispatch handles a single message and returns its response, nil for notifications.
+func (s *Server) dispatch(ctx context.Context, line []byte) *response {
+	var req request
+	if err := json.Unmarshal(line, &req); err != nil {
+		return errorResponse(nil, &Error{Code: CodeParseError, Message: fmt.Sprintf("invalid JSON: %v", err)})
+	}
+	if req.Version != Version || req.Method == "" {
+		return errorResponse(req.ID, &Error{Code: CodeInvalidRequest, Message: `requests need "jsonrpc": "2.0" and a method`})
+	}
+
+	handler, ok := s.methods[req.Method]
+	if !ok {
+		return s.reply(req, nil, &Error{Code: CodeMethodNotFound, Message: fmt.Sprintf("unknown method %q", req.Method)})
+	}
+
+	slog.Debug("Handling JSON-RPC request", "method", req.Method)
+	result, err := handler(ctx, req.Params)
+	return s.reply(req, result, err)
+}
+
+// reply builds the response to req, or nil when req is a notification.
+func (s *Server) reply(req request, result any, err error) *response {
+	if req.ID == nil {
+		if err != nil {
+			slog.Warn("JSON-RPC notification failed", "method", req.Method, "error", err)
+		}
+		return nil
+	}
+	if err != nil {
+		var rpcErr *Error
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &Error{Code: CodeServerError, Message: err.Error()}
+		}
+		return errorResponse(req.ID, rpcErr)
+	}
+	if result == nil {
+		result = struct{}{}
+	}
+	return &response{Version: Version, ID: req.ID, Result: result}
+}
+
+// errorResponse returns an error response for id, which is null when it is not known.
+func errorResponse(id json.RawMessage, err *Error) *response {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return &response{Version: Version, ID: id, Error: err}
+}
+
+// DecodeParams decodes params into v, reporting malformed params as CodeInvalidParams.
+// Missing params leave v unchanged.
+func DecodeParams(params json.RawMessage, v any) error {
+	if len(params) == 0 || string(params) == "null" {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(params))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return InvalidParams("invalid params: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// serve runs a Server with an echo and a failing method over the given request lines and
+// returns the response lines.
+func serve(t *testing.T, lines ...string) []string {
+	server := NewServer()
+	server.Handle("echo", func(ctx context.Context, params json.RawMessage) (any, error) {
+		var p struct {
+			Text string `json:"text"`
+		}
+		if err := DecodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		return p, nil
+	})
+	server.Handle("fail", func(ctx context.Context, params json.RawMessage) (any, error) {
+		return nil, fmt.Errorf("STS said no")
+	})
+	server.Handle("nothing", func(ctx context.Context, params json.RawMessage) (any, error) {
+		return nil, nil
+	})
+
+	var out bytes.Buffer
+	assert.NoError(t, server.Serve(context.Background(), strings.NewReader(strings.Join(lines, "\n")), &out))
+	return strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+}
+
+func TestServe(t *testing.T) {
+	t.Run("Calls the method", func(t *testing.T) {
+		assert.Equal(t, []string{`{"jsonrpc":"2.0","id":1,"result":{"text":"hi"}}`},
+			serve(t, `{"jsonrpc":"2.0","id":1,"method":"echo","params":{"text":"hi"}}`))
+	})
+
+	t.Run("Results may be empty", func(t *testing.T) {
+		assert.Equal(t, []string{`{"jsonrpc":"2.0","id":"a","result":{}}`},
+			serve(t, `{"jsonrpc":"2.0","id":"a","method":"nothing"}`))
+	})
+
+	t.Run("Notifications get no response", func(t *testing.T) {
+		assert.Equal(t, []string{`{"jsonrpc":"2.0","id":2,"result":{"text":""}}`},
+			serve(t, `{"jsonrpc":"2.0","method":"fail"}`, "", `{"jsonrpc":"2.0","id":2,"method":"echo"}`))
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		assert.Equal(t, []string{
+			`{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"invalid JSON: invalid character 'n' looking for beginning of object key string"}}`,
+			`{"jsonrpc":"2.0","id":1,"error":{"code":-32600,"message":"requests need \"jsonrpc\": \"2.0\" and a method"}}`,
+			`{"jsonrpc":"2.0","id":2,"error":{"code":-32601,"message":"unknown method \"nope\""}}`,
+			`{"jsonrpc":"2.0","id":3,"error":{"code":-32602,"message":"invalid params: json: unknown field \"txt\""}}`,
+			`{"jsonrpc":"2.0","id":4,"error":{"code":-32000,"message":"STS said no"}}`,
+		}, serve(t,
+			`{not json`,
+			`{"id":1,"method":"echo"}`,
+			`{"jsonrpc":"2.0","id":2,"method":"nope"}`,
+			`{"jsonrpc":"2.0","id":3,"method":"echo","params":{"txt":"hi"}}`,
+			`{"jsonrpc":"2.0","id":4,"method":"fail"}`,
+		))
+	})
+}
+
+func TestServeCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var out bytes.Buffer
+	err := NewServer().Serve(ctx, strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"echo"}`), &out)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Empty(t, out.String())
+}
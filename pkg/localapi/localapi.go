@@ -0,0 +1,348 @@
+// Package localapi serves a small JSON API over HTTP on the loopback interface, so desktop
+// apps and tray widgets can query and drive gredentures without shelling out and parsing its
+// output. Every request carries the bearer token kept in a file only the user can read, and
+// requests are handled one at a time in the order they arrive.
+package localapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gredentures/pkg/sysuser"
+)
+
+// DefaultAddr is the address served when none is given.
+const DefaultAddr = "127.0.0.1:7821"
+
+// maxBodySize bounds a single request body.
+const maxBodySize = 1 << 20
+
+// readHeaderTimeout bounds how long a client may take to send its request headers.
+const readHeaderTimeout = 5 * time.Second
+
+// DefaultTokenPath returns the file holding the bearer token of the API.
+func DefaultTokenPath() string {
+	if dir := sysuser.StateDir(); dir != "" {
+		return filepath.Join(dir, "api-token")
+	}
+	return filepath.Join(sysuser.Home(), ".gredentures", "api-token")
+}
+
+// ReadToken returns the bearer token in path, for clients of the API.
+func ReadToken(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read token file: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", path)
+	}
+	return token, nil
+}
+
+// LoadToken returns the bearer token in path, first creating the file with a random token,
+// readable by its owner only, when it does not exist yet.
+func LoadToken(path string) (string, error) {
+	if token, err := ReadToken(path); !errors.Is(err, os.ErrNotExist) {
+		return token, err
+	}
+
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	token := hex.EncodeToString(random)
+	if err := sysuser.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", fmt.Errorf("failed to create token directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return "", fmt.Errorf("failed to create token file: %w", err)
+	}
+	_, err = file.WriteString(token + "\n")
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to write token file: %w", err)
+	}
+	if err := sysuser.Chown(path); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// CheckLoopback returns an error unless addr is a host:port on the loopback interface. The API
+// hands out credentials, so it is never exposed to the network.
+func CheckLoopback(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid listen address %q: %w", addr, err)
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("listen address %q is not on the loopback interface", addr)
+	}
+	return nil
+}
+
+// Error is a failed request. Handlers return it to choose the status code and to name the
+// failure in Reason, so clients can react without matching on messages. Errors without a
+// status, and any other error, are sent with status 500 and the error text as message.
+type Error struct {
+	Status  int    `json:"-"`
+	Message string `json:"error"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// Error implements error.
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// BadRequest returns the error for a request body that cannot be decoded or is missing a value.
+func BadRequest(format string, args ...any) *Error {
+	return &Error{Status: http.StatusBadRequest, Message: fmt.Sprintf(format, args...)}
+}
+
+// Handler answers a single request. body is empty when the request had none.
+type Handler func(ctx context.Context, body json.RawMessage) (any, error)
+
+// StreamHandler answers a request with a stream of values, passing each to send, until it
+// returns or ctx ends. query holds the parameters of the request URL.
+type StreamHandler func(ctx context.Context, query url.Values, send func(v any) error) error
+
+// Server dispatches authenticated requests to the registered handlers.
+type Server struct {
+	Addr  string // Address to listen on, DefaultAddr when empty.
+	Token string // Bearer token every request has to carry.
+
+	mux *http.ServeMux
+	mu  sync.Mutex // Serializes the handlers, streams run alongside them.
+}
+
+// NewServer returns a Server for token without any routes.
+func NewServer(addr, token string) *Server {
+	return &Server{Addr: addr, Token: token, mux: http.NewServeMux()}
+}
+
+// Handle registers handler for pattern, a method and path such as "GET /v1/status".
+// Requests with another method receive 405.
+func (s *Server) Handle(pattern string, handler Handler) {
+	s.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+		if err != nil {
+			writeJSON(w, http.StatusRequestEntityTooLarge, &Error{Message: fmt.Sprintf("failed to read request: %v", err)})
+			return
+		}
+
+		slog.Debug("Handling API request", "method", r.Method, "path", r.URL.Path)
+		s.mu.Lock()
+		result, err := handler(r.Context(), bytes.TrimSpace(body))
+		s.mu.Unlock()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if result == nil {
+			result = struct{}{}
+		}
+		writeJSON(w, http.StatusOK, result)
+	})
+}
+
+// HandleStream registers handler for pattern like Handle. The values it sends are written as
+// newline-delimited JSON, each flushed to the client at once, and the handlers of other
+// requests keep running meanwhile. An error returned before anything was sent is answered
+// like the errors of Handle; once the stream has started, the response simply ends.
+func (s *Server) HandleStream(pattern string, handler StreamHandler) {
+	s.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		slog.Debug("Streaming API request", "method", r.Method, "path", r.URL.Path)
+		flusher, _ := w.(http.Flusher)
+		started := false
+		err := handler(r.Context(), r.URL.Query(), func(v any) error {
+			if !started {
+				w.Header().Set("Content-Type", "application/x-ndjson")
+				w.WriteHeader(http.StatusOK)
+				started = true
+			}
+			if err := json.NewEncoder(w).Encode(v); err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			return nil
+		})
+		if err != nil && !started {
+			writeError(w, err)
+		} else if err != nil {
+			slog.Debug("API stream ended", "path", r.URL.Path, "error", err)
+		} else if !started {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.WriteHeader(http.StatusOK)
+		}
+	})
+}
+
+// ServeHTTP implements http.Handler, refusing requests without the bearer token.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) != 1 {
+		slog.Warn("Refused API request without a valid token", "remote", r.RemoteAddr, "path", r.URL.Path)
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeJSON(w, http.StatusUnauthorized, &Error{Message: "missing or invalid bearer token"})
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// ListenAndServe listens on Addr, which has to be on the loopback interface, and serves until
+// ctx is cancelled.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	addr := s.Addr
+	if addr == "" {
+		addr = DefaultAddr
+	}
+	if err := CheckLoopback(addr); err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	return s.Serve(ctx, listener)
+}
+
+// Serve serves requests accepted on listener until ctx is cancelled.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	if s.Token == "" {
+		listener.Close()
+		return fmt.Errorf("refusing to serve without a token")
+	}
+	// Requests share ctx, so streams end with the server
+	server := &http.Server{Handler: s, ReadHeaderTimeout: readHeaderTimeout, BaseContext: func(net.Listener) context.Context { return ctx }}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), readHeaderTimeout)
+		defer cancel()
+		_ = server.Shutdown(shutdown)
+	}()
+
+	slog.Info("API listening", "address", listener.Addr().String())
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to serve: %w", err)
+	}
+	return nil
+}
+
+// DecodeBody decodes body into v, reporting malformed bodies as BadRequest. An empty body
+// leaves v unchanged.
+func DecodeBody(body json.RawMessage, v any) error {
+	if len(body) == 0 {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return BadRequest("invalid request body: %v", err)
+	}
+	return nil
+}
+
+// writeError writes the response of a failed request, see Error.
+func writeError(w http.ResponseWriter, err error) {
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		apiErr = &Error{Message: err.Error()}
+	}
+	status := apiErr.Status
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+	writeJSON(w, status, apiErr)
+}
+
+// writeJSON writes v as the JSON body of a response with status.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Debug("Failed to write API response", "error", err)
+	}
+}
+
+// Client calls the API of a running gredentures serve.
+type Client struct {
+	Addr  string       // Address the API listens on, DefaultAddr when empty.
+	Token string       // Bearer token of the API.
+	HTTP  *http.Client // Client sending the requests, http.DefaultClient when nil.
+}
+
+// Stream sends a GET request for path and passes every value of the streamed response to fn,
+// until the response ends, fn fails or ctx ends. A refused request returns its *Error. The
+// token is sent in plain HTTP, so Addr has to be on the loopback interface like that of Server.
+func (c Client) Stream(ctx context.Context, path string, fn func(json.RawMessage) error) error {
+	addr, client := c.Addr, c.HTTP
+	if addr == "" {
+		addr = DefaultAddr
+	}
+	if err := CheckLoopback(addr); err != nil {
+		return err
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+path, nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+c.Token)
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		apiErr := &Error{Status: response.StatusCode}
+		if json.NewDecoder(response.Body).Decode(apiErr) != nil || apiErr.Message == "" {
+			apiErr.Message = response.Status
+		}
+		return apiErr
+	}
+
+	decoder := json.NewDecoder(response.Body)
+	for {
+		var value json.RawMessage
+		if err := decoder.Decode(&value); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("failed to read the stream: %w", err)
+		}
+		if err := fn(value); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package localapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newServer returns a Server with an echo, two failing and an empty route, and a stream.
+func newServer() *Server {
+	server := NewServer("", "secret-token")
+	server.Handle("POST /echo", func(ctx context.Context, body json.RawMessage) (any, error) {
+		var p struct {
+			Text string `json:"text"`
+		}
+		if err := DecodeBody(body, &p); err != nil {
+			return nil, err
+		}
+		return p, nil
+	})
+	server.Handle("GET /fail", func(ctx context.Context, body json.RawMessage) (any, error) {
+		return nil, fmt.Errorf("STS said no")
+	})
+	server.Handle("GET /refuse", func(ctx context.Context, body json.RawMessage) (any, error) {
+		return nil, &Error{Status: http.StatusUnprocessableEntity, Message: "no token", Reason: "missingToken"}
+	})
+	server.Handle("GET /nothing", func(ctx context.Context, body json.RawMessage) (any, error) {
+		return nil, nil
+	})
+	server.HandleStream("GET /count", func(ctx context.Context, query url.Values, send func(any) error) error {
+		if query.Get("to") == "" {
+			return BadRequest("to is missing")
+		}
+		for i := range len(query.Get("to")) {
+			if err := send(map[string]int{"n": i}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return server
+}
+
+// call sends a request to server with token and returns the status code and body.
+func call(server *Server, token, method, path, body string) (int, string) {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+	return recorder.Code, strings.TrimSuffix(recorder.Body.String(), "\n")
+}
+
+func TestServeHTTP(t *testing.T) {
+	server := newServer()
+
+	t.Run("Calls the handler", func(t *testing.T) {
+		code, body := call(server, "secret-token", "POST", "/echo", `{"text":"hi"}`)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, `{"text":"hi"}`, body)
+	})
+
+	t.Run("Results may be empty", func(t *testing.T) {
+		code, body := call(server, "secret-token", "GET", "/nothing", "")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, `{}`, body)
+	})
+
+	t.Run("Requires the token", func(t *testing.T) {
+		for _, token := range []string{"", "wrong"} {
+			code, body := call(server, token, "GET", "/nothing", "")
+			assert.Equal(t, http.StatusUnauthorized, code)
+			assert.Equal(t, `{"error":"missing or invalid bearer token"}`, body)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		code, body := call(server, "secret-token", "GET", "/fail", "")
+		assert.Equal(t, http.StatusInternalServerError, code)
+		assert.Equal(t, `{"error":"STS said no"}`, body)
+
+		code, body = call(server, "secret-token", "GET", "/refuse", "")
+		assert.Equal(t, http.StatusUnprocessableEntity, code)
+		assert.Equal(t, `{"error":"no token","reason":"missingToken"}`, body)
+
+		code, body = call(server, "secret-token", "POST", "/echo", `{"txt":"hi"}`)
+		assert.Equal(t, http.StatusBadRequest, code)
+		assert.Equal(t, `{"error":"invalid request body: json: unknown field \"txt\""}`, body)
+	})
+
+	t.Run("Routes by method", func(t *testing.T) {
+		code, _ := call(server, "secret-token", "GET", "/echo", "")
+		assert.Equal(t, http.StatusMethodNotAllowed, code)
+		code, _ = call(server, "secret-token", "GET", "/nope", "")
+		assert.Equal(t, http.StatusNotFound, code)
+	})
+}
+
+func TestHandleStream(t *testing.T) {
+	server := newServer()
+
+	code, body := call(server, "secret-token", "GET", "/count?to=xxx", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "{\"n\":0}\n{\"n\":1}\n{\"n\":2}", body)
+
+	code, body = call(server, "secret-token", "GET", "/count", "")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, `{"error":"to is missing"}`, body)
+
+	code, _ = call(server, "", "GET", "/count?to=x", "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
+
+func TestClientStream(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = newServer().Serve(ctx, listener) }()
+	client := Client{Addr: listener.Addr().String(), Token: "secret-token"}
+
+	var values []string
+	assert.NoError(t, client.Stream(ctx, "/count?to=xx", func(value json.RawMessage) error {
+		values = append(values, string(value))
+		return nil
+	}))
+	assert.Equal(t, []string{`{"n":0}`, `{"n":1}`}, values)
+
+	err = client.Stream(ctx, "/count", func(json.RawMessage) error { return nil })
+	assert.Equal(t, &Error{Status: http.StatusBadRequest, Message: "to is missing"}, err)
+
+	client.Token = "wrong"
+	err = client.Stream(ctx, "/count?to=x", func(json.RawMessage) error { return nil })
+	assert.Equal(t, &Error{Status: http.StatusUnauthorized, Message: "missing or invalid bearer token"}, err)
+
+	client.Addr = "192.0.2.1:7821"
+	err = client.Stream(ctx, "/count?to=x", func(json.RawMessage) error { panic("unexpected value") })
+	assert.ErrorContains(t, err, "192.0.2.1")
+}
+
+func TestServe(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- newServer().Serve(ctx, listener) }()
+
+	request, err := http.NewRequest("GET", "http://"+listener.Addr().String()+"/nothing", nil)
+	require.NoError(t, err)
+	request.Header.Set("Authorization", "Bearer secret-token")
+	response, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func TestServeWithoutToken(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	assert.EqualError(t, NewServer("", "").Serve(context.Background(), listener), "refusing to serve without a token")
+}
+
+func TestCheckLoopback(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1:7821", "localhost:7821", "[::1]:7821", "127.0.0.2:0"} {
+		assert.NoError(t, CheckLoopback(addr), addr)
+	}
+	for _, addr := range []string{"0.0.0.0:7821", ":7821", "192.168.1.10:7821", "example.com:7821"} {
+		assert.ErrorContains(t, CheckLoopback(addr), "not on the loopback interface", addr)
+	}
+	assert.ErrorContains(t, CheckLoopback("127.0.0.1"), "invalid listen address")
+}
+
+func TestLoadToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "api-token")
+
+	token, err := LoadToken(path)
+	require.NoError(t, err)
+	assert.Len(t, token, 64)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	again, err := LoadToken(path)
+	require.NoError(t, err)
+	assert.Equal(t, token, again, "the token is kept")
+
+	read, err := ReadToken(path)
+	require.NoError(t, err)
+	assert.Equal(t, token, read)
+	_, err = ReadToken(filepath.Join(t.TempDir(), "missing"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	require.NoError(t, os.WriteFile(path, []byte("\n"), 0o600))
+	_, err = LoadToken(path)
+	assert.ErrorContains(t, err, "is empty")
+}
@@ -0,0 +1,286 @@
+// Package messages holds the user-facing texts of gredentures in a catalog, so teams can
+// translate them. Every text is a text/template keyed by an ID and takes named parameters,
+// e.g. "Error running recipe {{.Recipe}}: {{.Err}}". Unlike printf verbs, named parameters
+// can be reordered by a translation and cannot be mismatched with their arguments.
+//
+// English is built in. Other locales are YAML files named after the locale, e.g. de_DE.yml
+// or de.yml, mapping IDs to templates. Texts a translation leaves out fall back to English.
+package messages
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ID names a message of the catalog.
+type ID string
+
+// Messages of the login flow, its prompts and the hints for recognised failures.
+const (
+	Banner                    ID = "banner"
+	ErrParseArgs              ID = "error.parse-args"
+	ErrDebugHTTP              ID = "error.debug-http"
+	ErrOnePassword            ID = "error.onepassword"
+	ErrKeyStore               ID = "error.keystore"
+	ErrValidateOptions        ID = "error.validate-options"
+	ErrBootstrap              ID = "error.bootstrap"
+	ErrDefaultCreds           ID = "error.default-creds"
+	ErrSessionCreds           ID = "error.session-creds"
+	ErrOrgRoles               ID = "error.org-roles"
+	ErrRecipe                 ID = "error.recipe"
+	ErrExport                 ID = "error.export"
+	ErrWriteOutput            ID = "error.write-output"
+	ErrWriteIsolated          ID = "error.write-isolated"
+	ErrWindowsPath            ID = "error.windows-path"
+	ErrWriteFile              ID = "error.write-file"
+	ErrLoginMessage           ID = "error.login-message"
+	ErrCommandEnv             ID = "error.command-env"
+	ErrRunCommand             ID = "error.run-command"
+	WroteFile                 ID = "success.wrote-file"
+	WroteIsolated             ID = "note.wrote-isolated"
+	RemovedSessionToken       ID = "note.removed-session-token"
+	WarnPartialRoles          ID = "warning.partial-roles"
+	ProgressSession           ID = "progress.session"
+	ProgressOrgRoles          ID = "progress.org-roles"
+	ProgressRecipe            ID = "progress.recipe"
+	ProgressIdentity          ID = "progress.identity"
+	PromptMFACode             ID = "prompt.mfa-code"
+	PromptConfirm             ID = "prompt.confirm"
+	PromptOrg                 ID = "prompt.org"
+	PromptDevice              ID = "prompt.device"
+	HintMissingToken          ID = "hint.missing-token"
+	HintInvalidDevice         ID = "hint.invalid-device"
+	HintSecurityKeyDevice     ID = "hint.security-key-device"
+	HintInvalidToken          ID = "hint.invalid-token"
+	HintThrottled             ID = "hint.throttled"
+	HintExpiredToken          ID = "hint.expired-token"
+	HintClockSkew             ID = "hint.clock-skew"
+	HintFileLocked            ID = "hint.file-locked"
+	HintMFARequired           ID = "hint.mfa-required"
+	HintIncompleteCredentials ID = "hint.incomplete-credentials"
+	HintExternalIDRequired    ID = "hint.external-id-required"
+	HintSTSUnreachable        ID = "hint.sts-unreachable"
+	HintKeyFilePassphrase     ID = "hint.keyfile-passphrase"
+	HintForeignFile           ID = "hint.foreign-file"
+	HintForeignProfile        ID = "hint.foreign-profile"
+	HintInvalidProfile        ID = "hint.invalid-profile"
+	HintProfileCollision      ID = "hint.profile-collision"
+)
+
+// english holds the built-in texts, the fallback of every translation.
+var english = map[ID]string{
+	Banner:                    "Gredentures CLI version: {{.Version}}",
+	ErrParseArgs:              "Error parsing command line arguments: {{.Err}}",
+	ErrDebugHTTP:              "Error opening the HTTP trace file: {{.Err}}",
+	ErrOnePassword:            "Error reading 1Password item: {{.Err}}",
+	ErrKeyStore:               "Error reading the long-lived keys from the key store: {{.Err}}",
+	ErrValidateOptions:        "Error validating options: {{.Err}}",
+	ErrBootstrap:              "Error bootstrapping credentials file: {{.Err}}",
+	ErrDefaultCreds:           "Error getting default credentials: {{.Err}}",
+	ErrSessionCreds:           "Error getting session credentials: {{.Err}}",
+	ErrOrgRoles:               "Error assuming org roles: {{.Err}}",
+	ErrRecipe:                 "Error running recipe {{.Recipe}}: {{.Err}}",
+	ErrExport:                 "Error exporting credentials: {{.Err}}",
+	ErrWriteOutput:            "Error writing {{.Output}} credentials: {{.Err}}",
+	ErrWriteIsolated:          "Error writing isolated credentials: {{.Err}}",
+	ErrWindowsPath:            "Error locating Windows credentials file: {{.Err}}",
+	ErrWriteFile:              "Error writing {{.Path}}: {{.Err}}",
+	ErrLoginMessage:           "Error rendering login message: {{.Err}}",
+	ErrCommandEnv:             "Error preparing command environment: {{.Err}}",
+	ErrRunCommand:             "Error running command: {{.Err}}",
+	WroteFile:                 "Wrote credentials to {{.Path}}",
+	WroteIsolated:             "Wrote the credentials to {{.Path}}, {{.CredentialsPath}} was left untouched. Remove {{.Dir}} when done.",
+	RemovedSessionToken:       "Removed the stale aws_session_token left by another tool from profile {{.Profile}} of {{.Path}}, it broke GetSessionToken.",
+	WarnPartialRoles:          "Writing the org roles that were assumed, the others failed: {{.Err}}",
+	ProgressSession:           "Requesting session token from STS...",
+	ProgressOrgRoles:          "Assuming roles for all configured orgs...",
+	ProgressRecipe:            "Running login recipe {{.Recipe}}...",
+	ProgressIdentity:          "Looking up the session identity...",
+	PromptMFACode:             "MFA code: ",
+	PromptConfirm:             "{{.Question}} [y/N] ",
+	PromptOrg:                 "Org [{{.Default}}]: ",
+	PromptDevice:              "MFA device [{{.Default}}]: ",
+	HintMissingToken:          "Pass the current MFA code with -t, configure a token command, or choose how to ask for it with --prompt.",
+	HintInvalidDevice:         "Use the MFA device ARN shown in the IAM console, e.g. arn:aws:iam::123456789012:mfa/my-device.",
+	HintSecurityKeyDevice:     "Keep the security key for the console and add a virtual MFA device for gredentures with gredentures device enroll, an IAM user can have up to 8 devices.",
+	HintInvalidToken:          "Pass the six digits currently shown by your authenticator app or hardware token.",
+	HintThrottled:             "STS is rate limiting requests, wait a moment and try again.",
+	HintExpiredToken:          "The credentials used to call STS have expired, check the source profile.",
+	HintClockSkew:             "MFA codes depend on an accurate clock, enable time sync (e.g. timedatectl set-ntp true) and try again.",
+	HintFileLocked:            "Another gredentures run is writing the credentials file, try again once it finishes.",
+	HintMFARequired:           "This account enforces MFA, drop --no-mfa and pass the current MFA code with -t.",
+	HintIncompleteCredentials: "The credentials file was left as it was, fix the error reported above and log in again.",
+	HintExternalIDRequired:    "Roles of third parties usually require the external ID they gave you, pass it with --external-id or set ExternalID on the org or recipe.",
+	HintSTSUnreachable:        "STS cannot be reached, check the network or VPN. gredentures status and gredentures exec --offline work with the credentials already written.",
+	HintKeyFilePassphrase:     "Check the passphrase, or GREDENTURES_KEYSTORE_PASSPHRASE if it is set. A forgotten passphrase cannot be recovered, the keys have to be added again.",
+	HintForeignFile:           "Run gredentures as the user the file belongs to. Under sudo, gredentures writes the files of the user who ran sudo.",
+	HintForeignProfile:        "CompatMode leaves the profiles of other credential helpers alone, set another Profile on the org or recipe, or -p for the session.",
+	HintInvalidProfile:        "Profile names become [sections] of the credentials file, use letters, digits and characters like - _ . @ in them.",
+	HintProfileCollision:      "The profile holds keys of your own, choose another name with -p or Profile on the org, or pass --force to replace them with the session.",
+}
+
+// English is the locale of the built-in texts.
+const English = "en"
+
+// Args are the named parameters of a message.
+type Args map[string]any
+
+// Catalog renders the messages of one locale.
+type Catalog struct {
+	Locale string                    // Locale of the translation, English when none was found.
+	texts  map[ID]*template.Template // Translated texts.
+	base   map[ID]*template.Template // English texts, used for every ID the translation leaves out.
+}
+
+// builtin holds the parsed English texts.
+var builtin = mustParse(english)
+
+// Default returns the catalog of the built-in English texts.
+func Default() *Catalog {
+	return &Catalog{Locale: English, base: builtin}
+}
+
+// Load returns the catalog for the locale selected by environ, see Locale, from the
+// translations in dir. It looks for <locale>.yml and then for the file of the language alone,
+// e.g. de_DE.yml and then de.yml. Without a translation the built-in English texts are used.
+func Load(dir string, environ []string) (*Catalog, error) {
+	locale := Locale(environ)
+	if locale == English || dir == "" {
+		return Default(), nil
+	}
+
+	candidates := []string{locale}
+	if language, _, found := strings.Cut(locale, "_"); found {
+		candidates = append(candidates, language)
+	}
+	for _, candidate := range candidates {
+		path := filepath.Join(dir, candidate+".yml")
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return Default(), fmt.Errorf("failed to read translation %s: %w", path, err)
+		}
+		texts, err := parse(path, data)
+		if err != nil {
+			return Default(), err
+		}
+		slog.Debug("Loaded translation", "locale", candidate, "path", path)
+		return &Catalog{Locale: candidate, texts: texts, base: builtin}, nil
+	}
+	slog.Debug("No translation found, using English", "locale", locale, "dir", dir)
+	return Default(), nil
+}
+
+// Locale returns the locale selected by the first of LC_ALL, LC_MESSAGES and LANG that is set
+// in environ, without its encoding or modifier, e.g. de_DE for de_DE.UTF-8. The C and POSIX
+// locales, and an unset one, select English.
+func Locale(environ []string) string {
+	values := map[string]string{}
+	for _, entry := range environ {
+		if name, value, found := strings.Cut(entry, "="); found {
+			values[name] = value
+		}
+	}
+	for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		value := values[name]
+		if value == "" {
+			continue
+		}
+		value, _, _ = strings.Cut(value, ".")
+		value, _, _ = strings.Cut(value, "@")
+		if value == "C" || value == "POSIX" {
+			return English
+		}
+		return value
+	}
+	return English
+}
+
+// Text renders message id with args. When the translation has no text for id, or its text
+// fails to render, the English text is used instead, so a broken translation never hides a
+// message.
+func (c *Catalog) Text(id ID, args Args) string {
+	if tmpl, ok := c.texts[id]; ok {
+		text, err := render(tmpl, args)
+		if err == nil {
+			return text
+		}
+		slog.Debug("Translated message failed to render, using English", "id", id, "locale", c.Locale, "error", err)
+	}
+	if tmpl, ok := c.base[id]; ok {
+		if text, err := render(tmpl, args); err == nil {
+			return text
+		}
+	}
+	return string(id)
+}
+
+// render executes tmpl with args.
+func render(tmpl *template.Template, args Args) (string, error) {
+	var out strings.Builder
+	if err := tmpl.Execute(&out, args); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// parse reads the translation in data, read from path. Unknown IDs are rejected, so a typo
+// does not silently leave the English text in place.
+func parse(path string, data []byte) (map[ID]*template.Template, error) {
+	var raw map[ID]string
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid translation %s: %w", path, err)
+	}
+	for id := range raw {
+		if _, ok := english[id]; !ok {
+			return nil, fmt.Errorf("invalid translation %s: unknown message %q, expected one of %s", path, id, strings.Join(IDs(), ", "))
+		}
+	}
+	texts, err := parseTexts(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid translation %s: %w", path, err)
+	}
+	return texts, nil
+}
+
+// parseTexts parses every text as a template that fails on missing parameters.
+func parseTexts(raw map[ID]string) (map[ID]*template.Template, error) {
+	texts := make(map[ID]*template.Template, len(raw))
+	for id, text := range raw {
+		tmpl, err := template.New(string(id)).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("message %q: %w", id, err)
+		}
+		texts[id] = tmpl
+	}
+	return texts, nil
+}
+
+// mustParse parses the built-in texts, which are known to be valid.
+func mustParse(raw map[ID]string) map[ID]*template.Template {
+	texts, err := parseTexts(raw)
+	if err != nil {
+		panic(err)
+	}
+	return texts
+}
+
+// IDs returns the ID of every message, sorted, for writing translations.
+func IDs() []string {
+	ids := make([]string, 0, len(english))
+	for id := range english {
+		ids = append(ids, string(id))
+	}
+	slices.Sort(ids)
+	return ids
+}
@@ -0,0 +1,102 @@
+package messages
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocale(t *testing.T) {
+	tests := []struct {
+		environ []string
+		want    string
+	}{
+		{nil, English},
+		{[]string{"LANG=de_DE.UTF-8"}, "de_DE"},
+		{[]string{"LANG=de_DE.UTF-8", "LC_MESSAGES=fr_FR"}, "fr_FR"},
+		{[]string{"LANG=de_DE", "LC_MESSAGES=fr_FR", "LC_ALL=pt_BR.UTF-8"}, "pt_BR"},
+		{[]string{"LANG=ca_ES@valencia"}, "ca_ES"},
+		{[]string{"LC_ALL=", "LANG=nl"}, "nl"},
+		{[]string{"LANG=C.UTF-8"}, English},
+		{[]string{"LC_ALL=POSIX", "LANG=de_DE"}, English},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, Locale(tt.environ), "%v", tt.environ)
+	}
+}
+
+func TestDefault(t *testing.T) {
+	catalog := Default()
+	assert.Equal(t, English, catalog.Locale)
+	assert.Equal(t, "Gredentures CLI version: 1.2.3", catalog.Text(Banner, Args{"Version": "1.2.3"}))
+	assert.Equal(t, "Error running recipe prod: denied", catalog.Text(ErrRecipe, Args{"Recipe": "prod", "Err": errors.New("denied")}))
+	assert.Equal(t, "MFA code: ", catalog.Text(PromptMFACode, nil))
+	assert.Equal(t, "unknown.id", catalog.Text("unknown.id", nil))
+}
+
+func TestEveryMessageRenders(t *testing.T) {
+	args := Args{"Version": "1", "Err": "e", "Recipe": "r", "Output": "o", "Path": "p", "CredentialsPath": "c", "Dir": "d", "Question": "q", "Profile": "default", "Default": "prod"}
+	for _, id := range IDs() {
+		text := Default().Text(ID(id), args)
+		assert.NotEqual(t, id, text, "message %s must render", id)
+		assert.NotContains(t, text, "<no value>")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "de.yml"), []byte(`
+banner: "Gredentures CLI Version {{.Version}}"
+error.recipe: "Rezept {{.Recipe}} fehlgeschlagen: {{.Err}}"
+error.write-file: "Fehler: {{.Missing}}"
+`), 0o600))
+
+	t.Run("Falls back from the country to the language", func(t *testing.T) {
+		catalog, err := Load(dir, []string{"LANG=de_AT.UTF-8"})
+		assert.NoError(t, err)
+		assert.Equal(t, "de", catalog.Locale)
+		assert.Equal(t, "Gredentures CLI Version 1", catalog.Text(Banner, Args{"Version": "1"}))
+		assert.Equal(t, "Rezept prod fehlgeschlagen: denied", catalog.Text(ErrRecipe, Args{"Recipe": "prod", "Err": "denied"}))
+	})
+
+	t.Run("Uses English for texts left out or failing to render", func(t *testing.T) {
+		catalog, err := Load(dir, []string{"LANG=de_DE"})
+		assert.NoError(t, err)
+		assert.Equal(t, "Requesting session token from STS...", catalog.Text(ProgressSession, nil))
+		assert.Equal(t, "Error writing p: e", catalog.Text(ErrWriteFile, Args{"Path": "p", "Err": "e"}))
+	})
+
+	t.Run("Prefers the file of the full locale", func(t *testing.T) {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "de_CH.yml"), []byte(`banner: "Grüezi {{.Version}}"`), 0o600))
+		catalog, err := Load(dir, []string{"LANG=de_CH"})
+		assert.NoError(t, err)
+		assert.Equal(t, "de_CH", catalog.Locale)
+		assert.Equal(t, "Grüezi 1", catalog.Text(Banner, Args{"Version": "1"}))
+	})
+
+	t.Run("Uses English without a translation", func(t *testing.T) {
+		catalog, err := Load(dir, []string{"LANG=fr_FR"})
+		assert.NoError(t, err)
+		assert.Equal(t, English, catalog.Locale)
+
+		catalog, err = Load(filepath.Join(dir, "missing"), []string{"LANG=de"})
+		assert.NoError(t, err)
+		assert.Equal(t, English, catalog.Locale)
+	})
+
+	t.Run("Rejects broken translations", func(t *testing.T) {
+		broken := t.TempDir()
+		assert.NoError(t, os.WriteFile(filepath.Join(broken, "fr.yml"), []byte(`baner: "typo"`), 0o600))
+		assert.NoError(t, os.WriteFile(filepath.Join(broken, "es.yml"), []byte(`banner: "{{.Version"`), 0o600))
+
+		catalog, err := Load(broken, []string{"LANG=fr"})
+		assert.ErrorContains(t, err, `unknown message "baner"`)
+		assert.Equal(t, English, catalog.Locale)
+
+		_, err = Load(broken, []string{"LANG=es"})
+		assert.ErrorContains(t, err, `message "banner"`)
+	})
+}
@@ -0,0 +1,200 @@
+// Package onepassword reads AWS access keys and MFA one-time passwords from a 1Password
+// item, either through the `op` CLI or a 1Password Connect server. It lets gredentures run
+// without any long-lived secrets stored in ~/.aws or ~/.gredentures.yml.
+package onepassword
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"unicode"
+
+	"gredentures/pkg/secret"
+)
+
+// Item holds the AWS secrets read from a 1Password item.
+type Item struct {
+	AccessKeyID     string       // Long-lived AWS access key ID.
+	SecretAccessKey secret.Value // Long-lived AWS secret access key.
+	TOTP            secret.Value // Current one-time password, empty if the item has no OTP field.
+}
+
+// Client fetches an AWS item from 1Password.
+type Client interface {
+	GetItem(ctx context.Context, vault, item string) (Item, error)
+}
+
+// field is a single field of a 1Password item as returned by both the CLI and Connect.
+type field struct {
+	Type  string `json:"type"`
+	Label string `json:"label"`
+	Value string `json:"value"`
+	TOTP  string `json:"totp"`
+}
+
+// rawItem is the subset of a 1Password item that gredentures reads.
+type rawItem struct {
+	ID     string  `json:"id"`
+	Fields []field `json:"fields"`
+}
+
+// Normalized field labels recognised as the access key pair.
+var (
+	accessKeyLabels = []string{"accesskeyid", "awsaccesskeyid"}
+	secretKeyLabels = []string{"secretaccesskey", "awssecretaccesskey"}
+)
+
+// New returns a Connect client when host is set and a CLI client otherwise.
+func New(host, token string) Client {
+	if host != "" {
+		return &Connect{Host: host, Token: token, HTTPClient: http.DefaultClient}
+	}
+	return &CLI{Path: "op"}
+}
+
+// NewFromEnv is like New but reads the Connect host and token from OP_CONNECT_HOST and
+// OP_CONNECT_TOKEN, with host taking precedence over the environment when set.
+func NewFromEnv(host string) Client {
+	if host == "" {
+		host = os.Getenv("OP_CONNECT_HOST")
+	}
+	return New(host, os.Getenv("OP_CONNECT_TOKEN"))
+}
+
+// CLI reads items with the 1Password `op` command line tool.
+type CLI struct {
+	Path string // Path or name of the op binary.
+}
+
+// GetItem runs `op item get` and extracts the AWS secrets from its JSON output.
+func (c *CLI) GetItem(ctx context.Context, vault, item string) (Item, error) {
+	args := []string{"item", "get", item, "--format", "json"}
+	if vault != "" {
+		args = append(args, "--vault", vault)
+	}
+
+	slog.Debug("Reading 1Password item with op CLI", "item", item, "vault", vault)
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.Path, args...)
+	cmd.Stdin = os.Stdin // op may prompt to sign in
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return Item{}, fmt.Errorf("op item get failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var raw rawItem
+	if err := json.Unmarshal(out, &raw); err != nil {
+		return Item{}, fmt.Errorf("failed to parse op output: %w", err)
+	}
+
+	return parseItem(item, raw)
+}
+
+// Connect reads items from a 1Password Connect server.
+type Connect struct {
+	Host       string       // Base URL of the Connect server.
+	Token      string       // Connect access token.
+	HTTPClient *http.Client // Client used for requests.
+}
+
+// GetItem looks up the vault and item by name and extracts the AWS secrets.
+func (c *Connect) GetItem(ctx context.Context, vault, item string) (Item, error) {
+	if vault == "" {
+		return Item{}, fmt.Errorf("a vault is required when using 1Password Connect")
+	}
+	if c.Token == "" {
+		return Item{}, fmt.Errorf("OP_CONNECT_TOKEN must be set when using 1Password Connect")
+	}
+
+	slog.Debug("Reading 1Password item with Connect", "host", c.Host, "item", item, "vault", vault)
+	var vaults []rawItem
+	if err := c.get(ctx, "/v1/vaults?filter="+url.QueryEscape(fmt.Sprintf("name eq %q", vault)), &vaults); err != nil {
+		return Item{}, err
+	}
+	if len(vaults) != 1 {
+		return Item{}, fmt.Errorf("expected one vault named %q, found %d", vault, len(vaults))
+	}
+
+	var items []rawItem
+	if err := c.get(ctx, "/v1/vaults/"+vaults[0].ID+"/items?filter="+url.QueryEscape(fmt.Sprintf("title eq %q", item)), &items); err != nil {
+		return Item{}, err
+	}
+	if len(items) != 1 {
+		return Item{}, fmt.Errorf("expected one item titled %q, found %d", item, len(items))
+	}
+
+	var raw rawItem
+	if err := c.get(ctx, "/v1/vaults/"+vaults[0].ID+"/items/"+items[0].ID, &raw); err != nil {
+		return Item{}, err
+	}
+
+	return parseItem(item, raw)
+}
+
+// get performs an authenticated GET against the Connect API and decodes the JSON response.
+func (c *Connect) get(ctx context.Context, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(c.Host, "/")+path, nil)
+	if err != nil {
+		return fmt.Errorf("failed to build Connect request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("connect request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("connect request failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to parse Connect response: %w", err)
+	}
+
+	return nil
+}
+
+// parseItem extracts the access key pair and one-time password from an item's fields.
+func parseItem(name string, raw rawItem) (Item, error) {
+	var item Item
+	for _, f := range raw.Fields {
+		label := normalizeLabel(f.Label)
+		switch {
+		case f.Type == "OTP":
+			item.TOTP = secret.Value(f.TOTP)
+		case slices.Contains(accessKeyLabels, label):
+			item.AccessKeyID = f.Value
+		case slices.Contains(secretKeyLabels, label):
+			item.SecretAccessKey = secret.Value(f.Value)
+		}
+	}
+
+	if item.AccessKeyID == "" || item.SecretAccessKey == "" {
+		return Item{}, fmt.Errorf("1Password item %q must have \"access key id\" and \"secret access key\" fields", name)
+	}
+
+	return item, nil
+}
+
+// normalizeLabel lowercases a label and drops everything but letters and digits, so
+// "Access Key ID", "access_key_id" and "AccessKeyId" all compare equal.
+func normalizeLabel(label string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, label)
+}
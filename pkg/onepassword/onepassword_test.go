@@ -0,0 +1,108 @@
+package onepassword
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const itemJSON = `{
+  "id": "item-id",
+  "fields": [
+    {"type": "STRING", "label": "Access Key ID", "value": "AKIAEXAMPLE"},
+    {"type": "CONCEALED", "label": "secret_access_key", "value": "secretExample"},
+    {"type": "OTP", "label": "one-time password", "value": "otpauth://totp/aws", "totp": "123456"},
+    {"type": "STRING", "label": "notes", "value": "ignored"}
+  ]
+}`
+
+func TestParseItem(t *testing.T) {
+	t.Run("Extracts keys and TOTP", func(t *testing.T) {
+		item, err := parseItem("aws", rawItem{Fields: []field{
+			{Type: "STRING", Label: "AWS Access Key ID", Value: "AKIAEXAMPLE"},
+			{Type: "CONCEALED", Label: "SecretAccessKey", Value: "secretExample"},
+			{Type: "OTP", Label: "otp", TOTP: "123456"},
+		}})
+		assert.NoError(t, err)
+		assert.Equal(t, Item{AccessKeyID: "AKIAEXAMPLE", SecretAccessKey: "secretExample", TOTP: "123456"}, item)
+	})
+
+	t.Run("Requires the key pair", func(t *testing.T) {
+		_, err := parseItem("aws", rawItem{Fields: []field{{Type: "OTP", TOTP: "123456"}}})
+		assert.ErrorContains(t, err, `1Password item "aws" must have`)
+	})
+}
+
+func TestCLIGetItem(t *testing.T) {
+	// Fake op binary that records its arguments and prints a canned item
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "args")
+	script := "#!/bin/sh\necho \"$@\" > " + argsFile + "\ncat <<'EOF'\n" + itemJSON + "\nEOF\n"
+	opPath := filepath.Join(dir, "op")
+	assert.NoError(t, os.WriteFile(opPath, []byte(script), 0o755))
+
+	client := &CLI{Path: opPath}
+	item, err := client.GetItem(context.TODO(), "Private", "aws")
+	assert.NoError(t, err)
+	assert.Equal(t, Item{AccessKeyID: "AKIAEXAMPLE", SecretAccessKey: "secretExample", TOTP: "123456"}, item)
+
+	args, err := os.ReadFile(argsFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "item get aws --format json --vault Private\n", string(args))
+}
+
+func TestCLIGetItemFailure(t *testing.T) {
+	dir := t.TempDir()
+	opPath := filepath.Join(dir, "op")
+	assert.NoError(t, os.WriteFile(opPath, []byte("#!/bin/sh\necho 'not signed in' >&2\nexit 1\n"), 0o755))
+
+	client := &CLI{Path: opPath}
+	_, err := client.GetItem(context.TODO(), "", "aws")
+	assert.ErrorContains(t, err, "not signed in")
+}
+
+func TestConnectGetItem(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.URL.Path == "/v1/vaults" && r.URL.Query().Get("filter") == `name eq "Private"`:
+			_, _ = w.Write([]byte(`[{"id": "vault-id"}]`))
+		case r.URL.Path == "/v1/vaults/vault-id/items" && r.URL.Query().Get("filter") == `title eq "aws"`:
+			_, _ = w.Write([]byte(`[{"id": "item-id"}]`))
+		case r.URL.Path == "/v1/vaults/vault-id/items/item-id":
+			_, _ = w.Write([]byte(itemJSON))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	t.Run("Reads the item", func(t *testing.T) {
+		client := New(server.URL, "test-token")
+		item, err := client.GetItem(context.TODO(), "Private", "aws")
+		assert.NoError(t, err)
+		assert.Equal(t, Item{AccessKeyID: "AKIAEXAMPLE", SecretAccessKey: "secretExample", TOTP: "123456"}, item)
+	})
+
+	t.Run("Reports HTTP errors", func(t *testing.T) {
+		client := New(server.URL, "wrong-token")
+		_, err := client.GetItem(context.TODO(), "Private", "aws")
+		assert.ErrorContains(t, err, "status 401")
+	})
+
+	t.Run("Requires a vault and token", func(t *testing.T) {
+		_, err := New(server.URL, "test-token").GetItem(context.TODO(), "", "aws")
+		assert.ErrorContains(t, err, "vault is required")
+
+		_, err = New(server.URL, "").GetItem(context.TODO(), "Private", "aws")
+		assert.ErrorContains(t, err, "OP_CONNECT_TOKEN")
+	})
+}
@@ -0,0 +1,85 @@
+// Package progress draws a spinner on the terminal while gredentures waits on STS or IAM, so
+// a slow call over a VPN does not look like the tool has hung. Callers decide whether a
+// spinner is wanted; Enabled reports whether a stream is a terminal one can be drawn on.
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"gredentures/pkg/interrupt"
+
+	"golang.org/x/term"
+)
+
+// interval is the time between frames. Calls that finish within it never draw anything.
+const interval = 120 * time.Millisecond
+
+// frames are drawn in turn in front of the message.
+var frames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// clearLine returns the cursor to the start of the line and erases it.
+const clearLine = "\r\033[K"
+
+// Spinner animates a message on a terminal until Stop is called. A nil *Spinner is valid
+// and does nothing, so disabled spinners need no special casing.
+type Spinner struct {
+	out     io.Writer
+	message string
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+	forget  func()
+}
+
+// Enabled reports whether a spinner can be drawn on out: it must be a terminal that
+// understands cursor movement.
+func Enabled(out *os.File) bool {
+	return term.IsTerminal(int(out.Fd())) && os.Getenv("TERM") != "dumb"
+}
+
+// Start draws message with a spinner on out until Stop is called.
+func Start(out io.Writer, message string) *Spinner {
+	s := &Spinner{out: out, message: message, stop: make(chan struct{}), done: make(chan struct{})}
+	// Do not leave a half-drawn line behind when the run is interrupted
+	s.forget = interrupt.OnInterrupt(s.Stop)
+	go s.run()
+	return s
+}
+
+// run draws a frame every interval until stopped, then clears the line if it drew anything.
+func (s *Spinner) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	drawn := false
+	for frame := 0; ; frame++ {
+		select {
+		case <-ticker.C:
+			fmt.Fprintf(s.out, "%s%s %s", clearLine, frames[frame%len(frames)], s.message)
+			drawn = true
+		case <-s.stop:
+			if drawn {
+				fmt.Fprint(s.out, clearLine)
+			}
+			return
+		}
+	}
+}
+
+// Stop removes the spinner and waits until its line has been cleared, so whatever the caller
+// prints next starts on a clean line. Calling it more than once is safe.
+func (s *Spinner) Stop() {
+	if s == nil {
+		return
+	}
+	s.once.Do(func() {
+		close(s.stop)
+		<-s.done
+		s.forget()
+	})
+}
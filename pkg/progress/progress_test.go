@@ -0,0 +1,64 @@
+package progress
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// syncBuffer is a bytes.Buffer safe for the spinner goroutine to write to.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestSpinner(t *testing.T) {
+	t.Run("Draws frames and clears the line", func(t *testing.T) {
+		var out syncBuffer
+		s := Start(&out, "Requesting session token")
+		time.Sleep(3 * interval)
+		s.Stop()
+
+		drawn := out.String()
+		assert.Contains(t, drawn, frames[0]+" Requesting session token")
+		assert.True(t, strings.HasSuffix(drawn, clearLine), "the line is cleared on stop")
+
+		s.Stop()
+		assert.Equal(t, drawn, out.String(), "stopping again draws nothing")
+	})
+
+	t.Run("Fast calls draw nothing", func(t *testing.T) {
+		var out syncBuffer
+		Start(&out, "Requesting session token").Stop()
+		assert.Empty(t, out.String())
+	})
+
+	t.Run("Nil spinner", func(t *testing.T) {
+		var s *Spinner
+		assert.NotPanics(t, s.Stop)
+	})
+}
+
+func TestEnabled(t *testing.T) {
+	file, err := os.CreateTemp(t.TempDir(), "out")
+	assert.NoError(t, err)
+	defer file.Close()
+	assert.False(t, Enabled(file), "files and pipes get no spinner")
+}
@@ -0,0 +1,252 @@
+// Package prompt asks the user for input such as MFA codes and access keys. Every prompt goes
+// through the Prompter interface, so the same command works on a terminal, with answers piped
+// on stdin by a script, and from GUI contexts like IDE tasks that have no terminal at all,
+// where a zenity or osascript dialog, or any other command, asks instead.
+package prompt
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"gredentures/pkg/interrupt"
+	"gredentures/pkg/secret"
+
+	"golang.org/x/term"
+)
+
+// Prompt kinds accepted by New. Any other value is run as a shell command, see Shell.
+const (
+	KindTTY       = "tty"
+	KindStdin     = "stdin"
+	KindZenity    = "zenity"
+	KindOSAScript = "osascript"
+)
+
+// Environment passed to Shell prompt commands.
+const (
+	EnvQuestion = "GREDENTURES_PROMPT"
+	EnvHidden   = "GREDENTURES_PROMPT_HIDDEN"
+)
+
+// dialogTitle is the window title of zenity and osascript dialogs.
+const dialogTitle = "gredentures"
+
+// ErrCancelled is returned when the user dismisses a dialog or a prompt command fails.
+var ErrCancelled = errors.New("prompt cancelled")
+
+// Prompter asks the user for input.
+type Prompter interface {
+	// Ask returns the answer to question, shown as it is typed.
+	Ask(question string) (string, error)
+	// AskSecret returns the answer to question without showing it.
+	AskSecret(question string) (secret.Value, error)
+}
+
+// New returns the Prompter for kind. An empty kind picks one with Detect, which may return
+// nil when there is no way to ask.
+func New(kind string) (Prompter, error) {
+	switch kind {
+	case "":
+		return Detect(), nil
+	case KindTTY:
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			return nil, fmt.Errorf("the %s prompt requires stdin to be a terminal", KindTTY)
+		}
+		return NewTTY(os.Stdin, os.Stderr), nil
+	case KindStdin:
+		return NewScript(os.Stdin), nil
+	case KindZenity:
+		return Zenity(), nil
+	case KindOSAScript:
+		return OSAScript(), nil
+	}
+	return Shell(kind), nil
+}
+
+// Detect returns a TTY prompter when stdin is a terminal, otherwise a dialog when a desktop
+// session is available: osascript on macOS and zenity under X11 or Wayland. It returns nil
+// when neither is, e.g. in CI, so callers fail instead of waiting for input nobody can give.
+func Detect() Prompter {
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		return NewTTY(os.Stdin, os.Stderr)
+	}
+	switch {
+	case runtime.GOOS == "darwin" && available("osascript"):
+		return OSAScript()
+	case (os.Getenv("DISPLAY") != "" || os.Getenv("WAYLAND_DISPLAY") != "") && available("zenity"):
+		return Zenity()
+	}
+	return nil
+}
+
+// available reports whether name is on the PATH.
+func available(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
+
+// TTY prompts on a terminal, writing questions to out and hiding secrets as they are typed.
+type TTY struct {
+	in     *os.File
+	reader *bufio.Reader
+	out    io.Writer
+}
+
+// NewTTY returns a Prompter reading answers from the terminal in and asking on out.
+func NewTTY(in *os.File, out io.Writer) *TTY {
+	return &TTY{in: in, reader: bufio.NewReader(in), out: out}
+}
+
+// Ask implements Prompter.
+func (t *TTY) Ask(question string) (string, error) {
+	fmt.Fprint(t.out, question)
+	line, err := t.reader.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("failed to read answer: %w", err)
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// AskSecret implements Prompter. Interrupting the prompt would otherwise leave the terminal
+// with echo turned off, so its original state is restored first.
+func (t *TTY) AskSecret(question string) (secret.Value, error) {
+	fd := int(t.in.Fd())
+	if state, err := term.GetState(fd); err == nil {
+		defer interrupt.OnInterrupt(func() { term.Restore(fd, state) })()
+	}
+
+	fmt.Fprint(t.out, question)
+	answer, err := term.ReadPassword(fd)
+	fmt.Fprintln(t.out)
+	if err != nil {
+		return "", fmt.Errorf("failed to read answer: %w", err)
+	}
+	return secret.Value(strings.TrimSpace(string(answer))), nil
+}
+
+// Script answers prompts from a stream, one line per prompt in the order they are asked,
+// for scripts that pipe the answers on stdin. Questions are not written anywhere.
+type Script struct {
+	reader *bufio.Reader
+}
+
+// NewScript returns a Prompter reading answers from r.
+func NewScript(r io.Reader) *Script {
+	return &Script{reader: bufio.NewReader(r)}
+}
+
+// Ask implements Prompter.
+func (s *Script) Ask(question string) (string, error) {
+	slog.Debug("Reading answer from stdin", "question", question)
+	line, err := s.reader.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", fmt.Errorf("no answer for %q: %w", strings.TrimSpace(question), err)
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// AskSecret implements Prompter.
+func (s *Script) AskSecret(question string) (secret.Value, error) {
+	answer, err := s.Ask(question)
+	return secret.Value(answer), err
+}
+
+// Command asks by running an external program, such as a dialog, that prints the answer on
+// stdout. A non-zero exit status means the user cancelled.
+type Command struct {
+	name string                                      // Shown in errors.
+	argv func(question string, hidden bool) []string // Program and arguments to run.
+	env  func(question string, hidden bool) []string // Extra environment, nil for none.
+}
+
+// Zenity returns a Prompter showing GTK entry dialogs with zenity.
+func Zenity() *Command {
+	return &Command{name: "zenity", argv: func(question string, hidden bool) []string {
+		args := []string{"zenity", "--entry", "--title", dialogTitle, "--text", question}
+		if hidden {
+			args = append(args, "--hide-text")
+		}
+		return args
+	}}
+}
+
+// OSAScript returns a Prompter showing macOS dialogs with osascript.
+func OSAScript() *Command {
+	return &Command{name: "osascript", argv: func(question string, hidden bool) []string {
+		script := fmt.Sprintf(`text returned of (display dialog %s default answer "" with title %s`, appleString(question), appleString(dialogTitle))
+		if hidden {
+			script += " with hidden answer"
+		}
+		return []string{"osascript", "-e", script + ")"}
+	}}
+}
+
+// Shell returns a Prompter running command through the shell, with the question in
+// GREDENTURES_PROMPT and GREDENTURES_PROMPT_HIDDEN set to 1 for secrets.
+func Shell(command string) *Command {
+	return &Command{
+		name: command,
+		argv: func(string, bool) []string { return []string{"sh", "-c", command} },
+		env: func(question string, hidden bool) []string {
+			env := []string{EnvQuestion + "=" + question}
+			if hidden {
+				env = append(env, EnvHidden+"=1")
+			}
+			return env
+		},
+	}
+}
+
+// appleString quotes text as an AppleScript string literal.
+func appleString(text string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(text) + `"`
+}
+
+// Ask implements Prompter.
+func (c *Command) Ask(question string) (string, error) {
+	return c.run(question, false)
+}
+
+// AskSecret implements Prompter.
+func (c *Command) AskSecret(question string) (secret.Value, error) {
+	answer, err := c.run(question, true)
+	return secret.Value(answer), err
+}
+
+// run asks question with the command and returns the first line it prints.
+func (c *Command) run(question string, hidden bool) (string, error) {
+	// Dialogs show the question on its own, without the trailing colon of terminal prompts
+	question = strings.TrimSuffix(strings.TrimSpace(question), ":")
+
+	argv := c.argv(question, hidden)
+	cmd := exec.CommandContext(interrupt.Context(), argv[0], argv[1:]...)
+	if c.env != nil {
+		cmd.Env = append(os.Environ(), c.env(question, hidden)...)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	slog.Debug("Running prompt command", "command", c.name, "question", question)
+	out, err := cmd.Output()
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return "", fmt.Errorf("%w: %s: %s", ErrCancelled, c.name, message)
+		}
+		return "", fmt.Errorf("%w: %s exited with status %d", ErrCancelled, c.name, exitErr.ExitCode())
+	case err != nil:
+		return "", fmt.Errorf("failed to run prompt command %s: %w", c.name, err)
+	}
+
+	answer, _, _ := strings.Cut(string(out), "\n")
+	return strings.TrimSpace(answer), nil
+}
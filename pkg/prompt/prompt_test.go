@@ -0,0 +1,91 @@
+package prompt
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"gredentures/pkg/secret"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScript(t *testing.T) {
+	p := NewScript(strings.NewReader("AKIAEXAMPLE\n secret-key \n123456"))
+
+	answer, err := p.Ask("AWS Access Key ID: ")
+	assert.NoError(t, err)
+	assert.Equal(t, "AKIAEXAMPLE", answer)
+
+	key, err := p.AskSecret("AWS Secret Access Key: ")
+	assert.NoError(t, err)
+	assert.Equal(t, secret.Value("secret-key"), key)
+
+	code, err := p.AskSecret("MFA code: ")
+	assert.NoError(t, err, "the last answer needs no newline")
+	assert.Equal(t, secret.Value("123456"), code)
+
+	_, err = p.Ask("First code: ")
+	assert.ErrorContains(t, err, `no answer for "First code:"`)
+}
+
+func TestTTY(t *testing.T) {
+	in, w, err := os.Pipe()
+	assert.NoError(t, err)
+	defer in.Close()
+	_, err = w.WriteString("AKIAEXAMPLE\n")
+	assert.NoError(t, err)
+	w.Close()
+
+	var out bytes.Buffer
+	answer, err := NewTTY(in, &out).Ask("AWS Access Key ID: ")
+	assert.NoError(t, err)
+	assert.Equal(t, "AKIAEXAMPLE", answer)
+	assert.Equal(t, "AWS Access Key ID: ", out.String())
+}
+
+func TestShell(t *testing.T) {
+	t.Run("Passes the question and reads the first line", func(t *testing.T) {
+		p := Shell(`printf '%s|%s\nignored\n' "$GREDENTURES_PROMPT" "$GREDENTURES_PROMPT_HIDDEN"`)
+
+		answer, err := p.Ask("AWS Access Key ID: ")
+		assert.NoError(t, err)
+		assert.Equal(t, "AWS Access Key ID|", answer)
+
+		code, err := p.AskSecret("MFA code: ")
+		assert.NoError(t, err)
+		assert.Equal(t, secret.Value("MFA code|1"), code)
+	})
+
+	t.Run("Failing commands cancel", func(t *testing.T) {
+		_, err := Shell("echo dismissed >&2; exit 1").Ask("MFA code: ")
+		assert.ErrorIs(t, err, ErrCancelled)
+		assert.ErrorContains(t, err, "dismissed")
+
+		_, err = Shell("exit 5").Ask("MFA code: ")
+		assert.ErrorContains(t, err, "exited with status 5")
+	})
+}
+
+func TestDialogArgs(t *testing.T) {
+	assert.Equal(t, []string{"zenity", "--entry", "--title", "gredentures", "--text", "MFA code", "--hide-text"}, Zenity().argv("MFA code", true))
+	assert.Equal(t, []string{"zenity", "--entry", "--title", "gredentures", "--text", "First code"}, Zenity().argv("First code", false))
+
+	assert.Equal(t, []string{"osascript", "-e", `text returned of (display dialog "Say \"hi\" \\ bye" default answer "" with title "gredentures" with hidden answer)`},
+		OSAScript().argv(`Say "hi" \ bye`, true))
+}
+
+func TestNew(t *testing.T) {
+	p, err := New(KindStdin)
+	assert.NoError(t, err)
+	assert.IsType(t, &Script{}, p)
+
+	p, err = New(KindZenity)
+	assert.NoError(t, err)
+	assert.Equal(t, "zenity", p.(*Command).name)
+
+	p, err = New("my-askpass")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"sh", "-c", "my-askpass"}, p.(*Command).argv("MFA code", true))
+}
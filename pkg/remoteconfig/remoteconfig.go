@@ -0,0 +1,254 @@
+// Package remoteconfig fetches gredentures config files published over HTTPS, so a platform
+// team can maintain the canonical role and account map in one place and every laptop stays
+// current. Each file must be signed: a detached Ed25519 signature, base64 encoded, is published
+// next to it with a .sig suffix and has to match one of the trusted public keys. Verified
+// files are cached with their ETag, so unchanged files are not downloaded again and the cached
+// copy keeps working while the server cannot be reached.
+package remoteconfig
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gredentures/pkg/interrupt"
+	"gredentures/pkg/sysuser"
+)
+
+// SignatureSuffix is appended to a config URL to find its detached signature.
+const SignatureSuffix = ".sig"
+
+// FetchTimeout bounds each request made for a remote config.
+const FetchTimeout = 10 * time.Second
+
+// maxConfigSize bounds how much of a response is read, config files are small.
+const maxConfigSize = 1 << 20
+
+// IsRemote reports whether a config location is a URL rather than a local path.
+func IsRemote(location string) bool {
+	return strings.HasPrefix(location, "https://") || strings.HasPrefix(location, "http://")
+}
+
+// DefaultCacheDir returns the directory remote configs are cached in,
+// $XDG_CACHE_HOME/gredentures/config, falling back to ~/.cache when the variable is unset.
+func DefaultCacheDir() string {
+	dir := os.Getenv("XDG_CACHE_HOME")
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(sysuser.Home(), ".cache")
+	}
+	return filepath.Join(dir, "gredentures", "config")
+}
+
+// ParseKeys decodes base64 encoded Ed25519 public keys.
+func ParseKeys(encoded []string) ([]ed25519.PublicKey, error) {
+	keys := make([]ed25519.PublicKey, 0, len(encoded))
+	for _, value := range encoded {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key %q: %w", value, err)
+		}
+		if len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid public key %q: expected %d bytes, got %d", value, ed25519.PublicKeySize, len(key))
+		}
+		keys = append(keys, ed25519.PublicKey(key))
+	}
+	return keys, nil
+}
+
+// Fetcher downloads, verifies and caches remote config files.
+type Fetcher struct {
+	Client   *http.Client        // Client used for requests.
+	CacheDir string              // Directory holding one cache entry per URL.
+	Keys     []ed25519.PublicKey // Keys trusted to sign config files.
+	Offline  bool                // Only use the cached copies, never contacting the server.
+}
+
+// New returns a Fetcher trusting keys and caching in DefaultCacheDir.
+func New(keys []ed25519.PublicKey) *Fetcher {
+	return &Fetcher{Client: &http.Client{Timeout: FetchTimeout}, CacheDir: DefaultCacheDir(), Keys: keys}
+}
+
+// entry is a verified config file as stored in the cache.
+type entry struct {
+	URL       string `json:"url"`
+	ETag      string `json:"etag"`
+	Data      []byte `json:"data"`
+	Signature []byte `json:"signature"`
+}
+
+// Fetch returns the verified contents of the config file at url. The cached copy is revalidated
+// with its ETag, and used as it is when the server cannot be reached. An Offline fetcher
+// returns the cached copy without revalidating it.
+func (f *Fetcher) Fetch(ctx context.Context, url string) ([]byte, error) {
+	if !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("remote config %s must be fetched over https", url)
+	}
+	if len(f.Keys) == 0 {
+		return nil, fmt.Errorf("no public key is trusted to verify remote config %s", url)
+	}
+
+	cached := f.readCache(url)
+	fetched := cached
+	switch {
+	case f.Offline && cached == nil:
+		return nil, fmt.Errorf("remote config %s is not cached", url)
+	case !f.Offline:
+		var err error
+		if fetched, err = f.download(ctx, url, cached); err != nil {
+			if cached == nil {
+				return nil, err
+			}
+			slog.Warn("Failed to fetch remote config, using the cached copy", "url", url, "error", err)
+			fetched = cached
+		}
+	}
+
+	if err := f.verify(fetched); err != nil {
+		return nil, fmt.Errorf("remote config %s: %w", url, err)
+	}
+	if fetched != cached {
+		if err := f.writeCache(fetched); err != nil {
+			slog.Warn("Failed to cache remote config", "url", url, "error", err)
+		}
+	}
+	return fetched.Data, nil
+}
+
+// download fetches the config file and its signature, returning cached as it is when the
+// server reports that it has not changed.
+func (f *Fetcher) download(ctx context.Context, url string, cached *entry) (*entry, error) {
+	header := http.Header{}
+	if cached != nil && cached.ETag != "" {
+		header.Set("If-None-Match", cached.ETag)
+	}
+	resp, err := f.get(ctx, url, header)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		slog.Debug("Remote config not modified", "url", url)
+		return cached, nil
+	}
+	data, err := readBody(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	sigResp, err := f.get(ctx, url+SignatureSuffix, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer sigResp.Body.Close()
+	encoded, err := readBody(sigResp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch signature: %w", err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(encoded)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature for remote config %s: %w", url, err)
+	}
+
+	slog.Debug("Downloaded remote config", "url", url, "etag", resp.Header.Get("ETag"))
+	return &entry{URL: url, ETag: resp.Header.Get("ETag"), Data: data, Signature: signature}, nil
+}
+
+// get performs a GET request with the given headers.
+func (f *Fetcher) get(ctx context.Context, url string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request for %s: %w", url, err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request for %s failed: %w", url, err)
+	}
+	return resp, nil
+}
+
+// readBody reads a successful response body.
+func readBody(resp *http.Response) ([]byte, error) {
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("request for %s failed with status %d", resp.Request.URL, resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxConfigSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", resp.Request.URL, err)
+	}
+	if len(data) > maxConfigSize {
+		return nil, fmt.Errorf("%s is larger than %d bytes", resp.Request.URL, maxConfigSize)
+	}
+	return data, nil
+}
+
+// verify checks the signature against every trusted key.
+func (f *Fetcher) verify(e *entry) error {
+	for _, key := range f.Keys {
+		if ed25519.Verify(key, e.Data, e.Signature) {
+			return nil
+		}
+	}
+	return errors.New("signature does not match any trusted public key")
+}
+
+// cachePath returns the cache file for url.
+func (f *Fetcher) cachePath(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(f.CacheDir, hex.EncodeToString(sum[:])+".json")
+}
+
+// readCache returns the cached entry for url, or nil when there is none.
+func (f *Fetcher) readCache(url string) *entry {
+	data, err := os.ReadFile(f.cachePath(url))
+	if err != nil {
+		return nil
+	}
+	var cached entry
+	if err := json.Unmarshal(data, &cached); err != nil || cached.URL != url {
+		slog.Debug("Ignoring unreadable remote config cache", "url", url, "error", err)
+		return nil
+	}
+	return &cached
+}
+
+// writeCache atomically replaces the cache entry, so a concurrent run never reads half of it.
+func (f *Fetcher) writeCache(e *entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(f.CacheDir, 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(f.CacheDir, ".remote-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer interrupt.OnInterrupt(func() { os.Remove(tmp.Name()) })()
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.cachePath(e.URL))
+}
@@ -0,0 +1,163 @@
+package remoteconfig
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testServer publishes a signed config and counts the full downloads it serves.
+type testServer struct {
+	*httptest.Server
+	config    []byte
+	signature string
+	downloads atomic.Int32
+	down      atomic.Bool
+}
+
+func newTestServer(t *testing.T, key ed25519.PrivateKey, config string) *testServer {
+	s := &testServer{config: []byte(config), signature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, []byte(config)))}
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		switch r.URL.Path {
+		case "/config.yml":
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			s.downloads.Add(1)
+			w.Write(s.config)
+		case "/config.yml" + SignatureSuffix:
+			w.Write([]byte(s.signature + "\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func newTestFetcher(t *testing.T, s *testServer, keys ...ed25519.PublicKey) *Fetcher {
+	return &Fetcher{Client: s.Client(), CacheDir: t.TempDir(), Keys: keys}
+}
+
+func TestIsRemote(t *testing.T) {
+	assert.True(t, IsRemote("https://example.com/config.yml"))
+	assert.True(t, IsRemote("http://example.com/config.yml"))
+	assert.False(t, IsRemote("/etc/gredentures/config.yml"))
+	assert.False(t, IsRemote("config.yml"))
+}
+
+func TestParseKeys(t *testing.T) {
+	public, _, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+
+	keys, err := ParseKeys([]string{base64.StdEncoding.EncodeToString(public)})
+	assert.NoError(t, err)
+	assert.Equal(t, []ed25519.PublicKey{public}, keys)
+
+	_, err = ParseKeys([]string{"not base64!"})
+	assert.ErrorContains(t, err, "invalid public key")
+	_, err = ParseKeys([]string{base64.StdEncoding.EncodeToString([]byte("short"))})
+	assert.ErrorContains(t, err, "expected 32 bytes, got 5")
+}
+
+func TestFetch(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	assert.NoError(t, err)
+	const config = "gredentures:\n  Org: platform\n"
+
+	t.Run("Verifies and caches with the ETag", func(t *testing.T) {
+		s := newTestServer(t, private, config)
+		f := newTestFetcher(t, s, public)
+
+		for range 2 {
+			data, err := f.Fetch(context.Background(), s.URL+"/config.yml")
+			assert.NoError(t, err)
+			assert.Equal(t, config, string(data))
+		}
+		assert.Equal(t, int32(1), s.downloads.Load(), "the second fetch is answered from the cache")
+	})
+
+	t.Run("Falls back to the cache when the server is down", func(t *testing.T) {
+		s := newTestServer(t, private, config)
+		f := newTestFetcher(t, s, public)
+		_, err := f.Fetch(context.Background(), s.URL+"/config.yml")
+		assert.NoError(t, err)
+
+		s.down.Store(true)
+		data, err := f.Fetch(context.Background(), s.URL+"/config.yml")
+		assert.NoError(t, err)
+		assert.Equal(t, config, string(data))
+	})
+
+	t.Run("Uses only the cache offline", func(t *testing.T) {
+		s := newTestServer(t, private, config)
+		f := newTestFetcher(t, s, public)
+		f.Offline = true
+		_, err := f.Fetch(context.Background(), s.URL+"/config.yml")
+		assert.ErrorContains(t, err, "is not cached")
+
+		f.Offline = false
+		_, err = f.Fetch(context.Background(), s.URL+"/config.yml")
+		assert.NoError(t, err)
+		f.Offline = true
+		data, err := f.Fetch(context.Background(), s.URL+"/config.yml")
+		assert.NoError(t, err)
+		assert.Equal(t, config, string(data))
+		assert.Equal(t, int32(1), s.downloads.Load())
+	})
+
+	t.Run("Fails without a cache when the server is down", func(t *testing.T) {
+		s := newTestServer(t, private, config)
+		s.down.Store(true)
+		_, err := newTestFetcher(t, s, public).Fetch(context.Background(), s.URL+"/config.yml")
+		assert.ErrorContains(t, err, "failed with status 503")
+	})
+
+	t.Run("Rejects an untrusted signature", func(t *testing.T) {
+		other, _, err := ed25519.GenerateKey(nil)
+		assert.NoError(t, err)
+		s := newTestServer(t, private, config)
+		f := newTestFetcher(t, s, other)
+
+		_, err = f.Fetch(context.Background(), s.URL+"/config.yml")
+		assert.ErrorContains(t, err, "signature does not match any trusted public key")
+		assert.Nil(t, f.readCache(s.URL+"/config.yml"), "unverified files are never cached")
+	})
+
+	t.Run("Rejects a tampered config", func(t *testing.T) {
+		s := newTestServer(t, private, config)
+		s.config = []byte("gredentures:\n  Org: attacker\n")
+		_, err := newTestFetcher(t, s, public).Fetch(context.Background(), s.URL+"/config.yml")
+		assert.ErrorContains(t, err, "signature does not match")
+	})
+
+	t.Run("Requires https and a trusted key", func(t *testing.T) {
+		f := &Fetcher{Keys: []ed25519.PublicKey{public}}
+		_, err := f.Fetch(context.Background(), "http://example.com/config.yml")
+		assert.ErrorContains(t, err, "must be fetched over https")
+
+		_, err = (&Fetcher{}).Fetch(context.Background(), "https://example.com/config.yml")
+		assert.ErrorContains(t, err, "no public key is trusted")
+	})
+}
+
+func TestDefaultCacheDir(t *testing.T) {
+	t.Setenv("HOME", "/home/test")
+	t.Setenv("XDG_CACHE_HOME", "")
+	assert.Equal(t, "/home/test/.cache/gredentures/config", DefaultCacheDir())
+
+	t.Setenv("XDG_CACHE_HOME", "/cache")
+	assert.Equal(t, "/cache/gredentures/config", DefaultCacheDir())
+}
@@ -0,0 +1,61 @@
+// Package secret holds sensitive strings such as MFA codes, secret access keys and session
+// tokens in a type that refuses to be printed. Formatting, logging or marshalling a Value
+// always yields a placeholder, so secrets cannot leak into logs, error messages or panics;
+// the plain string is only available through Reveal, where it is handed to AWS or written out.
+package secret
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// Redacted is printed in place of a non-empty secret.
+const Redacted = "<redacted>"
+
+// Value is a secret string. The zero value is the empty secret and prints as "".
+type Value string
+
+// Reveal returns the secret itself. Only call it where the secret is used, never to print it.
+func (v Value) Reveal() string {
+	return string(v)
+}
+
+// String implements fmt.Stringer, returning Redacted for any non-empty secret.
+func (v Value) String() string {
+	if v == "" {
+		return ""
+	}
+	return Redacted
+}
+
+// GoString implements fmt.GoStringer so %#v is redacted too.
+func (v Value) GoString() string {
+	return fmt.Sprintf("secret.Value(%q)", v.String())
+}
+
+// Format implements fmt.Formatter, so every verb, including %x and %q, prints the placeholder.
+func (v Value) Format(f fmt.State, verb rune) {
+	switch verb {
+	case 'q':
+		fmt.Fprintf(f, "%q", v.String())
+	case 'v':
+		if f.Flag('#') {
+			fmt.Fprint(f, v.GoString())
+			return
+		}
+		fmt.Fprint(f, v.String())
+	default:
+		fmt.Fprint(f, v.String())
+	}
+}
+
+// LogValue implements slog.LogValuer.
+func (v Value) LogValue() slog.Value {
+	return slog.StringValue(v.String())
+}
+
+// MarshalText implements encoding.TextMarshaler, so JSON, YAML and other encoders never see
+// the secret. Writers that must emit a secret use Reveal explicitly.
+func (v Value) MarshalText() ([]byte, error) {
+	return []byte(v.String()), nil
+}
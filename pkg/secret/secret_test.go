@@ -0,0 +1,53 @@
+package secret
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const plain = "123456"
+
+func TestValueFormatting(t *testing.T) {
+	v := Value(plain)
+	for _, format := range []string{"%v", "%+v", "%#v", "%s", "%q", "%x", "%X", "%d", "%10s"} {
+		t.Run(format, func(t *testing.T) {
+			assert.NotContains(t, fmt.Sprintf(format, v), plain)
+			assert.NotContains(t, fmt.Sprintf(format, struct{ Token Value }{v}), plain)
+		})
+	}
+	assert.Equal(t, Redacted, v.String())
+	assert.Equal(t, "", Value("").String())
+	assert.Equal(t, plain, v.Reveal())
+}
+
+func TestValueErrors(t *testing.T) {
+	err := fmt.Errorf("token %v rejected: %w", Value(plain), errors.New("invalid"))
+	assert.NotContains(t, err.Error(), plain)
+}
+
+func TestValuePanic(t *testing.T) {
+	defer func() {
+		assert.NotContains(t, fmt.Sprint(recover()), plain)
+	}()
+	panic(Value(plain))
+}
+
+func TestValueLogging(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	logger.Debug("token", "token", Value(plain), "any", any(Value(plain)))
+	assert.NotContains(t, buf.String(), plain)
+	assert.Contains(t, buf.String(), Redacted)
+}
+
+func TestValueMarshal(t *testing.T) {
+	data, err := json.Marshal(map[string]Value{"token": plain})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"token":"<redacted>"}`, string(data))
+}
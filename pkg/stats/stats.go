@@ -0,0 +1,177 @@
+// Package stats records opt-in, anonymous usage statistics for gredentures. Only the number
+// of times each command was run is kept: no account IDs, profile names, hostnames, user names
+// or timestamps. Counts are written to a local file and, when an endpoint is configured, each
+// run is also reported to it. Files collected from many users can be combined offline with
+// Aggregate.
+package stats
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"gredentures/pkg/interrupt"
+	"gredentures/pkg/sysuser"
+)
+
+// Commands lists the command names that may be recorded. Anything else is rejected so that
+// no free-form, potentially identifying, value ever ends up in a stats file.
+var Commands = []string{"login", "exec", "export", "k8s-exec", "agent", "json-rpc", "serve", "sessions", "accounts", "roles", "keys", "device", "doctor"}
+
+// reportTimeout bounds how long reporting a run may delay the command.
+const reportTimeout = 2 * time.Second
+
+// Counts maps command names to the number of times they were run.
+type Counts map[string]int64
+
+// file is the on-disk layout of a stats file.
+type file struct {
+	Commands Counts `json:"commands"`
+}
+
+// event is the body posted to the reporting endpoint for a single run.
+type event struct {
+	Command string `json:"command"`
+}
+
+// DefaultPath returns the stats file used when none is configured.
+func DefaultPath() string {
+	if dir := sysuser.StateDir(); dir != "" {
+		return filepath.Join(dir, "stats.json")
+	}
+	return filepath.Join(sysuser.Home(), ".gredentures-stats.json")
+}
+
+// Load reads the counts from a stats file. A missing file holds no counts.
+func Load(path string) (Counts, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Counts{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stats file: %w", err)
+	}
+
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse stats file %s: %w", path, err)
+	}
+	if f.Commands == nil {
+		f.Commands = Counts{}
+	}
+	return f.Commands, nil
+}
+
+// Record increments the count of command in the stats file, creating it if needed.
+func Record(path, command string) error {
+	if !slices.Contains(Commands, command) {
+		return fmt.Errorf("unknown command %q", command)
+	}
+
+	counts, err := Load(path)
+	if err != nil {
+		return err
+	}
+	counts[command]++
+
+	data, err := json.MarshalIndent(file{Commands: counts}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal stats: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".gredentures-stats-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // No-op once the rename has succeeded
+	defer interrupt.OnInterrupt(func() { os.Remove(tmp.Name()) })()
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := sysuser.Chown(tmp.Name()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary file: %w", err)
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Report posts a single run of command to endpoint. Only the command name is sent.
+func Report(ctx context.Context, client *http.Client, endpoint, command string) error {
+	if !slices.Contains(Commands, command) {
+		return fmt.Errorf("unknown command %q", command)
+	}
+
+	body, err := json.Marshal(event{Command: command})
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, reportTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build stats request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	slog.Debug("Reporting usage", "endpoint", endpoint, "command", command)
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("stats request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("stats request failed with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Aggregate sums the counts of several stats files, e.g. collected from every user in an org.
+func Aggregate(paths []string) (Counts, error) {
+	total := Counts{}
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("failed to read stats file: %w", err)
+		}
+		counts, err := Load(path)
+		if err != nil {
+			return nil, err
+		}
+		for command, count := range counts {
+			total[command] += count
+		}
+	}
+	return total, nil
+}
+
+// String formats the counts as one "command count" line per command, sorted by name.
+func (c Counts) String() string {
+	commands := make([]string, 0, len(c))
+	for command := range c {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+
+	var out strings.Builder
+	for _, command := range commands {
+		fmt.Fprintf(&out, "%-10s %d\n", command, c[command])
+	}
+	return out.String()
+}
@@ -0,0 +1,79 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.json")
+
+	assert.NoError(t, Record(path, "login"))
+	assert.NoError(t, Record(path, "login"))
+	assert.NoError(t, Record(path, "exec"))
+	assert.NoError(t, Record(path, "sessions"))
+	assert.ErrorContains(t, Record(path, "123456789012"), "unknown command")
+
+	counts, err := Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, Counts{"login": 2, "exec": 1, "sessions": 1}, counts)
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"commands": {"login": 2, "exec": 1, "sessions": 1}}`, string(data))
+}
+
+func TestLoadMissing(t *testing.T) {
+	counts, err := Load(filepath.Join(t.TempDir(), "missing.json"))
+	assert.NoError(t, err)
+	assert.Empty(t, counts)
+}
+
+func TestReport(t *testing.T) {
+	var received map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		body, _ := io.ReadAll(r.Body)
+		assert.NoError(t, json.Unmarshal(body, &received))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	assert.NoError(t, Report(context.TODO(), server.Client(), server.URL, "export"))
+	assert.Equal(t, map[string]any{"command": "export"}, received)
+
+	assert.ErrorContains(t, Report(context.TODO(), server.Client(), server.URL, "whoami"), "unknown command")
+}
+
+func TestReportFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	assert.ErrorContains(t, Report(context.TODO(), server.Client(), server.URL, "login"), "status 500")
+}
+
+func TestAggregate(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "alice.json")
+	second := filepath.Join(dir, "bob.json")
+	assert.NoError(t, os.WriteFile(first, []byte(`{"commands": {"login": 3, "exec": 1}}`), 0600))
+	assert.NoError(t, os.WriteFile(second, []byte(`{"commands": {"login": 2, "k8s-exec": 4}}`), 0600))
+
+	counts, err := Aggregate([]string{first, second})
+	assert.NoError(t, err)
+	assert.Equal(t, Counts{"login": 5, "exec": 1, "k8s-exec": 4}, counts)
+	assert.Equal(t, "exec       1\nk8s-exec   4\nlogin      5\n", counts.String())
+
+	_, err = Aggregate([]string{filepath.Join(dir, "missing.json")})
+	assert.ErrorContains(t, err, "failed to read stats file")
+}
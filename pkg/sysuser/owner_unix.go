@@ -0,0 +1,25 @@
+//go:build unix
+
+package sysuser
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// fileOwner returns the user ID owning path, and false when path does not exist.
+func fileOwner(path string) (int, bool, error) {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, fmt.Errorf("failed to check the owner of %s: %w", path, err)
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false, nil
+	}
+	return int(stat.Uid), true, nil
+}
@@ -0,0 +1,151 @@
+// Package sysuser identifies the user gredentures runs for, so that every user of a shared
+// host such as a jump box keeps their own files, also when gredentures runs under sudo. In
+// system mode the state gredentures keeps, such as the key file or the account cache, moves
+// from the home directory to a directory per user below a root like /var/lib/gredentures.
+package sysuser
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+)
+
+// DefaultStateRoot holds a state directory per user in system mode, unless
+// GREDENTURES_STATE_ROOT names another one.
+const DefaultStateRoot = "/var/lib/gredentures"
+
+// ErrForeignFile is returned for a file or state directory owned by another user.
+var ErrForeignFile = errors.New("owned by another user")
+
+// User is the user gredentures runs for.
+type User struct {
+	Name string // Login name.
+	Home string // Home directory, below which the credentials and config files are.
+	UID  int    // User ID files are checked against and, under sudo, handed to.
+	GID  int    // Primary group ID.
+	Sudo bool   // Running as root through sudo on behalf of the user.
+}
+
+// Replaced in tests.
+var (
+	geteuid = os.Geteuid
+	lookup  = user.Lookup
+	current = user.Current
+)
+
+// stateDir is the state directory of the invoking user in system mode, "" otherwise.
+var stateDir string
+
+// Invoking returns the user gredentures runs for. Under sudo that is the user who ran sudo,
+// found through SUDO_USER, with the home directory of their account: sudo may or may not keep
+// HOME, so root's files are never written in their place. Otherwise it is the current user,
+// with $HOME as the home directory.
+func Invoking() User {
+	if name := os.Getenv("SUDO_USER"); geteuid() == 0 && name != "" && name != "root" {
+		if u, err := lookup(name); err == nil {
+			uid, _ := strconv.Atoi(u.Uid)
+			gid, _ := strconv.Atoi(u.Gid)
+			return User{Name: u.Username, Home: u.HomeDir, UID: uid, GID: gid, Sudo: true}
+		}
+	}
+
+	invoking := User{Name: os.Getenv("USER"), Home: os.Getenv("HOME"), UID: os.Getuid(), GID: os.Getgid()}
+	if u, err := current(); err == nil {
+		invoking.Name = u.Username
+		if invoking.Home == "" {
+			invoking.Home = u.HomeDir
+		}
+	}
+	return invoking
+}
+
+// Home returns the home directory of the invoking user.
+func Home() string {
+	return Invoking().Home
+}
+
+// StateRoot returns the directory holding a state directory per user in system mode.
+func StateRoot() string {
+	if root := os.Getenv("GREDENTURES_STATE_ROOT"); root != "" {
+		return root
+	}
+	return DefaultStateRoot
+}
+
+// EnableSystemMode keeps the state of the invoking user in root/<user>, creating it readable
+// by the user only and handing it to them under sudo. A state directory that belongs to
+// another user is refused.
+func EnableSystemMode(root string) error {
+	invoking := Invoking()
+	if invoking.Name == "" {
+		return fmt.Errorf("cannot tell which user runs gredentures")
+	}
+	dir := filepath.Join(root, invoking.Name)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	if err := Chown(dir); err != nil {
+		return err
+	}
+	if err := CheckOwner(dir); err != nil {
+		return err
+	}
+	stateDir = dir
+	return nil
+}
+
+// StateDir returns the state directory of the invoking user in system mode, "" when state is
+// kept in the home directory.
+func StateDir() string {
+	return stateDir
+}
+
+// CheckOwner returns ErrForeignFile when path exists and belongs to another user than the
+// invoking one. A missing path, and platforms without file owners, pass.
+func CheckOwner(path string) error {
+	owner, ok, err := fileOwner(path)
+	if err != nil || !ok {
+		return err
+	}
+	if invoking := Invoking(); owner != invoking.UID {
+		return fmt.Errorf("%w: %s belongs to user ID %d, not %s (%d)", ErrForeignFile, path, owner, invoking.Name, invoking.UID)
+	}
+	return nil
+}
+
+// MkdirAll creates dir and any missing parents with perm like os.MkdirAll, and hands the
+// directories it created to the invoking user under sudo.
+func MkdirAll(dir string, perm os.FileMode) error {
+	var created []string
+	for missing := filepath.Clean(dir); ; missing = filepath.Dir(missing) {
+		if _, err := os.Lstat(missing); err == nil || missing == filepath.Dir(missing) {
+			break
+		}
+		created = append(created, missing)
+	}
+	if err := os.MkdirAll(dir, perm); err != nil {
+		return err
+	}
+	for _, path := range created {
+		if err := Chown(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Chown hands path to the invoking user when running under sudo, so the files gredentures
+// writes as root stay usable by them. Otherwise it does nothing.
+func Chown(path string) error {
+	invoking := Invoking()
+	if !invoking.Sudo {
+		return nil
+	}
+	if err := os.Lchown(path, invoking.UID, invoking.GID); err != nil {
+		return fmt.Errorf("failed to hand %s to %s: %w", path, invoking.Name, err)
+	}
+	return nil
+}
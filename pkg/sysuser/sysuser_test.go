@@ -0,0 +1,89 @@
+package sysuser
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSudo makes Invoking see a run as root through sudo by alice, user ID 4242.
+func fakeSudo(t *testing.T) {
+	t.Setenv("SUDO_USER", "alice")
+	geteuid = func() int { return 0 }
+	lookup = func(name string) (*user.User, error) {
+		return &user.User{Username: name, Uid: "4242", Gid: "4343", HomeDir: "/home/" + name}, nil
+	}
+	t.Cleanup(func() { geteuid, lookup = os.Geteuid, user.Lookup })
+}
+
+func TestInvoking(t *testing.T) {
+	t.Setenv("HOME", "/home/test")
+	t.Setenv("SUDO_USER", "")
+	invoking := Invoking()
+	assert.Equal(t, "/home/test", invoking.Home)
+	assert.Equal(t, os.Getuid(), invoking.UID)
+	assert.False(t, invoking.Sudo)
+
+	fakeSudo(t)
+	t.Setenv("HOME", "/root")
+	assert.Equal(t, User{Name: "alice", Home: "/home/alice", UID: 4242, GID: 4343, Sudo: true}, Invoking())
+	assert.Equal(t, "/home/alice", Home(), "a HOME kept by sudo is ignored")
+
+	t.Setenv("SUDO_USER", "root")
+	assert.False(t, Invoking().Sudo, "sudo from root runs for root")
+}
+
+func TestCheckOwner(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("files have no owner user ID on windows")
+	}
+	t.Setenv("SUDO_USER", "")
+	path := filepath.Join(t.TempDir(), "credentials")
+	assert.NoError(t, CheckOwner(path), "a missing file passes")
+	require.NoError(t, os.WriteFile(path, nil, 0o600))
+	assert.NoError(t, CheckOwner(path))
+
+	fakeSudo(t)
+	assert.ErrorIs(t, CheckOwner(path), ErrForeignFile, "the file is not alice's")
+}
+
+func TestChownUnderSudo(t *testing.T) {
+	if os.Geteuid() != 0 || runtime.GOOS == "windows" {
+		t.Skip("handing files to another user needs root")
+	}
+	fakeSudo(t)
+	dir := t.TempDir()
+	require.NoError(t, MkdirAll(filepath.Join(dir, ".aws", "sso"), 0o700))
+	for _, path := range []string{filepath.Join(dir, ".aws"), filepath.Join(dir, ".aws", "sso")} {
+		owner, _, err := fileOwner(path)
+		require.NoError(t, err)
+		assert.Equal(t, 4242, owner, "%s is handed to alice", path)
+	}
+	owner, _, err := fileOwner(dir)
+	require.NoError(t, err)
+	assert.Equal(t, 0, owner, "existing directories are left alone")
+	assert.NoError(t, CheckOwner(filepath.Join(dir, ".aws")))
+}
+
+func TestEnableSystemMode(t *testing.T) {
+	t.Setenv("SUDO_USER", "")
+	t.Setenv("USER", "tester")
+	root := t.TempDir()
+	t.Cleanup(func() { stateDir = "" })
+
+	assert.Empty(t, StateDir())
+	require.NoError(t, EnableSystemMode(root))
+	name := Invoking().Name
+	assert.Equal(t, filepath.Join(root, name), StateDir())
+	info, err := os.Stat(StateDir())
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o700), info.Mode().Perm())
+
+	t.Setenv("GREDENTURES_STATE_ROOT", root)
+	assert.Equal(t, root, StateRoot())
+}
@@ -0,0 +1,156 @@
+// Package ui renders the terminal output of every gredentures command in one style: errors,
+// warnings, hints and successes each in their own colour, and tables with aligned columns
+// under a bold header. Results go to stdout, while errors, warnings and hints go to stderr so
+// they never end up in piped output. Colour is only used on terminals, and never when
+// NO_COLOR is set (https://no-color.org) or TERM is dumb.
+package ui
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// Style is a text attribute applied with Paint.
+type Style string
+
+// Styles used across the commands, as ANSI SGR parameters.
+const (
+	Plain  Style = ""
+	Bold   Style = "1"
+	Dim    Style = "2"
+	Red    Style = "31"
+	Green  Style = "32"
+	Yellow Style = "33"
+	Cyan   Style = "36"
+)
+
+// columnGap separates table columns.
+const columnGap = "  "
+
+// escapes matches the SGR sequences written by Paint, which take no space on screen.
+var escapes = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// Printer writes styled output to a pair of streams.
+type Printer struct {
+	out, err           io.Writer
+	colorOut, colorErr bool
+}
+
+// New returns a Printer for out and err, using colour on each of them that is a terminal.
+func New(out, err *os.File) *Printer {
+	return &Printer{out: out, err: err, colorOut: ColorEnabled(out), colorErr: ColorEnabled(err)}
+}
+
+// NewPlain returns a Printer that never uses colour, for output that is not a terminal.
+func NewPlain(out, err io.Writer) *Printer {
+	return &Printer{out: out, err: err}
+}
+
+// ColorEnabled reports whether colour should be written to f.
+func ColorEnabled(f *os.File) bool {
+	return os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb" && term.IsTerminal(int(f.Fd()))
+}
+
+// Paint returns text in style for stdout, or text itself when stdout gets no colour.
+func (p *Printer) Paint(style Style, text string) string {
+	return paint(p.colorOut, style, text)
+}
+
+// paint wraps text in the SGR sequence for style when color is set.
+func paint(color bool, style Style, text string) string {
+	if !color || style == Plain || text == "" {
+		return text
+	}
+	return "\x1b[" + string(style) + "m" + text + "\x1b[0m"
+}
+
+// Printf writes plain text to stdout.
+func (p *Printer) Printf(format string, args ...any) {
+	fmt.Fprintf(p.out, format, args...)
+}
+
+// Successf reports a completed action on stdout.
+func (p *Printer) Successf(format string, args ...any) {
+	p.line(p.out, p.colorOut, Green, format, args...)
+}
+
+// Errorf reports a failure on stderr.
+func (p *Printer) Errorf(format string, args ...any) {
+	p.line(p.err, p.colorErr, Red, format, args...)
+}
+
+// Warnf reports a problem that does not stop the command on stderr.
+func (p *Printer) Warnf(format string, args ...any) {
+	p.line(p.err, p.colorErr, Yellow, format, args...)
+}
+
+// Hintf suggests how to fix the preceding error on stderr.
+func (p *Printer) Hintf(format string, args ...any) {
+	p.line(p.err, p.colorErr, Cyan, format, args...)
+}
+
+// Notef writes context about the command to stderr, such as where a result came from,
+// keeping stdout for the result itself.
+func (p *Printer) Notef(format string, args ...any) {
+	p.line(p.err, false, Plain, format, args...)
+}
+
+// line writes one styled line to w, ending it with a newline whether or not format does.
+func (p *Printer) line(w io.Writer, color bool, style Style, format string, args ...any) {
+	fmt.Fprintln(w, paint(color, style, strings.TrimSuffix(fmt.Sprintf(format, args...), "\n")))
+}
+
+// Table writes rows to stdout in aligned columns under a bold header. Cells may already be
+// painted, only their visible width counts towards the alignment. The last column is not
+// padded, so long values such as ARNs and paths do not leave trailing spaces.
+func (p *Printer) Table(header []string, rows [][]string) {
+	p.table(p.out, p.colorOut, header, rows)
+}
+
+// NoteTable writes rows like Table, but to stderr, for summaries that must stay out of the
+// results on stdout, such as exported credentials.
+func (p *Printer) NoteTable(header []string, rows [][]string) {
+	p.table(p.err, p.colorErr, header, rows)
+}
+
+// table writes rows to w in aligned columns under a bold header, see Table.
+func (p *Printer) table(w io.Writer, color bool, header []string, rows [][]string) {
+	widths := make([]int, len(header))
+	for _, row := range append([][]string{header}, rows...) {
+		for i, cell := range row {
+			if i < len(widths) {
+				widths[i] = max(widths[i], visibleWidth(cell))
+			}
+		}
+	}
+
+	writeRow := func(row []string, style Style) {
+		var line strings.Builder
+		for i, cell := range row {
+			if i > 0 {
+				line.WriteString(columnGap)
+			}
+			line.WriteString(paint(color, style, cell))
+			if i < len(row)-1 && i < len(widths) {
+				line.WriteString(strings.Repeat(" ", widths[i]-visibleWidth(cell)))
+			}
+		}
+		fmt.Fprintln(w, strings.TrimRight(line.String(), " "))
+	}
+
+	writeRow(header, Bold)
+	for _, row := range rows {
+		writeRow(row, Plain)
+	}
+}
+
+// visibleWidth returns the number of characters text takes on screen.
+func visibleWidth(text string) int {
+	return utf8.RuneCountInString(escapes.ReplaceAllString(text, ""))
+}
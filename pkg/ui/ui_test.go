@@ -0,0 +1,76 @@
+package ui
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrinter(t *testing.T) {
+	var out, errOut bytes.Buffer
+	p := NewPlain(&out, &errOut)
+
+	p.Printf("Gredentures CLI version: %s\n", "dev")
+	p.Successf("Wrote credentials to %s", "/tmp/credentials")
+	p.Errorf("Error reading stats: %v\n", "boom")
+	p.Warnf("Usage statistics are disabled")
+	p.Hintf("Pass the current MFA code with -t.")
+	p.Notef("Chosen from: %s", "XDG config")
+
+	assert.Equal(t, "Gredentures CLI version: dev\nWrote credentials to /tmp/credentials\n", out.String())
+	assert.Equal(t, "Error reading stats: boom\nUsage statistics are disabled\nPass the current MFA code with -t.\nChosen from: XDG config\n", errOut.String())
+}
+
+func TestColor(t *testing.T) {
+	var out, errOut bytes.Buffer
+	p := &Printer{out: &out, err: &errOut, colorOut: true, colorErr: true}
+
+	p.Successf("done")
+	p.Errorf("Error: %s", "failed")
+	assert.Equal(t, "\x1b[32mdone\x1b[0m\n", out.String())
+	assert.Equal(t, "\x1b[31mError: failed\x1b[0m\n", errOut.String())
+	assert.Equal(t, "\x1b[1mx\x1b[0m", p.Paint(Bold, "x"))
+	assert.Equal(t, "x", p.Paint(Plain, "x"))
+	assert.Equal(t, "x", NewPlain(&out, &errOut).Paint(Red, "x"))
+}
+
+func TestColorEnabled(t *testing.T) {
+	file, err := os.CreateTemp(t.TempDir(), "out")
+	assert.NoError(t, err)
+	defer file.Close()
+	assert.False(t, ColorEnabled(file), "files and pipes get no colour")
+
+	t.Setenv("NO_COLOR", "1")
+	assert.False(t, ColorEnabled(os.Stdout))
+}
+
+func TestTable(t *testing.T) {
+	t.Run("Aligns columns", func(t *testing.T) {
+		var out bytes.Buffer
+		NewPlain(&out, &out).Table([]string{"OPTION", "VALUE", "SOURCE"}, [][]string{
+			{"Org", "my-org", "config file"},
+			{"Timeout", "86400", "default"},
+		})
+		assert.Equal(t, "OPTION   VALUE   SOURCE\n"+
+			"Org      my-org  config file\n"+
+			"Timeout  86400   default\n", out.String())
+	})
+
+	t.Run("Ignores colour when aligning", func(t *testing.T) {
+		var out bytes.Buffer
+		p := &Printer{out: &out, colorOut: true}
+		p.Table([]string{"STATUS", "CHECK"}, [][]string{{p.Paint(Green, "ok"), "sts"}, {"", "-> fix it"}})
+		assert.Equal(t, "\x1b[1mSTATUS\x1b[0m  \x1b[1mCHECK\x1b[0m\n"+
+			"\x1b[32mok\x1b[0m      sts\n"+
+			"        -> fix it\n", out.String())
+	})
+
+	t.Run("Writes summaries to stderr", func(t *testing.T) {
+		var out, errOut bytes.Buffer
+		NewPlain(&out, &errOut).NoteTable([]string{"ORG", "RESULT"}, [][]string{{"prod", "ok"}})
+		assert.Empty(t, out.String())
+		assert.Equal(t, "ORG   RESULT\nprod  ok\n", errOut.String())
+	})
+}
@@ -0,0 +1,206 @@
+// Package validate checks option values with small rules that can be composed into the
+// checks of a command or of a program using gredentures as a library. Check runs every rule
+// and reports all failures together, so a user fixes every missing or malformed option in one
+// go instead of one per run.
+//
+// Each rule only looks at its own concern: RequiredString reports a missing value, the format
+// rules accept an empty one. Combine them to require a well-formed value.
+package validate
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+)
+
+// Rule checks one value, returning what is wrong with it or nil.
+type Rule interface {
+	Check() error
+}
+
+// Func turns a function into a Rule, for checks not covered by the rules of this package.
+type Func func() error
+
+// Check implements Rule.
+func (f Func) Check() error {
+	return f()
+}
+
+// When returns a Rule applying rules only when cond holds, e.g. checks that only matter for
+// one mode of a command.
+func When(cond bool, rules ...Rule) Rule {
+	return Func(func() error {
+		if !cond {
+			return nil
+		}
+		return Check(rules...)
+	})
+}
+
+// Errors holds every failure found by Check. It unwraps to the failures, so errors.Is and
+// errors.As find sentinels wrapped by any of them.
+type Errors []error
+
+// Error implements the error interface, listing one failure per line when there are several.
+func (e Errors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	lines := make([]string, 0, len(e)+1)
+	lines = append(lines, fmt.Sprintf("%d problems found:", len(e)))
+	for _, err := range e {
+		lines = append(lines, "  - "+err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Unwrap returns the failures, for errors.Is and errors.As.
+func (e Errors) Unwrap() []error {
+	return e
+}
+
+// Check runs every rule and returns their failures as Errors, or nil when all of them pass.
+// Failures of nested Check calls, e.g. through When, are flattened into one list.
+func Check(rules ...Rule) error {
+	var errs Errors
+	for _, rule := range rules {
+		err := rule.Check()
+		var nested Errors
+		switch {
+		case err == nil:
+		case errors.As(err, &nested):
+			errs = append(errs, nested...)
+		default:
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// failure returns problem as an error, wrapping sentinel when one is given.
+func failure(sentinel error, problem string) error {
+	if sentinel == nil {
+		return errors.New(problem)
+	}
+	return fmt.Errorf("%w: %s", sentinel, problem)
+}
+
+// RequiredString fails when Value is empty.
+type RequiredString struct {
+	Name  string // Name of the option, e.g. Org.
+	Value string
+	Where string // Where the option can be set, appended to the message when given.
+	Err   error  // Returned instead of the message when set, for callers branching on a sentinel.
+}
+
+// Check implements Rule.
+func (r RequiredString) Check() error {
+	if r.Value != "" {
+		return nil
+	}
+	if r.Err != nil {
+		return r.Err
+	}
+	problem := fmt.Sprintf("the %s must be set", r.Name)
+	if r.Where != "" {
+		problem += " " + r.Where
+	}
+	return errors.New(problem)
+}
+
+var (
+	// partitionPattern matches the AWS partitions, e.g. aws, aws-cn and aws-us-gov.
+	partitionPattern = regexp.MustCompile(`^aws[a-z-]*$`)
+	// accountPattern matches AWS account IDs.
+	accountPattern = regexp.MustCompile(`^\d{12}$`)
+	// namePattern matches the path and name of IAM resources.
+	namePattern = regexp.MustCompile(`^[\w+=,.@/-]+$`)
+)
+
+// ARNFormat fails when Value is not the ARN of a Resource of Service in an account, e.g. an
+// IAM role arn:aws:iam::123456789012:role/Admin. An empty Value passes.
+type ARNFormat struct {
+	Name     string // What the ARN names, e.g. "role" or "MFA device".
+	Value    string
+	Service  string // Service of the resource, e.g. iam.
+	Resource string // Resource type, e.g. role for role/<name>.
+	Regional bool   // The ARN includes a region, unlike the ARNs of global services such as IAM.
+	Err      error  // Wrapped by the failure when set.
+}
+
+// Check implements Rule.
+func (r ARNFormat) Check() error {
+	if r.Value == "" {
+		return nil
+	}
+	parsed, err := arn.Parse(r.Value)
+	name, found := strings.CutPrefix(parsed.Resource, r.Resource+"/")
+	if err != nil || !partitionPattern.MatchString(parsed.Partition) || parsed.Service != r.Service ||
+		(parsed.Region != "") != r.Regional || !accountPattern.MatchString(parsed.AccountID) ||
+		!found || !namePattern.MatchString(name) {
+		region := ""
+		if r.Regional {
+			region = "<region>"
+		}
+		return failure(r.Err, fmt.Sprintf("%q is not a valid %s ARN (expected arn:aws:%s:%s:<account-id>:%s/<name>)",
+			r.Value, r.Name, r.Service, region, r.Resource))
+	}
+	return nil
+}
+
+// DurationRange fails when Value lies outside Min and Max. A zero Value passes, it stands for
+// a duration that was not set and is left to the default of the service.
+type DurationRange struct {
+	Name  string // Name of the option, e.g. Timeout.
+	Value time.Duration
+	Min   time.Duration
+	Max   time.Duration
+	Err   error // Wrapped by the failure when set.
+}
+
+// Check implements Rule.
+func (r DurationRange) Check() error {
+	if r.Value == 0 || r.Value >= r.Min && r.Value <= r.Max {
+		return nil
+	}
+	return failure(r.Err, fmt.Sprintf("the %s of %s must be between %s and %s",
+		r.Name, formatDuration(r.Value), formatDuration(r.Min), formatDuration(r.Max)))
+}
+
+// formatDuration shortens durations to the units they use, e.g. 36h instead of 36h0m0s.
+func formatDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// tokenPattern matches the six digit codes of virtual and hardware MFA devices.
+var tokenPattern = regexp.MustCompile(`^\d{6}$`)
+
+// TokenFormat fails when Value is not a six digit MFA code. An empty Value passes. The
+// failure never contains the token.
+type TokenFormat struct {
+	Name  string // Name of the option, e.g. Token.
+	Value string
+	Err   error // Wrapped by the failure when set.
+}
+
+// Check implements Rule.
+func (r TokenFormat) Check() error {
+	if r.Value == "" || tokenPattern.MatchString(r.Value) {
+		return nil
+	}
+	return failure(r.Err, fmt.Sprintf("the %s must be the six digit code shown by the MFA device", r.Name))
+}
@@ -0,0 +1,113 @@
+package validate
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequiredString(t *testing.T) {
+	assert.NoError(t, RequiredString{Name: "Org", Value: "acme"}.Check())
+	assert.EqualError(t, RequiredString{Name: "Org"}.Check(), "the Org must be set")
+	assert.EqualError(t, RequiredString{Name: "Org", Where: "in the config file"}.Check(), "the Org must be set in the config file")
+
+	sentinel := errors.New("missing")
+	assert.Equal(t, sentinel, RequiredString{Name: "Token", Err: sentinel}.Check())
+}
+
+func TestARNFormat(t *testing.T) {
+	role := ARNFormat{Name: "role", Service: "iam", Resource: "role"}
+	for _, value := range []string{"", "arn:aws:iam::123456789012:role/Admin", "arn:aws-us-gov:iam::123456789012:role/path/Admin"} {
+		role.Value = value
+		assert.NoError(t, role.Check(), value)
+	}
+	for _, value := range []string{
+		"Admin",
+		"arn:aws:iam::123:role/Admin",
+		"arn:aws:iam::123456789012:user/Admin",
+		"arn:aws:iam::123456789012:role/",
+		"arn:aws:iam:us-east-1:123456789012:role/Admin",
+		"arn:aws:sts::123456789012:role/Admin",
+		"arn:other:iam::123456789012:role/Admin",
+	} {
+		role.Value = value
+		assert.ErrorContains(t, role.Check(), "is not a valid role ARN (expected arn:aws:iam::<account-id>:role/<name>)", value)
+	}
+
+	topic := ARNFormat{Name: "topic", Value: "arn:aws:sns:eu-west-1:123456789012:alerts/x", Service: "sns", Resource: "alerts", Regional: true}
+	assert.NoError(t, topic.Check())
+	topic.Value = "arn:aws:sns::123456789012:alerts/x"
+	assert.ErrorContains(t, topic.Check(), "arn:aws:sns:<region>:<account-id>:alerts/<name>")
+
+	sentinel := errors.New("bad role")
+	assert.ErrorIs(t, ARNFormat{Value: "Admin", Err: sentinel}.Check(), sentinel)
+}
+
+func TestDurationRange(t *testing.T) {
+	timeout := DurationRange{Name: "Timeout", Min: 15 * time.Minute, Max: 36 * time.Hour}
+	for _, value := range []time.Duration{0, 15 * time.Minute, 12 * time.Hour, 36 * time.Hour} {
+		timeout.Value = value
+		assert.NoError(t, timeout.Check(), value)
+	}
+
+	timeout.Value = time.Minute
+	assert.EqualError(t, timeout.Check(), "the Timeout of 1m must be between 15m and 36h")
+	timeout.Value = 48*time.Hour + 30*time.Minute
+	assert.EqualError(t, timeout.Check(), "the Timeout of 48h30m must be between 15m and 36h")
+}
+
+func TestTokenFormat(t *testing.T) {
+	for _, value := range []string{"", "123456", "000000"} {
+		assert.NoError(t, TokenFormat{Name: "Token", Value: value}.Check(), value)
+	}
+	for _, value := range []string{"12345", "1234567", "12 345", "abcdef"} {
+		err := TokenFormat{Name: "Token", Value: value}.Check()
+		assert.EqualError(t, err, "the Token must be the six digit code shown by the MFA device")
+		assert.NotContains(t, err.Error(), value, "the token is never part of the message")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	missing := errors.New("token must be supplied")
+
+	t.Run("Passes when every rule does", func(t *testing.T) {
+		assert.NoError(t, Check())
+		assert.NoError(t, Check(RequiredString{Name: "Org", Value: "acme"}, TokenFormat{Name: "Token", Value: "123456"}))
+	})
+
+	t.Run("Reports one failure by itself", func(t *testing.T) {
+		assert.EqualError(t, Check(RequiredString{Name: "Org"}, TokenFormat{Name: "Token", Value: "123456"}), "the Org must be set")
+	})
+
+	t.Run("Reports every failure", func(t *testing.T) {
+		err := Check(
+			RequiredString{Name: "Org"},
+			RequiredString{Name: "Token", Err: missing},
+			DurationRange{Name: "Timeout", Value: time.Second, Min: time.Minute, Max: time.Hour},
+		)
+		assert.EqualError(t, err, "3 problems found:\n"+
+			"  - the Org must be set\n"+
+			"  - token must be supplied\n"+
+			"  - the Timeout of 1s must be between 1m and 1h")
+		assert.ErrorIs(t, err, missing)
+
+		var errs Errors
+		assert.ErrorAs(t, err, &errs)
+		assert.Len(t, errs, 3)
+	})
+
+	t.Run("Applies conditional rules and flattens them", func(t *testing.T) {
+		assert.NoError(t, Check(When(false, RequiredString{Name: "Device"})))
+
+		err := Check(RequiredString{Name: "Org"}, When(true, RequiredString{Name: "Device"}, RequiredString{Name: "Token"}))
+		var errs Errors
+		assert.ErrorAs(t, err, &errs)
+		assert.Len(t, errs, 3)
+	})
+
+	t.Run("Runs custom rules", func(t *testing.T) {
+		assert.ErrorIs(t, Check(Func(func() error { return missing })), missing)
+	})
+}